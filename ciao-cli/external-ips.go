@@ -284,6 +284,252 @@ func (cmd *externalIPUnMapCommand) run(args []string) error {
 	return nil
 }
 
+var portForwardCommand = &command{
+	SubCommands: map[string]subCommand{
+		"add":    new(portForwardAddCommand),
+		"list":   new(portForwardListCommand),
+		"delete": new(portForwardDeleteCommand),
+	},
+}
+
+func getCiaoPortForwardsResource() (string, string, error) {
+	url, ver, err := getCiaoExternalIPsResource()
+	if err != nil {
+		return "", "", err
+	}
+
+	return url + "/port-forwards", ver, nil
+}
+
+type portForwardAddCommand struct {
+	Flag         flag.FlagSet
+	instanceID   string
+	poolName     string
+	address      string
+	protocol     string
+	externalPort int
+	internalPort int
+}
+
+func (cmd *portForwardAddCommand) usage(...string) {
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] port-forward add [flags]
+
+Forward a port of an external IP to a port of an instance. Without -address
+a new external IP is allocated from the given pool, otherwise the port is
+added to an external IP already used by the port forwards of the tenant.
+
+The add flags are:
+
+`)
+	cmd.Flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func (cmd *portForwardAddCommand) parseArgs(args []string) []string {
+	cmd.Flag.StringVar(&cmd.instanceID, "instance", "", "ID of the instance to forward the port to.")
+	cmd.Flag.StringVar(&cmd.poolName, "pool", "", "Name of the pool to allocate the external IP from.")
+	cmd.Flag.StringVar(&cmd.address, "address", "", "External IP shared with other port forwards.")
+	cmd.Flag.StringVar(&cmd.protocol, "protocol", "tcp", "Protocol of the forwarded port, tcp or udp.")
+	cmd.Flag.IntVar(&cmd.externalPort, "external-port", 0, "Port of the external IP.")
+	cmd.Flag.IntVar(&cmd.internalPort, "internal-port", 0, "Port of the instance, defaults to the external port.")
+	cmd.Flag.Usage = func() { cmd.usage() }
+	cmd.Flag.Parse(args)
+	return cmd.Flag.Args()
+}
+
+func (cmd *portForwardAddCommand) run(args []string) error {
+	var f types.PortForward
+
+	if cmd.instanceID == "" {
+		errorf("Missing required -instance parameter")
+		cmd.usage()
+	}
+
+	if cmd.externalPort == 0 {
+		errorf("Missing required -external-port parameter")
+		cmd.usage()
+	}
+
+	if cmd.internalPort == 0 {
+		cmd.internalPort = cmd.externalPort
+	}
+
+	req := types.PortForwardRequest{
+		ExternalIP:   cmd.address,
+		ExternalPort: cmd.externalPort,
+		Protocol:     cmd.protocol,
+		InstanceID:   cmd.instanceID,
+		InternalPort: cmd.internalPort,
+	}
+
+	if cmd.poolName != "" {
+		req.PoolName = &cmd.poolName
+	}
+
+	b, err := json.Marshal(req)
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	body := bytes.NewReader(b)
+
+	url, ver, err := getCiaoPortForwardsResource()
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	resp, err := sendCiaoRequest("POST", url, nil, body, &ver)
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	if resp.StatusCode != http.StatusCreated {
+		fatalf("Port forward failed: %s", resp.Status)
+	}
+
+	err = unmarshalHTTPResponse(resp, &f)
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	fmt.Printf("Forwarded %s %s:%d to %s:%d\n", f.Protocol, f.ExternalIP,
+		f.ExternalPort, f.InternalIP, f.InternalPort)
+
+	return nil
+}
+
+type portForwardListCommand struct {
+	Flag     flag.FlagSet
+	template string
+}
+
+func (cmd *portForwardListCommand) usage(...string) {
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] port-forward list [flags]
+
+List all port forwards of external IPs.
+
+The list flags are:
+
+`)
+	cmd.Flag.PrintDefaults()
+	fmt.Fprintf(os.Stderr, "\n%s", templateutils.GenerateUsageDecorated("f", []types.PortForward{}, nil))
+	os.Exit(2)
+}
+
+func (cmd *portForwardListCommand) parseArgs(args []string) []string {
+	cmd.Flag.StringVar(&cmd.template, "f", "", "Template used to format output")
+	cmd.Flag.Usage = func() { cmd.usage() }
+	cmd.Flag.Parse(args)
+	return cmd.Flag.Args()
+}
+
+func (cmd *portForwardListCommand) run(args []string) error {
+	var forwards []types.PortForward
+
+	url, ver, err := getCiaoPortForwardsResource()
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	resp, err := sendCiaoRequest("GET", url, nil, nil, &ver)
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		fatalf("Port forward list failed: %s", resp.Status)
+	}
+
+	err = unmarshalHTTPResponse(resp, &forwards)
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	if cmd.template != "" {
+		return templateutils.OutputToTemplate(os.Stdout, "port-forward-list", cmd.template,
+			&forwards, nil)
+	}
+
+	w := new(tabwriter.Writer)
+	w.Init(os.Stdout, 0, 1, 1, ' ', 0)
+	fmt.Fprintf(w, "#\tID\tProtocol\tExternal\tInternal\tInstanceID")
+	if checkPrivilege() {
+		fmt.Fprintf(w, "\tTenantID\tPoolName\n")
+	} else {
+		fmt.Fprintf(w, "\n")
+	}
+
+	for i, f := range forwards {
+		fmt.Fprintf(w, "%d", i+1)
+		fmt.Fprintf(w, "\t%s", f.ID)
+		fmt.Fprintf(w, "\t%s", f.Protocol)
+		fmt.Fprintf(w, "\t%s", net.JoinHostPort(f.ExternalIP, fmt.Sprint(f.ExternalPort)))
+		fmt.Fprintf(w, "\t%s", net.JoinHostPort(f.InternalIP, fmt.Sprint(f.InternalPort)))
+		fmt.Fprintf(w, "\t%s", f.InstanceID)
+		if checkPrivilege() {
+			fmt.Fprintf(w, "\t%s\t%s", f.TenantID, f.PoolName)
+		}
+
+		fmt.Fprintf(w, "\n")
+	}
+
+	w.Flush()
+
+	return nil
+}
+
+type portForwardDeleteCommand struct {
+	Flag flag.FlagSet
+	ID   string
+}
+
+func (cmd *portForwardDeleteCommand) usage(...string) {
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] port-forward delete [flags]
+
+Delete a port forward. The external IP is released once its last port
+forward is deleted.
+
+The delete flags are:
+
+`)
+	cmd.Flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func (cmd *portForwardDeleteCommand) parseArgs(args []string) []string {
+	cmd.Flag.StringVar(&cmd.ID, "id", "", "ID of the port forward to delete.")
+	cmd.Flag.Usage = func() { cmd.usage() }
+	cmd.Flag.Parse(args)
+	return cmd.Flag.Args()
+}
+
+func (cmd *portForwardDeleteCommand) run(args []string) error {
+	if cmd.ID == "" {
+		errorf("Missing required -id parameter")
+		cmd.usage()
+	}
+
+	url, ver, err := getCiaoPortForwardsResource()
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	resp, err := sendCiaoRequest("DELETE", url+"/"+cmd.ID, nil, nil, &ver)
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		fatalf("Port forward deletion failed: %s", resp.Status)
+	}
+
+	fmt.Printf("Deleted port forward: %s\n", cmd.ID)
+
+	return nil
+}
+
 var poolCommand = &command{
 	SubCommands: map[string]subCommand{
 		"create": new(poolCreateCommand),
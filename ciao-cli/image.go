@@ -17,10 +17,13 @@
 package main
 
 import (
+	"crypto/md5"
+	"encoding/hex"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"net/http"
 	"os"
 	"text/template"
 
@@ -34,10 +37,12 @@ import (
 
 var imageCommand = &command{
 	SubCommands: map[string]subCommand{
-		"add":    new(imageAddCommand),
-		"show":   new(imageShowCommand),
-		"list":   new(imageListCommand),
-		"delete": new(imageDeleteCommand),
+		"add":      new(imageAddCommand),
+		"show":     new(imageShowCommand),
+		"list":     new(imageListCommand),
+		"delete":   new(imageDeleteCommand),
+		"download": new(imageDownloadCommand),
+		"update":   new(imageUpdateCommand),
 	},
 }
 
@@ -46,9 +51,15 @@ type imageAddCommand struct {
 	name       string
 	id         string
 	file       string
+	url        string
+	checksum   string
 	template   string
 	tags       string
 	visibility string
+	signature  string
+	sigCert    string
+	sigHash    string
+	sigKeyType string
 }
 
 const (
@@ -72,10 +83,16 @@ func (cmd *imageAddCommand) parseArgs(args []string) []string {
 	cmd.Flag.StringVar(&cmd.name, "name", "", "Image Name")
 	cmd.Flag.StringVar(&cmd.id, "id", "", "Image UUID")
 	cmd.Flag.StringVar(&cmd.file, "file", "", "Image file to upload")
+	cmd.Flag.StringVar(&cmd.url, "url", "", "HTTP(S) URL the image service should import the image from")
+	cmd.Flag.StringVar(&cmd.checksum, "checksum", "", "Expected MD5 or SHA-256 checksum of the image data")
 	cmd.Flag.StringVar(&cmd.template, "f", "", "Template used to format output")
 	cmd.Flag.StringVar(&cmd.visibility, "visibility", string(images.ImageVisibilityPrivate),
-		"Image visibility (internal,public,private)")
+		"Image visibility (internal,public,private,shared)")
 	cmd.Flag.StringVar(&cmd.tags, "tag", "", "Image tags (comma separated)")
+	cmd.Flag.StringVar(&cmd.signature, "signature", "", "Base64 encoded signature of the image data")
+	cmd.Flag.StringVar(&cmd.sigCert, "signature-cert", "", "UUID of the certificate verifying the signature")
+	cmd.Flag.StringVar(&cmd.sigHash, "signature-hash", "SHA-256", "Hash method used to sign the image data")
+	cmd.Flag.StringVar(&cmd.sigKeyType, "signature-key-type", "RSA-PSS", "Type of the key used to sign the image data")
 	cmd.Flag.Usage = func() { cmd.usage() }
 	cmd.Flag.Parse(args)
 	return cmd.Flag.Args()
@@ -86,13 +103,19 @@ func (cmd *imageAddCommand) run(args []string) error {
 		return errors.New("Missing required -name parameter")
 	}
 
-	if cmd.file == "" {
-		return errors.New("Missing required -file parameter")
+	if (cmd.file == "") == (cmd.url == "") {
+		return errors.New("Exactly one of the -file and -url parameters is required")
 	}
 
-	_, err := os.Stat(cmd.file)
-	if err != nil {
-		fatalf("Could not open %s [%s]\n", cmd.file, err)
+	if (cmd.signature == "") != (cmd.sigCert == "") {
+		return errors.New("The -signature and -signature-cert parameters must be used together")
+	}
+
+	if cmd.file != "" {
+		_, err := os.Stat(cmd.file)
+		if err != nil {
+			fatalf("Could not open %s [%s]\n", cmd.file, err)
+		}
 	}
 
 	client, err := imageServiceClient(*identityUser, *identityPassword, *tenantID)
@@ -104,7 +127,7 @@ func (cmd *imageAddCommand) run(args []string) error {
 	if cmd.visibility != "" {
 		imageVisibility = images.ImageVisibility(cmd.visibility)
 		switch imageVisibility {
-		case images.ImageVisibilityPublic, images.ImageVisibilityPrivate, internalImage:
+		case images.ImageVisibilityPublic, images.ImageVisibilityPrivate, images.ImageVisibilityShared, internalImage:
 		default:
 			fatalf("Invalid image visibility [%v]", imageVisibility)
 		}
@@ -119,12 +142,29 @@ func (cmd *imageAddCommand) run(args []string) error {
 		Tags:       tags,
 	}
 
+	if cmd.signature != "" {
+		opts.Properties = map[string]string{
+			"img_signature":                  cmd.signature,
+			"img_signature_certificate_uuid": cmd.sigCert,
+			"img_signature_hash_method":      cmd.sigHash,
+			"img_signature_key_type":         cmd.sigKeyType,
+		}
+	}
+
 	image, err := images.Create(client, opts).Extract()
 	if err != nil {
 		fatalf("Could not create image [%s]\n", err)
 	}
 
-	uploadTenantImage(*identityUser, *identityPassword, *tenantID, image.ID, cmd.file)
+	if cmd.url != "" {
+		err = importImage(client, image.ID, cmd.url, cmd.checksum)
+		if err != nil {
+			fatalf("Could not import image from %s [%s]\n", cmd.url, err)
+		}
+	} else {
+		uploadTenantImage(*identityUser, *identityPassword, *tenantID, image.ID, cmd.file, cmd.checksum)
+	}
+
 	image, err = images.Get(client, image.ID).Extract()
 	if err != nil {
 		fatalf("Could not retrieve new created image [%s]\n", err)
@@ -262,9 +302,10 @@ func (cmd *imageListCommand) run(args []string) error {
 }
 
 type imageDownloadCommand struct {
-	Flag  flag.FlagSet
-	image string
-	file  string
+	Flag   flag.FlagSet
+	image  string
+	file   string
+	resume bool
 }
 
 func (cmd *imageDownloadCommand) usage(...string) {
@@ -282,39 +323,105 @@ The download flags are:
 func (cmd *imageDownloadCommand) parseArgs(args []string) []string {
 	cmd.Flag.StringVar(&cmd.image, "image", "", "Image UUID")
 	cmd.Flag.StringVar(&cmd.file, "file", "", "Filename to save the image (default will print to stdout)")
+	cmd.Flag.BoolVar(&cmd.resume, "resume", false, "Resume a partial download into an existing file")
 	cmd.Flag.Usage = func() { cmd.usage() }
 	cmd.Flag.Parse(args)
 	return cmd.Flag.Args()
 }
 
 func (cmd *imageDownloadCommand) run(args []string) (err error) {
-	client, err := imageServiceClient(*identityUser, *identityPassword, *tenantID)
-	if err != nil {
-		fatalf("Could not get Image service client [%s]\n", err)
+	if cmd.image == "" {
+		errorf("Missing required -image parameter")
+		cmd.usage()
+	}
+
+	if cmd.resume && cmd.file == "" {
+		errorf("-resume requires the -file parameter")
+		cmd.usage()
 	}
 
-	r, err := images.Download(client, cmd.image).Extract()
+	client, err := imageServiceClient(*identityUser, *identityPassword, *tenantID)
 	if err != nil {
-		fatalf("Could not download image [%s]\n", err)
+		fatalf("Could not get Image service client [%s]\n", err)
 	}
 
 	dest := os.Stdout
+	var offset int64
 	if cmd.file != "" {
-		dest, err = os.Create(cmd.file)
+		flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		if cmd.resume {
+			flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+		}
+
+		dest, err = os.OpenFile(cmd.file, flags, 0644)
+		if err != nil {
+			fatalf("Could not create destination file: %s: %v", cmd.file, err)
+		}
 		defer func() {
 			closeErr := dest.Close()
 			if err == nil {
 				err = closeErr
 			}
 		}()
-		if err != nil {
-			fatalf("Could not create destination file: %s: %v", cmd.file, err)
+
+		if cmd.resume {
+			fi, err := dest.Stat()
+			if err != nil {
+				fatalf("Could not stat destination file: %s: %v", cmd.file, err)
+			}
+			offset = fi.Size()
 		}
 	}
 
-	_, err = io.Copy(dest, r)
+	err = downloadImage(client, cmd.image, offset, dest)
 	if err != nil {
-		fatalf("Error copying to destination: %v", err)
+		fatalf("Could not download image [%s]\n", err)
+	}
+
+	return nil
+}
+
+// downloadImage writes the data of image from offset to dest.  When the
+// whole image is downloaded its data is checked against the MD5 digest
+// sent by the image service.
+func downloadImage(client *gophercloud.ServiceClient, image string, offset int64, dest io.Writer) error {
+	headers := map[string]string{}
+	if offset > 0 {
+		headers["Range"] = fmt.Sprintf("bytes=%d-", offset)
+	}
+
+	resp, err := client.Get(client.ServiceURL("images", image, "file"), nil,
+		&gophercloud.RequestOpts{
+			MoreHeaders: headers,
+			OkCodes:     []int{200, 204, 206, 416},
+		})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusNoContent:
+		return fmt.Errorf("image has no data")
+	case http.StatusRequestedRangeNotSatisfiable:
+		// the partial download is already complete.
+		return nil
+	case http.StatusOK:
+		if offset > 0 {
+			return fmt.Errorf("image service does not support resuming downloads")
+		}
+	}
+
+	h := md5.New()
+	_, err = io.Copy(io.MultiWriter(dest, h), resp.Body)
+	if err != nil {
+		return fmt.Errorf("Error copying to destination: %v", err)
+	}
+
+	checksum := resp.Header.Get("Content-MD5")
+	if resp.StatusCode == http.StatusOK && checksum != "" &&
+		!strings.EqualFold(checksum, hex.EncodeToString(h.Sum(nil))) {
+		return fmt.Errorf("downloaded data does not match checksum %s", checksum)
 	}
 
 	return nil
@@ -358,7 +465,10 @@ func (cmd *imageDeleteCommand) run(args []string) error {
 	return res.Err
 }
 
-func uploadTenantImage(username, password, tenant, image, filename string) error {
+// uploadTenantImage uploads the contents of filename to an image.  If
+// checksum is not empty the image service rejects data that does not
+// match it.
+func uploadTenantImage(username, password, tenant, image, filename, checksum string) error {
 	client, err := imageServiceClient(username, password, tenant)
 	if err != nil {
 		fatalf("Could not get Image service client [%s]\n", err)
@@ -370,47 +480,95 @@ func uploadTenantImage(username, password, tenant, image, filename string) error
 	}
 	defer file.Close()
 
-	res := images.Upload(client, image, file)
-	if res.Err != nil {
-		fatalf("Could not upload %s [%s]", filename, res.Err)
+	headers := map[string]string{"Content-Type": "application/octet-stream"}
+	if checksum != "" {
+		headers["X-Image-Meta-Checksum"] = checksum
 	}
-	return res.Err
+
+	_, err = client.Put(client.ServiceURL("images", image, "file"), file, nil,
+		&gophercloud.RequestOpts{
+			MoreHeaders: headers,
+			OkCodes:     []int{204},
+		})
+	if err != nil {
+		fatalf("Could not upload %s [%s]", filename, err)
+	}
+	return err
 }
 
-type imageModifyCommand struct {
-	Flag  flag.FlagSet
-	name  string
-	image string
+// importImage asks the image service to fetch the image data from url.
+// The import completes in the background.
+func importImage(client *gophercloud.ServiceClient, image, url, checksum string) error {
+	method := map[string]interface{}{
+		"name": "web-download",
+		"uri":  url,
+	}
+	if checksum != "" {
+		method["checksum"] = checksum
+	}
+
+	reqBody := map[string]interface{}{
+		"method": method,
+	}
+
+	_, err := client.Post(client.ServiceURL("images", image, "import"), reqBody, nil,
+		&gophercloud.RequestOpts{OkCodes: []int{202}})
+	return err
+}
+
+type imageUpdateCommand struct {
+	Flag       flag.FlagSet
+	name       string
+	image      string
+	visibility string
+	tags       string
+	clearTags  bool
 }
 
-func (cmd *imageModifyCommand) usage(...string) {
-	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] image modify [flags]
+func (cmd *imageUpdateCommand) usage(...string) {
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] image update [flags]
 
-Modify an image
+Update an image
 
-The modify flags are:
+The update flags are:
 
 `)
 	cmd.Flag.PrintDefaults()
 	os.Exit(2)
 }
 
-func (cmd *imageModifyCommand) parseArgs(args []string) []string {
+func (cmd *imageUpdateCommand) parseArgs(args []string) []string {
 	cmd.Flag.StringVar(&cmd.name, "name", "", "Image Name")
 	cmd.Flag.StringVar(&cmd.image, "image", "", "Image UUID")
+	cmd.Flag.StringVar(&cmd.visibility, "visibility", "",
+		"Image visibility (internal,public,private,shared)")
+	cmd.Flag.StringVar(&cmd.tags, "tags", "", "Image tags (comma separated), replacing the current tags")
+	cmd.Flag.BoolVar(&cmd.clearTags, "clear-tags", false, "Remove all the image tags")
 	cmd.Flag.Usage = func() { cmd.usage() }
 	cmd.Flag.Parse(args)
 	return cmd.Flag.Args()
 }
 
-func (cmd *imageModifyCommand) run(args []string) error {
+// replaceImageVisibility is a patch changing the visibility of an image.
+type replaceImageVisibility struct {
+	visibility images.ImageVisibility
+}
+
+func (r replaceImageVisibility) ToImagePatchMap() map[string]interface{} {
+	return map[string]interface{}{
+		"op":    "replace",
+		"path":  "/visibility",
+		"value": r.visibility,
+	}
+}
+
+func (cmd *imageUpdateCommand) run(args []string) error {
 	if cmd.image == "" {
 		return errors.New("Missing required -image parameter")
 	}
 
-	client, err := imageServiceClient(*identityUser, *identityPassword, *tenantID)
-	if err != nil {
-		fatalf("Could not get Image service client [%s]\n", err)
+	if cmd.tags != "" && cmd.clearTags {
+		return errors.New("Only one of the -tags and -clear-tags parameters can be used")
 	}
 
 	var opts images.UpdateOpts
@@ -421,6 +579,35 @@ func (cmd *imageModifyCommand) run(args []string) error {
 		opts = append(opts, n)
 	}
 
+	if cmd.visibility != "" {
+		v := images.ImageVisibility(cmd.visibility)
+		switch v {
+		case images.ImageVisibilityPublic, images.ImageVisibilityPrivate, images.ImageVisibilityShared, internalImage:
+		default:
+			fatalf("Invalid image visibility [%v]", v)
+		}
+		opts = append(opts, replaceImageVisibility{v})
+	}
+
+	if cmd.tags != "" || cmd.clearTags {
+		t := images.ReplaceImageTags{
+			NewTags: []string{},
+		}
+		if cmd.tags != "" {
+			t.NewTags = strings.Split(cmd.tags, ",")
+		}
+		opts = append(opts, t)
+	}
+
+	if len(opts) == 0 {
+		return errors.New("Nothing to update")
+	}
+
+	client, err := imageServiceClient(*identityUser, *identityPassword, *tenantID)
+	if err != nil {
+		fatalf("Could not get Image service client [%s]\n", err)
+	}
+
 	image, err := images.Update(client, cmd.image, opts).Extract()
 	if err != nil {
 		fatalf("Could not update image's properties [%s]\n", err)
@@ -436,6 +623,7 @@ func dumpImage(i *images.Image) {
 	fmt.Printf("\tSize             [%d bytes]\n", i.SizeBytes)
 	fmt.Printf("\tUUID             [%s]\n", i.ID)
 	fmt.Printf("\tStatus           [%s]\n", i.Status)
+	fmt.Printf("\tChecksum         [%s]\n", i.Checksum)
 	fmt.Printf("\tVisibility       [%s]\n", i.Visibility)
 	fmt.Printf("\tTags             %v\n", i.Tags)
 	fmt.Printf("\tCreatedDate      [%s]\n", i.CreatedDate)
@@ -383,6 +383,9 @@ type instanceAddCommand struct {
 	label     string
 	volumes   volumeFlagSlice
 	template  string
+	network   string
+	ip        string
+	mac       string
 }
 
 func (cmd *instanceAddCommand) usage(...string) {
@@ -405,6 +408,9 @@ func (cmd *instanceAddCommand) parseArgs(args []string) []string {
 	cmd.Flag.StringVar(&cmd.label, "label", "", "Set a frame label. This will trigger frame tracing")
 	cmd.Flag.Var(&cmd.volumes, "volume", "volume descriptor argument list")
 	cmd.Flag.StringVar(&cmd.template, "f", "", "Template used to format output")
+	cmd.Flag.StringVar(&cmd.network, "network", "", "Comma separated UUIDs of the tenant networks to attach the instance to, the first one being its primary network")
+	cmd.Flag.StringVar(&cmd.ip, "ip", "", "Fixed IP address of the primary network interface")
+	cmd.Flag.StringVar(&cmd.mac, "mac", "", "Fixed MAC address of the primary network interface")
 	cmd.Flag.Usage = func() { cmd.usage() }
 	cmd.Flag.Parse(args)
 	return cmd.Flag.Args()
@@ -421,6 +427,11 @@ func (cmd *instanceAddCommand) validateAddCommandArgs() {
 		cmd.usage()
 	}
 
+	if cmd.instances != 1 && (cmd.ip != "" || cmd.mac != "") {
+		errorf("Cannot give a fixed address to multiple instances (\"-instances=%d\")", cmd.instances)
+		cmd.usage()
+	}
+
 	for _, volume := range cmd.volumes {
 		//NOTE: volume.uuid itself may only be validated by controller as
 		//only it knows which storage interface is in use and what
@@ -453,6 +464,21 @@ func populateCreateServerRequest(cmd *instanceAddCommand, server *compute.Create
 	server.Server.MaxInstances = cmd.instances
 	server.Server.MinInstances = 1
 
+	if cmd.network != "" {
+		for _, network := range strings.Split(cmd.network, ",") {
+			server.Server.Networks = append(server.Server.Networks,
+				compute.ServerNetwork{UUID: network})
+		}
+	}
+
+	if cmd.ip != "" || cmd.mac != "" {
+		if len(server.Server.Networks) == 0 {
+			server.Server.Networks = []compute.ServerNetwork{{}}
+		}
+		server.Server.Networks[0].FixedIP = cmd.ip
+		server.Server.Networks[0].MACAddress = cmd.mac
+	}
+
 	for _, volume := range cmd.volumes {
 		bd := compute.BlockDeviceMappingV2{
 			DeviceName:          "", //unsupported
@@ -84,17 +84,18 @@ type subCommand interface {
 }
 
 var commands = map[string]subCommand{
-	"instance":    instanceCommand,
-	"workload":    workloadCommand,
-	"tenant":      tenantCommand,
-	"event":       eventCommand,
-	"node":        nodeCommand,
-	"trace":       traceCommand,
-	"image":       imageCommand,
-	"volume":      volumeCommand,
-	"pool":        poolCommand,
-	"external-ip": externalIPCommand,
-	"quotas":      quotasCommand,
+	"instance":     instanceCommand,
+	"workload":     workloadCommand,
+	"tenant":       tenantCommand,
+	"event":        eventCommand,
+	"node":         nodeCommand,
+	"trace":        traceCommand,
+	"image":        imageCommand,
+	"volume":       volumeCommand,
+	"pool":         poolCommand,
+	"external-ip":  externalIPCommand,
+	"port-forward": portForwardCommand,
+	"quotas":       quotasCommand,
 }
 
 var scopedToken string
@@ -128,6 +128,7 @@ func listComputeNodes(t *template.Template) error {
 		fmt.Printf("\t\tRunning Instances: %d\n", node.TotalRunningInstances)
 		fmt.Printf("\t\tPending Instances: %d\n", node.TotalPendingInstances)
 		fmt.Printf("\t\tPaused Instances: %d\n", node.TotalPausedInstances)
+		fmt.Printf("\tCached Images: %d (%d MB)\n", node.CachedImages, node.ImageCacheSize)
 	}
 	return nil
 }
@@ -21,10 +21,12 @@ import (
 	"fmt"
 	"os"
 	"sort"
+	"strconv"
 	"text/template"
 	"time"
 
 	"github.com/01org/ciao/templateutils"
+	"github.com/mitchellh/mapstructure"
 	"github.com/rackspace/gophercloud"
 	"github.com/rackspace/gophercloud/openstack"
 	"github.com/rackspace/gophercloud/openstack/blockstorage/v2/extensions/volumeactions"
@@ -34,16 +36,62 @@ import (
 
 var volumeCommand = &command{
 	SubCommands: map[string]subCommand{
-		"add":    new(volumeAddCommand),
-		"list":   new(volumeListCommand),
-		"show":   new(volumeShowCommand),
-		"update": new(volumeUpdateCommand),
-		"delete": new(volumeDeleteCommand),
-		"attach": new(volumeAttachCommand),
-		"detach": new(volumeDetachCommand),
+		"add":     new(volumeAddCommand),
+		"list":    new(volumeListCommand),
+		"show":    new(volumeShowCommand),
+		"update":  new(volumeUpdateCommand),
+		"delete":  new(volumeDeleteCommand),
+		"attach":  new(volumeAttachCommand),
+		"detach":  new(volumeDetachCommand),
+		"backup":  new(volumeBackupCommand),
+		"restore": new(volumeRestoreCommand),
 	},
 }
 
+// volumeLimitFlags holds the I/O throttling flags shared by the
+// add and update commands. A negative value means the flag was not set.
+type volumeLimitFlags struct {
+	totalBytesSec int64
+	readBytesSec  int64
+	writeBytesSec int64
+	totalIOPSSec  int64
+	readIOPSSec   int64
+	writeIOPSSec  int64
+}
+
+func (l *volumeLimitFlags) addFlags(fs *flag.FlagSet) {
+	fs.Int64Var(&l.totalBytesSec, "total_bytes_sec", -1, "Total bytes per second limit (0 for no limit)")
+	fs.Int64Var(&l.readBytesSec, "read_bytes_sec", -1, "Read bytes per second limit (0 for no limit)")
+	fs.Int64Var(&l.writeBytesSec, "write_bytes_sec", -1, "Write bytes per second limit (0 for no limit)")
+	fs.Int64Var(&l.totalIOPSSec, "total_iops_sec", -1, "Total I/O operations per second limit (0 for no limit)")
+	fs.Int64Var(&l.readIOPSSec, "read_iops_sec", -1, "Read I/O operations per second limit (0 for no limit)")
+	fs.Int64Var(&l.writeIOPSSec, "write_iops_sec", -1, "Write I/O operations per second limit (0 for no limit)")
+}
+
+func (l *volumeLimitFlags) metadata() map[string]string {
+	meta := make(map[string]string)
+	limits := map[string]int64{
+		"total_bytes_sec": l.totalBytesSec,
+		"read_bytes_sec":  l.readBytesSec,
+		"write_bytes_sec": l.writeBytesSec,
+		"total_iops_sec":  l.totalIOPSSec,
+		"read_iops_sec":   l.readIOPSSec,
+		"write_iops_sec":  l.writeIOPSSec,
+	}
+
+	for key, val := range limits {
+		if val >= 0 {
+			meta[key] = strconv.FormatInt(val, 10)
+		}
+	}
+
+	if len(meta) == 0 {
+		return nil
+	}
+
+	return meta
+}
+
 type volumeAddCommand struct {
 	Flag        flag.FlagSet
 	size        int
@@ -51,6 +99,29 @@ type volumeAddCommand struct {
 	name        string
 	sourceType  string
 	source      string
+	volumeType  string
+	multiAttach bool
+	limits      volumeLimitFlags
+}
+
+// volumeCreateOpts extends the gophercloud volume creation options with the
+// multiattach attribute.
+type volumeCreateOpts struct {
+	volumes.CreateOpts
+	multiAttach bool
+}
+
+func (opts volumeCreateOpts) ToVolumeCreateMap() (map[string]interface{}, error) {
+	m, err := opts.CreateOpts.ToVolumeCreateMap()
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.multiAttach {
+		m["volume"].(map[string]interface{})["multiattach"] = true
+	}
+
+	return m, nil
 }
 
 func (cmd *volumeAddCommand) usage(...string) {
@@ -71,6 +142,9 @@ func (cmd *volumeAddCommand) parseArgs(args []string) []string {
 	cmd.Flag.StringVar(&cmd.source, "source", "", "ID of image or volume to clone from")
 	cmd.Flag.IntVar(&cmd.size, "size", 1, "Size of the volume in GB")
 	cmd.Flag.StringVar(&cmd.description, "description", "", "Volume description")
+	cmd.Flag.StringVar(&cmd.volumeType, "volume_type", "", "Volume type defining default I/O limits and encryption")
+	cmd.Flag.BoolVar(&cmd.multiAttach, "multiattach", false, "Allow the volume to be attached read-only to several instances")
+	cmd.limits.addFlags(&cmd.Flag)
 	cmd.Flag.Usage = func() { cmd.usage() }
 	cmd.Flag.Parse(args)
 	return cmd.Flag.Args()
@@ -82,10 +156,15 @@ func (cmd *volumeAddCommand) run(args []string) error {
 		fatalf("Could not get volume service client [%s]\n", err)
 	}
 
-	opts := volumes.CreateOpts{
-		Description: cmd.description,
-		Name:        cmd.name,
-		Size:        cmd.size,
+	opts := volumeCreateOpts{
+		CreateOpts: volumes.CreateOpts{
+			Description: cmd.description,
+			Name:        cmd.name,
+			Size:        cmd.size,
+			VolumeType:  cmd.volumeType,
+			Metadata:    cmd.limits.metadata(),
+		},
+		multiAttach: cmd.multiAttach,
 	}
 
 	if cmd.sourceType == "image" {
@@ -244,6 +323,7 @@ type volumeUpdateCommand struct {
 	volume      string
 	name        string
 	description string
+	limits      volumeLimitFlags
 }
 
 func (cmd *volumeUpdateCommand) usage(...string) {
@@ -261,6 +341,7 @@ func (cmd *volumeUpdateCommand) parseArgs(args []string) []string {
 	cmd.Flag.StringVar(&cmd.volume, "volume", "", "Volume UUID")
 	cmd.Flag.StringVar(&cmd.name, "name", "", "Volume name")
 	cmd.Flag.StringVar(&cmd.description, "description", "", "Volume description")
+	cmd.limits.addFlags(&cmd.Flag)
 	cmd.Flag.Usage = func() { cmd.usage() }
 	cmd.Flag.Parse(args)
 	return cmd.Flag.Args()
@@ -280,6 +361,7 @@ func (cmd *volumeUpdateCommand) run(args []string) error {
 	opts := volumes.UpdateOpts{
 		Name:        cmd.name,
 		Description: cmd.description,
+		Metadata:    cmd.limits.metadata(),
 	}
 
 	vol, err := volumes.Update(client, cmd.volume, opts).Extract()
@@ -391,8 +473,9 @@ func (cmd *volumeAttachCommand) run(args []string) error {
 }
 
 type volumeDetachCommand struct {
-	Flag   flag.FlagSet
-	volume string
+	Flag       flag.FlagSet
+	volume     string
+	attachment string
 }
 
 func (cmd *volumeDetachCommand) usage(...string) {
@@ -408,6 +491,7 @@ The detach flags are:
 
 func (cmd *volumeDetachCommand) parseArgs(args []string) []string {
 	cmd.Flag.StringVar(&cmd.volume, "volume", "", "Volume UUID")
+	cmd.Flag.StringVar(&cmd.attachment, "attachment", "", "Attachment UUID, to detach a shared volume from a single instance")
 	cmd.Flag.Usage = func() { cmd.usage() }
 	cmd.Flag.Parse(args)
 	return cmd.Flag.Args()
@@ -426,13 +510,160 @@ func (cmd *volumeDetachCommand) run(args []string) error {
 		fatalf("Could not get volume service client [%s]\n", err)
 	}
 
-	err = volumeactions.Detach(client, cmd.volume).ExtractErr()
+	if cmd.attachment == "" {
+		err = volumeactions.Detach(client, cmd.volume).ExtractErr()
+	} else {
+		reqBody := map[string]interface{}{
+			"os-detach": map[string]interface{}{
+				"attachment-id": cmd.attachment,
+			},
+		}
+		_, err = client.Post(client.ServiceURL("volumes", cmd.volume, "action"), reqBody, nil,
+			&gophercloud.RequestOpts{OkCodes: []int{202}})
+	}
 	if err == nil {
 		fmt.Printf("Detached volume: %s\n", cmd.volume)
 	}
 	return err
 }
 
+type volumeBackupCommand struct {
+	Flag        flag.FlagSet
+	volume      string
+	name        string
+	description string
+	force       bool
+}
+
+func (cmd *volumeBackupCommand) usage(...string) {
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] volume backup [flags]
+
+Backs up a volume to the backup store
+
+The backup flags are:
+`)
+	cmd.Flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func (cmd *volumeBackupCommand) parseArgs(args []string) []string {
+	cmd.Flag.StringVar(&cmd.volume, "volume", "", "Volume UUID")
+	cmd.Flag.StringVar(&cmd.name, "name", "", "Backup name")
+	cmd.Flag.StringVar(&cmd.description, "description", "", "Backup description")
+	cmd.Flag.BoolVar(&cmd.force, "force", false, "Back up the volume even if it is attached")
+	cmd.Flag.Usage = func() { cmd.usage() }
+	cmd.Flag.Parse(args)
+	return cmd.Flag.Args()
+}
+
+func (cmd *volumeBackupCommand) run(args []string) error {
+	if cmd.volume == "" {
+		errorf("missing required -volume parameter")
+		cmd.usage()
+	}
+
+	client, err := storageServiceClient(*identityUser, *identityPassword, *tenantID)
+	if err != nil {
+		fatalf("Could not get volume service client [%s]\n", err)
+	}
+
+	reqBody := map[string]interface{}{
+		"backup": map[string]interface{}{
+			"volume_id":   cmd.volume,
+			"name":        cmd.name,
+			"description": cmd.description,
+			"force":       cmd.force,
+		},
+	}
+
+	var body interface{}
+	_, err = client.Post(client.ServiceURL("backups"), reqBody, &body,
+		&gophercloud.RequestOpts{OkCodes: []int{202}})
+	if err != nil {
+		return err
+	}
+
+	var response struct {
+		Backup struct {
+			ID string `mapstructure:"id"`
+		} `mapstructure:"backup"`
+	}
+
+	err = mapstructure.Decode(body, &response)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Created backup: %s\n", response.Backup.ID)
+	return nil
+}
+
+type volumeRestoreCommand struct {
+	Flag   flag.FlagSet
+	backup string
+	name   string
+}
+
+func (cmd *volumeRestoreCommand) usage(...string) {
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] volume restore [flags]
+
+Restores a volume backup to a new volume
+
+The restore flags are:
+`)
+	cmd.Flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func (cmd *volumeRestoreCommand) parseArgs(args []string) []string {
+	cmd.Flag.StringVar(&cmd.backup, "backup", "", "Backup UUID")
+	cmd.Flag.StringVar(&cmd.name, "name", "", "Name of the new volume")
+	cmd.Flag.Usage = func() { cmd.usage() }
+	cmd.Flag.Parse(args)
+	return cmd.Flag.Args()
+}
+
+func (cmd *volumeRestoreCommand) run(args []string) error {
+	if cmd.backup == "" {
+		errorf("missing required -backup parameter")
+		cmd.usage()
+	}
+
+	client, err := storageServiceClient(*identityUser, *identityPassword, *tenantID)
+	if err != nil {
+		fatalf("Could not get volume service client [%s]\n", err)
+	}
+
+	restore := map[string]interface{}{}
+	if cmd.name != "" {
+		restore["name"] = cmd.name
+	}
+	reqBody := map[string]interface{}{
+		"restore": restore,
+	}
+
+	var body interface{}
+	_, err = client.Post(client.ServiceURL("backups", cmd.backup, "restore"), reqBody, &body,
+		&gophercloud.RequestOpts{OkCodes: []int{202}})
+	if err != nil {
+		return err
+	}
+
+	var response struct {
+		Restore struct {
+			VolumeID string `mapstructure:"volume_id"`
+		} `mapstructure:"restore"`
+	}
+
+	err = mapstructure.Decode(body, &response)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Restoring backup %s to volume: %s\n", cmd.backup, response.Restore.VolumeID)
+	return nil
+}
+
 func storageServiceClient(username, password, tenant string) (*gophercloud.ServiceClient, error) {
 	opt := gophercloud.AuthOptions{
 		IdentityEndpoint: *identityURL + "/v3/",
@@ -461,4 +692,8 @@ func dumpVolume(v *volumes.Volume) {
 	fmt.Printf("\tUUID             [%s]\n", v.ID)
 	fmt.Printf("\tStatus           [%s]\n", v.Status)
 	fmt.Printf("\tDescription      [%s]\n", v.Description)
+	fmt.Printf("\tMultiattach      [%t]\n", v.Multiattach)
+	for _, a := range v.Attachments {
+		fmt.Printf("\tAttachment       [%v] Instance [%v]\n", a["attachment_id"], a["server_id"])
+	}
 }
@@ -25,6 +25,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"os"
+	"strings"
 
 	"github.com/01org/ciao/ciao-controller/api"
 	"github.com/01org/ciao/ciao-controller/types"
@@ -37,10 +38,11 @@ import (
 
 var workloadCommand = &command{
 	SubCommands: map[string]subCommand{
-		"list":   new(workloadListCommand),
-		"create": new(workloadCreateCommand),
-		"delete": new(workloadDeleteCommand),
-		"show":   new(workloadShowCommand),
+		"list":    new(workloadListCommand),
+		"create":  new(workloadCreateCommand),
+		"delete":  new(workloadDeleteCommand),
+		"show":    new(workloadShowCommand),
+		"prewarm": new(workloadPrewarmCommand),
 	},
 }
 
@@ -482,3 +484,79 @@ func (cmd *workloadShowCommand) run(args []string) error {
 	outputWorkload(wl)
 	return nil
 }
+
+type workloadPrewarmCommand struct {
+	Flag     flag.FlagSet
+	workload string
+	nodes    string
+}
+
+func (cmd *workloadPrewarmCommand) usage(...string) {
+	fmt.Fprintf(os.Stderr, `usage: ciao-cli [options] workload prewarm [flags]
+
+Fetches the image of a container workload onto compute nodes
+
+The prewarm flags are:
+
+`)
+	cmd.Flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func (cmd *workloadPrewarmCommand) parseArgs(args []string) []string {
+	cmd.Flag.StringVar(&cmd.workload, "workload", "", "Workload UUID")
+	cmd.Flag.StringVar(&cmd.nodes, "nodes", "", "Comma separated list of node UUIDs, all nodes if empty")
+	cmd.Flag.Usage = func() { cmd.usage() }
+	cmd.Flag.Parse(args)
+	return cmd.Flag.Args()
+}
+
+func (cmd *workloadPrewarmCommand) run(args []string) error {
+	if cmd.workload == "" {
+		cmd.usage()
+	}
+
+	if !checkPrivilege() {
+		fatalf("Prewarming images is restricted to admin users")
+	}
+
+	var req types.PrewarmRequest
+	if cmd.nodes != "" {
+		req.Nodes = strings.Split(cmd.nodes, ",")
+	}
+
+	b, err := json.Marshal(req)
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	url, err := getCiaoWorkloadsResource()
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	ver := api.WorkloadsV1
+	url = fmt.Sprintf("%s/%s/prewarm", url, cmd.workload)
+
+	resp, err := sendCiaoRequest("POST", url, nil, bytes.NewReader(b), &ver)
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	if resp.StatusCode != http.StatusAccepted {
+		fatalf("Workload prewarm failed: %s", resp.Status)
+	}
+
+	var prewarm types.PrewarmResponse
+	err = unmarshalHTTPResponse(resp, &prewarm)
+	if err != nil {
+		fatalf(err.Error())
+	}
+
+	fmt.Printf("Prewarming %s on %d nodes\n", prewarm.Image, len(prewarm.Nodes))
+	for _, n := range prewarm.Nodes {
+		fmt.Printf("\t%s\n", n)
+	}
+
+	return nil
+}
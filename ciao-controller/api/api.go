@@ -42,6 +42,16 @@ const (
 
 	// TenantsV1 is the content-type string for v1 of our tenants resource
 	TenantsV1 = "x.ciao.tenants.v1"
+
+	// VolumesV1 is the content-type string for v1 of our volumes resource
+	VolumesV1 = "x.ciao.volumes.v1"
+
+	// SecurityGroupsV1 is the content-type string for v1 of our
+	// security-groups resource
+	SecurityGroupsV1 = "x.ciao.security-groups.v1"
+
+	// NetworksV1 is the content-type string for v1 of our networks resource
+	NetworksV1 = "x.ciao.networks.v1"
 )
 
 // HTTPErrorData represents the HTTP response body for
@@ -72,7 +82,13 @@ func errorResponse(err error) Response {
 		types.ErrTenantNotFound,
 		types.ErrAddressNotFound,
 		types.ErrInstanceNotFound,
-		types.ErrWorkloadNotFound:
+		types.ErrWorkloadNotFound,
+		types.ErrNodeNotFound,
+		types.ErrSecurityGroupNotFound,
+		types.ErrSecurityRuleNotFound,
+		types.ErrNetworkNotFound,
+		types.ErrSubnetNotFound,
+		types.ErrPortForwardNotFound:
 		return Response{http.StatusNotFound, nil}
 
 	case types.ErrQuota,
@@ -85,7 +101,17 @@ func errorResponse(err error) Response {
 		types.ErrBadRequest,
 		types.ErrPoolEmpty,
 		types.ErrDuplicatePoolName,
-		types.ErrWorkloadInUse:
+		types.ErrPoolSubnetTooLarge,
+		types.ErrWorkloadInUse,
+		types.ErrSecurityGroupInUse,
+		types.ErrSecurityGroupNICs,
+		types.ErrInvalidSecurityRule,
+		types.ErrNetworkInUse,
+		types.ErrInvalidSubnet,
+		types.ErrSubnetOverlap,
+		types.ErrInvalidDHCPOptions,
+		types.ErrInvalidPortForward,
+		types.ErrPortForwarded:
 		return Response{http.StatusForbidden, nil}
 
 	default:
@@ -211,6 +237,36 @@ func listResources(c *Context, w http.ResponseWriter, r *http.Request) (Response
 
 	links = append(links, link)
 
+	// we support the "security-groups" resource
+	link = types.APILink{
+		Rel:        "security-groups",
+		Version:    SecurityGroupsV1,
+		MinVersion: SecurityGroupsV1,
+	}
+
+	if !ok {
+		link.Href = fmt.Sprintf("%s/security-groups", c.URL)
+	} else {
+		link.Href = fmt.Sprintf("%s/%s/security-groups", c.URL, tenantID)
+	}
+
+	links = append(links, link)
+
+	// we support the "networks" resource
+	link = types.APILink{
+		Rel:        "networks",
+		Version:    NetworksV1,
+		MinVersion: NetworksV1,
+	}
+
+	if !ok {
+		link.Href = fmt.Sprintf("%s/networks", c.URL)
+	} else {
+		link.Href = fmt.Sprintf("%s/%s/networks", c.URL, tenantID)
+	}
+
+	links = append(links, link)
+
 	return Response{http.StatusOK, links}, nil
 }
 
@@ -459,6 +515,59 @@ func unmapExternalIP(c *Context, w http.ResponseWriter, r *http.Request) (Respon
 	return errorResponse(types.ErrAddressNotFound), types.ErrAddressNotFound
 }
 
+func listPortForwards(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	tenantID, ok := vars["tenant"]
+
+	if !ok {
+		return Response{http.StatusOK, c.ListPortForwards(nil)}, nil
+	}
+
+	return Response{http.StatusOK, c.ListPortForwards(&tenantID)}, nil
+}
+
+func addPortForward(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	var tenant *string
+	if tenantID, ok := vars["tenant"]; ok {
+		tenant = &tenantID
+	}
+
+	var req types.PortForwardRequest
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	f, err := c.AddPortForward(tenant, req)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusCreated, f}, nil
+}
+
+func deletePortForward(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	var tenant *string
+	if tenantID, ok := vars["tenant"]; ok {
+		tenant = &tenantID
+	}
+
+	err := c.DeletePortForward(tenant, vars["forward_id"])
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusNoContent, nil}, nil
+}
+
 func addWorkload(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
 	var req types.Workload
 
@@ -545,6 +654,37 @@ func showWorkload(c *Context, w http.ResponseWriter, r *http.Request) (Response,
 	return Response{http.StatusOK, wl}, nil
 }
 
+func prewarmWorkload(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	ID := vars["workload_id"]
+
+	// if we have no tenant variable, then we are admin
+	tenant, ok := vars["tenant"]
+	if !ok {
+		tenant = "public"
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	var req types.PrewarmRequest
+	if len(body) > 0 {
+		err = json.Unmarshal(body, &req)
+		if err != nil {
+			return errorResponse(err), err
+		}
+	}
+
+	resp, err := c.PrewarmWorkload(tenant, ID, req.Nodes)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusAccepted, resp}, nil
+}
+
 func listQuotas(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
 	vars := mux.Vars(r)
 	tenantID, ok := vars["tenant"]
@@ -585,6 +725,275 @@ func updateQuotas(c *Context, w http.ResponseWriter, r *http.Request) (Response,
 	return Response{http.StatusCreated, resp}, nil
 }
 
+func showVolumeReconciliation(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	return Response{http.StatusOK, c.ShowVolumeReconciliation()}, nil
+}
+
+func listSecurityGroups(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	tenantID, ok := vars["tenant"]
+
+	if !ok {
+		return Response{http.StatusOK, c.ListSecurityGroups(nil)}, nil
+	}
+
+	return Response{http.StatusOK, c.ListSecurityGroups(&tenantID)}, nil
+}
+
+func addSecurityGroup(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	tenantID := vars["tenant"]
+
+	var req types.NewSecurityGroupRequest
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	sg, err := c.CreateSecurityGroup(tenantID, req)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusCreated, sg}, nil
+}
+
+func showSecurityGroup(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	tenantID := vars["tenant"]
+	groupID := vars["group_id"]
+
+	sg, err := c.ShowSecurityGroup(tenantID, groupID)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusOK, sg}, nil
+}
+
+func deleteSecurityGroup(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	tenantID := vars["tenant"]
+	groupID := vars["group_id"]
+
+	err := c.DeleteSecurityGroup(tenantID, groupID)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusNoContent, nil}, nil
+}
+
+func addSecurityGroupRule(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	tenantID := vars["tenant"]
+	groupID := vars["group_id"]
+
+	var req types.SecurityGroupRule
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	rule, err := c.AddSecurityGroupRule(tenantID, groupID, req)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusCreated, rule}, nil
+}
+
+func deleteSecurityGroupRule(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	tenantID := vars["tenant"]
+	groupID := vars["group_id"]
+	ruleID := vars["rule_id"]
+
+	err := c.DeleteSecurityGroupRule(tenantID, groupID, ruleID)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusNoContent, nil}, nil
+}
+
+func addSecurityGroupInstance(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	tenantID := vars["tenant"]
+	groupID := vars["group_id"]
+
+	var req types.SecurityGroupInstanceRequest
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	err = c.AddSecurityGroupInstance(tenantID, groupID, req.InstanceID)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusNoContent, nil}, nil
+}
+
+func removeSecurityGroupInstance(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	tenantID := vars["tenant"]
+	groupID := vars["group_id"]
+	instanceID := vars["instance_id"]
+
+	err := c.RemoveSecurityGroupInstance(tenantID, groupID, instanceID)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusNoContent, nil}, nil
+}
+
+func listNetworks(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	tenantID, ok := vars["tenant"]
+
+	if !ok {
+		return Response{http.StatusOK, c.ListNetworks(nil)}, nil
+	}
+
+	return Response{http.StatusOK, c.ListNetworks(&tenantID)}, nil
+}
+
+func addNetwork(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	tenantID := vars["tenant"]
+
+	var req types.NewNetworkRequest
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	n, err := c.CreateNetwork(tenantID, req)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusCreated, n}, nil
+}
+
+func showNetwork(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	tenantID := vars["tenant"]
+	networkID := vars["network_id"]
+
+	n, err := c.ShowNetwork(tenantID, networkID)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusOK, n}, nil
+}
+
+func deleteNetwork(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	tenantID := vars["tenant"]
+	networkID := vars["network_id"]
+
+	err := c.DeleteNetwork(tenantID, networkID)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusNoContent, nil}, nil
+}
+
+func addNetworkSubnet(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	tenantID := vars["tenant"]
+	networkID := vars["network_id"]
+
+	var req types.NewSubnetRequest
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	sn, err := c.AddNetworkSubnet(tenantID, networkID, req)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusCreated, sn}, nil
+}
+
+func updateNetworkSubnet(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	tenantID := vars["tenant"]
+	networkID := vars["network_id"]
+	subnetID := vars["subnet_id"]
+
+	var req types.SubnetDHCPOptions
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	sn, err := c.UpdateNetworkSubnet(tenantID, networkID, subnetID, req)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusOK, sn}, nil
+}
+
+func deleteNetworkSubnet(c *Context, w http.ResponseWriter, r *http.Request) (Response, error) {
+	vars := mux.Vars(r)
+	tenantID := vars["tenant"]
+	networkID := vars["network_id"]
+	subnetID := vars["subnet_id"]
+
+	err := c.DeleteNetworkSubnet(tenantID, networkID, subnetID)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return Response{http.StatusNoContent, nil}, nil
+}
+
 // Service is an interface which must be implemented by the ciao API context.
 type Service interface {
 	AddPool(name string, subnet *string, ips []string) (types.Pool, error)
@@ -596,11 +1005,31 @@ type Service interface {
 	ListMappedAddresses(tenantID *string) []types.MappedIP
 	MapAddress(poolName *string, instanceID string) error
 	UnMapAddress(ID string) error
+	ListPortForwards(tenantID *string) []types.PortForward
+	AddPortForward(tenantID *string, req types.PortForwardRequest) (types.PortForward, error)
+	DeletePortForward(tenantID *string, forwardID string) error
 	CreateWorkload(req types.Workload) (types.Workload, error)
 	DeleteWorkload(tenantID string, workloadID string) error
 	ShowWorkload(tenantID string, workloadID string) (types.Workload, error)
+	PrewarmWorkload(tenantID string, workloadID string, nodes []string) (types.PrewarmResponse, error)
 	ListQuotas(tenantID string) []types.QuotaDetails
 	UpdateQuotas(tenantID string, qds []types.QuotaDetails) error
+	ShowVolumeReconciliation() types.VolumeReconciliation
+	ListSecurityGroups(tenantID *string) []types.SecurityGroup
+	CreateSecurityGroup(tenantID string, req types.NewSecurityGroupRequest) (types.SecurityGroup, error)
+	ShowSecurityGroup(tenantID string, groupID string) (types.SecurityGroup, error)
+	DeleteSecurityGroup(tenantID string, groupID string) error
+	AddSecurityGroupRule(tenantID string, groupID string, rule types.SecurityGroupRule) (types.SecurityGroupRule, error)
+	DeleteSecurityGroupRule(tenantID string, groupID string, ruleID string) error
+	AddSecurityGroupInstance(tenantID string, groupID string, instanceID string) error
+	RemoveSecurityGroupInstance(tenantID string, groupID string, instanceID string) error
+	ListNetworks(tenantID *string) []types.TenantNetwork
+	CreateNetwork(tenantID string, req types.NewNetworkRequest) (types.TenantNetwork, error)
+	ShowNetwork(tenantID string, networkID string) (types.TenantNetwork, error)
+	DeleteNetwork(tenantID string, networkID string) error
+	AddNetworkSubnet(tenantID string, networkID string, req types.NewSubnetRequest) (types.TenantSubnet, error)
+	UpdateNetworkSubnet(tenantID string, networkID string, subnetID string, req types.SubnetDHCPOptions) (types.TenantSubnet, error)
+	DeleteNetworkSubnet(tenantID string, networkID string, subnetID string) error
 }
 
 // Context is used to provide the services and current URL to the handlers.
@@ -694,6 +1123,31 @@ func Routes(config Config) *mux.Router {
 	route.Methods("DELETE")
 	route.HeadersRegexp("Content-Type", matchContent)
 
+	// port forwards of external IPs
+	route = r.Handle("/external-ips/port-forwards", Handler{context, listPortForwards, true})
+	route.Methods("GET")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/{tenant:"+uuid.UUIDRegex+"}/external-ips/port-forwards", Handler{context, listPortForwards, false})
+	route.Methods("GET")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/external-ips/port-forwards", Handler{context, addPortForward, true})
+	route.Methods("POST")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/{tenant:"+uuid.UUIDRegex+"}/external-ips/port-forwards", Handler{context, addPortForward, false})
+	route.Methods("POST")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/external-ips/port-forwards/{forward_id:"+uuid.UUIDRegex+"}", Handler{context, deletePortForward, true})
+	route.Methods("DELETE")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/{tenant:"+uuid.UUIDRegex+"}/external-ips/port-forwards/{forward_id:"+uuid.UUIDRegex+"}", Handler{context, deletePortForward, false})
+	route.Methods("DELETE")
+	route.HeadersRegexp("Content-Type", matchContent)
+
 	// workloads
 	matchContent = fmt.Sprintf("application/(%s|json)", WorkloadsV1)
 
@@ -709,6 +1163,10 @@ func Routes(config Config) *mux.Router {
 	route.Methods("GET")
 	route.HeadersRegexp("Content-Type", matchContent)
 
+	route = r.Handle("/workloads/{workload_id:"+uuid.UUIDRegex+"}/prewarm", Handler{context, prewarmWorkload, true})
+	route.Methods("POST")
+	route.HeadersRegexp("Content-Type", matchContent)
+
 	route = r.Handle("/{tenant:"+uuid.UUIDRegex+"}/workloads", Handler{context, addWorkload, false})
 	route.Methods("POST")
 	route.HeadersRegexp("Content-Type", matchContent)
@@ -721,6 +1179,12 @@ func Routes(config Config) *mux.Router {
 	route.Methods("GET")
 	route.HeadersRegexp("Content-Type", matchContent)
 
+	// pre-warming the image of a tenant workload is an admin operation
+	// too, the images are cached on nodes shared by all tenants.
+	route = r.Handle("/{tenant:"+uuid.UUIDRegex+"}/workloads/{workload_id:"+uuid.UUIDRegex+"}/prewarm", Handler{context, prewarmWorkload, true})
+	route.Methods("POST")
+	route.HeadersRegexp("Content-Type", matchContent)
+
 	// tenant quotas
 	matchContent = fmt.Sprintf("application/(%s|json)", TenantsV1)
 
@@ -736,5 +1200,86 @@ func Routes(config Config) *mux.Router {
 	route.Methods("PUT")
 	route.HeadersRegexp("Content-Type", matchContent)
 
+	// volume reconciliation
+	matchContent = fmt.Sprintf("application/(%s|json)", VolumesV1)
+
+	route = r.Handle("/volumes/reconciliation", Handler{context, showVolumeReconciliation, true})
+	route.Methods("GET")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	// security groups
+	matchContent = fmt.Sprintf("application/(%s|json)", SecurityGroupsV1)
+
+	route = r.Handle("/security-groups", Handler{context, listSecurityGroups, true})
+	route.Methods("GET")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/{tenant:"+uuid.UUIDRegex+"}/security-groups", Handler{context, listSecurityGroups, false})
+	route.Methods("GET")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/{tenant:"+uuid.UUIDRegex+"}/security-groups", Handler{context, addSecurityGroup, false})
+	route.Methods("POST")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/{tenant:"+uuid.UUIDRegex+"}/security-groups/{group_id:"+uuid.UUIDRegex+"}", Handler{context, showSecurityGroup, false})
+	route.Methods("GET")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/{tenant:"+uuid.UUIDRegex+"}/security-groups/{group_id:"+uuid.UUIDRegex+"}", Handler{context, deleteSecurityGroup, false})
+	route.Methods("DELETE")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/{tenant:"+uuid.UUIDRegex+"}/security-groups/{group_id:"+uuid.UUIDRegex+"}/rules", Handler{context, addSecurityGroupRule, false})
+	route.Methods("POST")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/{tenant:"+uuid.UUIDRegex+"}/security-groups/{group_id:"+uuid.UUIDRegex+"}/rules/{rule_id:"+uuid.UUIDRegex+"}", Handler{context, deleteSecurityGroupRule, false})
+	route.Methods("DELETE")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/{tenant:"+uuid.UUIDRegex+"}/security-groups/{group_id:"+uuid.UUIDRegex+"}/instances", Handler{context, addSecurityGroupInstance, false})
+	route.Methods("POST")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/{tenant:"+uuid.UUIDRegex+"}/security-groups/{group_id:"+uuid.UUIDRegex+"}/instances/{instance_id:"+uuid.UUIDRegex+"}", Handler{context, removeSecurityGroupInstance, false})
+	route.Methods("DELETE")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	// networks
+	matchContent = fmt.Sprintf("application/(%s|json)", NetworksV1)
+
+	route = r.Handle("/networks", Handler{context, listNetworks, true})
+	route.Methods("GET")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/{tenant:"+uuid.UUIDRegex+"}/networks", Handler{context, listNetworks, false})
+	route.Methods("GET")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/{tenant:"+uuid.UUIDRegex+"}/networks", Handler{context, addNetwork, false})
+	route.Methods("POST")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/{tenant:"+uuid.UUIDRegex+"}/networks/{network_id:"+uuid.UUIDRegex+"}", Handler{context, showNetwork, false})
+	route.Methods("GET")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/{tenant:"+uuid.UUIDRegex+"}/networks/{network_id:"+uuid.UUIDRegex+"}", Handler{context, deleteNetwork, false})
+	route.Methods("DELETE")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/{tenant:"+uuid.UUIDRegex+"}/networks/{network_id:"+uuid.UUIDRegex+"}/subnets", Handler{context, addNetworkSubnet, false})
+	route.Methods("POST")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/{tenant:"+uuid.UUIDRegex+"}/networks/{network_id:"+uuid.UUIDRegex+"}/subnets/{subnet_id:"+uuid.UUIDRegex+"}", Handler{context, updateNetworkSubnet, false})
+	route.Methods("PUT")
+	route.HeadersRegexp("Content-Type", matchContent)
+
+	route = r.Handle("/{tenant:"+uuid.UUIDRegex+"}/networks/{network_id:"+uuid.UUIDRegex+"}/subnets/{subnet_id:"+uuid.UUIDRegex+"}", Handler{context, deleteNetworkSubnet, false})
+	route.Methods("DELETE")
+	route.HeadersRegexp("Content-Type", matchContent)
+
 	return r
 }
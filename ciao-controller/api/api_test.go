@@ -21,6 +21,7 @@ import (
 	"net/http/httptest"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/01org/ciao/ciao-controller/types"
 	"github.com/01org/ciao/payloads"
@@ -49,7 +50,7 @@ var tests = []test{
 		"",
 		"application/text",
 		http.StatusOK,
-		`[{"rel":"pools","href":"/pools","version":"x.ciao.pools.v1","minimum_version":"x.ciao.pools.v1"},{"rel":"external-ips","href":"/external-ips","version":"x.ciao.external-ips.v1","minimum_version":"x.ciao.external-ips.v1"},{"rel":"workloads","href":"/workloads","version":"x.ciao.workloads.v1","minimum_version":"x.ciao.workloads.v1"},{"rel":"tenants","href":"/tenants","version":"x.ciao.tenants.v1","minimum_version":"x.ciao.tenants.v1"}]`,
+		`[{"rel":"pools","href":"/pools","version":"x.ciao.pools.v1","minimum_version":"x.ciao.pools.v1"},{"rel":"external-ips","href":"/external-ips","version":"x.ciao.external-ips.v1","minimum_version":"x.ciao.external-ips.v1"},{"rel":"workloads","href":"/workloads","version":"x.ciao.workloads.v1","minimum_version":"x.ciao.workloads.v1"},{"rel":"tenants","href":"/tenants","version":"x.ciao.tenants.v1","minimum_version":"x.ciao.tenants.v1"},{"rel":"security-groups","href":"/security-groups","version":"x.ciao.security-groups.v1","minimum_version":"x.ciao.security-groups.v1"},{"rel":"networks","href":"/networks","version":"x.ciao.networks.v1","minimum_version":"x.ciao.networks.v1"}]`,
 	},
 	{
 		"GET",
@@ -141,6 +142,33 @@ var tests = []test{
 		http.StatusNoContent,
 		"null",
 	},
+	{
+		"GET",
+		"/external-ips/port-forwards",
+		listPortForwards,
+		"",
+		ExternalIPsV1,
+		http.StatusOK,
+		`[{"forward_id":"validID","external_ip":"192.168.0.1","external_port":8022,"protocol":"tcp","instance_id":"validinstanceID","internal_ip":"172.16.0.1","internal_port":22,"tenant_id":"validtenant","pool_id":"validpool","pool_name":"mypool","links":[{"rel":"self","href":"/external-ips/port-forwards/validID"}]}]`,
+	},
+	{
+		"POST",
+		"/external-ips/port-forwards",
+		addPortForward,
+		`{"pool_name":"mypool","external_port":8022,"protocol":"tcp","instance_id":"validinstanceID","internal_port":22}`,
+		ExternalIPsV1,
+		http.StatusCreated,
+		`{"forward_id":"validID","external_ip":"192.168.0.1","external_port":8022,"protocol":"tcp","instance_id":"validinstanceID","internal_ip":"172.16.0.1","internal_port":22,"tenant_id":"validtenant","pool_id":"validpool","pool_name":"mypool","links":null}`,
+	},
+	{
+		"DELETE",
+		"/external-ips/port-forwards/validID",
+		deletePortForward,
+		"",
+		ExternalIPsV1,
+		http.StatusNoContent,
+		"null",
+	},
 	{
 		"POST",
 		"/workloads",
@@ -168,6 +196,15 @@ var tests = []test{
 		http.StatusOK,
 		`{"id":"ba58f471-0735-4773-9550-188e2d012941","description":"testWorkload","fw_type":"legacy","vm_type":"qemu","image_id":"73a86d7e-93c0-480e-9c41-ab42f69b7799","image_name":"","config":"this will totally work!","defaults":null,"storage":null}`,
 	},
+	{
+		"POST",
+		"/workloads/ba58f471-0735-4773-9550-188e2d012941/prewarm",
+		prewarmWorkload,
+		`{"nodes":["validnode"]}`,
+		"application/x.ciao.v1.workloads",
+		http.StatusAccepted,
+		`{"image":"docker/latest","nodes":["validnode"]}`,
+	},
 	{
 		"GET",
 		"/tenants/test-tenant-id/quotas/",
@@ -177,6 +214,104 @@ var tests = []test{
 		http.StatusOK,
 		`{"quotas":[{"name":"test-quota-1","value":"10","usage":"3"},{"name":"test-quota-2","value":"unlimited","usage":"10"},{"name":"test-limit","value":"123"}]}`,
 	},
+	{
+		"GET",
+		"/volumes/reconciliation",
+		showVolumeReconciliation,
+		"",
+		"application/x.ciao.v1.volumes",
+		http.StatusOK,
+		`{"last_run":"2017-03-01T10:00:00Z","cleanup":false,"issues":[{"type":"orphaned_volume","volume_id":"validID","detail":"present in the storage backend but unknown to the controller","cleaned":false}]}`,
+	},
+	{
+		"GET",
+		"/security-groups",
+		listSecurityGroups,
+		"",
+		"application/x.ciao.security-groups.v1",
+		http.StatusOK,
+		`[{"id":"validID","tenant_id":"test-tenant-id","name":"web","description":"","rules":[{"id":"validRuleID","protocol":"tcp","port_range_min":80,"port_range_max":80}],"instances":["validInstanceID"],"links":[{"rel":"self","href":"/test-tenant-id/security-groups/validID"}]}]`,
+	},
+	{
+		"POST",
+		"/test-tenant-id/security-groups",
+		addSecurityGroup,
+		`{"name":"web","rules":[{"protocol":"tcp","port_range_min":80}]}`,
+		"application/x.ciao.security-groups.v1",
+		http.StatusCreated,
+		`{"id":"validID","tenant_id":"test-tenant-id","name":"web","description":"","rules":[{"id":"validRuleID","protocol":"tcp","port_range_min":80,"port_range_max":80}],"instances":null,"links":null}`,
+	},
+	{
+		"POST",
+		"/test-tenant-id/security-groups/validID/rules",
+		addSecurityGroupRule,
+		`{"protocol":"icmp"}`,
+		"application/x.ciao.security-groups.v1",
+		http.StatusCreated,
+		`{"id":"validRuleID","protocol":"icmp"}`,
+	},
+	{
+		"POST",
+		"/test-tenant-id/security-groups/validID/instances",
+		addSecurityGroupInstance,
+		`{"instance_id":"validInstanceID"}`,
+		"application/x.ciao.security-groups.v1",
+		http.StatusNoContent,
+		"null",
+	},
+	{
+		"DELETE",
+		"/test-tenant-id/security-groups/validID",
+		deleteSecurityGroup,
+		"",
+		"application/x.ciao.security-groups.v1",
+		http.StatusNoContent,
+		"null",
+	}, {
+		"GET",
+		"/networks",
+		listNetworks,
+		"",
+		"application/x.ciao.networks.v1",
+		http.StatusOK,
+		`[{"id":"validID","tenant_id":"test-tenant-id","name":"private","subnets":[{"id":"validSubnetID","network_id":"validID","name":"","cidr":"10.0.0.0/24","gateway_ip":"10.0.0.1"}],"links":[{"rel":"self","href":"/test-tenant-id/networks/validID"}]}]`,
+	},
+	{
+		"POST",
+		"/test-tenant-id/networks",
+		addNetwork,
+		`{"name":"private","subnets":[{"cidr":"10.0.0.0/24"}]}`,
+		"application/x.ciao.networks.v1",
+		http.StatusCreated,
+		`{"id":"validID","tenant_id":"test-tenant-id","name":"private","subnets":[{"id":"validSubnetID","network_id":"validID","name":"","cidr":"10.0.0.0/24","gateway_ip":"10.0.0.1"}],"links":null}`,
+	},
+	{
+		"POST",
+		"/test-tenant-id/networks/validID/subnets",
+		addNetworkSubnet,
+		`{"name":"db","cidr":"10.0.1.0/24","gateway_ip":"10.0.1.254"}`,
+		"application/x.ciao.networks.v1",
+		http.StatusCreated,
+		`{"id":"validSubnetID","network_id":"validID","name":"db","cidr":"10.0.1.0/24","gateway_ip":"10.0.1.254"}`,
+	},
+	{
+		"PUT",
+		"/test-tenant-id/networks/validID/subnets/validSubnetID",
+		updateNetworkSubnet,
+		`{"dns_nameservers":["10.0.0.53"],"host_routes":[{"destination":"10.1.0.0/16","nexthop":"10.0.0.254"}]}`,
+		"application/x.ciao.networks.v1",
+		http.StatusOK,
+		`{"id":"validSubnetID","network_id":"validID","name":"","cidr":"10.0.0.0/24","gateway_ip":"10.0.0.1","dns_nameservers":["10.0.0.53"],"host_routes":[{"destination":"10.1.0.0/16","nexthop":"10.0.0.254"}]}`,
+	},
+	{
+		"DELETE",
+		"/test-tenant-id/networks/validID",
+		deleteNetwork,
+		"",
+		"application/x.ciao.networks.v1",
+		http.StatusNoContent,
+		"null",
+	},
 }
 
 type testCiaoService struct{}
@@ -282,6 +417,43 @@ func (ts testCiaoService) UnMapAddress(string) error {
 	return nil
 }
 
+func (ts testCiaoService) ListPortForwards(tenant *string) []types.PortForward {
+	f := types.PortForward{
+		ID:           "validID",
+		ExternalIP:   "192.168.0.1",
+		ExternalPort: 8022,
+		Protocol:     "tcp",
+		InstanceID:   "validinstanceID",
+		InternalIP:   "172.16.0.1",
+		InternalPort: 22,
+		TenantID:     "validtenant",
+		PoolID:       "validpool",
+		PoolName:     "mypool",
+		Links:        []types.Link{{Rel: "self", Href: "/external-ips/port-forwards/validID"}},
+	}
+
+	return []types.PortForward{f}
+}
+
+func (ts testCiaoService) AddPortForward(tenant *string, req types.PortForwardRequest) (types.PortForward, error) {
+	return types.PortForward{
+		ID:           "validID",
+		ExternalIP:   "192.168.0.1",
+		ExternalPort: req.ExternalPort,
+		Protocol:     req.Protocol,
+		InstanceID:   req.InstanceID,
+		InternalIP:   "172.16.0.1",
+		InternalPort: req.InternalPort,
+		TenantID:     "validtenant",
+		PoolID:       "validpool",
+		PoolName:     *req.PoolName,
+	}, nil
+}
+
+func (ts testCiaoService) DeletePortForward(tenant *string, ID string) error {
+	return nil
+}
+
 func (ts testCiaoService) CreateWorkload(req types.Workload) (types.Workload, error) {
 	req.ID = "ba58f471-0735-4773-9550-188e2d012941"
 	return req, nil
@@ -303,6 +475,10 @@ func (ts testCiaoService) ShowWorkload(tenant string, ID string) (types.Workload
 	}, nil
 }
 
+func (ts testCiaoService) PrewarmWorkload(tenant string, ID string, nodes []string) (types.PrewarmResponse, error) {
+	return types.PrewarmResponse{Image: "docker/latest", Nodes: nodes}, nil
+}
+
 func (ts testCiaoService) ListQuotas(tenantID string) []types.QuotaDetails {
 	return []types.QuotaDetails{
 		{Name: "test-quota-1", Value: 10, Usage: 3},
@@ -315,6 +491,146 @@ func (ts testCiaoService) UpdateQuotas(tenantID string, qds []types.QuotaDetails
 	return nil
 }
 
+func (ts testCiaoService) ShowVolumeReconciliation() types.VolumeReconciliation {
+	return types.VolumeReconciliation{
+		LastRun: time.Date(2017, 3, 1, 10, 0, 0, 0, time.UTC),
+		Issues: []types.VolumeIssue{
+			{
+				Type:     types.OrphanedVolume,
+				VolumeID: "validID",
+				Detail:   "present in the storage backend but unknown to the controller",
+			},
+		},
+	}
+}
+
+func (ts testCiaoService) ListSecurityGroups(tenantID *string) []types.SecurityGroup {
+	return []types.SecurityGroup{
+		{
+			ID:       "validID",
+			TenantID: "test-tenant-id",
+			Name:     "web",
+			Rules: []types.SecurityGroupRule{
+				{ID: "validRuleID", Protocol: "tcp", PortMin: 80, PortMax: 80},
+			},
+			Instances: []string{"validInstanceID"},
+			Links: []types.Link{
+				{Rel: "self", Href: "/test-tenant-id/security-groups/validID"},
+			},
+		},
+	}
+}
+
+func (ts testCiaoService) CreateSecurityGroup(tenantID string, req types.NewSecurityGroupRequest) (types.SecurityGroup, error) {
+	sg := types.SecurityGroup{
+		ID:          "validID",
+		TenantID:    "test-tenant-id",
+		Name:        req.Name,
+		Description: req.Description,
+	}
+
+	for _, r := range req.Rules {
+		r.ID = "validRuleID"
+		if r.PortMax == 0 {
+			r.PortMax = r.PortMin
+		}
+		sg.Rules = append(sg.Rules, r)
+	}
+
+	return sg, nil
+}
+
+func (ts testCiaoService) ShowSecurityGroup(tenantID string, groupID string) (types.SecurityGroup, error) {
+	return ts.ListSecurityGroups(&tenantID)[0], nil
+}
+
+func (ts testCiaoService) DeleteSecurityGroup(tenantID string, groupID string) error {
+	return nil
+}
+
+func (ts testCiaoService) AddSecurityGroupRule(tenantID string, groupID string, rule types.SecurityGroupRule) (types.SecurityGroupRule, error) {
+	rule.ID = "validRuleID"
+	return rule, nil
+}
+
+func (ts testCiaoService) DeleteSecurityGroupRule(tenantID string, groupID string, ruleID string) error {
+	return nil
+}
+
+func (ts testCiaoService) AddSecurityGroupInstance(tenantID string, groupID string, instanceID string) error {
+	return nil
+}
+
+func (ts testCiaoService) RemoveSecurityGroupInstance(tenantID string, groupID string, instanceID string) error {
+	return nil
+}
+
+func (ts testCiaoService) ListNetworks(tenantID *string) []types.TenantNetwork {
+	return []types.TenantNetwork{
+		{
+			ID:       "validID",
+			TenantID: "test-tenant-id",
+			Name:     "private",
+			Subnets: []types.TenantSubnet{
+				{ID: "validSubnetID", NetworkID: "validID", CIDR: "10.0.0.0/24", GatewayIP: "10.0.0.1"},
+			},
+			Links: []types.Link{
+				{Rel: "self", Href: "/test-tenant-id/networks/validID"},
+			},
+		},
+	}
+}
+
+func (ts testCiaoService) CreateNetwork(tenantID string, req types.NewNetworkRequest) (types.TenantNetwork, error) {
+	n := types.TenantNetwork{
+		ID:       "validID",
+		TenantID: "test-tenant-id",
+		Name:     req.Name,
+	}
+
+	for _, r := range req.Subnets {
+		sn, _ := ts.AddNetworkSubnet(tenantID, n.ID, r)
+		n.Subnets = append(n.Subnets, sn)
+	}
+
+	return n, nil
+}
+
+func (ts testCiaoService) ShowNetwork(tenantID string, networkID string) (types.TenantNetwork, error) {
+	return ts.ListNetworks(&tenantID)[0], nil
+}
+
+func (ts testCiaoService) DeleteNetwork(tenantID string, networkID string) error {
+	return nil
+}
+
+func (ts testCiaoService) AddNetworkSubnet(tenantID string, networkID string, req types.NewSubnetRequest) (types.TenantSubnet, error) {
+	sn := types.TenantSubnet{
+		ID:        "validSubnetID",
+		NetworkID: "validID",
+		Name:      req.Name,
+		CIDR:      req.CIDR,
+		GatewayIP: req.GatewayIP,
+	}
+
+	if sn.GatewayIP == "" {
+		sn.GatewayIP = "10.0.0.1"
+	}
+
+	return sn, nil
+}
+
+func (ts testCiaoService) UpdateNetworkSubnet(tenantID string, networkID string, subnetID string, req types.SubnetDHCPOptions) (types.TenantSubnet, error) {
+	sn := ts.ListNetworks(&tenantID)[0].Subnets[0]
+	sn.SubnetDHCPOptions = req
+
+	return sn, nil
+}
+
+func (ts testCiaoService) DeleteNetworkSubnet(tenantID string, networkID string, subnetID string) error {
+	return nil
+}
+
 func TestResponse(t *testing.T) {
 	var ts testCiaoService
 
@@ -20,6 +20,9 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"net"
+	"regexp"
+	"strconv"
 	"time"
 
 	"github.com/01org/ciao/ciao-controller/types"
@@ -41,8 +44,15 @@ type controllerClient interface {
 	Disconnect()
 	mapExternalIP(t types.Tenant, m types.MappedIP) error
 	unMapExternalIP(t types.Tenant, m types.MappedIP) error
-	attachVolume(volID string, instanceID string, nodeID string) error
+	attachVolume(volID string, instanceID string, nodeID string, limits payloads.IOLimits, readOnly bool) error
 	detachVolume(volID string, instanceID string, nodeID string) error
+	updateVolume(volID string, instanceID string, nodeID string, limits payloads.IOLimits) error
+	prewarmImage(image string, nodeID string) error
+	applySecurityRules(t types.Tenant, i types.Instance, rules []types.SecurityGroupRule) error
+	removeSecurityRules(t types.Tenant, i types.Instance) error
+	updateDNSRecords(t types.Tenant, added []types.Instance, removed []types.Instance) error
+	updateDHCPOptions(t types.Tenant, sn types.TenantSubnet) error
+	updatePortForwards(t types.Tenant, forwards []types.PortForward) error
 	ssntpClient() *ssntp.Client
 }
 
@@ -77,10 +87,14 @@ func (client *ssntpClient) CommandNotify(command ssntp.Command, frame *ssntp.Fra
 			glog.Warningf("Error unmarshalling STATS: %v", err)
 			return
 		}
+		arrived := client.ctl.securityGroupArrivals(stats)
 		err = client.ctl.ds.HandleStats(stats)
 		if err != nil {
 			glog.Warningf("Error updating stats in datastore: %v", err)
 		}
+		for _, instanceID := range arrived {
+			go client.ctl.enforceArrivedInstance(instanceID)
+		}
 	}
 	glog.V(1).Info(string(payload))
 }
@@ -120,6 +134,8 @@ func (client *ssntpClient) removeInstance(instanceID string) {
 		glog.Warningf("Error when releasing resources for deleted instance: %v", err)
 	}
 	client.deleteEphemeralStorage(instanceID)
+	client.ctl.releaseSecurityGroups(instanceID)
+	client.ctl.unpublishInstanceName(instanceID)
 	err = client.ctl.ds.DeleteInstance(instanceID)
 	if err != nil {
 		glog.Warningf("Error deleting instance from datastore: %v", err)
@@ -162,6 +178,16 @@ func (client *ssntpClient) instanceAdded(payload []byte) {
 	if err != nil {
 		glog.Warningf("Error adding CNCI IP to datastore: %v", err)
 	}
+
+	// a new CNCI does not know about the tenant's security groups,
+	// instance names, subnet DHCP options and port forwards yet.
+	i, err := client.ctl.ds.GetInstance(newCNCI.InstanceUUID)
+	if err == nil {
+		go client.ctl.enforceTenantSecurityGroups(i.TenantID)
+		go client.ctl.publishTenantNames(i.TenantID)
+		go client.ctl.publishTenantDHCPOptions(i.TenantID)
+		go client.ctl.publishTenantPortForwards(i.TenantID)
+	}
 }
 
 func (client *ssntpClient) traceReport(payload []byte) {
@@ -351,6 +377,26 @@ func (client *ssntpClient) detachVolumeFailure(payload []byte) {
 	}
 }
 
+func (client *ssntpClient) updateVolumeFailure(payload []byte) {
+	var failure payloads.ErrorUpdateVolumeFailure
+	err := yaml.Unmarshal(payload, &failure)
+	if err != nil {
+		glog.Warningf("Error unmarshalling UpdateVolumeFailure: %v", err)
+		return
+	}
+
+	data, err := client.ctl.ds.GetBlockDevice(failure.VolumeUUID)
+	if err != nil {
+		glog.Warningf("Error handling UpdateVolumeFailure: %v", err)
+		return
+	}
+
+	// the datastore keeps the requested settings, they will be applied
+	// the next time the volume is attached.
+	msg := fmt.Sprintf("Update Volume Failure %s of %s: %s", failure.VolumeUUID, failure.InstanceUUID, failure.Reason.String())
+	client.ctl.ds.LogError(data.TenantID, msg)
+}
+
 func (client *ssntpClient) assignError(payload []byte) {
 	var failure payloads.ErrorPublicIPFailure
 	err := yaml.Unmarshal(payload, &failure)
@@ -405,6 +451,9 @@ func (client *ssntpClient) ErrorNotify(err ssntp.Error, frame *ssntp.Frame) {
 	case ssntp.DetachVolumeFailure:
 		client.detachVolumeFailure(payload)
 
+	case ssntp.UpdateVolumeFailure:
+		client.updateVolumeFailure(payload)
+
 	case ssntp.AssignPublicIPFailure:
 		client.assignError(payload)
 
@@ -414,6 +463,14 @@ func (client *ssntpClient) ErrorNotify(err ssntp.Error, frame *ssntp.Frame) {
 	}
 }
 
+var encryptionKeyRegexp = regexp.MustCompile(`(encryption_key:\s*)\S+`)
+
+// redactPayload hides the volume encryption keys in a payload so that it
+// can be safely logged.
+func redactPayload(payload string) string {
+	return encryptionKeyRegexp.ReplaceAllString(payload, "${1}<redacted>")
+}
+
 func newSSNTPClient(ctl *controller, config *ssntp.Config) (controllerClient, error) {
 	client := &ssntpClient{name: "ciao Controller", ctl: ctl}
 
@@ -423,7 +480,7 @@ func newSSNTPClient(ctl *controller, config *ssntp.Config) (controllerClient, er
 
 func (client *ssntpClient) StartTracedWorkload(config string, startTime time.Time, label string) error {
 	glog.V(1).Info("START TRACED config:")
-	glog.V(1).Info(config)
+	glog.V(1).Info(redactPayload(config))
 
 	traceConfig := &ssntp.TraceConfig{
 		PathTrace: true,
@@ -438,7 +495,7 @@ func (client *ssntpClient) StartTracedWorkload(config string, startTime time.Tim
 
 func (client *ssntpClient) StartWorkload(config string) error {
 	glog.V(1).Info("START config:")
-	glog.V(1).Info(config)
+	glog.V(1).Info(redactPayload(config))
 
 	_, err := client.ssntp.SendCommand(ssntp.START, []byte(config))
 
@@ -518,11 +575,38 @@ func (client *ssntpClient) RestartInstance(i *types.Instance, w *types.Workload,
 			ConcentratorIP:   t.CNCIIP,
 			Subnet:           i.Subnet,
 			PrivateIP:        i.IPAddress,
+			PrivateIPv6:      i.IPv6Address,
 		},
 		Storage: make([]payloads.StorageResource, len(i.Attachments)),
 		Restart: true,
 	}
 
+	subnetKey, err := client.ctl.ds.TenantSubnetKey(i.TenantID, i.Subnet)
+	if err != nil {
+		return errors.Wrapf(err, "Unable to get subnet key of instance")
+	}
+	restartCmd.Networking.SubnetKey = strconv.Itoa(subnetKey)
+
+	if ipv6 := net.ParseIP(i.IPv6Address); ipv6 != nil {
+		mask := net.CIDRMask(64, 128)
+		subnetV6 := net.IPNet{IP: ipv6.Mask(mask), Mask: mask}
+		restartCmd.Networking.SubnetV6 = subnetV6.String()
+	}
+
+	if i.SubnetID != "" {
+		sn, err := client.ctl.ds.GetSubnet(i.SubnetID)
+		if err != nil {
+			return errors.Wrapf(err, "Unable to get subnet of instance")
+		}
+		restartCmd.Networking.Gateway = sn.GatewayIP
+		restartCmd.Networking.NetworkUUID = sn.NetworkID
+	}
+
+	restartCmd.ExtraNetworking, err = client.ctl.extraNetworking(t, i.ExtraNICs)
+	if err != nil {
+		return errors.Wrapf(err, "Unable to get subnets of instance")
+	}
+
 	if w.VMType == payloads.Docker {
 		restartCmd.DockerImage = w.ImageName
 	}
@@ -532,6 +616,9 @@ func (client *ssntpClient) RestartInstance(i *types.Instance, w *types.Workload,
 		vol.ID = i.Attachments[k].BlockID
 		vol.Bootable = i.Attachments[k].Boot
 		vol.Ephemeral = i.Attachments[k].Ephemeral
+		vol.ReadOnly = i.Attachments[k].ReadOnly
+		vol.IOLimits = client.ctl.volumeIOLimits(vol.ID)
+		vol.EncryptionKey = client.ctl.volumeEncryptionKey(vol.ID)
 	}
 
 	payload := payloads.Start{
@@ -558,7 +645,7 @@ func (client *ssntpClient) RestartInstance(i *types.Instance, w *types.Workload,
 	_, _ = buf.WriteString("\n...\n")
 
 	glog.Info("RESTART instance: ", i.ID)
-	glog.V(1).Info(buf.String())
+	glog.V(1).Info(redactPayload(buf.String()))
 
 	_, err = client.ssntp.SendCommand(ssntp.START, buf.Bytes())
 
@@ -587,22 +674,29 @@ func (client *ssntpClient) EvacuateNode(nodeID string) error {
 	return err
 }
 
-func (client *ssntpClient) attachVolume(volID string, instanceID string, nodeID string) error {
+func (client *ssntpClient) attachVolume(volID string, instanceID string, nodeID string, limits payloads.IOLimits, readOnly bool) error {
 	payload := payloads.AttachVolume{
 		Attach: payloads.VolumeCmd{
 			InstanceUUID:      instanceID,
 			VolumeUUID:        volID,
 			WorkloadAgentUUID: nodeID,
+			ReadOnly:          readOnly,
 		},
 	}
 
+	if !limits.IsZero() {
+		payload.Attach.IOLimits = &limits
+	}
+
+	payload.Attach.EncryptionKey = client.ctl.volumeEncryptionKey(volID)
+
 	y, err := yaml.Marshal(payload)
 	if err != nil {
 		return err
 	}
 
 	glog.Infof("AttachVolume %s to %s\n", volID, instanceID)
-	glog.V(1).Info(string(y))
+	glog.V(1).Info(redactPayload(string(y)))
 
 	_, err = client.ssntp.SendCommand(ssntp.AttachVolume, y)
 
@@ -631,6 +725,50 @@ func (client *ssntpClient) detachVolume(volID string, instanceID string, nodeID
 	return err
 }
 
+func (client *ssntpClient) updateVolume(volID string, instanceID string, nodeID string, limits payloads.IOLimits) error {
+	payload := payloads.UpdateVolume{
+		Update: payloads.VolumeCmd{
+			InstanceUUID:      instanceID,
+			VolumeUUID:        volID,
+			WorkloadAgentUUID: nodeID,
+			IOLimits:          &limits,
+		},
+	}
+
+	y, err := yaml.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	glog.Infof("UpdateVolume %s of %s\n", volID, instanceID)
+	glog.V(1).Info(string(y))
+
+	_, err = client.ssntp.SendCommand(ssntp.UpdateVolume, y)
+
+	return err
+}
+
+func (client *ssntpClient) prewarmImage(image string, nodeID string) error {
+	payload := payloads.PrewarmImage{
+		Prewarm: payloads.PrewarmImageCmd{
+			DockerImage:       image,
+			WorkloadAgentUUID: nodeID,
+		},
+	}
+
+	y, err := yaml.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	glog.Infof("PrewarmImage %s on %s\n", image, nodeID)
+	glog.V(1).Info(string(y))
+
+	_, err = client.ssntp.SendCommand(ssntp.PrewarmImage, y)
+
+	return err
+}
+
 func (client *ssntpClient) ssntpClient() *ssntp.Client {
 	return &client.ssntp
 }
@@ -686,3 +824,164 @@ func (client *ssntpClient) unMapExternalIP(t types.Tenant, m types.MappedIP) err
 	_, err = client.ssntp.SendCommand(ssntp.ReleasePublicIP, y)
 	return err
 }
+
+func securityRulesCommand(t types.Tenant, i types.Instance) payloads.SecurityRulesCommand {
+	return payloads.SecurityRulesCommand{
+		ConcentratorUUID:  t.CNCIID,
+		WorkloadAgentUUID: i.NodeID,
+		TenantUUID:        i.TenantID,
+		InstanceUUID:      i.ID,
+		PrivateIP:         i.IPAddress,
+		PrivateIPv6:       i.IPv6Address,
+	}
+}
+
+func (client *ssntpClient) applySecurityRules(t types.Tenant, i types.Instance, rules []types.SecurityGroupRule) error {
+	payload := payloads.CommandApplySecurityRules{
+		Apply: securityRulesCommand(t, i),
+	}
+
+	for _, r := range rules {
+		payload.Apply.Rules = append(payload.Apply.Rules, payloads.SecurityRule{
+			Protocol:   r.Protocol,
+			PortMin:    r.PortMin,
+			PortMax:    r.PortMax,
+			RemoteCIDR: r.RemoteCIDR,
+		})
+	}
+
+	y, err := yaml.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	glog.Infof("Request security rules for %s (%s)\n", i.ID, i.IPAddress)
+	glog.V(1).Info(string(y))
+
+	_, err = client.ssntp.SendCommand(ssntp.ApplySecurityRules, y)
+	return err
+}
+
+func (client *ssntpClient) removeSecurityRules(t types.Tenant, i types.Instance) error {
+	payload := payloads.CommandRemoveSecurityRules{
+		Remove: securityRulesCommand(t, i),
+	}
+
+	y, err := yaml.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	glog.Infof("Request removal of security rules for %s (%s)\n", i.ID, i.IPAddress)
+	glog.V(1).Info(string(y))
+
+	_, err = client.ssntp.SendCommand(ssntp.RemoveSecurityRules, y)
+	return err
+}
+
+func dnsRecords(instances []types.Instance) []payloads.DNSRecord {
+	var records []payloads.DNSRecord
+
+	for _, i := range instances {
+		r := payloads.DNSRecord{
+			InstanceUUID: i.ID,
+			Name:         instanceName(i),
+			PrivateIP:    i.IPAddress,
+			MACAddress:   i.MACAddress,
+		}
+		for _, nic := range i.ExtraNICs {
+			r.ExtraPrivateIPs = append(r.ExtraPrivateIPs, nic.IPAddress)
+			r.ExtraMACAddresses = append(r.ExtraMACAddresses, nic.MACAddress)
+		}
+		records = append(records, r)
+	}
+
+	return records
+}
+
+func (client *ssntpClient) updateDNSRecords(t types.Tenant, added []types.Instance, removed []types.Instance) error {
+	payload := payloads.EventDNSRecordsUpdated{
+		DNSRecords: payloads.DNSRecordsEvent{
+			ConcentratorUUID: t.CNCIID,
+			TenantUUID:       t.ID,
+			Domain:           tenantDNSDomain,
+			Added:            dnsRecords(added),
+			Removed:          dnsRecords(removed),
+		},
+	}
+
+	y, err := yaml.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	glog.Infof("Update DNS records of tenant %s: %d added %d removed\n", t.ID, len(added), len(removed))
+	glog.V(1).Info(string(y))
+
+	_, err = client.ssntp.SendEvent(ssntp.DNSRecordsUpdated, y)
+	return err
+}
+
+func (client *ssntpClient) updateDHCPOptions(t types.Tenant, sn types.TenantSubnet) error {
+	var routes []payloads.HostRoute
+	for _, r := range sn.HostRoutes {
+		routes = append(routes, payloads.HostRoute{
+			Destination: r.Destination,
+			NextHop:     r.NextHop,
+		})
+	}
+
+	payload := payloads.EventDHCPOptionsUpdated{
+		DHCPOptions: payloads.DHCPOptionsEvent{
+			ConcentratorUUID: t.CNCIID,
+			TenantUUID:       t.ID,
+			SubnetUUID:       sn.ID,
+			TenantSubnet:     sn.CIDR,
+			DNSServers:       sn.DNSNameservers,
+			SearchDomains:    sn.DNSSearchDomains,
+			Routes:           routes,
+			NTPServers:       sn.NTPServers,
+		},
+	}
+
+	y, err := yaml.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	glog.Infof("Update DHCP options of subnet %s of tenant %s\n", sn.CIDR, t.ID)
+	glog.V(1).Info(string(y))
+
+	_, err = client.ssntp.SendEvent(ssntp.DHCPOptionsUpdated, y)
+	return err
+}
+
+func (client *ssntpClient) updatePortForwards(t types.Tenant, forwards []types.PortForward) error {
+	payload := payloads.EventPortForwardsUpdated{
+		PortForwards: payloads.PortForwardsEvent{
+			ConcentratorUUID: t.CNCIID,
+			TenantUUID:       t.ID,
+		},
+	}
+
+	for _, f := range forwards {
+		payload.PortForwards.Forwards = append(payload.PortForwards.Forwards, payloads.PortForward{
+			Protocol:    f.Protocol,
+			PublicIP:    f.ExternalIP,
+			PublicPort:  f.ExternalPort,
+			PrivateIP:   f.InternalIP,
+			PrivatePort: f.InternalPort,
+		})
+	}
+
+	y, err := yaml.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	glog.Infof("Update port forwards of tenant %s: %d forwards\n", t.ID, len(forwards))
+	glog.V(1).Info(string(y))
+
+	_, err = client.ssntp.SendEvent(ssntp.PortForwardsUpdated, y)
+	return err
+}
@@ -22,6 +22,7 @@ import (
 	"time"
 
 	"github.com/01org/ciao/ciao-controller/types"
+	"github.com/01org/ciao/payloads"
 	"github.com/01org/ciao/ssntp"
 )
 
@@ -108,14 +109,42 @@ func (client *ssntpClientWrapper) unMapExternalIP(t types.Tenant, m types.Mapped
 	return client.realClient.unMapExternalIP(t, m)
 }
 
-func (client *ssntpClientWrapper) attachVolume(volID string, instanceID string, nodeID string) error {
-	return client.realClient.attachVolume(volID, instanceID, nodeID)
+func (client *ssntpClientWrapper) attachVolume(volID string, instanceID string, nodeID string, limits payloads.IOLimits, readOnly bool) error {
+	return client.realClient.attachVolume(volID, instanceID, nodeID, limits, readOnly)
 }
 
 func (client *ssntpClientWrapper) detachVolume(volID string, instanceID string, nodeID string) error {
 	return client.realClient.detachVolume(volID, instanceID, nodeID)
 }
 
+func (client *ssntpClientWrapper) updateVolume(volID string, instanceID string, nodeID string, limits payloads.IOLimits) error {
+	return client.realClient.updateVolume(volID, instanceID, nodeID, limits)
+}
+
+func (client *ssntpClientWrapper) prewarmImage(image string, nodeID string) error {
+	return client.realClient.prewarmImage(image, nodeID)
+}
+
+func (client *ssntpClientWrapper) applySecurityRules(t types.Tenant, i types.Instance, rules []types.SecurityGroupRule) error {
+	return client.realClient.applySecurityRules(t, i, rules)
+}
+
+func (client *ssntpClientWrapper) removeSecurityRules(t types.Tenant, i types.Instance) error {
+	return client.realClient.removeSecurityRules(t, i)
+}
+
+func (client *ssntpClientWrapper) updateDNSRecords(t types.Tenant, added []types.Instance, removed []types.Instance) error {
+	return client.realClient.updateDNSRecords(t, added, removed)
+}
+
+func (client *ssntpClientWrapper) updateDHCPOptions(t types.Tenant, sn types.TenantSubnet) error {
+	return client.realClient.updateDHCPOptions(t, sn)
+}
+
+func (client *ssntpClientWrapper) updatePortForwards(t types.Tenant, forwards []types.PortForward) error {
+	return client.realClient.updatePortForwards(t, forwards)
+}
+
 func (client *ssntpClientWrapper) ssntpClient() *ssntp.Client {
 	return client.realClient.ssntpClient()
 }
@@ -95,6 +95,13 @@ func (c *controller) deleteInstance(instanceID string) error {
 		}
 	}
 
+	// and for ports forwarded to the instance
+	for _, f := range c.ds.GetPortForwards(&i.TenantID) {
+		if f.InstanceID == instanceID {
+			return types.ErrInstanceMapped
+		}
+	}
+
 	go c.client.DeleteInstance(instanceID, i.NodeID)
 	return nil
 }
@@ -178,6 +185,11 @@ func (c *controller) startWorkload(w types.WorkloadRequest) ([]*types.Instance,
 		return nil, errors.New("Missing number of instances to start")
 	}
 
+	// a fixed address can only be given to a single instance
+	if w.Instances > 1 && (w.FixedIP != nil || w.MACAddress != nil) {
+		return nil, types.ErrBadRequest
+	}
+
 	wl, err := c.ds.GetWorkload(w.TenantID, w.WorkloadID)
 	if err != nil {
 		return nil, err
@@ -190,11 +202,23 @@ func (c *controller) startWorkload(w types.WorkloadRequest) ([]*types.Instance,
 		}
 	}
 
+	for _, s := range wl.Storage {
+		if s.SourceType != types.ImageService {
+			continue
+		}
+
+		err = c.checkImageBootable(w.TenantID, s.SourceID)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Unable to boot from image %s", s.SourceID)
+		}
+	}
+
 	var newInstances []*types.Instance
 
 	for i := 0; i < w.Instances && e == nil; i++ {
 		startTime := time.Now()
-		instance, err := newInstance(c, w.TenantID, &wl, w.Volumes)
+		instance, err := newInstance(c, w.TenantID, &wl, w.Volumes, w.NetworkIDs,
+			w.FixedIP, w.MACAddress)
 		if err != nil {
 			e = errors.Wrap(err, "Error creating instance")
 			continue
@@ -217,6 +241,9 @@ func (c *controller) startWorkload(w types.WorkloadRequest) ([]*types.Instance,
 			}
 
 			newInstances = append(newInstances, &instance.Instance)
+			if !instance.CNCI {
+				go c.publishInstanceName(instance.Instance)
+			}
 			if w.TraceLabel == "" {
 				go c.client.StartWorkload(instance.newConfig.config)
 			} else {
@@ -17,6 +17,7 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
@@ -24,14 +25,18 @@ import (
 	"os"
 	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/01org/ciao/ciao-controller/internal/datastore"
 	"github.com/01org/ciao/ciao-controller/internal/quotas"
 	"github.com/01org/ciao/ciao-controller/types"
+	imageDatastore "github.com/01org/ciao/ciao-image/datastore"
 	"github.com/01org/ciao/ciao-storage"
 	"github.com/01org/ciao/openstack/block"
+	"github.com/01org/ciao/openstack/compute"
+	"github.com/01org/ciao/openstack/image"
 	"github.com/01org/ciao/payloads"
 	"github.com/01org/ciao/ssntp"
 	"github.com/01org/ciao/ssntp/uuid"
@@ -240,7 +245,7 @@ func BenchmarkNewConfig(b *testing.B) {
 	b.ResetTimer()
 	noVolumes := []storage.BlockDevice{}
 	for n := 0; n < b.N; n++ {
-		_, err := newConfig(ctl, &wls[0], id.String(), tenant.ID, noVolumes)
+		_, err := newConfig(ctl, &wls[0], id.String(), tenant.ID, noVolumes, nil, nil, nil)
 		if err != nil {
 			b.Error(err)
 		}
@@ -329,6 +334,26 @@ func TestNewTenantHardwareAddr(t *testing.T) {
 	}
 }
 
+// TestTenantIPv6Addr
+// Confirm that the IPv6 subnet of a tenant subnet is derived from its
+// index and the SLAAC address of a tenant instance from its MAC address.
+func TestTenantIPv6Addr(t *testing.T) {
+	_, prefix, _ := net.ParseCIDR("fd00:1:2::/48")
+	ip := net.ParseIP("172.16.3.2")
+
+	subnet := tenantSubnetV6(*prefix, 0x1003)
+	expectedSubnet := "fd00:1:2:1003::/64"
+	if subnet.String() != expectedSubnet {
+		t.Error("Expected: ", expectedSubnet, " Received: ", subnet.String())
+	}
+
+	ipv6 := tenantIPv6Addr(subnet, newTenantHardwareAddr(ip))
+	expectedIP := "fd00:1:2:1003:0:acff:fe10:302"
+	if ipv6.String() != expectedIP {
+		t.Error("Expected: ", expectedIP, " Received: ", ipv6.String())
+	}
+}
+
 func TestStartWorkload(t *testing.T) {
 	var reason payloads.StartFailureReason
 
@@ -401,6 +426,39 @@ func TestDeleteInstance(t *testing.T) {
 	}
 }
 
+func TestInstanceDNSRecords(t *testing.T) {
+	var reason payloads.StartFailureReason
+
+	serverCh := server.AddEventChan(ssntp.DNSRecordsUpdated)
+
+	client, instances := testStartWorkload(t, 1, false, reason)
+	defer client.Shutdown()
+
+	result, err := server.GetEventChanResult(serverCh, ssntp.DNSRecordsUpdated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.TenantUUID != instances[0].TenantID {
+		t.Fatal("Did not get correct Tenant ID")
+	}
+
+	serverCh = server.AddEventChan(ssntp.DNSRecordsUpdated)
+	clientEvtCh := client.AddEventChan(ssntp.InstanceDeleted)
+	go client.SendDeleteEvent(instances[0].ID)
+	_, err = client.GetEventChanResult(clientEvtCh, ssntp.InstanceDeleted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err = server.GetEventChanResult(serverCh, ssntp.DNSRecordsUpdated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.InstanceUUID != instances[0].ID {
+		t.Fatal("Did not get correct Instance ID")
+	}
+}
+
 func TestStopInstance(t *testing.T) {
 	var reason payloads.StartFailureReason
 
@@ -499,6 +557,69 @@ func TestEvacuateNode(t *testing.T) {
 	}
 }
 
+func TestPrewarmWorkload(t *testing.T) {
+	client, err := testutil.NewSsntpTestClientConnection("PrewarmWorkload", ssntp.AGENT, testutil.AgentUUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Shutdown()
+
+	sendStatsCmd(client, t)
+
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wls, err := ctl.ds.GetWorkloads(tenant.ID)
+	if err != nil || len(wls) == 0 {
+		t.Fatal("No test workload")
+	}
+
+	// only container images are cached on the nodes
+	_, err = ctl.PrewarmWorkload(tenant.ID, wls[0].ID, []string{client.UUID})
+	if err != types.ErrBadRequest {
+		t.Fatalf("Expected ErrBadRequest, got %v", err)
+	}
+
+	wl := types.Workload{
+		ID:          uuid.Generate().String(),
+		TenantID:    tenant.ID,
+		Description: "testDockerWorkload",
+		VMType:      payloads.Docker,
+		ImageName:   testutil.DockerImage,
+	}
+	err = ctl.ds.AddWorkload(wl)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ctl.PrewarmWorkload(tenant.ID, wl.ID, []string{"unknown-node"})
+	if err != types.ErrNodeNotFound {
+		t.Fatalf("Expected ErrNodeNotFound, got %v", err)
+	}
+
+	serverCh := server.AddCmdChan(ssntp.PrewarmImage)
+
+	resp, err := ctl.PrewarmWorkload(tenant.ID, wl.ID, []string{client.UUID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.Image != testutil.DockerImage || len(resp.Nodes) != 1 {
+		t.Fatalf("Unexpected response %+v", resp)
+	}
+
+	result, err := server.GetCmdChanResult(serverCh, ssntp.PrewarmImage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.NodeUUID != client.UUID {
+		t.Fatal("Did not get node ID")
+	}
+}
+
 func TestAttachVolume(t *testing.T) {
 	client, err := testutil.NewSsntpTestClientConnection("AttachVolume", ssntp.AGENT, testutil.AgentUUID)
 	if err != nil {
@@ -510,7 +631,7 @@ func TestAttachVolume(t *testing.T) {
 
 	// ok to not send workload first?
 
-	err = ctl.client.attachVolume("volID", "instanceID", client.UUID)
+	err = ctl.client.attachVolume("volID", "instanceID", client.UUID, payloads.IOLimits{}, false)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -782,7 +903,109 @@ func TestDetachVolumeByAttachment(t *testing.T) {
 
 	err = ctl.DetachVolume(tenant.ID, "invalidVolume", "attachmentID")
 	if err == nil {
-		t.Fatal("Detach by attachment ID not supported yet")
+		t.Fatal("Detach of unknown attachment should fail")
+	}
+}
+
+func TestAttachMultiAttachVolume(t *testing.T) {
+	var reason payloads.StartFailureReason
+
+	client, instances := testStartWorkload(t, 2, false, reason)
+	defer client.Ssntp.Close()
+
+	tenantID := instances[0].TenantID
+
+	sendStatsCmd(client, t)
+
+	vol, err := ctl.CreateVolume(tenantID, block.RequestedVolume{Size: 1, MultiAttach: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !vol.MultiAttach {
+		t.Fatal("volume not flagged as multiattach")
+	}
+
+	// attach the volume to both instances.
+	for _, i := range instances {
+		serverCh := server.AddCmdChan(ssntp.AttachVolume)
+
+		err = ctl.AttachVolume(tenantID, vol.ID, i.ID, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		result, err := server.GetCmdChanResult(serverCh, ssntp.AttachVolume)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if result.InstanceUUID != i.ID || result.VolumeUUID != vol.ID {
+			t.Fatalf("expected %s %s, got %s %s", i.ID, vol.ID, result.InstanceUUID, result.VolumeUUID)
+		}
+	}
+
+	// the volume cannot be attached twice to the same instance.
+	err = ctl.AttachVolume(tenantID, vol.ID, instances[0].ID, "")
+	if err != block.ErrVolumeNotAvailable {
+		t.Fatal("Incorrect error")
+	}
+
+	attachments, err := ctl.ds.GetVolumeAttachments(vol.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(attachments) != 2 {
+		t.Fatalf("expected 2 attachments, got %d", len(attachments))
+	}
+
+	for _, a := range attachments {
+		if !a.ReadOnly {
+			t.Fatalf("attachment %s is not read-only", a.ID)
+		}
+	}
+
+	// detaching a single attachment should leave the volume in use.
+	serverCh := server.AddCmdChan(ssntp.DetachVolume)
+
+	err = ctl.DetachVolume(tenantID, vol.ID, attachments[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := server.GetCmdChanResult(serverCh, ssntp.DetachVolume)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.InstanceUUID != attachments[0].InstanceID || result.VolumeUUID != vol.ID {
+		t.Fatalf("expected %s %s, got %s %s", attachments[0].InstanceID, vol.ID, result.InstanceUUID, result.VolumeUUID)
+	}
+
+	data, err := ctl.ds.GetBlockDevice(vol.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if data.State != types.InUse {
+		t.Fatalf("expected state %s, got %s\n", types.InUse, data.State)
+	}
+}
+
+func TestAttachVolumeInUse(t *testing.T) {
+	client, tenantID, volume := doAttachVolumeCommand(t, false)
+	defer client.Ssntp.Close()
+
+	instances, err := ctl.ds.GetAllInstancesFromTenant(tenantID)
+	if err != nil || len(instances) == 0 {
+		t.Fatal("no instances found")
+	}
+
+	// a regular volume cannot be attached while in use.
+	err = ctl.AttachVolume(tenantID, volume, instances[0].ID, "")
+	if err != block.ErrVolumeNotAvailable {
+		t.Fatal("Incorrect error")
 	}
 }
 
@@ -1315,7 +1538,7 @@ func TestStorageConfig(t *testing.T) {
 	id := uuid.Generate()
 
 	noVolumes := []storage.BlockDevice{}
-	_, err = newConfig(ctl, &wls[0], id.String(), tenant.ID, noVolumes)
+	_, err = newConfig(ctl, &wls[0], id.String(), tenant.ID, noVolumes, nil, nil, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -1389,6 +1612,73 @@ func TestCreateImageVolume(t *testing.T) {
 	}
 }
 
+func TestCreateEncryptedVolume(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	volumeType := "encrypted"
+	volumeTypes[volumeType] = payloads.ConfigureVolumeType{
+		Name:      volumeType,
+		Encrypted: true,
+	}
+	defer delete(volumeTypes, volumeType)
+
+	req := block.RequestedVolume{
+		Size:       20,
+		VolumeType: &volumeType,
+	}
+
+	vol, err := ctl.CreateVolume(tenant.ID, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !vol.Encrypted {
+		t.Fatalf("volume should be reported as encrypted")
+	}
+
+	bd, err := ctl.ds.GetBlockDevice(vol.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bd.EncryptionKey == "" {
+		t.Fatalf("no encryption key stored for volume")
+	}
+
+	// a copy of the volume must be readable with the same key.
+	req = block.RequestedVolume{
+		SourceVolID: &vol.ID,
+	}
+
+	cp, err := ctl.CreateVolume(tenant.ID, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cpbd, err := ctl.ds.GetBlockDevice(cp.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !cp.Encrypted || cpbd.EncryptionKey != bd.EncryptionKey {
+		t.Fatalf("copied volume should share the source volume key")
+	}
+
+	imageRef := "test-image-id"
+	req = block.RequestedVolume{
+		ImageRef:   &imageRef,
+		VolumeType: &volumeType,
+	}
+
+	_, err = ctl.CreateVolume(tenant.ID, req)
+	if err != block.ErrEncryptionNotSupported {
+		t.Fatalf("expected %v, got %v", block.ErrEncryptionNotSupported, err)
+	}
+}
+
 func TestDeleteVolume(t *testing.T) {
 	tenant, err := addTestTenant()
 	if err != nil {
@@ -1430,25 +1720,88 @@ func TestDeleteVolume(t *testing.T) {
 	}
 }
 
-func TestListVolumes(t *testing.T) {
+func TestUpdateVolume(t *testing.T) {
 	tenant, err := addTestTenant()
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	_ = createTestVolume(tenant.ID, 20, t)
+	volID := createTestVolume(tenant.ID, 20, t)
 
-	vols, err := ctl.ListVolumes(tenant.ID)
+	name := "throttled"
+	req := block.RequestedVolumeUpdate{
+		Name: &name,
+		MetaData: map[string]interface{}{
+			"total_bytes_sec": "10485760",
+			"total_iops_sec":  "500",
+		},
+	}
+
+	// attempt to update with bad tenant ID
+	_, err = ctl.UpdateVolume("badID", volID, req)
+	if err == nil {
+		t.Fatal("Expected error updating volume of other tenant")
+	}
+
+	// attempt to set a negative limit
+	_, err = ctl.UpdateVolume(tenant.ID, volID, block.RequestedVolumeUpdate{
+		MetaData: map[string]interface{}{"read_iops_sec": "-1"},
+	})
+	if err != block.ErrInvalidIOLimits {
+		t.Fatal("Incorrect error")
+	}
+
+	invalid := []map[string]interface{}{
+		{"read_iops_sec": 1.5},
+		{"total_bytes_sec": "1048576", "write_bytes_sec": "1048576"},
+		{"total_iops_sec": 100.0, "read_iops_sec": 50.0},
+	}
+	for _, meta := range invalid {
+		_, err = ctl.UpdateVolume(tenant.ID, volID, block.RequestedVolumeUpdate{MetaData: meta})
+		if err != block.ErrInvalidIOLimits {
+			t.Fatalf("invalid limits %v accepted: %v", meta, err)
+		}
+	}
+
+	// this should work
+	vol, err := ctl.UpdateVolume(tenant.ID, volID, req)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	if len(vols) != 1 {
-		t.Fatal("Incorrect number of volumes returned")
+	if vol.Name == nil || *vol.Name != name {
+		t.Fatal("volume name not updated")
+	}
+
+	// confirm that the limits were stored in the datastore.
+	bd, err := ctl.ds.GetBlockDevice(volID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bd.IOLimits.TotalBytesSec != 10485760 || bd.IOLimits.TotalIOPSSec != 500 {
+		t.Fatalf("incorrect I/O limits stored: %+v\n", bd.IOLimits)
 	}
 }
 
-func TestShowVolumeDetails(t *testing.T) {
+func waitForBackupState(backupID string, state types.BackupState, t *testing.T) {
+	for i := 0; i < 50; i++ {
+		b, err := ctl.ds.GetVolumeBackup(backupID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if b.State == state {
+			return
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	t.Fatalf("backup %s did not reach state %s", backupID, state)
+}
+
+func TestVolumeBackupRestore(t *testing.T) {
 	tenant, err := addTestTenant()
 	if err != nil {
 		t.Fatal(err)
@@ -1456,532 +1809,2187 @@ func TestShowVolumeDetails(t *testing.T) {
 
 	volID := createTestVolume(tenant.ID, 20, t)
 
-	vol, err := ctl.ShowVolumeDetails(tenant.ID, volID)
+	data, err := ctl.ds.GetBlockDevice(volID)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	if vol.ID != volID {
-		t.Fatal("wrong volume retrieved")
+	data.IOLimits = payloads.IOLimits{ReadIOPSSec: 200, WriteIOPSSec: 100}
+	data.MultiAttach = true
+	err = ctl.ds.UpdateBlockDevice(data)
+	if err != nil {
+		t.Fatal(err)
 	}
-}
 
-func TestListVolumesDetail(t *testing.T) {
-	tenant, err := addTestTenant()
+	name := "backup001"
+	req := block.RequestedBackup{
+		VolumeID: volID,
+		Name:     &name,
+	}
+
+	_, err = ctl.CreateBackup("badID", req)
+	if err != block.ErrVolumeOwner {
+		t.Fatalf("expected %v, got %v", block.ErrVolumeOwner, err)
+	}
+
+	backup, err := ctl.CreateBackup(tenant.ID, req)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	_ = createTestVolume(tenant.ID, 20, t)
+	waitForBackupState(backup.ID, types.BackupAvailable, t)
 
-	vols, err := ctl.ListVolumesDetail(tenant.ID)
+	detail, err := ctl.ShowBackupDetails(tenant.ID, backup.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	if len(vols) != 1 {
-		t.Fatal("Incorrect number of volumes returned")
+	if detail.VolumeID != volID || detail.Size != 20 ||
+		detail.Status != block.BackupAvailable ||
+		detail.Name == nil || *detail.Name != name {
+		t.Fatalf("incorrect backup details returned: %+v", detail)
 	}
-}
 
-func testAddPool(t *testing.T, name string, subnet *string, ips []string) {
-	pool, err := ctl.AddPool(name, subnet, ips)
+	backups, err := ctl.ListBackups(tenant.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	if pool.ID == "" {
-		t.Fatal("id not set")
+	if len(backups) != 1 || backups[0].ID != backup.ID {
+		t.Fatalf("expected backup %s, got %v", backup.ID, backups)
 	}
 
-	expected := types.Pool{
-		ID:   pool.ID,
-		Name: name,
+	_, err = ctl.RestoreBackup(tenant.ID, backup.ID,
+		block.RequestedRestore{VolumeID: &volID})
+	if err != block.ErrRestoreToVolume {
+		t.Fatalf("expected %v, got %v", block.ErrRestoreToVolume, err)
 	}
 
-	if subnet != nil {
-		if pool.Subnets[0].ID == "" {
-			t.Fatal("subnet id not created")
-		}
+	volName := "restored"
+	restore, err := ctl.RestoreBackup(tenant.ID, backup.ID,
+		block.RequestedRestore{Name: &volName})
+	if err != nil {
+		t.Fatal(err)
+	}
 
-		sub := types.ExternalSubnet{
-			ID:   pool.Subnets[0].ID,
-			CIDR: *subnet,
-		}
+	if restore.BackupID != backup.ID || restore.VolumeID == volID {
+		t.Fatalf("incorrect restore returned: %+v", restore)
+	}
 
-		expected.Subnets = []types.ExternalSubnet{sub}
+	waitForBackupState(backup.ID, types.BackupAvailable, t)
 
-		_, ipNet, err := net.ParseCIDR(*subnet)
-		if err != nil {
-			t.Fatal(err)
-		}
+	vol, err := ctl.ds.GetBlockDevice(restore.VolumeID)
+	if err != nil {
+		t.Fatal(err)
+	}
 
-		ones, bits := ipNet.Mask.Size()
-		expected.TotalIPs = (1 << uint32(bits-ones)) - 2
-		expected.Free = expected.TotalIPs
-	} else if len(ips) > 0 {
-		// not an easy way to check this, so we're going to
-		// do some manual tests
-		if pool.TotalIPs != len(ips) ||
-			pool.Free != len(ips) ||
-			len(pool.IPs) != len(ips) {
-			t.Fatal("External IPs not handled correctly")
-		}
-		return
+	if vol.State != types.Available || vol.Size != 20 || vol.Name != volName ||
+		vol.IOLimits != data.IOLimits || !vol.MultiAttach {
+		t.Fatalf("incorrect restored volume: %+v", vol)
 	}
 
-	if reflect.DeepEqual(expected, pool) == false {
-		t.Fatalf("expected %v, got %v\n", expected, pool)
+	err = ctl.DeleteBackup(tenant.ID, backup.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ctl.ShowBackupDetails(tenant.ID, backup.ID)
+	if err != block.ErrBackupNotFound {
+		t.Fatalf("expected %v, got %v", block.ErrBackupNotFound, err)
 	}
 }
 
-func deletePool(name string) error {
-	pools, err := ctl.ListPools()
+func TestCleanStaleBackups(t *testing.T) {
+	tenant, err := addTestTenant()
 	if err != nil {
-		return err
+		t.Fatal(err)
 	}
 
-	if len(pools) < 1 {
-		return types.ErrPoolNotFound
+	volID := createTestVolume(tenant.ID, 20, t)
+
+	creating := types.VolumeBackup{
+		ID:         uuid.Generate().String(),
+		TenantID:   tenant.ID,
+		VolumeID:   volID,
+		State:      types.BackupCreating,
+		CreateTime: time.Now(),
+		Size:       20,
 	}
 
-	for _, pool := range pools {
-		if pool.Name == name {
-			return ctl.DeletePool(pool.ID)
-		}
+	err = ctl.CreateBlockDeviceSnapshot(volID, creating.ID)
+	if err != nil {
+		t.Fatal(err)
 	}
 
-	return types.ErrPoolNotFound
-}
+	err = ctl.ds.AddVolumeBackup(creating)
+	if err != nil {
+		t.Fatal(err)
+	}
 
-func TestAddPoolWithSubnet(t *testing.T) {
-	subnet := "192.168.0.0/16"
-	testAddPool(t, "test1", &subnet, []string{})
-	deletePool("test1")
-}
+	restoring := creating
+	restoring.ID = uuid.Generate().String()
+	restoring.State = types.BackupRestoring
+	err = ctl.ds.AddVolumeBackup(restoring)
+	if err != nil {
+		t.Fatal(err)
+	}
 
-func TestAddPoolWithIPs(t *testing.T) {
-	ips := []string{"10.10.0.1", "10.10.0.2"}
-	testAddPool(t, "test2", nil, ips)
-	deletePool("test2")
-}
+	restored := types.BlockData{
+		BlockDevice: storage.BlockDevice{
+			ID:   uuid.Generate().String(),
+			Size: 20,
+		},
+		CreateTime: time.Now(),
+		TenantID:   tenant.ID,
+		State:      types.RestoringBackup,
+	}
 
-func TestAddPool(t *testing.T) {
-	testAddPool(t, "test3", nil, []string{})
-	deletePool("test3")
-}
+	err = ctl.ds.AddBlockDevice(restored)
+	if err != nil {
+		t.Fatal(err)
+	}
 
-func TestListPools(t *testing.T) {
-	testAddPool(t, "listPoolTest", nil, []string{})
+	ctl.cleanStaleBackups()
 
-	pools, err := ctl.ListPools()
+	b, err := ctl.ds.GetVolumeBackup(creating.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	if len(pools) < 1 {
-		t.Fatal("Unable to retrieve pools")
+	if b.State != types.BackupError || b.FailReason == "" {
+		t.Fatalf("interrupted backup not marked as failed: %+v", b)
 	}
 
-	for _, pool := range pools {
-		if pool.Name == "listPoolTest" {
-			err := ctl.DeletePool(pool.ID)
-			if err != nil {
-				t.Fatal(err)
-			}
-			return
-		}
+	b, err = ctl.ds.GetVolumeBackup(restoring.ID)
+	if err != nil {
+		t.Fatal(err)
 	}
 
-	t.Fatal("Could not list pools")
+	if b.State != types.BackupAvailable {
+		t.Fatalf("interrupted restore left backup in state %s", b.State)
+	}
+
+	_, err = ctl.ds.GetBlockDevice(restored.ID)
+	if err == nil {
+		t.Fatal("volume of interrupted restore not removed")
+	}
+
+	// The snapshot of the interrupted backup has been released so the
+	// volume can now be deleted.
+	err = ctl.DeleteVolume(tenant.ID, volID)
+	if err != nil {
+		t.Fatal(err)
+	}
 }
 
-func TestShowPool(t *testing.T) {
-	testAddPool(t, "showPoolTest", nil, []string{})
+func TestBackupInUseVolume(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
 
-	pools, err := ctl.ListPools()
+	volID := createTestVolume(tenant.ID, 20, t)
+
+	data, err := ctl.ds.GetBlockDevice(volID)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	if len(pools) < 1 {
-		t.Fatal("Unable to retrieve pools")
+	data.State = types.InUse
+	err = ctl.ds.UpdateBlockDevice(data)
+	if err != nil {
+		t.Fatal(err)
 	}
 
-	for _, pool := range pools {
-		if pool.Name == "showPoolTest" {
-			_, err := ctl.ShowPool(pool.ID)
-			if err != nil {
-				t.Fatal(err)
-			}
+	req := block.RequestedBackup{
+		VolumeID: volID,
+	}
 
-			err = ctl.DeletePool(pool.ID)
-			if err != nil {
-				t.Fatal(err)
+	_, err = ctl.CreateBackup(tenant.ID, req)
+	if err != block.ErrVolumeNotAvailable {
+		t.Fatalf("expected %v, got %v", block.ErrVolumeNotAvailable, err)
+	}
+
+	req.Force = true
+	backup, err := ctl.CreateBackup(tenant.ID, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waitForBackupState(backup.ID, types.BackupAvailable, t)
+}
+
+// reconcileTestDriver is a block driver that reports a fixed set of
+// backend volumes and node mappings and records what it is asked to
+// clean up.
+type reconcileTestDriver struct {
+	storage.NoopDriver
+	devices  []string
+	mappings map[string][]string
+	deleted  []string
+	unmapped []string
+}
+
+func (d *reconcileTestDriver) ListBlockDevices() ([]string, error) {
+	return d.devices, nil
+}
+
+func (d *reconcileTestDriver) GetVolumeMapping() (map[string][]string, error) {
+	return d.mappings, nil
+}
+
+func (d *reconcileTestDriver) DeleteBlockDevice(volumeUUID string) error {
+	d.deleted = append(d.deleted, volumeUUID)
+	return nil
+}
+
+func (d *reconcileTestDriver) UnmapVolumeFromNode(device string) error {
+	d.unmapped = append(d.unmapped, device)
+	for id, devices := range d.mappings {
+		for _, dev := range devices {
+			if dev == device {
+				delete(d.mappings, id)
 			}
+		}
+	}
+	return nil
+}
 
-			return
+// reconcileTestImages is an image metadata store holding a single public
+// image.
+type reconcileTestImages struct {
+	imageDatastore.Noop
+	imageID string
+}
+
+func (m *reconcileTestImages) GetAll(tenant string) ([]imageDatastore.Image, error) {
+	if tenant != "public" {
+		return []imageDatastore.Image{}, nil
+	}
+
+	return []imageDatastore.Image{{ID: m.imageID, TenantID: tenant}}, nil
+}
+
+func findVolumeIssue(r types.VolumeReconciliation, issueType types.VolumeIssueType, volumeID string) *types.VolumeIssue {
+	for i := range r.Issues {
+		if r.Issues[i].Type == issueType && r.Issues[i].VolumeID == volumeID {
+			return &r.Issues[i]
 		}
 	}
 
-	t.Fatal("Could not show pool")
+	return nil
 }
 
-func TestDeletePool(t *testing.T) {
-	testAddPool(t, "deletePoolTest", nil, []string{})
+func TestReconcileVolumes(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
 
-	pools, err := ctl.ListPools()
+	present := createTestVolume(tenant.ID, 1, t)
+	missing := createTestVolume(tenant.ID, 1, t)
+	inUse := createTestVolume(tenant.ID, 1, t)
+	stale := createTestVolume(tenant.ID, 1, t)
+
+	bd, err := ctl.ds.GetBlockDevice(inUse)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bd.State = types.InUse
+	err = ctl.ds.UpdateBlockDevice(bd)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	if len(pools) < 1 {
-		t.Fatal("Unable to retrieve pools")
+	a, err := ctl.ds.CreateStorageAttachment(uuid.Generate().String(),
+		payloads.StorageResource{ID: stale})
+	if err != nil {
+		t.Fatal(err)
 	}
 
-	for _, pool := range pools {
-		if pool.Name == "deletePoolTest" {
-			err := ctl.DeletePool(pool.ID)
-			if err != nil {
-				t.Fatal(err)
-			}
+	orphan := uuid.Generate().String()
+	imageID := uuid.Generate().String()
 
-			_, err = ctl.ShowPool(pool.ID)
-			if err != types.ErrPoolNotFound {
-				t.Fatal("Pool not deleted")
-			}
-			return
-		}
+	driver := &reconcileTestDriver{
+		devices:  []string{present, inUse, stale, orphan, imageID, "not-ciao"},
+		mappings: map[string][]string{inUse: {"/dev/rbd0"}},
 	}
 
-	t.Fatal("Could not delete pool")
-}
+	oldDriver := ctl.BlockDriver
+	ctl.BlockDriver = driver
+	defer func() { ctl.BlockDriver = oldDriver }()
 
-func TestAddPoolSubnet(t *testing.T) {
-	subnet := "192.168.0.0/24"
+	is := &ImageService{ds: &imageDatastore.ImageStore{}}
+	_ = is.ds.Init(ctl.backupStore, &reconcileTestImages{imageID: imageID})
+	ctl.imageServiceLock.Lock()
+	ctl.imageService = is
+	ctl.imageServiceLock.Unlock()
 
-	testAddPool(t, "addsubnet", nil, []string{})
+	ctl.reconciler.cleanup = true
+	staleAttachmentAge = 0
 
-	pools, err := ctl.ListPools()
+	defer func() {
+		ctl.imageServiceLock.Lock()
+		ctl.imageService = nil
+		ctl.imageServiceLock.Unlock()
+		ctl.reconciler.cleanup = false
+		staleAttachmentAge = 15 * time.Minute
+	}()
+
+	r, err := ctl.reconcileVolumes()
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	if len(pools) < 1 {
-		t.Fatal("Unable to retrieve pools")
+	if findVolumeIssue(r, types.MissingVolume, missing) == nil {
+		t.Errorf("missing volume %s not reported", missing)
 	}
 
-	for _, pool := range pools {
-		if pool.Name == "addsubnet" {
-			err := ctl.AddAddress(pool.ID, &subnet, []string{})
-			if err != nil {
-				t.Fatal(err)
-			}
+	if findVolumeIssue(r, types.MissingVolume, present) != nil {
+		t.Errorf("volume %s wrongly reported as missing", present)
+	}
 
-			p1, err := ctl.ShowPool(pool.ID)
-			if err != nil {
-				t.Fatal(err)
-			}
+	issue := findVolumeIssue(r, types.StaleAttachment, stale)
+	if issue == nil || !issue.Cleaned || issue.AttachmentID != a.ID {
+		t.Errorf("stale attachment %s not cleaned up: %+v", a.ID, issue)
+	}
 
-			// we should have a our subnet.
-			if p1.Subnets[0].CIDR != subnet {
-				t.Fatalf("expectd %s subnet got %s", subnet, p1.Subnets[0].CIDR)
-			}
+	issue = findVolumeIssue(r, types.VolumeStateMismatch, inUse)
+	if issue == nil || !issue.Cleaned {
+		t.Errorf("state of volume %s not fixed: %+v", inUse, issue)
+	}
 
-			err = ctl.DeletePool(pool.ID)
-			if err != nil {
-				t.Fatal(err)
-			}
-			return
-		}
+	issue = findVolumeIssue(r, types.StaleMapping, inUse)
+	if issue == nil || !issue.Cleaned || issue.Device != "/dev/rbd0" {
+		t.Errorf("stale mapping of volume %s not cleaned up: %+v", inUse, issue)
+	}
+
+	// orphans are only reported once they have been seen twice.
+	if findVolumeIssue(r, types.OrphanedVolume, orphan) != nil {
+		t.Errorf("orphan %s reported on first run", orphan)
+	}
+
+	bd, err = ctl.ds.GetBlockDevice(inUse)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bd.State != types.Available {
+		t.Errorf("expected volume %s to be %s, got %s", inUse, types.Available, bd.State)
+	}
+
+	_, err = ctl.reconcileVolumes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r = ctl.ShowVolumeReconciliation()
+	issue = findVolumeIssue(r, types.OrphanedVolume, orphan)
+	if issue == nil || !issue.Cleaned {
+		t.Errorf("orphan %s not cleaned up: %+v", orphan, issue)
+	}
+
+	if len(driver.deleted) != 1 || driver.deleted[0] != orphan {
+		t.Errorf("expected only %s to be deleted, got %v", orphan, driver.deleted)
 	}
 
+	if len(driver.unmapped) != 1 || driver.unmapped[0] != "/dev/rbd0" {
+		t.Errorf("expected /dev/rbd0 to be unmapped, got %v", driver.unmapped)
+	}
 }
 
-func TestAddPoolAddress(t *testing.T) {
-	address := "192.168.1.1"
+func TestReconcileNewAttachment(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
 
-	testAddPool(t, "addaddress", nil, []string{})
+	volID := createTestVolume(tenant.ID, 1, t)
 
-	pools, err := ctl.ListPools()
+	a, err := ctl.ds.CreateStorageAttachment(uuid.Generate().String(),
+		payloads.StorageResource{ID: volID})
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	if len(pools) < 1 {
-		t.Fatal("Unable to retrieve pools")
+	defer func() { _ = ctl.ds.DeleteStorageAttachment(a.ID) }()
+
+	driver := &reconcileTestDriver{
+		devices: []string{volID},
 	}
 
-	for _, pool := range pools {
-		if pool.Name == "addaddress" {
-			err := ctl.AddAddress(pool.ID, nil, []string{address})
-			if err != nil {
-				t.Fatal(err)
-			}
+	oldDriver := ctl.BlockDriver
+	ctl.BlockDriver = driver
+	defer func() { ctl.BlockDriver = oldDriver }()
 
-			p1, err := ctl.ShowPool(pool.ID)
-			if err != nil {
-				t.Fatal(err)
-			}
+	ctl.reconciler.cleanup = true
+	defer func() { ctl.reconciler.cleanup = false }()
 
-			// we should have a our subnet.
-			if p1.IPs[0].Address != address {
-				t.Fatalf("expected %s address got %s", address, p1.IPs[0].Address)
-			}
+	r, err := ctl.reconcileVolumes()
+	if err != nil {
+		t.Fatal(err)
+	}
 
-			err = ctl.DeletePool(pool.ID)
-			if err != nil {
-				t.Fatal(err)
+	if findVolumeIssue(r, types.StaleAttachment, volID) != nil {
+		t.Errorf("attachment %s reported before reaching the minimum age", a.ID)
+	}
+
+	attachments, err := ctl.ds.GetVolumeAttachments(volID)
+	if err != nil || len(attachments) != 1 {
+		t.Errorf("attachment %s removed before reaching the minimum age: %v", a.ID, err)
+	}
+}
+
+func TestReconcileNotSupported(t *testing.T) {
+	oldDriver := ctl.BlockDriver
+	ctl.BlockDriver = &storage.NoopDriver{}
+	defer func() { ctl.BlockDriver = oldDriver }()
+
+	_, err := ctl.reconcileVolumes()
+	if err != storage.ErrNotSupported {
+		t.Fatalf("expected %v, got %v", storage.ErrNotSupported, err)
+	}
+}
+
+type lifecycleTestImages struct {
+	imageDatastore.Noop
+	images map[string]imageDatastore.Image
+}
+
+func (m *lifecycleTestImages) Write(i imageDatastore.Image) error {
+	m.images[i.TenantID+"/"+i.ID] = i
+	return nil
+}
+
+func (m *lifecycleTestImages) Delete(tenant, ID string) error {
+	delete(m.images, tenant+"/"+ID)
+	return nil
+}
+
+func (m *lifecycleTestImages) Get(tenant, ID string) (imageDatastore.Image, error) {
+	return m.images[tenant+"/"+ID], nil
+}
+
+// startTestImageService makes the controller use an image service whose
+// metadata is held in memory.
+func startTestImageService() (*ImageService, *lifecycleTestImages) {
+	is := &ImageService{
+		ds:    &imageDatastore.ImageStore{},
+		qs:    ctl.qs,
+		inUse: ctl.imageInUse,
+	}
+	metaDs := &lifecycleTestImages{images: make(map[string]imageDatastore.Image)}
+	_ = is.ds.Init(ctl.backupStore, metaDs)
+
+	ctl.imageServiceLock.Lock()
+	ctl.imageService = is
+	ctl.imageServiceLock.Unlock()
+
+	return is, metaDs
+}
+
+func stopTestImageService() {
+	ctl.imageServiceLock.Lock()
+	ctl.imageService = nil
+	ctl.imageServiceLock.Unlock()
+}
+
+// Checks the lifecycle of images used by volumes.
+//
+// An active image is deactivated, then reactivated, and a volume is
+// created from it each time.  The image is deleted while the volume
+// exists, then protected and deleted again once the volume is deleted.
+//
+// No volume should be created from the deactivated image and the image
+// should only be deleted once it is neither in use nor protected.
+func TestImageLifecycle(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	imageID := uuid.Generate().String()
+	err = ctl.backupStore.Write(imageID, strings.NewReader("image data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	is, metaDs := startTestImageService()
+	defer stopTestImageService()
+
+	_ = metaDs.Write(imageDatastore.Image{
+		ID:       imageID,
+		TenantID: tenant.ID,
+		State:    imageDatastore.Active,
+	})
+
+	err = is.DeactivateImage(tenant.ID, imageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := block.RequestedVolume{ImageRef: &imageID}
+	_, err = ctl.CreateVolume(tenant.ID, req)
+	if err != block.ErrImageNotAvailable {
+		t.Fatalf("Expected %v creating volume from deactivated image, got %v",
+			block.ErrImageNotAvailable, err)
+	}
+
+	err = is.ReactivateImage(tenant.ID, imageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = is.ReactivateImage(tenant.ID, imageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vol, err := ctl.CreateVolume(tenant.ID, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bd, err := ctl.ds.GetBlockDevice(vol.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bd.ImageID != imageID {
+		t.Fatalf("Volume cloned from %q, expected %q", bd.ImageID, imageID)
+	}
+
+	_, err = is.DeleteImage(tenant.ID, imageID)
+	if err != image.ErrImageInUse {
+		t.Fatalf("Expected %v deleting image in use, got %v", image.ErrImageInUse, err)
+	}
+
+	err = ctl.DeleteVolume(tenant.ID, vol.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	protected := true
+	resp, err := is.UpdateImage(tenant.ID, imageID, image.UpdateImageRequest{Protected: &protected})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !resp.Protected {
+		t.Fatal("Image not protected")
+	}
+
+	_, err = is.DeleteImage(tenant.ID, imageID)
+	if err != image.ErrImageProtected {
+		t.Fatalf("Expected %v deleting protected image, got %v", image.ErrImageProtected, err)
+	}
+
+	protected = false
+	_, err = is.UpdateImage(tenant.ID, imageID, image.UpdateImageRequest{Protected: &protected})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = is.DeleteImage(tenant.ID, imageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Checks that only signed images can be booted when required.
+//
+// Volumes are created from an unsigned image, an image whose signature
+// was verified and an image whose data changed after its signature was
+// verified, while signed images are required.
+//
+// Only the volume created from the signed image should be created.
+func TestSignedImagePolicy(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, metaDs := startTestImageService()
+	defer stopTestImageService()
+
+	requireSignedImages = true
+	defer func() { requireSignedImages = false }()
+
+	const sum = "3d2a4b27bc2b3fd2ed5ab2b9bcbf4b6bb4cbd0c7fd0d6ae02b0e0cb27d2f8bd2"
+
+	tests := []struct {
+		signedSHA256 string
+		sha256       string
+		err          error
+	}{
+		{"", sum, block.ErrImageNotAvailable},
+		{sum, sum, nil},
+		{sum, strings.Repeat("0", len(sum)), block.ErrImageNotAvailable},
+	}
+
+	for _, tt := range tests {
+		imageID := uuid.Generate().String()
+		_ = metaDs.Write(imageDatastore.Image{
+			ID:           imageID,
+			TenantID:     tenant.ID,
+			State:        imageDatastore.Active,
+			SHA256:       tt.sha256,
+			SignedSHA256: tt.signedSHA256,
+		})
+
+		_, err = ctl.CreateVolume(tenant.ID, block.RequestedVolume{ImageRef: &imageID})
+		if err != tt.err {
+			t.Errorf("Signed digest %q, digest %q: expected %v, got %v",
+				tt.signedSHA256, tt.sha256, tt.err, err)
+		}
+	}
+
+	err = ctl.checkImageBootable(tenant.ID, uuid.Generate().String())
+	if err != image.ErrImageNotSigned {
+		t.Errorf("Expected %v for unknown image, got %v", image.ErrImageNotSigned, err)
+	}
+}
+
+// stagingTestDriver is a block driver that keeps the data of the volumes
+// created from files.
+type stagingTestDriver struct {
+	storage.NoopDriver
+	volumes   map[string]string
+	snapshots map[string]bool
+}
+
+func (d *stagingTestDriver) CreateBlockDevice(volumeUUID string, imagePath string, size int) (storage.BlockDevice, error) {
+	data, err := ioutil.ReadFile(imagePath)
+	if err != nil {
+		return storage.BlockDevice{}, err
+	}
+
+	d.volumes[volumeUUID] = string(data)
+	return storage.BlockDevice{ID: volumeUUID}, nil
+}
+
+func (d *stagingTestDriver) CreateBlockDeviceSnapshot(volumeUUID string, snapshotID string) error {
+	d.snapshots[volumeUUID+"@"+snapshotID] = true
+	return nil
+}
+
+func (d *stagingTestDriver) DeleteBlockDeviceSnapshot(volumeUUID string, snapshotID string) error {
+	delete(d.snapshots, volumeUUID+"@"+snapshotID)
+	return nil
+}
+
+func (d *stagingTestDriver) DeleteBlockDevice(volumeUUID string) error {
+	delete(d.volumes, volumeUUID)
+	return nil
+}
+
+func (d *stagingTestDriver) GetBlockDeviceSize(volumeUUID string) (uint64, error) {
+	data, ok := d.volumes[volumeUUID]
+	if !ok {
+		return 0, errors.New("no such volume")
+	}
+
+	return uint64(len(data)), nil
+}
+
+func TestBootFromS3Image(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldStore, oldPath := *imageStore, *imagesPath
+	*imageStore = "s3"
+	*imagesPath = os.TempDir()
+	defer func() { *imageStore, *imagesPath = oldStore, oldPath }()
+
+	driver := &stagingTestDriver{
+		volumes:   make(map[string]string),
+		snapshots: make(map[string]bool),
+	}
+	oldDriver := ctl.BlockDriver
+	ctl.BlockDriver = driver
+	defer func() { ctl.BlockDriver = oldDriver }()
+
+	imageID := uuid.Generate().String()
+	err = ctl.backupStore.Write(imageID, strings.NewReader("image data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	is, metaDs := startTestImageService()
+	defer stopTestImageService()
+	is.deleted = ctl.unstageImage
+
+	_ = metaDs.Write(imageDatastore.Image{
+		ID:       imageID,
+		TenantID: tenant.ID,
+		State:    imageDatastore.Active,
+		Size:     uint64(len("image data")),
+	})
+
+	s := types.StorageResource{
+		Bootable:   true,
+		SourceType: types.ImageService,
+		SourceID:   imageID,
+	}
+
+	instanceID := uuid.Generate().String()
+	_, err = getStorage(ctl, s, tenant.ID, instanceID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if driver.volumes[imageID] != "image data" || !driver.snapshots[imageID+"@ciao-image"] {
+		t.Fatalf("image %s not copied to the storage backend: %v %v",
+			imageID, driver.volumes, driver.snapshots)
+	}
+
+	// The copy is only made once.
+	driver.volumes[imageID] = "copied"
+	_, err = ctl.CreateVolume(tenant.ID, block.RequestedVolume{ImageRef: &imageID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if driver.volumes[imageID] != "copied" {
+		t.Fatalf("image %s copied to the storage backend twice", imageID)
+	}
+
+	for _, bd := range ctl.ds.GetAllBlockDevices() {
+		if bd.ImageID == imageID {
+			_ = ctl.ds.DeleteBlockDevice(bd.ID)
+		}
+	}
+
+	_, err = is.DeleteImage(tenant.ID, imageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := driver.volumes[imageID]; ok || driver.snapshots[imageID+"@ciao-image"] {
+		t.Fatalf("copy of deleted image %s not removed", imageID)
+	}
+
+	_, err = ctl.CreateVolume(tenant.ID, block.RequestedVolume{ImageRef: &imageID})
+	if err != block.ErrImageNotAvailable {
+		t.Fatalf("expected %v, got %v", block.ErrImageNotAvailable, err)
+	}
+}
+
+func TestCreateImageDiskFormat(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	is, _ := startTestImageService()
+	defer stopTestImageService()
+
+	_, err = is.CreateImage(tenant.ID, image.CreateImageRequest{DiskFormat: "floppy"})
+	if err != image.ErrBadDiskFormat {
+		t.Fatalf("expected %v, got %v", image.ErrBadDiskFormat, err)
+	}
+
+	resp, err := is.CreateImage(tenant.ID, image.CreateImageRequest{DiskFormat: image.QCow})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.DiskFormat != image.QCow {
+		t.Fatalf("expected disk format %s, got %s", image.QCow, resp.DiskFormat)
+	}
+}
+
+func TestListVolumes(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_ = createTestVolume(tenant.ID, 20, t)
+
+	vols, err := ctl.ListVolumes(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(vols) != 1 {
+		t.Fatal("Incorrect number of volumes returned")
+	}
+}
+
+func TestShowVolumeDetails(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	volID := createTestVolume(tenant.ID, 20, t)
+
+	vol, err := ctl.ShowVolumeDetails(tenant.ID, volID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if vol.ID != volID {
+		t.Fatal("wrong volume retrieved")
+	}
+}
+
+func TestListVolumesDetail(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_ = createTestVolume(tenant.ID, 20, t)
+
+	vols, err := ctl.ListVolumesDetail(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(vols) != 1 {
+		t.Fatal("Incorrect number of volumes returned")
+	}
+}
+
+func testAddPool(t *testing.T, name string, subnet *string, ips []string) {
+	pool, err := ctl.AddPool(name, subnet, ips)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pool.ID == "" {
+		t.Fatal("id not set")
+	}
+
+	expected := types.Pool{
+		ID:   pool.ID,
+		Name: name,
+	}
+
+	if subnet != nil {
+		if pool.Subnets[0].ID == "" {
+			t.Fatal("subnet id not created")
+		}
+
+		sub := types.ExternalSubnet{
+			ID:   pool.Subnets[0].ID,
+			CIDR: *subnet,
+		}
+
+		expected.Subnets = []types.ExternalSubnet{sub}
+
+		_, ipNet, err := net.ParseCIDR(*subnet)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ones, bits := ipNet.Mask.Size()
+		expected.TotalIPs = (1 << uint32(bits-ones)) - 2
+		expected.Free = expected.TotalIPs
+	} else if len(ips) > 0 {
+		// not an easy way to check this, so we're going to
+		// do some manual tests
+		if pool.TotalIPs != len(ips) ||
+			pool.Free != len(ips) ||
+			len(pool.IPs) != len(ips) {
+			t.Fatal("External IPs not handled correctly")
+		}
+		return
+	}
+
+	if reflect.DeepEqual(expected, pool) == false {
+		t.Fatalf("expected %v, got %v\n", expected, pool)
+	}
+}
+
+func deletePool(name string) error {
+	pools, err := ctl.ListPools()
+	if err != nil {
+		return err
+	}
+
+	if len(pools) < 1 {
+		return types.ErrPoolNotFound
+	}
+
+	for _, pool := range pools {
+		if pool.Name == name {
+			return ctl.DeletePool(pool.ID)
+		}
+	}
+
+	return types.ErrPoolNotFound
+}
+
+func TestAddPoolWithSubnet(t *testing.T) {
+	subnet := "192.168.0.0/16"
+	testAddPool(t, "test1", &subnet, []string{})
+	deletePool("test1")
+}
+
+func TestAddPoolWithIPs(t *testing.T) {
+	ips := []string{"10.10.0.1", "10.10.0.2"}
+	testAddPool(t, "test2", nil, ips)
+	deletePool("test2")
+}
+
+func TestAddPool(t *testing.T) {
+	testAddPool(t, "test3", nil, []string{})
+	deletePool("test3")
+}
+
+func TestListPools(t *testing.T) {
+	testAddPool(t, "listPoolTest", nil, []string{})
+
+	pools, err := ctl.ListPools()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(pools) < 1 {
+		t.Fatal("Unable to retrieve pools")
+	}
+
+	for _, pool := range pools {
+		if pool.Name == "listPoolTest" {
+			err := ctl.DeletePool(pool.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return
+		}
+	}
+
+	t.Fatal("Could not list pools")
+}
+
+func TestShowPool(t *testing.T) {
+	testAddPool(t, "showPoolTest", nil, []string{})
+
+	pools, err := ctl.ListPools()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(pools) < 1 {
+		t.Fatal("Unable to retrieve pools")
+	}
+
+	for _, pool := range pools {
+		if pool.Name == "showPoolTest" {
+			_, err := ctl.ShowPool(pool.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = ctl.DeletePool(pool.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			return
+		}
+	}
+
+	t.Fatal("Could not show pool")
+}
+
+func TestDeletePool(t *testing.T) {
+	testAddPool(t, "deletePoolTest", nil, []string{})
+
+	pools, err := ctl.ListPools()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(pools) < 1 {
+		t.Fatal("Unable to retrieve pools")
+	}
+
+	for _, pool := range pools {
+		if pool.Name == "deletePoolTest" {
+			err := ctl.DeletePool(pool.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = ctl.ShowPool(pool.ID)
+			if err != types.ErrPoolNotFound {
+				t.Fatal("Pool not deleted")
+			}
+			return
+		}
+	}
+
+	t.Fatal("Could not delete pool")
+}
+
+func TestAddPoolSubnet(t *testing.T) {
+	subnet := "192.168.0.0/24"
+
+	testAddPool(t, "addsubnet", nil, []string{})
+
+	pools, err := ctl.ListPools()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(pools) < 1 {
+		t.Fatal("Unable to retrieve pools")
+	}
+
+	for _, pool := range pools {
+		if pool.Name == "addsubnet" {
+			err := ctl.AddAddress(pool.ID, &subnet, []string{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			p1, err := ctl.ShowPool(pool.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// we should have a our subnet.
+			if p1.Subnets[0].CIDR != subnet {
+				t.Fatalf("expectd %s subnet got %s", subnet, p1.Subnets[0].CIDR)
+			}
+
+			err = ctl.DeletePool(pool.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return
+		}
+	}
+
+}
+
+func TestAddPoolAddress(t *testing.T) {
+	address := "192.168.1.1"
+
+	testAddPool(t, "addaddress", nil, []string{})
+
+	pools, err := ctl.ListPools()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(pools) < 1 {
+		t.Fatal("Unable to retrieve pools")
+	}
+
+	for _, pool := range pools {
+		if pool.Name == "addaddress" {
+			err := ctl.AddAddress(pool.ID, nil, []string{address})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			p1, err := ctl.ShowPool(pool.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// we should have a our subnet.
+			if p1.IPs[0].Address != address {
+				t.Fatalf("expected %s address got %s", address, p1.IPs[0].Address)
+			}
+
+			err = ctl.DeletePool(pool.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return
+		}
+	}
+
+}
+
+func TestRemovePoolSubnet(t *testing.T) {
+	subnet := "192.168.0.0/24"
+	address := "192.168.1.1"
+
+	testAddPool(t, "addsubnet", &subnet, []string{})
+
+	pools, err := ctl.ListPools()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var pool types.Pool
+
+	for _, pool = range pools {
+		if pool.Name == "addsubnet" {
+			// make sure the subnet is there.
+			for _, sub := range pool.Subnets {
+				if sub.CIDR == subnet {
+					err := ctl.RemoveAddress(pool.ID, &sub.ID, nil)
+					if err != nil {
+						t.Fatalf("%s: %v\n", err, pool.Subnets)
+					}
+				}
+			}
+		}
+		break
+	}
+
+	p1, err := ctl.ShowPool(pool.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(p1.Subnets) != 0 || p1.TotalIPs != 0 || p1.Free != 0 {
+		fmt.Printf("pool %v\n", p1)
+		t.Fatal("subnet not deleted")
+	}
+
+	err = ctl.AddAddress(pool.ID, nil, []string{address})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p1, err = ctl.ShowPool(pool.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ctl.RemoveAddress(pool.ID, nil, &p1.IPs[0].ID)
+	if err != nil {
+		t.Fatalf("%s: %v\n", err, pool.IPs)
+	}
+
+	err = ctl.RemoveAddress(pool.ID, nil, nil)
+	if err != types.ErrBadRequest {
+		t.Fatal("invalid remove address request allowed")
+	}
+}
+
+func TestMapAddress(t *testing.T) {
+	var reason payloads.StartFailureReason
+
+	client, instances := testStartWorkload(t, 1, false, reason)
+	defer client.Shutdown()
+
+	ips := []string{"10.10.0.1"}
+	poolName := "testmap"
+
+	testAddPool(t, poolName, nil, ips)
+
+	pools, err := ctl.ListPools()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(pools) < 1 {
+		t.Fatal("Unable to retrieve pools")
+	}
+
+	for _, pool := range pools {
+		if pool.Name == "testmap" {
+			if pool.Free != 1 {
+				t.Fatal("Pool Free not correct")
+			}
+		}
+	}
+
+	err = ctl.MapAddress(&poolName, instances[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pools, err = ctl.ListPools()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(pools) < 1 {
+		t.Fatal("Unable to retrieve pools")
+	}
+
+	for _, pool := range pools {
+		if pool.Name == "testmap" {
+			if pool.Free != 0 {
+				fmt.Printf("%v", pool)
+				t.Fatal("Pool Free not decremented")
+			}
+		}
+	}
+}
+
+func TestMapAddressIPv6(t *testing.T) {
+	var reason payloads.StartFailureReason
+
+	client, instances := testStartWorkload(t, 1, false, reason)
+
+	poolName := "testmapipv6"
+	testAddPool(t, poolName, nil, []string{"2001:db8::10"})
+
+	// the instance was created without an IPv6 address
+	err := ctl.MapAddress(&poolName, instances[0].ID)
+	client.Shutdown()
+	if err != types.ErrPoolEmpty {
+		t.Fatalf("Expected %v, got %v", types.ErrPoolEmpty, err)
+	}
+
+	_, tenantIPv6Prefix, _ = net.ParseCIDR("fd00:1:2::/48")
+	defer func() { tenantIPv6Prefix = nil }()
+
+	client, instances = testStartWorkload(t, 1, false, reason)
+	defer client.Shutdown()
+
+	if instances[0].IPv6Address == "" {
+		t.Fatal("Instance has no IPv6 address")
+	}
+
+	err = ctl.MapAddress(&poolName, instances[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mappedIPs := ctl.ListMappedAddresses(&instances[0].TenantID)
+	for _, m := range mappedIPs {
+		if m.ExternalIP == "2001:db8::10" {
+			if m.InternalIP != instances[0].IPv6Address {
+				t.Fatalf("Expected %s, got %s", instances[0].IPv6Address, m.InternalIP)
 			}
 			return
 		}
 	}
+	t.Fatal("mapped IP not in list")
+}
+
+func TestAddPoolIPv6SubnetTooLarge(t *testing.T) {
+	subnet := "2001:db8::/64"
+
+	_, err := ctl.AddPool("testipv6large", &subnet, nil)
+	if err != types.ErrPoolSubnetTooLarge {
+		t.Fatalf("Expected %v, got %v", types.ErrPoolSubnetTooLarge, err)
+	}
+}
+
+func TestMapAddressNoPool(t *testing.T) {
+	var reason payloads.StartFailureReason
+
+	client, instances := testStartWorkload(t, 1, false, reason)
+	defer client.Shutdown()
+
+	ips := []string{"10.10.0.2"}
+	poolName := "testmapnopool"
+
+	testAddPool(t, poolName, nil, ips)
+
+	err := ctl.MapAddress(nil, instances[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pools, err := ctl.ListPools()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(pools) < 1 {
+		t.Fatal("Unable to retrieve pools")
+	}
+
+	for _, pool := range pools {
+		if pool.Name == "testmapnopool" {
+			if pool.Free != 0 {
+				fmt.Printf("%v", pool)
+				t.Fatal("Pool Free not decremented")
+			}
+		}
+	}
+
+	mappedIPs := ctl.ListMappedAddresses(&instances[0].TenantID)
+	if len(mappedIPs) != 1 {
+		t.Fatal("mapped IP not in list")
+	}
+}
+
+func TestPortForward(t *testing.T) {
+	var reason payloads.StartFailureReason
+
+	client, instances := testStartWorkload(t, 2, false, reason)
+	defer client.Shutdown()
+
+	poolName := "testforward"
+	testAddPool(t, poolName, nil, []string{"10.10.0.3"})
+
+	req := types.PortForwardRequest{
+		PoolName:     &poolName,
+		ExternalPort: 8022,
+		Protocol:     "sctp",
+		InstanceID:   instances[0].ID,
+		InternalPort: 22,
+	}
+
+	_, err := ctl.AddPortForward(nil, req)
+	if err != types.ErrInvalidPortForward {
+		t.Fatalf("Expected %v, got %v", types.ErrInvalidPortForward, err)
+	}
+
+	req.Protocol = "TCP"
+
+	_, err = ctl.AddPortForward(&testutil.ComputeUser, req)
+	if err != types.ErrInstanceNotFound {
+		t.Fatalf("port forwarded to another tenant: %v", err)
+	}
+
+	serverCh := server.AddEventChan(ssntp.PortForwardsUpdated)
+
+	f, err := ctl.AddPortForward(nil, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := server.GetEventChanResult(serverCh, ssntp.PortForwardsUpdated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.TenantUUID != instances[0].TenantID {
+		t.Fatal("Did not get correct Tenant ID")
+	}
+
+	if f.ExternalIP != "10.10.0.3" || f.Protocol != "tcp" || f.InternalIP != instances[0].IPAddress {
+		t.Fatalf("Unexpected port forward %v", f)
+	}
+
+	// the pool is now empty, but the external IP can be shared
+	req.InstanceID = instances[1].ID
+	_, err = ctl.AddPortForward(nil, req)
+	if err != types.ErrPoolEmpty {
+		t.Fatalf("Expected %v, got %v", types.ErrPoolEmpty, err)
+	}
+
+	req.ExternalIP = f.ExternalIP
+	_, err = ctl.AddPortForward(nil, req)
+	if err != types.ErrPortForwarded {
+		t.Fatalf("Expected %v, got %v", types.ErrPortForwarded, err)
+	}
+
+	req.ExternalPort = 9022
+	serverCh = server.AddEventChan(ssntp.PortForwardsUpdated)
+
+	shared, err := ctl.AddPortForward(&instances[1].TenantID, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = server.GetEventChanResult(serverCh, ssntp.PortForwardsUpdated)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	forwards := ctl.ListPortForwards(&instances[0].TenantID)
+	if len(forwards) != 2 {
+		t.Fatalf("Expected 2 port forwards, got %d", len(forwards))
+	}
+
+	sendStatsCmd(client, t)
+
+	err = ctl.deleteInstance(instances[1].ID)
+	if err != types.ErrInstanceMapped {
+		t.Fatalf("Expected %v, got %v", types.ErrInstanceMapped, err)
+	}
+
+	err = ctl.DeletePortForward(&testutil.ComputeUser, shared.ID)
+	if err != types.ErrPortForwardNotFound {
+		t.Fatalf("port forward deleted by another tenant: %v", err)
+	}
+
+	for _, ID := range []string{shared.ID, f.ID} {
+		err = ctl.DeletePortForward(nil, ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pools, err := ctl.ListPools()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, pool := range pools {
+		if pool.Name == poolName && pool.Free != 1 {
+			t.Fatal("Pool Free not incremented")
+		}
+	}
+}
+
+var testClients []*testutil.SsntpTestClient
+var ctl *controller
+var server *testutil.SsntpTestServer
+var wrappedClient *ssntpClientWrapper
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+
+	// create fake ssntp server
+	server = testutil.StartTestServer()
+
+	ctl = new(controller)
+	ctl.tenantReadiness = make(map[string]*tenantConfirmMemo)
+	ctl.ds = new(datastore.Datastore)
+	ctl.qs = new(quotas.Quotas)
+
+	ctl.BlockDriver = func() storage.BlockDriver {
+		return &storage.NoopDriver{}
+	}()
+
+	dir, err := ioutil.TempDir("", "controller_test")
+	if err != nil {
+		os.Exit(1)
+	}
+	fakeImage := fmt.Sprintf("%s/73a86d7e-93c0-480e-9c41-ab42f69b7799", dir)
+
+	ctl.backupStore = &imageDatastore.Posix{MountPoint: dir}
+
+	f, err := os.Create(fakeImage)
+	if err != nil {
+		os.RemoveAll(dir)
+		os.Exit(1)
+	}
+
+	dsConfig := datastore.Config{
+		PersistentURI:     "file:memdb1?mode=memory&cache=shared",
+		TransientURI:      "file:memdb2?mode=memory&cache=shared",
+		InitWorkloadsPath: *workloadsPath,
+	}
+
+	err = ctl.ds.Init(dsConfig)
+	if err != nil {
+		f.Close()
+		os.RemoveAll(dir)
+		os.Exit(1)
+	}
+	ctl.qs.Init()
+
+	config := &ssntp.Config{
+		URI:    "localhost",
+		CAcert: ssntp.DefaultCACert,
+		Cert:   ssntp.RoleToDefaultCertName(ssntp.Controller),
+	}
+
+	wrappedClient, err = newWrappedSSNTPClient(ctl, config)
+	if err != nil {
+		os.Exit(1)
+	}
+	ctl.client = wrappedClient
+
+	testIdentityConfig := testutil.IdentityConfig{
+		ComputeURL: testutil.ComputeURL,
+		ProjectID:  testutil.ComputeUser,
+	}
+
+	id := testutil.StartIdentityServer(testIdentityConfig)
+
+	idConfig := identityConfig{
+		endpoint:        id.URL,
+		serviceUserName: "test",
+		servicePassword: "iheartciao",
+	}
+
+	ctl.id, err = newIdentityClient(idConfig)
+	if err != nil {
+		fmt.Println(err)
+		// keep going anyway - any compute api tests will fail.
+	}
+
+	_, _ = addComputeTestTenant()
+	go ctl.startComputeService()
+	time.Sleep(1 * time.Second)
+	go ctl.startVolumeService()
+	time.Sleep(1 * time.Second)
+
+	code := m.Run()
+
+	ctl.client.Disconnect()
+	ctl.ds.Exit()
+	ctl.qs.Shutdown()
+	id.Close()
+	server.Shutdown()
+	f.Close()
+	os.RemoveAll(dir)
+
+	os.Exit(code)
+}
+
+func TestSecurityGroups(t *testing.T) {
+	var reason payloads.StartFailureReason
+
+	client, instances := testStartWorkload(t, 1, false, reason)
+	defer client.Shutdown()
+
+	tenantID := instances[0].TenantID
+	instanceID := instances[0].ID
+
+	req := types.NewSecurityGroupRequest{
+		Name: "web",
+		Rules: []types.SecurityGroupRule{
+			{Protocol: "TCP", PortMin: 80},
+		},
+	}
+
+	sg, err := ctl.CreateSecurityGroup(tenantID, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sg.Rules) != 1 || sg.Rules[0].ID == "" ||
+		sg.Rules[0].Protocol != "tcp" || sg.Rules[0].PortMax != 80 {
+		t.Fatalf("rule not canonicalised: %v", sg.Rules)
+	}
+
+	_, err = ctl.ShowSecurityGroup("invalid-tenant", sg.ID)
+	if err != types.ErrSecurityGroupNotFound {
+		t.Fatalf("security group visible to another tenant: %v", err)
+	}
+
+	serverCh := server.AddCmdChan(ssntp.ApplySecurityRules)
+
+	err = ctl.AddSecurityGroupInstance(tenantID, sg.ID, instanceID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := server.GetCmdChanResult(serverCh, ssntp.ApplySecurityRules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.InstanceUUID != instanceID {
+		t.Fatalf("rules applied to %s, expected %s", result.InstanceUUID, instanceID)
+	}
+
+	i, err := ctl.ds.GetInstance(instanceID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.NodeUUID != i.NodeID {
+		t.Fatalf("rules sent to node %s, expected %s", result.NodeUUID, i.NodeID)
+	}
+
+	// the instance is not known to run on another node yet
+	stats := payloads.Stat{
+		NodeUUID:  "moved-node",
+		Instances: []payloads.InstanceStat{{InstanceUUID: instanceID}},
+	}
+	arrived := ctl.securityGroupArrivals(stats)
+	if len(arrived) != 1 || arrived[0] != instanceID {
+		t.Fatalf("instance started on a new node not detected: %v", arrived)
+	}
+
+	stats.NodeUUID = i.NodeID
+	if arrived := ctl.securityGroupArrivals(stats); len(arrived) != 0 {
+		t.Fatalf("instance already filtered by its node: %v", arrived)
+	}
+
+	invalid := []types.SecurityGroupRule{
+		{Protocol: "sctp"},
+		{Protocol: "icmp", PortMin: 22},
+		{Protocol: "tcp", PortMin: 100, PortMax: 10},
+		{Protocol: "udp", PortMin: 70000},
+		{Protocol: "tcp", PortMin: 22, RemoteCIDR: "not-a-cidr"},
+	}
+	for _, r := range invalid {
+		_, err = ctl.AddSecurityGroupRule(tenantID, sg.ID, r)
+		if err != types.ErrInvalidSecurityRule {
+			t.Errorf("invalid rule %v accepted", r)
+		}
+	}
+
+	serverCh = server.AddCmdChan(ssntp.ApplySecurityRules)
+
+	rule, err := ctl.AddSecurityGroupRule(tenantID, sg.ID,
+		types.SecurityGroupRule{Protocol: "icmp", RemoteCIDR: "10.0.0.1/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rule.RemoteCIDR != "10.0.0.0/8" {
+		t.Errorf("remote CIDR not canonicalised: %s", rule.RemoteCIDR)
+	}
+
+	_, err = server.GetCmdChanResult(serverCh, ssntp.ApplySecurityRules)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serverCh = server.AddCmdChan(ssntp.ApplySecurityRules)
+
+	rule, err = ctl.AddSecurityGroupRule(tenantID, sg.ID,
+		types.SecurityGroupRule{Protocol: "tcp", PortMin: 22, RemoteCIDR: "fd00:1::5/48"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rule.RemoteCIDR != "fd00:1::/48" {
+		t.Errorf("remote CIDR not canonicalised: %s", rule.RemoteCIDR)
+	}
+
+	_, err = server.GetCmdChanResult(serverCh, ssntp.ApplySecurityRules)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ctl.DeleteSecurityGroup(tenantID, sg.ID)
+	if err != types.ErrSecurityGroupInUse {
+		t.Fatalf("security group in use deleted: %v", err)
+	}
+
+	serverCh = server.AddCmdChan(ssntp.RemoveSecurityRules)
+
+	err = ctl.RemoveSecurityGroupInstance(tenantID, sg.ID, instanceID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err = server.GetCmdChanResult(serverCh, ssntp.RemoveSecurityRules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.InstanceUUID != instanceID {
+		t.Fatalf("rules removed from %s, expected %s", result.InstanceUUID, instanceID)
+	}
+
+	err = ctl.DeleteSecurityGroup(tenantID, sg.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ctl.ShowSecurityGroup(tenantID, sg.ID)
+	if err != types.ErrSecurityGroupNotFound {
+		t.Fatal("security group not deleted")
+	}
+}
+
+func TestNetworks(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	invalid := []types.NewSubnetRequest{
+		{CIDR: "not-a-cidr"},
+		{CIDR: "fd00::/64"},
+		{CIDR: "10.0.0.0/8"},
+		{CIDR: "10.0.0.0/30"},
+		{CIDR: "10.0.0.0/24", GatewayIP: "10.0.1.1"},
+		{CIDR: "10.0.0.0/24", GatewayIP: "10.0.0.0"},
+		{CIDR: "10.0.0.0/24", GatewayIP: "10.0.0.255"},
+	}
+	for _, r := range invalid {
+		_, err = ctl.CreateNetwork(tenant.ID, types.NewNetworkRequest{
+			Name:    "invalid",
+			Subnets: []types.NewSubnetRequest{r},
+		})
+		if err != types.ErrInvalidSubnet {
+			t.Errorf("invalid subnet %v accepted: %v", r, err)
+		}
+	}
+
+	n, err := ctl.CreateNetwork(tenant.ID, types.NewNetworkRequest{
+		Name: "private",
+		Subnets: []types.NewSubnetRequest{
+			{CIDR: "10.10.0.3/29", GatewayIP: "10.10.0.6"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(n.Subnets) != 1 || n.Subnets[0].CIDR != "10.10.0.0/29" ||
+		n.Subnets[0].GatewayIP != "10.10.0.6" {
+		t.Fatalf("subnet not canonicalised: %v", n.Subnets)
+	}
+
+	_, err = ctl.ShowNetwork("invalid-tenant", n.ID)
+	if err != types.ErrNetworkNotFound {
+		t.Fatalf("network visible to another tenant: %v", err)
+	}
+
+	_, err = ctl.AddNetworkSubnet(tenant.ID, n.ID, types.NewSubnetRequest{CIDR: "10.10.0.0/24"})
+	if err != types.ErrSubnetOverlap {
+		t.Fatalf("overlapping subnet accepted: %v", err)
+	}
+
+	ip, err := ctl.ds.AllocateTenantIP(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ctl.AddNetworkSubnet(tenant.ID, n.ID, types.NewSubnetRequest{CIDR: "172.16.0.0/16"})
+	if err != types.ErrSubnetOverlap {
+		t.Fatalf("subnet overlapping allocated subnet accepted: %v", err)
+	}
+
+	err = ctl.ds.ReleaseTenantIP(tenant.ID, ip.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wls, err := ctl.ds.GetWorkloads(tenant.ID)
+	if err != nil || len(wls) == 0 {
+		t.Fatal(err)
+	}
+
+	noVolumes := []storage.BlockDevice{}
+	var ips []string
+	for i := 0; i < 5; i++ {
+		config, err := newConfig(ctl, &wls[0], uuid.Generate().String(), tenant.ID, noVolumes, []string{n.ID}, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		networking := config.sc.Start.Networking
+		if networking.Subnet != "10.10.0.0/29" || networking.Gateway != "10.10.0.6" ||
+			networking.NetworkUUID != n.ID || networking.SubnetV6 != "" {
+			t.Fatalf("wrong networking for tenant network: %v", networking)
+		}
+		if config.subnetID != n.Subnets[0].ID {
+			t.Fatalf("wrong subnet ID %s", config.subnetID)
+		}
+		ips = append(ips, config.ip)
+	}
+
+	expected := []string{"10.10.0.1", "10.10.0.2", "10.10.0.3", "10.10.0.4", "10.10.0.5"}
+	if !reflect.DeepEqual(ips, expected) {
+		t.Fatalf("wrong addresses allocated: %v", ips)
+	}
+
+	_, err = newConfig(ctl, &wls[0], uuid.Generate().String(), tenant.ID, noVolumes, []string{n.ID}, nil, nil)
+	if err != types.ErrSubnetFull {
+		t.Fatalf("address allocated from full subnet: %v", err)
+	}
+
+	err = ctl.DeleteNetwork(tenant.ID, n.ID)
+	if err != types.ErrNetworkInUse {
+		t.Fatalf("network with addresses deleted: %v", err)
+	}
+
+	for _, ip := range ips {
+		err = ctl.ds.ReleaseSubnetIP(n.Subnets[0].ID, ip)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = ctl.DeleteNetwork(tenant.ID, n.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ctl.ShowNetwork(tenant.ID, n.ID)
+	if err != types.ErrNetworkNotFound {
+		t.Fatalf("network not deleted: %v", err)
+	}
+}
+
+func TestSubnetDHCPOptions(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
 
-}
+	invalid := []types.SubnetDHCPOptions{
+		{DNSNameservers: []string{"fd00::53"}},
+		{NTPServers: []string{"ntp.example.com"}},
+		{DNSSearchDomains: []string{"example.com,ciao"}},
+		{HostRoutes: []types.HostRoute{{Destination: "10.1.0.0/16", NextHop: "10.50.1.1"}}},
+		{HostRoutes: []types.HostRoute{{Destination: "fd00::/64", NextHop: "10.50.0.1"}}},
+	}
+	for _, o := range invalid {
+		_, err = ctl.CreateNetwork(tenant.ID, types.NewNetworkRequest{
+			Name:    "invalid",
+			Subnets: []types.NewSubnetRequest{{CIDR: "10.50.0.0/24", SubnetDHCPOptions: o}},
+		})
+		if err != types.ErrInvalidDHCPOptions {
+			t.Errorf("invalid DHCP options %v accepted: %v", o, err)
+		}
+	}
 
-func TestRemovePoolSubnet(t *testing.T) {
-	subnet := "192.168.0.0/24"
-	address := "192.168.1.1"
+	serverCh := server.AddEventChan(ssntp.DHCPOptionsUpdated)
 
-	testAddPool(t, "addsubnet", &subnet, []string{})
+	n, err := ctl.CreateNetwork(tenant.ID, types.NewNetworkRequest{
+		Name: "private",
+		Subnets: []types.NewSubnetRequest{
+			{
+				CIDR: "10.50.0.0/24",
+				SubnetDHCPOptions: types.SubnetDHCPOptions{
+					DNSNameservers: []string{"10.50.0.53"},
+					HostRoutes: []types.HostRoute{
+						{Destination: "10.1.2.3/16", NextHop: "10.50.0.254"},
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
 
-	pools, err := ctl.ListPools()
+	result, err := server.GetEventChanResult(serverCh, ssntp.DHCPOptionsUpdated)
 	if err != nil {
 		t.Fatal(err)
 	}
+	if result.TenantUUID != tenant.ID {
+		t.Fatal("Did not get correct Tenant ID")
+	}
 
-	var pool types.Pool
+	routes := n.Subnets[0].HostRoutes
+	if len(routes) != 1 || routes[0].Destination != "10.1.0.0/16" {
+		t.Fatalf("route not canonicalised: %v", routes)
+	}
 
-	for _, pool = range pools {
-		if pool.Name == "addsubnet" {
-			// make sure the subnet is there.
-			for _, sub := range pool.Subnets {
-				if sub.CIDR == subnet {
-					err := ctl.RemoveAddress(pool.ID, &sub.ID, nil)
-					if err != nil {
-						t.Fatalf("%s: %v\n", err, pool.Subnets)
-					}
-				}
-			}
-		}
-		break
+	options := types.SubnetDHCPOptions{
+		DNSSearchDomains: []string{"example.com"},
+		NTPServers:       []string{"10.50.0.123"},
 	}
 
-	p1, err := ctl.ShowPool(pool.ID)
-	if err != nil {
-		t.Fatal(err)
+	_, err = ctl.UpdateNetworkSubnet("invalid-tenant", n.ID, n.Subnets[0].ID, options)
+	if err != types.ErrNetworkNotFound {
+		t.Fatalf("subnet updated by another tenant: %v", err)
 	}
 
-	if len(p1.Subnets) != 0 || p1.TotalIPs != 0 || p1.Free != 0 {
-		fmt.Printf("pool %v\n", p1)
-		t.Fatal("subnet not deleted")
+	_, err = ctl.UpdateNetworkSubnet(tenant.ID, n.ID, uuid.Generate().String(), options)
+	if err != types.ErrSubnetNotFound {
+		t.Fatalf("unknown subnet updated: %v", err)
 	}
 
-	err = ctl.AddAddress(pool.ID, nil, []string{address})
+	serverCh = server.AddEventChan(ssntp.DHCPOptionsUpdated)
+
+	sn, err := ctl.UpdateNetworkSubnet(tenant.ID, n.ID, n.Subnets[0].ID, options)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	p1, err = ctl.ShowPool(pool.ID)
+	_, err = server.GetEventChanResult(serverCh, ssntp.DHCPOptionsUpdated)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	err = ctl.RemoveAddress(pool.ID, nil, &p1.IPs[0].ID)
+	if !reflect.DeepEqual(sn.SubnetDHCPOptions, options) || sn.CIDR != "10.50.0.0/24" {
+		t.Fatalf("options not updated: %v", sn)
+	}
+
+	n, err = ctl.ShowNetwork(tenant.ID, n.ID)
 	if err != nil {
-		t.Fatalf("%s: %v\n", err, pool.IPs)
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(n.Subnets[0].SubnetDHCPOptions, options) {
+		t.Fatalf("options not stored: %v", n.Subnets[0])
 	}
 
-	err = ctl.RemoveAddress(pool.ID, nil, nil)
-	if err != types.ErrBadRequest {
-		t.Fatal("invalid remove address request allowed")
+	err = ctl.DeleteNetwork(tenant.ID, n.ID)
+	if err != nil {
+		t.Fatal(err)
 	}
 }
 
-func TestMapAddress(t *testing.T) {
-	var reason payloads.StartFailureReason
+func TestNetworksSkippedByTenantIP(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
 
-	client, instances := testStartWorkload(t, 1, false, reason)
-	defer client.Shutdown()
+	n, err := ctl.CreateNetwork(tenant.ID, types.NewNetworkRequest{
+		Name: "private",
+		Subnets: []types.NewSubnetRequest{
+			{CIDR: "172.16.0.0/24"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
 
-	ips := []string{"10.10.0.1"}
-	poolName := "testmap"
+	ip, err := ctl.ds.AllocateTenantIP(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
 
-	testAddPool(t, poolName, nil, ips)
+	if ip.String() != "172.16.1.2" {
+		t.Fatalf("allocated %s from a subnet of network %s", ip, n.ID)
+	}
 
-	pools, err := ctl.ListPools()
+	err = ctl.ds.ReleaseTenantIP(tenant.ID, ip.String())
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	if len(pools) < 1 {
-		t.Fatal("Unable to retrieve pools")
+	err = ctl.DeleteNetwork(tenant.ID, n.ID)
+	if err != nil {
+		t.Fatal(err)
 	}
+}
 
-	for _, pool := range pools {
-		if pool.Name == "testmap" {
-			if pool.Free != 1 {
-				t.Fatal("Pool Free not correct")
-			}
+func TestMultipleNICs(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var networks []types.TenantNetwork
+	for _, cidr := range []string{"10.20.0.0/29", "10.30.0.0/29", "10.40.0.0/29"} {
+		n, err := ctl.CreateNetwork(tenant.ID, types.NewNetworkRequest{
+			Name:    cidr,
+			Subnets: []types.NewSubnetRequest{{CIDR: cidr}},
+		})
+		if err != nil {
+			t.Fatal(err)
 		}
+		networks = append(networks, n)
 	}
+	a, b, c := networks[0], networks[1], networks[2]
 
-	err = ctl.MapAddress(&poolName, instances[0].ID)
-	if err != nil {
+	wls, err := ctl.ds.GetWorkloads(tenant.ID)
+	if err != nil || len(wls) == 0 {
 		t.Fatal(err)
 	}
 
-	pools, err = ctl.ListPools()
+	noVolumes := []storage.BlockDevice{}
+	_, err = newInstance(ctl, tenant.ID, &wls[0], noVolumes, []string{a.ID, a.ID}, nil, nil)
+	if err != types.ErrBadRequest {
+		t.Fatalf("instance attached twice to the same network: %v", err)
+	}
+
+	i, err := newInstance(ctl, tenant.ID, &wls[0], noVolumes, []string{a.ID, b.ID}, nil, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	if len(pools) < 1 {
-		t.Fatal("Unable to retrieve pools")
+	if i.IPAddress != "10.20.0.2" || i.SubnetID != a.Subnets[0].ID {
+		t.Fatalf("wrong primary NIC %s on subnet %s", i.IPAddress, i.SubnetID)
 	}
 
-	for _, pool := range pools {
-		if pool.Name == "testmap" {
-			if pool.Free != 0 {
-				fmt.Printf("%v", pool)
-				t.Fatal("Pool Free not decremented")
-			}
+	if len(i.ExtraNICs) != 1 {
+		t.Fatalf("expected 1 additional NIC, got %d", len(i.ExtraNICs))
+	}
+
+	nic := i.ExtraNICs[0]
+	if nic.IPAddress != "10.30.0.2" || nic.Subnet != "10.30.0.0/29" ||
+		nic.SubnetID != b.Subnets[0].ID || nic.NetworkID != b.ID ||
+		nic.VnicUUID == i.VnicUUID || nic.MACAddress == i.MACAddress {
+		t.Fatalf("wrong additional NIC: %v", nic)
+	}
+
+	extra := i.newConfig.sc.Start.ExtraNetworking
+	if len(extra) != 1 || extra[0].PrivateIP != nic.IPAddress ||
+		extra[0].VnicMAC != nic.MACAddress || extra[0].VnicUUID != nic.VnicUUID ||
+		extra[0].Gateway != "10.30.0.1" || extra[0].NetworkUUID != b.ID ||
+		extra[0].ConcentratorUUID != i.newConfig.sc.Start.Networking.ConcentratorUUID {
+		t.Fatalf("wrong networking for additional NIC: %v", extra)
+	}
+
+	// fill up the subnet of b, no address must be left allocated
+	// on c when attaching an instance to all three networks fails
+	var ips []string
+	for {
+		ip, err := ctl.ds.AllocateSubnetIP(b.Subnets[0].ID)
+		if err == types.ErrSubnetFull {
+			break
+		} else if err != nil {
+			t.Fatal(err)
 		}
+		ips = append(ips, ip.String())
 	}
-}
 
-func TestMapAddressNoPool(t *testing.T) {
-	var reason payloads.StartFailureReason
+	_, err = newInstance(ctl, tenant.ID, &wls[0], noVolumes, []string{a.ID, c.ID, b.ID}, nil, nil)
+	if err != types.ErrSubnetFull {
+		t.Fatalf("instance attached to a full subnet: %v", err)
+	}
 
-	client, instances := testStartWorkload(t, 1, false, reason)
-	defer client.Shutdown()
+	err = ctl.DeleteNetwork(tenant.ID, c.ID)
+	if err != nil {
+		t.Fatalf("address left allocated on failure: %v", err)
+	}
 
-	ips := []string{"10.10.0.2"}
-	poolName := "testmapnopool"
+	for _, ip := range ips {
+		err = ctl.ds.ReleaseSubnetIP(b.Subnets[0].ID, ip)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
 
-	testAddPool(t, poolName, nil, ips)
+	ctl.releaseExtraNICs(i.ExtraNICs)
+	err = ctl.DeleteNetwork(tenant.ID, b.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
 
-	err := ctl.MapAddress(nil, instances[0].ID)
+	err = ctl.ds.ReleaseSubnetIP(a.Subnets[0].ID, i.IPAddress)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	pools, err := ctl.ListPools()
+	err = ctl.DeleteNetwork(tenant.ID, a.ID)
 	if err != nil {
 		t.Fatal(err)
 	}
+}
 
-	if len(pools) < 1 {
-		t.Fatal("Unable to retrieve pools")
+func TestMultipleNICsSecurityGroups(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
 	}
 
-	for _, pool := range pools {
-		if pool.Name == "testmapnopool" {
-			if pool.Free != 0 {
-				fmt.Printf("%v", pool)
-				t.Fatal("Pool Free not decremented")
-			}
+	var networks []string
+	for _, cidr := range []string{"10.50.0.0/29", "10.60.0.0/29"} {
+		n, err := ctl.CreateNetwork(tenant.ID, types.NewNetworkRequest{
+			Name:    cidr,
+			Subnets: []types.NewSubnetRequest{{CIDR: cidr}},
+		})
+		if err != nil {
+			t.Fatal(err)
 		}
+		networks = append(networks, n.ID)
 	}
 
-	mappedIPs := ctl.ListMappedAddresses(&instances[0].TenantID)
-	if len(mappedIPs) != 1 {
-		t.Fatal("mapped IP not in list")
+	wls, err := ctl.ds.GetWorkloads(tenant.ID)
+	if err != nil || len(wls) == 0 {
+		t.Fatal(err)
 	}
-}
 
-var testClients []*testutil.SsntpTestClient
-var ctl *controller
-var server *testutil.SsntpTestServer
-var wrappedClient *ssntpClientWrapper
+	i, err := newInstance(ctl, tenant.ID, &wls[0], []storage.BlockDevice{}, networks, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
 
-func TestMain(m *testing.M) {
-	flag.Parse()
+	err = i.Add()
+	if err != nil {
+		t.Fatal(err)
+	}
 
-	// create fake ssntp server
-	server = testutil.StartTestServer()
+	records := dnsRecords([]types.Instance{i.Instance})
+	if len(records) != 1 || records[0].PrivateIP != "10.50.0.2" ||
+		records[0].MACAddress != i.MACAddress ||
+		len(records[0].ExtraPrivateIPs) != 1 || records[0].ExtraPrivateIPs[0] != "10.60.0.2" ||
+		len(records[0].ExtraMACAddresses) != 1 || records[0].ExtraMACAddresses[0] != i.ExtraNICs[0].MACAddress {
+		t.Fatalf("wrong DNS records %v", records)
+	}
 
-	ctl = new(controller)
-	ctl.tenantReadiness = make(map[string]*tenantConfirmMemo)
-	ctl.ds = new(datastore.Datastore)
-	ctl.qs = new(quotas.Quotas)
+	sg, err := ctl.CreateSecurityGroup(tenant.ID, types.NewSecurityGroupRequest{Name: "nics"})
+	if err != nil {
+		t.Fatal(err)
+	}
 
-	ctl.BlockDriver = func() storage.BlockDriver {
-		return &storage.NoopDriver{}
-	}()
+	err = ctl.AddSecurityGroupInstance(tenant.ID, sg.ID, i.ID)
+	if err != types.ErrSecurityGroupNICs {
+		t.Fatalf("instance with several NICs added to a security group: %v", err)
+	}
 
-	dir, err := ioutil.TempDir("", "controller_test")
+	err = ctl.DeleteSecurityGroup(tenant.ID, sg.ID)
 	if err != nil {
-		os.Exit(1)
+		t.Fatal(err)
 	}
-	fakeImage := fmt.Sprintf("%s/73a86d7e-93c0-480e-9c41-ab42f69b7799", dir)
 
-	f, err := os.Create(fakeImage)
+	err = ctl.ds.DeleteInstance(i.ID)
 	if err != nil {
-		os.RemoveAll(dir)
-		os.Exit(1)
+		t.Fatal(err)
 	}
 
-	dsConfig := datastore.Config{
-		PersistentURI:     "file:memdb1?mode=memory&cache=shared",
-		TransientURI:      "file:memdb2?mode=memory&cache=shared",
-		InitWorkloadsPath: *workloadsPath,
+	for _, n := range networks {
+		err = ctl.DeleteNetwork(tenant.ID, n)
+		if err != nil {
+			t.Fatal(err)
+		}
 	}
+}
 
-	err = ctl.ds.Init(dsConfig)
+func TestFixedInstanceAddress(t *testing.T) {
+	tenant, err := addTestTenant()
 	if err != nil {
-		f.Close()
-		os.RemoveAll(dir)
-		os.Exit(1)
+		t.Fatal(err)
 	}
-	ctl.qs.Init()
 
-	config := &ssntp.Config{
-		URI:    "localhost",
-		CAcert: ssntp.DefaultCACert,
-		Cert:   ssntp.RoleToDefaultCertName(ssntp.Controller),
+	n, err := ctl.CreateNetwork(tenant.ID, types.NewNetworkRequest{
+		Name:    "appliances",
+		Subnets: []types.NewSubnetRequest{{CIDR: "10.50.0.0/29"}},
+	})
+	if err != nil {
+		t.Fatal(err)
 	}
 
-	wrappedClient, err = newWrappedSSNTPClient(ctl, config)
-	if err != nil {
-		os.Exit(1)
+	wls, err := ctl.ds.GetWorkloads(tenant.ID)
+	if err != nil || len(wls) == 0 {
+		t.Fatal(err)
 	}
-	ctl.client = wrappedClient
 
-	testIdentityConfig := testutil.IdentityConfig{
-		ComputeURL: testutil.ComputeURL,
-		ProjectID:  testutil.ComputeUser,
+	createServer := func(count int, networks ...compute.ServerNetwork) error {
+		var server compute.CreateServerRequest
+		server.Server.Flavor = wls[0].ID
+		server.Server.MaxInstances = count
+		server.Server.Networks = networks
+		_, err := ctl.CreateServer(tenant.ID, server)
+		return err
 	}
 
-	id := testutil.StartIdentityServer(testIdentityConfig)
+	err = createServer(1, compute.ServerNetwork{
+		UUID:       n.ID,
+		FixedIP:    "10.50.0.4",
+		MACAddress: "52:54:00:12:34:56",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
 
-	idConfig := identityConfig{
-		endpoint:        id.URL,
-		serviceUserName: "test",
-		servicePassword: "iheartciao",
+	err = createServer(1, compute.ServerNetwork{FixedIP: "172.20.1.10"})
+	if err != nil {
+		t.Fatal(err)
 	}
 
-	ctl.id, err = newIdentityClient(idConfig)
+	instances, err := ctl.ds.GetAllInstancesFromTenant(tenant.ID)
 	if err != nil {
-		fmt.Println(err)
-		// keep going anyway - any compute api tests will fail.
+		t.Fatal(err)
 	}
 
-	_, _ = addComputeTestTenant()
-	go ctl.startComputeService()
-	time.Sleep(1 * time.Second)
-	go ctl.startVolumeService()
-	time.Sleep(1 * time.Second)
+	addrs := make(map[string]string)
+	for _, i := range instances {
+		addrs[i.IPAddress] = i.MACAddress
+	}
+	expected := map[string]string{
+		"10.50.0.4":   "52:54:00:12:34:56",
+		"172.20.1.10": "02:00:ac:14:01:0a",
+	}
+	if !reflect.DeepEqual(addrs, expected) {
+		t.Fatalf("expected addresses %v, got %v", expected, addrs)
+	}
 
-	code := m.Run()
+	tests := []struct {
+		count    int
+		networks []compute.ServerNetwork
+		err      error
+	}{
+		{1, []compute.ServerNetwork{{UUID: n.ID, FixedIP: "10.50.0.4"}}, compute.ErrAddressInUse},
+		{1, []compute.ServerNetwork{{FixedIP: "172.20.1.10"}}, compute.ErrAddressInUse},
+		{1, []compute.ServerNetwork{{UUID: n.ID, FixedIP: "10.50.0.5", MACAddress: "52:54:00:12:34:56"}}, compute.ErrAddressInUse},
+		{1, []compute.ServerNetwork{{UUID: n.ID, FixedIP: "10.50.0.5", MACAddress: "02:00:0a:32:00:06"}}, compute.ErrInvalidAddress},
+		{1, []compute.ServerNetwork{{UUID: n.ID, MACAddress: "01:00:5e:00:00:01"}}, compute.ErrInvalidAddress},
+		{1, []compute.ServerNetwork{{UUID: n.ID, FixedIP: "10.60.0.5"}}, compute.ErrInvalidAddress},
+		{1, []compute.ServerNetwork{{UUID: n.ID, FixedIP: "10.50.0.7"}}, compute.ErrInvalidAddress},
+		{1, []compute.ServerNetwork{{UUID: n.ID, FixedIP: "not-an-ip"}}, compute.ErrInvalidAddress},
+		{1, []compute.ServerNetwork{{FixedIP: "10.50.0.5"}}, compute.ErrInvalidAddress},
+		{2, []compute.ServerNetwork{{UUID: n.ID, FixedIP: "10.50.0.5"}}, compute.ErrInvalidAddress},
+		{1, []compute.ServerNetwork{{UUID: n.ID}, {UUID: n.ID, FixedIP: "10.50.0.5"}}, compute.ErrInvalidAddress},
+	}
 
-	ctl.client.Disconnect()
-	ctl.ds.Exit()
-	ctl.qs.Shutdown()
-	id.Close()
-	server.Shutdown()
-	f.Close()
-	os.RemoveAll(dir)
+	for _, test := range tests {
+		err = createServer(test.count, test.networks...)
+		if err != test.err {
+			t.Errorf("expected %v creating %v, got %v", test.err, test.networks, err)
+		}
+	}
 
-	os.Exit(code)
+	// failed requests must not keep their address claimed
+	err = ctl.ds.ClaimSubnetIP(n.Subnets[0].ID, net.ParseIP("10.50.0.5"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ctl.ds.ReleaseSubnetIP(n.Subnets[0].ID, "10.50.0.5")
+	if err != nil {
+		t.Fatal(err)
+	}
 }
@@ -0,0 +1,94 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"github.com/01org/ciao/ciao-controller/types"
+	"github.com/golang/glog"
+)
+
+// instanceName returns the name an instance is resolved by within the DNS
+// domain of its tenant. It is the hostname the instance is given.
+func instanceName(i types.Instance) string {
+	return i.ID
+}
+
+// updateTenantNames sends the names of added and removed instances to
+// the CNCI of their tenant.
+func (c *controller) updateTenantNames(tenantID string, added []types.Instance, removed []types.Instance) error {
+	t, err := c.ds.GetTenant(tenantID)
+	if err != nil {
+		return err
+	}
+	if t == nil {
+		return types.ErrTenantNotFound
+	}
+
+	// the CNCI is sent all the names of the tenant when it is added
+	if t.CNCIID == "" {
+		return nil
+	}
+
+	return c.client.updateDNSRecords(*t, added, removed)
+}
+
+// publishInstanceName makes a new instance resolvable by name by the
+// other instances of its tenant.
+func (c *controller) publishInstanceName(i types.Instance) {
+	err := c.updateTenantNames(i.TenantID, []types.Instance{i}, nil)
+	if err != nil {
+		glog.Warningf("Unable to publish the name of %s: %v", i.ID, err)
+	}
+}
+
+// unpublishInstanceName removes the name of a deleted instance, its IP
+// address may be reused.
+func (c *controller) unpublishInstanceName(instanceID string) {
+	i, err := c.ds.GetInstance(instanceID)
+	if err != nil || i.CNCI {
+		return
+	}
+
+	err = c.updateTenantNames(i.TenantID, nil, []types.Instance{*i})
+	if err != nil {
+		glog.Warningf("Unable to remove the name of %s: %v", instanceID, err)
+	}
+}
+
+// publishTenantNames sends the names of all the instances of a tenant,
+// e.g., when a new CNCI joins.
+func (c *controller) publishTenantNames(tenantID string) {
+	instances, err := c.ds.GetAllInstancesFromTenant(tenantID)
+	if err != nil {
+		glog.Warningf("Unable to get the instances of tenant %s: %v", tenantID, err)
+		return
+	}
+
+	var added []types.Instance
+	for _, i := range instances {
+		if !i.CNCI && i.IPAddress != "" {
+			added = append(added, *i)
+		}
+	}
+
+	if len(added) == 0 {
+		return
+	}
+
+	err = c.updateTenantNames(tenantID, added, nil)
+	if err != nil {
+		glog.Warningf("Unable to publish the names of tenant %s: %v", tenantID, err)
+	}
+}
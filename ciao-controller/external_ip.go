@@ -17,10 +17,12 @@ package main
 import (
 	"fmt"
 	"net"
+	"strings"
 
 	"github.com/01org/ciao/ciao-controller/types"
 	"github.com/01org/ciao/payloads"
 	"github.com/01org/ciao/ssntp/uuid"
+	"github.com/golang/glog"
 )
 
 func (c *controller) makePoolLinks(pool *types.Pool) {
@@ -118,6 +120,9 @@ func (c *controller) AddPool(name string, subnet *string, ips []string) (types.P
 		}
 
 		ones, bits := ipNet.Mask.Size()
+		if bits == 8*net.IPv6len && bits-ones > 24 {
+			return pool, types.ErrPoolSubnetTooLarge
+		}
 
 		// subtract out gateway and broadcast
 		TotalIPs := (1 << uint32(bits-ones)) - 2
@@ -283,3 +288,181 @@ func (c *controller) UnMapAddress(address string) error {
 
 	return c.client.unMapExternalIP(*t, m)
 }
+
+func (c *controller) makePortForwardLinks(f *types.PortForward, tenant *string) {
+	var ref string
+
+	if tenant != nil {
+		ref = fmt.Sprintf("%s/%s/external-ips/port-forwards/%s",
+			c.apiURL, *tenant, f.ID)
+	} else {
+		ref = fmt.Sprintf("%s/external-ips/port-forwards/%s",
+			c.apiURL, f.ID)
+	}
+
+	f.Links = []types.Link{{Rel: "self", Href: ref}}
+
+	if tenant == nil {
+		poolRef := fmt.Sprintf("%s/pools/%s", c.apiURL, f.PoolID)
+		f.Links = append(f.Links, types.Link{Rel: "pool", Href: poolRef})
+	}
+}
+
+// validatePortForward checks the protocol and ports of a port forward
+// request and normalizes its protocol and external IP.
+func validatePortForward(req *types.PortForwardRequest) error {
+	req.Protocol = strings.ToLower(req.Protocol)
+	if req.Protocol != "tcp" && req.Protocol != "udp" {
+		return types.ErrInvalidPortForward
+	}
+
+	for _, port := range []int{req.ExternalPort, req.InternalPort} {
+		if port < 1 || port > 65535 {
+			return types.ErrInvalidPortForward
+		}
+	}
+
+	if req.ExternalIP != "" {
+		IP := net.ParseIP(req.ExternalIP)
+		if IP == nil {
+			return types.ErrInvalidIP
+		}
+		req.ExternalIP = IP.String()
+	}
+
+	return nil
+}
+
+// sendPortForwards sends all the port forwards of a tenant to its CNCI.
+func (c *controller) sendPortForwards(tenantID string) error {
+	t, err := c.ds.GetTenant(tenantID)
+	if err != nil {
+		return err
+	}
+	if t == nil {
+		return types.ErrTenantNotFound
+	}
+
+	// the CNCI is sent the port forwards of the tenant when it is added
+	if t.CNCIID == "" {
+		return nil
+	}
+
+	return c.client.updatePortForwards(*t, c.ds.GetPortForwards(&tenantID))
+}
+
+// publishTenantPortForwards sends the port forwards of a tenant, if it has
+// any, e.g., when a new CNCI joins.
+func (c *controller) publishTenantPortForwards(tenantID string) {
+	if len(c.ds.GetPortForwards(&tenantID)) == 0 {
+		return
+	}
+
+	err := c.sendPortForwards(tenantID)
+	if err != nil {
+		glog.Warningf("Unable to send the port forwards of tenant %s: %v", tenantID, err)
+	}
+}
+
+func (c *controller) ListPortForwards(tenant *string) []types.PortForward {
+	forwards := c.ds.GetPortForwards(tenant)
+
+	for i := range forwards {
+		c.makePortForwardLinks(&forwards[i], tenant)
+	}
+
+	return forwards
+}
+
+func (c *controller) AddPortForward(tenant *string, req types.PortForwardRequest) (f types.PortForward, err error) {
+	err = validatePortForward(&req)
+	if err != nil {
+		return f, err
+	}
+
+	i, err := c.ds.GetInstance(req.InstanceID)
+	if err != nil {
+		return f, err
+	}
+
+	if tenant != nil && i.TenantID != *tenant {
+		return f, types.ErrInstanceNotFound
+	}
+
+	newForward := types.PortForward{
+		ExternalIP:   req.ExternalIP,
+		ExternalPort: req.ExternalPort,
+		Protocol:     req.Protocol,
+		InstanceID:   req.InstanceID,
+		InternalPort: req.InternalPort,
+	}
+
+	if req.ExternalIP != "" {
+		// the port forwards already account for the shared external IP
+		f, err = c.ds.AddPortForward(newForward)
+	} else {
+		// A matching release for this is in DeletePortForward
+		res := <-c.qs.Consume(i.TenantID, payloads.RequestedResource{Type: payloads.ExternalIP, Value: 1})
+		defer func() {
+			if err != nil {
+				c.qs.Release(i.TenantID, payloads.RequestedResource{Type: payloads.ExternalIP, Value: 1})
+			}
+		}()
+
+		if !res.Allowed() {
+			return f, types.ErrQuota
+		}
+
+		var pools []types.Pool
+		pools, err = c.ds.GetPools()
+		if err != nil {
+			return f, err
+		}
+
+		err = types.ErrPoolEmpty
+
+		for _, pool := range pools {
+			if (req.PoolName != nil && pool.Name == *req.PoolName) ||
+				(req.PoolName == nil && pool.Free > 0) {
+				newForward.PoolID = pool.ID
+				f, err = c.ds.AddPortForward(newForward)
+				break
+			}
+		}
+	}
+
+	if err != nil {
+		return f, err
+	}
+
+	err = c.sendPortForwards(i.TenantID)
+	if err != nil {
+		_, _, _ = c.ds.DeletePortForward(f.ID)
+		return types.PortForward{}, err
+	}
+
+	c.makePortForwardLinks(&f, tenant)
+
+	return f, nil
+}
+
+func (c *controller) DeletePortForward(tenant *string, ID string) error {
+	f, err := c.ds.GetPortForward(ID)
+	if err != nil {
+		return err
+	}
+
+	if tenant != nil && f.TenantID != *tenant {
+		return types.ErrPortForwardNotFound
+	}
+
+	_, released, err := c.ds.DeletePortForward(ID)
+	if released {
+		c.qs.Release(f.TenantID, payloads.RequestedResource{Type: payloads.ExternalIP, Value: 1})
+	}
+	if err != nil {
+		return err
+	}
+
+	return c.sendPortForwards(f.TenantID)
+}
@@ -17,9 +17,12 @@
 package main
 
 import (
+	"bytes"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"net"
+	"strconv"
 	"time"
 
 	"github.com/01org/ciao/ciao-controller/types"
@@ -32,11 +35,14 @@ import (
 )
 
 type config struct {
-	sc     payloads.Start
-	config string
-	cnci   bool
-	mac    string
-	ip     string
+	sc        payloads.Start
+	config    string
+	cnci      bool
+	mac       string
+	ip        string
+	ipv6      string
+	subnetID  string
+	extraNICs []types.InstanceNIC
 }
 
 type instance struct {
@@ -62,25 +68,29 @@ func isCNCIWorkload(workload *types.Workload) bool {
 }
 
 func newInstance(ctl *controller, tenantID string, workload *types.Workload,
-	volumes []storage.BlockDevice) (*instance, error) {
+	volumes []storage.BlockDevice, networkIDs []string, fixedIP net.IP,
+	mac net.HardwareAddr) (*instance, error) {
 	id := uuid.Generate()
 
-	config, err := newConfig(ctl, workload, id.String(), tenantID, volumes)
+	config, err := newConfig(ctl, workload, id.String(), tenantID, volumes, networkIDs, fixedIP, mac)
 	if err != nil {
 		return nil, err
 	}
 
 	newInstance := types.Instance{
-		TenantID:   tenantID,
-		WorkloadID: workload.ID,
-		State:      payloads.Pending,
-		ID:         id.String(),
-		CNCI:       config.cnci,
-		IPAddress:  config.ip,
-		VnicUUID:   config.sc.Start.Networking.VnicUUID,
-		Subnet:     config.sc.Start.Networking.Subnet,
-		MACAddress: config.mac,
-		CreateTime: time.Now(),
+		TenantID:    tenantID,
+		WorkloadID:  workload.ID,
+		State:       payloads.Pending,
+		ID:          id.String(),
+		CNCI:        config.cnci,
+		IPAddress:   config.ip,
+		IPv6Address: config.ipv6,
+		VnicUUID:    config.sc.Start.Networking.VnicUUID,
+		Subnet:      config.sc.Start.Networking.Subnet,
+		SubnetID:    config.subnetID,
+		MACAddress:  config.mac,
+		ExtraNICs:   config.extraNICs,
+		CreateTime:  time.Now(),
 	}
 
 	i := &instance{
@@ -128,7 +138,12 @@ func (i *instance) Clean() error {
 		return nil
 	}
 
-	i.ctl.ds.ReleaseTenantIP(i.TenantID, i.IPAddress)
+	if i.SubnetID != "" {
+		i.ctl.ds.ReleaseSubnetIP(i.SubnetID, i.IPAddress)
+	} else {
+		i.ctl.ds.ReleaseTenantIP(i.TenantID, i.IPAddress)
+	}
+	i.ctl.releaseExtraNICs(i.ExtraNICs)
 
 	wl, err := i.ctl.ds.GetWorkload(i.TenantID, i.WorkloadID)
 	if err != nil {
@@ -173,6 +188,10 @@ func addBlockDevice(c *controller, tenant string, instanceID string, device stor
 		Description: s.Tag,
 	}
 
+	if s.SourceType == types.ImageService {
+		data.ImageID = s.SourceID
+	}
+
 	res := <-c.qs.Consume(tenant,
 		payloads.RequestedResource{Type: payloads.Volume, Value: 1},
 		payloads.RequestedResource{Type: payloads.SharedDiskGiB, Value: device.Size})
@@ -195,7 +214,12 @@ func addBlockDevice(c *controller, tenant string, instanceID string, device stor
 func getStorage(c *controller, s types.StorageResource, tenant string, instanceID string) (payloads.StorageResource, error) {
 	// storage already exists, use preexisting definition.
 	if s.ID != "" {
-		return payloads.StorageResource{ID: s.ID, Bootable: s.Bootable}, nil
+		return payloads.StorageResource{
+			ID:            s.ID,
+			Bootable:      s.Bootable,
+			IOLimits:      c.volumeIOLimits(s.ID),
+			EncryptionKey: c.volumeEncryptionKey(s.ID),
+		}, nil
 	}
 
 	// new storage.
@@ -225,13 +249,31 @@ func getStorage(c *controller, s types.StorageResource, tenant string, instanceI
 	// ID of source is the image id.
 	switch s.SourceType {
 	case types.ImageService:
+		err := c.stageImage(tenant, s.SourceID)
+		if err != nil {
+			glog.Errorf("Unable to copy image %s to the storage backend: %v", s.SourceID, err)
+			return payloads.StorageResource{}, err
+		}
+
 		device, err := c.CreateBlockDeviceFromSnapshot(s.SourceID, "ciao-image")
 		if err != nil {
 			glog.Errorf("Unable to get block device for image: %v", err)
 			return payloads.StorageResource{}, err
 		}
 
-		return addBlockDevice(c, tenant, instanceID, device, s)
+		resource, err := addBlockDevice(c, tenant, instanceID, device, s)
+		if err != nil {
+			return resource, err
+		}
+
+		// Let the launcher check the image the volume was
+		// cloned from before the instance first boots.
+		resource.ImageSHA256 = c.imageChecksum(tenant, s.SourceID)
+		if resource.ImageSHA256 != "" {
+			resource.ImageUUID = s.SourceID
+		}
+
+		return resource, nil
 
 	case types.VolumeService:
 		device, err := c.CopyBlockDevice(s.SourceID)
@@ -266,7 +308,8 @@ func controllerStorageResourceFromPayload(volume payloads.StorageResource) (s ty
 }
 
 func newConfig(ctl *controller, wl *types.Workload, instanceID string, tenantID string,
-	volumes []storage.BlockDevice) (config, error) {
+	volumes []storage.BlockDevice, networkIDs []string, fixedIP net.IP,
+	mac net.HardwareAddr) (config, error) {
 
 	var metaData userData
 	var config config
@@ -284,29 +327,130 @@ func newConfig(ctl *controller, wl *types.Workload, instanceID string, tenantID
 	config.cnci = isCNCIWorkload(wl)
 
 	var networking payloads.NetworkResources
+	var extraNetworking []payloads.NetworkResources
 	var storage []payloads.StorageResource
 
+	// the first network is the one of the primary NIC, the instance
+	// gets an additional NIC on each of the other networks
+	var networkID string
+	if len(networkIDs) > 0 {
+		networkID = networkIDs[0]
+	}
+
+	if len(networkIDs) > 1 && (config.cnci || wl.VMType == payloads.Docker) {
+		return config, types.ErrBadRequest
+	}
+
+	if config.cnci && (fixedIP != nil || mac != nil) {
+		return config, types.ErrBadRequest
+	}
+
+	for k := range networkIDs {
+		for _, id := range networkIDs[k+1:] {
+			if id == networkIDs[k] {
+				return config, types.ErrBadRequest
+			}
+		}
+	}
+
 	// do we ever need to save the vnic uuid?
 	networking.VnicUUID = uuid.Generate().String()
 
 	if config.cnci == false {
-		ipAddress, err := ctl.ds.AllocateTenantIP(tenantID)
+		var ipAddress net.IP
+		var subnet types.TenantSubnet
+
+		if len(networkIDs) > 1 {
+			config.extraNICs, err = ctl.allocateExtraNICs(tenantID, networkIDs[1:])
+			if err != nil {
+				return config, err
+			}
+
+			extraNetworking, err = ctl.extraNetworking(tenant, config.extraNICs)
+			if err != nil {
+				ctl.releaseExtraNICs(config.extraNICs)
+				return config, err
+			}
+		}
+
+		if fixedIP != nil {
+			ipAddress = fixedIP
+			if networkID != "" {
+				subnet, err = ctl.claimNetworkIP(tenantID, networkID, fixedIP)
+			} else {
+				err = ctl.ds.ClaimTenantIP(tenantID, fixedIP)
+			}
+		} else if networkID != "" {
+			ipAddress, subnet, err = ctl.allocateNetworkIP(tenantID, networkID)
+		} else {
+			ipAddress, err = ctl.ds.AllocateTenantIP(tenantID)
+		}
 		if err != nil {
 			fmt.Println("Unable to allocate IP address: ", err)
+			ctl.releaseExtraNICs(config.extraNICs)
+			return config, err
+		}
+
+		if mac == nil {
+			mac = newTenantHardwareAddr(ipAddress)
+		} else if err = ctl.checkInstanceMAC(mac, ipAddress); err != nil {
+			if networkID != "" {
+				ctl.ds.ReleaseSubnetIP(subnet.ID, ipAddress.String())
+			} else {
+				ctl.ds.ReleaseTenantIP(tenantID, ipAddress.String())
+			}
+			ctl.releaseExtraNICs(config.extraNICs)
 			return config, err
 		}
 
-		networking.VnicMAC = newTenantHardwareAddr(ipAddress).String()
+		networking.VnicMAC = mac.String()
 
 		// send in CIDR notation?
 		networking.PrivateIP = ipAddress.String()
 		config.ip = ipAddress.String()
-		mask := net.IPv4Mask(255, 255, 255, 0)
-		ipnet := net.IPNet{
-			IP:   ipAddress.Mask(mask),
-			Mask: mask,
+
+		if networkID != "" {
+			// tenant defined subnets are IPv4 only
+			networking.Subnet = subnet.CIDR
+			networking.Gateway = subnet.GatewayIP
+			networking.NetworkUUID = networkID
+			config.subnetID = subnet.ID
+		} else {
+			mask := net.IPv4Mask(255, 255, 255, 0)
+			ipnet := net.IPNet{
+				IP:   ipAddress.Mask(mask),
+				Mask: mask,
+			}
+			networking.Subnet = ipnet.String()
 		}
-		networking.Subnet = ipnet.String()
+
+		subnetKey, err := ctl.ds.TenantSubnetKey(tenantID, networking.Subnet)
+		if err != nil {
+			if networkID != "" {
+				ctl.ds.ReleaseSubnetIP(subnet.ID, ipAddress.String())
+			} else {
+				ctl.ds.ReleaseTenantIP(tenantID, ipAddress.String())
+			}
+			ctl.releaseExtraNICs(config.extraNICs)
+			return config, err
+		}
+		networking.SubnetKey = strconv.Itoa(subnetKey)
+
+		if tenantIPv6Prefix != nil && networkID == "" {
+			index, err := ctl.ds.TenantSubnetV6(tenantID, ipAddress)
+			if err != nil {
+				ctl.ds.ReleaseTenantIP(tenantID, ipAddress.String())
+				ctl.releaseExtraNICs(config.extraNICs)
+				return config, err
+			}
+
+			subnetV6 := tenantSubnetV6(*tenantIPv6Prefix, index)
+			ipv6 := tenantIPv6Addr(subnetV6, newTenantHardwareAddr(ipAddress))
+			networking.SubnetV6 = subnetV6.String()
+			networking.PrivateIPv6 = ipv6.String()
+			config.ipv6 = ipv6.String()
+		}
+
 		networking.ConcentratorUUID = tenant.CNCIID
 
 		// in theory we should refuse to go on if ip is null
@@ -345,8 +489,11 @@ func newConfig(ctl *controller, wl *types.Workload, instanceID string, tenantID
 				if err != nil {
 					return config, err
 				}
+			} else if volume.ID != "" {
+				// launcher will attach pre-existing volume
+				instanceStorage.IOLimits = ctl.volumeIOLimits(volume.ID)
+				instanceStorage.EncryptionKey = ctl.volumeEncryptionKey(volume.ID)
 			} /* else {
-				// volume.ID != "": launcher will attach pre-existing volume
 				// volume.Local: launcher will create ephemeral volume
 			} */
 
@@ -383,6 +530,7 @@ func newConfig(ctl *controller, wl *types.Workload, instanceID string, tenantID
 		InstancePersistence: payloads.Host,
 		RequestedResources:  defaults,
 		Networking:          networking,
+		ExtraNetworking:     extraNetworking,
 		Storage:             storage,
 	}
 
@@ -411,6 +559,48 @@ func newConfig(ctl *controller, wl *types.Workload, instanceID string, tenantID
 	return config, err
 }
 
+// checkInstanceMAC verifies that mac can be given to the primary NIC of a new
+// instance with address ip. The 02:00 prefix is left to the addresses derived
+// from instance IPs by newTenantHardwareAddr.
+func (c *controller) checkInstanceMAC(mac net.HardwareAddr, ip net.IP) error {
+	if len(mac) != 6 || mac[0]&1 != 0 {
+		return types.ErrInvalidMAC
+	}
+
+	if mac[0] == 2 && mac[1] == 0 && !bytes.Equal(mac, newTenantHardwareAddr(ip)) {
+		return types.ErrInvalidMAC
+	}
+
+	instances, err := c.ds.GetAllInstances()
+	if err != nil {
+		return err
+	}
+
+	for _, i := range instances {
+		if i.MACAddress == mac.String() {
+			return types.ErrAddressInUse
+		}
+		for _, nic := range i.ExtraNICs {
+			if nic.MACAddress == mac.String() {
+				return types.ErrAddressInUse
+			}
+		}
+	}
+
+	tenants, err := c.ds.GetAllTenants()
+	if err != nil {
+		return err
+	}
+
+	for _, t := range tenants {
+		if t.CNCIMAC == mac.String() {
+			return types.ErrAddressInUse
+		}
+	}
+
+	return nil
+}
+
 func newTenantHardwareAddr(ip net.IP) net.HardwareAddr {
 	buf := make([]byte, 6)
 	ipBytes := ip.To4()
@@ -419,3 +609,32 @@ func newTenantHardwareAddr(ip net.IP) net.HardwareAddr {
 	copy(buf[2:6], ipBytes)
 	return net.HardwareAddr(buf)
 }
+
+// tenantSubnetV6 returns the IPv6 /64 the datastore allocated to a tenant
+// subnet. index selects the /64 out of the /48 prefix.
+func tenantSubnetV6(prefix net.IPNet, index uint16) net.IPNet {
+	subnet := make(net.IP, net.IPv6len)
+	copy(subnet, prefix.IP.To16()[:6])
+	binary.BigEndian.PutUint16(subnet[6:8], index)
+
+	return net.IPNet{
+		IP:   subnet,
+		Mask: net.CIDRMask(64, 128),
+	}
+}
+
+// tenantIPv6Addr returns the address an instance configures through SLAAC
+// on subnet, i.e. the modified EUI-64 interface identifier of its MAC.
+func tenantIPv6Addr(subnet net.IPNet, mac net.HardwareAddr) net.IP {
+	ip := make(net.IP, net.IPv6len)
+	copy(ip, subnet.IP.To16()[:8])
+	ip[8] = mac[0] ^ 0x02
+	ip[9] = mac[1]
+	ip[10] = mac[2]
+	ip[11] = 0xff
+	ip[12] = 0xfe
+	ip[13] = mac[3]
+	ip[14] = mac[4]
+	ip[15] = mac[5]
+	return ip
+}
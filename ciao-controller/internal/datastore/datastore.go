@@ -23,6 +23,7 @@ import (
 	"crypto/rand"
 	"encoding/binary"
 	"fmt"
+	"math"
 	"net"
 	"sort"
 	"sync"
@@ -40,6 +41,7 @@ var (
 	ErrNoTenant            = errors.New("Tenant not found")
 	ErrNoBlockData         = errors.New("Block Device not found")
 	ErrNoStorageAttachment = errors.New("No Volume Attached")
+	ErrNoVolumeBackup      = errors.New("Volume backup not found")
 )
 
 // Config contains configuration information for the datastore.
@@ -60,11 +62,13 @@ const (
 
 type tenant struct {
 	types.Tenant
-	network   map[int]map[int]bool
-	subnets   []int
-	instances map[string]*types.Instance
-	devices   map[string]types.BlockData
-	workloads []types.Workload
+	network    map[int]map[int]bool
+	subnets    []int
+	subnetsV6  map[int]int
+	subnetKeys map[string]int
+	instances  map[string]*types.Instance
+	devices    map[string]types.BlockData
+	workloads  []types.Workload
 }
 
 type node struct {
@@ -97,6 +101,8 @@ type persistentStore interface {
 	updateTenant(t *tenant) (err error)
 	releaseTenantIP(tenantID string, subnetInt int, rest int) (err error)
 	claimTenantIP(tenantID string, subnetInt int, rest int) (err error)
+	claimTenantSubnetV6(tenantID string, subnetInt int, subnetV6 int) (err error)
+	claimTenantSubnetKey(tenantID string, subnet string, subnetKey int) (err error)
 
 	// interfaces related to instances
 	getInstances() (instances []*types.Instance, err error)
@@ -121,6 +127,10 @@ type persistentStore interface {
 	addStorageAttachment(a types.StorageAttachment) error
 	getAllStorageAttachments() (map[string]types.StorageAttachment, error)
 	deleteStorageAttachment(ID string) error
+	getAllVolumeBackups() (map[string]types.VolumeBackup, error)
+	addVolumeBackup(b types.VolumeBackup) error
+	updateVolumeBackup(b types.VolumeBackup) error
+	deleteVolumeBackup(ID string) error
 
 	// external IP interfaces
 	addPool(pool types.Pool) error
@@ -132,6 +142,22 @@ type persistentStore interface {
 	deleteMappedIP(ID string) error
 	getMappedIPs() map[string]types.MappedIP
 
+	addPortForward(f types.PortForward) error
+	deletePortForward(ID string) error
+	getPortForwards() map[string]types.PortForward
+
+	// security group interfaces
+	addSecurityGroup(sg types.SecurityGroup) error
+	updateSecurityGroup(sg types.SecurityGroup) error
+	deleteSecurityGroup(ID string) error
+	getAllSecurityGroups() (map[string]types.SecurityGroup, error)
+
+	// tenant network interfaces
+	addNetwork(n types.TenantNetwork) error
+	updateNetwork(n types.TenantNetwork) error
+	deleteNetwork(ID string) error
+	getAllNetworks() (map[string]types.TenantNetwork, error)
+
 	// quotas
 	updateQuotas(tenantID string, qds []types.QuotaDetails) error
 	getQuotas(tenantID string) ([]types.QuotaDetails, error)
@@ -173,11 +199,23 @@ type Datastore struct {
 	// maybe add a map[instanceid][]types.StorageAttachment
 	// to make retrieval of volumes faster.
 
+	backups     map[string]types.VolumeBackup
+	backupsLock *sync.RWMutex
+
 	pools           map[string]types.Pool
 	externalSubnets map[string]bool
 	externalIPs     map[string]bool
 	mappedIPs       map[string]types.MappedIP
+	portForwards    map[string]types.PortForward
+	forwardedIPs    map[string]int
 	poolsLock       *sync.RWMutex
+
+	securityGroups     map[string]types.SecurityGroup
+	securityGroupsLock *sync.RWMutex
+
+	networks     map[string]types.TenantNetwork
+	subnetAddrs  map[string]map[string]bool
+	networksLock *sync.RWMutex
 }
 
 func (ds *Datastore) initExternalIPs() {
@@ -198,6 +236,12 @@ func (ds *Datastore) initExternalIPs() {
 	}
 
 	ds.mappedIPs = ds.db.getMappedIPs()
+
+	ds.portForwards = ds.db.getPortForwards()
+	ds.forwardedIPs = make(map[string]int)
+	for _, f := range ds.portForwards {
+		ds.forwardedIPs[f.ExternalIP]++
+	}
 }
 
 // Init initializes the private data for the Datastore object.
@@ -298,6 +342,13 @@ func (ds *Datastore) Init(config Config) error {
 		return errors.Wrap(err, "error getting storage attachments from database")
 	}
 
+	ds.backups, err = ds.db.getAllVolumeBackups()
+	if err != nil {
+		return errors.Wrap(err, "error getting volume backups from database")
+	}
+
+	ds.backupsLock = &sync.RWMutex{}
+
 	ds.instanceVolumes = make(map[attachment]string)
 
 	for key, value := range ds.attachments {
@@ -318,6 +369,38 @@ func (ds *Datastore) Init(config Config) error {
 
 	ds.initExternalIPs()
 
+	ds.securityGroups, err = ds.db.getAllSecurityGroups()
+	if err != nil {
+		return errors.Wrap(err, "error getting security groups from database")
+	}
+
+	ds.securityGroupsLock = &sync.RWMutex{}
+
+	ds.networks, err = ds.db.getAllNetworks()
+	if err != nil {
+		return errors.Wrap(err, "error getting networks from database")
+	}
+
+	// addresses of tenant subnets are only claimed by instances
+	ds.subnetAddrs = make(map[string]map[string]bool)
+	for _, n := range ds.networks {
+		for _, sn := range n.Subnets {
+			ds.subnetAddrs[sn.ID] = make(map[string]bool)
+		}
+	}
+	for _, i := range ds.instances {
+		if addrs, ok := ds.subnetAddrs[i.SubnetID]; ok {
+			addrs[i.IPAddress] = true
+		}
+		for _, nic := range i.ExtraNICs {
+			if addrs, ok := ds.subnetAddrs[nic.SubnetID]; ok {
+				addrs[nic.IPAddress] = true
+			}
+		}
+	}
+
+	ds.networksLock = &sync.RWMutex{}
+
 	return nil
 }
 
@@ -689,6 +772,40 @@ func (ds *Datastore) ReleaseTenantIP(tenantID string, ip string) error {
 	return ds.db.releaseTenantIP(tenantID, int(subnetInt), int(ipBytes[3]))
 }
 
+// legacySubnet returns the 172.x.y.0/24 subnet AllocateTenantIP identifies
+// with subnetInt.
+func legacySubnet(subnetInt uint16) *net.IPNet {
+	return &net.IPNet{
+		IP:   net.IPv4(172, byte(subnetInt>>8), byte(subnetInt), 0).To4(),
+		Mask: net.CIDRMask(24, 32),
+	}
+}
+
+// overlapsTenantNetworks returns true if subnet overlaps with a subnet of
+// one of the networks defined by tenantID.
+func (ds *Datastore) overlapsTenantNetworks(tenantID string, subnet *net.IPNet) bool {
+	ds.networksLock.RLock()
+	defer ds.networksLock.RUnlock()
+
+	for _, n := range ds.networks {
+		if n.TenantID != tenantID {
+			continue
+		}
+		for _, sn := range n.Subnets {
+			_, ipNet, err := net.ParseCIDR(sn.CIDR)
+			if err == nil && subnetsOverlap(ipNet, subnet) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func subnetsOverlap(a *net.IPNet, b *net.IPNet) bool {
+	return a.Contains(b.IP) || b.Contains(a.IP)
+}
+
 // AllocateTenantIP will find a free IP address within a tenant network.
 // For now we make each tenant have unique subnets even though it
 // isn't actually needed because of a docker issue.
@@ -718,7 +835,7 @@ func (ds *Datastore) AllocateTenantIP(tenantID string) (net.IP, error) {
 		for {
 			// check for new subnet.
 			_, ok := network[int(i)]
-			if !ok {
+			if !ok && !ds.overlapsTenantNetworks(tenantID, legacySubnet(i)) {
 				sub := make(map[int]bool)
 				network[int(i)] = sub
 
@@ -780,6 +897,164 @@ func (ds *Datastore) AllocateTenantIP(tenantID string) (net.IP, error) {
 	return next, nil
 }
 
+// ClaimTenantIP reserves a specific address for a new instance of a tenant.
+// The address must belong to one of the 172.x.y.0/24 subnets AllocateTenantIP
+// hands out, and the subnet must not overlap one of the tenant networks.
+func (ds *Datastore) ClaimTenantIP(tenantID string, ip net.IP) error {
+	ipBytes := ip.To4()
+	if ipBytes == nil || ipBytes[0] != 172 || ipBytes[1] < 16 || ipBytes[1] > 31 {
+		return types.ErrInvalidIP
+	}
+
+	// .0 is the network, .1 the gateway and .255 the broadcast address
+	host := int(ipBytes[3])
+	if host < 2 || host == 255 {
+		return types.ErrInvalidIP
+	}
+
+	subnetInt := binary.BigEndian.Uint16(ipBytes[1:3])
+
+	ds.tenantsLock.Lock()
+	defer ds.tenantsLock.Unlock()
+
+	tenant := ds.tenants[tenantID]
+	if tenant == nil {
+		return types.ErrTenantNotFound
+	}
+
+	hosts, ok := tenant.network[int(subnetInt)]
+	if !ok {
+		if ds.overlapsTenantNetworks(tenantID, legacySubnet(subnetInt)) {
+			return types.ErrInvalidIP
+		}
+
+		hosts = make(map[int]bool)
+		tenant.network[int(subnetInt)] = hosts
+		tenant.subnets = append(tenant.subnets, int(subnetInt))
+	}
+
+	if hosts[host] {
+		return types.ErrAddressInUse
+	}
+
+	hosts[host] = true
+
+	go ds.db.claimTenantIP(tenantID, int(subnetInt), host)
+
+	return nil
+}
+
+// TenantSubnetV6 returns the index of the IPv6 /64 paired with the tenant
+// subnet ip belongs to. The index selects a /64 out of the tenant IPv6 /48
+// and is never shared by two tenant subnets, whichever tenant they belong
+// to. A tenant subnet gets its index the first time it is asked for and
+// keeps it from then on.
+func (ds *Datastore) TenantSubnetV6(tenantID string, ip net.IP) (uint16, error) {
+	ipBytes := ip.To4()
+	if ipBytes == nil {
+		return 0, types.ErrInvalidIP
+	}
+
+	subnetInt := int(binary.BigEndian.Uint16(ipBytes[1:3]))
+
+	ds.tenantsLock.Lock()
+	defer ds.tenantsLock.Unlock()
+
+	tenant := ds.tenants[tenantID]
+	if tenant == nil {
+		return 0, types.ErrTenantNotFound
+	}
+
+	if subnetV6, ok := tenant.subnetsV6[subnetInt]; ok {
+		return uint16(subnetV6), nil
+	}
+
+	used := make(map[int]bool)
+	for _, t := range ds.tenants {
+		for _, subnetV6 := range t.subnetsV6 {
+			used[subnetV6] = true
+		}
+	}
+
+	for subnetV6 := 0; subnetV6 <= math.MaxUint16; subnetV6++ {
+		if used[subnetV6] {
+			continue
+		}
+
+		err := ds.db.claimTenantSubnetV6(tenantID, subnetInt, subnetV6)
+		if err != nil {
+			return 0, err
+		}
+
+		if tenant.subnetsV6 == nil {
+			tenant.subnetsV6 = make(map[int]int)
+		}
+		tenant.subnetsV6[subnetInt] = subnetV6
+
+		return uint16(subnetV6), nil
+	}
+
+	glog.Warning("Out of IPv6 subnets")
+	return 0, errors.New("Out of IPv6 subnets")
+}
+
+// maxSubnetKey is the largest subnet key. The compute nodes use the subnet
+// keys as VXLAN network identifiers, which are 24 bits long.
+const maxSubnetKey = 1<<24 - 1
+
+// TenantSubnetKey returns the key of a tenant subnet. The key identifies
+// the tunnels of the subnet between the compute nodes and the CNCI and is
+// never shared by two tenant subnets, whichever tenant they belong to. A
+// tenant subnet gets its key the first time it is asked for and keeps it
+// from then on.
+func (ds *Datastore) TenantSubnetKey(tenantID string, subnet string) (int, error) {
+	_, ipNet, err := net.ParseCIDR(subnet)
+	if err != nil {
+		return 0, types.ErrBadRequest
+	}
+	subnet = ipNet.String()
+
+	ds.tenantsLock.Lock()
+	defer ds.tenantsLock.Unlock()
+
+	tenant := ds.tenants[tenantID]
+	if tenant == nil {
+		return 0, types.ErrTenantNotFound
+	}
+
+	if subnetKey, ok := tenant.subnetKeys[subnet]; ok {
+		return subnetKey, nil
+	}
+
+	used := make(map[int]bool)
+	for _, t := range ds.tenants {
+		for _, subnetKey := range t.subnetKeys {
+			used[subnetKey] = true
+		}
+	}
+
+	for subnetKey := 1; subnetKey <= maxSubnetKey; subnetKey++ {
+		if used[subnetKey] {
+			continue
+		}
+
+		err := ds.db.claimTenantSubnetKey(tenantID, subnet, subnetKey)
+		if err != nil {
+			return 0, err
+		}
+
+		if tenant.subnetKeys == nil {
+			tenant.subnetKeys = make(map[string]int)
+		}
+		tenant.subnetKeys[subnet] = subnetKey
+
+		return subnetKey, nil
+	}
+
+	glog.Warning("Out of subnet keys")
+	return 0, errors.New("Out of subnet keys")
+}
+
 // GetAllInstances retrieves all instances out of the datastore.
 func (ds *Datastore) GetAllInstances() ([]*types.Instance, error) {
 	var instances []*types.Instance
@@ -982,8 +1257,9 @@ func (ds *Datastore) StartFailure(instanceID string, reason payloads.StartFailur
 }
 
 // AttachVolumeFailure will clean up after a failure to attach a volume.
-// The volume state will be changed back to available, and an error message
-// will be logged.
+// The attachment will be removed, the volume state will be changed back to
+// available, or to in use if the volume is still attached to other instances,
+// and an error message will be logged.
 func (ds *Datastore) AttachVolumeFailure(instanceID string, volumeID string, reason payloads.AttachVolumeFailureReason) error {
 	// update the block data to reflect correct state
 	data, err := ds.GetBlockDevice(volumeID)
@@ -991,7 +1267,23 @@ func (ds *Datastore) AttachVolumeFailure(instanceID string, volumeID string, rea
 		return errors.Wrapf(err, "error getting block device for volume (%v)", volumeID)
 	}
 
-	data.State = types.Available
+	a, err := ds.getStorageAttachment(instanceID, volumeID)
+	if err == nil {
+		err = ds.DeleteStorageAttachment(a.ID)
+		if err != nil {
+			glog.Warningf("error deleting storage attachment (%v): %v", a.ID, err)
+		}
+	}
+
+	ds.attachLock.RLock()
+	attached := ds.volumeAttachedLocked(volumeID)
+	ds.attachLock.RUnlock()
+
+	if attached {
+		data.State = types.InUse
+	} else {
+		data.State = types.Available
+	}
 	err = ds.UpdateBlockDevice(data)
 	if err != nil {
 		return errors.Wrapf(err, "error updating block device for volume (%v)", volumeID)
@@ -1072,13 +1364,25 @@ func (ds *Datastore) deleteInstance(instanceID string) (string, error) {
 		err = errors.Wrapf(tmpErr, "error deleting instance from database (%v)", i.ID)
 	}
 
-	if tmpErr := ds.ReleaseTenantIP(i.TenantID, i.IPAddress); tmpErr != nil {
+	var tmpErr error
+	if i.SubnetID != "" {
+		tmpErr = ds.ReleaseSubnetIP(i.SubnetID, i.IPAddress)
+	} else {
+		tmpErr = ds.ReleaseTenantIP(i.TenantID, i.IPAddress)
+	}
+	if tmpErr != nil {
 		glog.Warningf("error releasing IP for instance (%v): %v", i.ID, err)
 		if err == nil {
 			err = errors.Wrapf(err, "error releasing IP for instance (%v)", i.ID)
 		}
 	}
 
+	for _, nic := range i.ExtraNICs {
+		if tmpErr := ds.ReleaseSubnetIP(nic.SubnetID, nic.IPAddress); tmpErr != nil {
+			glog.Warningf("error releasing IP %s for instance (%v): %v", nic.IPAddress, i.ID, tmpErr)
+		}
+	}
+
 	ds.updateStorageAttachments(instanceID, nil)
 
 	return i.TenantID, err
@@ -1257,6 +1561,11 @@ func (ds *Datastore) addNodeStat(stat payloads.Stat) error {
 		OnlineCPUs:    stat.CpusOnline,
 	}
 
+	if stat.ImageCache != nil {
+		cnStat.ImageCacheSize = stat.ImageCache.SizeMB
+		cnStat.CachedImages = len(stat.ImageCache.Images)
+	}
+
 	ds.nodeLastStatLock.Lock()
 
 	delete(ds.nodeLastStat, stat.NodeUUID)
@@ -1575,6 +1884,21 @@ func (ds *Datastore) GetBlockDevices(tenant string) ([]types.BlockData, error) {
 
 }
 
+// GetAllBlockDevices will return all the BlockDevices known to the
+// datastore, whichever tenant they belong to.
+func (ds *Datastore) GetAllBlockDevices() []types.BlockData {
+	ds.bdLock.RLock()
+
+	devices := make([]types.BlockData, 0, len(ds.blockDevices))
+	for _, value := range ds.blockDevices {
+		devices = append(devices, value)
+	}
+
+	ds.bdLock.RUnlock()
+
+	return devices
+}
+
 // GetBlockDevice will return information about a block device from the
 // datastore.
 func (ds *Datastore) GetBlockDevice(ID string) (types.BlockData, error) {
@@ -1602,136 +1926,685 @@ func (ds *Datastore) UpdateBlockDevice(data types.BlockData) error {
 	return errors.Wrapf(ds.AddBlockDevice(data), "error updating block device (%v)", data.ID)
 }
 
-// CreateStorageAttachment will associate an instance with a block device in
-// the datastore
-func (ds *Datastore) CreateStorageAttachment(instanceID string, volume payloads.StorageResource) (types.StorageAttachment, error) {
-	link := attachment{
-		instanceID: instanceID,
-		volumeID:   volume.ID,
+// AddVolumeBackup will store information about a new volume backup
+// in the datastore.
+func (ds *Datastore) AddVolumeBackup(b types.VolumeBackup) error {
+	err := ds.db.addVolumeBackup(b)
+	if err != nil {
+		return errors.Wrapf(err, "error adding volume backup (%v) to database", b.ID)
 	}
 
-	a := types.StorageAttachment{
-		InstanceID: instanceID,
-		ID:         uuid.Generate().String(),
-		BlockID:    volume.ID,
-		Ephemeral:  volume.Ephemeral,
-		Boot:       volume.Bootable,
-	}
+	ds.backupsLock.Lock()
+	ds.backups[b.ID] = b
+	ds.backupsLock.Unlock()
 
-	err := ds.db.addStorageAttachment(a)
-	if err != nil {
-		return types.StorageAttachment{}, errors.Wrap(err, "error adding storage attachment to database")
-	}
+	return nil
+}
 
-	// ensure that the volume is marked in use as we have created an attachment
-	bd, err := ds.GetBlockDevice(volume.ID)
-	if err != nil {
-		ds.db.deleteStorageAttachment(a.ID)
-		return types.StorageAttachment{}, errors.Wrapf(err, "error fetching block device (%v)", volume.ID)
+// UpdateVolumeBackup will replace existing information about a
+// volume backup in the datastore.
+func (ds *Datastore) UpdateVolumeBackup(b types.VolumeBackup) error {
+	ds.backupsLock.Lock()
+	_, ok := ds.backups[b.ID]
+	if !ok {
+		ds.backupsLock.Unlock()
+		return ErrNoVolumeBackup
 	}
+	ds.backups[b.ID] = b
+	ds.backupsLock.Unlock()
 
-	bd.State = types.InUse
-	err = ds.UpdateBlockDevice(bd)
-	if err != nil {
-		ds.db.deleteStorageAttachment(a.ID)
-		return types.StorageAttachment{}, errors.Wrapf(err, "error updating block device (%v)", volume.ID)
+	return errors.Wrapf(ds.db.updateVolumeBackup(b), "error updating volume backup (%v)", b.ID)
+}
+
+// DeleteVolumeBackup will remove a volume backup from the datastore.
+func (ds *Datastore) DeleteVolumeBackup(ID string) error {
+	ds.backupsLock.Lock()
+	_, ok := ds.backups[ID]
+	if !ok {
+		ds.backupsLock.Unlock()
+		return ErrNoVolumeBackup
 	}
+	delete(ds.backups, ID)
+	ds.backupsLock.Unlock()
 
-	// add it to our links map
-	ds.attachLock.Lock()
-	ds.attachments[a.ID] = a
-	ds.instanceVolumes[link] = a.ID
-	ds.attachLock.Unlock()
+	return errors.Wrapf(ds.db.deleteVolumeBackup(ID), "error deleting volume backup (%v)", ID)
+}
 
-	return a, nil
+// GetVolumeBackup will return information about a volume backup from
+// the datastore.
+func (ds *Datastore) GetVolumeBackup(ID string) (types.VolumeBackup, error) {
+	ds.backupsLock.RLock()
+	b, ok := ds.backups[ID]
+	ds.backupsLock.RUnlock()
+
+	if !ok {
+		return types.VolumeBackup{}, ErrNoVolumeBackup
+	}
+	return b, nil
 }
 
-// GetStorageAttachments returns a list of volumes associated with this instance.
-func (ds *Datastore) GetStorageAttachments(instanceID string) []types.StorageAttachment {
-	var links []types.StorageAttachment
+// GetVolumeBackups will return all the volume backups owned by a tenant.
+func (ds *Datastore) GetVolumeBackups(tenant string) ([]types.VolumeBackup, error) {
+	var backups []types.VolumeBackup
 
-	ds.attachLock.RLock()
-	for _, a := range ds.attachments {
-		if a.InstanceID == instanceID {
-			links = append(links, a)
+	ds.backupsLock.RLock()
+	for _, b := range ds.backups {
+		if b.TenantID == tenant {
+			backups = append(backups, b)
 		}
 	}
-	ds.attachLock.RUnlock()
+	ds.backupsLock.RUnlock()
 
-	return links
+	return backups, nil
 }
 
-func (ds *Datastore) updateStorageAttachments(instanceID string, volumes []string) {
-	m := make(map[string]bool)
+// copySecurityGroup returns a copy of sg which does not share its rules
+// and instances with sg.
+func copySecurityGroup(sg types.SecurityGroup) types.SecurityGroup {
+	sg.Rules = append([]types.SecurityGroupRule(nil), sg.Rules...)
+	sg.Instances = append([]string(nil), sg.Instances...)
+	sg.Links = nil
+	return sg
+}
 
-	// this for handy searching.
-	for _, v := range volumes {
-		m[v] = true
+// AddSecurityGroup will store a new security group in the datastore.
+func (ds *Datastore) AddSecurityGroup(sg types.SecurityGroup) error {
+	sg = copySecurityGroup(sg)
+
+	err := ds.db.addSecurityGroup(sg)
+	if err != nil {
+		return errors.Wrapf(err, "error adding security group (%v) to database", sg.ID)
 	}
 
-	// see if we already know about each attachment.
-	ds.attachLock.Lock()
+	ds.securityGroupsLock.Lock()
+	ds.securityGroups[sg.ID] = sg
+	ds.securityGroupsLock.Unlock()
 
-	for _, v := range volumes {
-		key := attachment{
-			instanceID: instanceID,
-			volumeID:   v,
-		}
+	return nil
+}
 
-		_, ok := ds.instanceVolumes[key]
-		if !ok {
-			// add the attachment
-			a := types.StorageAttachment{
-				InstanceID: instanceID,
-				ID:         uuid.Generate().String(),
-				BlockID:    v,
-			}
-			ds.attachments[a.ID] = a
-			ds.instanceVolumes[key] = a.ID
+// UpdateSecurityGroup will replace the rules and instances of an existing
+// security group.
+func (ds *Datastore) UpdateSecurityGroup(sg types.SecurityGroup) error {
+	sg = copySecurityGroup(sg)
 
-			// not sure what to do with an error here.
-			err := ds.db.addStorageAttachment(a)
-			if err != nil {
-				glog.Warningf("error adding storage attachment to database: %v", err)
-				continue
-			}
+	ds.securityGroupsLock.Lock()
+	defer ds.securityGroupsLock.Unlock()
 
-			// update the state of the volume.
-			bd, err := ds.GetBlockDevice(v)
-			if err != nil {
-				glog.Warningf("error fetching block device (%v): %v", v, err)
-				// well, maybe we should add it, it obviously
-				// exists.
-				continue
-			}
+	_, ok := ds.securityGroups[sg.ID]
+	if !ok {
+		return types.ErrSecurityGroupNotFound
+	}
 
-			bd.State = types.InUse
-			err = ds.UpdateBlockDevice(bd)
-			if err != nil {
-				glog.Warningf("error updating block device (%v): %v", v, err)
-			}
-		}
+	err := ds.db.updateSecurityGroup(sg)
+	if err != nil {
+		return errors.Wrapf(err, "error updating security group (%v)", sg.ID)
 	}
 
-	// finally, check to see if all the attachments we already
-	// know about are in the list.
-	for _, ID := range ds.instanceVolumes {
-		a := ds.attachments[ID]
+	ds.securityGroups[sg.ID] = sg
 
-		if a.InstanceID == instanceID && !m[a.BlockID] {
-			bd, err := ds.GetBlockDevice(a.BlockID)
+	return nil
+}
+
+// DeleteSecurityGroup will remove a security group from the datastore.
+func (ds *Datastore) DeleteSecurityGroup(ID string) error {
+	ds.securityGroupsLock.Lock()
+	defer ds.securityGroupsLock.Unlock()
+
+	_, ok := ds.securityGroups[ID]
+	if !ok {
+		return types.ErrSecurityGroupNotFound
+	}
+
+	err := ds.db.deleteSecurityGroup(ID)
+	if err != nil {
+		return errors.Wrapf(err, "error deleting security group (%v)", ID)
+	}
+
+	delete(ds.securityGroups, ID)
+
+	return nil
+}
+
+// GetSecurityGroup will return a security group from the datastore.
+func (ds *Datastore) GetSecurityGroup(ID string) (types.SecurityGroup, error) {
+	ds.securityGroupsLock.RLock()
+	sg, ok := ds.securityGroups[ID]
+	ds.securityGroupsLock.RUnlock()
+
+	if !ok {
+		return types.SecurityGroup{}, types.ErrSecurityGroupNotFound
+	}
+
+	return copySecurityGroup(sg), nil
+}
+
+// GetSecurityGroups will return the security groups of a tenant, or all
+// security groups if tenant is nil.
+func (ds *Datastore) GetSecurityGroups(tenant *string) []types.SecurityGroup {
+	var groups []types.SecurityGroup
+
+	ds.securityGroupsLock.RLock()
+	for _, sg := range ds.securityGroups {
+		if tenant == nil || sg.TenantID == *tenant {
+			groups = append(groups, copySecurityGroup(sg))
+		}
+	}
+	ds.securityGroupsLock.RUnlock()
+
+	return groups
+}
+
+// GetInstanceSecurityGroups will return the security groups an instance
+// belongs to.
+func (ds *Datastore) GetInstanceSecurityGroups(instanceID string) []types.SecurityGroup {
+	var groups []types.SecurityGroup
+
+	ds.securityGroupsLock.RLock()
+	for _, sg := range ds.securityGroups {
+		for _, i := range sg.Instances {
+			if i == instanceID {
+				groups = append(groups, copySecurityGroup(sg))
+				break
+			}
+		}
+	}
+	ds.securityGroupsLock.RUnlock()
+
+	return groups
+}
+
+// copyDHCPOptions returns a copy of o which does not share its lists
+// with o.
+func copyDHCPOptions(o types.SubnetDHCPOptions) types.SubnetDHCPOptions {
+	return types.SubnetDHCPOptions{
+		DNSNameservers:   append([]string(nil), o.DNSNameservers...),
+		DNSSearchDomains: append([]string(nil), o.DNSSearchDomains...),
+		HostRoutes:       append([]types.HostRoute(nil), o.HostRoutes...),
+		NTPServers:       append([]string(nil), o.NTPServers...),
+	}
+}
+
+// copyNetwork returns a copy of n which does not share its subnets with n.
+func copyNetwork(n types.TenantNetwork) types.TenantNetwork {
+	n.Subnets = append([]types.TenantSubnet(nil), n.Subnets...)
+	for i := range n.Subnets {
+		n.Subnets[i].SubnetDHCPOptions = copyDHCPOptions(n.Subnets[i].SubnetDHCPOptions)
+	}
+	n.Links = nil
+	return n
+}
+
+// checkSubnetsLocked verifies that subnets do not overlap with each other,
+// with the subnets of the other networks of tenantID nor with the subnets
+// allocated to the tenant by AllocateTenantIP. The caller must hold
+// ds.tenantsLock and ds.networksLock.
+func (ds *Datastore) checkSubnetsLocked(tenantID string, subnets []types.TenantSubnet) error {
+	var existing []*net.IPNet
+
+	if t := ds.tenants[tenantID]; t != nil {
+		for _, subnetInt := range t.subnets {
+			existing = append(existing, legacySubnet(uint16(subnetInt)))
+		}
+	}
+
+	for _, n := range ds.networks {
+		if n.TenantID != tenantID {
+			continue
+		}
+		for _, sn := range n.Subnets {
+			_, ipNet, err := net.ParseCIDR(sn.CIDR)
+			if err == nil {
+				existing = append(existing, ipNet)
+			}
+		}
+	}
+
+	for _, sn := range subnets {
+		_, ipNet, err := net.ParseCIDR(sn.CIDR)
+		if err != nil {
+			return types.ErrInvalidSubnet
+		}
+
+		for _, e := range existing {
+			if subnetsOverlap(e, ipNet) {
+				return types.ErrSubnetOverlap
+			}
+		}
+		existing = append(existing, ipNet)
+	}
+
+	return nil
+}
+
+// AddNetwork will store a new tenant network in the datastore. The subnets
+// of the network must not overlap with any other subnet of the tenant.
+func (ds *Datastore) AddNetwork(n types.TenantNetwork) error {
+	n = copyNetwork(n)
+
+	ds.tenantsLock.RLock()
+	defer ds.tenantsLock.RUnlock()
+	ds.networksLock.Lock()
+	defer ds.networksLock.Unlock()
+
+	err := ds.checkSubnetsLocked(n.TenantID, n.Subnets)
+	if err != nil {
+		return err
+	}
+
+	err = ds.db.addNetwork(n)
+	if err != nil {
+		return errors.Wrapf(err, "error adding network (%v) to database", n.ID)
+	}
+
+	ds.networks[n.ID] = n
+	for _, sn := range n.Subnets {
+		ds.subnetAddrs[sn.ID] = make(map[string]bool)
+	}
+
+	return nil
+}
+
+// AddNetworkSubnet will add a subnet to an existing tenant network. The
+// subnet must not overlap with any other subnet of the tenant.
+func (ds *Datastore) AddNetworkSubnet(networkID string, sn types.TenantSubnet) error {
+	ds.tenantsLock.RLock()
+	defer ds.tenantsLock.RUnlock()
+	ds.networksLock.Lock()
+	defer ds.networksLock.Unlock()
+
+	n, ok := ds.networks[networkID]
+	if !ok {
+		return types.ErrNetworkNotFound
+	}
+
+	err := ds.checkSubnetsLocked(n.TenantID, []types.TenantSubnet{sn})
+	if err != nil {
+		return err
+	}
+
+	n = copyNetwork(n)
+	n.Subnets = append(n.Subnets, sn)
+
+	err = ds.db.updateNetwork(n)
+	if err != nil {
+		return errors.Wrapf(err, "error updating network (%v)", n.ID)
+	}
+
+	ds.networks[n.ID] = n
+	ds.subnetAddrs[sn.ID] = make(map[string]bool)
+
+	return nil
+}
+
+// UpdateSubnetDHCPOptions will replace the DHCP options of a subnet of a
+// tenant network.
+func (ds *Datastore) UpdateSubnetDHCPOptions(networkID string, subnetID string, options types.SubnetDHCPOptions) (types.TenantSubnet, error) {
+	ds.networksLock.Lock()
+	defer ds.networksLock.Unlock()
+
+	n, ok := ds.networks[networkID]
+	if !ok {
+		return types.TenantSubnet{}, types.ErrNetworkNotFound
+	}
+
+	n = copyNetwork(n)
+
+	for i := range n.Subnets {
+		if n.Subnets[i].ID != subnetID {
+			continue
+		}
+
+		n.Subnets[i].SubnetDHCPOptions = copyDHCPOptions(options)
+
+		err := ds.db.updateNetwork(n)
+		if err != nil {
+			return types.TenantSubnet{}, errors.Wrapf(err, "error updating network (%v)", n.ID)
+		}
+
+		ds.networks[n.ID] = n
+
+		sn := n.Subnets[i]
+		sn.SubnetDHCPOptions = copyDHCPOptions(options)
+
+		return sn, nil
+	}
+
+	return types.TenantSubnet{}, types.ErrSubnetNotFound
+}
+
+// DeleteNetworkSubnet will remove a subnet from a tenant network. Subnets
+// with instances attached to them cannot be removed.
+func (ds *Datastore) DeleteNetworkSubnet(networkID string, subnetID string) error {
+	ds.networksLock.Lock()
+	defer ds.networksLock.Unlock()
+
+	n, ok := ds.networks[networkID]
+	if !ok {
+		return types.ErrNetworkNotFound
+	}
+
+	n = copyNetwork(n)
+
+	found := false
+	for i := range n.Subnets {
+		if n.Subnets[i].ID == subnetID {
+			n.Subnets = append(n.Subnets[:i], n.Subnets[i+1:]...)
+			found = true
+			break
+		}
+	}
+
+	if !found {
+		return types.ErrSubnetNotFound
+	}
+
+	if len(ds.subnetAddrs[subnetID]) > 0 {
+		return types.ErrNetworkInUse
+	}
+
+	err := ds.db.updateNetwork(n)
+	if err != nil {
+		return errors.Wrapf(err, "error updating network (%v)", n.ID)
+	}
+
+	ds.networks[n.ID] = n
+	delete(ds.subnetAddrs, subnetID)
+
+	return nil
+}
+
+// DeleteNetwork will remove a tenant network and its subnets from the
+// datastore. Networks with instances attached to them cannot be removed.
+func (ds *Datastore) DeleteNetwork(ID string) error {
+	ds.networksLock.Lock()
+	defer ds.networksLock.Unlock()
+
+	n, ok := ds.networks[ID]
+	if !ok {
+		return types.ErrNetworkNotFound
+	}
+
+	for _, sn := range n.Subnets {
+		if len(ds.subnetAddrs[sn.ID]) > 0 {
+			return types.ErrNetworkInUse
+		}
+	}
+
+	err := ds.db.deleteNetwork(ID)
+	if err != nil {
+		return errors.Wrapf(err, "error deleting network (%v)", ID)
+	}
+
+	for _, sn := range n.Subnets {
+		delete(ds.subnetAddrs, sn.ID)
+	}
+	delete(ds.networks, ID)
+
+	return nil
+}
+
+// GetNetwork will return a tenant network from the datastore.
+func (ds *Datastore) GetNetwork(ID string) (types.TenantNetwork, error) {
+	ds.networksLock.RLock()
+	n, ok := ds.networks[ID]
+	ds.networksLock.RUnlock()
+
+	if !ok {
+		return types.TenantNetwork{}, types.ErrNetworkNotFound
+	}
+
+	return copyNetwork(n), nil
+}
+
+// GetNetworks will return the networks of a tenant, or all networks if
+// tenant is nil.
+func (ds *Datastore) GetNetworks(tenant *string) []types.TenantNetwork {
+	var networks []types.TenantNetwork
+
+	ds.networksLock.RLock()
+	for _, n := range ds.networks {
+		if tenant == nil || n.TenantID == *tenant {
+			networks = append(networks, copyNetwork(n))
+		}
+	}
+	ds.networksLock.RUnlock()
+
+	return networks
+}
+
+// getSubnetLocked returns a tenant subnet. The caller must hold
+// ds.networksLock.
+func (ds *Datastore) getSubnetLocked(ID string) (types.TenantSubnet, error) {
+	for _, n := range ds.networks {
+		for _, sn := range n.Subnets {
+			if sn.ID == ID {
+				return sn, nil
+			}
+		}
+	}
+
+	return types.TenantSubnet{}, types.ErrSubnetNotFound
+}
+
+// GetSubnet will return a subnet of a tenant network.
+func (ds *Datastore) GetSubnet(ID string) (types.TenantSubnet, error) {
+	ds.networksLock.RLock()
+	defer ds.networksLock.RUnlock()
+
+	return ds.getSubnetLocked(ID)
+}
+
+// AllocateSubnetIP will find a free IP address within a tenant subnet. The
+// network and broadcast addresses of the subnet and the address of its
+// gateway are never allocated.
+func (ds *Datastore) AllocateSubnetIP(subnetID string) (net.IP, error) {
+	ds.networksLock.Lock()
+	defer ds.networksLock.Unlock()
+
+	sn, err := ds.getSubnetLocked(subnetID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, ipNet, err := net.ParseCIDR(sn.CIDR)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid subnet %s", sn.CIDR)
+	}
+
+	addrs := ds.subnetAddrs[subnetID]
+	ones, bits := ipNet.Mask.Size()
+	size := uint32(1) << uint32(bits-ones)
+	network := binary.BigEndian.Uint32(ipNet.IP.To4())
+
+	for i := uint32(1); i < size-1; i++ {
+		ip := make(net.IP, net.IPv4len)
+		binary.BigEndian.PutUint32(ip, network+i)
+
+		if ip.String() == sn.GatewayIP || addrs[ip.String()] {
+			continue
+		}
+
+		addrs[ip.String()] = true
+		return ip, nil
+	}
+
+	return nil, types.ErrSubnetFull
+}
+
+// ClaimSubnetIP reserves a specific address of a tenant subnet for a new
+// instance. The same addresses AllocateSubnetIP never hands out cannot be
+// claimed.
+func (ds *Datastore) ClaimSubnetIP(subnetID string, ip net.IP) error {
+	ds.networksLock.Lock()
+	defer ds.networksLock.Unlock()
+
+	sn, err := ds.getSubnetLocked(subnetID)
+	if err != nil {
+		return err
+	}
+
+	_, ipNet, err := net.ParseCIDR(sn.CIDR)
+	if err != nil {
+		return errors.Wrapf(err, "invalid subnet %s", sn.CIDR)
+	}
+
+	ip = ip.To4()
+	if ip == nil || !ipNet.Contains(ip) {
+		return types.ErrInvalidIP
+	}
+
+	ones, bits := ipNet.Mask.Size()
+	host := binary.BigEndian.Uint32(ip) - binary.BigEndian.Uint32(ipNet.IP.To4())
+	size := uint32(1) << uint32(bits-ones)
+	if host == 0 || host == size-1 || ip.String() == sn.GatewayIP {
+		return types.ErrInvalidIP
+	}
+
+	addrs := ds.subnetAddrs[subnetID]
+	if addrs[ip.String()] {
+		return types.ErrAddressInUse
+	}
+
+	addrs[ip.String()] = true
+
+	return nil
+}
+
+// ReleaseSubnetIP will return an IP address previously allocated from a
+// tenant subnet.
+func (ds *Datastore) ReleaseSubnetIP(subnetID string, ip string) error {
+	ds.networksLock.Lock()
+	defer ds.networksLock.Unlock()
+
+	addrs, ok := ds.subnetAddrs[subnetID]
+	if !ok {
+		return types.ErrSubnetNotFound
+	}
+
+	delete(addrs, ip)
+
+	return nil
+}
+
+// CreateStorageAttachment will associate an instance with a block device in
+// the datastore
+func (ds *Datastore) CreateStorageAttachment(instanceID string, volume payloads.StorageResource) (types.StorageAttachment, error) {
+	link := attachment{
+		instanceID: instanceID,
+		volumeID:   volume.ID,
+	}
+
+	a := types.StorageAttachment{
+		InstanceID: instanceID,
+		ID:         uuid.Generate().String(),
+		BlockID:    volume.ID,
+		Ephemeral:  volume.Ephemeral,
+		Boot:       volume.Bootable,
+		ReadOnly:   volume.ReadOnly,
+	}
+
+	err := ds.db.addStorageAttachment(a)
+	if err != nil {
+		return types.StorageAttachment{}, errors.Wrap(err, "error adding storage attachment to database")
+	}
+
+	// ensure that the volume is marked in use as we have created an attachment
+	bd, err := ds.GetBlockDevice(volume.ID)
+	if err != nil {
+		ds.db.deleteStorageAttachment(a.ID)
+		return types.StorageAttachment{}, errors.Wrapf(err, "error fetching block device (%v)", volume.ID)
+	}
+
+	bd.State = types.InUse
+	err = ds.UpdateBlockDevice(bd)
+	if err != nil {
+		ds.db.deleteStorageAttachment(a.ID)
+		return types.StorageAttachment{}, errors.Wrapf(err, "error updating block device (%v)", volume.ID)
+	}
+
+	// add it to our links map
+	ds.attachLock.Lock()
+	ds.attachments[a.ID] = a
+	ds.instanceVolumes[link] = a.ID
+	ds.attachLock.Unlock()
+
+	return a, nil
+}
+
+// GetStorageAttachments returns a list of volumes associated with this instance.
+func (ds *Datastore) GetStorageAttachments(instanceID string) []types.StorageAttachment {
+	var links []types.StorageAttachment
+
+	ds.attachLock.RLock()
+	for _, a := range ds.attachments {
+		if a.InstanceID == instanceID {
+			links = append(links, a)
+		}
+	}
+	ds.attachLock.RUnlock()
+
+	return links
+}
+
+func (ds *Datastore) updateStorageAttachments(instanceID string, volumes []string) {
+	m := make(map[string]bool)
+
+	// this for handy searching.
+	for _, v := range volumes {
+		m[v] = true
+	}
+
+	// see if we already know about each attachment.
+	ds.attachLock.Lock()
+
+	for _, v := range volumes {
+		key := attachment{
+			instanceID: instanceID,
+			volumeID:   v,
+		}
+
+		_, ok := ds.instanceVolumes[key]
+		if !ok {
+			// add the attachment
+			a := types.StorageAttachment{
+				InstanceID: instanceID,
+				ID:         uuid.Generate().String(),
+				BlockID:    v,
+			}
+			ds.attachments[a.ID] = a
+			ds.instanceVolumes[key] = a.ID
+
+			// not sure what to do with an error here.
+			err := ds.db.addStorageAttachment(a)
 			if err != nil {
-				glog.Warningf("error fetching block device (%v): %v", a.BlockID, err)
+				glog.Warningf("error adding storage attachment to database: %v", err)
 				continue
 			}
 
 			// update the state of the volume.
-			bd.State = types.Available
+			bd, err := ds.GetBlockDevice(v)
+			if err != nil {
+				glog.Warningf("error fetching block device (%v): %v", v, err)
+				// well, maybe we should add it, it obviously
+				// exists.
+				continue
+			}
+
+			bd.State = types.InUse
 			err = ds.UpdateBlockDevice(bd)
 			if err != nil {
-				glog.Warningf("error updating block device (%v): %v", a.BlockID, err)
+				glog.Warningf("error updating block device (%v): %v", v, err)
 			}
+		}
+	}
 
+	// finally, check to see if all the attachments we already
+	// know about are in the list.
+	for _, ID := range ds.instanceVolumes {
+		a := ds.attachments[ID]
+
+		if a.InstanceID == instanceID && !m[a.BlockID] {
 			// delete the attachment.
 			key := attachment{
 				instanceID: a.InstanceID,
@@ -1741,6 +2614,26 @@ func (ds *Datastore) updateStorageAttachments(instanceID string, volumes []strin
 			delete(ds.attachments, ID)
 			delete(ds.instanceVolumes, key)
 
+			bd, err := ds.GetBlockDevice(a.BlockID)
+			if err != nil {
+				glog.Warningf("error fetching block device (%v): %v", a.BlockID, err)
+				go ds.db.deleteStorageAttachment(ID)
+				continue
+			}
+
+			// update the state of the volume.  A multi-attach
+			// volume stays in use until its last attachment
+			// is gone.
+			if ds.volumeAttachedLocked(a.BlockID) {
+				bd.State = types.InUse
+			} else {
+				bd.State = types.Available
+			}
+			err = ds.UpdateBlockDevice(bd)
+			if err != nil {
+				glog.Warningf("error updating block device (%v): %v", a.BlockID, err)
+			}
+
 			// update persistent store asynch.
 			// ok for lock to be held here, but
 			// not needed as the db keeps it's
@@ -1751,6 +2644,18 @@ func (ds *Datastore) updateStorageAttachments(instanceID string, volumes []strin
 	ds.attachLock.Unlock()
 }
 
+// volumeAttachedLocked returns true if at least one instance is attached to
+// the volume.  It must be called with the attachLock held.
+func (ds *Datastore) volumeAttachedLocked(volumeID string) bool {
+	for _, a := range ds.attachments {
+		if a.BlockID == volumeID {
+			return true
+		}
+	}
+
+	return false
+}
+
 func (ds *Datastore) getStorageAttachment(instanceID string, volumeID string) (types.StorageAttachment, error) {
 	var a types.StorageAttachment
 
@@ -1814,6 +2719,21 @@ func (ds *Datastore) GetVolumeAttachments(volume string) ([]types.StorageAttachm
 	return attachments, nil
 }
 
+// GetAllStorageAttachments will return all the attachments known to the
+// datastore.
+func (ds *Datastore) GetAllStorageAttachments() []types.StorageAttachment {
+	ds.attachLock.RLock()
+
+	attachments := make([]types.StorageAttachment, 0, len(ds.attachments))
+	for _, a := range ds.attachments {
+		attachments = append(attachments, a)
+	}
+
+	ds.attachLock.RUnlock()
+
+	return attachments
+}
+
 // GetPool will return an external IP Pool
 func (ds *Datastore) GetPool(ID string) (types.Pool, error) {
 	ds.poolsLock.RLock()
@@ -1998,6 +2918,9 @@ func (ds *Datastore) AddExternalSubnet(poolID string, subnet string) error {
 	}
 
 	ones, bits := ipNet.Mask.Size()
+	if bits == 8*net.IPv6len && bits-ones > 24 {
+		return types.ErrPoolSubnetTooLarge
+	}
 
 	// deduct gateway and broadcast
 	newIPs := (1 << uint32(bits-ones)) - 2
@@ -2084,10 +3007,9 @@ func (ds *Datastore) DeleteSubnet(poolID string, subnetID string) error {
 			return errors.Wrapf(err, "unable to parse subnet CIDR (%v)", sub.CIDR)
 		}
 
-		// check each address in this subnet is not mapped.
+		// check each address in this subnet is not in use.
 		for IP := IP.Mask(ipNet.Mask); ipNet.Contains(IP); incrementIP(IP) {
-			_, ok := ds.mappedIPs[IP.String()]
-			if ok {
+			if ds.externalIPInUse(IP.String()) {
 				return types.ErrPoolNotEmpty
 			}
 		}
@@ -2128,9 +3050,8 @@ func (ds *Datastore) DeleteExternalIP(poolID string, addrID string) error {
 		}
 
 		// this path will be taken only once.
-		// check address is not mapped.
-		_, ok := ds.mappedIPs[extIP.Address]
-		if ok {
+		// check address is not in use.
+		if ds.externalIPInUse(extIP.Address) {
 			return types.ErrPoolNotEmpty
 		}
 
@@ -2214,11 +3135,57 @@ func (ds *Datastore) MapExternalIP(poolID string, instanceID string) (types.Mapp
 		return m, types.ErrPoolEmpty
 	}
 
+	externalIP, internalIP, err := ds.freePoolIP(pool, instance)
+	if err != nil {
+		return m, err
+	}
+
+	m.ID = uuid.Generate().String()
+	m.ExternalIP = externalIP
+	m.InternalIP = internalIP
+	m.InstanceID = instanceID
+	m.TenantID = instance.TenantID
+	m.PoolID = pool.ID
+	m.PoolName = pool.Name
+
+	pool.Free--
+
+	err = ds.db.addMappedIP(m)
+	if err != nil {
+		return types.MappedIP{}, errors.Wrap(err, "error adding IP mapping to database")
+	}
+	ds.mappedIPs[externalIP] = m
+
+	err = ds.db.updatePool(pool)
+	if err != nil {
+		return types.MappedIP{}, errors.Wrap(err, "error updating pool in database")
+	}
+
+	ds.pools[poolID] = pool
+
+	return m, nil
+}
+
+// externalIPInUse returns true if an external IP is mapped to an instance
+// or carries port forwards. The caller must hold poolsLock.
+func (ds *Datastore) externalIPInUse(address string) bool {
+	_, ok := ds.mappedIPs[address]
+	return ok || ds.forwardedIPs[address] > 0
+}
+
+// freePoolIP returns an unused address of a pool along with the private
+// address of the instance it can go to. The caller must hold poolsLock.
+func (ds *Datastore) freePoolIP(pool types.Pool, instance *types.Instance) (string, string, error) {
 	// find a free IP address in any subnet.
 	for _, sub := range pool.Subnets {
 		IP, ipNet, err := net.ParseCIDR(sub.CIDR)
 		if err != nil {
-			return m, errors.Wrapf(err, "error parsing subnet CIDR (%v)", sub.CIDR)
+			return "", "", errors.Wrapf(err, "error parsing subnet CIDR (%v)", sub.CIDR)
+		}
+
+		internalIP := instanceIPFor(instance, IP)
+		if internalIP == "" {
+			continue
 		}
 
 		initIP := IP.Mask(ipNet.Mask)
@@ -2228,70 +3195,39 @@ func (ds *Datastore) MapExternalIP(poolID string, instanceID string) (types.Mapp
 
 		// check each address in this subnet
 		for IP := initIP; ipNet.Contains(IP); incrementIP(IP) {
-			_, ok := ds.mappedIPs[IP.String()]
-			if !ok {
-				m.ID = uuid.Generate().String()
-				m.ExternalIP = IP.String()
-				m.InternalIP = instance.IPAddress
-				m.InstanceID = instanceID
-				m.TenantID = instance.TenantID
-				m.PoolID = pool.ID
-				m.PoolName = pool.Name
-
-				pool.Free--
-
-				err = ds.db.addMappedIP(m)
-				if err != nil {
-					return types.MappedIP{}, errors.Wrap(err, "error adding IP mapping to database")
-				}
-				ds.mappedIPs[IP.String()] = m
-
-				err = ds.db.updatePool(pool)
-				if err != nil {
-					return types.MappedIP{}, errors.Wrap(err, "error updating pool in database")
-				}
-
-				ds.pools[poolID] = pool
-
-				return m, nil
+			if !ds.externalIPInUse(IP.String()) {
+				return IP.String(), internalIP, nil
 			}
 		}
 	}
 
 	// we are still looking. Check our individual IPs
 	for _, IP := range pool.IPs {
-		_, ok := ds.mappedIPs[IP.Address]
-		if !ok {
-			m.ID = uuid.Generate().String()
-			m.ExternalIP = IP.Address
-			m.InternalIP = instance.IPAddress
-			m.InstanceID = instanceID
-			m.TenantID = instance.TenantID
-			m.PoolID = pool.ID
-			m.PoolName = pool.Name
-
-			pool.Free--
-
-			err = ds.db.addMappedIP(m)
-			if err != nil {
-				return types.MappedIP{}, errors.Wrap(err, "error adding IP mapping to database")
-			}
-			ds.mappedIPs[IP.Address] = m
-
-			err = ds.db.updatePool(pool)
-			if err != nil {
-				return types.MappedIP{}, errors.Wrap(err, "error updating pool in database")
-			}
-
-			ds.pools[poolID] = pool
+		internalIP := instanceIPFor(instance, net.ParseIP(IP.Address))
+		if internalIP == "" {
+			continue
+		}
 
-			return m, nil
+		if !ds.externalIPInUse(IP.Address) {
+			return IP.Address, internalIP, nil
 		}
 	}
 
-	// if you got here you are out of luck. But you never should.
+	// if you got here you are out of luck. Unless the instance lacks
+	// an address of the family of the free addresses, you never should.
 	glog.Warningf("Pool reports %d free addresses but none found", pool.Free)
-	return m, types.ErrPoolEmpty
+	return "", "", types.ErrPoolEmpty
+}
+
+// instanceIPFor returns the private address of instance to which the
+// external IP can be mapped. External IPv6 addresses map to the IPv6
+// address of dual stack instances. An empty string is returned if the
+// instance has no address of the same family.
+func instanceIPFor(instance *types.Instance, externalIP net.IP) string {
+	if externalIP.To4() != nil {
+		return instance.IPAddress
+	}
+	return instance.IPv6Address
 }
 
 // UnMapExternalIP will stop associating a given address with an instance.
@@ -2328,6 +3264,171 @@ func (ds *Datastore) UnMapExternalIP(address string) error {
 	return nil
 }
 
+// GetPortForwards will return the port forwards of a tenant, or of all the
+// tenants if tenant is nil.
+func (ds *Datastore) GetPortForwards(tenant *string) []types.PortForward {
+	var forwards []types.PortForward
+
+	ds.poolsLock.RLock()
+	defer ds.poolsLock.RUnlock()
+
+	for _, f := range ds.portForwards {
+		if tenant != nil && f.TenantID != *tenant {
+			continue
+		}
+		forwards = append(forwards, f)
+	}
+
+	return forwards
+}
+
+// GetPortForward will return the port forward with the given ID.
+func (ds *Datastore) GetPortForward(ID string) (types.PortForward, error) {
+	ds.poolsLock.RLock()
+	defer ds.poolsLock.RUnlock()
+
+	f, ok := ds.portForwards[ID]
+	if !ok {
+		return types.PortForward{}, types.ErrPortForwardNotFound
+	}
+
+	return f, nil
+}
+
+// AddPortForward will forward a port of an external IP to an instance. When
+// f.ExternalIP is empty a free address is allocated from pool f.PoolID.
+// Otherwise the address must already carry port forwards of the tenant of
+// the instance.
+func (ds *Datastore) AddPortForward(f types.PortForward) (types.PortForward, error) {
+	instance, err := ds.GetInstance(f.InstanceID)
+	if err != nil {
+		return types.PortForward{}, errors.Wrapf(err, "error getting instance (%v)", f.InstanceID)
+	}
+
+	ds.poolsLock.Lock()
+	defer ds.poolsLock.Unlock()
+
+	f.TenantID = instance.TenantID
+
+	if f.ExternalIP == "" {
+		pool, ok := ds.pools[f.PoolID]
+		if !ok {
+			return types.PortForward{}, types.ErrPoolNotFound
+		}
+
+		if pool.Free == 0 {
+			return types.PortForward{}, types.ErrPoolEmpty
+		}
+
+		f.ExternalIP, f.InternalIP, err = ds.freePoolIP(pool, instance)
+		if err != nil {
+			return types.PortForward{}, err
+		}
+		f.PoolName = pool.Name
+
+		pool.Free--
+
+		err = ds.db.updatePool(pool)
+		if err != nil {
+			return types.PortForward{}, errors.Wrap(err, "error updating pool in database")
+		}
+
+		ds.pools[pool.ID] = pool
+	} else {
+		var shared types.PortForward
+
+		for _, other := range ds.portForwards {
+			if other.ExternalIP != f.ExternalIP {
+				continue
+			}
+
+			if other.TenantID != f.TenantID {
+				return types.PortForward{}, types.ErrAddressNotFound
+			}
+
+			if other.Protocol == f.Protocol && other.ExternalPort == f.ExternalPort {
+				return types.PortForward{}, types.ErrPortForwarded
+			}
+
+			shared = other
+		}
+
+		if shared.ID == "" {
+			return types.PortForward{}, types.ErrAddressNotFound
+		}
+
+		f.InternalIP = instanceIPFor(instance, net.ParseIP(f.ExternalIP))
+		if f.InternalIP == "" {
+			return types.PortForward{}, types.ErrInvalidPortForward
+		}
+		f.PoolID = shared.PoolID
+		f.PoolName = shared.PoolName
+	}
+
+	f.ID = uuid.Generate().String()
+
+	err = ds.db.addPortForward(f)
+	if err != nil {
+		if ds.forwardedIPs[f.ExternalIP] == 0 {
+			_ = ds.releasePoolIP(f.PoolID)
+		}
+		return types.PortForward{}, errors.Wrap(err, "error adding port forward to database")
+	}
+
+	ds.portForwards[f.ID] = f
+	ds.forwardedIPs[f.ExternalIP]++
+
+	return f, nil
+}
+
+// releasePoolIP returns an address to the free addresses of a pool. The
+// caller must hold poolsLock.
+func (ds *Datastore) releasePoolIP(poolID string) error {
+	pool, ok := ds.pools[poolID]
+	if !ok {
+		return types.ErrPoolNotFound
+	}
+
+	pool.Free++
+
+	err := ds.db.updatePool(pool)
+	if err != nil {
+		return errors.Wrap(err, "error updating pool in database")
+	}
+
+	ds.pools[pool.ID] = pool
+
+	return nil
+}
+
+// DeletePortForward will remove a port forward. The external IP of the
+// port forward is returned to its pool when no other port forward uses it,
+// in which case released is true.
+func (ds *Datastore) DeletePortForward(ID string) (f types.PortForward, released bool, err error) {
+	ds.poolsLock.Lock()
+	defer ds.poolsLock.Unlock()
+
+	f, ok := ds.portForwards[ID]
+	if !ok {
+		return f, false, types.ErrPortForwardNotFound
+	}
+
+	err = ds.db.deletePortForward(ID)
+	if err != nil {
+		return f, false, errors.Wrap(err, "error deleting port forward from database")
+	}
+
+	delete(ds.portForwards, ID)
+	ds.forwardedIPs[f.ExternalIP]--
+	if ds.forwardedIPs[f.ExternalIP] > 0 {
+		return f, false, nil
+	}
+
+	delete(ds.forwardedIPs, f.ExternalIP)
+
+	return f, true, ds.releasePoolIP(f.PoolID)
+}
+
 // GenerateCNCIWorkload is used to create a workload definition for the CNCI.
 // This function should be called prior to any workload launch.
 func (ds *Datastore) GenerateCNCIWorkload(vcpus int, memMB int, diskMB int, key string, password string) {
@@ -881,6 +881,133 @@ func TestAllocateTenantIP(t *testing.T) {
 	}
 }
 
+func TestTenantSubnetV6(t *testing.T) {
+	var subnetsV6 []uint16
+
+	ip := net.ParseIP("172.16.0.2")
+
+	for i := 0; i < 2; i++ {
+		tenant, err := addTestTenant()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		subnetV6, err := ds.TenantSubnetV6(tenant.ID, ip)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		again, err := ds.TenantSubnetV6(tenant.ID, ip)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if again != subnetV6 {
+			t.Fatalf("Expected IPv6 subnet %d, got %d", subnetV6, again)
+		}
+
+		// clear out cache
+		ds.tenantsLock.Lock()
+		cached := ds.tenants[tenant.ID]
+		delete(ds.tenants, tenant.ID)
+		ds.tenantsLock.Unlock()
+
+		newTenant, err := ds.getTenant(tenant.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ds.tenantsLock.Lock()
+		ds.tenants[tenant.ID] = cached
+		ds.tenantsLock.Unlock()
+
+		if newTenant.subnetsV6[16<<8] != int(subnetV6) {
+			t.Fatal("IPv6 subnet not stored in database")
+		}
+
+		subnetsV6 = append(subnetsV6, subnetV6)
+	}
+
+	// the same IPv4 subnet of two tenants must not share its IPv6 subnet
+	if subnetsV6[0] == subnetsV6[1] {
+		t.Fatalf("IPv6 subnet %d allocated twice", subnetsV6[0])
+	}
+}
+
+func TestTenantSubnetKey(t *testing.T) {
+	var subnetKeys []int
+
+	for i := 0; i < 2; i++ {
+		tenant, err := addTestTenant()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		subnetKey, err := ds.TenantSubnetKey(tenant.ID, "10.0.0.16/28")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if subnetKey <= 0 || subnetKey > maxSubnetKey {
+			t.Fatalf("Subnet key %d out of range", subnetKey)
+		}
+
+		again, err := ds.TenantSubnetKey(tenant.ID, "10.0.0.16/28")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if again != subnetKey {
+			t.Fatalf("Expected subnet key %d, got %d", subnetKey, again)
+		}
+
+		other, err := ds.TenantSubnetKey(tenant.ID, "10.0.0.32/28")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if other == subnetKey {
+			t.Fatalf("Subnet key %d allocated to two subnets", subnetKey)
+		}
+
+		// clear out cache
+		ds.tenantsLock.Lock()
+		cached := ds.tenants[tenant.ID]
+		delete(ds.tenants, tenant.ID)
+		ds.tenantsLock.Unlock()
+
+		newTenant, err := ds.getTenant(tenant.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ds.tenantsLock.Lock()
+		ds.tenants[tenant.ID] = cached
+		ds.tenantsLock.Unlock()
+
+		if newTenant.subnetKeys["10.0.0.16/28"] != subnetKey {
+			t.Fatal("Subnet key not stored in database")
+		}
+
+		subnetKeys = append(subnetKeys, subnetKey)
+	}
+
+	// the same subnet of two tenants must not share its key
+	if subnetKeys[0] == subnetKeys[1] {
+		t.Fatalf("Subnet key %d allocated twice", subnetKeys[0])
+	}
+
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ds.TenantSubnetKey(tenant.ID, "10.0.0.16")
+	if err != types.ErrBadRequest {
+		t.Fatalf("Expected %v, got %v", types.ErrBadRequest, err)
+	}
+}
+
 func TestGetCNCIWorkloadID(t *testing.T) {
 	_, err := ds.GetCNCIWorkloadID()
 	if err != nil {
@@ -943,6 +1070,57 @@ func TestGetAllTenants(t *testing.T) {
 	// errors.
 }
 
+func TestClaimTenantIP(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ip, err := ds.AllocateTenantIP(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ds.ClaimTenantIP(tenant.ID, ip)
+	if err != types.ErrAddressInUse {
+		t.Fatalf("allocated address claimed: %v", err)
+	}
+
+	for _, addr := range []string{"10.0.0.2", "172.32.0.2", "172.16.5.1", "172.16.5.255"} {
+		err = ds.ClaimTenantIP(tenant.ID, net.ParseIP(addr))
+		if err != types.ErrInvalidIP {
+			t.Fatalf("%s claimed: %v", addr, err)
+		}
+	}
+
+	fixed := net.ParseIP("172.31.200.20")
+	err = ds.ClaimTenantIP(tenant.ID, fixed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newTenant, err := ds.getTenant(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ipBytes := fixed.To4()
+	subnetInt := int(binary.BigEndian.Uint16(ipBytes[1:3]))
+	if newTenant.network[subnetInt][int(ipBytes[3])] != true {
+		t.Fatal("IP Address not claimed in cache")
+	}
+
+	err = ds.ReleaseTenantIP(tenant.ID, fixed.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ds.ClaimTenantIP(tenant.ID, fixed)
+	if err != nil {
+		t.Fatalf("released address not claimed: %v", err)
+	}
+}
+
 func TestAddCNCIIP(t *testing.T) {
 	/* add a new tenant */
 	tuuid := uuid.Generate()
@@ -1471,6 +1649,66 @@ func TestUpdateBlockDevice(t *testing.T) {
 	}
 }
 
+func TestVolumeBackup(t *testing.T) {
+	newTenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := types.VolumeBackup{
+		ID:         uuid.Generate().String(),
+		TenantID:   newTenant.ID,
+		VolumeID:   uuid.Generate().String(),
+		State:      types.BackupCreating,
+		CreateTime: time.Now(),
+		Size:       10,
+	}
+
+	err = ds.AddVolumeBackup(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backups, err := ds.GetVolumeBackups(newTenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(backups) != 1 || backups[0].ID != b.ID {
+		t.Fatalf("expected backup %s for tenant, got %v", b.ID, backups)
+	}
+
+	b.State = types.BackupAvailable
+	err = ds.UpdateVolumeBackup(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b2, err := ds.GetVolumeBackup(b.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if b2.State != types.BackupAvailable {
+		t.Fatalf("expected State == %s, got %s", types.BackupAvailable, b2.State)
+	}
+
+	err = ds.DeleteVolumeBackup(b.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ds.GetVolumeBackup(b.ID)
+	if err != ErrNoVolumeBackup {
+		t.Fatalf("expected %v, got %v", ErrNoVolumeBackup, err)
+	}
+
+	err = ds.UpdateVolumeBackup(b)
+	if err != ErrNoVolumeBackup {
+		t.Fatalf("expected %v, got %v", ErrNoVolumeBackup, err)
+	}
+}
+
 func TestGetBlockDevicesErr(t *testing.T) {
 	// confirm that sending a bad tenant id results in error
 	_, err := ds.GetBlockDevices("badID")
@@ -1730,6 +1968,92 @@ func TestUpdateStorageAttachmentDeleted(t *testing.T) {
 	ds.updateStorageAttachments(instance.ID, attachments)
 }
 
+func TestUpdateStorageAttachmentMultiAttach(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blockDevice := storage.BlockDevice{
+		ID: uuid.Generate().String(),
+	}
+
+	data := types.BlockData{
+		BlockDevice: blockDevice,
+		State:       types.Available,
+		TenantID:    tenant.ID,
+		CreateTime:  time.Now(),
+		MultiAttach: true,
+	}
+
+	err = ds.AddBlockDevice(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wls, err := ds.GetWorkloads(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(wls) == 0 {
+		t.Fatal("No Workloads Found")
+	}
+
+	volume := payloads.StorageResource{
+		ID:       data.ID,
+		ReadOnly: true,
+	}
+
+	var instances []*types.Instance
+	for i := 0; i < 2; i++ {
+		instance, err := addTestInstance(tenant, wls[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = ds.CreateStorageAttachment(instance.ID, volume)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		instances = append(instances, instance)
+	}
+
+	attachments, err := ds.GetVolumeAttachments(data.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(attachments) != 2 || !attachments[0].ReadOnly || !attachments[1].ReadOnly {
+		t.Fatalf("expected 2 read-only attachments, got %v", attachments)
+	}
+
+	// the volume should stay in use while it is still attached
+	// to the second instance.
+	ds.updateStorageAttachments(instances[0].ID, []string{})
+
+	bd, err := ds.GetBlockDevice(data.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bd.State != types.InUse {
+		t.Fatalf("expected state: %s, got %s\n", types.InUse, bd.State)
+	}
+
+	ds.updateStorageAttachments(instances[1].ID, []string{})
+
+	bd, err = ds.GetBlockDevice(data.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bd.State != types.Available {
+		t.Fatalf("expected state: %s, got %s\n", types.Available, bd.State)
+	}
+}
+
 func TestGetStorageAttachment(t *testing.T) {
 	tenant, err := addTestTenant()
 	if err != nil {
@@ -2027,6 +2351,70 @@ func TestGetVolumeAttachments(t *testing.T) {
 	}
 }
 
+func TestGetAllBlockDevicesAndAttachments(t *testing.T) {
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := types.BlockData{
+		BlockDevice: storage.BlockDevice{
+			ID: uuid.Generate().String(),
+		},
+		State:      types.Available,
+		TenantID:   tenant.ID,
+		CreateTime: time.Now(),
+	}
+
+	err = ds.AddBlockDevice(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found := false
+	for _, d := range ds.GetAllBlockDevices() {
+		if d.ID == data.ID {
+			found = true
+			break
+		}
+	}
+
+	if !found {
+		t.Fatalf("block device %s not returned", data.ID)
+	}
+
+	wls, err := ds.GetWorkloads(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(wls) == 0 {
+		t.Fatal("No Workloads Found")
+	}
+
+	instance, err := addTestInstance(tenant, wls[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := ds.CreateStorageAttachment(instance.ID, payloads.StorageResource{ID: data.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found = false
+	for _, sa := range ds.GetAllStorageAttachments() {
+		if sa.ID == a.ID {
+			found = sa.InstanceID == instance.ID && sa.BlockID == data.ID
+			break
+		}
+	}
+
+	if !found {
+		t.Fatalf("storage attachment %s not returned", a.ID)
+	}
+}
+
 func TestAddPool(t *testing.T) {
 	pool := types.Pool{
 		ID:   uuid.Generate().String(),
@@ -2515,6 +2903,120 @@ func TestMapIPs(t *testing.T) {
 	}
 }
 
+func TestPortForwards(t *testing.T) {
+	orig := types.Pool{
+		ID:   uuid.Generate().String(),
+		Name: "test",
+	}
+
+	err := ds.AddPool(orig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ds.AddExternalIPs(orig.ID, []string{"192.168.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool, err := ds.GetPool(orig.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tenant, err := addTestTenant()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wls, err := ds.GetWorkloads(tenant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	instance, err := addTestInstance(tenant, wls[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := ds.AddPortForward(types.PortForward{
+		PoolID:       pool.ID,
+		ExternalPort: 8022,
+		Protocol:     "tcp",
+		InstanceID:   instance.ID,
+		InternalPort: 22,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if f.ExternalIP != "192.168.0.1" || f.InternalIP != instance.IPAddress || f.TenantID != tenant.ID {
+		t.Fatalf("unexpected port forward %v", f)
+	}
+
+	// the forwarded IP can neither be mapped nor removed from the pool
+	_, err = ds.MapExternalIP(pool.ID, instance.ID)
+	if err != types.ErrPoolEmpty {
+		t.Fatalf("forwarded IP mapped: %v", err)
+	}
+
+	err = ds.DeleteExternalIP(pool.ID, pool.IPs[0].ID)
+	if err != types.ErrPoolNotEmpty {
+		t.Fatalf("forwarded IP deleted: %v", err)
+	}
+
+	shared, err := ds.AddPortForward(types.PortForward{
+		ExternalIP:   f.ExternalIP,
+		ExternalPort: 8080,
+		Protocol:     "tcp",
+		InstanceID:   instance.ID,
+		InternalPort: 80,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if shared.PoolID != pool.ID {
+		t.Fatalf("expected pool %s, got %s", pool.ID, shared.PoolID)
+	}
+
+	_, err = ds.AddPortForward(types.PortForward{
+		ExternalIP:   f.ExternalIP,
+		ExternalPort: 8080,
+		Protocol:     "tcp",
+		InstanceID:   instance.ID,
+		InternalPort: 8080,
+	})
+	if err != types.ErrPortForwarded {
+		t.Fatalf("expected %v, got %v", types.ErrPortForwarded, err)
+	}
+
+	if len(ds.GetPortForwards(&tenant.ID)) != 2 {
+		t.Fatal("GetPortForwards failed")
+	}
+
+	_, released, err := ds.DeletePortForward(f.ID)
+	if err != nil || released {
+		t.Fatalf("shared IP released: %v", err)
+	}
+
+	_, released, err = ds.DeletePortForward(shared.ID)
+	if err != nil || !released {
+		t.Fatalf("IP not released: %v", err)
+	}
+
+	_, _, err = ds.DeletePortForward(shared.ID)
+	if err != types.ErrPortForwardNotFound {
+		t.Fatalf("expected %v, got %v", types.ErrPortForwardNotFound, err)
+	}
+
+	// cleanup.
+	err = ds.DeletePool(pool.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestGetMappedIPs(t *testing.T) {
 	orig := types.Pool{
 		ID:   uuid.Generate().String(),
@@ -2633,6 +3135,237 @@ var ds *Datastore
 
 var workloadsPath = flag.String("workloads_path", "../../workloads", "path to yaml files")
 
+func TestSecurityGroups(t *testing.T) {
+	tenantID := uuid.Generate().String()
+	instanceID := uuid.Generate().String()
+
+	orig := types.SecurityGroup{
+		ID:       uuid.Generate().String(),
+		TenantID: tenantID,
+		Name:     "web",
+		Rules: []types.SecurityGroupRule{
+			{
+				ID:       uuid.Generate().String(),
+				Protocol: "tcp",
+				PortMin:  80,
+				PortMax:  80,
+			},
+		},
+	}
+
+	err := ds.AddSecurityGroup(orig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sg, err := ds.GetSecurityGroup(orig.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if reflect.DeepEqual(orig, sg) == false {
+		t.Fatalf("expected %v, got %v\n", orig, sg)
+	}
+
+	// changes to the returned group must not leak into the datastore
+	sg.Rules[0].PortMax = 8080
+	sg.Instances = append(sg.Instances, instanceID)
+	sg2, err := ds.GetSecurityGroup(orig.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sg2.Rules[0].PortMax != 80 || len(sg2.Instances) != 0 {
+		t.Fatalf("security group modified outside of datastore %v", sg2)
+	}
+
+	err = ds.UpdateSecurityGroup(sg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	groups := ds.GetSecurityGroups(&tenantID)
+	if len(groups) != 1 || groups[0].ID != orig.ID {
+		t.Fatalf("unexpected tenant security groups %v", groups)
+	}
+
+	other := uuid.Generate().String()
+	if len(ds.GetSecurityGroups(&other)) != 0 {
+		t.Fatal("security group listed for the wrong tenant")
+	}
+
+	groups = ds.GetInstanceSecurityGroups(instanceID)
+	if len(groups) != 1 || groups[0].Rules[0].PortMax != 8080 {
+		t.Fatalf("unexpected instance security groups %v", groups)
+	}
+
+	err = ds.UpdateSecurityGroup(types.SecurityGroup{ID: uuid.Generate().String()})
+	if err != types.ErrSecurityGroupNotFound {
+		t.Fatal("Updated non existent security group")
+	}
+
+	err = ds.DeleteSecurityGroup(orig.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ds.GetSecurityGroup(orig.ID)
+	if err != types.ErrSecurityGroupNotFound {
+		t.Fatal("Found deleted security group")
+	}
+
+	err = ds.DeleteSecurityGroup(orig.ID)
+	if err != types.ErrSecurityGroupNotFound {
+		t.Fatal("Deleted non existent security group")
+	}
+}
+
+func TestNetworks(t *testing.T) {
+	tenantID := uuid.Generate().String()
+	networkID := uuid.Generate().String()
+
+	orig := types.TenantNetwork{
+		ID:       networkID,
+		TenantID: tenantID,
+		Name:     "private",
+		Subnets: []types.TenantSubnet{
+			{
+				ID:        uuid.Generate().String(),
+				NetworkID: networkID,
+				CIDR:      "10.20.0.0/29",
+				GatewayIP: "10.20.0.1",
+			},
+		},
+	}
+
+	err := ds.AddNetwork(orig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := ds.GetNetwork(orig.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if reflect.DeepEqual(orig, n) == false {
+		t.Fatalf("expected %v, got %v\n", orig, n)
+	}
+
+	subnet := types.TenantSubnet{
+		ID:        uuid.Generate().String(),
+		NetworkID: networkID,
+		CIDR:      "10.20.0.8/29",
+		GatewayIP: "10.20.0.9",
+	}
+
+	err = ds.AddNetworkSubnet(orig.ID, subnet)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	overlap := subnet
+	overlap.ID = uuid.Generate().String()
+	overlap.CIDR = "10.20.0.0/24"
+	err = ds.AddNetworkSubnet(orig.ID, overlap)
+	if err != types.ErrSubnetOverlap {
+		t.Fatalf("overlapping subnet added: %v", err)
+	}
+
+	n, err = ds.GetNetwork(orig.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(n.Subnets) != 2 {
+		t.Fatalf("subnet not added: %v", n)
+	}
+
+	ip, err := ds.AllocateSubnetIP(subnet.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip.String() != "10.20.0.10" {
+		t.Fatalf("allocated %s, expected 10.20.0.10", ip)
+	}
+
+	for _, addr := range []string{"10.20.0.2", "10.20.0.8", "10.20.0.9", "10.20.0.15"} {
+		err = ds.ClaimSubnetIP(subnet.ID, net.ParseIP(addr))
+		if err != types.ErrInvalidIP {
+			t.Fatalf("%s claimed: %v", addr, err)
+		}
+	}
+
+	err = ds.ClaimSubnetIP(subnet.ID, ip)
+	if err != types.ErrAddressInUse {
+		t.Fatalf("allocated address claimed: %v", err)
+	}
+
+	fixed := net.ParseIP("10.20.0.14")
+	err = ds.ClaimSubnetIP(subnet.ID, fixed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ds.ReleaseSubnetIP(subnet.ID, fixed.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	options := types.SubnetDHCPOptions{
+		DNSNameservers: []string{"10.20.0.13"},
+		NTPServers:     []string{"10.20.0.14"},
+	}
+	sn, err := ds.UpdateSubnetDHCPOptions(orig.ID, subnet.ID, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(sn.SubnetDHCPOptions, options) || sn.CIDR != subnet.CIDR {
+		t.Fatalf("expected options %v, got %v", options, sn)
+	}
+
+	sn, err = ds.GetSubnet(subnet.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(sn.SubnetDHCPOptions, options) {
+		t.Fatalf("options not stored: %v", sn)
+	}
+
+	_, err = ds.UpdateSubnetDHCPOptions(orig.ID, uuid.Generate().String(), options)
+	if err != types.ErrSubnetNotFound {
+		t.Fatalf("options of unknown subnet updated: %v", err)
+	}
+
+	err = ds.DeleteNetworkSubnet(orig.ID, subnet.ID)
+	if err != types.ErrNetworkInUse {
+		t.Fatalf("subnet with addresses deleted: %v", err)
+	}
+
+	err = ds.ReleaseSubnetIP(subnet.ID, ip.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ds.DeleteNetworkSubnet(orig.ID, subnet.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other := uuid.Generate().String()
+	if len(ds.GetNetworks(&other)) != 0 {
+		t.Fatal("network listed for the wrong tenant")
+	}
+
+	err = ds.DeleteNetwork(orig.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ds.GetNetwork(orig.ID)
+	if err != types.ErrNetworkNotFound {
+		t.Fatal("Found deleted network")
+	}
+}
+
 func TestMain(m *testing.M) {
 	flag.Parse()
 
@@ -126,6 +126,14 @@ func (db *MemoryDB) claimTenantIP(tenantID string, subnetInt int, rest int) erro
 	return nil
 }
 
+func (db *MemoryDB) claimTenantSubnetV6(tenantID string, subnetInt int, subnetV6 int) error {
+	return nil
+}
+
+func (db *MemoryDB) claimTenantSubnetKey(tenantID string, subnet string, subnetKey int) error {
+	return nil
+}
+
 func (db *MemoryDB) getInstances() ([]*types.Instance, error) {
 	var instances []*types.Instance
 	for _, instance := range db.instances {
@@ -202,6 +210,22 @@ func (db *MemoryDB) deleteStorageAttachment(ID string) error {
 	return nil
 }
 
+func (db *MemoryDB) getAllVolumeBackups() (map[string]types.VolumeBackup, error) {
+	return make(map[string]types.VolumeBackup), nil
+}
+
+func (db *MemoryDB) addVolumeBackup(b types.VolumeBackup) error {
+	return nil
+}
+
+func (db *MemoryDB) updateVolumeBackup(b types.VolumeBackup) error {
+	return nil
+}
+
+func (db *MemoryDB) deleteVolumeBackup(ID string) error {
+	return nil
+}
+
 func (db *MemoryDB) addPool(pool types.Pool) error {
 	return nil
 }
@@ -230,6 +254,50 @@ func (db *MemoryDB) getMappedIPs() map[string]types.MappedIP {
 	return make(map[string]types.MappedIP)
 }
 
+func (db *MemoryDB) addPortForward(f types.PortForward) error {
+	return nil
+}
+
+func (db *MemoryDB) deletePortForward(ID string) error {
+	return nil
+}
+
+func (db *MemoryDB) getPortForwards() map[string]types.PortForward {
+	return make(map[string]types.PortForward)
+}
+
+func (db *MemoryDB) addSecurityGroup(sg types.SecurityGroup) error {
+	return nil
+}
+
+func (db *MemoryDB) updateSecurityGroup(sg types.SecurityGroup) error {
+	return nil
+}
+
+func (db *MemoryDB) deleteSecurityGroup(ID string) error {
+	return nil
+}
+
+func (db *MemoryDB) getAllSecurityGroups() (map[string]types.SecurityGroup, error) {
+	return make(map[string]types.SecurityGroup), nil
+}
+
+func (db *MemoryDB) addNetwork(n types.TenantNetwork) error {
+	return nil
+}
+
+func (db *MemoryDB) updateNetwork(n types.TenantNetwork) error {
+	return nil
+}
+
+func (db *MemoryDB) deleteNetwork(ID string) error {
+	return nil
+}
+
+func (db *MemoryDB) getAllNetworks() (map[string]types.TenantNetwork, error) {
+	return make(map[string]types.TenantNetwork), nil
+}
+
 func (db *MemoryDB) updateWorkload(wl types.Workload) error {
 	return nil
 }
@@ -109,6 +109,42 @@ func (d subnetData) Init() error {
 	return d.ds.exec(d.db, cmd)
 }
 
+type subnetV6Data struct {
+	namedData
+}
+
+func (d subnetV6Data) Init() error {
+	cmd := `CREATE TABLE IF NOT EXISTS tenant_subnets_v6
+		(
+		tenant_id varchar(32),
+		subnet int,
+		subnet_v6 int,
+		unique(tenant_id, subnet),
+		unique(subnet_v6),
+		foreign key(tenant_id) references tenants(id)
+		);`
+
+	return d.ds.exec(d.db, cmd)
+}
+
+type subnetKeyData struct {
+	namedData
+}
+
+func (d subnetKeyData) Init() error {
+	cmd := `CREATE TABLE IF NOT EXISTS tenant_subnet_keys
+		(
+		tenant_id varchar(32),
+		subnet string,
+		subnet_key int,
+		unique(tenant_id, subnet),
+		unique(subnet_key),
+		foreign key(tenant_id) references tenants(id)
+		);`
+
+	return d.ds.exec(d.db, cmd)
+}
+
 // Handling of Instance specific data
 type instanceData struct {
 	namedData
@@ -124,6 +160,8 @@ func (d instanceData) Init() error {
 		vnic_uuid string,
 		subnet string,
 		ip string,
+		ipv6 string,
+		subnet_id string,
 		create_time DATETIME,
 		foreign key(tenant_id) references tenants(id),
 		foreign key(workload_id) references workload_template(id),
@@ -148,6 +186,15 @@ func (d blockData) Init() error {
 		create_time DATETIME,
 		name string,
 		description string,
+		total_bytes_sec integer,
+		read_bytes_sec integer,
+		write_bytes_sec integer,
+		total_iops_sec integer,
+		read_iops_sec integer,
+		write_iops_sec integer,
+		multiattach int,
+		encryption_key string,
+		image_id string,
 		foreign key(tenant_id) references tenants(id)
 		);`
 
@@ -166,6 +213,7 @@ func (d attachments) Init() error {
 		block_id string,
 		ephemeral int,
 		boot int,
+		read_only int,
 		foreign key(instance_id) references instances(id),
 		foreign key(block_id) references block_data(id)
 		);`
@@ -173,6 +221,37 @@ func (d attachments) Init() error {
 	return d.ds.exec(d.db, cmd)
 }
 
+// Volume backups
+type volumeBackupData struct {
+	namedData
+}
+
+func (d volumeBackupData) Init() error {
+	cmd := `CREATE TABLE IF NOT EXISTS volume_backups
+		(
+		id string primary key,
+		tenant_id string,
+		volume_id string,
+		state string,
+		create_time DATETIME,
+		name string,
+		description string,
+		size integer,
+		fail_reason string,
+		encryption_key string,
+		total_bytes_sec integer,
+		read_bytes_sec integer,
+		write_bytes_sec integer,
+		total_iops_sec integer,
+		read_iops_sec integer,
+		write_iops_sec integer,
+		multiattach int,
+		foreign key(tenant_id) references tenants(id)
+		);`
+
+	return d.ds.exec(d.db, cmd)
+}
+
 // workload storage resources
 
 type workloadStorage struct {
@@ -441,6 +520,161 @@ func (d mappedIPData) Init() error {
 	return d.ds.exec(d.db, cmd)
 }
 
+type portForwardData struct {
+	namedData
+}
+
+func (d portForwardData) Init() error {
+	cmd := `CREATE TABLE IF NOT EXISTS port_forwards
+		(
+			id varchar(32) primary key,
+			pool_id varchar(32),
+			external_ip string,
+			external_port integer,
+			protocol string,
+			tenant_id varchar(32),
+			instance_id varchar(32),
+			internal_ip string,
+			internal_port integer
+		);`
+
+	return d.ds.exec(d.db, cmd)
+}
+
+type securityGroupData struct {
+	namedData
+}
+
+func (d securityGroupData) Init() error {
+	cmd := `CREATE TABLE IF NOT EXISTS security_groups
+		(
+			id varchar(32) primary key,
+			tenant_id varchar(32),
+			name string,
+			description string
+		);`
+
+	return d.ds.exec(d.db, cmd)
+}
+
+type securityRuleData struct {
+	namedData
+}
+
+func (d securityRuleData) Init() error {
+	cmd := `CREATE TABLE IF NOT EXISTS security_group_rules
+		(
+			id varchar(32) primary key,
+			group_id varchar(32),
+			protocol string,
+			port_min int,
+			port_max int,
+			remote_cidr string
+		);`
+
+	return d.ds.exec(d.db, cmd)
+}
+
+type securityMemberData struct {
+	namedData
+}
+
+func (d securityMemberData) Init() error {
+	cmd := `CREATE TABLE IF NOT EXISTS security_group_instances
+		(
+			group_id varchar(32),
+			instance_id varchar(32),
+			unique(group_id, instance_id)
+		);`
+
+	return d.ds.exec(d.db, cmd)
+}
+
+type networkData struct {
+	namedData
+}
+
+func (d networkData) Init() error {
+	cmd := `CREATE TABLE IF NOT EXISTS networks
+		(
+			id varchar(32) primary key,
+			tenant_id varchar(32),
+			name string
+		);`
+
+	return d.ds.exec(d.db, cmd)
+}
+
+type networkSubnetData struct {
+	namedData
+}
+
+func (d networkSubnetData) Init() error {
+	cmd := `CREATE TABLE IF NOT EXISTS network_subnets
+		(
+			id varchar(32) primary key,
+			network_id varchar(32),
+			name string,
+			cidr string,
+			gateway_ip string
+		);`
+
+	return d.ds.exec(d.db, cmd)
+}
+
+// instanceNICData holds the additional NICs of the instances, the primary
+// NIC is stored with the instance itself.
+type instanceNICData struct {
+	namedData
+}
+
+func (d instanceNICData) Init() error {
+	cmd := `CREATE TABLE IF NOT EXISTS instance_nics
+		(
+			instance_id varchar(32),
+			nic_index int,
+			vnic_uuid varchar(32),
+			mac_address string,
+			ip string,
+			subnet string,
+			subnet_id varchar(32),
+			network_id varchar(32),
+			unique(instance_id, nic_index)
+		);`
+
+	return d.ds.exec(d.db, cmd)
+}
+
+type networkSubnetOptionData struct {
+	namedData
+}
+
+func (d networkSubnetOptionData) Init() error {
+	cmd := `CREATE TABLE IF NOT EXISTS network_subnet_options
+		(
+			subnet_id varchar(32),
+			option string,
+			value string
+		);`
+
+	return d.ds.exec(d.db, cmd)
+}
+
+type networkSubnetRouteData struct {
+	namedData
+}
+
+func (d networkSubnetRouteData) Init() error {
+	cmd := `CREATE TABLE IF NOT EXISTS network_subnet_routes
+		(
+			subnet_id varchar(32),
+			destination string,
+			nexthop string
+		);`
+
+	return d.ds.exec(d.db, cmd)
+}
+
 type quotaData struct {
 	namedData
 }
@@ -551,16 +785,28 @@ func (ds *sqliteDB) init(config Config) error {
 		nodeStatisticsData{namedData{ds: ds, name: "node_statistics", db: ds.tdb}},
 		logData{namedData{ds: ds, name: "log", db: ds.tdb}},
 		subnetData{namedData{ds: ds, name: "tenant_network", db: ds.db}},
+		subnetV6Data{namedData{ds: ds, name: "tenant_subnets_v6", db: ds.db}},
+		subnetKeyData{namedData{ds: ds, name: "tenant_subnet_keys", db: ds.db}},
 		instanceStatisticsData{namedData{ds: ds, name: "instance_statistics", db: ds.tdb}},
 		frameStatisticsData{namedData{ds: ds, name: "frame_statistics", db: ds.tdb}},
 		traceData{namedData{ds: ds, name: "trace_data", db: ds.tdb}},
 		blockData{namedData{ds: ds, name: "block_data", db: ds.db}},
 		attachments{namedData{ds: ds, name: "attachments", db: ds.db}},
 		workloadStorage{namedData{ds: ds, name: "workload_storage", db: ds.db}},
+		volumeBackupData{namedData{ds: ds, name: "volume_backups", db: ds.db}},
 		poolData{namedData{ds: ds, name: "pools", db: ds.db}},
 		subnetPoolData{namedData{ds: ds, name: "subnet_pool", db: ds.db}},
 		addressData{namedData{ds: ds, name: "address_pool", db: ds.db}},
 		mappedIPData{namedData{ds: ds, name: "mapped_ips", db: ds.db}},
+		portForwardData{namedData{ds: ds, name: "port_forwards", db: ds.db}},
+		securityGroupData{namedData{ds: ds, name: "security_groups", db: ds.db}},
+		securityRuleData{namedData{ds: ds, name: "security_group_rules", db: ds.db}},
+		securityMemberData{namedData{ds: ds, name: "security_group_instances", db: ds.db}},
+		networkData{namedData{ds: ds, name: "networks", db: ds.db}},
+		networkSubnetData{namedData{ds: ds, name: "network_subnets", db: ds.db}},
+		networkSubnetOptionData{namedData{ds: ds, name: "network_subnet_options", db: ds.db}},
+		networkSubnetRouteData{namedData{ds: ds, name: "network_subnet_routes", db: ds.db}},
+		instanceNICData{namedData{ds: ds, name: "instance_nics", db: ds.db}},
 		quotaData{namedData{ds: ds, name: "quotas", db: ds.db}},
 	}
 
@@ -857,6 +1103,16 @@ func (ds *sqliteDB) getTenant(ID string) (*tenant, error) {
 		glog.V(2).Info(err)
 	}
 
+	err = ds.getTenantSubnetsV6(t)
+	if err != nil {
+		glog.V(2).Info(err)
+	}
+
+	err = ds.getTenantSubnetKeys(t)
+	if err != nil {
+		glog.V(2).Info(err)
+	}
+
 	t.instances, err = ds.getTenantInstances(t.ID)
 	if err != nil {
 		glog.V(2).Info(err)
@@ -1119,6 +1375,16 @@ func (ds *sqliteDB) getTenants() ([]*tenant, error) {
 			return nil, err
 		}
 
+		err = ds.getTenantSubnetsV6(t)
+		if err != nil {
+			return nil, err
+		}
+
+		err = ds.getTenantSubnetKeys(t)
+		if err != nil {
+			return nil, err
+		}
+
 		t.instances, err = ds.getTenantInstances(t.ID)
 		if err != nil {
 			return nil, err
@@ -1188,6 +1454,112 @@ func (ds *sqliteDB) releaseTenantIP(tenantID string, subnetInt int, rest int) er
 	return err
 }
 
+func (ds *sqliteDB) claimTenantSubnetV6(tenantID string, subnetInt int, subnetV6 int) error {
+	datastore := ds.getTableDB("tenant_subnets_v6")
+
+	ds.dbLock.Lock()
+	defer ds.dbLock.Unlock()
+
+	tx, err := datastore.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("INSERT INTO tenant_subnets_v6 VALUES(?, ?, ?)", tenantID, subnetInt, subnetV6)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (ds *sqliteDB) getTenantSubnetsV6(tenant *tenant) error {
+	tenant.subnetsV6 = make(map[int]int)
+
+	datastore := ds.getTableDB("tenant_subnets_v6")
+
+	ds.dbLock.Lock()
+	defer ds.dbLock.Unlock()
+
+	query := `SELECT subnet, subnet_v6
+		  FROM tenant_subnets_v6
+		  WHERE tenant_id = ?`
+
+	rows, err := datastore.Query(query, tenant.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var subnetInt int
+		var subnetV6 int
+
+		err = rows.Scan(&subnetInt, &subnetV6)
+		if err != nil {
+			return err
+		}
+
+		tenant.subnetsV6[subnetInt] = subnetV6
+	}
+
+	return rows.Err()
+}
+
+func (ds *sqliteDB) claimTenantSubnetKey(tenantID string, subnet string, subnetKey int) error {
+	datastore := ds.getTableDB("tenant_subnet_keys")
+
+	ds.dbLock.Lock()
+	defer ds.dbLock.Unlock()
+
+	tx, err := datastore.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("INSERT INTO tenant_subnet_keys VALUES(?, ?, ?)", tenantID, subnet, subnetKey)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (ds *sqliteDB) getTenantSubnetKeys(tenant *tenant) error {
+	tenant.subnetKeys = make(map[string]int)
+
+	datastore := ds.getTableDB("tenant_subnet_keys")
+
+	ds.dbLock.Lock()
+	defer ds.dbLock.Unlock()
+
+	query := `SELECT subnet, subnet_key
+		  FROM tenant_subnet_keys
+		  WHERE tenant_id = ?`
+
+	rows, err := datastore.Query(query, tenant.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var subnet string
+		var subnetKey int
+
+		err = rows.Scan(&subnet, &subnetKey)
+		if err != nil {
+			return err
+		}
+
+		tenant.subnetKeys[subnet] = subnetKey
+	}
+
+	return rows.Err()
+}
+
 func (ds *sqliteDB) getTenantNetwork(tenant *tenant) error {
 	tenant.network = make(map[int]map[int]bool)
 
@@ -1283,7 +1655,9 @@ func (ds *sqliteDB) getInstances() ([]*types.Instance, error) {
 		mac_address,
 		vnic_uuid,
 		subnet,
-		ip
+		ip,
+		IFNULL(ipv6, ""),
+		IFNULL(subnet_id, "")
 	FROM instances
 	LEFT JOIN latest
 	ON instances.id = latest.instance_id
@@ -1302,7 +1676,7 @@ func (ds *sqliteDB) getInstances() ([]*types.Instance, error) {
 
 		var sshPort sql.NullInt64
 
-		err = rows.Scan(&i.ID, &i.TenantID, &i.State, &i.WorkloadID, &i.SSHIP, &sshPort, &i.NodeID, &i.MACAddress, &i.VnicUUID, &i.Subnet, &i.IPAddress)
+		err = rows.Scan(&i.ID, &i.TenantID, &i.State, &i.WorkloadID, &i.SSHIP, &sshPort, &i.NodeID, &i.MACAddress, &i.VnicUUID, &i.Subnet, &i.IPAddress, &i.IPv6Address, &i.SubnetID)
 		if err != nil {
 			tx.Rollback()
 			ds.tdbLock.RUnlock()
@@ -1326,6 +1700,15 @@ func (ds *sqliteDB) getInstances() ([]*types.Instance, error) {
 
 	ds.tdbLock.RUnlock()
 
+	nics, err := ds.getInstanceNICs()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, i := range instances {
+		i.ExtraNICs = nics[i.ID]
+	}
+
 	return instances, nil
 }
 
@@ -1362,7 +1745,9 @@ func (ds *sqliteDB) getTenantInstances(tenantID string) (map[string]*types.Insta
 		mac_address,
 		vnic_uuid,
 		subnet,
-		ip
+		ip,
+		IFNULL(ipv6, ""),
+		IFNULL(subnet_id, "")
 	FROM instances
 	LEFT JOIN latest
 	ON instances.id = latest.instance_id
@@ -1385,7 +1770,7 @@ func (ds *sqliteDB) getTenantInstances(tenantID string) (map[string]*types.Insta
 
 		i := &types.Instance{}
 
-		err = rows.Scan(&i.ID, &i.TenantID, &i.State, &sshIP, &sshPort, &i.WorkloadID, &nodeID, &i.MACAddress, &i.VnicUUID, &i.Subnet, &i.IPAddress)
+		err = rows.Scan(&i.ID, &i.TenantID, &i.State, &sshIP, &sshPort, &i.WorkloadID, &nodeID, &i.MACAddress, &i.VnicUUID, &i.Subnet, &i.IPAddress, &i.IPv6Address, &i.SubnetID)
 		if err != nil {
 			tx.Rollback()
 			ds.tdbLock.RUnlock()
@@ -1417,30 +1802,94 @@ func (ds *sqliteDB) getTenantInstances(tenantID string) (map[string]*types.Insta
 
 	ds.tdbLock.RUnlock()
 
-	return instances, nil
-}
-
-func (ds *sqliteDB) addInstance(instance *types.Instance) error {
-	ds.dbLock.Lock()
+	nics, err := ds.getInstanceNICs()
+	if err != nil {
+		return nil, err
+	}
 
-	err := ds.create("instances", instance.ID, instance.TenantID, instance.WorkloadID, instance.MACAddress, instance.VnicUUID, instance.Subnet, instance.IPAddress, instance.CreateTime.Format(time.RFC3339Nano))
+	for _, i := range instances {
+		i.ExtraNICs = nics[i.ID]
+	}
 
-	ds.dbLock.Unlock()
-	return err
+	return instances, nil
 }
 
-func (ds *sqliteDB) deleteInstance(instanceID string) error {
-	datastore := ds.getTableDB("instances")
+// getInstanceNICs returns the additional NICs of all instances, indexed
+// by instance ID and sorted in the order they were attached.
+func (ds *sqliteDB) getInstanceNICs() (map[string][]types.InstanceNIC, error) {
+	nics := make(map[string][]types.InstanceNIC)
 
-	ds.dbLock.Lock()
+	datastore := ds.getTableDB("instance_nics")
 
-	tx, err := datastore.Begin()
+	query := `SELECT	instance_id,
+				vnic_uuid,
+				mac_address,
+				ip,
+				subnet,
+				subnet_id,
+				network_id
+		  FROM	instance_nics
+		  ORDER BY instance_id, nic_index`
+
+	rows, err := datastore.Query(query)
 	if err != nil {
-		ds.tdbLock.Unlock()
-		return err
+		return nil, err
 	}
+	defer rows.Close()
 
-	_, err = tx.Exec("DELETE FROM instances WHERE id = ?", instanceID)
+	for rows.Next() {
+		var instanceID string
+		var nic types.InstanceNIC
+
+		err = rows.Scan(&instanceID, &nic.VnicUUID, &nic.MACAddress, &nic.IPAddress, &nic.Subnet, &nic.SubnetID, &nic.NetworkID)
+		if err != nil {
+			continue
+		}
+
+		nics[instanceID] = append(nics[instanceID], nic)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return nics, nil
+}
+
+func (ds *sqliteDB) addInstance(instance *types.Instance) error {
+	ds.dbLock.Lock()
+
+	err := ds.create("instances", instance.ID, instance.TenantID, instance.WorkloadID, instance.MACAddress, instance.VnicUUID, instance.Subnet, instance.IPAddress, instance.IPv6Address, instance.SubnetID, instance.CreateTime.Format(time.RFC3339Nano))
+
+	for k, nic := range instance.ExtraNICs {
+		if err != nil {
+			break
+		}
+		err = ds.create("instance_nics", instance.ID, k+1, nic.VnicUUID, nic.MACAddress, nic.IPAddress, nic.Subnet, nic.SubnetID, nic.NetworkID)
+	}
+
+	ds.dbLock.Unlock()
+	return err
+}
+
+func (ds *sqliteDB) deleteInstance(instanceID string) error {
+	datastore := ds.getTableDB("instances")
+
+	ds.dbLock.Lock()
+
+	tx, err := datastore.Begin()
+	if err != nil {
+		ds.tdbLock.Unlock()
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM instances WHERE id = ?", instanceID)
+	if err != nil {
+		tx.Rollback()
+		ds.dbLock.Unlock()
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM instance_nics WHERE instance_id = ?", instanceID)
 	if err != nil {
 		tx.Rollback()
 		ds.dbLock.Unlock()
@@ -1924,7 +2373,16 @@ func (ds *sqliteDB) getTenantDevices(tenantID string) (map[string]types.BlockDat
 				block_data.state,
 				block_data.create_time,
 				block_data.name,
-				block_data.description
+				block_data.description,
+				block_data.total_bytes_sec,
+				block_data.read_bytes_sec,
+				block_data.write_bytes_sec,
+				block_data.total_iops_sec,
+				block_data.read_iops_sec,
+				block_data.write_iops_sec,
+				block_data.multiattach,
+				block_data.encryption_key,
+				block_data.image_id
 		  FROM	block_data
 		  WHERE block_data.tenant_id = ?`
 
@@ -1939,7 +2397,10 @@ func (ds *sqliteDB) getTenantDevices(tenantID string) (map[string]types.BlockDat
 		var state string
 		var data types.BlockData
 
-		err = rows.Scan(&data.ID, &data.TenantID, &data.Size, &state, &data.CreateTime, &data.Name, &data.Description)
+		err = rows.Scan(&data.ID, &data.TenantID, &data.Size, &state, &data.CreateTime, &data.Name, &data.Description,
+			&data.IOLimits.TotalBytesSec, &data.IOLimits.ReadBytesSec, &data.IOLimits.WriteBytesSec,
+			&data.IOLimits.TotalIOPSSec, &data.IOLimits.ReadIOPSSec, &data.IOLimits.WriteIOPSSec,
+			&data.MultiAttach, &data.EncryptionKey, &data.ImageID)
 		if err != nil {
 			continue
 		}
@@ -1968,7 +2429,16 @@ func (ds *sqliteDB) getAllBlockData() (map[string]types.BlockData, error) {
 				block_data.state,
 				block_data.create_time,
 				block_data.name,
-				block_data.description
+				block_data.description,
+				block_data.total_bytes_sec,
+				block_data.read_bytes_sec,
+				block_data.write_bytes_sec,
+				block_data.total_iops_sec,
+				block_data.read_iops_sec,
+				block_data.write_iops_sec,
+				block_data.multiattach,
+				block_data.encryption_key,
+				block_data.image_id
 		  FROM	block_data `
 
 	rows, err := datastore.Query(query)
@@ -1981,7 +2451,10 @@ func (ds *sqliteDB) getAllBlockData() (map[string]types.BlockData, error) {
 		var data types.BlockData
 		var state string
 
-		err = rows.Scan(&data.ID, &data.TenantID, &data.Size, &state, &data.CreateTime, &data.Name, &data.Description)
+		err = rows.Scan(&data.ID, &data.TenantID, &data.Size, &state, &data.CreateTime, &data.Name, &data.Description,
+			&data.IOLimits.TotalBytesSec, &data.IOLimits.ReadBytesSec, &data.IOLimits.WriteBytesSec,
+			&data.IOLimits.TotalIOPSSec, &data.IOLimits.ReadIOPSSec, &data.IOLimits.WriteIOPSSec,
+			&data.MultiAttach, &data.EncryptionKey, &data.ImageID)
 		if err != nil {
 			continue
 		}
@@ -1998,13 +2471,17 @@ func (ds *sqliteDB) getAllBlockData() (map[string]types.BlockData, error) {
 
 func (ds *sqliteDB) addBlockData(data types.BlockData) error {
 	ds.dbLock.Lock()
-	err := ds.create("block_data", data.ID, data.TenantID, data.Size, string(data.State), data.CreateTime.Format(time.RFC3339Nano), data.Name, data.Description)
+	l := &data.IOLimits
+	err := ds.create("block_data", data.ID, data.TenantID, data.Size, string(data.State), data.CreateTime.Format(time.RFC3339Nano), data.Name, data.Description,
+		l.TotalBytesSec, l.ReadBytesSec, l.WriteBytesSec, l.TotalIOPSSec, l.ReadIOPSSec, l.WriteIOPSSec,
+		data.MultiAttach, data.EncryptionKey, data.ImageID)
 	ds.dbLock.Unlock()
 
 	return err
 }
 
-// For now we only support updating the state.
+// For now we only support updating the state, the name, the description
+// and the I/O limits.
 func (ds *sqliteDB) updateBlockData(data types.BlockData) error {
 	db := ds.getTableDB("block_data")
 
@@ -2016,7 +2493,14 @@ func (ds *sqliteDB) updateBlockData(data types.BlockData) error {
 		return err
 	}
 
-	_, err = tx.Exec("UPDATE block_data SET state = ? WHERE id = ?", string(data.State), data.ID)
+	l := &data.IOLimits
+	_, err = tx.Exec(`UPDATE block_data SET state = ?, name = ?, description = ?,
+				total_bytes_sec = ?, read_bytes_sec = ?, write_bytes_sec = ?,
+				total_iops_sec = ?, read_iops_sec = ?, write_iops_sec = ?
+			  WHERE id = ?`,
+		string(data.State), data.Name, data.Description,
+		l.TotalBytesSec, l.ReadBytesSec, l.WriteBytesSec,
+		l.TotalIOPSSec, l.ReadIOPSSec, l.WriteIOPSSec, data.ID)
 	if err != nil {
 		tx.Rollback()
 		ds.dbLock.Unlock()
@@ -2053,6 +2537,121 @@ func (ds *sqliteDB) deleteBlockData(ID string) error {
 	return err
 }
 
+func (ds *sqliteDB) getAllVolumeBackups() (map[string]types.VolumeBackup, error) {
+	backups := make(map[string]types.VolumeBackup)
+
+	datastore := ds.getTableDB("volume_backups")
+
+	query := `SELECT	volume_backups.id,
+				volume_backups.tenant_id,
+				volume_backups.volume_id,
+				volume_backups.state,
+				volume_backups.create_time,
+				volume_backups.name,
+				volume_backups.description,
+				volume_backups.size,
+				volume_backups.fail_reason,
+				volume_backups.encryption_key,
+				volume_backups.total_bytes_sec,
+				volume_backups.read_bytes_sec,
+				volume_backups.write_bytes_sec,
+				volume_backups.total_iops_sec,
+				volume_backups.read_iops_sec,
+				volume_backups.write_iops_sec,
+				volume_backups.multiattach
+		  FROM	volume_backups `
+
+	rows, err := datastore.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var b types.VolumeBackup
+		var state string
+
+		err = rows.Scan(&b.ID, &b.TenantID, &b.VolumeID, &state, &b.CreateTime,
+			&b.Name, &b.Description, &b.Size, &b.FailReason, &b.EncryptionKey,
+			&b.IOLimits.TotalBytesSec, &b.IOLimits.ReadBytesSec, &b.IOLimits.WriteBytesSec,
+			&b.IOLimits.TotalIOPSSec, &b.IOLimits.ReadIOPSSec, &b.IOLimits.WriteIOPSSec,
+			&b.MultiAttach)
+		if err != nil {
+			continue
+		}
+
+		b.State = types.BackupState(state)
+		backups[b.ID] = b
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return backups, nil
+}
+
+func (ds *sqliteDB) addVolumeBackup(b types.VolumeBackup) error {
+	ds.dbLock.Lock()
+	err := ds.create("volume_backups", b.ID, b.TenantID, b.VolumeID, string(b.State),
+		b.CreateTime.Format(time.RFC3339Nano), b.Name, b.Description, b.Size, b.FailReason,
+		b.EncryptionKey, b.IOLimits.TotalBytesSec, b.IOLimits.ReadBytesSec, b.IOLimits.WriteBytesSec,
+		b.IOLimits.TotalIOPSSec, b.IOLimits.ReadIOPSSec, b.IOLimits.WriteIOPSSec, b.MultiAttach)
+	ds.dbLock.Unlock()
+
+	return err
+}
+
+// Only the state, size and failure reason of a backup change
+// after it has been created.
+func (ds *sqliteDB) updateVolumeBackup(b types.VolumeBackup) error {
+	db := ds.getTableDB("volume_backups")
+
+	ds.dbLock.Lock()
+
+	tx, err := db.Begin()
+	if err != nil {
+		ds.dbLock.Unlock()
+		return err
+	}
+
+	_, err = tx.Exec("UPDATE volume_backups SET state = ?, size = ?, fail_reason = ? WHERE id = ?",
+		string(b.State), b.Size, b.FailReason, b.ID)
+	if err != nil {
+		tx.Rollback()
+		ds.dbLock.Unlock()
+		return err
+	}
+
+	tx.Commit()
+
+	ds.dbLock.Unlock()
+
+	return err
+}
+
+func (ds *sqliteDB) deleteVolumeBackup(ID string) error {
+	datastore := ds.getTableDB("volume_backups")
+
+	ds.dbLock.Lock()
+	tx, err := datastore.Begin()
+	if err != nil {
+		ds.dbLock.Unlock()
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM volume_backups WHERE id = ?", ID)
+	if err != nil {
+		tx.Rollback()
+		ds.dbLock.Unlock()
+		return err
+	}
+
+	tx.Commit()
+	ds.dbLock.Unlock()
+
+	return err
+}
+
 func (ds *sqliteDB) addStorageAttachment(a types.StorageAttachment) error {
 	datastore := ds.getTableDB("attachments")
 
@@ -2064,7 +2663,7 @@ func (ds *sqliteDB) addStorageAttachment(a types.StorageAttachment) error {
 		return err
 	}
 
-	_, err = tx.Exec("INSERT INTO attachments (id, instance_id, block_id, ephemeral, boot) VALUES (?, ?, ?, ?, ?)", a.ID, a.InstanceID, a.BlockID, a.Ephemeral, a.Boot)
+	_, err = tx.Exec("INSERT INTO attachments (id, instance_id, block_id, ephemeral, boot, read_only) VALUES (?, ?, ?, ?, ?, ?)", a.ID, a.InstanceID, a.BlockID, a.Ephemeral, a.Boot, a.ReadOnly)
 	if err != nil {
 		tx.Rollback()
 		ds.dbLock.Unlock()
@@ -2086,7 +2685,8 @@ func (ds *sqliteDB) getAllStorageAttachments() (map[string]types.StorageAttachme
 				attachments.instance_id,
 				attachments.block_id,
 				attachments.ephemeral,
-				attachments.boot
+				attachments.boot,
+				attachments.read_only
 		  FROM	attachments `
 
 	rows, err := datastore.Query(query)
@@ -2098,7 +2698,7 @@ func (ds *sqliteDB) getAllStorageAttachments() (map[string]types.StorageAttachme
 	for rows.Next() {
 		var a types.StorageAttachment
 
-		err = rows.Scan(&a.ID, &a.InstanceID, &a.BlockID, &a.Ephemeral, &a.Boot)
+		err = rows.Scan(&a.ID, &a.InstanceID, &a.BlockID, &a.Ephemeral, &a.Boot, &a.ReadOnly)
 		if err != nil {
 			continue
 		}
@@ -2520,6 +3120,281 @@ func (ds *sqliteDB) getMappedIPs() map[string]types.MappedIP {
 	return IPs
 }
 
+func (ds *sqliteDB) addPortForward(f types.PortForward) error {
+	datastore := ds.getTableDB("port_forwards")
+
+	ds.dbLock.Lock()
+	defer ds.dbLock.Unlock()
+
+	tx, err := datastore.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("INSERT INTO port_forwards (id, pool_id, external_ip, external_port, protocol, tenant_id, instance_id, internal_ip, internal_port) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		f.ID, f.PoolID, f.ExternalIP, f.ExternalPort, f.Protocol, f.TenantID, f.InstanceID, f.InternalIP, f.InternalPort)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	tx.Commit()
+
+	return nil
+}
+
+func (ds *sqliteDB) deletePortForward(ID string) error {
+	datastore := ds.getTableDB("port_forwards")
+
+	ds.dbLock.Lock()
+	defer ds.dbLock.Unlock()
+
+	tx, err := datastore.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM port_forwards WHERE id = ?", ID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	tx.Commit()
+
+	return err
+}
+
+func (ds *sqliteDB) getPortForwards() map[string]types.PortForward {
+	forwards := make(map[string]types.PortForward)
+
+	datastore := ds.getTableDB("port_forwards")
+
+	query := `SELECT	port_forwards.id,
+				port_forwards.pool_id,
+				port_forwards.external_ip,
+				port_forwards.external_port,
+				port_forwards.protocol,
+				port_forwards.tenant_id,
+				port_forwards.instance_id,
+				port_forwards.internal_ip,
+				port_forwards.internal_port,
+				pools.name
+		  FROM	port_forwards
+		  JOIN pools
+		  ON pools.id = port_forwards.pool_id`
+
+	rows, err := datastore.Query(query)
+	if err != nil {
+		fmt.Println(err)
+		return forwards
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var f types.PortForward
+
+		err = rows.Scan(&f.ID, &f.PoolID, &f.ExternalIP, &f.ExternalPort, &f.Protocol, &f.TenantID, &f.InstanceID, &f.InternalIP, &f.InternalPort, &f.PoolName)
+		if err != nil {
+			continue
+		}
+
+		forwards[f.ID] = f
+	}
+
+	if err = rows.Err(); err != nil {
+		fmt.Println(err)
+	}
+
+	return forwards
+}
+
+func (ds *sqliteDB) addSecurityGroup(sg types.SecurityGroup) error {
+	return ds.updateSecurityGroup(sg)
+}
+
+// updateSecurityGroup replaces a security group, its rules and its
+// instances in a single transaction.
+func (ds *sqliteDB) updateSecurityGroup(sg types.SecurityGroup) error {
+	datastore := ds.getTableDB("security_groups")
+
+	ds.dbLock.Lock()
+	defer ds.dbLock.Unlock()
+
+	tx, err := datastore.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("REPLACE INTO security_groups (id, tenant_id, name, description) VALUES (?, ?, ?, ?)",
+		sg.ID, sg.TenantID, sg.Name, sg.Description)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM security_group_rules WHERE group_id = ?", sg.ID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	for _, r := range sg.Rules {
+		_, err = tx.Exec("INSERT INTO security_group_rules (id, group_id, protocol, port_min, port_max, remote_cidr) VALUES (?, ?, ?, ?, ?, ?)",
+			r.ID, sg.ID, r.Protocol, r.PortMin, r.PortMax, r.RemoteCIDR)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	_, err = tx.Exec("DELETE FROM security_group_instances WHERE group_id = ?", sg.ID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	for _, instanceID := range sg.Instances {
+		_, err = tx.Exec("INSERT INTO security_group_instances (group_id, instance_id) VALUES (?, ?)",
+			sg.ID, instanceID)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	tx.Commit()
+
+	return nil
+}
+
+func (ds *sqliteDB) deleteSecurityGroup(ID string) error {
+	datastore := ds.getTableDB("security_groups")
+
+	ds.dbLock.Lock()
+	defer ds.dbLock.Unlock()
+
+	tx, err := datastore.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, table := range []string{"security_group_rules", "security_group_instances"} {
+		_, err = tx.Exec("DELETE FROM "+table+" WHERE group_id = ?", ID)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	_, err = tx.Exec("DELETE FROM security_groups WHERE id = ?", ID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	tx.Commit()
+
+	return nil
+}
+
+func (ds *sqliteDB) getAllSecurityGroups() (map[string]types.SecurityGroup, error) {
+	groups := make(map[string]types.SecurityGroup)
+
+	datastore := ds.getTableDB("security_groups")
+
+	query := `SELECT	id,
+				tenant_id,
+				name,
+				description
+		  FROM	security_groups`
+
+	rows, err := datastore.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var sg types.SecurityGroup
+
+		err = rows.Scan(&sg.ID, &sg.TenantID, &sg.Name, &sg.Description)
+		if err != nil {
+			continue
+		}
+
+		groups[sg.ID] = sg
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	query = `SELECT	id,
+			group_id,
+			protocol,
+			port_min,
+			port_max,
+			remote_cidr
+		  FROM	security_group_rules`
+
+	ruleRows, err := datastore.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer ruleRows.Close()
+
+	for ruleRows.Next() {
+		var r types.SecurityGroupRule
+		var groupID string
+
+		err = ruleRows.Scan(&r.ID, &groupID, &r.Protocol, &r.PortMin, &r.PortMax, &r.RemoteCIDR)
+		if err != nil {
+			continue
+		}
+
+		sg, ok := groups[groupID]
+		if !ok {
+			continue
+		}
+		sg.Rules = append(sg.Rules, r)
+		groups[groupID] = sg
+	}
+	if err = ruleRows.Err(); err != nil {
+		return nil, err
+	}
+
+	query = `SELECT	group_id,
+			instance_id
+		  FROM	security_group_instances`
+
+	memberRows, err := datastore.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer memberRows.Close()
+
+	for memberRows.Next() {
+		var groupID, instanceID string
+
+		err = memberRows.Scan(&groupID, &instanceID)
+		if err != nil {
+			continue
+		}
+
+		sg, ok := groups[groupID]
+		if !ok {
+			continue
+		}
+		sg.Instances = append(sg.Instances, instanceID)
+		groups[groupID] = sg
+	}
+	if err = memberRows.Err(); err != nil {
+		return nil, err
+	}
+
+	return groups, nil
+}
+
 func (ds *sqliteDB) updateQuotas(tenantID string, qds []types.QuotaDetails) error {
 	datastore := ds.getTableDB("quotas")
 
@@ -2571,3 +3446,279 @@ func (ds *sqliteDB) getQuotas(tenantID string) ([]types.QuotaDetails, error) {
 
 	return results, nil
 }
+
+func (ds *sqliteDB) addNetwork(n types.TenantNetwork) error {
+	return ds.updateNetwork(n)
+}
+
+// updateNetwork replaces a network and its subnets in a single
+// transaction.
+func (ds *sqliteDB) updateNetwork(n types.TenantNetwork) error {
+	datastore := ds.getTableDB("networks")
+
+	ds.dbLock.Lock()
+	defer ds.dbLock.Unlock()
+
+	tx, err := datastore.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("REPLACE INTO networks (id, tenant_id, name) VALUES (?, ?, ?)",
+		n.ID, n.TenantID, n.Name)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	err = deleteSubnetOptions(tx, n.ID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM network_subnets WHERE network_id = ?", n.ID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	for _, sn := range n.Subnets {
+		_, err = tx.Exec("INSERT INTO network_subnets (id, network_id, name, cidr, gateway_ip) VALUES (?, ?, ?, ?, ?)",
+			sn.ID, n.ID, sn.Name, sn.CIDR, sn.GatewayIP)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		err = insertSubnetOptions(tx, sn)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	tx.Commit()
+
+	return nil
+}
+
+// deleteSubnetOptions removes the DHCP options of all the subnets of a
+// network.
+func deleteSubnetOptions(tx *sql.Tx, networkID string) error {
+	for _, table := range []string{"network_subnet_options", "network_subnet_routes"} {
+		cmd := fmt.Sprintf("DELETE FROM %s WHERE subnet_id IN (SELECT id FROM network_subnets WHERE network_id = ?)", table)
+		_, err := tx.Exec(cmd, networkID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// insertSubnetOptions stores the DHCP options of a subnet, in the order
+// they are handed out.
+func insertSubnetOptions(tx *sql.Tx, sn types.TenantSubnet) error {
+	options := []struct {
+		option string
+		values []string
+	}{
+		{"dns_nameserver", sn.DNSNameservers},
+		{"dns_search_domain", sn.DNSSearchDomains},
+		{"ntp_server", sn.NTPServers},
+	}
+
+	for _, o := range options {
+		for _, v := range o.values {
+			_, err := tx.Exec("INSERT INTO network_subnet_options (subnet_id, option, value) VALUES (?, ?, ?)",
+				sn.ID, o.option, v)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, r := range sn.HostRoutes {
+		_, err := tx.Exec("INSERT INTO network_subnet_routes (subnet_id, destination, nexthop) VALUES (?, ?, ?)",
+			sn.ID, r.Destination, r.NextHop)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (ds *sqliteDB) deleteNetwork(ID string) error {
+	datastore := ds.getTableDB("networks")
+
+	ds.dbLock.Lock()
+	defer ds.dbLock.Unlock()
+
+	tx, err := datastore.Begin()
+	if err != nil {
+		return err
+	}
+
+	err = deleteSubnetOptions(tx, ID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM network_subnets WHERE network_id = ?", ID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = tx.Exec("DELETE FROM networks WHERE id = ?", ID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	tx.Commit()
+
+	return nil
+}
+
+func (ds *sqliteDB) getAllNetworks() (map[string]types.TenantNetwork, error) {
+	networks := make(map[string]types.TenantNetwork)
+
+	datastore := ds.getTableDB("networks")
+
+	query := `SELECT	id,
+				tenant_id,
+				name
+		  FROM	networks`
+
+	rows, err := datastore.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var n types.TenantNetwork
+
+		err = rows.Scan(&n.ID, &n.TenantID, &n.Name)
+		if err != nil {
+			continue
+		}
+
+		networks[n.ID] = n
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	query = `SELECT	id,
+			network_id,
+			name,
+			cidr,
+			gateway_ip
+		  FROM	network_subnets`
+
+	subnetRows, err := datastore.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer subnetRows.Close()
+
+	for subnetRows.Next() {
+		var sn types.TenantSubnet
+
+		err = subnetRows.Scan(&sn.ID, &sn.NetworkID, &sn.Name, &sn.CIDR, &sn.GatewayIP)
+		if err != nil {
+			continue
+		}
+
+		n, ok := networks[sn.NetworkID]
+		if !ok {
+			continue
+		}
+		n.Subnets = append(n.Subnets, sn)
+		networks[sn.NetworkID] = n
+	}
+	if err = subnetRows.Err(); err != nil {
+		return nil, err
+	}
+
+	subnets := make(map[string]*types.TenantSubnet)
+	for _, n := range networks {
+		for i := range n.Subnets {
+			subnets[n.Subnets[i].ID] = &n.Subnets[i]
+		}
+	}
+
+	query = `SELECT	subnet_id,
+			option,
+			value
+		  FROM	network_subnet_options
+		  ORDER BY rowid`
+
+	optionRows, err := datastore.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer optionRows.Close()
+
+	for optionRows.Next() {
+		var subnetID, option, value string
+
+		err = optionRows.Scan(&subnetID, &option, &value)
+		if err != nil {
+			continue
+		}
+
+		sn, ok := subnets[subnetID]
+		if !ok {
+			continue
+		}
+
+		switch option {
+		case "dns_nameserver":
+			sn.DNSNameservers = append(sn.DNSNameservers, value)
+		case "dns_search_domain":
+			sn.DNSSearchDomains = append(sn.DNSSearchDomains, value)
+		case "ntp_server":
+			sn.NTPServers = append(sn.NTPServers, value)
+		}
+	}
+	if err = optionRows.Err(); err != nil {
+		return nil, err
+	}
+
+	query = `SELECT	subnet_id,
+			destination,
+			nexthop
+		  FROM	network_subnet_routes
+		  ORDER BY rowid`
+
+	routeRows, err := datastore.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer routeRows.Close()
+
+	for routeRows.Next() {
+		var subnetID string
+		var r types.HostRoute
+
+		err = routeRows.Scan(&subnetID, &r.Destination, &r.NextHop)
+		if err != nil {
+			continue
+		}
+
+		if sn, ok := subnets[subnetID]; ok {
+			sn.HostRoutes = append(sn.HostRoutes, r)
+		}
+	}
+	if err = routeRows.Err(); err != nil {
+		return nil, err
+	}
+
+	return networks, nil
+}
@@ -357,6 +357,81 @@ func TestUpdatePool(t *testing.T) {
 	db.disconnect()
 }
 
+func TestSQLiteDBNetworks(t *testing.T) {
+	db, err := getPersistentStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n := types.TenantNetwork{
+		ID:       uuid.Generate().String(),
+		TenantID: uuid.Generate().String(),
+		Name:     "private",
+	}
+	n.Subnets = []types.TenantSubnet{
+		{
+			ID:        uuid.Generate().String(),
+			NetworkID: n.ID,
+			Name:      "web",
+			CIDR:      "10.0.0.0/24",
+			GatewayIP: "10.0.0.254",
+			SubnetDHCPOptions: types.SubnetDHCPOptions{
+				DNSNameservers:   []string{"10.0.0.53", "8.8.8.8"},
+				DNSSearchDomains: []string{"example.com"},
+				HostRoutes: []types.HostRoute{
+					{Destination: "10.1.0.0/16", NextHop: "10.0.0.1"},
+				},
+				NTPServers: []string{"10.0.0.123"},
+			},
+		},
+	}
+
+	err = db.addNetwork(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	networks, err := db.getAllNetworks()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(networks[n.ID], n) {
+		t.Fatalf("expected %v, got %v", n, networks[n.ID])
+	}
+
+	n.Subnets = nil
+	err = db.updateNetwork(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	networks, err = db.getAllNetworks()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(networks[n.ID].Subnets) != 0 {
+		t.Fatal("subnet not removed")
+	}
+
+	err = db.deleteNetwork(n.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	networks, err = db.getAllNetworks()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := networks[n.ID]; ok {
+		t.Fatal("network not deleted")
+	}
+
+	db.disconnect()
+}
+
 func TestDeletePool(t *testing.T) {
 	db, err := getPersistentStore()
 	if err != nil {
@@ -587,6 +662,70 @@ func TestDeleteAddress(t *testing.T) {
 	db.disconnect()
 }
 
+func TestSQLiteDBInstanceNICs(t *testing.T) {
+	db, err := getPersistentStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	i := types.Instance{
+		ID:         uuid.Generate().String(),
+		TenantID:   uuid.Generate().String(),
+		WorkloadID: uuid.Generate().String(),
+		IPAddress:  "10.0.0.2",
+		SubnetID:   uuid.Generate().String(),
+	}
+
+	for _, ip := range []string{"10.1.0.2", "10.2.0.2"} {
+		i.ExtraNICs = append(i.ExtraNICs, types.InstanceNIC{
+			VnicUUID:   uuid.Generate().String(),
+			MACAddress: "02:00:0a:01:00:02",
+			IPAddress:  ip,
+			Subnet:     ip[:5] + "0.0/24",
+			SubnetID:   uuid.Generate().String(),
+			NetworkID:  uuid.Generate().String(),
+		})
+	}
+
+	err = db.addInstance(&i)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	instances, err := db.getInstances()
+	if err != nil || len(instances) != 1 {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(instances[0].ExtraNICs, i.ExtraNICs) {
+		t.Fatalf("expected %v, got %v", i.ExtraNICs, instances[0].ExtraNICs)
+	}
+
+	err = db.deleteInstance(i.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the NICs of a deleted instance must not come back with a new
+	// instance reusing its ID
+	i.ExtraNICs = nil
+	err = db.addInstance(&i)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	instances, err = db.getInstances()
+	if err != nil || len(instances) != 1 {
+		t.Fatal(err)
+	}
+
+	if len(instances[0].ExtraNICs) != 0 {
+		t.Fatal("NICs not deleted")
+	}
+
+	db.disconnect()
+}
+
 func TestCreateMappedIP(t *testing.T) {
 	db, err := getPersistentStore()
 	if err != nil {
@@ -713,6 +852,56 @@ func TestDeleteMappedIP(t *testing.T) {
 	}
 }
 
+func TestCreatePortForward(t *testing.T) {
+	db, err := getPersistentStore()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := types.Pool{
+		ID:   uuid.Generate().String(),
+		Name: "test",
+	}
+
+	err = db.addPool(pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := types.PortForward{
+		ID:           uuid.Generate().String(),
+		ExternalIP:   "192.168.0.1",
+		ExternalPort: 8022,
+		Protocol:     "tcp",
+		InstanceID:   uuid.Generate().String(),
+		InternalIP:   "172.16.0.2",
+		InternalPort: 22,
+		TenantID:     uuid.Generate().String(),
+		PoolID:       pool.ID,
+		PoolName:     pool.Name,
+	}
+
+	err = db.addPortForward(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	forwards := db.getPortForwards()
+	if reflect.DeepEqual(forwards[f.ID], f) == false {
+		t.Fatalf("expected %v, got %v\n", f, forwards[f.ID])
+	}
+
+	err = db.deletePortForward(f.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	forwards = db.getPortForwards()
+	if len(forwards) != 0 {
+		t.Fatal("port forward not deleted")
+	}
+}
+
 func createTestTenant(db persistentStore, t *testing.T) *tenant {
 	tid := uuid.Generate().String()
 	thw, err := newHardwareAddr()
@@ -27,13 +27,16 @@ import (
 	"context"
 	"flag"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"sync"
+	"time"
 
 	"github.com/01org/ciao/ciao-controller/api"
 	"github.com/01org/ciao/ciao-controller/internal/datastore"
 	"github.com/01org/ciao/ciao-controller/internal/quotas"
+	imageDatastore "github.com/01org/ciao/ciao-image/datastore"
 	storage "github.com/01org/ciao/ciao-storage"
 	"github.com/01org/ciao/clogger/gloginterface"
 	"github.com/01org/ciao/database"
@@ -42,6 +45,7 @@ import (
 	osIdentity "github.com/01org/ciao/openstack/identity"
 	osimage "github.com/01org/ciao/openstack/image"
 	"github.com/01org/ciao/osprepare"
+	"github.com/01org/ciao/payloads"
 	"github.com/01org/ciao/ssntp"
 	"github.com/golang/glog"
 	"github.com/gorilla/mux"
@@ -62,6 +66,11 @@ type controller struct {
 	tenantReadiness     map[string]*tenantConfirmMemo
 	tenantReadinessLock sync.Mutex
 	qs                  *quotas.Quotas
+	backupStore         imageDatastore.RawDataStore
+	imageService        *ImageService
+	imageServiceLock    sync.RWMutex
+	imageStagingLock    sync.Mutex
+	reconciler          volumeReconciler
 }
 
 var cert = flag.String("cert", "", "Client certificate")
@@ -86,8 +95,57 @@ var logDir = "/var/lib/ciao/logs/controller"
 
 var imagesPath = flag.String("images_path", "/var/lib/ciao/images", "path to ciao images")
 
+var imageImportMaxSize = flag.Uint("image_import_max_size", 64, "largest image, in GiB, that can be imported from a URL, 0 for no limit")
+
+var imageImportAllowPrivate = flag.Bool("image_import_allow_private", false, "allow images to be imported from loopback, link-local and private addresses")
+
+var imageStore = flag.String("image_store", "ceph", "where image data is stored (ceph, s3)")
+
+var imageS3Endpoint = flag.String("image_s3_endpoint", "", "URL of the S3 compatible object store holding images")
+
+var imageS3Bucket = flag.String("image_s3_bucket", "ciao-images", "S3 bucket holding images")
+
+var imageS3Region = flag.String("image_s3_region", "", "S3 region used to sign requests (default us-east-1)")
+
+var imageS3PartSize = flag.Int64("image_s3_part_size", 64, "size, in MiB, of the parts in which images are uploaded to S3")
+
+var backupsPath = flag.String("backups_path", "/var/lib/ciao/backups", "path to volume backups")
+
+var backupStoreType = flag.String("backup_store", "posix", "where volume backups are stored (posix, s3)")
+
+var backupS3Endpoint = flag.String("backup_s3_endpoint", "", "URL of the S3 compatible object store holding volume backups")
+
+var backupS3Bucket = flag.String("backup_s3_bucket", "ciao-backups", "S3 bucket holding volume backups")
+
+var backupS3Region = flag.String("backup_s3_region", "", "S3 region used to sign backup requests (default us-east-1)")
+
 var cephID = flag.String("ceph_id", "", "ceph client id")
 
+var volumeReconcileInterval = flag.Duration("volume_reconcile_interval", 10*time.Minute, "how often to check volumes against the storage backend, 0 to disable")
+
+var volumeReconcileCleanup = flag.Bool("volume_reconcile_cleanup", false, "repair the volume inconsistencies found with the storage backend")
+
+// volumeTypes maps the names of the volume types defined in the cluster
+// configuration to their definitions.
+var volumeTypes = make(map[string]payloads.ConfigureVolumeType)
+
+// requireSignedImages is set by the cluster configuration to only allow
+// instances and volumes to be created from images whose signature was
+// verified.
+var requireSignedImages = false
+
+// imageSigningCerts is the directory holding the certificates with which
+// image signatures are verified.
+var imageSigningCerts = "/etc/pki/ciao/image-signing"
+
+// tenantIPv6Prefix is the /48 out of which the IPv6 subnets of dual stack
+// tenant subnets are allocated. Tenant subnets are IPv4 only if it is nil.
+var tenantIPv6Prefix *net.IPNet
+
+// tenantDNSDomain is the DNS domain under which the CNCI of a tenant
+// resolves the names of the tenant's instances.
+var tenantDNSDomain = "ciao"
+
 var cnciVCPUs = 4
 var cnciMem = 2048
 var cnciDisk = 2048
@@ -172,6 +230,10 @@ func main() {
 		*cephID = clusterConfig.Configure.Storage.CephID
 	}
 
+	for _, vt := range clusterConfig.Configure.Storage.VolumeTypes {
+		volumeTypes[vt.Name] = vt
+	}
+
 	if clusterConfig.Configure.Controller.CNCIVcpus != 0 {
 		cnciVCPUs = clusterConfig.Configure.Controller.CNCIVcpus
 	}
@@ -186,6 +248,26 @@ func main() {
 
 	adminSSHKey = clusterConfig.Configure.Controller.AdminSSHKey
 
+	requireSignedImages = clusterConfig.Configure.Controller.RequireSignedImages
+	if clusterConfig.Configure.Controller.ImageSigningCerts != "" {
+		imageSigningCerts = clusterConfig.Configure.Controller.ImageSigningCerts
+	}
+
+	if clusterConfig.Configure.Controller.TenantIPv6Prefix != "" {
+		_, prefix, err := net.ParseCIDR(clusterConfig.Configure.Controller.TenantIPv6Prefix)
+		if err != nil || prefix.IP.To4() != nil {
+			glog.Fatalf("Invalid tenant IPv6 prefix %s", clusterConfig.Configure.Controller.TenantIPv6Prefix)
+		}
+		if ones, _ := prefix.Mask.Size(); ones != 48 {
+			glog.Fatalf("Tenant IPv6 prefix %s is not a /48", prefix)
+		}
+		tenantIPv6Prefix = prefix
+	}
+
+	if clusterConfig.Configure.Controller.TenantDNSDomain != "" {
+		tenantDNSDomain = clusterConfig.Configure.Controller.TenantDNSDomain
+	}
+
 	if clusterConfig.Configure.Controller.AdminPassword != "" {
 		adminPassword = clusterConfig.Configure.Controller.AdminPassword
 	}
@@ -211,6 +293,13 @@ func main() {
 		return driver
 	}()
 
+	ctl.backupStore, err = backupRawDataStore()
+	if err != nil {
+		glog.Errorf("Volume backups disabled: %v", err)
+	}
+
+	ctl.cleanStaleBackups()
+
 	ctl.id, err = newIdentityClient(idConfig)
 	if err != nil {
 		glog.Fatal("Unable to authenticate to Keystone: ", err)
@@ -235,6 +324,11 @@ func main() {
 	wg.Add(1)
 	go ctl.startCiaoService()
 
+	if *volumeReconcileInterval > 0 {
+		ctl.reconciler.cleanup = *volumeReconcileCleanup
+		go ctl.startVolumeReconciler(*volumeReconcileInterval)
+	}
+
 	wg.Wait()
 	ctl.qs.Shutdown()
 	ctl.ds.Exit()
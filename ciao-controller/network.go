@@ -0,0 +1,475 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/01org/ciao/ciao-controller/types"
+	"github.com/01org/ciao/payloads"
+	"github.com/01org/ciao/ssntp/uuid"
+	"github.com/golang/glog"
+)
+
+const (
+	// tenant subnets need room for a few instances but should not be
+	// so large that finding a free address becomes expensive
+	minSubnetPrefix = 16
+	maxSubnetPrefix = 29
+)
+
+func (c *controller) makeNetworkLinks(n *types.TenantNetwork, tenant *string) {
+	var ref string
+
+	if tenant != nil {
+		ref = fmt.Sprintf("%s/%s/networks/%s", c.apiURL, *tenant, n.ID)
+	} else {
+		ref = fmt.Sprintf("%s/networks/%s", c.apiURL, n.ID)
+	}
+
+	n.Links = []types.Link{{Rel: "self", Href: ref}}
+}
+
+// newTenantSubnet validates a subnet request and returns the subnet it
+// describes. The CIDR is put in canonical form and the gateway defaults
+// to the first address of the subnet.
+func newTenantSubnet(networkID string, req types.NewSubnetRequest) (types.TenantSubnet, error) {
+	_, ipNet, err := net.ParseCIDR(req.CIDR)
+	if err != nil || ipNet.IP.To4() == nil {
+		return types.TenantSubnet{}, types.ErrInvalidSubnet
+	}
+
+	ones, _ := ipNet.Mask.Size()
+	if ones < minSubnetPrefix || ones > maxSubnetPrefix {
+		return types.TenantSubnet{}, types.ErrInvalidSubnet
+	}
+
+	network := binary.BigEndian.Uint32(ipNet.IP.To4())
+	broadcast := network | ^binary.BigEndian.Uint32(ipNet.Mask)
+
+	gateway := make(net.IP, net.IPv4len)
+	binary.BigEndian.PutUint32(gateway, network+1)
+
+	if req.GatewayIP != "" {
+		gw := net.ParseIP(req.GatewayIP).To4()
+		if gw == nil || !ipNet.Contains(gw) {
+			return types.TenantSubnet{}, types.ErrInvalidSubnet
+		}
+
+		gwInt := binary.BigEndian.Uint32(gw)
+		if gwInt == network || gwInt == broadcast {
+			return types.TenantSubnet{}, types.ErrInvalidSubnet
+		}
+		gateway = gw
+	}
+
+	options, err := checkDHCPOptions(ipNet, req.SubnetDHCPOptions)
+	if err != nil {
+		return types.TenantSubnet{}, err
+	}
+
+	return types.TenantSubnet{
+		ID:                uuid.Generate().String(),
+		NetworkID:         networkID,
+		Name:              req.Name,
+		CIDR:              ipNet.String(),
+		GatewayIP:         gateway.String(),
+		SubnetDHCPOptions: options,
+	}, nil
+}
+
+// checkIPv4List verifies that all the addresses of a DHCP option are IPv4
+// addresses and returns them in canonical form.
+func checkIPv4List(addrs []string) ([]string, error) {
+	var ips []string
+
+	for _, a := range addrs {
+		ip := net.ParseIP(a).To4()
+		if ip == nil {
+			return nil, types.ErrInvalidDHCPOptions
+		}
+		ips = append(ips, ip.String())
+	}
+
+	return ips, nil
+}
+
+// checkDHCPOptions validates the DHCP options of a subnet. Only IPv4
+// addresses can be handed out and the next hop of the static routes has
+// to be an address of the subnet.
+func checkDHCPOptions(subnet *net.IPNet, options types.SubnetDHCPOptions) (types.SubnetDHCPOptions, error) {
+	var checked types.SubnetDHCPOptions
+	var err error
+
+	checked.DNSNameservers, err = checkIPv4List(options.DNSNameservers)
+	if err != nil {
+		return types.SubnetDHCPOptions{}, err
+	}
+
+	checked.NTPServers, err = checkIPv4List(options.NTPServers)
+	if err != nil {
+		return types.SubnetDHCPOptions{}, err
+	}
+
+	for _, d := range options.DNSSearchDomains {
+		if d == "" || len(d) > 253 || strings.ContainsAny(d, " \t\n,/") {
+			return types.SubnetDHCPOptions{}, types.ErrInvalidDHCPOptions
+		}
+		checked.DNSSearchDomains = append(checked.DNSSearchDomains, d)
+	}
+
+	for _, r := range options.HostRoutes {
+		_, dest, err := net.ParseCIDR(r.Destination)
+		if err != nil || dest.IP.To4() == nil {
+			return types.SubnetDHCPOptions{}, types.ErrInvalidDHCPOptions
+		}
+
+		nextHop := net.ParseIP(r.NextHop).To4()
+		if nextHop == nil || !subnet.Contains(nextHop) {
+			return types.SubnetDHCPOptions{}, types.ErrInvalidDHCPOptions
+		}
+
+		checked.HostRoutes = append(checked.HostRoutes, types.HostRoute{
+			Destination: dest.String(),
+			NextHop:     nextHop.String(),
+		})
+	}
+
+	return checked, nil
+}
+
+// hasDHCPOptions returns true if any DHCP option is set for a subnet.
+func hasDHCPOptions(sn types.TenantSubnet) bool {
+	return len(sn.DNSNameservers) > 0 || len(sn.DNSSearchDomains) > 0 ||
+		len(sn.HostRoutes) > 0 || len(sn.NTPServers) > 0
+}
+
+// sendDHCPOptions sends the DHCP options of the subnets of a tenant to its
+// CNCI. Subnets without options are skipped unless all is set.
+func (c *controller) sendDHCPOptions(tenantID string, subnets []types.TenantSubnet, all bool) error {
+	t, err := c.ds.GetTenant(tenantID)
+	if err != nil {
+		return err
+	}
+	if t == nil {
+		return types.ErrTenantNotFound
+	}
+
+	// the CNCI is sent the options of all the subnets when it is added
+	if t.CNCIID == "" {
+		return nil
+	}
+
+	for _, sn := range subnets {
+		if !all && !hasDHCPOptions(sn) {
+			continue
+		}
+
+		err = c.client.updateDHCPOptions(*t, sn)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// publishTenantDHCPOptions sends the DHCP options of all the subnets of a
+// tenant, e.g., when a new CNCI joins.
+func (c *controller) publishTenantDHCPOptions(tenantID string) {
+	var subnets []types.TenantSubnet
+
+	for _, n := range c.ds.GetNetworks(&tenantID) {
+		subnets = append(subnets, n.Subnets...)
+	}
+
+	err := c.sendDHCPOptions(tenantID, subnets, false)
+	if err != nil {
+		glog.Warningf("Unable to send the DHCP options of tenant %s: %v", tenantID, err)
+	}
+}
+
+// getTenantNetwork returns a network owned by tenantID.
+func (c *controller) getTenantNetwork(tenantID string, networkID string) (types.TenantNetwork, error) {
+	n, err := c.ds.GetNetwork(networkID)
+	if err != nil {
+		return n, err
+	}
+
+	if n.TenantID != tenantID {
+		return types.TenantNetwork{}, types.ErrNetworkNotFound
+	}
+
+	return n, nil
+}
+
+// allocateNetworkIP allocates an address for a new instance from the
+// first subnet of a tenant network that has a free address.
+func (c *controller) allocateNetworkIP(tenantID string, networkID string) (net.IP, types.TenantSubnet, error) {
+	n, err := c.getTenantNetwork(tenantID, networkID)
+	if err != nil {
+		return nil, types.TenantSubnet{}, err
+	}
+
+	if len(n.Subnets) == 0 {
+		return nil, types.TenantSubnet{}, types.ErrSubnetNotFound
+	}
+
+	for _, sn := range n.Subnets {
+		ip, err := c.ds.AllocateSubnetIP(sn.ID)
+		if err == types.ErrSubnetFull {
+			continue
+		}
+		return ip, sn, err
+	}
+
+	return nil, types.TenantSubnet{}, types.ErrSubnetFull
+}
+
+// claimNetworkIP reserves a specific address for a new instance in the
+// subnet of a tenant network that contains it.
+func (c *controller) claimNetworkIP(tenantID string, networkID string, ip net.IP) (types.TenantSubnet, error) {
+	n, err := c.getTenantNetwork(tenantID, networkID)
+	if err != nil {
+		return types.TenantSubnet{}, err
+	}
+
+	for _, sn := range n.Subnets {
+		_, ipNet, err := net.ParseCIDR(sn.CIDR)
+		if err != nil || !ipNet.Contains(ip) {
+			continue
+		}
+		return sn, c.ds.ClaimSubnetIP(sn.ID, ip)
+	}
+
+	return types.TenantSubnet{}, types.ErrInvalidIP
+}
+
+// allocateExtraNICs allocates an address for each additional NIC of an
+// instance, one per network.  Nothing stays allocated if one of the
+// networks has no address left.
+func (c *controller) allocateExtraNICs(tenantID string, networkIDs []string) ([]types.InstanceNIC, error) {
+	var nics []types.InstanceNIC
+
+	for _, networkID := range networkIDs {
+		ip, sn, err := c.allocateNetworkIP(tenantID, networkID)
+		if err != nil {
+			c.releaseExtraNICs(nics)
+			return nil, err
+		}
+
+		nics = append(nics, types.InstanceNIC{
+			VnicUUID:   uuid.Generate().String(),
+			MACAddress: newTenantHardwareAddr(ip).String(),
+			IPAddress:  ip.String(),
+			Subnet:     sn.CIDR,
+			SubnetID:   sn.ID,
+			NetworkID:  networkID,
+		})
+	}
+
+	return nics, nil
+}
+
+func (c *controller) releaseExtraNICs(nics []types.InstanceNIC) {
+	for _, nic := range nics {
+		err := c.ds.ReleaseSubnetIP(nic.SubnetID, nic.IPAddress)
+		if err != nil {
+			glog.Warningf("Unable to release %s: %v", nic.IPAddress, err)
+		}
+	}
+}
+
+// extraNetworking returns the networking resources the launcher needs to
+// create the additional vnics of an instance.
+func (c *controller) extraNetworking(tenant *types.Tenant, nics []types.InstanceNIC) ([]payloads.NetworkResources, error) {
+	var networking []payloads.NetworkResources
+
+	for _, nic := range nics {
+		sn, err := c.ds.GetSubnet(nic.SubnetID)
+		if err != nil {
+			return nil, err
+		}
+
+		subnetKey, err := c.ds.TenantSubnetKey(tenant.ID, nic.Subnet)
+		if err != nil {
+			return nil, err
+		}
+
+		networking = append(networking, payloads.NetworkResources{
+			VnicMAC:          nic.MACAddress,
+			VnicUUID:         nic.VnicUUID,
+			ConcentratorUUID: tenant.CNCIID,
+			ConcentratorIP:   tenant.CNCIIP,
+			Subnet:           nic.Subnet,
+			SubnetKey:        strconv.Itoa(subnetKey),
+			PrivateIP:        nic.IPAddress,
+			Gateway:          sn.GatewayIP,
+			NetworkUUID:      nic.NetworkID,
+		})
+	}
+
+	return networking, nil
+}
+
+func (c *controller) ListNetworks(tenant *string) []types.TenantNetwork {
+	networks := c.ds.GetNetworks(tenant)
+
+	for i := range networks {
+		c.makeNetworkLinks(&networks[i], tenant)
+	}
+
+	return networks
+}
+
+func (c *controller) CreateNetwork(tenantID string, req types.NewNetworkRequest) (types.TenantNetwork, error) {
+	if req.Name == "" {
+		return types.TenantNetwork{}, types.ErrBadRequest
+	}
+
+	t, err := c.ds.GetTenant(tenantID)
+	if err != nil {
+		return types.TenantNetwork{}, err
+	}
+	if t == nil {
+		return types.TenantNetwork{}, types.ErrTenantNotFound
+	}
+
+	n := types.TenantNetwork{
+		ID:       uuid.Generate().String(),
+		TenantID: tenantID,
+		Name:     req.Name,
+	}
+
+	for _, r := range req.Subnets {
+		sn, err := newTenantSubnet(n.ID, r)
+		if err != nil {
+			return types.TenantNetwork{}, err
+		}
+		n.Subnets = append(n.Subnets, sn)
+	}
+
+	err = c.ds.AddNetwork(n)
+	if err != nil {
+		return types.TenantNetwork{}, err
+	}
+
+	err = c.sendDHCPOptions(tenantID, n.Subnets, false)
+	if err != nil {
+		glog.Warningf("Unable to send the DHCP options of network %s: %v", n.ID, err)
+	}
+
+	c.makeNetworkLinks(&n, &tenantID)
+
+	return n, nil
+}
+
+func (c *controller) ShowNetwork(tenantID string, networkID string) (types.TenantNetwork, error) {
+	n, err := c.getTenantNetwork(tenantID, networkID)
+	if err != nil {
+		return n, err
+	}
+
+	c.makeNetworkLinks(&n, &tenantID)
+
+	return n, nil
+}
+
+func (c *controller) DeleteNetwork(tenantID string, networkID string) error {
+	_, err := c.getTenantNetwork(tenantID, networkID)
+	if err != nil {
+		return err
+	}
+
+	return c.ds.DeleteNetwork(networkID)
+}
+
+func (c *controller) AddNetworkSubnet(tenantID string, networkID string, req types.NewSubnetRequest) (types.TenantSubnet, error) {
+	_, err := c.getTenantNetwork(tenantID, networkID)
+	if err != nil {
+		return types.TenantSubnet{}, err
+	}
+
+	sn, err := newTenantSubnet(networkID, req)
+	if err != nil {
+		return types.TenantSubnet{}, err
+	}
+
+	err = c.ds.AddNetworkSubnet(networkID, sn)
+	if err != nil {
+		return types.TenantSubnet{}, err
+	}
+
+	err = c.sendDHCPOptions(tenantID, []types.TenantSubnet{sn}, false)
+	if err != nil {
+		glog.Warningf("Unable to send the DHCP options of subnet %s: %v", sn.ID, err)
+	}
+
+	return sn, nil
+}
+
+func (c *controller) UpdateNetworkSubnet(tenantID string, networkID string, subnetID string, req types.SubnetDHCPOptions) (types.TenantSubnet, error) {
+	n, err := c.getTenantNetwork(tenantID, networkID)
+	if err != nil {
+		return types.TenantSubnet{}, err
+	}
+
+	var sn *types.TenantSubnet
+	for i := range n.Subnets {
+		if n.Subnets[i].ID == subnetID {
+			sn = &n.Subnets[i]
+			break
+		}
+	}
+	if sn == nil {
+		return types.TenantSubnet{}, types.ErrSubnetNotFound
+	}
+
+	_, ipNet, err := net.ParseCIDR(sn.CIDR)
+	if err != nil {
+		return types.TenantSubnet{}, types.ErrInvalidSubnet
+	}
+
+	options, err := checkDHCPOptions(ipNet, req)
+	if err != nil {
+		return types.TenantSubnet{}, err
+	}
+
+	updated, err := c.ds.UpdateSubnetDHCPOptions(networkID, subnetID, options)
+	if err != nil {
+		return types.TenantSubnet{}, err
+	}
+
+	// options that were removed have to be withdrawn from the CNCI too
+	err = c.sendDHCPOptions(tenantID, []types.TenantSubnet{updated}, true)
+	if err != nil {
+		glog.Warningf("Unable to send the DHCP options of subnet %s: %v", subnetID, err)
+	}
+
+	return updated, nil
+}
+
+func (c *controller) DeleteNetworkSubnet(tenantID string, networkID string, subnetID string) error {
+	_, err := c.getTenantNetwork(tenantID, networkID)
+	if err != nil {
+		return err
+	}
+
+	return c.ds.DeleteNetworkSubnet(networkID, subnetID)
+}
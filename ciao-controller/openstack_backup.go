@@ -0,0 +1,489 @@
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/01org/ciao/ciao-controller/types"
+	imageDatastore "github.com/01org/ciao/ciao-image/datastore"
+	"github.com/01org/ciao/ciao-storage"
+	"github.com/01org/ciao/openstack/block"
+	"github.com/01org/ciao/payloads"
+	"github.com/01org/ciao/ssntp/uuid"
+	"github.com/golang/glog"
+)
+
+// backupRawDataStore returns the store selected by the backup_store flag
+// in which volume backups are kept.  As for images, the S3 credentials are
+// taken from the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment
+// variables.
+func backupRawDataStore() (imageDatastore.RawDataStore, error) {
+	switch *backupStoreType {
+	case "posix":
+		if err := os.MkdirAll(*backupsPath, 0755); err != nil {
+			return nil, fmt.Errorf("unable to create backup directory (%s): %v", *backupsPath, err)
+		}
+
+		return &imageDatastore.Posix{MountPoint: *backupsPath}, nil
+	case "s3":
+		if *backupS3Endpoint == "" {
+			return nil, fmt.Errorf("backup_s3_endpoint is required for the s3 backup store")
+		}
+
+		return &imageDatastore.S3{
+			Endpoint:  *backupS3Endpoint,
+			Bucket:    *backupS3Bucket,
+			Region:    *backupS3Region,
+			AccessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			PartSize:  *imageS3PartSize << 20,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unknown backup store %s", *backupStoreType)
+}
+
+// volumeBackingUp returns true if a backup of the volume is being created.
+// The volume must not be deleted until the backup snapshot is released.
+func (c *controller) volumeBackingUp(volumeID string, tenant string) bool {
+	backups, _ := c.ds.GetVolumeBackups(tenant)
+	for _, b := range backups {
+		if b.VolumeID == volumeID && b.State == types.BackupCreating {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *controller) getTenantBackup(tenant string, backup string) (types.VolumeBackup, error) {
+	b, err := c.ds.GetVolumeBackup(backup)
+	if err != nil {
+		return types.VolumeBackup{}, block.ErrBackupNotFound
+	}
+
+	if b.TenantID != tenant {
+		return types.VolumeBackup{}, block.ErrBackupOwner
+	}
+
+	return b, nil
+}
+
+func backupDetail(b types.VolumeBackup) block.BackupDetail {
+	detail := block.BackupDetail{
+		CreatedAt: &b.CreateTime,
+		ID:        b.ID,
+		Links:     make([]block.Link, 0),
+		Size:      b.Size,
+		Status:    block.BackupStatus(b.State),
+		VolumeID:  b.VolumeID,
+	}
+
+	if b.Name != "" {
+		detail.Name = &b.Name
+	}
+
+	if b.Description != "" {
+		detail.Description = &b.Description
+	}
+
+	if b.FailReason != "" {
+		detail.FailReason = &b.FailReason
+	}
+
+	if b.State == types.BackupAvailable {
+		detail.ObjectCount = 1
+	}
+
+	return detail
+}
+
+// backupVolume streams the backup snapshot of a volume into the backup
+// store.  The snapshot is deleted once the copy is complete, whether it
+// succeeded or not.
+func (c *controller) backupVolume(b types.VolumeBackup) {
+	pr, pw := io.Pipe()
+
+	go func() {
+		err := c.ExportBlockDeviceSnapshot(b.VolumeID, b.ID, pw)
+		pw.CloseWithError(err)
+	}()
+
+	err := c.backupStore.Write(b.ID, pr)
+	pr.CloseWithError(err)
+
+	serr := c.DeleteBlockDeviceSnapshot(b.VolumeID, b.ID)
+	if serr != nil {
+		glog.Warningf("Unable to delete backup snapshot %s@%s: %v", b.VolumeID, b.ID, serr)
+	}
+
+	if err != nil {
+		glog.Errorf("Backup %s of volume %s failed: %v", b.ID, b.VolumeID, err)
+		_ = c.backupStore.Delete(b.ID)
+		b.State = types.BackupError
+		b.FailReason = err.Error()
+		msg := fmt.Sprintf("Backup of volume %s failed", b.VolumeID)
+		c.ds.LogError(b.TenantID, msg)
+	} else {
+		b.State = types.BackupAvailable
+	}
+
+	err = c.ds.UpdateVolumeBackup(b)
+	if err != nil {
+		glog.Errorf("Unable to update backup %s: %v", b.ID, err)
+	}
+}
+
+// restoreVolume creates the volume described by data from the contents
+// of a backup.  If the restore fails the volume is removed and the quota
+// it was charged is released.
+func (c *controller) restoreVolume(b types.VolumeBackup, data types.BlockData, resources []payloads.RequestedResource) {
+	var bd storage.BlockDevice
+
+	r, err := c.backupStore.Read(b.ID, 0, 0)
+	if err == nil {
+		bd, err = c.ImportBlockDevice(data.ID, r)
+		r.Close()
+	}
+
+	b.State = types.BackupAvailable
+	uerr := c.ds.UpdateVolumeBackup(b)
+	if uerr != nil {
+		glog.Errorf("Unable to update backup %s: %v", b.ID, uerr)
+	}
+
+	if err != nil {
+		glog.Errorf("Restore of backup %s to volume %s failed: %v", b.ID, data.ID, err)
+		_ = c.ds.DeleteBlockDevice(data.ID)
+		c.qs.Release(data.TenantID, resources...)
+		msg := fmt.Sprintf("Restore of backup %s failed", b.ID)
+		c.ds.LogError(data.TenantID, msg)
+		return
+	}
+
+	data.Bootable = bd.Bootable
+	data.State = types.Available
+	err = c.ds.UpdateBlockDevice(data)
+	if err != nil {
+		glog.Errorf("Unable to update volume %s: %v", data.ID, err)
+	}
+}
+
+// cleanStaleBackups tidies up the backups and restores that were in
+// progress when the controller last stopped.  Backups that were being
+// created are marked as failed and their snapshots removed, volumes that
+// were being restored are deleted and their quota released.
+func (c *controller) cleanStaleBackups() {
+	tenants, err := c.ds.GetAllTenants()
+	if err != nil {
+		glog.Warningf("Unable to retrieve tenants: %v", err)
+		return
+	}
+
+	for _, t := range tenants {
+		backups, err := c.ds.GetVolumeBackups(t.ID)
+		if err != nil {
+			glog.Warningf("Unable to retrieve backups of tenant %s: %v", t.ID, err)
+			continue
+		}
+
+		for _, b := range backups {
+			switch b.State {
+			case types.BackupCreating:
+				err = c.DeleteBlockDeviceSnapshot(b.VolumeID, b.ID)
+				if err != nil {
+					glog.Warningf("Unable to delete backup snapshot %s@%s: %v", b.VolumeID, b.ID, err)
+				}
+				if c.backupStore != nil {
+					_ = c.backupStore.Delete(b.ID)
+				}
+				b.State = types.BackupError
+				b.FailReason = "backup interrupted by a controller restart"
+			case types.BackupRestoring:
+				b.State = types.BackupAvailable
+			default:
+				continue
+			}
+
+			err = c.ds.UpdateVolumeBackup(b)
+			if err != nil {
+				glog.Warningf("Unable to update backup %s: %v", b.ID, err)
+			}
+		}
+
+		devices, err := c.ds.GetBlockDevices(t.ID)
+		if err != nil {
+			glog.Warningf("Unable to retrieve volumes of tenant %s: %v", t.ID, err)
+			continue
+		}
+
+		for _, data := range devices {
+			if data.State != types.RestoringBackup {
+				continue
+			}
+
+			glog.Warningf("Removing volume %s left over from an interrupted restore", data.ID)
+			_ = c.DeleteBlockDevice(data.ID)
+			err = c.ds.DeleteBlockDevice(data.ID)
+			if err != nil {
+				glog.Warningf("Unable to delete volume %s: %v", data.ID, err)
+				continue
+			}
+			c.qs.Release(t.ID,
+				payloads.RequestedResource{Type: payloads.Volume, Value: 1},
+				payloads.RequestedResource{Type: payloads.SharedDiskGiB, Value: data.Size})
+		}
+	}
+}
+
+// CreateBackup snapshots a volume and copies the snapshot to the backup
+// store in the background.  Volumes which are in use can only be backed
+// up if req.Force is set.
+func (c *controller) CreateBackup(tenant string, req block.RequestedBackup) (block.Backup, error) {
+	err := c.confirmTenant(tenant)
+	if err != nil {
+		return block.Backup{}, err
+	}
+
+	if c.backupStore == nil {
+		return block.Backup{}, block.ErrBackupNotSupported
+	}
+
+	info, err := c.ds.GetBlockDevice(req.VolumeID)
+	if err != nil {
+		return block.Backup{}, block.ErrVolumeNotFound
+	}
+
+	if info.TenantID != tenant {
+		return block.Backup{}, block.ErrVolumeOwner
+	}
+
+	if info.State != types.Available &&
+		!(info.State == types.InUse && req.Force) {
+		return block.Backup{}, block.ErrVolumeNotAvailable
+	}
+
+	b := types.VolumeBackup{
+		ID:         uuid.Generate().String(),
+		TenantID:   tenant,
+		VolumeID:   info.ID,
+		State:      types.BackupCreating,
+		CreateTime: time.Now(),
+		Size:       info.Size,
+
+		// The backup holds the volume's data exactly as it is
+		// stored, so the key is needed to read a restored copy.
+		EncryptionKey: info.EncryptionKey,
+		IOLimits:      info.IOLimits,
+		MultiAttach:   info.MultiAttach,
+	}
+
+	if req.Name != nil {
+		b.Name = *req.Name
+	}
+
+	if req.Description != nil {
+		b.Description = *req.Description
+	}
+
+	err = c.CreateBlockDeviceSnapshot(info.ID, b.ID)
+	if err != nil {
+		return block.Backup{}, err
+	}
+
+	err = c.ds.AddVolumeBackup(b)
+	if err != nil {
+		_ = c.DeleteBlockDeviceSnapshot(info.ID, b.ID)
+		return block.Backup{}, err
+	}
+
+	go c.backupVolume(b)
+
+	return block.Backup{
+		ID:    b.ID,
+		Links: make([]block.Link, 0),
+		Name:  req.Name,
+	}, nil
+}
+
+// DeleteBackup removes a backup from the backup store.
+func (c *controller) DeleteBackup(tenant string, backup string) error {
+	err := c.confirmTenant(tenant)
+	if err != nil {
+		return err
+	}
+
+	b, err := c.getTenantBackup(tenant, backup)
+	if err != nil {
+		return err
+	}
+
+	if b.State != types.BackupAvailable && b.State != types.BackupError {
+		return block.ErrBackupNotAvailable
+	}
+
+	if c.backupStore != nil {
+		err = c.backupStore.Delete(b.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return c.ds.DeleteVolumeBackup(b.ID)
+}
+
+// ListBackups returns the backups owned by a tenant.
+func (c *controller) ListBackups(tenant string) ([]block.Backup, error) {
+	err := c.confirmTenant(tenant)
+	if err != nil {
+		return nil, err
+	}
+
+	backups, err := c.ds.GetVolumeBackups(tenant)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := make([]block.Backup, 0, len(backups))
+	for i := range backups {
+		b := block.Backup{
+			ID:    backups[i].ID,
+			Links: make([]block.Link, 0),
+		}
+
+		if backups[i].Name != "" {
+			b.Name = &backups[i].Name
+		}
+
+		resp = append(resp, b)
+	}
+
+	return resp, nil
+}
+
+// ListBackupsDetail returns detailed information about the backups
+// owned by a tenant.
+func (c *controller) ListBackupsDetail(tenant string) ([]block.BackupDetail, error) {
+	err := c.confirmTenant(tenant)
+	if err != nil {
+		return nil, err
+	}
+
+	backups, err := c.ds.GetVolumeBackups(tenant)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := make([]block.BackupDetail, 0, len(backups))
+	for _, b := range backups {
+		resp = append(resp, backupDetail(b))
+	}
+
+	return resp, nil
+}
+
+// ShowBackupDetails returns detailed information about a single backup.
+func (c *controller) ShowBackupDetails(tenant string, backup string) (block.BackupDetail, error) {
+	err := c.confirmTenant(tenant)
+	if err != nil {
+		return block.BackupDetail{}, err
+	}
+
+	b, err := c.getTenantBackup(tenant, backup)
+	if err != nil {
+		return block.BackupDetail{}, err
+	}
+
+	return backupDetail(b), nil
+}
+
+// RestoreBackup creates a new volume from a backup.  The volume is
+// returned straight away in the restoring-backup state and becomes
+// available once the backup data has been copied into it.
+func (c *controller) RestoreBackup(tenant string, backup string, req block.RequestedRestore) (block.Restore, error) {
+	err := c.confirmTenant(tenant)
+	if err != nil {
+		return block.Restore{}, err
+	}
+
+	if req.VolumeID != nil && *req.VolumeID != "" {
+		return block.Restore{}, block.ErrRestoreToVolume
+	}
+
+	if c.backupStore == nil {
+		return block.Restore{}, block.ErrBackupNotSupported
+	}
+
+	b, err := c.getTenantBackup(tenant, backup)
+	if err != nil {
+		return block.Restore{}, err
+	}
+
+	if b.State != types.BackupAvailable {
+		return block.Restore{}, block.ErrBackupNotAvailable
+	}
+
+	res := <-c.qs.Consume(tenant,
+		payloads.RequestedResource{Type: payloads.Volume, Value: 1},
+		payloads.RequestedResource{Type: payloads.SharedDiskGiB, Value: b.Size})
+
+	if !res.Allowed() {
+		c.qs.Release(tenant, res.Resources()...)
+		return block.Restore{}, block.ErrQuota
+	}
+
+	data := types.BlockData{
+		BlockDevice: storage.BlockDevice{
+			ID:   uuid.Generate().String(),
+			Size: b.Size,
+		},
+		CreateTime:    time.Now(),
+		TenantID:      tenant,
+		State:         types.RestoringBackup,
+		EncryptionKey: b.EncryptionKey,
+		IOLimits:      b.IOLimits,
+		MultiAttach:   b.MultiAttach,
+	}
+
+	if req.Name != nil {
+		data.Name = *req.Name
+	}
+
+	err = c.ds.AddBlockDevice(data)
+	if err != nil {
+		c.qs.Release(tenant, res.Resources()...)
+		return block.Restore{}, err
+	}
+
+	b.State = types.BackupRestoring
+	err = c.ds.UpdateVolumeBackup(b)
+	if err != nil {
+		_ = c.ds.DeleteBlockDevice(data.ID)
+		c.qs.Release(tenant, res.Resources()...)
+		return block.Restore{}, err
+	}
+
+	go c.restoreVolume(b, data, res.Resources())
+
+	return block.Restore{
+		BackupID:   b.ID,
+		VolumeID:   data.ID,
+		VolumeName: req.Name,
+	}, nil
+}
@@ -15,8 +15,8 @@
 package main
 
 import (
-	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"sort"
 	"strconv"
@@ -28,6 +28,7 @@ import (
 	"github.com/01org/ciao/payloads"
 	"github.com/01org/ciao/ssntp/uuid"
 	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
 )
 
 func instanceToServer(ctl *controller, instance *types.Instance) (compute.ServerDetails, error) {
@@ -71,6 +72,23 @@ func instanceToServer(ctl *controller, instance *types.Instance) (compute.Server
 		Created: instance.CreateTime,
 	}
 
+	if instance.IPv6Address != "" {
+		server.Addresses.Private = append(server.Addresses.Private,
+			compute.PrivateAddresses{
+				Addr:               instance.IPv6Address,
+				Version:            6,
+				OSEXTIPSMACMacAddr: instance.MACAddress,
+			})
+	}
+
+	for _, nic := range instance.ExtraNICs {
+		server.Addresses.Private = append(server.Addresses.Private,
+			compute.PrivateAddresses{
+				Addr:               nic.IPAddress,
+				OSEXTIPSMACMacAddr: nic.MACAddress,
+			})
+	}
+
 	return server, nil
 }
 
@@ -350,6 +368,45 @@ func abstractBlockDevices(blockDeviceMappings []compute.BlockDeviceMappingV2) (v
 	return
 }
 
+// serverFixedAddress returns the fixed IP and MAC address requested for the
+// primary NIC of a new server, if any. Only the first network of the server
+// can carry them, and it may omit its uuid to ask for an address on the
+// controller allocated subnets.
+func serverFixedAddress(networks []compute.ServerNetwork, nInstances int) (net.IP, net.HardwareAddr, error) {
+	var fixedIP net.IP
+	var mac net.HardwareAddr
+
+	for i, n := range networks {
+		if n.FixedIP == "" && n.MACAddress == "" {
+			if n.UUID == "" {
+				return nil, nil, compute.ErrInvalidAddress
+			}
+			continue
+		}
+
+		if i > 0 || nInstances > 1 || (n.UUID == "" && len(networks) > 1) {
+			return nil, nil, compute.ErrInvalidAddress
+		}
+
+		if n.FixedIP != "" {
+			fixedIP = net.ParseIP(n.FixedIP).To4()
+			if fixedIP == nil {
+				return nil, nil, compute.ErrInvalidAddress
+			}
+		}
+
+		if n.MACAddress != "" {
+			var err error
+			mac, err = net.ParseMAC(n.MACAddress)
+			if err != nil || len(mac) != 6 {
+				return nil, nil, compute.ErrInvalidAddress
+			}
+		}
+	}
+
+	return fixedIP, mac, nil
+}
+
 func (c *controller) CreateServer(tenant string, server compute.CreateServerRequest) (resp interface{}, err error) {
 	nInstances := 1
 
@@ -374,12 +431,31 @@ func (c *controller) CreateServer(tenant string, server compute.CreateServerRequ
 		label = server.Server.Name
 	}
 
+	// instances get a NIC on each of the requested networks, or a
+	// single NIC on the controller allocated subnets if none is given
+	var networkIDs []string
+	for _, n := range server.Server.Networks {
+		networkIDs = append(networkIDs, n.UUID)
+	}
+
+	fixedIP, mac, err := serverFixedAddress(server.Server.Networks, nInstances)
+	if err != nil {
+		return server, err
+	}
+	if len(networkIDs) == 1 && networkIDs[0] == "" {
+		// only a fixed address on the controller allocated subnets
+		networkIDs = nil
+	}
+
 	w := types.WorkloadRequest{
 		WorkloadID: server.Server.Flavor,
 		TenantID:   tenant,
 		Instances:  nInstances,
 		TraceLabel: label,
 		Volumes:    volumes,
+		NetworkIDs: networkIDs,
+		FixedIP:    fixedIP,
+		MACAddress: mac,
 	}
 	var e error
 	instances, err := c.startWorkload(w)
@@ -399,6 +475,14 @@ func (c *controller) CreateServer(tenant string, server compute.CreateServerRequ
 
 	// If no instances launcher or if none converted bail early
 	if e != nil && len(servers.Servers) == 0 {
+		switch errors.Cause(e) {
+		case types.ErrAddressInUse:
+			return server, compute.ErrAddressInUse
+		case types.ErrInvalidIP, types.ErrInvalidMAC, types.ErrBadRequest:
+			if fixedIP != nil || mac != nil {
+				return server, compute.ErrInvalidAddress
+			}
+		}
 		return server, e
 	}
 
@@ -17,12 +17,15 @@ package main
 import (
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
+	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/01org/ciao/ciao-controller/internal/quotas"
+	"github.com/01org/ciao/ciao-controller/types"
 	imageDatastore "github.com/01org/ciao/ciao-image/datastore"
 	"github.com/01org/ciao/ciao-storage"
 	"github.com/01org/ciao/database"
@@ -38,6 +41,13 @@ import (
 type ImageService struct {
 	ds imageDatastore.DataStore
 	qs *quotas.Quotas
+
+	// inUse reports whether instances or volumes created from an image
+	// still exist.  Such images cannot be deleted.
+	inUse func(imageID string) (bool, error)
+
+	// deleted is called once an image has been deleted.
+	deleted func(imageID string)
 }
 
 // CreateImage will create an empty image in the image datastore.
@@ -46,6 +56,10 @@ func (is *ImageService) CreateImage(tenantID string, req image.CreateImageReques
 	// datastore.
 	glog.Infof("Creating Image: %v", req.ID)
 
+	if req.DiskFormat != "" && !req.DiskFormat.Valid() {
+		return image.DefaultResponse{}, image.ErrBadDiskFormat
+	}
+
 	id := req.ID
 	if id == "" {
 		id = uuid.Generate().String()
@@ -70,6 +84,9 @@ func (is *ImageService) CreateImage(tenantID string, req image.CreateImageReques
 		CreateTime: time.Now(),
 		Tags:       strings.Join(req.Tags, ","),
 		Visibility: req.Visibility,
+		Type:       imageDatastore.Type(req.DiskFormat),
+		Protected:  req.Protected,
+		Signature:  req.Signature,
 	}
 
 	err := is.ds.CreateImage(i)
@@ -86,6 +103,10 @@ func (is *ImageService) CreateImage(tenantID string, req image.CreateImageReques
 	}
 
 	glog.Infof("Image %v created", id)
+	diskFormat := req.DiskFormat
+	if diskFormat == "" {
+		diskFormat = image.Raw
+	}
 	size := int(i.Size)
 	tags := []string{}
 	if len(i.Tags) > 0 {
@@ -96,15 +117,16 @@ func (is *ImageService) CreateImage(tenantID string, req image.CreateImageReques
 		CreatedAt:  i.CreateTime,
 		Tags:       tags,
 		Locations:  make([]string, 0),
-		DiskFormat: image.Raw,
+		DiskFormat: diskFormat,
 		Visibility: i.Visibility,
 		Self:       fmt.Sprintf("/v2/images/%s", i.ID),
-		Protected:  false,
+		Protected:  i.Protected,
 		ID:         i.ID,
 		File:       fmt.Sprintf("/v2/images/%s/file", i.ID),
 		Schema:     "/v2/schemas/image",
 		Name:       &i.Name,
 		Size:       &size,
+		Signature:  i.Signature,
 	}, nil
 }
 
@@ -114,20 +136,45 @@ func createImageResponse(img imageDatastore.Image) (image.DefaultResponse, error
 	if len(img.Tags) > 0 {
 		tags = strings.Split(img.Tags, ",")
 	}
+
+	// The original format is only reported for images that were
+	// converted when they were uploaded.
+	var properties interface{}
+	if img.OriginalType != "" && img.OriginalType != img.Type {
+		properties = map[string]string{
+			"original_disk_format": string(img.OriginalType),
+		}
+	}
+
+	var checksum, hashAlgo, hashValue *string
+	if img.Checksum != "" {
+		checksum = &img.Checksum
+	}
+	if img.SHA256 != "" {
+		algo := "sha256"
+		hashAlgo = &algo
+		hashValue = &img.SHA256
+	}
+
 	return image.DefaultResponse{
-		Status:     img.State.Status(),
-		CreatedAt:  img.CreateTime,
-		Tags:       tags,
-		Locations:  make([]string, 0),
-		DiskFormat: image.DiskFormat(img.Type),
-		Visibility: img.Visibility,
-		Self:       fmt.Sprintf("/v2/images/%s", img.ID),
-		Protected:  false,
-		ID:         img.ID,
-		File:       fmt.Sprintf("/v2/images/%s/file", img.ID),
-		Schema:     "/v2/schemas/image",
-		Name:       &img.Name,
-		Size:       &size,
+		Status:      img.State.Status(),
+		CreatedAt:   img.CreateTime,
+		Tags:        tags,
+		Locations:   make([]string, 0),
+		DiskFormat:  image.DiskFormat(img.Type),
+		Visibility:  img.Visibility,
+		Self:        fmt.Sprintf("/v2/images/%s", img.ID),
+		Protected:   img.Protected,
+		ID:          img.ID,
+		File:        fmt.Sprintf("/v2/images/%s/file", img.ID),
+		Schema:      "/v2/schemas/image",
+		Name:        &img.Name,
+		Size:        &size,
+		CheckSum:    checksum,
+		OSHashAlgo:  hashAlgo,
+		OSHashValue: hashValue,
+		Properties:  properties,
+		Signature:   img.Signature,
 	}, nil
 }
 
@@ -151,11 +198,12 @@ func (is *ImageService) ListImages(tenant string) ([]image.DefaultResponse, erro
 }
 
 // UploadImage will upload a raw image data and update its status.
-func (is *ImageService) UploadImage(tenantID, imageID string, body io.Reader) (image.NoContentImageResponse, error) {
+// If checksum is not empty the upload fails unless the data matches it.
+func (is *ImageService) UploadImage(tenantID, imageID string, body io.Reader, checksum string) (image.NoContentImageResponse, error) {
 	glog.Infof("Uploading image: %v", imageID)
 	var response image.NoContentImageResponse
 
-	err := is.ds.UploadImage(tenantID, imageID, body)
+	err := is.ds.UploadImage(tenantID, imageID, body, checksum)
 	if err != nil {
 		glog.Errorf("Error on uploading image: %v", err)
 		return response, err
@@ -166,11 +214,159 @@ func (is *ImageService) UploadImage(tenantID, imageID string, body io.Reader) (i
 	return response, nil
 }
 
-// DeleteImage will delete a raw image and its metadata
+// ImportImage will start fetching the raw image data from the location
+// given in the request.
+func (is *ImageService) ImportImage(tenantID, imageID string, req image.ImportImageRequest) (image.NoContentImageResponse, error) {
+	glog.Infof("Importing image %v from %v", imageID, req.Method.URI)
+	var response image.NoContentImageResponse
+
+	err := is.ds.ImportImage(tenantID, imageID, req.Method.URI, req.Method.Checksum)
+	if err != nil {
+		glog.Errorf("Error on importing image: %v", err)
+		return response, err
+	}
+
+	response.ImageID = imageID
+	return response, nil
+}
+
+// DownloadImage will return length bytes of the raw image data, starting
+// at offset.  The MD5 digest of the data is only known when the image was
+// stored as uploaded.
+func (is *ImageService) DownloadImage(tenantID, imageID string, offset, length uint64) (image.ImageData, error) {
+	glog.Infof("Downloading image %v, %d bytes from %d", imageID, length, offset)
+	var response image.ImageData
+
+	img, err := is.ds.GetImage(tenantID, imageID)
+	if err != nil {
+		glog.Errorf("Error on getting image: %v", err)
+		return response, err
+	}
+
+	r, err := is.ds.DownloadImage(tenantID, imageID, offset, length)
+	if err != nil {
+		glog.Errorf("Error on downloading image: %v", err)
+		return response, err
+	}
+
+	response.ReadCloser = r
+	if img.OriginalType == "" || img.OriginalType == img.Type {
+		response.Checksum = img.Checksum
+	}
+
+	return response, nil
+}
+
+// UpdateImage will change the attributes of an image.  Images that are
+// made public or internal, or private again, are moved to the images of
+// the corresponding tenant, which is charged for them.
+func (is *ImageService) UpdateImage(tenantID, imageID string, req image.UpdateImageRequest) (image.DefaultResponse, error) {
+	glog.Infof("Updating image: %v", imageID)
+
+	img, err := is.ds.GetImage(tenantID, imageID)
+	if err != nil {
+		glog.Errorf("Error on getting image: %v", err)
+		return image.DefaultResponse{}, err
+	}
+
+	if (img == imageDatastore.Image{}) {
+		return image.DefaultResponse{}, image.ErrNoImage
+	}
+
+	if img.TenantID != tenantID {
+		return image.DefaultResponse{}, image.ErrForbiddenAccess
+	}
+
+	if img.State == imageDatastore.Saving || img.State == imageDatastore.Importing {
+		return image.DefaultResponse{}, image.ErrImageSaving
+	}
+
+	if req.Name != nil {
+		img.Name = *req.Name
+	}
+
+	if req.Tags != nil {
+		img.Tags = strings.Join(*req.Tags, ",")
+	}
+
+	if req.Protected != nil {
+		img.Protected = *req.Protected
+	}
+
+	if req.DiskFormat != nil {
+		if img.State != imageDatastore.Created {
+			return image.DefaultResponse{}, image.ErrImageNotQueued
+		}
+		img.Type = imageDatastore.Type(*req.DiskFormat)
+	}
+
+	// The signature is verified when the data is uploaded so it cannot
+	// be changed afterwards.
+	if len(req.SignatureProperties) > 0 {
+		if img.State != imageDatastore.Created {
+			return image.DefaultResponse{}, image.ErrImageNotQueued
+		}
+		for property, value := range req.SignatureProperties {
+			img.Signature.SetProperty(property, value)
+		}
+	}
+
+	if req.Visibility == nil || *req.Visibility == img.Visibility {
+		err = is.ds.UpdateImage(img)
+		if err != nil {
+			glog.Errorf("Error on updating image: %v", err)
+			return image.DefaultResponse{}, err
+		}
+
+		return createImageResponse(img)
+	}
+
+	img.Visibility = *req.Visibility
+	img.TenantID = req.TenantID
+	if img.Visibility == image.Public || img.Visibility == image.Internal {
+		img.TenantID = string(img.Visibility)
+	}
+
+	quota := payloads.RequestedResource{Type: payloads.Image, Value: 1}
+	res := <-is.qs.Consume(img.TenantID, quota)
+	if !res.Allowed() {
+		is.qs.Release(img.TenantID, quota)
+		return image.DefaultResponse{}, image.ErrQuota
+	}
+
+	err = is.ds.MoveImage(tenantID, img)
+	if err != nil {
+		glog.Errorf("Error on moving image: %v", err)
+		is.qs.Release(img.TenantID, quota)
+		return image.DefaultResponse{}, err
+	}
+
+	is.qs.Release(tenantID, quota)
+
+	glog.Infof("Image %v is now %s", imageID, img.Visibility)
+	return createImageResponse(img)
+}
+
+// DeleteImage will delete a raw image and its metadata.  Protected
+// images and images from which existing instances or volumes were
+// created cannot be deleted.
 func (is *ImageService) DeleteImage(tenantID, imageID string) (image.NoContentImageResponse, error) {
 	glog.Infof("Deleting image: %v", imageID)
 	var response image.NoContentImageResponse
 
+	if is.inUse != nil {
+		inUse, err := is.inUse(imageID)
+		if err != nil {
+			glog.Errorf("Error on checking if image is in use: %v", err)
+			return response, err
+		}
+
+		if inUse {
+			glog.Infof("Image %v is in use", imageID)
+			return response, image.ErrImageInUse
+		}
+	}
+
 	err := is.ds.DeleteImage(tenantID, imageID)
 	if err != nil {
 		glog.Errorf("Error on deleting image: %v", err)
@@ -179,6 +375,10 @@ func (is *ImageService) DeleteImage(tenantID, imageID string) (image.NoContentIm
 
 	is.qs.Release(tenantID, payloads.RequestedResource{Type: payloads.Image, Value: 1})
 
+	if is.deleted != nil {
+		is.deleted(imageID)
+	}
+
 	response.ImageID = imageID
 	glog.Infof("Image %v deleted", imageID)
 	return response, nil
@@ -205,6 +405,298 @@ func (is *ImageService) GetImage(tenantID, imageID string) (image.DefaultRespons
 	return response, nil
 }
 
+// setImageState moves an image owned by tenantID from the from state to
+// the to state.  Images already in the to state are left alone.
+func (is *ImageService) setImageState(tenantID, imageID string, from, to imageDatastore.State) error {
+	img, err := is.ds.GetImage(tenantID, imageID)
+	if err != nil {
+		glog.Errorf("Error on getting image: %v", err)
+		return err
+	}
+
+	if (img == imageDatastore.Image{}) {
+		return image.ErrNoImage
+	}
+
+	if img.TenantID != tenantID {
+		return image.ErrForbiddenAccess
+	}
+
+	if img.State == to {
+		return nil
+	}
+
+	if img.State != from {
+		return image.ErrImageNotActive
+	}
+
+	img.State = to
+	err = is.ds.UpdateImage(img)
+	if err != nil {
+		glog.Errorf("Error on updating image: %v", err)
+		return err
+	}
+
+	glog.Infof("Image %v is now %s", imageID, to)
+	return nil
+}
+
+// DeactivateImage stops new instances and volumes from being created
+// from an active image.  Existing instances are left running.
+func (is *ImageService) DeactivateImage(tenantID, imageID string) error {
+	return is.setImageState(tenantID, imageID, imageDatastore.Active, imageDatastore.Deactivated)
+}
+
+// ReactivateImage makes a deactivated image usable again.
+func (is *ImageService) ReactivateImage(tenantID, imageID string) error {
+	return is.setImageState(tenantID, imageID, imageDatastore.Deactivated, imageDatastore.Active)
+}
+
+func createMemberResponse(m imageDatastore.Member) image.Member {
+	return image.Member{
+		CreatedAt: m.CreateTime,
+		ImageID:   m.ImageID,
+		MemberID:  m.TenantID,
+		Schema:    "/v2/schemas/member",
+		Status:    m.Status,
+		UpdatedAt: m.UpdateTime,
+	}
+}
+
+// ListMembers will return the tenants an image is shared with.
+func (is *ImageService) ListMembers(tenantID, imageID string) ([]image.Member, error) {
+	response := []image.Member{}
+
+	members, err := is.ds.GetMembers(tenantID, imageID)
+	if err != nil {
+		glog.Errorf("Error on getting members of image %v: %v", imageID, err)
+		return response, err
+	}
+
+	for _, m := range members {
+		response = append(response, createMemberResponse(m))
+	}
+
+	return response, nil
+}
+
+// AddMember will share an image with a tenant.
+func (is *ImageService) AddMember(tenantID, imageID, memberID string) (image.Member, error) {
+	glog.Infof("Sharing image %v with %v", imageID, memberID)
+
+	m, err := is.ds.AddMember(tenantID, imageID, memberID)
+	if err != nil {
+		glog.Errorf("Error on sharing image: %v", err)
+		return image.Member{}, err
+	}
+
+	return createMemberResponse(m), nil
+}
+
+// UpdateMember will accept or reject an image shared with a tenant.
+func (is *ImageService) UpdateMember(tenantID, imageID, memberID string, status image.MemberStatus) (image.Member, error) {
+	glog.Infof("Setting status of %v for image %v to %v", memberID, imageID, status)
+
+	m, err := is.ds.UpdateMember(tenantID, imageID, memberID, status)
+	if err != nil {
+		glog.Errorf("Error on updating image member: %v", err)
+		return image.Member{}, err
+	}
+
+	return createMemberResponse(m), nil
+}
+
+// DeleteMember will stop sharing an image with a tenant.
+func (is *ImageService) DeleteMember(tenantID, imageID, memberID string) error {
+	glog.Infof("Unsharing image %v with %v", imageID, memberID)
+
+	err := is.ds.DeleteMember(tenantID, imageID, memberID)
+	if err != nil {
+		glog.Errorf("Error on deleting image member: %v", err)
+	}
+
+	return err
+}
+
+// findImage returns the image imageID visible to tenant.  The boolean is
+// false if the image service is not running or does not know the image.
+func (c *controller) findImage(tenant, imageID string) (imageDatastore.Image, bool) {
+	c.imageServiceLock.RLock()
+	is := c.imageService
+	c.imageServiceLock.RUnlock()
+
+	if is == nil {
+		return imageDatastore.Image{}, false
+	}
+
+	tables := []string{tenant, string(image.Public), string(image.Internal)}
+	for _, table := range tables {
+		img, err := is.ds.GetImage(table, imageID)
+		if err == nil && img.ID == imageID {
+			return img, true
+		}
+	}
+
+	return imageDatastore.Image{}, false
+}
+
+// imageChecksum returns the SHA-256 digest of the data stored for an image
+// visible to tenant, or an empty string if the digest is not known.
+func (c *controller) imageChecksum(tenant, imageID string) string {
+	img, ok := c.findImage(tenant, imageID)
+	if !ok {
+		return ""
+	}
+
+	if img.StoredSHA256 != "" {
+		return img.StoredSHA256
+	}
+	return img.SHA256
+}
+
+// stageImage copies the data of an image held outside of the Ceph cluster
+// into it, the first time the image is used, so that volumes can be cloned
+// from it in the same way as from images held in Ceph.  The copy is
+// removed when the image is deleted.
+func (c *controller) stageImage(tenant, imageID string) error {
+	if *imageStore == "ceph" {
+		return nil
+	}
+
+	c.imageStagingLock.Lock()
+	defer c.imageStagingLock.Unlock()
+
+	if _, err := c.GetBlockDeviceSize(imageID); err == nil {
+		return nil
+	}
+
+	c.imageServiceLock.RLock()
+	is := c.imageService
+	c.imageServiceLock.RUnlock()
+
+	img, ok := c.findImage(tenant, imageID)
+	if !ok || is == nil {
+		return image.ErrNoImage
+	}
+
+	r, err := is.ds.DownloadImage(img.TenantID, imageID, 0, 0)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = r.Close() }()
+
+	f, err := ioutil.TempFile(*imagesPath, "ciao-image")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(f.Name()) }()
+
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = c.CreateBlockDevice(imageID, f.Name(), 0)
+	if err != nil {
+		return err
+	}
+
+	err = c.CreateBlockDeviceSnapshot(imageID, "ciao-image")
+	if err != nil {
+		_ = c.DeleteBlockDevice(imageID)
+		return err
+	}
+
+	glog.Infof("Image %s copied to the storage backend", imageID)
+
+	return nil
+}
+
+// unstageImage removes the copy made by stageImage of a deleted image.
+func (c *controller) unstageImage(imageID string) {
+	if *imageStore == "ceph" {
+		return
+	}
+
+	c.imageStagingLock.Lock()
+	defer c.imageStagingLock.Unlock()
+
+	if _, err := c.GetBlockDeviceSize(imageID); err != nil {
+		return
+	}
+
+	err := c.DeleteBlockDeviceSnapshot(imageID, "ciao-image")
+	if err == nil {
+		err = c.DeleteBlockDevice(imageID)
+	}
+	if err != nil {
+		glog.Warningf("Unable to remove the copy of image %s: %v", imageID, err)
+	}
+}
+
+// checkImageBootable returns an error if no new instances or volumes can
+// be created from an image because it has been deactivated or, when
+// signed images are required, because its signature was not verified or
+// its data no longer matches the signed data.  Otherwise images that are
+// unknown to the image service are left for the storage backend to
+// reject.
+func (c *controller) checkImageBootable(tenant, imageID string) error {
+	img, ok := c.findImage(tenant, imageID)
+	if ok && img.State == imageDatastore.Deactivated {
+		return image.ErrImageDeactivated
+	}
+
+	if requireSignedImages {
+		if !ok || img.SignedSHA256 == "" || img.SignedSHA256 != img.SHA256 {
+			return image.ErrImageNotSigned
+		}
+	}
+
+	return nil
+}
+
+// imageInUse returns true if an instance booted from imageID, or a volume
+// cloned from it, still exists.
+func (c *controller) imageInUse(imageID string) (bool, error) {
+	for _, bd := range c.ds.GetAllBlockDevices() {
+		if bd.ImageID == imageID {
+			return true, nil
+		}
+	}
+
+	instances, err := c.ds.GetAllInstances()
+	if err != nil {
+		return false, err
+	}
+
+	bootsFromImage := make(map[string]bool)
+	for _, i := range instances {
+		uses, ok := bootsFromImage[i.WorkloadID]
+		if !ok {
+			wl, err := c.ds.GetWorkload(i.TenantID, i.WorkloadID)
+			if err != nil {
+				continue
+			}
+
+			for _, s := range wl.Storage {
+				if s.SourceType == types.ImageService && s.SourceID == imageID {
+					uses = true
+				}
+			}
+			bootsFromImage[i.WorkloadID] = uses
+		}
+
+		if uses {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
 // ImageConfig is required to setup the API context for the image service.
 type ImageConfig struct {
 	// Port represents the http port that should be used for the service.
@@ -223,6 +715,67 @@ type ImageConfig struct {
 	MetaDataStore imageDatastore.MetaDataStore
 }
 
+// imageOwners returns the owners of all the images that may be held by
+// the image service: the public and internal owners and every tenant.
+func (c *controller) imageOwners() ([]string, error) {
+	tenants, err := c.ds.GetAllTenants()
+	if err != nil {
+		return nil, err
+	}
+
+	owners := []string{string(image.Public), string(image.Internal)}
+	for _, t := range tenants {
+		owners = append(owners, t.ID)
+	}
+
+	return owners, nil
+}
+
+// imageRawDataStore returns the raw datastore selected by the
+// image_store flag.  The S3 credentials are taken from the
+// AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment variables so
+// that they do not appear on the command line.
+func imageRawDataStore() (imageDatastore.RawDataStore, error) {
+	glog.Info("ciao-image - Initialize raw datastore")
+
+	switch *imageStore {
+	case "ceph":
+		rawDs := &imageDatastore.Ceph{
+			ImageTempDir: *imagesPath,
+			BlockDriver: storage.CephDriver{
+				ID: *cephID,
+			},
+		}
+
+		glog.Infof("rawDs        : %T", rawDs)
+		glog.Infof("ImageTempDir : %v", rawDs.ImageTempDir)
+		glog.Infof("ID           : %v", rawDs.BlockDriver.ID)
+
+		return rawDs, nil
+	case "s3":
+		if *imageS3Endpoint == "" {
+			return nil, fmt.Errorf("image_s3_endpoint is required for the s3 image store")
+		}
+
+		rawDs := &imageDatastore.S3{
+			Endpoint:  *imageS3Endpoint,
+			Bucket:    *imageS3Bucket,
+			Region:    *imageS3Region,
+			AccessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			PartSize:  *imageS3PartSize << 20,
+		}
+
+		glog.Infof("rawDs        : %T", rawDs)
+		glog.Infof("Endpoint     : %v", rawDs.Endpoint)
+		glog.Infof("Bucket       : %v", rawDs.Bucket)
+
+		return rawDs, nil
+	}
+
+	return nil, fmt.Errorf("unknown image store %s", *imageStore)
+}
+
 // startImageService will get the Image API endpoints from the OpenStack image api,
 // then wrap them in keystone validation. It will then start the https
 // service.
@@ -256,18 +809,11 @@ func (c *controller) startImageService() error {
 		glog.Fatalf("Error on DB Tables Initialization: %v ", err)
 	}
 
-	rawDs := &imageDatastore.Ceph{
-		ImageTempDir: *imagesPath,
-		BlockDriver: storage.CephDriver{
-			ID: *cephID,
-		},
+	rawDs, err := imageRawDataStore()
+	if err != nil {
+		glog.Fatalf("Error on raw datastore initialization: %v", err)
 	}
 
-	glog.Info("ciao-image - Initialize raw datastore")
-	glog.Infof("rawDs        : %T", rawDs)
-	glog.Infof("ImageTempDir : %v", rawDs.ImageTempDir)
-	glog.Infof("ID           : %v", rawDs.BlockDriver.ID)
-
 	config := ImageConfig{
 		Port:          image.APIPort,
 		HTTPSCACert:   httpsCAcert,
@@ -283,12 +829,32 @@ func (c *controller) startImageService() error {
 	glog.Infof("RawDataStore  : %T", config.RawDataStore)
 	glog.Infof("MetaDataStore : %T", config.MetaDataStore)
 
-	is := ImageService{ds: &imageDatastore.ImageStore{}, qs: c.qs}
+	ds := &imageDatastore.ImageStore{
+		MaxImportSize:       uint64(*imageImportMaxSize) << 30,
+		AllowPrivateImports: *imageImportAllowPrivate,
+		TempDir:             *imagesPath,
+		CertDir:             imageSigningCerts,
+	}
+	is := ImageService{ds: ds, qs: c.qs, inUse: c.imageInUse, deleted: c.unstageImage}
 	err = is.ds.Init(config.RawDataStore, config.MetaDataStore)
 	if err != nil {
 		return err
 	}
 
+	owners, err := c.imageOwners()
+	if err != nil {
+		return err
+	}
+
+	err = ds.CleanInterruptedTransfers(owners)
+	if err != nil {
+		return err
+	}
+
+	c.imageServiceLock.Lock()
+	c.imageService = &is
+	c.imageServiceLock.Unlock()
+
 	apiConfig := image.APIConfig{
 		Port:         config.Port,
 		ImageService: &is,
@@ -15,8 +15,11 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/base64"
 	"errors"
 	"fmt"
+	"math"
 	"net/http"
 	"strconv"
 	"time"
@@ -30,6 +33,140 @@ import (
 	"github.com/gorilla/mux"
 )
 
+// ioLimitsFields maps the volume metadata keys used to get and set the
+// I/O limits of a volume to the corresponding fields of l.
+func ioLimitsFields(l *payloads.IOLimits) map[string]*int64 {
+	return map[string]*int64{
+		"total_bytes_sec": &l.TotalBytesSec,
+		"read_bytes_sec":  &l.ReadBytesSec,
+		"write_bytes_sec": &l.WriteBytesSec,
+		"total_iops_sec":  &l.TotalIOPSSec,
+		"read_iops_sec":   &l.ReadIOPSSec,
+		"write_iops_sec":  &l.WriteIOPSSec,
+	}
+}
+
+// applyIOLimitsMetaData overrides the limits in l with any I/O limits
+// found in the volume metadata.  A value of 0 removes the limit.
+func applyIOLimitsMetaData(l *payloads.IOLimits, meta block.MetaData) error {
+	m, ok := meta.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	fields := ioLimitsFields(l)
+	for key, val := range m {
+		field, ok := fields[key]
+		if !ok {
+			continue
+		}
+
+		var limit int64
+		switch val := val.(type) {
+		case string:
+			var err error
+			limit, err = strconv.ParseInt(val, 10, 64)
+			if err != nil {
+				return block.ErrInvalidIOLimits
+			}
+		case float64:
+			if val != math.Trunc(val) || val > math.MaxInt64 {
+				return block.ErrInvalidIOLimits
+			}
+			limit = int64(val)
+		default:
+			return block.ErrInvalidIOLimits
+		}
+
+		if limit < 0 {
+			return block.ErrInvalidIOLimits
+		}
+
+		*field = limit
+	}
+
+	// QEMU refuses a total limit combined with a read or write limit
+	if l.TotalBytesSec != 0 && (l.ReadBytesSec != 0 || l.WriteBytesSec != 0) {
+		return block.ErrInvalidIOLimits
+	}
+
+	if l.TotalIOPSSec != 0 && (l.ReadIOPSSec != 0 || l.WriteIOPSSec != 0) {
+		return block.ErrInvalidIOLimits
+	}
+
+	return nil
+}
+
+// ioLimitsMetaData returns the volume metadata describing the I/O limits l.
+func ioLimitsMetaData(l payloads.IOLimits) map[string]interface{} {
+	meta := make(map[string]interface{})
+
+	for key, field := range ioLimitsFields(&l) {
+		if *field != 0 {
+			meta[key] = strconv.FormatInt(*field, 10)
+		}
+	}
+
+	return meta
+}
+
+// volumeIOLimits returns the I/O limits of an existing volume, or nil
+// if the volume is not throttled.
+func (c *controller) volumeIOLimits(volumeID string) *payloads.IOLimits {
+	data, err := c.ds.GetBlockDevice(volumeID)
+	if err != nil || data.IOLimits.IsZero() {
+		return nil
+	}
+
+	return &data.IOLimits
+}
+
+// volumeEncryptionKey returns the LUKS secret of an existing volume, or an
+// empty string if the volume is not encrypted.
+func (c *controller) volumeEncryptionKey(volumeID string) string {
+	data, err := c.ds.GetBlockDevice(volumeID)
+	if err != nil {
+		return ""
+	}
+
+	return data.EncryptionKey
+}
+
+// generateEncryptionKey returns a new random base64 encoded key suitable
+// for use as the LUKS secret of a volume.
+func generateEncryptionKey() (string, error) {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	if err != nil {
+		return "", fmt.Errorf("Unable to generate encryption key: %v", err)
+	}
+
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// volumeAttachments returns the attachments of a volume in the format
+// expected by the block API.
+func (c *controller) volumeAttachments(volumeID string) []block.Attachment {
+	attachments := make([]block.Attachment, 0)
+
+	data, err := c.ds.GetVolumeAttachments(volumeID)
+	if err != nil {
+		glog.Warningf("Unable to retrieve attachments of volume %s: %v", volumeID, err)
+		return attachments
+	}
+
+	for _, a := range data {
+		attachments = append(attachments, block.Attachment{
+			ServerUUID:     a.InstanceID,
+			AttachmentUUID: a.ID,
+			VolumeUUID:     a.BlockID,
+			DeviceUUID:     a.BlockID,
+		})
+	}
+
+	return attachments
+}
+
 // Implement the Block Service interface
 func (c *controller) GetAbsoluteLimits(tenant string) (block.AbsoluteLimits, error) {
 	err := c.confirmTenant(tenant)
@@ -47,16 +184,61 @@ func (c *controller) CreateVolume(tenant string, req block.RequestedVolume) (blo
 		return block.Volume{}, err
 	}
 
+	var vt payloads.ConfigureVolumeType
+
+	if req.VolumeType != nil && *req.VolumeType != "" {
+		var ok bool
+		vt, ok = volumeTypes[*req.VolumeType]
+		if !ok {
+			return block.Volume{}, block.ErrVolumeTypeNotFound
+		}
+	}
+
+	limits := vt.IOLimits
+	err = applyIOLimitsMetaData(&limits, req.MetaData)
+	if err != nil {
+		return block.Volume{}, err
+	}
+
 	var bd storage.BlockDevice
+	var key string
 
 	// no limits checking for now.
 	if req.ImageRef != nil {
+		// images are stored unencrypted so there's no way of
+		// creating an encrypted volume from them.
+		if vt.Encrypted {
+			return block.Volume{}, block.ErrEncryptionNotSupported
+		}
+
+		if c.checkImageBootable(tenant, *req.ImageRef) != nil {
+			return block.Volume{}, block.ErrImageNotAvailable
+		}
+
+		if c.stageImage(tenant, *req.ImageRef) != nil {
+			return block.Volume{}, block.ErrImageNotAvailable
+		}
+
 		// create bootable volume
 		bd, err = c.CreateBlockDeviceFromSnapshot(*req.ImageRef, "ciao-image")
 		bd.Bootable = true
 	} else if req.SourceVolID != nil {
+		// a copy of an encrypted volume is encrypted with the same key.
+		key = c.volumeEncryptionKey(*req.SourceVolID)
+		if vt.Encrypted && key == "" {
+			return block.Volume{}, block.ErrEncryptionNotSupported
+		}
+
 		// copy existing volume
 		bd, err = c.CopyBlockDevice(*req.SourceVolID)
+	} else if vt.Encrypted {
+		key, err = generateEncryptionKey()
+		if err != nil {
+			return block.Volume{}, err
+		}
+
+		// create empty encrypted volume
+		bd, err = c.CreateEncryptedBlockDevice("", req.Size, key)
 	} else {
 		// create empty volume
 		bd, err = c.CreateBlockDevice("", "", req.Size)
@@ -71,10 +253,17 @@ func (c *controller) CreateVolume(tenant string, req block.RequestedVolume) (blo
 	// the block device data with the device itself?
 	// you should modify BlockData to include a "bootable" flag.
 	data := types.BlockData{
-		BlockDevice: bd,
-		CreateTime:  time.Now(),
-		TenantID:    tenant,
-		State:       types.Available,
+		BlockDevice:   bd,
+		CreateTime:    time.Now(),
+		TenantID:      tenant,
+		State:         types.Available,
+		IOLimits:      limits,
+		MultiAttach:   req.MultiAttach,
+		EncryptionKey: key,
+	}
+
+	if req.ImageRef != nil {
+		data.ImageID = *req.ImageRef
 	}
 
 	if req.Name != nil {
@@ -87,7 +276,8 @@ func (c *controller) CreateVolume(tenant string, req block.RequestedVolume) (blo
 
 	// It's best to make the quota request here as we don't know the volume
 	// size earlier. If the ceph cluster is full then it might error out
-	// earlier.
+	// earlier.  A multi-attach volume is only charged once, no matter how
+	// many instances it ends up being attached to.
 	res := <-c.qs.Consume(tenant,
 		payloads.RequestedResource{Type: payloads.Volume, Value: 1},
 		payloads.RequestedResource{Type: payloads.SharedDiskGiB, Value: bd.Size})
@@ -115,9 +305,102 @@ func (c *controller) CreateVolume(tenant string, req block.RequestedVolume) (blo
 		ID:          bd.ID,
 		Size:        data.Size,
 		Bootable:    strconv.FormatBool(data.Bootable),
+		VolumeType:  req.VolumeType,
+		MultiAttach: data.MultiAttach,
+		Encrypted:   data.EncryptionKey != "",
+		MetaData:    ioLimitsMetaData(data.IOLimits),
 	}, nil
 }
 
+// UpdateVolume will update the name, description and I/O limits of a
+// volume.  The new I/O limits are applied immediately to all the instances
+// the volume is attached to.
+func (c *controller) UpdateVolume(tenant string, volume string, req block.RequestedVolumeUpdate) (block.Volume, error) {
+	err := c.confirmTenant(tenant)
+	if err != nil {
+		return block.Volume{}, err
+	}
+
+	info, err := c.ds.GetBlockDevice(volume)
+	if err != nil {
+		return block.Volume{}, block.ErrVolumeNotFound
+	}
+
+	if info.TenantID != tenant {
+		return block.Volume{}, block.ErrVolumeOwner
+	}
+
+	limits := info.IOLimits
+	err = applyIOLimitsMetaData(&limits, req.MetaData)
+	if err != nil {
+		return block.Volume{}, err
+	}
+
+	limitsChanged := limits != info.IOLimits
+	info.IOLimits = limits
+
+	if req.Name != nil {
+		info.Name = *req.Name
+	}
+
+	if req.Description != nil {
+		info.Description = *req.Description
+	}
+
+	var attachments []types.StorageAttachment
+	if limitsChanged && info.State == types.InUse {
+		attachments, err = c.ds.GetVolumeAttachments(volume)
+		if err != nil {
+			return block.Volume{}, err
+		}
+	}
+
+	err = c.ds.UpdateBlockDevice(info)
+	if err != nil {
+		return block.Volume{}, err
+	}
+
+	// The stored limits are applied whenever the volume is attached, an
+	// instance that misses the update keeps its old limits until then.
+	for _, a := range attachments {
+		i, err := c.ds.GetInstance(a.InstanceID)
+		if err != nil {
+			glog.Errorf("Can't find instance %s of volume %s", a.InstanceID, volume)
+			continue
+		}
+
+		err = c.client.updateVolume(volume, a.InstanceID, i.NodeID, limits)
+		if err != nil {
+			glog.Warningf("Can't update I/O limits of volume %s of instance %s: %v",
+				volume, a.InstanceID, err)
+		}
+	}
+
+	vol := block.Volume{
+		Status:      block.VolumeStatus(info.State),
+		UserID:      tenant,
+		Attachments: c.volumeAttachments(info.ID),
+		Links:       make([]block.Link, 0),
+		CreatedAt:   &info.CreateTime,
+		ID:          info.ID,
+		Size:        info.Size,
+		Bootable:    strconv.FormatBool(info.Bootable),
+		MultiAttach: info.MultiAttach,
+		Encrypted:   info.EncryptionKey != "",
+		MetaData:    ioLimitsMetaData(info.IOLimits),
+	}
+
+	if info.Name != "" {
+		vol.Name = &info.Name
+	}
+
+	if info.Description != "" {
+		vol.Description = &info.Description
+	}
+
+	return vol, nil
+}
+
 func (c *controller) DeleteVolume(tenant string, volume string) error {
 	err := c.confirmTenant(tenant)
 	if err != nil {
@@ -135,8 +418,9 @@ func (c *controller) DeleteVolume(tenant string, volume string) error {
 		return block.ErrVolumeOwner
 	}
 
-	// check that the block device is available.
-	if info.State != types.Available {
+	// check that the block device is available and that its
+	// backup snapshot is not still being copied.
+	if info.State != types.Available || c.volumeBackingUp(volume, tenant) {
 		return block.ErrVolumeNotAvailable
 	}
 
@@ -172,8 +456,11 @@ func (c *controller) AttachVolume(tenant string, volume string, instance string,
 		return err
 	}
 
-	// check that the block device is available.
-	if info.State != types.Available {
+	// check that the block device is available.  A multi-attach
+	// volume can also be attached while it is in use.
+	prevState := info.State
+	if info.State != types.Available &&
+		(!info.MultiAttach || info.State != types.InUse) {
 		return block.ErrVolumeNotAvailable
 	}
 
@@ -192,23 +479,41 @@ func (c *controller) AttachVolume(tenant string, volume string, instance string,
 		return block.ErrInstanceOwner
 	}
 
-	// update volume state to attaching
-	info.State = types.Attaching
+	// a multi-attach volume can only be shared with other instances
+	// if all of its existing attachments are read-only, and can only
+	// be attached once to a given instance.
+	if prevState == types.InUse {
+		attachments, err := c.ds.GetVolumeAttachments(volume)
+		if err != nil {
+			return err
+		}
 
-	err = c.ds.UpdateBlockDevice(info)
-	if err != nil {
-		return err
+		for _, a := range attachments {
+			if a.InstanceID == instance || !a.ReadOnly {
+				return block.ErrVolumeNotAvailable
+			}
+		}
+	} else {
+		// update volume state to attaching
+		info.State = types.Attaching
+
+		err = c.ds.UpdateBlockDevice(info)
+		if err != nil {
+			return err
+		}
 	}
 
-	// create an attachment object
+	// create an attachment object.  Multi-attach volumes are
+	// always attached read-only.
 	a := payloads.StorageResource{
 		ID:        info.ID,
 		Ephemeral: false,
 		Bootable:  false,
+		ReadOnly:  info.MultiAttach,
 	}
 	_, err = c.ds.CreateStorageAttachment(i.ID, a)
 	if err != nil {
-		info.State = types.Available
+		info.State = prevState
 		dsErr := c.ds.UpdateBlockDevice(info)
 		if dsErr != nil {
 			glog.Error(dsErr)
@@ -217,9 +522,9 @@ func (c *controller) AttachVolume(tenant string, volume string, instance string,
 	}
 
 	// send command to attach volume.
-	err = c.client.attachVolume(volume, instance, i.NodeID)
+	err = c.client.attachVolume(volume, instance, i.NodeID, info.IOLimits, info.MultiAttach)
 	if err != nil {
-		info.State = types.Available
+		info.State = prevState
 		dsErr := c.ds.UpdateBlockDevice(info)
 		if dsErr != nil {
 			glog.Error(dsErr)
@@ -236,18 +541,25 @@ func (c *controller) DetachVolume(tenant string, volume string, attachment strin
 		return err
 	}
 
-	// we don't support detaching by attachment ID yet.
-	if attachment != "" {
-		return errors.New("Detaching by attachment ID not implemented")
-	}
-
 	// get attachment info
 	attachments, err := c.ds.GetVolumeAttachments(volume)
 	if err != nil {
 		return err
 	}
 
-	if len(attachments) == 0 {
+	// if no attachment ID is given, the volume is detached from
+	// all the instances it is attached to.
+	detach := attachments
+	if attachment != "" {
+		detach = nil
+		for _, a := range attachments {
+			if a.ID == attachment {
+				detach = append(detach, a)
+			}
+		}
+	}
+
+	if len(detach) == 0 {
 		return block.ErrVolumeNotAttached
 	}
 
@@ -270,24 +582,26 @@ func (c *controller) DetachVolume(tenant string, volume string, attachment strin
 	// we cannot detach a boot device - these aren't
 	// like regular attachments and shouldn't be treated
 	// as such.
-	for _, a := range attachments {
+	for _, a := range detach {
 		if a.Boot == true {
 			return block.ErrVolumeNotAttached
 		}
 	}
 
-	// update volume state to detaching
-	info.State = types.Detaching
+	// update volume state to detaching, unless the volume remains
+	// attached to other instances.
+	if len(detach) == len(attachments) {
+		info.State = types.Detaching
 
-	err = c.ds.UpdateBlockDevice(info)
-	if err != nil {
-		return err
+		err = c.ds.UpdateBlockDevice(info)
+		if err != nil {
+			return err
+		}
 	}
 
 	var retval error
 
-	// detach everything for this volume
-	for _, a := range attachments {
+	for _, a := range detach {
 		// get instance info
 		i, err := c.ds.GetInstance(a.InstanceID)
 		if err != nil {
@@ -354,6 +668,10 @@ func (c *controller) ListVolumesDetail(tenant string) ([]block.VolumeDetail, err
 		vol.OSVolTenantAttr = data.TenantID
 		vol.CreatedAt = &data.CreateTime
 		vol.Bootable = strconv.FormatBool(data.Bootable)
+		vol.MultiAttach = data.MultiAttach
+		vol.Encrypted = data.EncryptionKey != ""
+		vol.Attachments = c.volumeAttachments(data.ID)
+		vol.MetaData = ioLimitsMetaData(data.IOLimits)
 
 		if data.Name != "" {
 			vol.Name = &data.Name
@@ -402,6 +720,10 @@ func (c *controller) ShowVolumeDetails(tenant string, volume string) (block.Volu
 	vol.OSVolTenantAttr = data.TenantID
 	vol.CreatedAt = &data.CreateTime
 	vol.Bootable = strconv.FormatBool(data.Bootable)
+	vol.MultiAttach = data.MultiAttach
+	vol.Encrypted = data.EncryptionKey != ""
+	vol.Attachments = c.volumeAttachments(data.ID)
+	vol.MetaData = ioLimitsMetaData(data.IOLimits)
 
 	if data.Name != "" {
 		vol.Name = &data.Name
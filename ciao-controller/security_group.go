@@ -0,0 +1,385 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/01org/ciao/ciao-controller/types"
+	"github.com/01org/ciao/payloads"
+	"github.com/01org/ciao/ssntp/uuid"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+func (c *controller) makeSecurityGroupLinks(sg *types.SecurityGroup, tenant *string) {
+	var ref string
+
+	if tenant != nil {
+		ref = fmt.Sprintf("%s/%s/security-groups/%s", c.apiURL, *tenant, sg.ID)
+	} else {
+		ref = fmt.Sprintf("%s/security-groups/%s", c.apiURL, sg.ID)
+	}
+
+	sg.Links = []types.Link{{Rel: "self", Href: ref}}
+}
+
+// validateSecurityGroupRule checks a rule and puts it in canonical form. A
+// port range with a single port only needs PortMin.
+func validateSecurityGroupRule(rule *types.SecurityGroupRule) error {
+	rule.Protocol = strings.ToLower(rule.Protocol)
+
+	switch rule.Protocol {
+	case "tcp", "udp":
+		if rule.PortMin != 0 && rule.PortMax == 0 {
+			rule.PortMax = rule.PortMin
+		}
+		if rule.PortMin == 0 && rule.PortMax == 0 {
+			break
+		}
+		if rule.PortMin < 1 || rule.PortMax > 65535 || rule.PortMin > rule.PortMax {
+			return types.ErrInvalidSecurityRule
+		}
+	case "icmp", "":
+		if rule.PortMin != 0 || rule.PortMax != 0 {
+			return types.ErrInvalidSecurityRule
+		}
+	default:
+		return types.ErrInvalidSecurityRule
+	}
+
+	if rule.RemoteCIDR != "" {
+		_, subnet, err := net.ParseCIDR(rule.RemoteCIDR)
+		if err != nil {
+			return types.ErrInvalidSecurityRule
+		}
+		rule.RemoteCIDR = subnet.String()
+	}
+
+	return nil
+}
+
+// getTenantSecurityGroup returns a security group owned by tenantID.
+func (c *controller) getTenantSecurityGroup(tenantID string, groupID string) (types.SecurityGroup, error) {
+	sg, err := c.ds.GetSecurityGroup(groupID)
+	if err != nil {
+		return sg, err
+	}
+
+	if sg.TenantID != tenantID {
+		return types.SecurityGroup{}, types.ErrSecurityGroupNotFound
+	}
+
+	return sg, nil
+}
+
+// enforceSecurityGroups sends the rules of all the groups an instance
+// belongs to to the tenant CNCI and to the node running the instance.
+// Ingress filtering is removed from instances that no longer belong to
+// any group.
+func (c *controller) enforceSecurityGroups(instanceID string) error {
+	i, err := c.ds.GetInstance(instanceID)
+	if err != nil {
+		return err
+	}
+
+	t, err := c.ds.GetTenant(i.TenantID)
+	if err != nil {
+		return err
+	}
+	if t == nil {
+		return types.ErrTenantNotFound
+	}
+
+	groups := c.ds.GetInstanceSecurityGroups(instanceID)
+	if len(groups) == 0 {
+		return c.client.removeSecurityRules(*t, *i)
+	}
+
+	var rules []types.SecurityGroupRule
+	for _, sg := range groups {
+		rules = append(rules, sg.Rules...)
+	}
+
+	return c.client.applySecurityRules(*t, *i, rules)
+}
+
+// enforceSecurityGroupMembers updates the rules of all the instances of a
+// security group after the rules of the group have changed.
+func (c *controller) enforceSecurityGroupMembers(sg types.SecurityGroup) error {
+	var lastErr error
+
+	for _, instanceID := range sg.Instances {
+		err := c.enforceSecurityGroups(instanceID)
+		if err != nil {
+			glog.Warningf("Unable to update security rules of %s: %v", instanceID, err)
+			lastErr = err
+		}
+	}
+
+	return errors.Wrapf(lastErr, "error updating security rules of group %s", sg.ID)
+}
+
+// enforceTenantSecurityGroups resends the rules of all the instances in a
+// tenant's security groups, e.g., when a new CNCI joins.
+func (c *controller) enforceTenantSecurityGroups(tenantID string) {
+	for _, sg := range c.ds.GetSecurityGroups(&tenantID) {
+		_ = c.enforceSecurityGroupMembers(sg)
+	}
+}
+
+// securityGroupArrivals returns the instances of a STATS payload that
+// belong to a security group and were not known to run on the node that
+// sent it. That node does not filter their traffic yet.
+func (c *controller) securityGroupArrivals(stats payloads.Stat) []string {
+	var arrived []string
+
+	for _, stat := range stats.Instances {
+		i, err := c.ds.GetInstance(stat.InstanceUUID)
+		if err != nil || i.NodeID == stats.NodeUUID {
+			continue
+		}
+
+		if len(c.ds.GetInstanceSecurityGroups(i.ID)) > 0 {
+			arrived = append(arrived, i.ID)
+		}
+	}
+
+	return arrived
+}
+
+// enforceArrivedInstance sends the rules of an instance to the node it
+// has just been started on, along with its CNCI.
+func (c *controller) enforceArrivedInstance(instanceID string) {
+	err := c.enforceSecurityGroups(instanceID)
+	if err != nil {
+		glog.Warningf("Unable to send security rules of %s to its node: %v", instanceID, err)
+	}
+}
+
+// releaseSecurityGroups removes a deleted instance from all its security
+// groups and stops filtering its traffic, its IP address may be reused.
+func (c *controller) releaseSecurityGroups(instanceID string) {
+	groups := c.ds.GetInstanceSecurityGroups(instanceID)
+	if len(groups) == 0 {
+		return
+	}
+
+	for _, sg := range groups {
+		for j, member := range sg.Instances {
+			if member == instanceID {
+				sg.Instances = append(sg.Instances[:j], sg.Instances[j+1:]...)
+				break
+			}
+		}
+
+		err := c.ds.UpdateSecurityGroup(sg)
+		if err != nil {
+			glog.Warningf("Unable to remove %s from security group %s: %v", instanceID, sg.ID, err)
+		}
+	}
+
+	err := c.enforceSecurityGroups(instanceID)
+	if err != nil {
+		glog.Warningf("Unable to remove security rules of %s: %v", instanceID, err)
+	}
+}
+
+func (c *controller) ListSecurityGroups(tenant *string) []types.SecurityGroup {
+	groups := c.ds.GetSecurityGroups(tenant)
+
+	for i := range groups {
+		c.makeSecurityGroupLinks(&groups[i], tenant)
+	}
+
+	return groups
+}
+
+func (c *controller) CreateSecurityGroup(tenantID string, req types.NewSecurityGroupRequest) (types.SecurityGroup, error) {
+	if req.Name == "" {
+		return types.SecurityGroup{}, types.ErrBadRequest
+	}
+
+	t, err := c.ds.GetTenant(tenantID)
+	if err != nil {
+		return types.SecurityGroup{}, err
+	}
+	if t == nil {
+		return types.SecurityGroup{}, types.ErrTenantNotFound
+	}
+
+	sg := types.SecurityGroup{
+		ID:          uuid.Generate().String(),
+		TenantID:    tenantID,
+		Name:        req.Name,
+		Description: req.Description,
+	}
+
+	for _, rule := range req.Rules {
+		err := validateSecurityGroupRule(&rule)
+		if err != nil {
+			return types.SecurityGroup{}, err
+		}
+		rule.ID = uuid.Generate().String()
+		sg.Rules = append(sg.Rules, rule)
+	}
+
+	err = c.ds.AddSecurityGroup(sg)
+	if err != nil {
+		return types.SecurityGroup{}, err
+	}
+
+	c.makeSecurityGroupLinks(&sg, &tenantID)
+
+	return sg, nil
+}
+
+func (c *controller) ShowSecurityGroup(tenantID string, groupID string) (types.SecurityGroup, error) {
+	sg, err := c.getTenantSecurityGroup(tenantID, groupID)
+	if err != nil {
+		return sg, err
+	}
+
+	c.makeSecurityGroupLinks(&sg, &tenantID)
+
+	return sg, nil
+}
+
+func (c *controller) DeleteSecurityGroup(tenantID string, groupID string) error {
+	sg, err := c.getTenantSecurityGroup(tenantID, groupID)
+	if err != nil {
+		return err
+	}
+
+	if len(sg.Instances) > 0 {
+		return types.ErrSecurityGroupInUse
+	}
+
+	return c.ds.DeleteSecurityGroup(groupID)
+}
+
+func (c *controller) AddSecurityGroupRule(tenantID string, groupID string, rule types.SecurityGroupRule) (types.SecurityGroupRule, error) {
+	sg, err := c.getTenantSecurityGroup(tenantID, groupID)
+	if err != nil {
+		return types.SecurityGroupRule{}, err
+	}
+
+	err = validateSecurityGroupRule(&rule)
+	if err != nil {
+		return types.SecurityGroupRule{}, err
+	}
+
+	rule.ID = uuid.Generate().String()
+	sg.Rules = append(sg.Rules, rule)
+
+	err = c.ds.UpdateSecurityGroup(sg)
+	if err != nil {
+		return types.SecurityGroupRule{}, err
+	}
+
+	return rule, c.enforceSecurityGroupMembers(sg)
+}
+
+func (c *controller) DeleteSecurityGroupRule(tenantID string, groupID string, ruleID string) error {
+	sg, err := c.getTenantSecurityGroup(tenantID, groupID)
+	if err != nil {
+		return err
+	}
+
+	found := false
+	for i := range sg.Rules {
+		if sg.Rules[i].ID == ruleID {
+			sg.Rules = append(sg.Rules[:i], sg.Rules[i+1:]...)
+			found = true
+			break
+		}
+	}
+
+	if !found {
+		return types.ErrSecurityRuleNotFound
+	}
+
+	err = c.ds.UpdateSecurityGroup(sg)
+	if err != nil {
+		return err
+	}
+
+	return c.enforceSecurityGroupMembers(sg)
+}
+
+func (c *controller) AddSecurityGroupInstance(tenantID string, groupID string, instanceID string) error {
+	sg, err := c.getTenantSecurityGroup(tenantID, groupID)
+	if err != nil {
+		return err
+	}
+
+	i, err := c.ds.GetInstance(instanceID)
+	if err != nil {
+		return err
+	}
+
+	if i.TenantID != tenantID || i.CNCI {
+		return types.ErrInstanceNotFound
+	}
+
+	for _, member := range sg.Instances {
+		if member == instanceID {
+			return nil
+		}
+	}
+
+	// the rules are only enforced on the primary NIC
+	if len(i.ExtraNICs) > 0 {
+		return types.ErrSecurityGroupNICs
+	}
+
+	sg.Instances = append(sg.Instances, instanceID)
+
+	err = c.ds.UpdateSecurityGroup(sg)
+	if err != nil {
+		return err
+	}
+
+	return c.enforceSecurityGroups(instanceID)
+}
+
+func (c *controller) RemoveSecurityGroupInstance(tenantID string, groupID string, instanceID string) error {
+	sg, err := c.getTenantSecurityGroup(tenantID, groupID)
+	if err != nil {
+		return err
+	}
+
+	found := false
+	for i, member := range sg.Instances {
+		if member == instanceID {
+			sg.Instances = append(sg.Instances[:i], sg.Instances[i+1:]...)
+			found = true
+			break
+		}
+	}
+
+	if !found {
+		return types.ErrInstanceNotFound
+	}
+
+	err = c.ds.UpdateSecurityGroup(sg)
+	if err != nil {
+		return err
+	}
+
+	return c.enforceSecurityGroups(instanceID)
+}
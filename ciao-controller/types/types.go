@@ -19,6 +19,7 @@ package types
 import (
 	"encoding/json"
 	"errors"
+	"net"
 	"strconv"
 	"strings"
 	"time"
@@ -94,6 +95,20 @@ type WorkloadResponse struct {
 	Link     Link     `json:"link"`
 }
 
+// PrewarmRequest is sent to the /workloads/{workload_id}/prewarm api to
+// fetch the backing image of a workload onto compute nodes.  If Nodes is
+// empty the image is fetched onto all the nodes known to the controller.
+type PrewarmRequest struct {
+	Nodes []string `json:"nodes,omitempty"`
+}
+
+// PrewarmResponse is returned from the /workloads/{workload_id}/prewarm
+// api.  It lists the nodes that have been asked to fetch the image.
+type PrewarmResponse struct {
+	Image string   `json:"image"`
+	Nodes []string `json:"nodes"`
+}
+
 // WorkloadRequest contains resource and configuration for a user
 // workload.
 type WorkloadRequest struct {
@@ -102,6 +117,9 @@ type WorkloadRequest struct {
 	Instances  int
 	TraceLabel string
 	Volumes    []storage.BlockDevice
+	NetworkIDs []string
+	FixedIP    net.IP
+	MACAddress net.HardwareAddr
 }
 
 // Instance contains information about an instance of a workload.
@@ -114,14 +132,30 @@ type Instance struct {
 	MACAddress  string              `json:"mac_address"`
 	VnicUUID    string              `json:"vnic_uuid"`
 	Subnet      string              `json:"subnet"`
+	SubnetID    string              `json:"subnet_id,omitempty"`
 	IPAddress   string              `json:"ip_address"`
+	IPv6Address string              `json:"ipv6_address,omitempty"`
 	SSHIP       string              `json:"ssh_ip"`
 	SSHPort     int                 `json:"ssh_port"`
+	ExtraNICs   []InstanceNIC       `json:"extra_nics,omitempty"`
 	CNCI        bool                `json:"-"`
 	Attachments []StorageAttachment `json:"-"`
 	CreateTime  time.Time           `json:"-"`
 }
 
+// InstanceNIC describes an additional network interface of an instance
+// attached to several tenant networks.  The first interface of an
+// instance is described by the MACAddress, IPAddress and SubnetID fields
+// of the Instance itself.
+type InstanceNIC struct {
+	VnicUUID   string `json:"vnic_uuid"`
+	MACAddress string `json:"mac_address"`
+	IPAddress  string `json:"ip_address"`
+	Subnet     string `json:"subnet"`
+	SubnetID   string `json:"subnet_id"`
+	NetworkID  string `json:"network_id"`
+}
+
 // SortedInstancesByID implements sort.Interface for Instance by ID string
 type SortedInstancesByID []*Instance
 
@@ -238,6 +272,10 @@ const (
 	// Detaching means that the volume is in process
 	// of detaching.
 	Detaching BlockState = "detaching"
+
+	// RestoringBackup means that the volume is being
+	// created from a backup.
+	RestoringBackup BlockState = BlockState(block.RestoringBackup)
 )
 
 // BlockData respresents the attributes of this block device.
@@ -245,11 +283,57 @@ const (
 // or can we use a set of interfaces to get the info?
 type BlockData struct {
 	storage.BlockDevice
-	TenantID    string     // the tenant who owns this volume
-	State       BlockState // status of
-	CreateTime  time.Time  // when we created the volume
-	Name        string     // a human readable name for this volume
-	Description string     // some text to describe this volume.
+	TenantID      string            // the tenant who owns this volume
+	State         BlockState        // status of
+	CreateTime    time.Time         // when we created the volume
+	Name          string            // a human readable name for this volume
+	Description   string            // some text to describe this volume.
+	IOLimits      payloads.IOLimits // I/O throttling settings of this volume
+	MultiAttach   bool              // whether the volume can be attached read-only to several instances
+	EncryptionKey string            // base64 LUKS secret, empty if the volume is not encrypted
+	ImageID       string            // the image the volume was cloned from, if any
+}
+
+// BackupState represents the state of a volume backup in the controller
+// datastore.
+type BackupState string
+
+const (
+	// BackupCreating means that the volume is being copied to the
+	// backup store.
+	BackupCreating BackupState = BackupState(block.BackupCreating)
+
+	// BackupAvailable means that the backup can be restored.
+	BackupAvailable BackupState = BackupState(block.BackupAvailable)
+
+	// BackupRestoring means that the backup is being copied to a
+	// new volume.
+	BackupRestoring BackupState = BackupState(block.BackupRestoring)
+
+	// BackupDeleting means that the backup is being removed from
+	// the backup store.
+	BackupDeleting BackupState = BackupState(block.BackupDeleting)
+
+	// BackupError means that the backup could not be created.
+	BackupError BackupState = BackupState(block.BackupError)
+)
+
+// VolumeBackup represents a copy of a volume held in the backup store.
+type VolumeBackup struct {
+	ID            string      // the uuid of the backup
+	TenantID      string      // the tenant who owns this backup
+	VolumeID      string      // the volume this backup was taken from
+	State         BackupState // status of the backup
+	CreateTime    time.Time   // when the backup was requested
+	Name          string      // a human readable name for this backup
+	Description   string      // some text to describe this backup
+	Size          int         // size in GiB of the backed up volume
+	FailReason    string      // why the backup failed, if it did
+	EncryptionKey string      // LUKS secret of the backed up volume, if encrypted
+
+	// The settings of the backed up volume given to restored volumes.
+	IOLimits    payloads.IOLimits
+	MultiAttach bool
 }
 
 // StorageAttachment represents a link between a block device and
@@ -260,6 +344,7 @@ type StorageAttachment struct {
 	BlockID    string // the ID of the block device
 	Ephemeral  bool   // whether the storage should be deleted on Cleanup
 	Boot       bool   // whether this is a boot device
+	ReadOnly   bool   // whether the instance has read-only access to the device
 }
 
 // CiaoComputeTenants represents the unmarshalled version of the contents of a
@@ -300,6 +385,8 @@ type CiaoComputeNode struct {
 	TotalRunningInstances int       `json:"total_running_instances"`
 	TotalPendingInstances int       `json:"total_pending_instances"`
 	TotalPausedInstances  int       `json:"total_paused_instances"`
+	ImageCacheSize        int       `json:"image_cache_size"`
+	CachedImages          int       `json:"cached_images"`
 }
 
 // CiaoComputeNodes represents the unmarshalled version of the contents of a
@@ -551,8 +638,13 @@ var (
 	// ErrDuplicatePoolName is returned when a duplicate pool name is used
 	ErrDuplicatePoolName = errors.New("Pool by that name already exists")
 
+	// ErrPoolSubnetTooLarge is returned when an IPv6 subnet larger than
+	// a /104 is added to a pool
+	ErrPoolSubnetTooLarge = errors.New("IPv6 pool subnets cannot be larger than /104")
+
 	// ErrInstanceMapped is returned when an instance cannot be deleted
-	// due to having an external IP assigned to it.
+	// due to having an external IP assigned to it or ports of an external
+	// IP forwarded to it.
 	ErrInstanceMapped = errors.New("Unmap the external IP prior to deletion")
 
 	// ErrWorkloadNotFound is returned when a workload ID cannot be found
@@ -560,6 +652,71 @@ var (
 
 	// ErrWorkloadInUse is returned by DeleteWorkload when an instance of a workload is still active.
 	ErrWorkloadInUse = errors.New("Workload definition still in use")
+
+	// ErrNodeNotFound is returned when a node ID cannot be found
+	ErrNodeNotFound = errors.New("Node not found")
+
+	// ErrSecurityGroupNotFound is returned when a security group ID cannot be found
+	ErrSecurityGroupNotFound = errors.New("Security group not found")
+
+	// ErrSecurityGroupInUse is returned when a security group with member
+	// instances is deleted
+	ErrSecurityGroupInUse = errors.New("Security group still has instances")
+
+	// ErrSecurityGroupNICs is returned when an instance with more than one
+	// NIC is added to a security group
+	ErrSecurityGroupNICs = errors.New("Security groups only support instances with one NIC")
+
+	// ErrSecurityRuleNotFound is returned when a security group rule ID cannot be found
+	ErrSecurityRuleNotFound = errors.New("Security group rule not found")
+
+	// ErrInvalidSecurityRule is returned when a security group rule has an
+	// invalid protocol, port range or remote subnet
+	ErrInvalidSecurityRule = errors.New("Invalid security group rule")
+
+	// ErrNetworkNotFound is returned when a tenant network ID cannot be found
+	ErrNetworkNotFound = errors.New("Network not found")
+
+	// ErrSubnetNotFound is returned when a tenant subnet ID cannot be found
+	ErrSubnetNotFound = errors.New("Subnet not found")
+
+	// ErrNetworkInUse is returned when a network or subnet with instances
+	// attached to it is deleted
+	ErrNetworkInUse = errors.New("Network still has instances")
+
+	// ErrInvalidSubnet is returned when a tenant subnet has an invalid
+	// CIDR or gateway
+	ErrInvalidSubnet = errors.New("Invalid subnet")
+
+	// ErrSubnetOverlap is returned when a tenant subnet overlaps with
+	// another subnet of the tenant
+	ErrSubnetOverlap = errors.New("Subnet overlaps with an existing subnet")
+
+	// ErrSubnetFull is returned when a tenant subnet has no free addresses
+	ErrSubnetFull = errors.New("Subnet has no free addresses")
+
+	// ErrInvalidDHCPOptions is returned when the DHCP options of a tenant
+	// subnet cannot be handed out to its instances
+	ErrInvalidDHCPOptions = errors.New("Invalid DHCP options")
+
+	// ErrAddressInUse is returned when the fixed IP or MAC address requested
+	// for a new instance is already claimed
+	ErrAddressInUse = errors.New("Address already in use")
+
+	// ErrInvalidMAC is returned when a MAC address cannot be given to an
+	// instance
+	ErrInvalidMAC = errors.New("The MAC Address is not valid")
+
+	// ErrPortForwardNotFound is returned when a port forward isn't found
+	ErrPortForwardNotFound = errors.New("Port forward not found")
+
+	// ErrInvalidPortForward is returned when a port forward request has
+	// an unsupported protocol or an out of range port
+	ErrInvalidPortForward = errors.New("Invalid port forward")
+
+	// ErrPortForwarded is returned when the port of an external IP is
+	// already forwarded
+	ErrPortForwarded = errors.New("Port already forwarded")
 )
 
 // Link provides a url and relationship for a resource.
@@ -662,6 +819,125 @@ type MapIPRequest struct {
 	InstanceID string  `json:"instance_id"`
 }
 
+// PortForward forwards a port of an external IP to a port of an instance.
+// The external IPs of port forwards are not mapped to a single instance and
+// may be shared by the port forwards to several instances of a tenant.
+type PortForward struct {
+	ID           string `json:"forward_id"`
+	ExternalIP   string `json:"external_ip"`
+	ExternalPort int    `json:"external_port"`
+	Protocol     string `json:"protocol"`
+	InstanceID   string `json:"instance_id"`
+	InternalIP   string `json:"internal_ip"`
+	InternalPort int    `json:"internal_port"`
+	TenantID     string `json:"tenant_id"`
+	PoolID       string `json:"pool_id"`
+	PoolName     string `json:"pool_name"`
+	Links        []Link `json:"links"`
+}
+
+// PortForwardRequest is used to forward a port to an instance. A new
+// external IP is allocated from the pool unless ExternalIP names one
+// already used by the port forwards of the tenant.
+type PortForwardRequest struct {
+	PoolName     *string `json:"pool_name"`
+	ExternalIP   string  `json:"external_ip,omitempty"`
+	ExternalPort int     `json:"external_port"`
+	Protocol     string  `json:"protocol"`
+	InstanceID   string  `json:"instance_id"`
+	InternalPort int     `json:"internal_port"`
+}
+
+// SecurityGroupRule describes ingress traffic that is allowed to reach the
+// instances of a security group.
+type SecurityGroupRule struct {
+	ID         string `json:"id"`
+	Protocol   string `json:"protocol,omitempty"`
+	PortMin    int    `json:"port_range_min,omitempty"`
+	PortMax    int    `json:"port_range_max,omitempty"`
+	RemoteCIDR string `json:"remote_ip_prefix,omitempty"`
+}
+
+// SecurityGroup is a named set of ingress rules enforced by the tenant CNCI
+// for the instances that belong to the group. Ingress traffic to a member
+// instance that is not allowed by a rule of one of its groups is dropped.
+type SecurityGroup struct {
+	ID          string              `json:"id"`
+	TenantID    string              `json:"tenant_id"`
+	Name        string              `json:"name"`
+	Description string              `json:"description"`
+	Rules       []SecurityGroupRule `json:"rules"`
+	Instances   []string            `json:"instances"`
+	Links       []Link              `json:"links"`
+}
+
+// NewSecurityGroupRequest is used to create a new security group.
+type NewSecurityGroupRequest struct {
+	Name        string              `json:"name"`
+	Description string              `json:"description"`
+	Rules       []SecurityGroupRule `json:"rules"`
+}
+
+// SecurityGroupInstanceRequest is used to add an instance to a security
+// group.
+type SecurityGroupInstanceRequest struct {
+	InstanceID string `json:"instance_id"`
+}
+
+// HostRoute is a static route handed out by DHCP to the instances of a
+// tenant subnet.
+type HostRoute struct {
+	Destination string `json:"destination"`
+	NextHop     string `json:"nexthop"`
+}
+
+// SubnetDHCPOptions are the DHCP options handed out to the instances of a
+// tenant subnet, besides their address and gateway. The CNCI of the tenant
+// is the DNS server of the instances unless DNSNameservers is set.
+type SubnetDHCPOptions struct {
+	DNSNameservers   []string    `json:"dns_nameservers,omitempty"`
+	DNSSearchDomains []string    `json:"dns_search_domains,omitempty"`
+	HostRoutes       []HostRoute `json:"host_routes,omitempty"`
+	NTPServers       []string    `json:"ntp_servers,omitempty"`
+}
+
+// TenantSubnet is an IPv4 subnet of a tenant network, with the address
+// of the gateway the instances attached to it route through.
+type TenantSubnet struct {
+	ID        string `json:"id"`
+	NetworkID string `json:"network_id"`
+	Name      string `json:"name"`
+	CIDR      string `json:"cidr"`
+	GatewayIP string `json:"gateway_ip"`
+	SubnetDHCPOptions
+}
+
+// TenantNetwork is a network defined by a tenant. Instances attached to
+// the subnets of a network can reach each other, but not the instances
+// attached to other networks of the tenant.
+type TenantNetwork struct {
+	ID       string         `json:"id"`
+	TenantID string         `json:"tenant_id"`
+	Name     string         `json:"name"`
+	Subnets  []TenantSubnet `json:"subnets"`
+	Links    []Link         `json:"links"`
+}
+
+// NewSubnetRequest is used to add a subnet to a network. The gateway
+// defaults to the first address of the subnet.
+type NewSubnetRequest struct {
+	Name      string `json:"name"`
+	CIDR      string `json:"cidr"`
+	GatewayIP string `json:"gateway_ip"`
+	SubnetDHCPOptions
+}
+
+// NewNetworkRequest is used to create a new network.
+type NewNetworkRequest struct {
+	Name    string             `json:"name"`
+	Subnets []NewSubnetRequest `json:"subnets"`
+}
+
 // QuotaDetails holds information for updating and querying quotas
 type QuotaDetails struct {
 	Name  string
@@ -731,3 +1007,49 @@ type QuotaUpdateRequest struct {
 type QuotaListResponse struct {
 	Quotas []QuotaDetails `json:"quotas"`
 }
+
+// VolumeIssueType describes a kind of inconsistency between the volumes
+// recorded by the controller and the storage backend.
+type VolumeIssueType string
+
+const (
+	// OrphanedVolume is a storage backend volume unknown to the controller.
+	OrphanedVolume VolumeIssueType = "orphaned_volume"
+
+	// MissingVolume is a volume known to the controller that the storage
+	// backend does not hold.
+	MissingVolume VolumeIssueType = "missing_volume"
+
+	// StaleAttachment is an attachment to an instance or a volume that no
+	// longer exists.
+	StaleAttachment VolumeIssueType = "stale_attachment"
+
+	// StaleMapping is a volume mapped on the controller node which is not
+	// attached to any instance.
+	StaleMapping VolumeIssueType = "stale_mapping"
+
+	// VolumeStateMismatch is a volume whose state does not match its
+	// attachments.
+	VolumeStateMismatch VolumeIssueType = "state_mismatch"
+)
+
+// VolumeIssue describes a single inconsistency found by the volume
+// reconciler.
+type VolumeIssue struct {
+	Type         VolumeIssueType `json:"type"`
+	VolumeID     string          `json:"volume_id"`
+	TenantID     string          `json:"tenant_id,omitempty"`
+	InstanceID   string          `json:"instance_id,omitempty"`
+	AttachmentID string          `json:"attachment_id,omitempty"`
+	Device       string          `json:"device,omitempty"`
+	Detail       string          `json:"detail"`
+	Cleaned      bool            `json:"cleaned"`
+}
+
+// VolumeReconciliation holds the results of the last comparison of the
+// controller's volumes with the storage backend.
+type VolumeReconciliation struct {
+	LastRun time.Time     `json:"last_run"`
+	Cleanup bool          `json:"cleanup"`
+	Issues  []VolumeIssue `json:"issues"`
+}
@@ -0,0 +1,329 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/01org/ciao/ciao-controller/types"
+	"github.com/01org/ciao/ciao-storage"
+	"github.com/01org/ciao/ssntp/uuid"
+	"github.com/golang/glog"
+)
+
+// volumeReconciler holds the state of the periodic comparison of the
+// controller's volumes with the storage backend.
+type volumeReconciler struct {
+	sync.Mutex
+
+	// cleanup is set if the inconsistencies found should be repaired.
+	cleanup bool
+
+	// report holds the findings of the last run.
+	report types.VolumeReconciliation
+
+	// suspects holds the backend volumes that were unknown to the
+	// controller during the last run.
+	suspects map[string]bool
+
+	// staleSince holds the time at which each stale attachment was
+	// first seen.
+	staleSince map[string]time.Time
+}
+
+// staleAttachmentAge is how long an attachment must have been seen to
+// refer to a missing instance or volume before it is reported.  This
+// leaves time for instances and volumes that are being created or
+// deleted to catch up with their attachments.
+var staleAttachmentAge = 15 * time.Minute
+
+// imageIDs returns the IDs of all the images held by the image service.
+// Images live in the same pool as volumes so they must not be mistaken
+// for orphans.
+func (c *controller) imageIDs() (map[string]bool, error) {
+	c.imageServiceLock.RLock()
+	is := c.imageService
+	c.imageServiceLock.RUnlock()
+
+	if is == nil {
+		return nil, errors.New("Image service not running")
+	}
+
+	owners, err := c.imageOwners()
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make(map[string]bool)
+	for _, owner := range owners {
+		images, err := is.ds.GetAllImages(owner)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, i := range images {
+			ids[i.ID] = true
+		}
+	}
+
+	return ids, nil
+}
+
+func (c *controller) reportVolumeIssue(r *types.VolumeReconciliation, issue types.VolumeIssue) {
+	msg := fmt.Sprintf("Volume %s: %s", issue.VolumeID, issue.Detail)
+	if issue.Cleaned {
+		msg += " (cleaned up)"
+	}
+
+	glog.Warning(msg)
+	c.ds.LogError(issue.TenantID, msg)
+
+	r.Issues = append(r.Issues, issue)
+}
+
+// findOrphanedVolumes reports the backend volumes that are neither volumes
+// nor images known to the controller.  As volumes are created in the
+// backend before they are added to the datastore, a volume is only treated
+// as an orphan once it has been seen on two consecutive runs.
+func (c *controller) findOrphanedVolumes(r *types.VolumeReconciliation, backend []string,
+	volumes map[string]types.BlockData) {
+	images, err := c.imageIDs()
+	if err != nil {
+		glog.Warningf("Skipping orphaned volume check: %v", err)
+		return
+	}
+
+	suspects := make(map[string]bool)
+	for _, id := range backend {
+		if _, ok := volumes[id]; ok || images[id] {
+			continue
+		}
+
+		// Leave alone anything that was not created by ciao.
+		if _, err := uuid.Parse(id); err != nil {
+			continue
+		}
+
+		suspects[id] = true
+		if !c.reconciler.suspects[id] {
+			continue
+		}
+
+		issue := types.VolumeIssue{
+			Type:     types.OrphanedVolume,
+			VolumeID: id,
+			Detail:   "present in the storage backend but unknown to the controller",
+		}
+
+		if r.Cleanup {
+			err = c.DeleteBlockDevice(id)
+			if err != nil {
+				glog.Errorf("Unable to delete orphaned volume %s: %v", id, err)
+			}
+			issue.Cleaned = err == nil
+		}
+
+		c.reportVolumeIssue(r, issue)
+	}
+
+	c.reconciler.suspects = suspects
+}
+
+// reconcileVolumes compares the volumes, attachments and node mappings
+// recorded by the controller with what the storage backend reports.  The
+// inconsistencies found are logged and, if cleanup is enabled, repaired
+// where this can be done safely.  Missing volumes are only ever reported.
+func (c *controller) reconcileVolumes() (types.VolumeReconciliation, error) {
+	c.reconciler.Lock()
+	defer c.reconciler.Unlock()
+
+	r := types.VolumeReconciliation{
+		LastRun: time.Now(),
+		Cleanup: c.reconciler.cleanup,
+		Issues:  []types.VolumeIssue{},
+	}
+
+	// Without a list of the backend volumes every volume would be
+	// reported as missing.
+	backend, err := c.ListBlockDevices()
+	if err != nil {
+		return r, err
+	}
+
+	mappings, err := c.GetVolumeMapping()
+	if err != nil {
+		return r, err
+	}
+
+	volumes := make(map[string]types.BlockData)
+	for _, v := range c.ds.GetAllBlockDevices() {
+		volumes[v.ID] = v
+	}
+
+	c.findOrphanedVolumes(&r, backend, volumes)
+
+	attached := make(map[string]bool)
+	staleSince := make(map[string]time.Time)
+	for _, a := range c.ds.GetAllStorageAttachments() {
+		v, volumeOK := volumes[a.BlockID]
+		_, err := c.ds.GetInstance(a.InstanceID)
+		if volumeOK && err == nil {
+			attached[a.BlockID] = true
+			continue
+		}
+
+		since, ok := c.reconciler.staleSince[a.ID]
+		if !ok {
+			since = r.LastRun
+		}
+		staleSince[a.ID] = since
+		if r.LastRun.Sub(since) < staleAttachmentAge {
+			// Until then it still counts as an attachment.
+			attached[a.BlockID] = true
+			continue
+		}
+
+		issue := types.VolumeIssue{
+			Type:         types.StaleAttachment,
+			VolumeID:     a.BlockID,
+			TenantID:     v.TenantID,
+			InstanceID:   a.InstanceID,
+			AttachmentID: a.ID,
+			Detail:       fmt.Sprintf("attachment %s refers to a deleted instance or volume", a.ID),
+		}
+
+		if r.Cleanup {
+			err = c.ds.DeleteStorageAttachment(a.ID)
+			if err != nil {
+				glog.Errorf("Unable to delete stale attachment %s: %v", a.ID, err)
+			}
+			issue.Cleaned = err == nil
+		}
+
+		c.reportVolumeIssue(&r, issue)
+	}
+	c.reconciler.staleSince = staleSince
+
+	inBackend := make(map[string]bool)
+	for _, id := range backend {
+		inBackend[id] = true
+	}
+
+	for _, v := range volumes {
+		// The backend volume of a restore is only created once the
+		// backup has been read.
+		if !inBackend[v.ID] && v.State != types.RestoringBackup {
+			c.reportVolumeIssue(&r, types.VolumeIssue{
+				Type:     types.MissingVolume,
+				VolumeID: v.ID,
+				TenantID: v.TenantID,
+				Detail:   "not present in the storage backend",
+			})
+			continue
+		}
+
+		if v.State != types.InUse || attached[v.ID] {
+			continue
+		}
+
+		issue := types.VolumeIssue{
+			Type:     types.VolumeStateMismatch,
+			VolumeID: v.ID,
+			TenantID: v.TenantID,
+			Detail:   "in use but not attached to any instance",
+		}
+
+		if r.Cleanup {
+			v.State = types.Available
+			err = c.ds.UpdateBlockDevice(v)
+			if err != nil {
+				glog.Errorf("Unable to update state of volume %s: %v", v.ID, err)
+			}
+			issue.Cleaned = err == nil
+		}
+
+		c.reportVolumeIssue(&r, issue)
+	}
+
+	for id, devices := range mappings {
+		if attached[id] {
+			continue
+		}
+
+		for _, dev := range devices {
+			issue := types.VolumeIssue{
+				Type:     types.StaleMapping,
+				VolumeID: id,
+				TenantID: volumes[id].TenantID,
+				Device:   dev,
+				Detail:   fmt.Sprintf("mapped to %s but not attached to any instance", dev),
+			}
+
+			if r.Cleanup {
+				err = c.UnmapVolumeFromNode(dev)
+				if err != nil {
+					glog.Errorf("Unable to unmap %s: %v", dev, err)
+				}
+				issue.Cleaned = err == nil
+			}
+
+			c.reportVolumeIssue(&r, issue)
+		}
+	}
+
+	c.reconciler.report = r
+
+	return r, nil
+}
+
+// startVolumeReconciler runs reconcileVolumes every interval until the
+// controller exits.
+func (c *controller) startVolumeReconciler(interval time.Duration) {
+	glog.Infof("Reconciling volumes every %v, cleanup %t", interval, c.reconciler.cleanup)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		r, err := c.reconcileVolumes()
+		if err == storage.ErrNotSupported {
+			glog.Warning("Storage backend cannot list volumes, volume reconciliation disabled")
+			return
+		} else if err != nil {
+			glog.Errorf("Unable to reconcile volumes: %v", err)
+			continue
+		}
+
+		glog.Infof("Volume reconciliation found %d issues", len(r.Issues))
+	}
+}
+
+// ShowVolumeReconciliation returns the findings of the last volume
+// reconciliation.
+func (c *controller) ShowVolumeReconciliation() types.VolumeReconciliation {
+	c.reconciler.Lock()
+	defer c.reconciler.Unlock()
+
+	r := c.reconciler.report
+	r.Cleanup = c.reconciler.cleanup
+	if r.Issues == nil {
+		r.Issues = []types.VolumeIssue{}
+	}
+
+	return r
+}
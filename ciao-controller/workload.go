@@ -210,3 +210,48 @@ func (c *controller) DeleteWorkload(tenantID string, workloadID string) error {
 func (c *controller) ShowWorkload(tenantID string, workloadID string) (types.Workload, error) {
 	return c.ds.GetWorkload(tenantID, workloadID)
 }
+
+// PrewarmWorkload asks compute nodes to fetch the backing image of a
+// workload into their image cache so that the first instances of the
+// workload start quickly.  Only container workloads have images that are
+// cached on the nodes.  If nodes is empty all the nodes known to the
+// controller are asked to fetch the image.
+func (c *controller) PrewarmWorkload(tenantID string, workloadID string, nodes []string) (types.PrewarmResponse, error) {
+	wl, err := c.ds.GetWorkload(tenantID, workloadID)
+	if err != nil {
+		return types.PrewarmResponse{}, types.ErrWorkloadNotFound
+	}
+
+	if wl.VMType != payloads.Docker || wl.ImageName == "" {
+		return types.PrewarmResponse{}, types.ErrBadRequest
+	}
+
+	known := make(map[string]bool)
+	for _, n := range c.ds.GetNodeLastStats().Nodes {
+		known[n.ID] = true
+	}
+
+	if len(nodes) == 0 {
+		for n := range known {
+			nodes = append(nodes, n)
+		}
+	}
+
+	for _, n := range nodes {
+		if !known[n] {
+			return types.PrewarmResponse{}, types.ErrNodeNotFound
+		}
+	}
+
+	for _, n := range nodes {
+		err = c.client.prewarmImage(wl.ImageName, n)
+		if err != nil {
+			return types.PrewarmResponse{}, err
+		}
+	}
+
+	return types.PrewarmResponse{
+		Image: wl.ImageName,
+		Nodes: nodes,
+	}, nil
+}
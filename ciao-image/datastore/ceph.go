@@ -29,6 +29,12 @@ type Ceph struct {
 	BlockDriver  storage.CephDriver
 }
 
+// RequiredFormat returns the format of the images that Ceph can boot
+// instances from.  Images in other formats are converted to it.
+func (c *Ceph) RequiredFormat() Type {
+	return Raw
+}
+
 // Write copies an image onto the fileystem into a tempory location and uploads
 // it into ceph, snapshots.
 func (c *Ceph) Write(ID string, body io.Reader) error {
@@ -65,6 +71,41 @@ func (c *Ceph) Write(ID string, body io.Reader) error {
 	return nil
 }
 
+// Read streams the contents of the image snapshot out of ceph.  rbd can
+// only export whole snapshots, so the data before offset is discarded as
+// it is streamed.
+// The caller is responsible for closing the returned reader.
+func (c *Ceph) Read(ID string, offset, length uint64) (io.ReadCloser, error) {
+	pr, pw := io.Pipe()
+
+	go func() {
+		w := &skipWriter{w: pw, skip: offset}
+		err := c.BlockDriver.ExportBlockDeviceSnapshot(ID, "ciao-image", w)
+		pw.CloseWithError(err)
+	}()
+
+	return readRange(pr, length), nil
+}
+
+// skipWriter discards the first skip bytes written to it.
+type skipWriter struct {
+	w    io.Writer
+	skip uint64
+}
+
+func (s *skipWriter) Write(p []byte) (int, error) {
+	if s.skip >= uint64(len(p)) {
+		s.skip -= uint64(len(p))
+		return len(p), nil
+	}
+
+	n, err := s.w.Write(p[s.skip:])
+	n += int(s.skip)
+	s.skip = 0
+
+	return n, err
+}
+
 // Delete removes an image from ceph after deleting the snapshot.
 func (c *Ceph) Delete(ID string) error {
 	err := c.BlockDriver.DeleteBlockDeviceSnapshot(ID, "ciao-image")
@@ -0,0 +1,89 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datastore
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"strings"
+
+	"github.com/01org/ciao/openstack/image"
+)
+
+// validateChecksum checks that an expected checksum supplied by a client
+// is a hex encoded MD5 or SHA-256 digest.
+func validateChecksum(checksum string) error {
+	sum, err := hex.DecodeString(checksum)
+	if err != nil || (len(sum) != md5.Size && len(sum) != sha256.Size) {
+		return image.ErrBadChecksum
+	}
+
+	return nil
+}
+
+// imageHasher computes the digests recorded for an image as its data
+// is written to it.
+type imageHasher struct {
+	md5    hash.Hash
+	sha256 hash.Hash
+}
+
+func newImageHasher() *imageHasher {
+	return &imageHasher{
+		md5:    md5.New(),
+		sha256: sha256.New(),
+	}
+}
+
+func (h *imageHasher) Write(p []byte) (int, error) {
+	_, _ = h.md5.Write(p)
+	return h.sha256.Write(p)
+}
+
+func (h *imageHasher) md5Sum() string {
+	return hex.EncodeToString(h.md5.Sum(nil))
+}
+
+func (h *imageHasher) sha256Sum() string {
+	return hex.EncodeToString(h.sha256.Sum(nil))
+}
+
+// verify compares the digests of the data written with checksum, which
+// may be either an MD5 or a SHA-256 digest.  An empty checksum matches
+// any data.
+func (h *imageHasher) verify(checksum string) error {
+	if checksum == "" {
+		return nil
+	}
+
+	sum := h.sha256Sum()
+	if len(checksum) == md5.Size*2 {
+		sum = h.md5Sum()
+	}
+
+	if !strings.EqualFold(sum, checksum) {
+		return image.ErrChecksumMismatch
+	}
+
+	return nil
+}
+
+// setChecksums records the digests of the data written in img.
+func (h *imageHasher) setChecksums(img *Image) {
+	img.Checksum = h.md5Sum()
+	img.SHA256 = h.sha256Sum()
+}
@@ -31,11 +31,22 @@ const (
 	// Saving means the image is being saved
 	Saving State = "saving"
 
+	// Importing means the image is being fetched from a remote location.
+	Importing State = "importing"
+
 	// Active means that the image is created, uploaded and ready to use.
 	Active State = "active"
 
 	// Killed means that an image data upload error occurred.
 	Killed State = "killed"
+
+	// Rejected means that the image data was uploaded but is not a valid
+	// image.
+	Rejected State = "rejected"
+
+	// Deactivated means that the image data is kept but that no new
+	// instances or volumes can be created from it.
+	Deactivated State = "deactivated"
 )
 
 // Status translate an image state to an openstack image status.
@@ -45,10 +56,16 @@ func (state State) Status() image.Status {
 		return image.Queued
 	case Saving:
 		return image.Saving
+	case Importing:
+		return image.Importing
 	case Active:
 		return image.Active
 	case Killed:
 		return image.Killed
+	case Rejected:
+		return image.Rejected
+	case Deactivated:
+		return image.Deactivated
 	}
 
 	return image.Active
@@ -66,6 +83,15 @@ const (
 
 	// ISO is the iso format.
 	ISO Type = "iso"
+
+	// VMDK is the VMware virtual disk format.
+	VMDK Type = "vmdk"
+
+	// VHD is the Microsoft virtual hard disk format.
+	VHD Type = "vhd"
+
+	// VHDX is the Hyper-V virtual hard disk format.
+	VHDX Type = "vhdx"
 )
 
 // Image contains the information that ciao will store about the image
@@ -79,6 +105,45 @@ type Image struct {
 	Size       uint64
 	Visibility image.Visibility
 	Tags       string
+
+	// Checksum is the hex encoded MD5 digest of the image data, as
+	// reported in the Glance checksum field.
+	Checksum string
+
+	// SHA256 is the hex encoded SHA-256 digest of the image data.
+	SHA256 string
+
+	// OriginalType is the format of the image data as it was uploaded.
+	// Type is the format of the data held by the raw datastore, which
+	// differs when the image had to be converted.
+	OriginalType Type
+
+	// StoredSHA256 is the hex encoded SHA-256 digest of the data held
+	// by the raw datastore.
+	StoredSHA256 string
+
+	// Protected images cannot be deleted.
+	Protected bool
+
+	// Signature is the signature of the image data supplied by the
+	// client, if any.
+	Signature image.Signature
+
+	// SignedSHA256 is the hex encoded SHA-256 digest of the image data
+	// whose signature was verified when it was uploaded.  It is empty
+	// if the image is not signed.
+	SignedSHA256 string
+}
+
+// Member is a tenant with which an image is shared.  Owner is the
+// tenant holding the image.
+type Member struct {
+	ImageID    string
+	TenantID   string
+	Owner      string
+	Status     image.MemberStatus
+	CreateTime time.Time
+	UpdateTime time.Time
 }
 
 // DataStore is the image data storage interface.
@@ -88,8 +153,15 @@ type DataStore interface {
 	GetAllImages(tenant string) ([]Image, error)
 	GetImage(tenant, id string) (Image, error)
 	UpdateImage(Image) error
+	MoveImage(tenant string, image Image) error
 	DeleteImage(tenant, id string) error
-	UploadImage(tenant, id string, imageFile io.Reader) error
+	UploadImage(tenant, id string, imageFile io.Reader, checksum string) error
+	ImportImage(tenant, id, uri, checksum string) error
+	DownloadImage(tenant, id string, offset, length uint64) (io.ReadCloser, error)
+	GetMembers(tenant, id string) ([]Member, error)
+	AddMember(tenant, id, member string) (Member, error)
+	UpdateMember(tenant, id, member string, status image.MemberStatus) (Member, error)
+	DeleteMember(tenant, id, member string) error
 }
 
 // MetaDataStore is the metadata storing interface that's used by
@@ -99,12 +171,36 @@ type MetaDataStore interface {
 	Delete(tenant, ID string) error
 	Get(tenant, ID string) (Image, error)
 	GetAll(tenant string) ([]Image, error)
+	WriteMember(member Member) error
+	DeleteMember(imageID, tenant string) error
+	GetAllMembers() ([]Member, error)
 }
 
 // RawDataStore is the raw data storage interface that's used by the
 // image cache implementation.
 type RawDataStore interface {
 	Write(ID string, body io.Reader) error
+
+	// Read returns length bytes of the data of an image, starting at
+	// offset.  A zero length reads the data up to its end.
+	Read(ID string, offset, length uint64) (io.ReadCloser, error)
+
 	Delete(ID string) error
 	GetImageSize(ID string) (uint64, error)
 }
+
+// rangeReadCloser reads part of the data of an image.
+type rangeReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// readRange limits the data read from rc to length bytes.  A zero length
+// leaves rc unlimited.
+func readRange(rc io.ReadCloser, length uint64) io.ReadCloser {
+	if length == 0 {
+		return rc
+	}
+
+	return rangeReadCloser{io.LimitReader(rc, int64(length)), rc}
+}
@@ -15,13 +15,23 @@
 package datastore
 
 import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
 	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/01org/ciao/database"
+	"github.com/01org/ciao/openstack/image"
 )
 
 var mountPoint = "/tmp"
@@ -164,7 +174,15 @@ func testDelete(t *testing.T, d RawDataStore, m MetaDataStore) {
 
 	// upload image file
 	tmpfile := createTmpFile(t)
-	err = imageStore.UploadImage(testTenantID, testImageID, tmpfile)
+	defer os.Remove(tmpfile.Name())
+
+	f, err := os.Open(tmpfile.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	err = imageStore.UploadImage(testTenantID, testImageID, f, "")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -201,12 +219,54 @@ func testUpload(t *testing.T, d RawDataStore, m MetaDataStore) {
 	}
 
 	// Upload a string
-	err = imageStore.UploadImage(i.TenantID, i.ID, strings.NewReader("Upload file"))
+	err = imageStore.UploadImage(i.TenantID, i.ID, strings.NewReader("Upload file"), "")
 	if err != nil {
 		t.Fatal(err)
 	}
 }
 
+func testRead(t *testing.T, d RawDataStore) {
+	content := "Read file"
+
+	err := d.Write(testImageID, strings.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		offset   uint64
+		length   uint64
+		expected string
+	}{
+		{0, 0, content},
+		{5, 0, "file"},
+		{0, 4, "Read"},
+		{2, 3, "ad "},
+	}
+
+	for _, tt := range tests {
+		r, err := d.Read(testImageID, tt.offset, tt.length)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := ioutil.ReadAll(r)
+		_ = r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(data) != tt.expected {
+			t.Fatalf("expected %q, got %q", tt.expected, string(data))
+		}
+	}
+
+	_, err = d.Read("not-an-image", 0, 0)
+	if err == nil {
+		t.Fatal("expected error reading missing image")
+	}
+}
+
 // cleanDatastore cleans temporal files that were created during the test
 func cleanDatastore() {
 	_ = os.Remove(path.Join(mountPoint, testImageID))
@@ -225,6 +285,7 @@ func TestPosixNoopGetAll(t *testing.T) {
 
 func TestPosixNoopDelete(t *testing.T) {
 	testDelete(t, &Posix{MountPoint: mountPoint}, &Noop{})
+	cleanDatastore()
 }
 
 func TestPosixNoopUpload(t *testing.T) {
@@ -232,6 +293,11 @@ func TestPosixNoopUpload(t *testing.T) {
 	cleanDatastore()
 }
 
+func TestPosixRead(t *testing.T) {
+	testRead(t, &Posix{MountPoint: mountPoint})
+	cleanDatastore()
+}
+
 // Tests for MetaDs
 
 func initMetaDs() *MetaDs {
@@ -277,3 +343,812 @@ func TestPosixMetaDsUpload(t *testing.T) {
 	testUpload(t, &Posix{MountPoint: mountPoint}, metaDs)
 	cleanDatastore()
 }
+
+func TestPosixMetaDsUploadChecksum(t *testing.T) {
+	content := "Checked upload"
+	md5Sum := md5.Sum([]byte(content))
+	sha256Sum := sha256.Sum256([]byte(content))
+
+	metaDs := initMetaDs()
+	defer metaDs.DbClose()
+	defer cleanDatastore()
+
+	imageStore := &ImageStore{}
+	_ = imageStore.Init(&Posix{MountPoint: mountPoint}, metaDs)
+
+	tests := []struct {
+		checksum string
+		err      error
+		state    State
+	}{
+		{"not-a-checksum", image.ErrBadChecksum, Created},
+		{strings.Repeat("0", 64), image.ErrChecksumMismatch, Killed},
+		{hex.EncodeToString(md5Sum[:]), nil, Active},
+	}
+
+	for _, tt := range tests {
+		err := imageStore.CreateImage(Image{
+			ID:       testImageID,
+			TenantID: testTenantID,
+			State:    Created,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = imageStore.UploadImage(testTenantID, testImageID, strings.NewReader(content), tt.checksum)
+		if err != tt.err {
+			t.Fatalf("Upload with checksum %s: expected error %v, got %v", tt.checksum, tt.err, err)
+		}
+
+		img, err := imageStore.GetImage(testTenantID, testImageID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if img.State != tt.state {
+			t.Fatalf("Upload with checksum %s: expected state %s, got %s", tt.checksum, tt.state, img.State)
+		}
+	}
+
+	img, _ := imageStore.GetImage(testTenantID, testImageID)
+	if img.Checksum != hex.EncodeToString(md5Sum[:]) {
+		t.Errorf("Expected md5 %x, got %s", md5Sum, img.Checksum)
+	}
+
+	if img.SHA256 != hex.EncodeToString(sha256Sum[:]) {
+		t.Errorf("Expected sha256 %x, got %s", sha256Sum, img.SHA256)
+	}
+}
+
+// waitForImport polls the image until its import has completed.
+func waitForImport(t *testing.T, s *ImageStore, tenant, ID string) Image {
+	for i := 0; i < 100; i++ {
+		img, err := s.GetImage(tenant, ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if img.State != Importing {
+			return img
+		}
+
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	t.Fatalf("Import of image %s did not complete", ID)
+	return Image{}
+}
+
+func TestPosixMetaDsImport(t *testing.T) {
+	content := "Imported image"
+	sum := sha256.Sum256([]byte(content))
+	checksum := hex.EncodeToString(sum[:])
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/image.img" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(content))
+	}))
+	defer ts.Close()
+
+	metaDs := initMetaDs()
+	defer metaDs.DbClose()
+	defer cleanDatastore()
+
+	imageStore := &ImageStore{AllowPrivateImports: true}
+	_ = imageStore.Init(&Posix{MountPoint: mountPoint}, metaDs)
+
+	tests := []struct {
+		uri      string
+		checksum string
+		max      uint64
+		err      error
+		state    State
+	}{
+		{"ftp://example.com/image.img", "", 0, image.ErrBadURI, Created},
+		{ts.URL + "/image.img", "1234", 0, image.ErrBadChecksum, Created},
+		{ts.URL + "/missing.img", "", 0, image.ErrImportFailed, Created},
+		{ts.URL + "/image.img", "", 4, image.ErrImageTooLarge, Created},
+		{ts.URL + "/image.img", strings.Repeat("0", 64), 0, nil, Killed},
+		{ts.URL + "/image.img", checksum, 0, nil, Active},
+	}
+
+	err := imageStore.CreateImage(Image{
+		ID:       testImageID,
+		TenantID: testTenantID,
+		State:    Created,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range tests {
+		imageStore.MaxImportSize = tt.max
+		err := imageStore.ImportImage(testTenantID, testImageID, tt.uri, tt.checksum)
+		if err != tt.err {
+			t.Fatalf("Import from %s: expected error %v, got %v", tt.uri, tt.err, err)
+		}
+
+		img := waitForImport(t, imageStore, testTenantID, testImageID)
+		if img.State != tt.state {
+			t.Fatalf("Import from %s: expected state %s, got %s", tt.uri, tt.state, img.State)
+		}
+	}
+
+	img, _ := imageStore.GetImage(testTenantID, testImageID)
+	if img.Size != uint64(len(content)) {
+		t.Fatalf("Expected size %d, got %d", len(content), img.Size)
+	}
+
+	if img.SHA256 != checksum {
+		t.Fatalf("Expected sha256 %s, got %s", checksum, img.SHA256)
+	}
+
+	err = imageStore.ImportImage(testTenantID, testImageID, ts.URL+"/image.img", "")
+	if err != image.ErrImageNotQueued {
+		t.Fatalf("Expected %v importing into an active image, got %v", image.ErrImageNotQueued, err)
+	}
+
+	r, err := imageStore.rawDs.Read(testImageID, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	data, _ := ioutil.ReadAll(r)
+	if string(data) != content {
+		t.Fatalf("Expected %q, got %q", content, string(data))
+	}
+}
+
+func TestPosixMetaDsImportLimit(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Without a Content-Length the limit can only be
+		// enforced while the data is copied.
+		w.(http.Flusher).Flush()
+		_, _ = w.Write([]byte("Image without a length"))
+	}))
+	defer ts.Close()
+
+	metaDs := initMetaDs()
+	defer metaDs.DbClose()
+	defer cleanDatastore()
+
+	imageStore := &ImageStore{MaxImportSize: 4, AllowPrivateImports: true}
+	_ = imageStore.Init(&Posix{MountPoint: mountPoint}, metaDs)
+
+	err := imageStore.CreateImage(Image{
+		ID:       testImageID,
+		TenantID: testTenantID,
+		State:    Created,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = imageStore.ImportImage(testTenantID, testImageID, ts.URL, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img := waitForImport(t, imageStore, testTenantID, testImageID)
+	if img.State != Killed {
+		t.Fatalf("Expected state %s, got %s", Killed, img.State)
+	}
+
+	if _, err := os.Stat(path.Join(mountPoint, testImageID)); !os.IsNotExist(err) {
+		t.Fatalf("Data of oversized image not removed")
+	}
+}
+
+func TestPosixMetaDsImportDestination(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "file:///etc/passwd", http.StatusFound)
+			return
+		}
+		_, _ = w.Write([]byte("Internal image"))
+	}))
+	defer ts.Close()
+
+	metaDs := initMetaDs()
+	defer metaDs.DbClose()
+	defer cleanDatastore()
+
+	imageStore := &ImageStore{}
+	_ = imageStore.Init(&Posix{MountPoint: mountPoint}, metaDs)
+
+	err := imageStore.CreateImage(Image{
+		ID:       testImageID,
+		TenantID: testTenantID,
+		State:    Created,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = imageStore.ImportImage(testTenantID, testImageID, ts.URL, "")
+	if err != image.ErrImportFailed {
+		t.Fatalf("Import from loopback address: expected %v, got %v", image.ErrImportFailed, err)
+	}
+
+	imageStore.AllowPrivateImports = true
+	err = imageStore.ImportImage(testTenantID, testImageID, ts.URL+"/redirect", "")
+	if err != image.ErrImportFailed {
+		t.Fatalf("Import redirected to a file: expected %v, got %v", image.ErrImportFailed, err)
+	}
+
+	img, _ := imageStore.GetImage(testTenantID, testImageID)
+	if img.State != Created {
+		t.Fatalf("Expected state %s, got %s", Created, img.State)
+	}
+}
+
+func TestPosixMetaDsUploadConflicts(t *testing.T) {
+	metaDs := initMetaDs()
+	defer metaDs.DbClose()
+	defer cleanDatastore()
+
+	imageStore := &ImageStore{}
+	_ = imageStore.Init(&Posix{MountPoint: mountPoint}, metaDs)
+
+	err := imageStore.CreateImage(Image{
+		ID:       testImageID,
+		TenantID: testTenantID,
+		State:    Created,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stale, err := imageStore.GetImage(testTenantID, testImageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pr, pw := io.Pipe()
+	done := make(chan error)
+	go func() {
+		done <- imageStore.UploadImage(testTenantID, testImageID, pr, "")
+	}()
+
+	for i := 0; ; i++ {
+		img, _ := imageStore.GetImage(testTenantID, testImageID)
+		if img.State == Saving {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("Image not saving during upload")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	renamed := stale
+	renamed.Name = "renamed"
+	if err := imageStore.UpdateImage(renamed); err != image.ErrImageSaving {
+		t.Errorf("Update during upload: expected %v, got %v", image.ErrImageSaving, err)
+	}
+
+	if err := imageStore.MoveImage(testTenantID, renamed); err != image.ErrImageSaving {
+		t.Errorf("Move during upload: expected %v, got %v", image.ErrImageSaving, err)
+	}
+
+	err = imageStore.UploadImage(testTenantID, testImageID, strings.NewReader("Other data"), "")
+	if err != image.ErrImageSaving {
+		t.Errorf("Second upload: expected %v, got %v", image.ErrImageSaving, err)
+	}
+
+	_, _ = pw.Write([]byte("Uploaded data"))
+	_ = pw.Close()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	// An update based on the image as it was before the upload must
+	// not undo it.
+	err = imageStore.UpdateImage(renamed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img, _ := imageStore.GetImage(testTenantID, testImageID)
+	if img.State != Active || img.Size != uint64(len("Uploaded data")) || img.Name != "renamed" {
+		t.Fatalf("Unexpected image after upload and update: %+v", img)
+	}
+}
+
+func TestImportAddressAllowed(t *testing.T) {
+	tests := []struct {
+		ip      string
+		allowed bool
+	}{
+		{"127.0.0.1", false},
+		{"10.1.2.3", false},
+		{"172.20.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"0.0.0.0", false},
+		{"::1", false},
+		{"::ffff:127.0.0.1", false},
+		{"fe80::1", false},
+		{"fd00::1", false},
+		{"8.8.8.8", true},
+		{"2001:4860:4860::8888", true},
+	}
+
+	for _, tt := range tests {
+		if allowed := importAddressAllowed(net.ParseIP(tt.ip)); allowed != tt.allowed {
+			t.Errorf("%s: expected %t, got %t", tt.ip, tt.allowed, allowed)
+		}
+	}
+}
+
+func TestCleanInterruptedTransfers(t *testing.T) {
+	metaDs := initMetaDs()
+	defer metaDs.DbClose()
+	defer cleanDatastore()
+
+	imageStore := &ImageStore{}
+	_ = imageStore.Init(&Posix{MountPoint: mountPoint}, metaDs)
+
+	err := imageStore.CreateImage(Image{
+		ID:       testImageID,
+		TenantID: testTenantID,
+		State:    Importing,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = imageStore.rawDs.Write(testImageID, strings.NewReader("partial"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = imageStore.CleanInterruptedTransfers([]string{testTenantID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img, _ := imageStore.GetImage(testTenantID, testImageID)
+	if img.State != Killed {
+		t.Fatalf("Expected state %s, got %s", Killed, img.State)
+	}
+
+	if _, err := os.Stat(path.Join(mountPoint, testImageID)); !os.IsNotExist(err) {
+		t.Fatalf("Data of interrupted import not removed")
+	}
+}
+
+func TestDetectFormat(t *testing.T) {
+	fixedVHD := make([]byte, 2048)
+	copy(fixedVHD[len(fixedVHD)-vhdFooterSize:], vhdCookie)
+
+	iso := make([]byte, 0x9000)
+	copy(iso[isoMagicOffset:], isoMagic)
+
+	tests := []struct {
+		data   []byte
+		format Type
+	}{
+		{[]byte(qcow2Magic + "\x00\x00\x00\x03"), QCow},
+		{[]byte(vmdkMagic + "\x01\x00\x00\x00"), VMDK},
+		{[]byte(vhdxMagic + "ciao"), VHDX},
+		{[]byte(vhdCookie + "\x00\x00\x00\x02"), VHD},
+		{fixedVHD, VHD},
+		{iso, ISO},
+		{[]byte("temporary file's content"), Raw},
+		{[]byte{}, Raw},
+	}
+
+	for _, tt := range tests {
+		f, err := ioutil.TempFile("", "format")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = f.Write(tt.data)
+		if err == nil {
+			var format Type
+			format, err = detectFormat(f)
+			if err == nil && format != tt.format {
+				t.Errorf("Expected format %s, got %s", tt.format, format)
+			}
+		}
+
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// rawPosix is a posix datastore that only accepts raw images, like Ceph.
+type rawPosix struct {
+	Posix
+}
+
+func (p *rawPosix) RequiredFormat() Type {
+	return Raw
+}
+
+// fakeQemuImg returns a qemu-img replacement that reports info for every
+// image and converts images to converted.
+func fakeQemuImg(info string, converted string) func(args ...string) ([]byte, error) {
+	return func(args ...string) ([]byte, error) {
+		switch args[0] {
+		case "info":
+			if info == "" {
+				return []byte("Image is not in qcow2 format"), errors.New("exit status 1")
+			}
+			return []byte(info), nil
+		case "check":
+			return []byte(`{"corruptions":0,"leaks":2,"check-errors":0}`), errors.New("exit status 3")
+		case "convert":
+			return nil, ioutil.WriteFile(args[len(args)-1], []byte(converted), 0600)
+		}
+
+		return nil, errors.New("unexpected qemu-img command")
+	}
+}
+
+func TestPosixMetaDsUploadConvert(t *testing.T) {
+	content := qcow2Magic + "qcow2 image"
+	converted := "raw image"
+	convertedSum := sha256.Sum256([]byte(converted))
+
+	defer func(f func(args ...string) ([]byte, error)) { qemuImg = f }(qemuImg)
+
+	metaDs := initMetaDs()
+	defer metaDs.DbClose()
+	defer cleanDatastore()
+
+	imageStore := &ImageStore{}
+	_ = imageStore.Init(&rawPosix{Posix{MountPoint: mountPoint}}, metaDs)
+
+	tests := []struct {
+		info  string
+		err   error
+		state State
+	}{
+		{"", image.ErrMalformedImage, Rejected},
+		{`{"format":"qcow2","virtual-size":1073741824,"backing-filename":"/etc/shadow"}`,
+			image.ErrMalformedImage, Rejected},
+		{`{"format":"qcow2","virtual-size":1073741824,"format-specific":{"type":"qcow2","data":{"data-file":"/dev/sda"}}}`,
+			image.ErrMalformedImage, Rejected},
+		{`{"format":"qcow2","virtual-size":1073741824}`, nil, Active},
+	}
+
+	for _, tt := range tests {
+		qemuImg = fakeQemuImg(tt.info, converted)
+
+		err := imageStore.CreateImage(Image{
+			ID:       testImageID,
+			TenantID: testTenantID,
+			State:    Created,
+			Type:     Raw,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = imageStore.UploadImage(testTenantID, testImageID, strings.NewReader(content), "")
+		if err != tt.err {
+			t.Fatalf("Upload with info %s: expected error %v, got %v", tt.info, tt.err, err)
+		}
+
+		img, err := imageStore.GetImage(testTenantID, testImageID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if img.State != tt.state {
+			t.Fatalf("Upload with info %s: expected state %s, got %s", tt.info, tt.state, img.State)
+		}
+	}
+
+	img, _ := imageStore.GetImage(testTenantID, testImageID)
+	if img.OriginalType != QCow || img.Type != Raw {
+		t.Errorf("Expected %s image stored as %s, got %s stored as %s", QCow, Raw, img.OriginalType, img.Type)
+	}
+
+	if img.StoredSHA256 != hex.EncodeToString(convertedSum[:]) || img.StoredSHA256 == img.SHA256 {
+		t.Errorf("Unexpected stored data checksum %s", img.StoredSHA256)
+	}
+
+	data, err := ioutil.ReadFile(path.Join(mountPoint, testImageID))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data) != converted {
+		t.Errorf("Expected %q, got %q", converted, string(data))
+	}
+}
+
+func TestPosixMetaDsDownload(t *testing.T) {
+	content := "Download file"
+
+	metaDs := initMetaDs()
+	defer metaDs.DbClose()
+	defer cleanDatastore()
+
+	imageStore := &ImageStore{}
+	_ = imageStore.Init(&Posix{MountPoint: mountPoint}, metaDs)
+
+	err := imageStore.CreateImage(Image{
+		ID:       testImageID,
+		TenantID: testTenantID,
+		State:    Created,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = imageStore.DownloadImage(testTenantID, testImageID, 0, 0)
+	if err != image.ErrNoImageData {
+		t.Fatalf("Expected %v downloading queued image, got %v", image.ErrNoImageData, err)
+	}
+
+	err = imageStore.UploadImage(testTenantID, testImageID, strings.NewReader(content), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		offset   uint64
+		length   uint64
+		expected string
+		err      error
+	}{
+		{0, 0, content, nil},
+		{9, 4, "file", nil},
+		{13, 0, "", nil},
+		{9, 5, "", image.ErrBadRange},
+		{14, 0, "", image.ErrBadRange},
+	}
+
+	for _, tt := range tests {
+		r, err := imageStore.DownloadImage(testTenantID, testImageID, tt.offset, tt.length)
+		if err != tt.err {
+			t.Fatalf("Expected error %v, got %v", tt.err, err)
+		}
+		if err != nil {
+			continue
+		}
+
+		data, err := ioutil.ReadAll(r)
+		_ = r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(data) != tt.expected {
+			t.Errorf("Expected %q, got %q", tt.expected, string(data))
+		}
+	}
+
+	_, err = imageStore.DownloadImage("other-tenant", testImageID, 0, 0)
+	if err != image.ErrNoImage {
+		t.Fatalf("Expected %v, got %v", image.ErrNoImage, err)
+	}
+}
+
+func TestPosixMetaDsMove(t *testing.T) {
+	metaDs := initMetaDs()
+	defer metaDs.DbClose()
+	defer cleanDatastore()
+
+	imageStore := &ImageStore{}
+	_ = imageStore.Init(&Posix{MountPoint: mountPoint}, metaDs)
+
+	i := Image{
+		ID:         testImageID,
+		TenantID:   testTenantID,
+		State:      Created,
+		Visibility: image.Private,
+	}
+
+	err := imageStore.CreateImage(i)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	i.TenantID = string(image.Public)
+	i.Visibility = image.Public
+	i.Name = "public image"
+
+	err = imageStore.MoveImage(testTenantID, i)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img, _ := imageStore.GetImage(testTenantID, testImageID)
+	if img != (Image{}) {
+		t.Fatalf("Image still owned by %s after move", testTenantID)
+	}
+
+	img, err = imageStore.GetImage(string(image.Public), testImageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if img.Name != i.Name || img.Visibility != image.Public {
+		t.Fatalf("Expected %v, got %v", i, img)
+	}
+
+	err = imageStore.MoveImage(testTenantID, i)
+	if err != image.ErrNoImage {
+		t.Fatalf("Expected %v moving missing image, got %v", image.ErrNoImage, err)
+	}
+}
+
+func TestPosixMetaDsProtectedAndDeactivated(t *testing.T) {
+	metaDs := initMetaDs()
+	defer metaDs.DbClose()
+	defer cleanDatastore()
+
+	imageStore := &ImageStore{}
+	_ = imageStore.Init(&Posix{MountPoint: mountPoint}, metaDs)
+
+	i := Image{
+		ID:         testImageID,
+		TenantID:   testTenantID,
+		State:      Created,
+		Visibility: image.Private,
+		Protected:  true,
+	}
+
+	err := imageStore.CreateImage(i)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = imageStore.UploadImage(testTenantID, testImageID, strings.NewReader("Upload file"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = imageStore.DeleteImage(testTenantID, testImageID)
+	if err != image.ErrImageProtected {
+		t.Fatalf("Expected %v deleting protected image, got %v", image.ErrImageProtected, err)
+	}
+
+	i, err = imageStore.GetImage(testTenantID, testImageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	i.State = Deactivated
+	i.Protected = false
+	err = imageStore.UpdateImage(i)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if i.State.Status() != image.Deactivated {
+		t.Fatalf("Expected status %s, got %s", image.Deactivated, i.State.Status())
+	}
+
+	err = imageStore.UploadImage(testTenantID, testImageID, strings.NewReader("Upload file"), "")
+	if err != image.ErrImageDeactivated {
+		t.Fatalf("Expected %v uploading to deactivated image, got %v", image.ErrImageDeactivated, err)
+	}
+
+	r, err := imageStore.DownloadImage(testTenantID, testImageID, 0, 0)
+	if err != nil {
+		t.Fatalf("Unable to download deactivated image: %v", err)
+	}
+	_ = r.Close()
+
+	err = imageStore.DeleteImage(testTenantID, testImageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPosixMetaDsMembers(t *testing.T) {
+	const memberID = "a1d2f5c3-2b6f-4c39-9d3c-0f0b2e4fb16a"
+
+	metaDs := initMetaDs()
+	defer metaDs.DbClose()
+	defer cleanDatastore()
+
+	imageStore := &ImageStore{}
+	_ = imageStore.Init(&Posix{MountPoint: mountPoint}, metaDs)
+
+	i := Image{
+		ID:         testImageID,
+		TenantID:   testTenantID,
+		State:      Created,
+		Visibility: image.Private,
+	}
+
+	err := imageStore.CreateImage(i)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = imageStore.AddMember(testTenantID, testImageID, memberID)
+	if err != image.ErrNotShared {
+		t.Fatalf("Expected %v sharing private image, got %v", image.ErrNotShared, err)
+	}
+
+	i.Visibility = image.Shared
+	_ = imageStore.UpdateImage(i)
+
+	m, err := imageStore.AddMember(testTenantID, testImageID, memberID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.Status != image.MemberPending || m.Owner != testTenantID {
+		t.Fatalf("Unexpected member %v", m)
+	}
+
+	_, err = imageStore.AddMember(testTenantID, testImageID, memberID)
+	if err != image.ErrAlreadyExists {
+		t.Fatalf("Expected %v adding member twice, got %v", image.ErrAlreadyExists, err)
+	}
+
+	img, err := imageStore.GetImage(memberID, testImageID)
+	if err != nil || img.ID != testImageID {
+		t.Fatalf("Shared image not visible to member: %v", err)
+	}
+
+	images, _ := imageStore.GetAllImages(memberID)
+	if len(images) != 0 {
+		t.Fatalf("Pending image listed for member")
+	}
+
+	_, err = imageStore.UpdateMember(testTenantID, testImageID, memberID, image.MemberAccepted)
+	if err != image.ErrForbiddenAccess {
+		t.Fatalf("Expected %v accepting image as owner, got %v", image.ErrForbiddenAccess, err)
+	}
+
+	_, err = imageStore.UpdateMember(memberID, testImageID, memberID, image.MemberAccepted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	images, _ = imageStore.GetAllImages(memberID)
+	if len(images) != 1 || images[0].ID != testImageID {
+		t.Fatalf("Accepted image not listed for member: %v", images)
+	}
+
+	members, _ := imageStore.GetMembers(memberID, testImageID)
+	if len(members) != 1 || members[0].TenantID != memberID {
+		t.Fatalf("Unexpected members %v", members)
+	}
+
+	err = imageStore.DeleteMember(memberID, testImageID, memberID)
+	if err != image.ErrForbiddenAccess {
+		t.Fatalf("Expected %v removing member as member, got %v", image.ErrForbiddenAccess, err)
+	}
+
+	err = imageStore.DeleteImage(memberID, testImageID)
+	if err != image.ErrForbiddenAccess {
+		t.Fatalf("Expected %v deleting image as member, got %v", image.ErrForbiddenAccess, err)
+	}
+
+	i.Visibility = image.Private
+	_ = imageStore.UpdateImage(i)
+
+	if img, _ := imageStore.GetImage(memberID, testImageID); img != (Image{}) {
+		t.Fatalf("Private image visible to member")
+	}
+
+	err = imageStore.DeleteImage(testTenantID, testImageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	members, _ = metaDs.GetAllMembers()
+	if len(members) != 0 {
+		t.Fatalf("Members not deleted with image: %v", members)
+	}
+}
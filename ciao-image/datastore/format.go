@@ -0,0 +1,202 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datastore
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+)
+
+// FormatRequirer is implemented by raw datastores that can only use image
+// data in a particular format.  Images uploaded in another format are
+// converted before being written to such datastores.
+type FormatRequirer interface {
+	RequiredFormat() Type
+}
+
+// Magic numbers used to identify the image formats.  ISO images are
+// identified by the signature of their first volume descriptor, fixed
+// size VHD images by their footer.
+const (
+	qcow2Magic = "QFI\xfb"
+	vmdkMagic  = "KDMV"
+	vhdxMagic  = "vhdxfile"
+	vhdCookie  = "conectix"
+
+	isoMagic       = "CD001"
+	isoMagicOffset = 0x8001
+
+	vhdFooterSize = 512
+)
+
+// qemuImg runs qemu-img with the given arguments.  It is a variable so
+// that tests can run without qemu-img.
+var qemuImg = func(args ...string) ([]byte, error) {
+	return exec.Command("qemu-img", args...).CombinedOutput()
+}
+
+// qemuFormat returns the name qemu-img uses for an image format.
+func qemuFormat(format Type) string {
+	if format == VHD {
+		return "vpc"
+	}
+
+	return string(format)
+}
+
+// convertible returns true if images in format can be converted to raw.
+// Raw and ISO images are already stored as plain data.
+func convertible(format Type) bool {
+	switch format {
+	case QCow, VMDK, VHD, VHDX:
+		return true
+	}
+
+	return false
+}
+
+// detectFormat identifies the format of an image from its contents.
+// Anything that is not recognised is assumed to be a raw disk image.
+func detectFormat(f *os.File) (Type, error) {
+	header := make([]byte, isoMagicOffset+len(isoMagic))
+	n, err := f.ReadAt(header, 0)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	header = header[:n]
+
+	switch {
+	case bytes.HasPrefix(header, []byte(qcow2Magic)):
+		return QCow, nil
+	case bytes.HasPrefix(header, []byte(vmdkMagic)):
+		return VMDK, nil
+	case bytes.HasPrefix(header, []byte(vhdxMagic)):
+		return VHDX, nil
+	case bytes.HasPrefix(header, []byte(vhdCookie)):
+		return VHD, nil
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	if fi.Size() >= vhdFooterSize {
+		cookie := make([]byte, len(vhdCookie))
+		_, err = f.ReadAt(cookie, fi.Size()-vhdFooterSize)
+		if err != nil {
+			return "", err
+		}
+
+		if string(cookie) == vhdCookie {
+			return VHD, nil
+		}
+	}
+
+	if len(header) == isoMagicOffset+len(isoMagic) &&
+		string(header[isoMagicOffset:]) == isoMagic {
+		return ISO, nil
+	}
+
+	return Raw, nil
+}
+
+// qemuImgInfo holds the parts of the qemu-img info output used to check
+// images.
+type qemuImgInfo struct {
+	Format          string `json:"format"`
+	VirtualSize     int64  `json:"virtual-size"`
+	BackingFilename string `json:"backing-filename"`
+	FormatSpecific  struct {
+		Data struct {
+			DataFile string `json:"data-file"`
+		} `json:"data"`
+	} `json:"format-specific"`
+}
+
+// checkFormat makes sure that an image can be safely handed to qemu-img
+// and to instances.  Images that qemu-img cannot parse, or that refer to
+// other files, are rejected.
+func checkFormat(path string, format Type) error {
+	if !convertible(format) {
+		return nil
+	}
+
+	out, err := qemuImg("info", "--output=json", "-f", qemuFormat(format), path)
+	if err != nil {
+		return fmt.Errorf("not a valid %s image: %s", format, bytes.TrimSpace(out))
+	}
+
+	var info qemuImgInfo
+	err = json.Unmarshal(out, &info)
+	if err != nil {
+		return fmt.Errorf("unable to parse image information: %v", err)
+	}
+
+	if info.Format != qemuFormat(format) || info.VirtualSize <= 0 {
+		return fmt.Errorf("not a valid %s image", format)
+	}
+
+	if info.BackingFilename != "" || info.FormatSpecific.Data.DataFile != "" {
+		return fmt.Errorf("images with external files are not supported")
+	}
+
+	if format == QCow || format == VHDX {
+		return checkConsistency(path, format)
+	}
+
+	return nil
+}
+
+// qemuImgCheck holds the parts of the qemu-img check output used to check
+// images.  Leaked clusters are harmless and so are not reported.
+type qemuImgCheck struct {
+	Corruptions int `json:"corruptions"`
+	CheckErrors int `json:"check-errors"`
+}
+
+// checkConsistency runs the qemu-img consistency check on an image.
+// qemu-img exits with an error for leaks as well as for corruptions so
+// its output is used to tell them apart.
+func checkConsistency(path string, format Type) error {
+	out, _ := qemuImg("check", "--output=json", "-f", qemuFormat(format), path)
+
+	var check qemuImgCheck
+	err := json.Unmarshal(out, &check)
+	if err != nil {
+		return fmt.Errorf("unable to check %s image: %s", format, bytes.TrimSpace(out))
+	}
+
+	if check.Corruptions > 0 || check.CheckErrors > 0 {
+		return fmt.Errorf("corrupt %s image: %d corruptions, %d errors",
+			format, check.Corruptions, check.CheckErrors)
+	}
+
+	return nil
+}
+
+// convertToRaw converts the image at src to a raw image at dst.
+func convertToRaw(src, dst string, format Type) error {
+	out, err := qemuImg("convert", "-f", qemuFormat(format), "-O", "raw", src, dst)
+	if err != nil {
+		return fmt.Errorf("unable to convert %s image: %v: %s", format, err, bytes.TrimSpace(out))
+	}
+
+	return nil
+}
@@ -0,0 +1,364 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datastore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+
+	"github.com/01org/ciao/openstack/image"
+	"github.com/golang/glog"
+)
+
+// importProgressStep is the amount of data fetched between two updates
+// of the size reported for an image being imported.
+const importProgressStep = 16 << 20
+
+// importDialTimeout and importHeaderTimeout bound the time taken to
+// connect to the server an image is imported from and for it to start
+// answering.  importIdleTimeout bounds the time spent waiting for each
+// piece of the image data.
+const (
+	importDialTimeout   = 30 * time.Second
+	importHeaderTimeout = time.Minute
+	importIdleTimeout   = 5 * time.Minute
+	importMaxRedirects  = 10
+)
+
+var errImportCancelled = errors.New("Image deleted during import")
+
+var errImportDestination = errors.New("Destination address not allowed")
+
+// importBlockedNets are the destinations images cannot be imported
+// from unless private imports are allowed, so that the import API
+// cannot be used to reach the services of the cluster itself.
+var importBlockedNets = func() []*net.IPNet {
+	var nets []*net.IPNet
+	for _, cidr := range []string{
+		"0.0.0.0/8",
+		"10.0.0.0/8",
+		"100.64.0.0/10",
+		"127.0.0.0/8",
+		"169.254.0.0/16",
+		"172.16.0.0/12",
+		"192.168.0.0/16",
+		"224.0.0.0/4",
+		"240.0.0.0/4",
+		"::/128",
+		"::1/128",
+		"fc00::/7",
+		"fe80::/10",
+		"ff00::/8",
+	} {
+		_, n, _ := net.ParseCIDR(cidr)
+		nets = append(nets, n)
+	}
+	return nets
+}()
+
+// importAddressAllowed returns true if images may be fetched from ip.
+func importAddressAllowed(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+
+	for _, n := range importBlockedNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// checkImportURL returns image.ErrBadURI unless u is an absolute HTTP or
+// HTTPS URL.
+func checkImportURL(u *url.URL) error {
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return image.ErrBadURI
+	}
+
+	return nil
+}
+
+// importClient returns the HTTP client with which images are fetched.
+// The destination is checked once the host name has been resolved, on
+// every connection, so that neither the URL nor a redirect can point the
+// controller at an internal address.
+func (s *ImageStore) importClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: importDialTimeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			if s.AllowPrivateImports {
+				return nil
+			}
+
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+
+			ip := net.ParseIP(host)
+			if ip == nil || !importAddressAllowed(ip) {
+				return errImportDestination
+			}
+
+			return nil
+		},
+	}
+
+	return &http.Client{
+		// No proxy is used as the destination could not be
+		// checked through one.
+		Transport: &http.Transport{
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   importDialTimeout,
+			ResponseHeaderTimeout: importHeaderTimeout,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= importMaxRedirects {
+				return fmt.Errorf("stopped after %d redirects", importMaxRedirects)
+			}
+
+			return checkImportURL(req.URL)
+		},
+	}
+}
+
+// idleTimeoutReader fails reads of a response body that take longer
+// than timeout, cancelling the request.
+type idleTimeoutReader struct {
+	io.ReadCloser
+	timeout time.Duration
+	cancel  context.CancelFunc
+}
+
+func (r *idleTimeoutReader) Read(p []byte) (int, error) {
+	t := time.AfterFunc(r.timeout, r.cancel)
+	n, err := r.ReadCloser.Read(p)
+	t.Stop()
+	return n, err
+}
+
+func (r *idleTimeoutReader) Close() error {
+	err := r.ReadCloser.Close()
+	r.cancel()
+	return err
+}
+
+// importReader counts the image data as it is fetched and fails once
+// more than max bytes have been read.
+type importReader struct {
+	r        io.Reader
+	size     uint64
+	reported uint64
+	max      uint64
+	progress func(uint64) error
+}
+
+func (ir *importReader) Read(p []byte) (int, error) {
+	n, err := ir.r.Read(p)
+	ir.size += uint64(n)
+
+	if ir.max > 0 && ir.size > ir.max {
+		return n, image.ErrImageTooLarge
+	}
+
+	if ir.size-ir.reported >= importProgressStep {
+		ir.reported = ir.size
+		perr := ir.progress(ir.size)
+		if perr != nil {
+			return n, perr
+		}
+	}
+
+	return n, err
+}
+
+// updateImport writes the metadata of an image being imported, unless
+// the image has been deleted since the import started.
+func (s *ImageStore) updateImport(img Image) error {
+	s.ImageMap.Lock()
+	defer s.ImageMap.Unlock()
+
+	cur, err := s.metaDs.Get(img.TenantID, img.ID)
+	if err != nil || cur.ID != img.ID || cur.State != Importing {
+		return errImportCancelled
+	}
+
+	return s.metaDs.Write(img)
+}
+
+// fetchImage copies the image data from body into the raw datastore and
+// marks the image as active, as killed if the copy fails or the data
+// does not match checksum, or as rejected if it is not a valid image.
+func (s *ImageStore) fetchImage(img Image, body io.ReadCloser, checksum string) {
+	defer func() { _ = body.Close() }()
+
+	ir := &importReader{
+		r:   body,
+		max: s.MaxImportSize,
+		progress: func(size uint64) error {
+			progress := img
+			progress.Size = size
+			return s.updateImport(progress)
+		},
+	}
+
+	err := s.storeImage(&img, ir, checksum)
+	if err == nil {
+		img.Size, err = s.rawDs.GetImageSize(img.ID)
+	}
+
+	switch err {
+	case nil:
+		img.State = Active
+	case image.ErrMalformedImage:
+		img.State = Rejected
+	default:
+		glog.Errorf("Import of image %s failed: %v", img.ID, err)
+		_ = s.rawDs.Delete(img.ID)
+		img.State = Killed
+	}
+
+	err = s.updateImport(img)
+	if err != nil {
+		glog.Warningf("Unable to complete import of image %s: %v", img.ID, err)
+		_ = s.rawDs.Delete(img.ID)
+		return
+	}
+
+	glog.Infof("Import of image %s finished, state %s", img.ID, img.State)
+}
+
+// CleanInterruptedTransfers marks as killed the images of owners that were
+// being imported or uploaded when the image service last stopped, and
+// removes any data already copied.  The images can then be deleted or
+// their data supplied again.
+func (s *ImageStore) CleanInterruptedTransfers(owners []string) error {
+	s.ImageMap.Lock()
+	defer s.ImageMap.Unlock()
+
+	for _, owner := range owners {
+		images, err := s.metaDs.GetAll(owner)
+		if err != nil {
+			return err
+		}
+
+		for _, img := range images {
+			if img.State != Importing && img.State != Saving {
+				continue
+			}
+
+			glog.Warningf("Transfer of image %s data interrupted", img.ID)
+			_ = s.rawDs.Delete(img.ID)
+			img.State = Killed
+			err = s.metaDs.Write(img)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// ImportImage fetches the data of a queued image from an HTTP(S) URL.
+// The request is made before returning so that unreachable locations
+// and oversized images are reported to the caller.  The data is then
+// copied in the background, with the image in the Importing state and
+// its size tracking the progress of the copy.  If checksum, an MD5 or
+// SHA-256 digest, is not empty the data must match it for the image to
+// become active.
+func (s *ImageStore) ImportImage(tenant, ID, uri, checksum string) error {
+	u, err := url.Parse(uri)
+	if err != nil || checkImportURL(u) != nil {
+		return image.ErrBadURI
+	}
+
+	if checksum != "" {
+		err = validateChecksum(checksum)
+		if err != nil {
+			return err
+		}
+	}
+
+	s.ImageMap.Lock()
+	img, err := s.metaDs.Get(tenant, ID)
+	if err != nil || img == (Image{}) {
+		s.ImageMap.Unlock()
+		return image.ErrNoImage
+	}
+
+	if img.State != Created && img.State != Killed && img.State != Rejected {
+		s.ImageMap.Unlock()
+		return image.ErrImageNotQueued
+	}
+
+	prevState := img.State
+	img.State = Importing
+	img.Size = 0
+	img.Checksum = ""
+	img.SHA256 = ""
+	img.StoredSHA256 = ""
+	err = s.metaDs.Write(img)
+	s.ImageMap.Unlock()
+	if err != nil {
+		return err
+	}
+
+	var resp *http.Response
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequest(http.MethodGet, uri, nil)
+	if err == nil {
+		resp, err = s.importClient().Do(req.WithContext(ctx))
+	}
+	if err == nil && resp.StatusCode != http.StatusOK {
+		_ = resp.Body.Close()
+		err = fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	if err != nil {
+		glog.Errorf("Unable to fetch image %s from %s: %v", ID, uri, err)
+		err = image.ErrImportFailed
+	} else if s.MaxImportSize > 0 && resp.ContentLength > int64(s.MaxImportSize) {
+		_ = resp.Body.Close()
+		err = image.ErrImageTooLarge
+	}
+
+	if err != nil {
+		cancel()
+		img.State = prevState
+		_ = s.updateImport(img)
+		return err
+	}
+
+	body := &idleTimeoutReader{
+		ReadCloser: resp.Body,
+		timeout:    importIdleTimeout,
+		cancel:     cancel,
+	}
+
+	go s.fetchImage(img, body, checksum)
+
+	return nil
+}
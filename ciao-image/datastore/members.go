@@ -0,0 +1,223 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datastore
+
+import (
+	"time"
+
+	"github.com/01org/ciao/openstack/image"
+)
+
+// The helpers below must be called with the ImageMap lock held.
+
+// findMembers returns the members of image ID that are tenant.  An empty
+// ID or tenant matches all images or tenants.
+func (s *ImageStore) findMembers(ID, tenant string) ([]Member, error) {
+	all, err := s.metaDs.GetAllMembers()
+	if err != nil {
+		return nil, err
+	}
+
+	var members []Member
+	for _, m := range all {
+		if (ID == "" || m.ImageID == ID) && (tenant == "" || m.TenantID == tenant) {
+			members = append(members, m)
+		}
+	}
+
+	return members, nil
+}
+
+// sharedImage returns an image that has been shared with tenant.  Images
+// are only shared while their visibility is shared.
+func (s *ImageStore) sharedImage(tenant, ID string) (Image, error) {
+	members, err := s.findMembers(ID, tenant)
+	if err != nil {
+		return Image{}, err
+	}
+
+	for _, m := range members {
+		img, err := s.metaDs.Get(m.Owner, ID)
+		if err == nil && img.ID == ID && img.Visibility == image.Shared {
+			return img, nil
+		}
+	}
+
+	return Image{}, image.ErrNoImage
+}
+
+// ownedImage returns an image owned by tenant.  Images that are only
+// shared with tenant cannot be modified by it.
+func (s *ImageStore) ownedImage(tenant, ID string) (Image, error) {
+	img, err := s.metaDs.Get(tenant, ID)
+	if err == nil && img.ID == ID {
+		return img, nil
+	}
+
+	_, err = s.sharedImage(tenant, ID)
+	if err == nil {
+		return Image{}, image.ErrForbiddenAccess
+	}
+
+	return Image{}, image.ErrNoImage
+}
+
+// setMembersOwner records that the image shared with the members of
+// image ID is now held by owner.
+func (s *ImageStore) setMembersOwner(ID, owner string) error {
+	members, err := s.findMembers(ID, "")
+	if err != nil {
+		return err
+	}
+
+	for _, m := range members {
+		m.Owner = owner
+		err = s.metaDs.WriteMember(m)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// deleteMembers stops sharing image ID with all tenants.
+func (s *ImageStore) deleteMembers(ID string) error {
+	members, err := s.findMembers(ID, "")
+	if err != nil {
+		return err
+	}
+
+	for _, m := range members {
+		err = s.metaDs.DeleteMember(ID, m.TenantID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetMembers returns the tenants an image is shared with.  Members of
+// the image only get their own membership.
+func (s *ImageStore) GetMembers(tenant, ID string) ([]Member, error) {
+	s.ImageMap.RLock()
+	defer s.ImageMap.RUnlock()
+
+	img, err := s.metaDs.Get(tenant, ID)
+	if err == nil && img.ID == ID {
+		return s.findMembers(ID, "")
+	}
+
+	_, err = s.sharedImage(tenant, ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.findMembers(ID, tenant)
+}
+
+// AddMember shares an image owned by tenant with member.  The image is
+// pending until member accepts it.
+func (s *ImageStore) AddMember(tenant, ID, member string) (Member, error) {
+	s.ImageMap.Lock()
+	defer s.ImageMap.Unlock()
+
+	img, err := s.ownedImage(tenant, ID)
+	if err != nil {
+		return Member{}, err
+	}
+
+	if img.Visibility != image.Shared {
+		return Member{}, image.ErrNotShared
+	}
+
+	if member == tenant || member == string(image.Public) || member == string(image.Internal) {
+		return Member{}, image.ErrBadMember
+	}
+
+	members, err := s.findMembers(ID, member)
+	if err != nil {
+		return Member{}, err
+	}
+
+	if len(members) > 0 {
+		return Member{}, image.ErrAlreadyExists
+	}
+
+	now := time.Now()
+	m := Member{
+		ImageID:    ID,
+		TenantID:   member,
+		Owner:      tenant,
+		Status:     image.MemberPending,
+		CreateTime: now,
+		UpdateTime: now,
+	}
+
+	return m, s.metaDs.WriteMember(m)
+}
+
+// UpdateMember sets the status of the membership of tenant to an image.
+// Only the member itself can accept or reject an image.
+func (s *ImageStore) UpdateMember(tenant, ID, member string, status image.MemberStatus) (Member, error) {
+	s.ImageMap.Lock()
+	defer s.ImageMap.Unlock()
+
+	if member != tenant {
+		_, err := s.ownedImage(tenant, ID)
+		if err == nil {
+			return Member{}, image.ErrForbiddenAccess
+		}
+		return Member{}, image.ErrNoMember
+	}
+
+	members, err := s.findMembers(ID, member)
+	if err != nil {
+		return Member{}, err
+	}
+
+	if len(members) == 0 {
+		return Member{}, image.ErrNoMember
+	}
+
+	m := members[0]
+	m.Status = status
+	m.UpdateTime = time.Now()
+
+	return m, s.metaDs.WriteMember(m)
+}
+
+// DeleteMember stops sharing an image owned by tenant with member.
+func (s *ImageStore) DeleteMember(tenant, ID, member string) error {
+	s.ImageMap.Lock()
+	defer s.ImageMap.Unlock()
+
+	_, err := s.ownedImage(tenant, ID)
+	if err != nil {
+		return err
+	}
+
+	members, err := s.findMembers(ID, member)
+	if err != nil {
+		return err
+	}
+
+	if len(members) == 0 {
+		return image.ErrNoMember
+	}
+
+	return s.metaDs.DeleteMember(ID, member)
+}
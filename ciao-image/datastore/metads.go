@@ -15,9 +15,46 @@
 package datastore
 
 import (
+	"fmt"
+
 	"github.com/01org/ciao/database"
 )
 
+// tableImageMembers holds the tenants that images are shared with.  The
+// members of all images are kept in a single table, keyed by image and
+// tenant.
+const tableImageMembers = "image-members"
+
+// memberMap is the database table of image members.
+type memberMap struct {
+	m map[string]*Member
+}
+
+func (mm *memberMap) NewTable() {
+	mm.m = make(map[string]*Member)
+}
+
+func (mm *memberMap) Name() string {
+	return tableImageMembers
+}
+
+func (mm *memberMap) NewElement() interface{} {
+	return &Member{}
+}
+
+func (mm *memberMap) Add(k string, v interface{}) error {
+	val, ok := v.(*Member)
+	if !ok {
+		return fmt.Errorf("Invalid value type %t", v)
+	}
+	mm.m[k] = val
+	return nil
+}
+
+func memberKey(imageID, tenant string) string {
+	return imageID + "/" + tenant
+}
+
 // MetaDs implements the DataStore interface for persistent data
 type MetaDs struct {
 	database.DbProvider
@@ -69,3 +106,25 @@ func (m *MetaDs) GetAll(tenant string) (images []Image, err error) {
 
 	return images, err
 }
+
+// WriteMember is the image member write implementation.
+func (m *MetaDs) WriteMember(member Member) error {
+	return m.DbAdd(tableImageMembers, memberKey(member.ImageID, member.TenantID), &member)
+}
+
+// DeleteMember is the image member delete implementation.
+func (m *MetaDs) DeleteMember(imageID, tenant string) error {
+	return m.DbDelete(tableImageMembers, memberKey(imageID, tenant))
+}
+
+// GetAllMembers is the get all image members implementation.
+func (m *MetaDs) GetAllMembers() ([]Member, error) {
+	elements, err := m.DbGetAll(tableImageMembers, &memberMap{})
+
+	members := make([]Member, len(elements))
+	for i, member := range elements {
+		members[i] = *member.(*Member)
+	}
+
+	return members, err
+}
@@ -48,3 +48,21 @@ func (n *Noop) Get(tenant, id string) (Image, error) {
 func (n *Noop) GetAll(tenant string) ([]Image, error) {
 	return []Image{}, nil
 }
+
+// WriteMember is the noop image member write implementation.
+// It drops data.
+func (n *Noop) WriteMember(m Member) error {
+	return nil
+}
+
+// DeleteMember is the noop image member delete implementation.
+// It drops data.
+func (n *Noop) DeleteMember(imageID, tenant string) error {
+	return nil
+}
+
+// GetAllMembers is the noop get all image members implementation.
+// It drops data.
+func (n *Noop) GetAllMembers() ([]Member, error) {
+	return []Member{}, nil
+}
@@ -51,6 +51,26 @@ func (p *Posix) Write(ID string, body io.Reader) (err error) {
 	return err
 }
 
+// Read opens an image stored in the posix filesystem and returns length
+// bytes of its data from offset.
+// The caller is responsible for closing the returned reader.
+func (p *Posix) Read(ID string, offset, length uint64) (io.ReadCloser, error) {
+	imageName := path.Join(p.MountPoint, ID)
+
+	image, err := os.Open(imageName)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = image.Seek(int64(offset), io.SeekStart)
+	if err != nil {
+		_ = image.Close()
+		return nil, err
+	}
+
+	return readRange(image, length), nil
+}
+
 // Delete removes an image from the posix filesystem
 func (p *Posix) Delete(ID string) error {
 	imageName := path.Join(p.MountPoint, ID)
@@ -0,0 +1,436 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datastore
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// s3MinPartSize is the smallest part, other than the last one, that
+	// S3 accepts in a multipart upload.
+	s3MinPartSize = 5 << 20
+
+	// s3DefaultPartSize is the size of the parts in which images are
+	// uploaded when no part size is configured.
+	s3DefaultPartSize = 64 << 20
+
+	// s3DefaultRegion is the region used to sign requests when none is
+	// configured.  Most S3 compatible object stores accept it.
+	s3DefaultRegion = "us-east-1"
+
+	s3TimeFormat = "20060102T150405Z"
+	s3DateFormat = "20060102"
+)
+
+// S3 implements the DataStore interface for S3 compatible object stores.
+// Images are stored as objects named after their ID in Bucket, using path
+// style requests to Endpoint signed with AWS signature version 4.  Images
+// larger than PartSize are uploaded in parts, each of which is held in
+// memory while it is sent.
+type S3 struct {
+	Endpoint  string
+	Bucket    string
+	Region    string
+	AccessKey string
+	SecretKey string
+
+	// PartSize is the size of the parts of multipart uploads.  It
+	// defaults to 64MiB and cannot be less than 5MiB.
+	PartSize int64
+
+	// Client is the HTTP client used to talk to the object store.
+	// http.DefaultClient is used if nil.
+	Client *http.Client
+}
+
+// s3Error is the body of the error responses of S3.
+type s3Error struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+type s3InitiateMultipartUploadResult struct {
+	UploadID string `xml:"UploadId"`
+}
+
+type s3CompletedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+type s3CompleteMultipartUpload struct {
+	XMLName xml.Name          `xml:"CompleteMultipartUpload"`
+	Parts   []s3CompletedPart `xml:"Part"`
+}
+
+var emptyPayloadHash = hex.EncodeToString(sha256.New().Sum(nil))
+
+// s3URIEncode encodes s as required by the canonical requests of AWS
+// signature version 4.  Slashes are kept when encoding paths.
+func s3URIEncode(s string, path bool) string {
+	var buf bytes.Buffer
+
+	for _, b := range []byte(s) {
+		switch {
+		case 'a' <= b && b <= 'z', 'A' <= b && b <= 'Z', '0' <= b && b <= '9',
+			b == '-', b == '.', b == '_', b == '~', path && b == '/':
+			buf.WriteByte(b)
+		default:
+			fmt.Fprintf(&buf, "%%%02X", b)
+		}
+	}
+
+	return buf.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	_, _ = h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// RequiredFormat returns the format in which images are held in S3.
+// Instances cannot boot from S3 so the data of an image is copied into
+// the Ceph cluster the first time it is used, without being converted,
+// and must be in a format that Ceph can boot from.
+func (s *S3) RequiredFormat() Type {
+	return Raw
+}
+
+func (s *S3) region() string {
+	if s.Region == "" {
+		return s3DefaultRegion
+	}
+
+	return s.Region
+}
+
+func (s *S3) partSize() int64 {
+	if s.PartSize == 0 {
+		return s3DefaultPartSize
+	}
+
+	if s.PartSize < s3MinPartSize {
+		return s3MinPartSize
+	}
+
+	return s.PartSize
+}
+
+func (s *S3) client() *http.Client {
+	if s.Client == nil {
+		return http.DefaultClient
+	}
+
+	return s.Client
+}
+
+// sign adds an AWS signature version 4 to req, whose body has the hex
+// encoded SHA-256 digest payloadHash.
+func (s *S3) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.UTC().Format(s3TimeFormat)
+	date := now.UTC().Format(s3DateFormat)
+
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		k = strings.ToLower(k)
+		if strings.HasPrefix(k, "x-amz-") || k == "range" || k == "content-md5" || k == "content-type" {
+			headers[k] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders bytes.Buffer
+	for _, k := range names {
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", k, headers[k])
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	query := req.URL.Query()
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	params := make([]string, 0, len(keys))
+	for _, k := range keys {
+		for _, v := range query[k] {
+			params = append(params, s3URIEncode(k, false)+"="+s3URIEncode(v, false))
+		}
+	}
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		s3URIEncode(req.URL.Path, true),
+		strings.Join(params, "&"),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := strings.Join([]string{date, s.region(), "s3", "aws4_request"}, "/")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hex.EncodeToString(requestHash[:]),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), date)
+	key = hmacSHA256(key, s.region())
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKey, scope, signedHeaders, signature))
+}
+
+// objectURL returns the URL of the object holding image ID.
+func (s *S3) objectURL(ID string, query url.Values) (*url.URL, error) {
+	u, err := url.Parse(s.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid S3 endpoint %s: %v", s.Endpoint, err)
+	}
+
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.Bucket + "/" + ID
+	u.RawQuery = strings.Replace(query.Encode(), "uploads=", "uploads", 1)
+
+	return u, nil
+}
+
+// do sends a signed request for image ID to the object store.  The body
+// of successful responses must be closed by the caller.
+func (s *S3) do(method, ID string, query url.Values, headers map[string]string, body []byte) (*http.Response, error) {
+	u, err := s.objectURL(ID, query)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	payloadHash := emptyPayloadHash
+	if len(body) > 0 {
+		sum := sha256.Sum256(body)
+		payloadHash = hex.EncodeToString(sum[:])
+	}
+	s.sign(req, payloadHash, time.Now())
+
+	resp, err := s.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode/100 != 2 {
+		defer func() { _ = resp.Body.Close() }()
+		return resp, s.responseError(method, ID, resp)
+	}
+
+	return resp, nil
+}
+
+// responseError returns an error describing a failed request.
+func (s *S3) responseError(method, ID string, resp *http.Response) error {
+	data, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<16))
+
+	var e s3Error
+	if xml.Unmarshal(data, &e) == nil && e.Code != "" {
+		return fmt.Errorf("S3 %s of %s/%s failed: %s: %s", method, s.Bucket, ID, e.Code, e.Message)
+	}
+
+	return fmt.Errorf("S3 %s of %s/%s failed: %s", method, s.Bucket, ID, resp.Status)
+}
+
+// Write uploads an image to the object store, in parts if it is larger
+// than the part size.
+func (s *S3) Write(ID string, body io.Reader) error {
+	part := make([]byte, s.partSize())
+
+	n, err := io.ReadFull(body, part)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		resp, err := s.do("PUT", ID, nil, nil, part[:n])
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("Error reading image: %v", err)
+	}
+
+	uploadID, err := s.initiateUpload(ID)
+	if err != nil {
+		return err
+	}
+
+	err = s.uploadParts(ID, uploadID, part, body)
+	if err != nil {
+		resp, aerr := s.do("DELETE", ID, url.Values{"uploadId": {uploadID}}, nil, nil)
+		if aerr == nil {
+			_ = resp.Body.Close()
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (s *S3) initiateUpload(ID string) (string, error) {
+	resp, err := s.do("POST", ID, url.Values{"uploads": {""}}, nil, nil)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	var result s3InitiateMultipartUploadResult
+	err = xml.NewDecoder(resp.Body).Decode(&result)
+	if err != nil || result.UploadID == "" {
+		return "", fmt.Errorf("Unable to start upload of %s/%s: %v", s.Bucket, ID, err)
+	}
+
+	return result.UploadID, nil
+}
+
+// uploadParts uploads the first part, already read in part, and the
+// remaining data of an image in body and completes the upload.
+func (s *S3) uploadParts(ID, uploadID string, part []byte, body io.Reader) error {
+	var complete s3CompleteMultipartUpload
+
+	data := part
+	for number := 1; len(data) > 0; number++ {
+		query := url.Values{
+			"partNumber": {strconv.Itoa(number)},
+			"uploadId":   {uploadID},
+		}
+
+		resp, err := s.do("PUT", ID, query, nil, data)
+		if err != nil {
+			return err
+		}
+		etag := resp.Header.Get("ETag")
+		_ = resp.Body.Close()
+
+		complete.Parts = append(complete.Parts, s3CompletedPart{number, etag})
+
+		n, err := io.ReadFull(body, part)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return fmt.Errorf("Error reading image: %v", err)
+		}
+		data = part[:n]
+	}
+
+	completeBody, err := xml.Marshal(complete)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.do("POST", ID, url.Values{"uploadId": {uploadID}}, nil, completeBody)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	// S3 can report errors in the body of successful responses to
+	// complete requests.
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	var e s3Error
+	if xml.Unmarshal(respBody, &e) == nil && e.Code != "" {
+		return fmt.Errorf("Unable to complete upload of %s/%s: %s: %s", s.Bucket, ID, e.Code, e.Message)
+	}
+
+	return nil
+}
+
+// Read streams length bytes of an image, starting at offset, out of the
+// object store.
+// The caller is responsible for closing the returned reader.
+func (s *S3) Read(ID string, offset, length uint64) (io.ReadCloser, error) {
+	headers := map[string]string{}
+	if length > 0 {
+		headers["Range"] = fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)
+	} else if offset > 0 {
+		headers["Range"] = fmt.Sprintf("bytes=%d-", offset)
+	}
+
+	resp, err := s.do("GET", ID, nil, headers, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Body, nil
+}
+
+// Delete removes an image from the object store.
+func (s *S3) Delete(ID string) error {
+	resp, err := s.do("DELETE", ID, nil, nil, nil)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return resp.Body.Close()
+}
+
+// GetImageSize returns the size, in bytes, of the object holding an image.
+func (s *S3) GetImageSize(ID string) (uint64, error) {
+	resp, err := s.do("HEAD", ID, nil, nil, nil)
+	if err != nil {
+		return 0, fmt.Errorf("Error getting image size: %v", err)
+	}
+	_ = resp.Body.Close()
+
+	if resp.ContentLength < 0 {
+		return 0, fmt.Errorf("Error getting image size: unknown object size")
+	}
+
+	return uint64(resp.ContentLength), nil
+}
@@ -0,0 +1,169 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datastore
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha512" // registers SHA-384 and SHA-512
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"time"
+
+	"github.com/01org/ciao/openstack/image"
+	"github.com/01org/ciao/ssntp/uuid"
+)
+
+// signatureHashes maps the Glance names of the hash methods with which
+// image data can be signed to their implementation.
+var signatureHashes = map[string]crypto.Hash{
+	"SHA-224": crypto.SHA224,
+	"SHA-256": crypto.SHA256,
+	"SHA-384": crypto.SHA384,
+	"SHA-512": crypto.SHA512,
+}
+
+// rsaPSS is the Glance name of the RSA key type.  Signatures are made
+// with the PSS padding scheme.
+const rsaPSS = "RSA-PSS"
+
+// signatureCurves maps the Glance names of the elliptic curve key types
+// to their curve.
+var signatureCurves = map[string]elliptic.Curve{
+	"ECC_SECP384R1": elliptic.P384(),
+	"ECC_SECP521R1": elliptic.P521(),
+}
+
+// signatureVerifier hashes the data of an image as it is written and
+// then checks the signature of the image against the digest.
+type signatureVerifier struct {
+	hash.Hash
+	sig       image.Signature
+	hashAlgo  crypto.Hash
+	signature []byte
+}
+
+// newSignatureVerifier checks that the signature properties of an image
+// are complete and supported.
+func newSignatureVerifier(sig image.Signature) (*signatureVerifier, error) {
+	hashAlgo, ok := signatureHashes[sig.HashMethod]
+	if !ok {
+		return nil, fmt.Errorf("unsupported hash method %q", sig.HashMethod)
+	}
+
+	if _, ok := signatureCurves[sig.KeyType]; !ok && sig.KeyType != rsaPSS {
+		return nil, fmt.Errorf("unsupported key type %q", sig.KeyType)
+	}
+
+	if _, err := uuid.Parse(sig.Certificate); err != nil {
+		return nil, fmt.Errorf("invalid certificate reference %q", sig.Certificate)
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(sig.Signature)
+	if err != nil || len(signature) == 0 {
+		return nil, errors.New("signature is not base64 encoded")
+	}
+
+	return &signatureVerifier{
+		Hash:      hashAlgo.New(),
+		sig:       sig,
+		hashAlgo:  hashAlgo,
+		signature: signature,
+	}, nil
+}
+
+// loadSigningCertificate reads the certificate id from certDir and checks
+// that it can currently be used to verify signatures.
+func loadSigningCertificate(certDir, id string) (*x509.Certificate, error) {
+	if certDir == "" {
+		return nil, errors.New("no signing certificates configured")
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(certDir, id+".pem"))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read certificate %s: %v", id, err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("certificate %s is not PEM encoded", id)
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse certificate %s: %v", id, err)
+	}
+
+	now := time.Now()
+	if now.Before(cert.NotBefore) || now.After(cert.NotAfter) {
+		return nil, fmt.Errorf("certificate %s is not valid at this time", id)
+	}
+
+	if cert.KeyUsage != 0 && cert.KeyUsage&x509.KeyUsageDigitalSignature == 0 {
+		return nil, fmt.Errorf("certificate %s cannot be used for signatures", id)
+	}
+
+	return cert, nil
+}
+
+// verify checks the signature of the data written against the public key
+// of the certificate referenced by the image, which is read from certDir.
+func (v *signatureVerifier) verify(certDir string) error {
+	cert, err := loadSigningCertificate(certDir, v.sig.Certificate)
+	if err != nil {
+		return err
+	}
+
+	digest := v.Sum(nil)
+
+	switch key := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		if v.sig.KeyType != rsaPSS {
+			return fmt.Errorf("certificate %s does not hold a %s key", v.sig.Certificate, v.sig.KeyType)
+		}
+
+		opts := &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthAuto}
+		err = rsa.VerifyPSS(key, v.hashAlgo, digest, v.signature, opts)
+		if err != nil {
+			return errors.New("signature does not match image data")
+		}
+	case *ecdsa.PublicKey:
+		if signatureCurves[v.sig.KeyType] != key.Curve {
+			return fmt.Errorf("certificate %s does not hold a %s key", v.sig.Certificate, v.sig.KeyType)
+		}
+
+		var es struct {
+			R, S *big.Int
+		}
+		rest, err := asn1.Unmarshal(v.signature, &es)
+		if err != nil || len(rest) != 0 || !ecdsa.Verify(key, digest, es.R, es.S) {
+			return errors.New("signature does not match image data")
+		}
+	default:
+		return fmt.Errorf("certificate %s holds an unsupported key", v.sig.Certificate)
+	}
+
+	return nil
+}
@@ -0,0 +1,212 @@
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package datastore
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/01org/ciao/openstack/image"
+)
+
+const testCertID = "6d3a1a4e-5a8b-4c0e-9e0c-2f3c6c1e7b2d"
+
+// writeTestCertificate writes a self signed certificate for key to dir,
+// named after testCertID.
+func writeTestCertificate(t *testing.T, dir string, key crypto.Signer) {
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "ciao image signing"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	err = ioutil.WriteFile(filepath.Join(dir, testCertID+".pem"), data, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// signTestData returns the base64 encoded signature of the SHA-256
+// digest of data.
+func signTestData(t *testing.T, key crypto.Signer, data string) string {
+	digest := crypto.SHA256.New()
+	_, _ = digest.Write([]byte(data))
+
+	var opts crypto.SignerOpts = crypto.SHA256
+	if _, ok := key.(*rsa.PrivateKey); ok {
+		opts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA256}
+	}
+
+	sig, err := key.Sign(rand.Reader, digest.Sum(nil), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return base64.StdEncoding.EncodeToString(sig)
+}
+
+func testSignedUpload(t *testing.T, key crypto.Signer, keyType string) {
+	metaDs := initMetaDs()
+	defer metaDs.DbClose()
+	defer cleanDatastore()
+
+	certDir, err := ioutil.TempDir("", "ciao-image-certs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(certDir) }()
+
+	writeTestCertificate(t, certDir, key)
+
+	imageStore := &ImageStore{CertDir: certDir}
+	_ = imageStore.Init(&Posix{MountPoint: mountPoint}, metaDs)
+
+	const data = "signed image data"
+
+	i := Image{
+		ID:       testImageID,
+		TenantID: testTenantID,
+		State:    Created,
+		Signature: image.Signature{
+			Signature:   signTestData(t, key, data),
+			HashMethod:  "SHA-256",
+			KeyType:     keyType,
+			Certificate: testCertID,
+		},
+	}
+
+	err = imageStore.CreateImage(i)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = imageStore.UploadImage(testTenantID, testImageID, strings.NewReader(data), "")
+	if err != nil {
+		t.Fatalf("Unable to upload signed image: %v", err)
+	}
+
+	img, err := imageStore.GetImage(testTenantID, testImageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if img.State != Active || img.SignedSHA256 == "" || img.SignedSHA256 != img.SHA256 {
+		t.Fatalf("Signature of image not recorded: %+v", img)
+	}
+
+	err = imageStore.UploadImage(testTenantID, testImageID, strings.NewReader("tampered image data"), "")
+	if err != image.ErrBadSignature {
+		t.Fatalf("Expected %v uploading tampered data, got %v", image.ErrBadSignature, err)
+	}
+
+	img, err = imageStore.GetImage(testTenantID, testImageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if img.State != Killed {
+		t.Fatalf("Expected image with bad signature to be %s, got %s", Killed, img.State)
+	}
+}
+
+// Checks that images signed with RSA keys are verified.
+//
+// An image signed with an RSA key is uploaded, then replaced with data
+// that does not match the signature.
+//
+// The first upload should succeed and record the digest of the signed
+// data, the second should fail and kill the image.
+func TestPosixMetaDsSignedUploadRSA(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testSignedUpload(t, key, "RSA-PSS")
+}
+
+// Checks that images signed with elliptic curve keys are verified.
+//
+// An image signed with a P-384 key is uploaded, then replaced with data
+// that does not match the signature.
+//
+// The first upload should succeed and record the digest of the signed
+// data, the second should fail and kill the image.
+func TestPosixMetaDsSignedUploadECC(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testSignedUpload(t, key, "ECC_SECP384R1")
+}
+
+// Checks that invalid signature properties are rejected.
+//
+// Verifiers are created for signatures with unsupported hash methods and
+// key types, invalid certificate references and signatures that are not
+// base64 encoded.
+//
+// No verifier should be created.
+func TestSignatureVerifierInvalid(t *testing.T) {
+	valid := image.Signature{
+		Signature:   base64.StdEncoding.EncodeToString([]byte("signature")),
+		HashMethod:  "SHA-256",
+		KeyType:     "RSA-PSS",
+		Certificate: testCertID,
+	}
+
+	if _, err := newSignatureVerifier(valid); err != nil {
+		t.Fatalf("Valid signature rejected: %v", err)
+	}
+
+	tests := []func(s *image.Signature){
+		func(s *image.Signature) { s.HashMethod = "MD5" },
+		func(s *image.Signature) { s.KeyType = "DSA" },
+		func(s *image.Signature) { s.Certificate = "../../etc/ssl/cert" },
+		func(s *image.Signature) { s.Signature = "not base64!" },
+		func(s *image.Signature) { s.Signature = "" },
+	}
+
+	for i, tt := range tests {
+		sig := valid
+		tt(&sig)
+		if _, err := newSignatureVerifier(sig); err == nil {
+			t.Errorf("Invalid signature %d accepted: %+v", i, sig)
+		}
+	}
+}
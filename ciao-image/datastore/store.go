@@ -15,13 +15,18 @@
 package datastore
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"os"
 	"sync"
 
 	"github.com/01org/ciao/clogger/gloginterface"
 	"github.com/01org/ciao/database"
 	"github.com/01org/ciao/openstack/image"
+	"github.com/golang/glog"
 )
 
 const (
@@ -64,6 +69,23 @@ type ImageStore struct {
 	metaDs MetaDataStore
 	rawDs  RawDataStore
 	ImageMap
+
+	// MaxImportSize is the largest image, in bytes, that can be
+	// imported from a URL.  Zero means there is no limit.
+	MaxImportSize uint64
+
+	// AllowPrivateImports allows images to be imported from loopback,
+	// link-local and private addresses.
+	AllowPrivateImports bool
+
+	// TempDir is the directory in which image data is staged while it
+	// is checked and converted.  The system default is used if empty.
+	TempDir string
+
+	// CertDir is the directory holding the certificates with which the
+	// signatures of images are verified.  Each certificate is PEM encoded
+	// in a file named after its ID with a .pem extension.
+	CertDir string
 }
 
 // Init initializes the datastore struct and must be called before anything.
@@ -89,7 +111,8 @@ func (s *ImageStore) CreateImage(i Image) error {
 	return nil
 }
 
-// GetAllImages gets returns all the known images.
+// GetAllImages gets returns all the known images, including the images
+// shared with tenant that it has accepted.
 func (s *ImageStore) GetAllImages(tenant string) ([]Image, error) {
 	var images []Image
 	s.ImageMap.RLock()
@@ -99,15 +122,39 @@ func (s *ImageStore) GetAllImages(tenant string) ([]Image, error) {
 		return nil, err
 	}
 
+	members, err := s.findMembers("", tenant)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, m := range members {
+		if m.Status != image.MemberAccepted {
+			continue
+		}
+
+		img, err := s.metaDs.Get(m.Owner, m.ImageID)
+		if err == nil && img.ID == m.ImageID && img.Visibility == image.Shared {
+			images = append(images, img)
+		}
+	}
+
 	return images, nil
 }
 
-// GetImage returns the image specified by the ID string.
+// GetImage returns the image specified by the ID string.  Images shared
+// with tenant are returned whether or not it has accepted them.
 func (s *ImageStore) GetImage(tenant, ID string) (Image, error) {
 	s.ImageMap.RLock()
 	defer s.ImageMap.RUnlock()
 
 	img, err := s.metaDs.Get(tenant, ID)
+	if err != nil || img == (Image{}) {
+		shared, serr := s.sharedImage(tenant, ID)
+		if serr == nil {
+			return shared, nil
+		}
+	}
+
 	if err != nil {
 		return Image{}, image.ErrNoImage
 	}
@@ -115,12 +162,58 @@ func (s *ImageStore) GetImage(tenant, ID string) (Image, error) {
 	return img, nil
 }
 
+// setImageData copies the attributes of src that describe its data, and
+// are set when the data is uploaded or imported, to dst.
+func setImageData(dst *Image, src Image) {
+	dst.Size = src.Size
+	dst.Checksum = src.Checksum
+	dst.SHA256 = src.SHA256
+	dst.OriginalType = src.OriginalType
+	dst.StoredSHA256 = src.StoredSHA256
+	dst.SignedSHA256 = src.SignedSHA256
+}
+
+// mergeUpdate checks that the image held in the metadata store, cur, can
+// be replaced by i, and keeps in i the attributes of cur that the caller
+// may have read before an upload completed.  Images cannot be changed
+// while their data is being uploaded or imported.
+func mergeUpdate(i *Image, cur Image) error {
+	if cur == (Image{}) {
+		return image.ErrNoImage
+	}
+
+	if cur.State == Saving || cur.State == Importing {
+		return image.ErrImageSaving
+	}
+
+	setImageData(i, cur)
+	if cur.State != Created {
+		if i.State == Created {
+			i.State = cur.State
+		}
+		i.Type = cur.Type
+		i.Signature = cur.Signature
+	}
+
+	return nil
+}
+
 // UpdateImage will modify an existing image.
 func (s *ImageStore) UpdateImage(i Image) error {
 	s.ImageMap.Lock()
 	defer s.ImageMap.Unlock()
 
-	err := s.metaDs.Write(i)
+	cur, err := s.metaDs.Get(i.TenantID, i.ID)
+	if err != nil {
+		return image.ErrNoImage
+	}
+
+	err = mergeUpdate(&i, cur)
+	if err != nil {
+		return err
+	}
+
+	err = s.metaDs.Write(i)
 	if err != nil {
 		return err
 	}
@@ -128,21 +221,59 @@ func (s *ImageStore) UpdateImage(i Image) error {
 	return nil
 }
 
+// MoveImage will modify an existing image, moving it from the images of
+// tenant to those of i.TenantID.  This is needed when the visibility of
+// an image changes.
+func (s *ImageStore) MoveImage(tenant string, i Image) error {
+	s.ImageMap.Lock()
+	defer s.ImageMap.Unlock()
+
+	img, err := s.metaDs.Get(tenant, i.ID)
+	if err != nil {
+		return image.ErrNoImage
+	}
+
+	err = mergeUpdate(&i, img)
+	if err != nil {
+		return err
+	}
+
+	err = s.metaDs.Write(i)
+	if err != nil {
+		return err
+	}
+
+	if tenant == i.TenantID {
+		return nil
+	}
+
+	err = s.metaDs.Delete(tenant, i.ID)
+	if err != nil {
+		return err
+	}
+
+	return s.setMembersOwner(i.ID, i.TenantID)
+}
+
 // DeleteImage will delete an existing image.
 func (s *ImageStore) DeleteImage(tenant, ID string) error {
 	s.ImageMap.Lock()
 	defer s.ImageMap.Unlock()
 
-	img, err := s.metaDs.Get(tenant, ID)
+	img, err := s.ownedImage(tenant, ID)
 	if err != nil {
 		return err
 	}
 
-	if img == (Image{}) || img.TenantID != tenant {
+	if img.TenantID != tenant {
 		return image.ErrNoImage
 	}
 
-	if img.State == Active {
+	if img.Protected {
+		return image.ErrImageProtected
+	}
+
+	if img.State == Active || img.State == Deactivated {
 		err = s.rawDs.Delete(ID)
 		if err != nil {
 			return err
@@ -153,50 +284,217 @@ func (s *ImageStore) DeleteImage(tenant, ID string) error {
 		tenant = string(image.Public)
 	}
 	err = s.metaDs.Delete(tenant, ID)
+	if err != nil {
+		return err
+	}
 
-	return err
+	return s.deleteMembers(ID)
 }
 
-// UploadImage will read an image, save it and update the image cache.
-func (s *ImageStore) UploadImage(tenant, ID string, body io.Reader) error {
+// convertImage converts a staged image to raw and returns the converted
+// data along with its SHA-256 digest.  The caller must close and remove
+// the returned file.
+func (s *ImageStore) convertImage(path string, format Type) (*os.File, string, error) {
+	f, err := ioutil.TempFile(s.TempDir, "ciao-image-raw")
+	if err != nil {
+		return nil, "", fmt.Errorf("Error creating temporary image file: %v", err)
+	}
+	name := f.Name()
+	_ = f.Close()
 
-	s.ImageMap.RLock()
-	img, err := s.metaDs.Get(tenant, ID)
-	s.ImageMap.RUnlock()
+	err = convertToRaw(path, name, format)
+	if err == nil {
+		f, err = os.Open(name)
+	}
+	if err != nil {
+		_ = os.Remove(name)
+		return nil, "", err
+	}
+
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		_ = f.Close()
+		_ = os.Remove(name)
+		return nil, "", err
+	}
+
+	return f, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// storeImage writes the data of an image to the raw datastore.  The data
+// is staged in a temporary file first so that its checksum and format can
+// be checked and, if the raw datastore requires it, converted to raw.
+// The digests and formats of the data are recorded in img.  Malformed
+// images are reported with image.ErrMalformedImage and images whose
+// signature cannot be verified with image.ErrBadSignature.
+func (s *ImageStore) storeImage(img *Image, body io.Reader, checksum string) error {
+	staged, err := ioutil.TempFile(s.TempDir, "ciao-image")
+	if err != nil {
+		return fmt.Errorf("Error creating temporary image file: %v", err)
+	}
+	defer func() {
+		_ = staged.Close()
+		_ = os.Remove(staged.Name())
+	}()
+
+	var verifier *signatureVerifier
+	if img.Signature != (image.Signature{}) {
+		verifier, err = newSignatureVerifier(img.Signature)
+		if err != nil {
+			glog.Errorf("Image %s has an invalid signature: %v", img.ID, err)
+			return image.ErrBadSignature
+		}
+	}
+
+	hasher := newImageHasher()
+	var w io.Writer = hasher
+	if verifier != nil {
+		w = io.MultiWriter(hasher, verifier)
+	}
+
+	_, err = io.Copy(staged, io.TeeReader(body, w))
 	if err != nil {
 		return err
 	}
 
-	if img == (Image{}) {
-		return image.ErrNoImage
+	err = hasher.verify(checksum)
+	if err != nil {
+		return err
 	}
 
-	if img.State == Saving {
-		return image.ErrImageSaving
+	if verifier != nil {
+		err = verifier.verify(s.CertDir)
+		if err != nil {
+			glog.Errorf("Signature of image %s rejected: %v", img.ID, err)
+			return image.ErrBadSignature
+		}
 	}
 
-	img.State = Saving
+	format, err := detectFormat(staged)
+	if err != nil {
+		return err
+	}
 
-	if s.rawDs != nil {
-		err = s.rawDs.Write(ID, body)
+	if img.Type != "" && img.Type != format {
+		glog.Warningf("Image %s declared as %s contains %s data", img.ID, img.Type, format)
+	}
+
+	err = checkFormat(staged.Name(), format)
+	if err != nil {
+		glog.Errorf("Image %s rejected: %v", img.ID, err)
+		return image.ErrMalformedImage
+	}
+
+	hasher.setChecksums(img)
+	img.SignedSHA256 = ""
+	if verifier != nil {
+		img.SignedSHA256 = img.SHA256
+	}
+	img.OriginalType = format
+	img.Type = format
+	img.StoredSHA256 = img.SHA256
+
+	data := staged
+	fr, ok := s.rawDs.(FormatRequirer)
+	if ok && fr.RequiredFormat() == Raw && convertible(format) {
+		converted, sum, err := s.convertImage(staged.Name(), format)
 		if err != nil {
-			img.State = Killed
+			return err
+		}
+		defer func() {
+			_ = converted.Close()
+			_ = os.Remove(converted.Name())
+		}()
+
+		glog.Infof("Image %s converted from %s to %s", img.ID, format, Raw)
+
+		img.Type = Raw
+		img.StoredSHA256 = sum
+		data = converted
+	} else {
+		_, err = staged.Seek(0, io.SeekStart)
+		if err != nil {
+			return err
 		}
+	}
+
+	err = s.rawDs.Write(img.ID, data)
+	if err != nil {
+		_ = s.rawDs.Delete(img.ID)
+	}
+
+	return err
+}
 
-		img.Size, err = s.rawDs.GetImageSize(ID)
+// UploadImage will read an image, save it and update the image cache.
+// The MD5 and SHA-256 digests of the data are recorded with the image.
+// If checksum is not empty the data must match it, otherwise the data is
+// discarded and the image is killed.  Images that are not valid in the
+// format detected are rejected.
+func (s *ImageStore) UploadImage(tenant, ID string, body io.Reader, checksum string) error {
+	if checksum != "" {
+		err := validateChecksum(checksum)
 		if err != nil {
-			img.State = Killed
 			return err
 		}
 	}
 
+	// The image is marked as saving before the data is read so that
+	// it cannot be changed, moved or uploaded again in the meantime.
+	s.ImageMap.Lock()
+	img, err := s.metaDs.Get(tenant, ID)
+	if err == nil && img == (Image{}) {
+		err = image.ErrNoImage
+	} else if err == nil && (img.State == Saving || img.State == Importing) {
+		err = image.ErrImageSaving
+	} else if err == nil && img.State == Deactivated {
+		err = image.ErrImageDeactivated
+	}
 	if err == nil {
+		img.State = Saving
+		err = s.metaDs.Write(img)
+	}
+	s.ImageMap.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if s.rawDs != nil {
+		err = s.storeImage(&img, body, checksum)
+		if err == nil {
+			img.Size, err = s.rawDs.GetImageSize(ID)
+		}
+	}
+
+	switch err {
+	case nil:
 		img.State = Active
+	case image.ErrMalformedImage:
+		img.State = Rejected
+	default:
+		img.State = Killed
 	}
 
 	s.ImageMap.Lock()
 	defer s.ImageMap.Unlock()
-	metaDsErr := s.metaDs.Write(img)
+
+	cur, metaDsErr := s.metaDs.Get(tenant, ID)
+	if metaDsErr != nil || cur.ID != ID {
+		// The image was deleted while its data was being saved.
+		if s.rawDs != nil {
+			_ = s.rawDs.Delete(ID)
+		}
+		return image.ErrNoImage
+	}
+
+	setImageData(&cur, img)
+	cur.State = img.State
+	cur.Type = img.Type
+	metaDsErr = s.metaDs.Write(cur)
 
 	if err == nil && metaDsErr != nil {
 		err = metaDsErr
@@ -204,3 +502,27 @@ func (s *ImageStore) UploadImage(tenant, ID string, body io.Reader) error {
 
 	return err
 }
+
+// DownloadImage returns length bytes of the data of an active or
+// deactivated image, starting at offset.  A zero length reads the data up to its end.
+func (s *ImageStore) DownloadImage(tenant, ID string, offset, length uint64) (io.ReadCloser, error) {
+	s.ImageMap.RLock()
+	img, err := s.metaDs.Get(tenant, ID)
+	if err != nil || img == (Image{}) {
+		img, err = s.sharedImage(tenant, ID)
+	}
+	s.ImageMap.RUnlock()
+	if err != nil {
+		return nil, image.ErrNoImage
+	}
+
+	if (img.State != Active && img.State != Deactivated) || s.rawDs == nil {
+		return nil, image.ErrNoImageData
+	}
+
+	if offset > img.Size || length > img.Size-offset {
+		return nil, image.ErrBadRange
+	}
+
+	return s.rawDs.Read(ID, offset, length)
+}
@@ -23,7 +23,8 @@ import (
 )
 
 func processAttachVolume(storageDriver storage.BlockDriver, monitorCh chan interface{}, cfg *vmConfig,
-	instance, instanceDir, volumeUUID string, conn serverConn) *attachVolumeError {
+	instance, instanceDir string, volume volumeConfig, conn serverConn) *attachVolumeError {
+	volumeUUID := volume.UUID
 
 	if cfg.Container {
 		attachErr := &attachVolumeError{nil, payloads.AttachVolumeNotSupported}
@@ -66,9 +67,12 @@ func processAttachVolume(storageDriver storage.BlockDriver, monitorCh chan inter
 		responseCh := make(chan error)
 
 		monitorCh <- virtualizerAttachCmd{
-			responseCh: responseCh,
-			volumeUUID: volumeUUID,
-			device:     devName,
+			responseCh:    responseCh,
+			volumeUUID:    volumeUUID,
+			device:        devName,
+			ioLimits:      volume.IOLimits,
+			readOnly:      volume.ReadOnly,
+			encryptionKey: volume.encryptionKey,
 		}
 
 		err = <-responseCh
@@ -81,7 +85,7 @@ func processAttachVolume(storageDriver storage.BlockDriver, monitorCh chan inter
 		}
 	}
 
-	cfg.Volumes = append(cfg.Volumes, volumeConfig{UUID: volumeUUID})
+	cfg.Volumes = append(cfg.Volumes, volume)
 
 	err := cfg.save(instanceDir)
 	if err != nil {
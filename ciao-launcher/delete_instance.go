@@ -39,6 +39,19 @@ func deleteVnic(instanceDir string, conn serverConn) {
 	if err != nil {
 		glog.Warningf("Unable to destroy vnic: %s", err)
 	}
+
+	extraVnicCfgs, err := createExtraVnicCfgs(cfg)
+	if err != nil {
+		glog.Warningf("Unable to create vnicCfg: %s", err)
+		return
+	}
+
+	for _, extraCfg := range extraVnicCfgs {
+		err = destroyVnic(conn, extraCfg)
+		if err != nil {
+			glog.Warningf("Unable to destroy vnic: %s", err)
+		}
+	}
 }
 
 func processDelete(vm virtualizer, instanceDir string, conn serverConn, running ovsRunningState) error {
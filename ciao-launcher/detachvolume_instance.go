@@ -49,6 +49,7 @@ func processDetachVolume(storageDriver storage.BlockDriver, monitorCh chan inter
 		monitorCh <- virtualizerDetachCmd{
 			responseCh: responseCh,
 			volumeUUID: volumeUUID,
+			encrypted:  vol.Encrypted,
 		}
 
 		glog.Infof("Detaching Volume %v", volumeUUID)
@@ -24,6 +24,7 @@ import (
 	"os"
 	"os/exec"
 	"path"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -72,6 +73,9 @@ type mounter interface {
 
 // BUG(markus): We shouldn't report ssh ports for docker instances
 
+// dockerImages is the cache of the docker images pulled by the launcher.
+var dockerImages = newImageCache(dockerRemoveImage)
+
 func getDockerClient() (cli *client.Client, err error) {
 	return client.NewClient("unix:///var/run/docker.sock", "v1.22", nil,
 		map[string]string{
@@ -123,6 +127,8 @@ func (d *docker) checkBackingImage() error {
 
 	glog.Infof("Docker Image %s is present on node", d.cfg.DockerImage)
 
+	dockerImages.fetched(d.cfg.DockerImage, images[0].Size)
+
 	return nil
 }
 
@@ -169,9 +175,75 @@ func (d *docker) ensureBackingImage() error {
 		return err
 	}
 
+	// Record the size of the new image and make room for it.
+	_ = d.checkBackingImage()
+	go dockerImages.evict()
+
 	return nil
 }
 
+// prewarmDockerImage pulls a docker image into the image cache so that
+// the first instance using it starts quickly.
+func prewarmDockerImage(image string) {
+	d := &docker{cfg: &vmConfig{DockerImage: image}}
+	err := d.ensureBackingImage()
+	if err != nil {
+		glog.Errorf("Unable to prewarm docker image %s: %v", image, err)
+		return
+	}
+
+	glog.Infof("Docker image %s prewarmed", image)
+}
+
+// seedDockerImageCache records the docker images already present on the
+// node in the image cache, so that the images pulled before the launcher
+// was restarted can be evicted.  Images are assumed to have been last used
+// when they were created.
+func seedDockerImageCache() {
+	cli, err := getDockerClient()
+	if err != nil {
+		glog.Warningf("Unable to list docker images: %v", err)
+		return
+	}
+
+	images, err := cli.ImageList(context.Background(), types.ImageListOptions{})
+	if err != nil {
+		glog.Warningf("Unable to list docker images: %v", err)
+		return
+	}
+
+	for _, image := range images {
+		for _, tag := range image.RepoTags {
+			if tag == "<none>:<none>" {
+				continue
+			}
+
+			// Only the first tag is recorded so that the size of
+			// the image is not counted more than once.  Workloads
+			// usually name the latest images without their tag.
+			tag = strings.TrimSuffix(tag, ":latest")
+			dockerImages.seed(tag, image.Size, time.Unix(image.Created, 0))
+			break
+		}
+	}
+
+	go dockerImages.evict()
+}
+
+func dockerRemoveImage(image string) error {
+	cli, err := getDockerClient()
+	if err != nil {
+		return err
+	}
+
+	_, err = cli.ImageRemove(context.Background(),
+		types.ImageRemoveOptions{
+			ImageID:       image,
+			PruneChildren: true,
+		})
+	return err
+}
+
 func (d *docker) createConfigs(bridge string, userData, metaData []byte, volumes []string) (config *container.Config,
 	hostConfig *container.HostConfig, networkConfig *network.NetworkingConfig) {
 
@@ -365,7 +437,7 @@ func (d *docker) deleteImage() error {
 	return dockerDeleteContainer(d.cli, d.dockerID, d.cfg.Instance)
 }
 
-func (d *docker) startVM(vnicName, ipAddress, cephID string) error {
+func (d *docker) startVM(vnicNames []string, ipAddress, cephID string) error {
 	err := d.initDockerClient()
 	if err != nil {
 		return err
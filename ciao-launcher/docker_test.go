@@ -99,10 +99,26 @@ func (s dockerTestStorage) GetVolumeMapping() (map[string][]string, error) {
 	return nil, nil
 }
 
+func (s dockerTestStorage) ListBlockDevices() ([]string, error) {
+	return nil, nil
+}
+
 func (s dockerTestStorage) CopyBlockDevice(volumeUUID string) (storage.BlockDevice, error) {
 	return storage.BlockDevice{}, nil
 }
 
+func (s dockerTestStorage) ExportBlockDeviceSnapshot(volumeUUID string, snapshotID string, w io.Writer) error {
+	return nil
+}
+
+func (s dockerTestStorage) CreateEncryptedBlockDevice(volumeUUID string, sizeGB int, key string) (storage.BlockDevice, error) {
+	return storage.BlockDevice{}, nil
+}
+
+func (s dockerTestStorage) ImportBlockDevice(volumeUUID string, r io.Reader) (storage.BlockDevice, error) {
+	return storage.BlockDevice{}, nil
+}
+
 func (s dockerTestStorage) GetBlockDeviceSize(volumeUUID string) (uint64, error) {
 	return 0, nil
 }
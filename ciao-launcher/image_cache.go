@@ -0,0 +1,273 @@
+/*
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/01org/ciao/payloads"
+	"github.com/golang/glog"
+)
+
+type cachedImage struct {
+	size     int64
+	lastUsed time.Time
+	users    map[string]struct{}
+}
+
+// imageCache keeps track of the backing images fetched onto the node and
+// of the instances that use them.  Once the images take up more than
+// budgetMB, the least recently used images that are no longer used by any
+// instance are evicted.
+//
+// The overseer records which instance uses which image while the instance
+// go routines record the images they fetch, so all access is protected by
+// the mutex.  Images are removed without holding the mutex, as this can
+// take a while, but instances that claim an image being evicted wait for
+// its removal to complete before they fetch it again.
+type imageCache struct {
+	sync.Mutex
+	budgetMB  int
+	images    map[string]*cachedImage
+	instances map[string]string
+	evicting  map[string]chan struct{}
+	remove    func(image string) error
+}
+
+func newImageCache(remove func(image string) error) *imageCache {
+	return &imageCache{
+		images:    make(map[string]*cachedImage),
+		instances: make(map[string]string),
+		evicting:  make(map[string]chan struct{}),
+		remove:    remove,
+	}
+}
+
+func (c *imageCache) entry(image string) *cachedImage {
+	ci := c.images[image]
+	if ci == nil {
+		ci = &cachedImage{users: make(map[string]struct{})}
+		c.images[image] = ci
+	}
+	return ci
+}
+
+// use records that instance uses image.  It must be called before the
+// instance fetches the image to prevent the image from being evicted
+// while the instance is starting.
+func (c *imageCache) use(instance, image string) {
+	if image == "" {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	for {
+		done, ok := c.evicting[image]
+		if !ok {
+			break
+		}
+		c.Unlock()
+		<-done
+		c.Lock()
+	}
+
+	ci := c.entry(image)
+	ci.users[instance] = struct{}{}
+	ci.lastUsed = time.Now()
+	c.instances[instance] = image
+}
+
+// release records that instance no longer uses its image.  The image is
+// not evicted straight away, it may be reused by a later instance.
+func (c *imageCache) release(instance string) {
+	c.Lock()
+	defer c.Unlock()
+
+	image, ok := c.instances[instance]
+	if !ok {
+		return
+	}
+	delete(c.instances, instance)
+
+	ci := c.images[image]
+	if ci == nil {
+		return
+	}
+
+	delete(ci.users, instance)
+	ci.lastUsed = time.Now()
+
+	// images whose size is unknown were never fetched onto the node, e.g.,
+	// because the instance failed to start, so there is nothing to cache.
+	if len(ci.users) == 0 && ci.size == 0 {
+		delete(c.images, image)
+	}
+}
+
+// fetched records the size of an image present on the node.  Images that
+// are fetched without being used by an instance, i.e., pre-warmed images,
+// become candidates for eviction straight away.
+func (c *imageCache) fetched(image string, size int64) {
+	if image == "" {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	ci := c.entry(image)
+	ci.size = size
+	ci.lastUsed = time.Now()
+}
+
+// seed records an image found on the node when the launcher starts, last
+// used at lastUsed, so that images fetched by earlier runs of the launcher
+// count towards the budget and can be evicted.
+func (c *imageCache) seed(image string, size int64, lastUsed time.Time) {
+	if image == "" {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	ci := c.entry(image)
+	if ci.size == 0 {
+		ci.size = size
+	}
+	if ci.lastUsed.IsZero() {
+		ci.lastUsed = lastUsed
+	}
+}
+
+func (c *imageCache) sizeLocked() int64 {
+	var size int64
+	for _, ci := range c.images {
+		size += ci.size
+	}
+	return size
+}
+
+// evict removes the least recently used images not used by any instance
+// until the cache fits in its budget.  Images that cannot be removed are
+// kept in the cache.
+func (c *imageCache) evict() {
+	victims := c.pickVictims()
+
+	for _, victim := range victims {
+		glog.Infof("Evicting image %s from cache", victim.image)
+		err := c.remove(victim.image)
+
+		c.Lock()
+		if err != nil {
+			glog.Warningf("Unable to evict image %s: %v", victim.image, err)
+			ci := c.entry(victim.image)
+			if ci.size == 0 {
+				ci.size = victim.info.size
+			}
+			if ci.lastUsed.IsZero() {
+				ci.lastUsed = victim.info.lastUsed
+			}
+		}
+		close(c.evicting[victim.image])
+		delete(c.evicting, victim.image)
+		c.Unlock()
+	}
+}
+
+type imageCacheVictim struct {
+	image string
+	info  *cachedImage
+}
+
+// pickVictims removes from the cache the least recently used images not
+// used by any instance that need to be evicted for the cache to fit in its
+// budget and marks them as being evicted.
+func (c *imageCache) pickVictims() []imageCacheVictim {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.budgetMB <= 0 {
+		return nil
+	}
+
+	budget := int64(c.budgetMB) << 20
+	size := c.sizeLocked()
+
+	candidates := make([]imageCacheVictim, 0, len(c.images))
+	for image, ci := range c.images {
+		if len(ci.users) > 0 || ci.size == 0 {
+			continue
+		}
+		candidates = append(candidates, imageCacheVictim{image, ci})
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].info.lastUsed.Before(candidates[j].info.lastUsed)
+	})
+
+	var victims []imageCacheVictim
+	for _, v := range candidates {
+		if size <= budget {
+			break
+		}
+		size -= v.info.size
+		delete(c.images, v.image)
+		c.evicting[v.image] = make(chan struct{})
+		victims = append(victims, v)
+	}
+
+	if size > budget {
+		glog.Warningf("Image cache over budget, %d MB in use", size>>20)
+	}
+
+	return victims
+}
+
+// stats returns the usage of the cache in the format expected by the
+// STATS command.
+func (c *imageCache) stats() *payloads.ImageCacheStat {
+	c.Lock()
+	defer c.Unlock()
+
+	s := &payloads.ImageCacheStat{
+		SizeMB:   int(c.sizeLocked() >> 20),
+		BudgetMB: c.budgetMB,
+		Images:   make([]payloads.CachedImageStat, 0, len(c.images)),
+	}
+
+	names := make([]string, 0, len(c.images))
+	for image := range c.images {
+		names = append(names, image)
+	}
+	sort.Strings(names)
+
+	for _, image := range names {
+		ci := c.images[image]
+		s.Images = append(s.Images, payloads.CachedImageStat{
+			Image:     image,
+			SizeMB:    int(ci.size >> 20),
+			Instances: len(ci.users),
+			LastUsed:  ci.lastUsed.UTC().Format(time.RFC3339),
+		})
+	}
+
+	return s
+}
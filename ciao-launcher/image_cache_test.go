@@ -0,0 +1,181 @@
+/*
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+type imageCacheTestRemover struct {
+	removed []string
+	fail    map[string]bool
+}
+
+func (r *imageCacheTestRemover) remove(image string) error {
+	if r.fail[image] {
+		return fmt.Errorf("%s is busy", image)
+	}
+	r.removed = append(r.removed, image)
+	return nil
+}
+
+// Checks that unused images are evicted in LRU order.
+//
+// Four images of 100MB are added to a cache with a budget of 250MB.  Two
+// of the images are used by instances.  One instance is then deleted and
+// the cache is evicted.
+//
+// The two least recently used images that are no longer used by any
+// instance should be evicted, in that order, and the stats should only
+// list the remaining images.
+func TestImageCacheEvict(t *testing.T) {
+	r := &imageCacheTestRemover{}
+	c := newImageCache(r.remove)
+	c.budgetMB = 250
+
+	c.use("instance-a", "image-a")
+	c.use("instance-b", "image-b")
+
+	base := time.Now()
+	for i, image := range []string{"image-a", "image-b", "image-c", "image-d"} {
+		c.fetched(image, 100<<20)
+		c.images[image].lastUsed = base.Add(time.Duration(i) * time.Minute)
+	}
+
+	c.release("instance-a")
+	c.images["image-a"].lastUsed = base.Add(-time.Minute)
+
+	c.evict()
+
+	if len(r.removed) != 2 || r.removed[0] != "image-a" || r.removed[1] != "image-c" {
+		t.Fatalf("Unexpected images evicted %v", r.removed)
+	}
+
+	s := c.stats()
+	if s.SizeMB != 200 || s.BudgetMB != 250 || len(s.Images) != 2 {
+		t.Fatalf("Unexpected cache stats %+v", s)
+	}
+
+	if s.Images[0].Image != "image-b" || s.Images[0].Instances != 1 ||
+		s.Images[1].Image != "image-d" || s.Images[1].Instances != 0 {
+		t.Fatalf("Unexpected image stats %+v", s.Images)
+	}
+}
+
+// Checks that images are only evicted when possible.
+//
+// An in-use image and an image that cannot be removed are added to a
+// cache whose budget they exceed, then the cache is evicted.  The budget
+// is then removed and an unused image added.
+//
+// Nothing should be evicted from the cache in both cases.
+func TestImageCacheNoEvict(t *testing.T) {
+	r := &imageCacheTestRemover{fail: map[string]bool{"image-busy": true}}
+	c := newImageCache(r.remove)
+	c.budgetMB = 100
+
+	c.use("instance-a", "image-a")
+	c.fetched("image-a", 100<<20)
+	c.fetched("image-busy", 100<<20)
+
+	c.evict()
+	if len(r.removed) != 0 || len(c.images) != 2 {
+		t.Fatalf("Unexpected eviction %v", r.removed)
+	}
+
+	c.budgetMB = 0
+	c.fetched("image-b", 100<<20)
+	c.evict()
+	if len(r.removed) != 0 || len(c.images) != 3 {
+		t.Fatalf("Unexpected eviction %v", r.removed)
+	}
+}
+
+// Checks that images that were never fetched are forgotten.
+//
+// An instance using an image whose size was never recorded is released.
+//
+// The image should no longer be listed in the cache.
+func TestImageCacheReleaseUnfetched(t *testing.T) {
+	c := newImageCache(func(string) error { return nil })
+
+	c.use("instance-a", "image-a")
+	if len(c.stats().Images) != 1 {
+		t.Fatalf("Image not tracked")
+	}
+
+	c.release("instance-a")
+	if len(c.stats().Images) != 0 {
+		t.Fatalf("Unfetched image still tracked")
+	}
+
+	// releasing an unknown instance should be harmless
+	c.release("instance-b")
+}
+
+// Checks that images are removed without holding the cache lock.
+//
+// Two unused images, one of them seeded at startup, are added to a cache
+// whose budget they exceed.  The cache is evicted by a remover that reads
+// the cache stats and blocks until an instance claims the image being
+// removed.
+//
+// The stats should be readable during the removal, the instance should
+// only claim the image once it has been removed and the image seeded at
+// startup, being the least recently used, should be the one evicted.
+func TestImageCacheEvictUnlocked(t *testing.T) {
+	claiming := make(chan struct{})
+	claimed := make(chan struct{})
+	var c *imageCache
+	var removed []string
+	c = newImageCache(func(image string) error {
+		_ = c.stats()
+		close(claiming)
+		select {
+		case <-claimed:
+			t.Errorf("Image %s claimed during its removal", image)
+		case <-time.After(50 * time.Millisecond):
+		}
+		removed = append(removed, image)
+		return nil
+	})
+	c.budgetMB = 150
+
+	c.fetched("image-a", 100<<20)
+	c.seed("image-b", 100<<20, time.Now().Add(-time.Hour))
+
+	go func() {
+		<-claiming
+		c.use("instance-a", "image-b")
+		close(claimed)
+	}()
+
+	c.evict()
+	<-claimed
+
+	if len(removed) != 1 || removed[0] != "image-b" {
+		t.Fatalf("Unexpected images evicted %v", removed)
+	}
+
+	s := c.stats()
+	if len(s.Images) != 2 || s.Images[1].Image != "image-b" ||
+		s.Images[1].Instances != 1 || s.Images[1].SizeMB != 0 {
+		t.Fatalf("Unexpected image stats %+v", s.Images)
+	}
+}
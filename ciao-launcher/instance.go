@@ -24,6 +24,7 @@ import (
 	yaml "gopkg.in/yaml.v2"
 
 	storage "github.com/01org/ciao/ciao-storage"
+	"github.com/01org/ciao/networking/libsnnet"
 	"github.com/01org/ciao/payloads"
 	"github.com/01org/ciao/ssntp"
 	"github.com/golang/glog"
@@ -81,11 +82,19 @@ type insDeleteCmd struct {
 type insMonitorCmd struct{}
 
 type insAttachVolumeCmd struct {
-	volumeUUID string
+	volume volumeConfig
 }
 type insDetachVolumeCmd struct {
 	volumeUUID string
 }
+type insUpdateVolumeCmd struct {
+	volumeUUID string
+	ioLimits   payloads.IOLimits
+}
+type insSecurityRulesCmd struct {
+	action libsnnet.FwAction
+	rules  []libsnnet.SecurityRule
+}
 
 /*
 This functions asks the server loop to kill the instance.  An instance
@@ -234,20 +243,20 @@ func (id *instanceData) attachVolumeCommand(cmd *insAttachVolumeCmd) {
 	if id.shuttingDown {
 		attachErr := &attachVolumeError{nil, payloads.AttachVolumeInstanceFailure}
 		glog.Errorf("Unable to attach instance[%s]", string(attachErr.code))
-		attachErr.send(id.ac.conn, id.instance, cmd.volumeUUID)
+		attachErr.send(id.ac.conn, id.instance, cmd.volume.UUID)
 		return
 	}
 
 	attachErr := processAttachVolume(id.storageDriver, id.monitorCh, id.cfg, id.instance, id.instanceDir,
-		cmd.volumeUUID, id.ac.conn)
+		cmd.volume, id.ac.conn)
 	if attachErr != nil {
-		attachErr.send(id.ac.conn, id.instance, cmd.volumeUUID)
+		attachErr.send(id.ac.conn, id.instance, cmd.volume.UUID)
 		return
 	}
 	d, m, c := id.vm.stats()
 	id.ovsCh <- &ovsStatsUpdateCmd{id.instance, m, d, c, id.getVolumes()}
 
-	glog.Infof("Volume %s attached to instance %s", cmd.volumeUUID, id.instance)
+	glog.Infof("Volume %s attached to instance %s", cmd.volume.UUID, id.instance)
 }
 
 func (id *instanceData) detachVolumeCommand(cmd *insDetachVolumeCmd) {
@@ -270,6 +279,39 @@ func (id *instanceData) detachVolumeCommand(cmd *insDetachVolumeCmd) {
 	glog.Infof("Volume %s detched from instance %s", cmd.volumeUUID, id.instance)
 }
 
+func (id *instanceData) updateVolumeCommand(cmd *insUpdateVolumeCmd) {
+	if id.shuttingDown {
+		updateErr := &updateVolumeError{nil, payloads.UpdateVolumeInstanceFailure}
+		glog.Errorf("Unable to update volume of instance[%s]", string(updateErr.code))
+		updateErr.send(id.ac.conn, id.instance, cmd.volumeUUID)
+		return
+	}
+
+	updateErr := processUpdateVolume(id.monitorCh, id.cfg, id.instance, id.instanceDir,
+		cmd.volumeUUID, cmd.ioLimits)
+	if updateErr != nil {
+		updateErr.send(id.ac.conn, id.instance, cmd.volumeUUID)
+		return
+	}
+
+	glog.Infof("Volume %s of instance %s updated", cmd.volumeUUID, id.instance)
+}
+
+func (id *instanceData) securityRulesCommand(cmd *insSecurityRulesCmd) {
+	if id.shuttingDown {
+		glog.Warningf("Ignoring security rules of instance %s being deleted", id.instance)
+		return
+	}
+
+	err := processSecurityRules(id.cfg, cmd.action, cmd.rules)
+	if err != nil {
+		glog.Errorf("Unable to update security rules of instance %s: %v", id.instance, err)
+		return
+	}
+
+	glog.Infof("Security rules of instance %s updated", id.instance)
+}
+
 func (id *instanceData) logStartTrace() {
 	if id.st == nil {
 		return
@@ -309,6 +351,10 @@ func (id *instanceData) instanceCommand(cmd interface{}) bool {
 		id.attachVolumeCommand(cmd)
 	case *insDetachVolumeCmd:
 		id.detachVolumeCommand(cmd)
+	case *insUpdateVolumeCmd:
+		id.updateVolumeCommand(cmd)
+	case *insSecurityRulesCmd:
+		id.securityRulesCommand(cmd)
 	case *insDeleteCmd:
 		if id.deleteCommand(cmd) {
 			return false
@@ -434,7 +480,7 @@ func startInstance(instance string, cfg *vmConfig, wg *sync.WaitGroup, doneCh ch
 	} else if cfg.Container {
 		vm = &docker{storageDriver: storageDriver}
 	} else {
-		vm = &qemuV{}
+		vm = &qemuV{storageDriver: storageDriver}
 	}
 	return startInstanceWithVM(instance, cfg, wg, doneCh, ac, ovsCh, vm, storageDriver,
 		instancesDir)
@@ -60,6 +60,7 @@ type instanceTestState struct {
 	df              payloads.ErrorDeleteFailure
 	avf             payloads.ErrorAttachVolumeFailure
 	dvf             payloads.ErrorDetachVolumeFailure
+	uvf             payloads.ErrorUpdateVolumeFailure
 	deMigration     bool
 	de              payloads.EventInstanceDeleted
 	se              payloads.EventInstanceStopped
@@ -92,7 +93,7 @@ func (v *instanceTestState) deleteImage() error {
 	return nil
 }
 
-func (v *instanceTestState) startVM(vnicName, ipAddress, cephID string) error {
+func (v *instanceTestState) startVM(vnicNames []string, ipAddress, cephID string) error {
 	if v.failStartVM {
 		return fmt.Errorf("Failed to start VM")
 	}
@@ -148,6 +149,11 @@ func (v *instanceTestState) SendError(error ssntp.Error, payload []byte) (int, e
 		if err != nil {
 			v.t.Fatalf("Failed to unmarshall detach volume error %v", err)
 		}
+	case ssntp.UpdateVolumeFailure:
+		err := yaml.Unmarshal(payload, &v.uvf)
+		if err != nil {
+			v.t.Fatalf("Failed to unmarshall update volume error %v", err)
+		}
 	}
 
 	if v.errorCh != nil {
@@ -835,7 +841,7 @@ func TestAttachVolumeToInstance(t *testing.T) {
 	state, ovsCh, cmdCh, doneCh := startVMWithCFG(t, &wg, &cfg, true, false)
 
 	select {
-	case cmdCh <- &insAttachVolumeCmd{testutil.VolumeUUID}:
+	case cmdCh <- &insAttachVolumeCmd{volumeConfig{UUID: testutil.VolumeUUID}}:
 	case <-time.After(time.Second):
 		t.Error("Timed out sending attach volume command")
 	}
@@ -870,7 +876,7 @@ func TestAttachExistingVolumeToInstance(t *testing.T) {
 	state, ovsCh, cmdCh, doneCh := startVMWithCFG(t, &wg, &cfg, true, false)
 
 	select {
-	case cmdCh <- &insAttachVolumeCmd{testutil.VolumeUUID}:
+	case cmdCh <- &insAttachVolumeCmd{volumeConfig{UUID: testutil.VolumeUUID}}:
 	case <-time.After(time.Second):
 		t.Error("Timed out sending attach volume command")
 	}
@@ -887,7 +893,7 @@ func TestAttachExistingVolumeToInstance(t *testing.T) {
 	select {
 	case <-state.errorCh:
 		t.Error("Initial Volume attach failed")
-	case cmdCh <- &insAttachVolumeCmd{testutil.VolumeUUID}:
+	case cmdCh <- &insAttachVolumeCmd{volumeConfig{UUID: testutil.VolumeUUID}}:
 	case <-time.After(time.Second):
 		t.Error("Timed out sending attach volume command")
 	}
@@ -924,7 +930,7 @@ func TestDetachVolumeFromInstance(t *testing.T) {
 	state, ovsCh, cmdCh, doneCh := startVMWithCFG(t, &wg, &cfg, true, false)
 
 	select {
-	case cmdCh <- &insAttachVolumeCmd{testutil.VolumeUUID}:
+	case cmdCh <- &insAttachVolumeCmd{volumeConfig{UUID: testutil.VolumeUUID}}:
 	case <-time.After(time.Second):
 		t.Error("Timed out sending attach volume command")
 	}
@@ -995,6 +1001,95 @@ func TestDetachNonexistingVolumeFromInstance(t *testing.T) {
 	wg.Wait()
 }
 
+// Check we can update the I/O limits of a volume attached to an instance
+//
+// We start the instance loop, add a volume, wait for the instance statistics,
+// update the volume's I/O limits and then delete the instance.
+//
+// The instanceLoop and then instance should start correctly.  The volume should
+// be correctly attached.  The update command should be passed to the monitor
+// with the new I/O limits and the instance should be correctly deleted.
+func TestUpdateVolumeOfInstance(t *testing.T) {
+	var wg sync.WaitGroup
+	cfg := standardCfg
+	state, ovsCh, cmdCh, doneCh := startVMWithCFG(t, &wg, &cfg, true, false)
+
+	select {
+	case cmdCh <- &insAttachVolumeCmd{volumeConfig{UUID: testutil.VolumeUUID}}:
+	case <-time.After(time.Second):
+		t.Error("Timed out sending attach volume command")
+	}
+
+	select {
+	case monCmd := <-state.monitorCh:
+		monCmd.(virtualizerAttachCmd).responseCh <- nil
+	case <-time.After(time.Second):
+		t.Error("Timed out waiting for attach volume command result")
+	}
+
+	_ = state.expectStatsUpdateWithVolumes(t, ovsCh, []string{testutil.VolumeUUID})
+
+	limits := payloads.IOLimits{TotalIOPSSec: 500}
+	select {
+	case cmdCh <- &insUpdateVolumeCmd{testutil.VolumeUUID, limits}:
+	case <-time.After(time.Second):
+		t.Error("Timed out sending update volume command")
+	}
+
+	select {
+	case monCmd := <-state.monitorCh:
+		updateCmd := monCmd.(virtualizerUpdateCmd)
+		if updateCmd.ioLimits != limits {
+			t.Errorf("Unexpected I/O limits.  Expected %+v got %+v",
+				limits, updateCmd.ioLimits)
+		}
+		updateCmd.responseCh <- nil
+	case <-time.After(time.Second):
+		t.Error("Timed out waiting for update volume command result")
+	}
+
+	if !state.deleteInstance(t, ovsCh, cmdCh) {
+		cleanupShutdownFail(t, cfg.Instance, doneCh, ovsCh, &wg)
+	}
+
+	wg.Wait()
+}
+
+// Check that updating a nonexistent volume fails
+//
+// We start the instance loop, update a volume and delete the instance.
+//
+// The instanceLoop and then instance should start correctly.  The volume should
+// fail to be updated as it isn't attached. The instance should be correctly
+// deleted.
+func TestUpdateNonexistingVolumeOfInstance(t *testing.T) {
+	var wg sync.WaitGroup
+	cfg := standardCfg
+	state, ovsCh, cmdCh, doneCh := startVMWithCFG(t, &wg, &cfg, true, false)
+
+	select {
+	case cmdCh <- &insUpdateVolumeCmd{testutil.VolumeUUID, payloads.IOLimits{}}:
+	case <-time.After(time.Second):
+		t.Error("Timed out sending update volume command")
+	}
+
+	select {
+	case <-state.errorCh:
+		if state.uvf.Reason != payloads.UpdateVolumeNotAttached {
+			t.Errorf("Unexpected error.  Expected %s got %s",
+				payloads.UpdateVolumeNotAttached, state.uvf.Reason)
+		}
+	case <-time.After(time.Second):
+		t.Error("Timed out waiting for update to fail")
+	}
+
+	if !state.deleteInstance(t, ovsCh, cmdCh) {
+		cleanupShutdownFail(t, cfg.Instance, doneCh, ovsCh, &wg)
+	}
+
+	wg.Wait()
+}
+
 func TestMain(m *testing.M) {
 	flag.Parse()
 	var err error
@@ -79,6 +79,8 @@ var serverCertPath string
 var clientCertPath string
 var computeNet []string
 var mgmtNet []string
+var tunnelMode string
+var vxlanGroup string
 var networking bool
 var hardReset bool
 var diskLimit bool
@@ -94,6 +96,8 @@ func init() {
 	flag.BoolVar(&hardReset, "hard-reset", false, "Kill and delete all instances, reset networking and exit")
 	flag.BoolVar(&simulate, "simulation", false, "Launcher simulation")
 	flag.StringVar(&cephID, "ceph_id", "", "ceph client id")
+	flag.IntVar(&dockerImages.budgetMB, "image-cache-mb", 0,
+		"Size in MB above which unused docker images are evicted, 0 for no limit")
 }
 
 const (
@@ -233,6 +237,8 @@ func loadClusterConfig(conn serverConn) error {
 	}
 	computeNet = clusterConfig.Configure.Launcher.ComputeNetwork
 	mgmtNet = clusterConfig.Configure.Launcher.ManagementNetwork
+	tunnelMode = clusterConfig.Configure.Launcher.TunnelMode
+	vxlanGroup = clusterConfig.Configure.Launcher.VxlanGroup
 	diskLimit = clusterConfig.Configure.Launcher.DiskLimit
 	memLimit = clusterConfig.Configure.Launcher.MemoryLimit
 	if cephID == "" {
@@ -246,6 +252,8 @@ func printClusterConfig() {
 	glog.Info("-----------------------")
 	glog.Infof("Compute Network:      %v", computeNet)
 	glog.Infof("Management Network:   %v", mgmtNet)
+	glog.Infof("Tunnel Mode:          %v", tunnelMode)
+	glog.Infof("VXLAN Group:          %v", vxlanGroup)
 	glog.Infof("Disk Limit:           %v", diskLimit)
 	glog.Infof("Memory Limit:         %v", memLimit)
 	glog.Infof("Ceph ID:              %v", cephID)
@@ -499,6 +507,10 @@ func main() {
 			glog.Fatalf("Unable to create mandatory dirs: %v", err)
 		}
 
+		if !simulate {
+			seedDockerImageCache()
+		}
+
 		exitCode = startLauncher()
 	}
 
@@ -63,6 +63,20 @@ func initNetworkPhase1() error {
 		Mode:          libsnnet.GreTunnel,
 	}
 
+	switch tunnelMode {
+	case "", "gre":
+	case "vxlan":
+		cn.NetworkConfig.Mode = libsnnet.Vxlan
+		if vxlanGroup != "" {
+			cn.NetworkConfig.VxlanGroup = net.ParseIP(vxlanGroup)
+			if cn.NetworkConfig.VxlanGroup == nil {
+				return fmt.Errorf("Unable to Parse VXLAN group :%s", vxlanGroup)
+			}
+		}
+	default:
+		return fmt.Errorf("Unsupported tunnel mode :%s", tunnelMode)
+	}
+
 	libsnnet.CnMaxAPIConcurrency = 1
 	if err := cn.Init(); err != nil {
 		return err
@@ -176,6 +190,23 @@ func createCNVnicCfg(cfg *vmConfig) (*libsnnet.VnicConfig, error) {
 		return nil, fmt.Errorf("Invalid vnic subnet %v", err)
 	}
 
+	var vnetV6 net.IPNet
+	if cfg.SubnetIPv6 != "" {
+		_, subnetV6, err := net.ParseCIDR(cfg.SubnetIPv6)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid vnic IPv6 subnet %v", err)
+		}
+		vnetV6 = *subnetV6
+	}
+
+	var gateway net.IP
+	if cfg.Gateway != "" {
+		gateway = net.ParseIP(cfg.Gateway)
+		if gateway == nil || !vnet.Contains(gateway) {
+			return nil, fmt.Errorf("Invalid vnic gateway %s", cfg.Gateway)
+		}
+	}
+
 	concIP := net.ParseIP(cfg.ConcIP)
 	if concIP == nil {
 		return nil, fmt.Errorf("Invalid concentrator ip %s", cfg.ConcIP)
@@ -186,7 +217,16 @@ func createCNVnicCfg(cfg *vmConfig) (*libsnnet.VnicConfig, error) {
 		return nil, fmt.Errorf("Invalid vnicIP ip %s", cfg.VnicIP)
 	}
 
-	subnetKey := binary.LittleEndian.Uint32(vnet.IP)
+	subnetKey := cfg.SubnetKey
+	if subnetKey == 0 {
+		// instances started by older controllers have no subnet key,
+		// they keep using the one derived from the subnet address
+		subnetKey = int(binary.LittleEndian.Uint32(vnet.IP))
+	}
+	if subnetKey <= 0 || subnetKey > libsnnet.MaxSubnetKey {
+		return nil, fmt.Errorf("Invalid subnet key %d", subnetKey)
+	}
+
 	var role libsnnet.VnicRole
 	if cfg.Container {
 		role = libsnnet.TenantContainer
@@ -200,7 +240,10 @@ func createCNVnicCfg(cfg *vmConfig) (*libsnnet.VnicConfig, error) {
 		ConcIP:     concIP,
 		VnicMAC:    mac,
 		Subnet:     *vnet,
-		SubnetKey:  int(subnetKey),
+		SubnetV6:   vnetV6,
+		Gateway:    gateway,
+		NetworkID:  cfg.NetworkUUID,
+		SubnetKey:  subnetKey,
 		VnicID:     cfg.VnicUUID,
 		InstanceID: cfg.Instance,
 		TenantID:   cfg.TenantUUID,
@@ -225,6 +268,33 @@ func createCNCIVnicCfg(cfg *vmConfig) (*libsnnet.VnicConfig, error) {
 		TenantID:   cfg.TenantUUID}, nil
 }
 
+// createExtraVnicCfgs returns the configurations of the additional vnics
+// of a VM attached to several tenant networks.  They share the tenant and
+// concentrator of the first vnic.
+func createExtraVnicCfgs(cfg *vmConfig) ([]*libsnnet.VnicConfig, error) {
+	vnicCfgs := make([]*libsnnet.VnicConfig, 0, len(cfg.ExtraVnics))
+
+	for _, extra := range cfg.ExtraVnics {
+		nicCfg := *cfg
+		nicCfg.VnicMAC = extra.VnicMAC
+		nicCfg.VnicIP = extra.VnicIP
+		nicCfg.SubnetIP = extra.SubnetIP
+		nicCfg.SubnetIPv6 = ""
+		nicCfg.SubnetKey = extra.SubnetKey
+		nicCfg.Gateway = extra.Gateway
+		nicCfg.NetworkUUID = extra.NetworkUUID
+		nicCfg.VnicUUID = extra.VnicUUID
+
+		vnicCfg, err := createCNVnicCfg(&nicCfg)
+		if err != nil {
+			return nil, err
+		}
+		vnicCfgs = append(vnicCfgs, vnicCfg)
+	}
+
+	return vnicCfgs, nil
+}
+
 func createVnicCfg(cfg *vmConfig) (*libsnnet.VnicConfig, error) {
 	if cfg.NetworkNode {
 		return createCNCIVnicCfg(cfg)
@@ -326,6 +396,16 @@ func destroyVnic(conn serverConn, vnicCfg *libsnnet.VnicConfig) error {
 	return nil
 }
 
+func setTunnelPeers(cfg *libsnnet.VnicConfig, peers []net.IP) {
+	if !networking || cnNet == nil {
+		return
+	}
+
+	if err := cnNet.SetTunnelPeers(cfg, peers); err != nil {
+		glog.Warningf("Unable to set tunnel peers of %s: %v", cfg.SubnetID, err)
+	}
+}
+
 func getNodeIPAddress() string {
 	if len(nicInfo) == 0 {
 		return "127.0.0.1"
@@ -316,6 +316,10 @@ func (ovs *overseer) sendStats(cns *cnStats, status ssntp.Status) {
 		i++
 	}
 
+	if role := ovs.ac.conn.Role(); role.IsAgent() {
+		s.ImageCache = dockerImages.stats()
+	}
+
 	payload, err := yaml.Marshal(&s)
 	if err != nil {
 		glog.Errorf("Unable to Marshall STATS %v", err)
@@ -396,6 +400,7 @@ func (ovs *overseer) processAddCommand(cmd *ovsAddCmd) {
 		ovs.vcpusAllocated += cfg.Cpus
 		ovs.diskSpaceAllocated += cfg.Disk
 		ovs.memoryAllocated += cfg.Mem
+		dockerImages.use(cmd.instance, cfg.DockerImage)
 		targetCh = startInstance(cmd.instance, cfg, ovs.childWg, ovs.childDoneCh,
 			ovs.ac, ovs.ovsInstanceCh)
 		ovs.instances[cmd.instance] = &ovsInstanceState{
@@ -440,6 +445,8 @@ func (ovs *overseer) processRemoveCommand(cmd *ovsRemoveCmd) {
 	}
 
 	delete(ovs.instances, cmd.instance)
+	dockerImages.release(cmd.instance)
+	go dockerImages.evict()
 	cmd.errCh <- nil
 }
 
@@ -619,6 +626,7 @@ func startOverseerFull(instancesDir string, wg *sync.WaitGroup, ac *agentClient,
 		diskSpaceAllocated += cfg.Disk
 		memoryAllocated += cfg.Mem
 
+		dockerImages.use(instance, cfg.DockerImage)
 		target := startInstance(instance, cfg, childWg, childDoneCh, ac, ovsInstanceCh)
 		instances[instance] = &ovsInstanceState{
 			cmdCh:          target,
@@ -19,9 +19,13 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"net"
 	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/01org/ciao/networking/libsnnet"
@@ -65,8 +69,21 @@ func printCloudinit(data *payloads.Start) {
 	glog.Infof("VnicIP:               %v", net.PrivateIP)
 	glog.Infof("ConcIP:               %v", net.ConcentratorIP)
 	glog.Infof("SubnetIP:             %v", net.Subnet)
+	glog.Infof("SubnetIPv6:           %v", net.SubnetV6)
+	glog.Infof("SubnetKey:            %v", net.SubnetKey)
+	glog.Infof("Gateway:              %v", net.Gateway)
+	glog.Infof("NetworkUUID:          %v", net.NetworkUUID)
 	glog.Infof("ConcUUID:             %v", net.ConcentratorUUID)
 	glog.Infof("VnicUUID:             %v", net.VnicUUID)
+	for _, extra := range start.ExtraNetworking {
+		glog.Infof("Extra VnicMAC:        %v", extra.VnicMAC)
+		glog.Infof("Extra VnicIP:         %v", extra.PrivateIP)
+		glog.Infof("Extra SubnetIP:       %v", extra.Subnet)
+		glog.Infof("Extra SubnetKey:      %v", extra.SubnetKey)
+		glog.Infof("Extra Gateway:        %v", extra.Gateway)
+		glog.Infof("Extra NetworkUUID:    %v", extra.NetworkUUID)
+		glog.Infof("Extra VnicUUID:       %v", extra.VnicUUID)
+	}
 	glog.Infof("Restart:              %t", start.Restart)
 
 	glog.Info("Requested resources:")
@@ -115,6 +132,22 @@ func parseVMTtype(start *payloads.StartCmd) (bool, error) {
 	return vmType == payloads.Docker, nil
 }
 
+// parseSubnetKey returns the key the controller allocated to the tenant
+// subnet of a vnic, or 0 if there is none.
+func parseSubnetKey(key string) (int, error) {
+	key = strings.TrimSpace(key)
+	if key == "" {
+		return 0, nil
+	}
+
+	subnetKey, err := strconv.Atoi(key)
+	if err != nil || subnetKey <= 0 || subnetKey > libsnnet.MaxSubnetKey {
+		return 0, fmt.Errorf("Invalid subnet key received: %s", key)
+	}
+
+	return subnetKey, nil
+}
+
 func parseStartPayload(data []byte) (*vmConfig, *payloadError) {
 	var clouddata payloads.Start
 
@@ -160,13 +193,69 @@ func parseStartPayload(data []byte) (*vmConfig, *payloadError) {
 	net := &start.Networking
 	vnicIP := strings.TrimSpace(net.PrivateIP)
 	sshPort := computeSSHPort(networkNode, vnicIP)
+
+	if len(start.ExtraNetworking) > 0 && (container || networkNode) {
+		err = fmt.Errorf("Only VMs can be attached to several networks")
+		return nil, &payloadError{err, payloads.InvalidData}
+	}
+
+	subnetKey, err := parseSubnetKey(net.SubnetKey)
+	if err != nil {
+		return nil, &payloadError{err, payloads.InvalidData}
+	}
+
+	var extraVnics []vnicConfig
+	for _, extra := range start.ExtraNetworking {
+		extraKey, err := parseSubnetKey(extra.SubnetKey)
+		if err != nil {
+			return nil, &payloadError{err, payloads.InvalidData}
+		}
+
+		extraVnics = append(extraVnics, vnicConfig{
+			VnicMAC:     strings.TrimSpace(extra.VnicMAC),
+			VnicIP:      strings.TrimSpace(extra.PrivateIP),
+			SubnetIP:    strings.TrimSpace(extra.Subnet),
+			SubnetKey:   extraKey,
+			Gateway:     strings.TrimSpace(extra.Gateway),
+			NetworkUUID: strings.TrimSpace(extra.NetworkUUID),
+			VnicUUID:    strings.TrimSpace(extra.VnicUUID),
+		})
+	}
+
 	var volumes []volumeConfig
 	for _, storage := range start.Storage {
 		if storage.ID != "" {
-			volumes = append(volumes, volumeConfig{
+			vol := volumeConfig{
 				UUID:     storage.ID,
 				Bootable: storage.Bootable,
-			})
+				ReadOnly: storage.ReadOnly,
+			}
+			if storage.IOLimits != nil {
+				vol.IOLimits = *storage.IOLimits
+			}
+			if storage.EncryptionKey != "" {
+				if container {
+					err = fmt.Errorf("Encrypted volumes are not supported for containers")
+					return nil, &payloadError{err, payloads.InvalidData}
+				}
+				key, payloadErr := extractEncryptionKey(storage.EncryptionKey,
+					payloads.InvalidData)
+				if payloadErr != nil {
+					return nil, payloadErr
+				}
+				vol.Encrypted = true
+				vol.encryptionKey = key
+			}
+			if storage.ImageSHA256 != "" {
+				sum, err := hex.DecodeString(storage.ImageSHA256)
+				if err != nil || len(sum) != sha256.Size || storage.ImageUUID == "" {
+					err = fmt.Errorf("Invalid image checksum for volume %s", storage.ID)
+					return nil, &payloadError{err, payloads.InvalidData}
+				}
+				vol.imageUUID = storage.ImageUUID
+				vol.imageSHA256 = strings.ToLower(storage.ImageSHA256)
+			}
+			volumes = append(volumes, vol)
 		} else {
 			/* See github issue #972:
 			   A storage.ID == "" implies an auto-created-by-launcher
@@ -186,9 +275,14 @@ func parseStartPayload(data []byte) (*vmConfig, *payloadError) {
 		VnicIP:      vnicIP,
 		ConcIP:      strings.TrimSpace(net.ConcentratorIP),
 		SubnetIP:    strings.TrimSpace(net.Subnet),
+		SubnetIPv6:  strings.TrimSpace(net.SubnetV6),
+		SubnetKey:   subnetKey,
+		Gateway:     strings.TrimSpace(net.Gateway),
+		NetworkUUID: strings.TrimSpace(net.NetworkUUID),
 		TenantUUID:  strings.TrimSpace(start.TenantUUID),
 		ConcUUID:    strings.TrimSpace(net.ConcentratorUUID),
 		VnicUUID:    strings.TrimSpace(net.VnicUUID),
+		ExtraVnics:  extraVnics,
 		SSHPort:     sshPort,
 		Volumes:     volumes,
 		Restart:     clouddata.Start.Restart,
@@ -230,6 +324,15 @@ func generateDetachVolumeError(instance, volume string, dve *detachVolumeError)
 	return yaml.Marshal(dvf)
 }
 
+func generateUpdateVolumeError(instance, volume string, uve *updateVolumeError) (out []byte, err error) {
+	uvf := &payloads.ErrorUpdateVolumeFailure{
+		InstanceUUID: instance,
+		VolumeUUID:   volume,
+		Reason:       uve.code,
+	}
+	return yaml.Marshal(uvf)
+}
+
 func generateNetEventPayload(ssntpEvent *libsnnet.SsntpEventInfo, agentUUID string) ([]byte, error) {
 	var event interface{}
 	var eventData *payloads.TenantAddedEvent
@@ -252,13 +355,55 @@ func generateNetEventPayload(ssntpEvent *libsnnet.SsntpEventInfo, agentUUID stri
 	eventData.AgentIP = ssntpEvent.CnIP
 	eventData.TenantUUID = ssntpEvent.TenantID
 	eventData.TenantSubnet = ssntpEvent.SubnetID
+	eventData.TenantSubnetV6 = ssntpEvent.SubnetV6
+	eventData.TenantGateway = ssntpEvent.Gateway
+	eventData.NetworkUUID = ssntpEvent.NetworkID
 	eventData.ConcentratorUUID = ssntpEvent.ConcID
 	eventData.ConcentratorIP = ssntpEvent.CnciIP
 	eventData.SubnetKey = ssntpEvent.SubnetKey
+	if ssntpEvent.Mode == libsnnet.Vxlan {
+		eventData.TunnelType = "vxlan"
+		eventData.VxlanGroup = ssntpEvent.VxlanGroup
+	}
 
 	return yaml.Marshal(event)
 }
 
+func parseTunnelPeersPayload(data []byte) (*libsnnet.VnicConfig, []net.IP, error) {
+	var ev payloads.EventTunnelPeersUpdated
+
+	err := yaml.Unmarshal(data, &ev)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tp := &ev.TunnelPeers
+
+	concIP := net.ParseIP(tp.ConcentratorIP)
+	if concIP == nil {
+		return nil, nil, fmt.Errorf("Invalid concentrator ip %s", tp.ConcentratorIP)
+	}
+
+	peers := make([]net.IP, 0, len(tp.Peers))
+	for _, p := range tp.Peers {
+		ip := net.ParseIP(p)
+		if ip == nil {
+			return nil, nil, fmt.Errorf("Invalid peer ip %s", p)
+		}
+		peers = append(peers, ip)
+	}
+
+	cfg := &libsnnet.VnicConfig{
+		ConcIP:    concIP,
+		SubnetKey: tp.SubnetKey,
+		TenantID:  tp.TenantUUID,
+		SubnetID:  tp.TenantSubnet,
+		ConcID:    tp.ConcentratorUUID,
+	}
+
+	return cfg, peers, nil
+}
+
 func parseDeletePayload(data []byte) (string, bool, *payloadError) {
 	var clouddata payloads.Delete
 
@@ -290,16 +435,66 @@ func extractVolumeInfo(cmd *payloads.VolumeCmd, errString string) (string, strin
 	return instance, volume, nil
 }
 
-func parseAttachVolumePayload(data []byte) (string, string, *payloadError) {
+func extractIOLimits(cmd *payloads.VolumeCmd, errString string) (payloads.IOLimits, *payloadError) {
+	if cmd.IOLimits == nil {
+		return payloads.IOLimits{}, nil
+	}
+
+	l := *cmd.IOLimits
+	if l.TotalBytesSec < 0 || l.ReadBytesSec < 0 || l.WriteBytesSec < 0 ||
+		l.TotalIOPSSec < 0 || l.ReadIOPSSec < 0 || l.WriteIOPSSec < 0 {
+		err := fmt.Errorf("Invalid I/O limits received: %+v", l)
+		return payloads.IOLimits{}, &payloadError{err, errString}
+	}
+
+	return l, nil
+}
+
+func extractEncryptionKey(key string, errString string) (string, *payloadError) {
+	key = strings.TrimSpace(key)
+	if key == "" {
+		return "", nil
+	}
+
+	if _, err := base64.StdEncoding.DecodeString(key); err != nil {
+		err = fmt.Errorf("Invalid encryption key received: %v", err)
+		return "", &payloadError{err, errString}
+	}
+
+	return key, nil
+}
+
+func parseAttachVolumePayload(data []byte) (string, volumeConfig, *payloadError) {
 	var clouddata payloads.AttachVolume
 
 	err := yaml.Unmarshal(data, &clouddata)
 	if err != nil {
 		glog.Errorf("YAML error: %v", err)
-		return "", "", &payloadError{err, payloads.AttachVolumeInvalidPayload}
+		return "", volumeConfig{}, &payloadError{err, payloads.AttachVolumeInvalidPayload}
+	}
+
+	instance, volume, payloadErr := extractVolumeInfo(&clouddata.Attach, payloads.AttachVolumeInvalidData)
+	if payloadErr != nil {
+		return "", volumeConfig{}, payloadErr
+	}
+
+	limits, payloadErr := extractIOLimits(&clouddata.Attach, payloads.AttachVolumeInvalidData)
+	if payloadErr != nil {
+		return "", volumeConfig{}, payloadErr
+	}
+
+	key, payloadErr := extractEncryptionKey(clouddata.Attach.EncryptionKey, payloads.AttachVolumeInvalidData)
+	if payloadErr != nil {
+		return "", volumeConfig{}, payloadErr
 	}
 
-	return extractVolumeInfo(&clouddata.Attach, payloads.AttachVolumeInvalidData)
+	return instance, volumeConfig{
+		UUID:          volume,
+		IOLimits:      limits,
+		ReadOnly:      clouddata.Attach.ReadOnly,
+		Encrypted:     key != "",
+		encryptionKey: key,
+	}, nil
 }
 
 func parseDetachVolumePayload(data []byte) (string, string, *payloadError) {
@@ -314,6 +509,44 @@ func parseDetachVolumePayload(data []byte) (string, string, *payloadError) {
 	return extractVolumeInfo(&clouddata.Detach, payloads.DetachVolumeInvalidData)
 }
 
+func parseUpdateVolumePayload(data []byte) (string, string, payloads.IOLimits, *payloadError) {
+	var clouddata payloads.UpdateVolume
+
+	err := yaml.Unmarshal(data, &clouddata)
+	if err != nil {
+		glog.Errorf("YAML error: %v", err)
+		return "", "", payloads.IOLimits{}, &payloadError{err, payloads.UpdateVolumeInvalidPayload}
+	}
+
+	instance, volume, payloadErr := extractVolumeInfo(&clouddata.Update, payloads.UpdateVolumeInvalidData)
+	if payloadErr != nil {
+		return "", "", payloads.IOLimits{}, payloadErr
+	}
+
+	limits, payloadErr := extractIOLimits(&clouddata.Update, payloads.UpdateVolumeInvalidData)
+	if payloadErr != nil {
+		return "", "", payloads.IOLimits{}, payloadErr
+	}
+
+	return instance, volume, limits, nil
+}
+
+func parsePrewarmImagePayload(data []byte) (string, error) {
+	var clouddata payloads.PrewarmImage
+
+	err := yaml.Unmarshal(data, &clouddata)
+	if err != nil {
+		glog.Errorf("YAML error: %v", err)
+		return "", err
+	}
+
+	if clouddata.Prewarm.DockerImage == "" {
+		return "", fmt.Errorf("Missing docker image")
+	}
+
+	return clouddata.Prewarm.DockerImage, nil
+}
+
 func linesToBytes(doc []string, buf *bytes.Buffer) {
 	for _, line := range doc {
 		_, _ = buf.WriteString(line)
@@ -479,3 +712,46 @@ func splitYaml(data []byte) ([]byte, []byte, []byte) {
 
 	return s.Bytes(), ci.Bytes(), md.Bytes()
 }
+
+func securityRules(cmd *payloads.SecurityRulesCommand) (string, []libsnnet.SecurityRule, error) {
+	if cmd.InstanceUUID == "" {
+		return "", nil, fmt.Errorf("Missing instance UUID")
+	}
+
+	rules := make([]libsnnet.SecurityRule, 0, len(cmd.Rules))
+	for _, r := range cmd.Rules {
+		rules = append(rules, libsnnet.SecurityRule{
+			Protocol:   r.Protocol,
+			PortMin:    r.PortMin,
+			PortMax:    r.PortMax,
+			RemoteCIDR: r.RemoteCIDR,
+		})
+	}
+
+	return cmd.InstanceUUID, rules, nil
+}
+
+func parseApplySecurityRulesPayload(data []byte) (string, []libsnnet.SecurityRule, error) {
+	var clouddata payloads.CommandApplySecurityRules
+
+	err := yaml.Unmarshal(data, &clouddata)
+	if err != nil {
+		glog.Errorf("YAML error: %v", err)
+		return "", nil, err
+	}
+
+	return securityRules(&clouddata.Apply)
+}
+
+func parseRemoveSecurityRulesPayload(data []byte) (string, error) {
+	var clouddata payloads.CommandRemoveSecurityRules
+
+	err := yaml.Unmarshal(data, &clouddata)
+	if err != nil {
+		glog.Errorf("YAML error: %v", err)
+		return "", err
+	}
+
+	instance, _, err := securityRules(&clouddata.Remove)
+	return instance, err
+}
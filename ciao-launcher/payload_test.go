@@ -69,18 +69,130 @@ start:
 			SSHPort:    35050,
 			Volumes: []volumeConfig{
 				{
-					"69e84267-ed01-4738-b15f-b47de06b62e7",
-					true,
+					UUID:     "69e84267-ed01-4738-b15f-b47de06b62e7",
+					Bootable: true,
 				},
 			},
 		},
 	},
+	{
+		`
+start:
+  requested_resources:
+     - type: vcpus
+       value: 2
+     - type: mem_mb
+       value: 370
+  instance_uuid: d7d86208-b46c-4465-9018-ee14087d415f
+  tenant_uuid: 67d86208-000-4465-9018-fe14087d415f
+  fw_type: legacy
+  vm_type: qemu
+  networking:
+    vnic_mac: 02:00:0a:0a:00:02
+    vnic_uuid: 67d86208-b46c-0000-9018-fe14087d415f
+    concentrator_ip: 192.168.42.21
+    concentrator_uuid: 67d86208-b46c-4465-0000-fe14087d415f
+    subnet: 10.10.0.0/24
+    subnet_key: "7"
+    private_ip: 10.10.0.2
+    gateway: 10.10.0.1
+    network_uuid: 1ad2ec2a-9d1e-4d8c-8c2c-6f0e0c4e6b1c
+  extra_networking:
+    - vnic_mac: 02:00:0a:14:00:03
+      vnic_uuid: 5b1e3b0e-7b47-4b5a-9d5c-1f0c0a3e8a11
+      concentrator_ip: 192.168.42.21
+      concentrator_uuid: 67d86208-b46c-4465-0000-fe14087d415f
+      subnet: 10.20.0.0/24
+      subnet_key: "9"
+      private_ip: 10.20.0.3
+      gateway: 10.20.0.254
+      network_uuid: 9c6d7f0a-3c0b-4d5e-8a7b-2f1e0d3c4b5a
+`,
+		&vmConfig{
+			Cpus:        2,
+			Mem:         370,
+			Instance:    "d7d86208-b46c-4465-9018-ee14087d415f",
+			Legacy:      true,
+			VnicMAC:     "02:00:0a:0a:00:02",
+			VnicIP:      "10.10.0.2",
+			ConcIP:      "192.168.42.21",
+			SubnetIP:    "10.10.0.0/24",
+			SubnetKey:   7,
+			Gateway:     "10.10.0.1",
+			NetworkUUID: "1ad2ec2a-9d1e-4d8c-8c2c-6f0e0c4e6b1c",
+			TenantUUID:  "67d86208-000-4465-9018-fe14087d415f",
+			ConcUUID:    "67d86208-b46c-4465-0000-fe14087d415f",
+			VnicUUID:    "67d86208-b46c-0000-9018-fe14087d415f",
+			ExtraVnics: []vnicConfig{
+				{
+					VnicMAC:     "02:00:0a:14:00:03",
+					VnicIP:      "10.20.0.3",
+					SubnetIP:    "10.20.0.0/24",
+					SubnetKey:   9,
+					Gateway:     "10.20.0.254",
+					NetworkUUID: "9c6d7f0a-3c0b-4d5e-8a7b-2f1e0d3c4b5a",
+					VnicUUID:    "5b1e3b0e-7b47-4b5a-9d5c-1f0c0a3e8a11",
+				},
+			},
+			SSHPort: 33002,
+		},
+	},
+	{
+		`
+start:
+  requested_resources:
+     - type: vcpus
+       value: 2
+     - type: mem_mb
+       value: 370
+  instance_uuid: d7d86208-b46c-4465-9018-ee14087d415f
+  tenant_uuid: 67d86208-000-4465-9018-fe14087d415f
+  vm_type: docker
+  docker_image: ubuntu
+  networking:
+    vnic_mac: 02:00:0a:0a:00:02
+    vnic_uuid: 67d86208-b46c-0000-9018-fe14087d415f
+    concentrator_ip: 192.168.42.21
+    concentrator_uuid: 67d86208-b46c-4465-0000-fe14087d415f
+    subnet: 10.10.0.0/24
+    private_ip: 10.10.0.2
+  extra_networking:
+    - vnic_mac: 02:00:0a:14:00:03
+      vnic_uuid: 5b1e3b0e-7b47-4b5a-9d5c-1f0c0a3e8a11
+      subnet: 10.20.0.0/24
+      private_ip: 10.20.0.3
+`,
+		nil,
+	},
 	{
 		"start",
 		nil,
 	},
 	{
 		`
+start:
+  requested_resources:
+     - type: vcpus
+       value: 2
+     - type: mem_mb
+       value: 370
+  instance_uuid: d7d86208-b46c-4465-9018-ee14087d415f
+  tenant_uuid: 67d86208-000-4465-9018-fe14087d415f
+  fw_type: legacy
+  vm_type: qemu
+  networking:
+    vnic_mac: 02:00:0a:0a:00:02
+    vnic_uuid: 67d86208-b46c-0000-9018-fe14087d415f
+    concentrator_ip: 192.168.42.21
+    concentrator_uuid: 67d86208-b46c-4465-0000-fe14087d415f
+    subnet: 10.10.0.0/24
+    subnet_key: "16777216"
+    private_ip: 10.10.0.2
+`,
+		nil,
+	},
+	{
+		`
 start:
   requested_resources:
      - type: vcpus
@@ -166,9 +278,28 @@ func TestParseAttachVolumePayload(t *testing.T) {
 	if err != nil {
 		t.Fatalf("parseAttachVolumePayload failed: %v", err)
 	}
-	if instance != testutil.InstanceUUID || volume != testutil.VolumeUUID {
+	if instance != testutil.InstanceUUID || volume.UUID != testutil.VolumeUUID {
 		t.Fatalf("VolumeUUID or InstanceUUID is invalid")
 	}
+	if volume.ReadOnly {
+		t.Fatalf("Volume should not be read-only")
+	}
+
+	_, volume, err = parseAttachVolumePayload([]byte(testutil.AttachVolumeReadOnlyYaml))
+	if err != nil {
+		t.Fatalf("parseAttachVolumePayload failed: %v", err)
+	}
+	if !volume.ReadOnly {
+		t.Fatalf("Volume should be read-only")
+	}
+
+	_, volume, err = parseAttachVolumePayload([]byte(testutil.AttachVolumeEncryptedYaml))
+	if err != nil {
+		t.Fatalf("parseAttachVolumePayload failed: %v", err)
+	}
+	if !volume.Encrypted || volume.encryptionKey != testutil.VolumeEncryptionKey {
+		t.Fatalf("Volume should be encrypted with the key in the payload")
+	}
 
 	_, _, err = parseAttachVolumePayload([]byte("  -"))
 	if err == nil || err.code != payloads.AttachVolumeInvalidPayload {
@@ -208,6 +339,64 @@ func TestParseDetachVolumePayload(t *testing.T) {
 	}
 }
 
+// Verify the parsePrewarmImagePayload function.
+//
+// The function is passed one valid payload, one corrupt payload and one
+// payload that does not name an image.
+//
+// The image should be returned for the valid payload and errors for the
+// other two.
+func TestParsePrewarmImagePayload(t *testing.T) {
+	image, err := parsePrewarmImagePayload([]byte(testutil.PrewarmImageYaml))
+	if err != nil {
+		t.Fatalf("parsePrewarmImagePayload failed: %v", err)
+	}
+	if image != testutil.DockerImage {
+		t.Fatalf("Unexpected image %s", image)
+	}
+
+	_, err = parsePrewarmImagePayload([]byte("  -"))
+	if err == nil {
+		t.Fatalf("Error expected for corrupt payload")
+	}
+
+	_, err = parsePrewarmImagePayload([]byte(testutil.EvacuateYaml))
+	if err == nil {
+		t.Fatalf("Error expected for payload without image")
+	}
+}
+
+// Verify the parseUpdateVolumePayload function.
+//
+// The function is passed one valid payload and two invalid payloads.
+//
+// No error should be returned for the valid payload and the returned instance
+// and volume UUIDs and I/O limits should match what is in the payload.  Errors
+// should be returned for the invalid payloads.
+func TestParseUpdateVolumePayload(t *testing.T) {
+	instance, volume, limits, err := parseUpdateVolumePayload([]byte(testutil.UpdateVolumeYaml))
+	if err != nil {
+		t.Fatalf("parseUpdateVolumePayload failed: %v", err)
+	}
+	if instance != testutil.InstanceUUID || volume != testutil.VolumeUUID {
+		t.Fatalf("VolumeUUID or InstanceUUID is invalid")
+	}
+	expected := payloads.IOLimits{TotalBytesSec: 10485760, TotalIOPSSec: 500}
+	if limits != expected {
+		t.Fatalf("Unexpected I/O limits %+v, expected %+v", limits, expected)
+	}
+
+	_, _, _, err = parseUpdateVolumePayload([]byte("  -"))
+	if err == nil || err.code != payloads.UpdateVolumeInvalidPayload {
+		t.Fatalf("UpdateVolumeInvalidPayload error expected")
+	}
+
+	_, _, _, err = parseUpdateVolumePayload([]byte(testutil.BadUpdateVolumeYaml))
+	if err == nil || err.code != payloads.UpdateVolumeInvalidData {
+		t.Fatalf("UpdateVolumeInvalidData error expected")
+	}
+}
+
 // Verify the parseStartPayload function.
 //
 // The function is passed one valid payload and a number of invalid payloads.
@@ -302,6 +491,77 @@ func TestGenerateNetEventPayload(t *testing.T) {
 	}
 }
 
+// Verify that the tunnel type of a VXLAN subnet is sent to the CNCI.
+//
+// A VXLAN payloads.EventTenantAdded event is generated with a multicast
+// group.
+//
+// The tunnel type and the group should be present in the payload.
+func TestGenerateNetEventPayloadVxlan(t *testing.T) {
+	ev := &libsnnet.SsntpEventInfo{
+		Event:      libsnnet.SsntpTunAdd,
+		CnciIP:     testutil.CNCIIP,
+		CnIP:       testutil.InstancePrivateIP,
+		TenantID:   testutil.TenantUUID,
+		ConcID:     testutil.CNCIUUID,
+		SubnetKey:  1,
+		Mode:       libsnnet.Vxlan,
+		VxlanGroup: "239.1.1.1",
+	}
+
+	pl, err := generateNetEventPayload(ev, testutil.AgentUUID)
+	if err != nil {
+		t.Fatalf("Failed to generate payload : %v", err)
+	}
+	var eventData payloads.EventTenantAdded
+	err = yaml.Unmarshal(pl, &eventData)
+	if err != nil {
+		t.Fatalf("Unable to unmarshall event : %v", err)
+	}
+
+	if eventData.TenantAdded.TunnelType != "vxlan" ||
+		eventData.TenantAdded.VxlanGroup != ev.VxlanGroup {
+		t.Errorf("Unexpected tunnel %s %s", eventData.TenantAdded.TunnelType,
+			eventData.TenantAdded.VxlanGroup)
+	}
+}
+
+// Check that parseTunnelPeersPayload works correctly.
+//
+// Parse a valid TunnelPeersUpdated payload and one with an invalid peer.
+//
+// The first payload should parse without any error and the tunnel
+// configuration and peers should be as expected.  The second payload
+// should fail to parse.
+func TestParseTunnelPeersPayload(t *testing.T) {
+	cfg, peers, err := parseTunnelPeersPayload([]byte(testutil.TunnelPeersYaml))
+	if err != nil {
+		t.Fatalf("Failed to parse payload : %v", err)
+	}
+
+	if cfg.TenantID != testutil.TenantUUID || cfg.SubnetID != testutil.TenantSubnet ||
+		cfg.ConcID != testutil.CNCIUUID || cfg.ConcIP.String() != testutil.CNCIIP {
+		t.Errorf("Unexpected tunnel configuration %v", cfg)
+	}
+
+	if len(peers) != 1 || peers[0].String() != testutil.AgentIP {
+		t.Errorf("Unexpected peers %v", peers)
+	}
+
+	var ev payloads.EventTunnelPeersUpdated
+	ev.TunnelPeers.ConcentratorIP = testutil.CNCIIP
+	ev.TunnelPeers.Peers = []string{"not an ip"}
+	pl, err := yaml.Marshal(&ev)
+	if err != nil {
+		t.Fatalf("Failed to marshal payload : %v", err)
+	}
+
+	_, _, err = parseTunnelPeersPayload(pl)
+	if err == nil {
+		t.Errorf("parseTunnelPeersPayload should have failed on invalid peer")
+	}
+}
+
 // Check that parseDeletePayload works correctly.
 //
 // Parse a valid delete payload.
@@ -321,3 +581,28 @@ func TestParseDeletePayload(t *testing.T) {
 		t.Errorf("Expected stop to be false")
 	}
 }
+
+func TestParseSecurityRulesPayload(t *testing.T) {
+	instance, rules, err := parseApplySecurityRulesPayload([]byte(testutil.ApplySecurityRulesYaml))
+	if err != nil {
+		t.Fatalf("Failed to parse payload : %v", err)
+	}
+
+	expected := []libsnnet.SecurityRule{
+		{Protocol: "tcp", PortMin: 22, PortMax: 22},
+		{Protocol: "icmp", RemoteCIDR: "192.168.1.0/24"},
+	}
+	if instance != testutil.InstanceUUID || !reflect.DeepEqual(rules, expected) {
+		t.Errorf("Unexpected security rules %s %v", instance, rules)
+	}
+
+	instance, err = parseRemoveSecurityRulesPayload([]byte(testutil.RemoveSecurityRulesYaml))
+	if err != nil || instance != testutil.InstanceUUID {
+		t.Errorf("Unexpected security rules removal %s %v", instance, err)
+	}
+
+	_, err = parseRemoveSecurityRulesPayload([]byte("remove_security_rules:\n  private_ip: 192.168.1.2\n"))
+	if err == nil {
+		t.Errorf("parseRemoveSecurityRulesPayload should have failed on missing instance")
+	}
+}
@@ -19,8 +19,11 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"path"
@@ -32,6 +35,8 @@ import (
 
 	"context"
 
+	storage "github.com/01org/ciao/ciao-storage"
+	"github.com/01org/ciao/payloads"
 	"github.com/01org/ciao/qemu"
 	"github.com/golang/glog"
 )
@@ -70,6 +75,7 @@ func init() {
 
 type qemuV struct {
 	cfg            *vmConfig
+	storageDriver  storage.BlockDriver
 	instanceDir    string
 	vcPort         int
 	pid            int
@@ -140,12 +146,57 @@ func createCloudInitISO(instanceDir, isoPath string, cfg *vmConfig, userData, me
 	return nil
 }
 
+// verifiedImages records the images whose checksum has already been
+// verified by this launcher, so that they are only read once.
+var verifiedImages = struct {
+	sync.Mutex
+	m map[string]string
+}{m: make(map[string]string)}
+
+// verifyBackingImages checks that the images the volumes of a new instance
+// were cloned from still match the checksum recorded when they were
+// uploaded to the image service.
+func verifyBackingImages(driver storage.BlockDriver, volumes []volumeConfig) error {
+	for _, v := range volumes {
+		if v.imageSHA256 == "" {
+			continue
+		}
+
+		verifiedImages.Lock()
+		verified := verifiedImages.m[v.imageUUID] == v.imageSHA256
+		verifiedImages.Unlock()
+		if verified {
+			continue
+		}
+
+		h := sha256.New()
+		err := driver.ExportBlockDeviceSnapshot(v.imageUUID, "ciao-image", h)
+		if err != nil {
+			return fmt.Errorf("Unable to read image %s: %v", v.imageUUID, err)
+		}
+
+		sum := hex.EncodeToString(h.Sum(nil))
+		if sum != v.imageSHA256 {
+			return fmt.Errorf("Checksum of image %s is %s, expected %s",
+				v.imageUUID, sum, v.imageSHA256)
+		}
+
+		glog.Infof("Image %s checksum verified", v.imageUUID)
+
+		verifiedImages.Lock()
+		verifiedImages.m[v.imageUUID] = sum
+		verifiedImages.Unlock()
+	}
+
+	return nil
+}
+
 func (q *qemuV) ensureBackingImage() error {
 	if !q.cfg.haveBootableVolume() {
 		return fmt.Errorf("No bootable volumes specified in START payload")
 	}
 
-	return nil
+	return verifyBackingImages(q.storageDriver, q.cfg.Volumes)
 }
 
 func (q *qemuV) createImage(bridge string, userData, metaData []byte) error {
@@ -243,6 +294,32 @@ func computeTapParam(vnicName string, mac string) ([]string, error) {
 	return params, nil
 }
 
+// computeTapParams returns the parameters of all the tap vnics of a VM.
+// The additional vnics follow the primary one, in the order in which the
+// controller attached them, so that they are enumerated in that order by
+// the guest.
+func computeTapParams(vnicNames []string, cfg *vmConfig) ([]string, error) {
+	if len(vnicNames) != len(cfg.ExtraVnics)+1 {
+		return nil, fmt.Errorf("Expected %d vnics, got %d",
+			len(cfg.ExtraVnics)+1, len(vnicNames))
+	}
+
+	params, err := computeTapParam(vnicNames[0], cfg.VnicMAC)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, extra := range cfg.ExtraVnics {
+		tapParam, err := computeTapParam(vnicNames[i+1], extra.VnicMAC)
+		if err != nil {
+			return nil, err
+		}
+		params = append(params, tapParam...)
+	}
+
+	return params, nil
+}
+
 func launchQemuWithNC(params []string, fds []*os.File, ipAddress string) (int, error) {
 	var err error
 
@@ -320,6 +397,82 @@ func launchQemuWithSpice(params []string, fds []*os.File, ipAddress string) (int
 	return port, err
 }
 
+func ioLimitsDriveOptions(l *payloads.IOLimits) string {
+	var buf bytes.Buffer
+
+	limits := []struct {
+		name  string
+		value int64
+	}{
+		{"bps", l.TotalBytesSec},
+		{"bps_rd", l.ReadBytesSec},
+		{"bps_wr", l.WriteBytesSec},
+		{"iops", l.TotalIOPSSec},
+		{"iops_rd", l.ReadIOPSSec},
+		{"iops_wr", l.WriteIOPSSec},
+	}
+
+	for _, limit := range limits {
+		if limit.value > 0 {
+			fmt.Fprintf(&buf, ",%s=%d", limit.name, limit.value)
+		}
+	}
+
+	return buf.String()
+}
+
+func qmpIOThrottle(l *payloads.IOLimits) qemu.BlockIOThrottle {
+	return qemu.BlockIOThrottle{
+		BPS:    l.TotalBytesSec,
+		BPSRd:  l.ReadBytesSec,
+		BPSWr:  l.WriteBytesSec,
+		IOPS:   l.TotalIOPSSec,
+		IOPSRd: l.ReadIOPSSec,
+		IOPSWr: l.WriteIOPSSec,
+	}
+}
+
+func volumeSecretPath(instanceDir, volumeUUID string) string {
+	return path.Join(instanceDir, fmt.Sprintf("secret_%s", volumeUUID))
+}
+
+// writeVolumeSecrets stores the keys of the instance's encrypted volumes
+// in files that qemu reads when it starts up.  The keys are passed in files
+// rather than on the command line so that they cannot be read from the
+// process list.  The files should be removed as soon as qemu has started.
+func writeVolumeSecrets(cfg *vmConfig, instanceDir string) ([]string, error) {
+	var secrets []string
+
+	for _, v := range cfg.Volumes {
+		if !v.Encrypted {
+			continue
+		}
+
+		if v.encryptionKey == "" {
+			removeVolumeSecrets(secrets)
+			return nil, fmt.Errorf("No encryption key for volume %s", v.UUID)
+		}
+
+		secretPath := volumeSecretPath(instanceDir, v.UUID)
+		err := ioutil.WriteFile(secretPath, []byte(v.encryptionKey), 0600)
+		if err != nil {
+			removeVolumeSecrets(secrets)
+			return nil, fmt.Errorf("Unable to write secret for volume %s: %v", v.UUID, err)
+		}
+		secrets = append(secrets, secretPath)
+	}
+
+	return secrets, nil
+}
+
+func removeVolumeSecrets(secrets []string) {
+	for _, s := range secrets {
+		if err := os.Remove(s); err != nil {
+			glog.Warningf("Unable to remove volume secret %s: %v", s, err)
+		}
+	}
+}
+
 func generateQEMULaunchParams(cfg *vmConfig, isoPath, instanceDir string,
 	networkParams []string, cephID string) []string {
 	params := make([]string, 0, 32)
@@ -337,8 +490,22 @@ func generateQEMULaunchParams(cfg *vmConfig, isoPath, instanceDir string,
 
 	for _, v := range cfg.Volumes {
 		blockdevID := fmt.Sprintf("drive_%s", v.UUID)
-		volDriveStr := fmt.Sprintf("file=rbd:rbd/%s:id=%s,if=none,id=%s,format=raw",
-			v.UUID, cephID, blockdevID)
+		var volDriveStr string
+		if v.Encrypted {
+			secretID := fmt.Sprintf("secret_%s", v.UUID)
+			params = append(params, "-object",
+				fmt.Sprintf("secret,id=%s,file=%s,format=base64", secretID,
+					volumeSecretPath(instanceDir, v.UUID)))
+			volDriveStr = fmt.Sprintf("file=rbd:rbd/%s:id=%s,if=none,id=%s,format=luks,key-secret=%s",
+				v.UUID, cephID, blockdevID, secretID)
+		} else {
+			volDriveStr = fmt.Sprintf("file=rbd:rbd/%s:id=%s,if=none,id=%s,format=raw",
+				v.UUID, cephID, blockdevID)
+		}
+		if v.ReadOnly {
+			volDriveStr += ",readonly=on"
+		}
+		volDriveStr += ioLimitsDriveOptions(&v.IOLimits)
 		params = append(params, "-drive", volDriveStr)
 		volDeviceStr :=
 			fmt.Sprintf("virtio-blk-pci,scsi=off,bus=pci.0,addr=0x%x,id=device_%s,drive=%s",
@@ -392,7 +559,7 @@ func generateQEMULaunchParams(cfg *vmConfig, isoPath, instanceDir string,
 	return params
 }
 
-func (q *qemuV) startVM(vnicName, ipAddress, cephID string) error {
+func (q *qemuV) startVM(vnicNames []string, ipAddress, cephID string) error {
 
 	var fds []*os.File
 
@@ -400,33 +567,37 @@ func (q *qemuV) startVM(vnicName, ipAddress, cephID string) error {
 
 	networkParams := make([]string, 0, 32)
 
-	if vnicName != "" {
+	if len(vnicNames) > 0 {
 		if q.cfg.NetworkNode {
 			var err error
 			var macvtapParam []string
 			//TODO: @mcastelino get from scheduler/controller
 			numQueues := 4
-			macvtapParam, fds, err = computeMacvtapParam(vnicName, q.cfg.VnicMAC, numQueues)
+			macvtapParam, fds, err = computeMacvtapParam(vnicNames[0], q.cfg.VnicMAC, numQueues)
 			if err != nil {
 				return err
 			}
 			defer cleanupFds(fds, len(fds))
 			networkParams = append(networkParams, macvtapParam...)
 		} else {
-			tapParam, err := computeTapParam(vnicName, q.cfg.VnicMAC)
+			tapParams, err := computeTapParams(vnicNames, q.cfg)
 			if err != nil {
 				return err
 			}
-			networkParams = append(networkParams, tapParam...)
+			networkParams = append(networkParams, tapParams...)
 		}
 	} else {
 		networkParams = append(networkParams, "-net", "nic,model=virtio")
 		networkParams = append(networkParams, "-net", "user")
 	}
 
-	params := generateQEMULaunchParams(q.cfg, q.isoPath, q.instanceDir, networkParams, cephID)
+	secrets, err := writeVolumeSecrets(q.cfg, q.instanceDir)
+	if err != nil {
+		return err
+	}
+	defer removeVolumeSecrets(secrets)
 
-	var err error
+	params := generateQEMULaunchParams(q.cfg, q.isoPath, q.instanceDir, networkParams, cephID)
 
 	if !launchWithUI.Enabled() {
 		params = append(params, "-display", "none", "-vga", "none")
@@ -467,20 +638,80 @@ func (q *qemuV) lostVM() {
 func qmpAttach(cmd virtualizerAttachCmd, q *qemu.QMP) {
 	glog.Info("Attach command received")
 	blockdevID := fmt.Sprintf("drive_%s", cmd.volumeUUID)
-	err := q.ExecuteBlockdevAdd(context.Background(), cmd.device, blockdevID)
+	secretID := fmt.Sprintf("secret_%s", cmd.volumeUUID)
+	var err error
+	if cmd.encryptionKey != "" {
+		err = q.ExecuteObjectAddSecret(context.Background(), secretID, cmd.encryptionKey)
+		if err != nil {
+			glog.Errorf("Failed to execute object-add: %v", err)
+			cmd.responseCh <- err
+			return
+		}
+		err = q.ExecuteBlockdevAddLUKS(context.Background(), cmd.device, blockdevID,
+			secretID, cmd.readOnly)
+	} else if cmd.readOnly {
+		err = q.ExecuteBlockdevAddReadOnly(context.Background(), cmd.device, blockdevID)
+	} else {
+		err = q.ExecuteBlockdevAdd(context.Background(), cmd.device, blockdevID)
+	}
 	if err != nil {
 		glog.Errorf("Failed to execute blockdev-add: %v", err)
+		if cmd.encryptionKey != "" {
+			_ = q.ExecuteObjectDel(context.Background(), secretID)
+		}
 	} else {
 		devID := fmt.Sprintf("device_%s", cmd.volumeUUID)
 		err = q.ExecuteDeviceAdd(context.Background(), blockdevID,
 			devID, "virtio-blk-pci", "")
 		if err != nil {
 			glog.Errorf("Failed to execute device_add: %v", err)
+		} else if !cmd.ioLimits.IsZero() {
+			err = q.ExecuteBlockSetIOThrottle(context.Background(),
+				blockdevID, qmpIOThrottle(&cmd.ioLimits))
+			if err != nil {
+				glog.Errorf("Failed to execute block_set_io_throttle: %v", err)
+				qmpUndoAttach(cmd, q)
+			}
 		}
 	}
 	cmd.responseCh <- err
 }
 
+// qmpUndoAttach removes a volume that was attached to the guest but could
+// not be throttled, so that a failed attach does not leave it usable
+// without its I/O limits.
+func qmpUndoAttach(cmd virtualizerAttachCmd, q *qemu.QMP) {
+	devID := fmt.Sprintf("device_%s", cmd.volumeUUID)
+	err := q.ExecuteDeviceDel(context.Background(), devID)
+	if err != nil {
+		glog.Errorf("Failed to remove unthrottled device %s: %v", devID, err)
+		return
+	}
+
+	blockdevID := fmt.Sprintf("drive_%s", cmd.volumeUUID)
+	err = q.ExecuteXBlockdevDel(context.Background(), blockdevID)
+	if err != nil {
+		glog.Errorf("Failed to remove unthrottled drive %s: %v", blockdevID, err)
+		return
+	}
+
+	if cmd.encryptionKey != "" {
+		secretID := fmt.Sprintf("secret_%s", cmd.volumeUUID)
+		_ = q.ExecuteObjectDel(context.Background(), secretID)
+	}
+}
+
+func qmpUpdate(cmd virtualizerUpdateCmd, q *qemu.QMP) {
+	glog.Info("Update command received")
+	blockdevID := fmt.Sprintf("drive_%s", cmd.volumeUUID)
+	err := q.ExecuteBlockSetIOThrottle(context.Background(), blockdevID,
+		qmpIOThrottle(&cmd.ioLimits))
+	if err != nil {
+		glog.Errorf("Failed to execute block_set_io_throttle: %v", err)
+	}
+	cmd.responseCh <- err
+}
+
 func qmpDetach(cmd virtualizerDetachCmd, q *qemu.QMP) {
 	glog.Info("Detach command received")
 	devID := fmt.Sprintf("device_%s", cmd.volumeUUID)
@@ -492,6 +723,12 @@ func qmpDetach(cmd virtualizerDetachCmd, q *qemu.QMP) {
 		err = q.ExecuteXBlockdevDel(context.Background(), blockdevID)
 		if err != nil {
 			glog.Errorf("Failed to execute x-blockdev-del: %v", err)
+		} else if cmd.encrypted {
+			secretID := fmt.Sprintf("secret_%s", cmd.volumeUUID)
+			err = q.ExecuteObjectDel(context.Background(), secretID)
+			if err != nil {
+				glog.Errorf("Failed to execute object-del: %v", err)
+			}
 		}
 	}
 	cmd.responseCh <- err
@@ -551,6 +788,8 @@ DONE:
 			qmpAttach(cmd, q)
 		case virtualizerDetachCmd:
 			qmpDetach(cmd, q)
+		case virtualizerUpdateCmd:
+			qmpUpdate(cmd, q)
 		}
 	}
 }
@@ -19,7 +19,10 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"net"
 	"os"
 	"path"
@@ -27,6 +30,10 @@ import (
 	"sync"
 	"testing"
 	"time"
+
+	storage "github.com/01org/ciao/ciao-storage"
+	"github.com/01org/ciao/payloads"
+	"github.com/01org/ciao/testutil"
 )
 
 var imageInfoTestGood = `
@@ -195,6 +202,130 @@ func TestGenerateQEMULaunchParams(t *testing.T) {
 	}
 }
 
+// Checks that the I/O limits of a volume are correctly added to its -drive
+// parameter.
+//
+// generateQEMULaunchParams is called with a volume that has a bandwidth and
+// an IOPS limit set.
+//
+// The -drive parameter of the volume should contain the bps and iops options
+// and no other throttling options.
+func TestGenerateQEMULaunchParamsIOLimits(t *testing.T) {
+	var cfg vmConfig
+
+	cfg.Legacy = true
+	cfg.Volumes = []volumeConfig{
+		{
+			UUID: testutil.VolumeUUID,
+			IOLimits: payloads.IOLimits{
+				TotalBytesSec: 10485760,
+				WriteIOPSSec:  100,
+			},
+		},
+	}
+
+	genParams := generateQEMULaunchParams(&cfg, "/var/lib/ciao/instance/1/seed.iso",
+		"/var/lib/ciao/instance/1", nil, "ciao")
+	expected := fmt.Sprintf("file=rbd:rbd/%s:id=ciao,if=none,id=drive_%s,format=raw,bps=10485760,iops_wr=100",
+		testutil.VolumeUUID, testutil.VolumeUUID)
+	if len(genParams) < 2 || genParams[0] != "-drive" || genParams[1] != expected {
+		t.Fatalf("Unexpected volume parameters %v, expected %s", genParams, expected)
+	}
+}
+
+// Checks that read-only volumes are passed to qemu as read-only drives.
+//
+// generateQEMULaunchParams is called with a single read-only volume.
+//
+// The -drive parameter of the volume should contain the readonly option.
+func TestGenerateQEMULaunchParamsReadOnly(t *testing.T) {
+	var cfg vmConfig
+
+	cfg.Legacy = true
+	cfg.Volumes = []volumeConfig{
+		{
+			UUID:     testutil.VolumeUUID,
+			ReadOnly: true,
+		},
+	}
+
+	genParams := generateQEMULaunchParams(&cfg, "/var/lib/ciao/instance/1/seed.iso",
+		"/var/lib/ciao/instance/1", nil, "ciao")
+	expected := fmt.Sprintf("file=rbd:rbd/%s:id=ciao,if=none,id=drive_%s,format=raw,readonly=on",
+		testutil.VolumeUUID, testutil.VolumeUUID)
+	if len(genParams) < 2 || genParams[0] != "-drive" || genParams[1] != expected {
+		t.Fatalf("Unexpected volume parameters %v, expected %s", genParams, expected)
+	}
+}
+
+// Checks that encrypted volumes are opened with qemu's LUKS driver.
+//
+// generateQEMULaunchParams is called with a single encrypted volume.
+//
+// A secret object that reads the key from a file in the instance directory
+// should be created and the -drive parameter of the volume should use the
+// luks format and refer to that secret.
+func TestGenerateQEMULaunchParamsEncrypted(t *testing.T) {
+	var cfg vmConfig
+
+	cfg.Legacy = true
+	cfg.Volumes = []volumeConfig{
+		{
+			UUID:      testutil.VolumeUUID,
+			Encrypted: true,
+		},
+	}
+
+	genParams := generateQEMULaunchParams(&cfg, "/var/lib/ciao/instance/1/seed.iso",
+		"/var/lib/ciao/instance/1", nil, "ciao")
+	expectedSecret := fmt.Sprintf("secret,id=secret_%s,file=/var/lib/ciao/instance/1/secret_%s,format=base64",
+		testutil.VolumeUUID, testutil.VolumeUUID)
+	expectedDrive := fmt.Sprintf("file=rbd:rbd/%s:id=ciao,if=none,id=drive_%s,format=luks,key-secret=secret_%s",
+		testutil.VolumeUUID, testutil.VolumeUUID, testutil.VolumeUUID)
+	if len(genParams) < 4 || genParams[0] != "-object" || genParams[1] != expectedSecret ||
+		genParams[2] != "-drive" || genParams[3] != expectedDrive {
+		t.Fatalf("Unexpected volume parameters %v, expected %s and %s", genParams,
+			expectedSecret, expectedDrive)
+	}
+}
+
+// Checks that a VM attached to several networks gets a tap netdev per vnic.
+//
+// computeTapParams is called with the vnics of a VM with one additional
+// vnic, and then with a missing vnic name.
+//
+// The parameters of the additional vnic should follow those of the primary
+// vnic and use its MAC address.  An error should be returned when the
+// number of vnic names does not match the configuration.
+func TestComputeTapParams(t *testing.T) {
+	cfg := vmConfig{
+		VnicMAC: "02:00:0a:0a:00:02",
+		ExtraVnics: []vnicConfig{
+			{VnicMAC: "02:00:0a:14:00:03"},
+		},
+	}
+
+	params, err := computeTapParams([]string{"vnic1", "vnic2"}, &cfg)
+	if err != nil {
+		t.Fatalf("Unable to compute tap params: %v", err)
+	}
+
+	expected := []string{
+		"-netdev", "type=tap,ifname=vnic1,script=no,downscript=no,id=vnic1,vhost=on",
+		"-device", "driver=virtio-net-pci,netdev=vnic1,mac=02:00:0a:0a:00:02",
+		"-netdev", "type=tap,ifname=vnic2,script=no,downscript=no,id=vnic2,vhost=on",
+		"-device", "driver=virtio-net-pci,netdev=vnic2,mac=02:00:0a:14:00:03",
+	}
+	if !reflect.DeepEqual(params, expected) {
+		t.Fatalf("%s and %s do not match", params, expected)
+	}
+
+	_, err = computeTapParams([]string{"vnic1"}, &cfg)
+	if err == nil {
+		t.Fatalf("Missing vnic not detected")
+	}
+}
+
 func TestQmpConnectBadSocket(t *testing.T) {
 	var wg sync.WaitGroup
 	qmpChannel := make(chan interface{})
@@ -315,3 +446,69 @@ func TestQmpLost(t *testing.T) {
 		return false
 	})
 }
+
+type imageTestStorage struct {
+	storage.NoopDriver
+	images  map[string]string
+	exports int
+}
+
+func (s *imageTestStorage) ExportBlockDeviceSnapshot(volumeUUID string, snapshotID string, w io.Writer) error {
+	s.exports++
+	data, ok := s.images[volumeUUID]
+	if !ok || snapshotID != "ciao-image" {
+		return fmt.Errorf("No snapshot %s@%s", volumeUUID, snapshotID)
+	}
+
+	_, err := io.WriteString(w, data)
+	return err
+}
+
+// Check that the images volumes are cloned from are verified.
+//
+// The first image matches its checksum and is only read once, the second
+// image has been altered and the third one cannot be read.  Volumes
+// without a checksum are not checked.
+//
+// verifyBackingImages should succeed for the first image and fail for the
+// others.
+func TestVerifyBackingImages(t *testing.T) {
+	good := "1c07a6e4-53d3-4ad1-b2c1-96d84aa0dd1d"
+	bad := "9e3bc38f-8ec6-4f43-8ec6-40cd5ccbb63b"
+	missing := "5c98bc92-a6d5-4cdd-9d1a-f2d5e29ac5b3"
+
+	driver := &imageTestStorage{
+		images: map[string]string{
+			good: "good image",
+			bad:  "altered image",
+		},
+	}
+
+	goodSum := sha256.Sum256([]byte("good image"))
+	badSum := sha256.Sum256([]byte("original image"))
+
+	volumes := []volumeConfig{
+		{UUID: "volume"},
+		{UUID: "volume", imageUUID: good, imageSHA256: hex.EncodeToString(goodSum[:])},
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := verifyBackingImages(driver, volumes); err != nil {
+			t.Fatalf("Unable to verify image: %v", err)
+		}
+	}
+
+	if driver.exports != 1 {
+		t.Errorf("Expected image to be read once, read %d times", driver.exports)
+	}
+
+	volumes = []volumeConfig{{UUID: "volume", imageUUID: bad, imageSHA256: hex.EncodeToString(badSum[:])}}
+	if err := verifyBackingImages(driver, volumes); err == nil {
+		t.Errorf("Altered image not detected")
+	}
+
+	volumes = []volumeConfig{{UUID: "volume", imageUUID: missing, imageSHA256: hex.EncodeToString(goodSum[:])}}
+	if err := verifyBackingImages(driver, volumes); err == nil {
+		t.Errorf("Missing image not detected")
+	}
+}
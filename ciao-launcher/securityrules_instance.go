@@ -0,0 +1,41 @@
+/*
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/01org/ciao/networking/libsnnet"
+)
+
+// processSecurityRules filters the traffic bridged to the vnic of an
+// instance.  The CNCI of the tenant enforces the same rules but never
+// sees the traffic between instances of a subnet running on this node.
+// The vnic and its filtering are deleted along with the instance so the
+// rules are not saved in the instance state.
+func processSecurityRules(cfg *vmConfig, action libsnnet.FwAction, rules []libsnnet.SecurityRule) error {
+	if !networking || cnNet == nil || cfg.NetworkNode {
+		return nil
+	}
+
+	vnicCfg, err := createVnicCfg(cfg)
+	if err != nil {
+		return fmt.Errorf("Could not create VnicCFG: %v", err)
+	}
+
+	return cnNet.InstanceSecurity(action, vnicCfg, rules)
+}
@@ -93,7 +93,7 @@ VM:
 
 }
 
-func (s *simulation) startVM(vnicName, ipAddress, cephID string) error {
+func (s *simulation) startVM(vnicNames []string, ipAddress, cephID string) error {
 	glog.Infof("startVM\n")
 
 	s.killCh = make(chan struct{})
@@ -20,6 +20,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/01org/ciao/networking/libsnnet"
 	"github.com/01org/ciao/payloads"
 	"github.com/01org/ciao/ssntp"
 	"github.com/golang/glog"
@@ -146,11 +147,58 @@ func (client *agentClient) CommandNotify(cmd ssntp.Command, frame *ssntp.Frame)
 			return
 		}
 		client.cmdCh <- &cmdWrapper{instance, &insDetachVolumeCmd{volume}}
+	case ssntp.UpdateVolume:
+		instance, volume, limits, payloadErr := parseUpdateVolumePayload(payload)
+		if payloadErr != nil {
+			updateVolumeError := &updateVolumeError{
+				payloadErr.err,
+				payloads.UpdateVolumeFailureReason(payloadErr.code),
+			}
+			updateVolumeError.send(client.conn, "", "")
+			glog.Errorf("Unable to parse YAML: %s", payloadErr.err)
+			return
+		}
+		client.cmdCh <- &cmdWrapper{instance, &insUpdateVolumeCmd{volume, limits}}
+	case ssntp.ApplySecurityRules:
+		instance, rules, err := parseApplySecurityRulesPayload(payload)
+		if err != nil {
+			glog.Errorf("Unable to parse YAML: %s", err)
+			return
+		}
+		client.cmdCh <- &cmdWrapper{instance, &insSecurityRulesCmd{libsnnet.FwEnable, rules}}
+	case ssntp.RemoveSecurityRules:
+		instance, err := parseRemoveSecurityRulesPayload(payload)
+		if err != nil {
+			glog.Errorf("Unable to parse YAML: %s", err)
+			return
+		}
+		client.cmdCh <- &cmdWrapper{instance, &insSecurityRulesCmd{libsnnet.FwDisable, nil}}
+	case ssntp.PrewarmImage:
+		if role := client.conn.Role(); !role.IsAgent() {
+			glog.Warning("Ignoring PrewarmImage command on network node")
+			return
+		}
+		image, err := parsePrewarmImagePayload(payload)
+		if err != nil {
+			glog.Errorf("Unable to parse YAML: %s", err)
+			return
+		}
+		go prewarmDockerImage(image)
 	}
 }
 
 func (client *agentClient) EventNotify(event ssntp.Event, frame *ssntp.Frame) {
 	glog.Infof("EVENT %s", event)
+
+	switch event {
+	case ssntp.TunnelPeersUpdated:
+		cfg, peers, err := parseTunnelPeersPayload(frame.Payload)
+		if err != nil {
+			glog.Errorf("Unable to parse YAML: %s", err)
+			return
+		}
+		go setTunnelPeers(cfg, peers)
+	}
 }
 
 func (client *agentClient) ErrorNotify(err ssntp.Error, frame *ssntp.Frame) {
@@ -65,9 +65,10 @@ func createInstance(vm virtualizer, instanceDir string, cfg *vmConfig, bridge st
 
 func processStart(cmd *insStartCmd, instanceDir string, vm virtualizer, conn serverConn) (*startTimes, *startError) {
 	var err error
-	var vnicName string
+	var vnicNames []string
 	var bridge string
 	var vnicCfg *libsnnet.VnicConfig
+	var extraVnicCfgs []*libsnnet.VnicConfig
 	var st startTimes
 
 	st.startStamp = time.Now()
@@ -99,13 +100,35 @@ func processStart(cmd *insStartCmd, instanceDir string, vm virtualizer, conn ser
 			glog.Errorf("Could not create VnicCFG: %s", err)
 			return nil, &startError{err, payloads.InvalidData, cmd.cfg.Restart}
 		}
+
+		extraVnicCfgs, err = createExtraVnicCfgs(cfg)
+		if err != nil {
+			glog.Errorf("Could not create VnicCFG: %s", err)
+			return nil, &startError{err, payloads.InvalidData, cmd.cfg.Restart}
+		}
 	}
 
 	if vnicCfg != nil {
+		var vnicName string
 		vnicName, bridge, err = createVnic(conn, vnicCfg)
 		if err != nil {
 			return nil, &startError{err, payloads.NetworkFailure, cmd.cfg.Restart}
 		}
+		vnicNames = append(vnicNames, vnicName)
+	}
+
+	for i, extraCfg := range extraVnicCfgs {
+		vnicName, _, err := createVnic(conn, extraCfg)
+		if err != nil {
+			// The instance directory does not exist yet so the
+			// vnics would not be deleted along with the instance.
+			_ = destroyVnic(conn, vnicCfg)
+			for _, created := range extraVnicCfgs[:i] {
+				_ = destroyVnic(conn, created)
+			}
+			return nil, &startError{err, payloads.NetworkFailure, cmd.cfg.Restart}
+		}
+		vnicNames = append(vnicNames, vnicName)
 	}
 
 	st.networkStamp = time.Now()
@@ -117,7 +140,7 @@ func processStart(cmd *insStartCmd, instanceDir string, vm virtualizer, conn ser
 
 	st.creationStamp = time.Now()
 
-	err = vm.startVM(vnicName, getNodeIPAddress(), cephID)
+	err = vm.startVM(vnicNames, getNodeIPAddress(), cephID)
 	if err != nil {
 		return nil, &startError{err, payloads.LaunchFailure, cmd.cfg.Restart}
 	}
@@ -0,0 +1,74 @@
+/*
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package main
+
+import (
+	"github.com/01org/ciao/payloads"
+	"github.com/golang/glog"
+)
+
+func processUpdateVolume(monitorCh chan interface{}, cfg *vmConfig, instance, instanceDir,
+	volumeUUID string, ioLimits payloads.IOLimits) *updateVolumeError {
+
+	if cfg.Container {
+		updateErr := &updateVolumeError{nil, payloads.UpdateVolumeNotSupported}
+		glog.Errorf("Cannot update a container volume [%s]", string(updateErr.code))
+		return updateErr
+	}
+
+	vol := cfg.findVolume(volumeUUID)
+	if vol == nil {
+		updateErr := &updateVolumeError{nil, payloads.UpdateVolumeNotAttached}
+		glog.Errorf("%s is not attached to instance %s [%s]",
+			volumeUUID, instance, string(updateErr.code))
+		return updateErr
+	}
+
+	// If the instance is not running the new limits will be picked up
+	// the next time it is launched.
+
+	if monitorCh != nil {
+		responseCh := make(chan error)
+
+		monitorCh <- virtualizerUpdateCmd{
+			responseCh: responseCh,
+			volumeUUID: volumeUUID,
+			ioLimits:   ioLimits,
+		}
+
+		err := <-responseCh
+		if err != nil {
+			glog.Errorf("Unable to update volume %s of instance %s: %v",
+				volumeUUID, instance, err)
+			return &updateVolumeError{err, payloads.UpdateVolumeUpdateFailure}
+		}
+	}
+
+	oldLimits := vol.IOLimits
+	vol.IOLimits = ioLimits
+
+	err := cfg.save(instanceDir)
+	if err != nil {
+		vol.IOLimits = oldLimits
+		updateErr := &updateVolumeError{err, payloads.UpdateVolumeStateFailure}
+		glog.Errorf("Unable to persist instance %s state [%s]: %v",
+			instance, string(updateErr.code), err)
+		return updateErr
+	}
+
+	return nil
+}
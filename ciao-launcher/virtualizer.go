@@ -19,17 +19,28 @@ package main
 import (
 	"errors"
 	"sync"
+
+	"github.com/01org/ciao/payloads"
 )
 
 type virtualizerStopCmd struct{}
 type virtualizerAttachCmd struct {
+	responseCh    chan error
+	volumeUUID    string
+	device        string
+	ioLimits      payloads.IOLimits
+	readOnly      bool
+	encryptionKey string
+}
+type virtualizerDetachCmd struct {
 	responseCh chan error
 	volumeUUID string
-	device     string
+	encrypted  bool
 }
-type virtualizerDetachCmd struct {
+type virtualizerUpdateCmd struct {
 	responseCh chan error
 	volumeUUID string
+	ioLimits   payloads.IOLimits
 }
 
 var errImageNotFound = errors.New("Image Not Found")
@@ -65,7 +76,9 @@ type virtualizer interface {
 	deleteImage() error
 
 	// Boots a VM.  This method is called by START
-	startVM(vnicName, ipAddress, cephID string) error
+	// vnicNames: names of the vnics of the instance, the first one being
+	// its primary vnic.  Empty when networking is disabled.
+	startVM(vnicNames []string, ipAddress, cephID string) error
 
 	//BUG(markus): Need to use context rather than the monitor channel to
 	//detect when we need to quit.
@@ -21,12 +21,40 @@ import (
 	"os"
 	"path"
 
+	"github.com/01org/ciao/payloads"
 	"github.com/golang/glog"
 )
 
 type volumeConfig struct {
-	UUID     string
-	Bootable bool
+	UUID      string
+	Bootable  bool
+	IOLimits  payloads.IOLimits
+	ReadOnly  bool
+	Encrypted bool
+
+	// encryptionKey is unexported so that it is never written to the
+	// instance state file.  The controller sends it again whenever the
+	// volume needs to be opened.
+	encryptionKey string
+
+	// imageUUID and imageSHA256 identify the image a new volume was
+	// cloned from.  They are only needed when the instance is first
+	// started and so are not saved either.
+	imageUUID   string
+	imageSHA256 string
+}
+
+// vnicConfig holds the networking configuration of an additional vnic
+// of an instance attached to several tenant networks.  The first vnic
+// is described by the Vnic fields of vmConfig.
+type vnicConfig struct {
+	VnicMAC     string
+	VnicIP      string
+	SubnetIP    string
+	SubnetKey   int
+	Gateway     string
+	NetworkUUID string
+	VnicUUID    string
 }
 
 type vmConfig struct {
@@ -42,9 +70,14 @@ type vmConfig struct {
 	VnicIP      string
 	ConcIP      string
 	SubnetIP    string
+	SubnetIPv6  string
+	SubnetKey   int
+	Gateway     string
+	NetworkUUID string
 	TenantUUID  string
 	ConcUUID    string
 	VnicUUID    string
+	ExtraVnics  []vnicConfig
 	SSHPort     int
 	Volumes     []volumeConfig
 	Restart     bool
@@ -574,6 +574,14 @@ func (sched *ssntpSchedulerServer) getCommandConcentratorUUID(command ssntp.Comm
 		var cmd payloads.CommandReleasePublicIP
 		err := yaml.Unmarshal(payload, &cmd)
 		return cmd.ReleaseIP.ConcentratorUUID, err
+	case ssntp.ApplySecurityRules:
+		var cmd payloads.CommandApplySecurityRules
+		err := yaml.Unmarshal(payload, &cmd)
+		return cmd.Apply.ConcentratorUUID, err
+	case ssntp.RemoveSecurityRules:
+		var cmd payloads.CommandRemoveSecurityRules
+		err := yaml.Unmarshal(payload, &cmd)
+		return cmd.Remove.ConcentratorUUID, err
 	}
 }
 
@@ -589,6 +597,18 @@ func (sched *ssntpSchedulerServer) getEventConcentratorUUID(event ssntp.Event, p
 		var ev payloads.EventTenantRemoved
 		err := yaml.Unmarshal(payload, &ev)
 		return ev.TenantRemoved.ConcentratorUUID, err
+	case ssntp.DNSRecordsUpdated:
+		var ev payloads.EventDNSRecordsUpdated
+		err := yaml.Unmarshal(payload, &ev)
+		return ev.DNSRecords.ConcentratorUUID, err
+	case ssntp.DHCPOptionsUpdated:
+		var ev payloads.EventDHCPOptionsUpdated
+		err := yaml.Unmarshal(payload, &ev)
+		return ev.DHCPOptions.ConcentratorUUID, err
+	case ssntp.PortForwardsUpdated:
+		var ev payloads.EventPortForwardsUpdated
+		err := yaml.Unmarshal(payload, &ev)
+		return ev.PortForwards.ConcentratorUUID, err
 	}
 }
 
@@ -609,6 +629,40 @@ func (sched *ssntpSchedulerServer) fwdCmdToCNCI(command ssntp.Command, payload [
 	return dest
 }
 
+func getSecurityRulesAgentUUID(command ssntp.Command, payload []byte) (string, error) {
+	switch command {
+	default:
+		return "", fmt.Errorf("unsupported ssntp.Command type \"%s\"", command)
+	case ssntp.ApplySecurityRules:
+		var cmd payloads.CommandApplySecurityRules
+		err := yaml.Unmarshal(payload, &cmd)
+		return cmd.Apply.WorkloadAgentUUID, err
+	case ssntp.RemoveSecurityRules:
+		var cmd payloads.CommandRemoveSecurityRules
+		err := yaml.Unmarshal(payload, &cmd)
+		return cmd.Remove.WorkloadAgentUUID, err
+	}
+}
+
+func (sched *ssntpSchedulerServer) fwdSecurityRules(command ssntp.Command, payload []byte) (dest ssntp.ForwardDestination) {
+	// the CNCI filters the traffic routed to the instance, the compute
+	// node running it filters the traffic bridged to it
+	dest = sched.fwdCmdToCNCI(command, payload)
+	if dest.Decision() == ssntp.Discard {
+		return
+	}
+
+	agentUUID, err := getSecurityRulesAgentUUID(command, payload)
+	if err != nil || agentUUID == "" {
+		return
+	}
+
+	glog.V(2).Infof("Forwarding %s command to %s\n", command.String(), agentUUID)
+	dest.AddRecipient(agentUUID)
+
+	return
+}
+
 func (sched *ssntpSchedulerServer) fwdEventToCNCI(event ssntp.Event, payload []byte) (dest ssntp.ForwardDestination) {
 	// since the scheduler is the primary ssntp server, it needs to
 	// unwrap CNCI directed event payloads and forward to the right CNCI
@@ -626,6 +680,31 @@ func (sched *ssntpSchedulerServer) fwdEventToCNCI(event ssntp.Event, payload []b
 	return dest
 }
 
+func (sched *ssntpSchedulerServer) fwdEventToComputeNode(event ssntp.Event, payload []byte) (dest ssntp.ForwardDestination) {
+	// CNCI generated events that target a given agent/launcher
+
+	var agentUUID string
+	var err error
+
+	switch event {
+	case ssntp.TunnelPeersUpdated:
+		var ev payloads.EventTunnelPeersUpdated
+		err = yaml.Unmarshal(payload, &ev)
+		agentUUID = ev.TunnelPeers.AgentUUID
+	}
+
+	if err != nil || agentUUID == "" {
+		glog.Errorf("Bad %s event yaml. Unable to forward to compute node.\n", event)
+		dest.SetDecision(ssntp.Discard)
+		return
+	}
+
+	glog.V(2).Infof("Forwarding %s event to %s\n", event.String(), agentUUID)
+	dest.AddRecipient(agentUUID)
+
+	return dest
+}
+
 func getWorkloadAgentUUID(sched *ssntpSchedulerServer, command ssntp.Command, payload []byte) (string, string, error) {
 	switch command {
 	default:
@@ -655,6 +734,14 @@ func getWorkloadAgentUUID(sched *ssntpSchedulerServer, command ssntp.Command, pa
 		var cmd payloads.DetachVolume
 		err := yaml.Unmarshal(payload, &cmd)
 		return cmd.Detach.InstanceUUID, cmd.Detach.WorkloadAgentUUID, err
+	case ssntp.UpdateVolume:
+		var cmd payloads.UpdateVolume
+		err := yaml.Unmarshal(payload, &cmd)
+		return cmd.Update.InstanceUUID, cmd.Update.WorkloadAgentUUID, err
+	case ssntp.PrewarmImage:
+		var cmd payloads.PrewarmImage
+		err := yaml.Unmarshal(payload, &cmd)
+		return "", cmd.Prewarm.WorkloadAgentUUID, err
 	}
 }
 
@@ -850,12 +937,20 @@ func (sched *ssntpSchedulerServer) CommandForward(controllerUUID string, command
 		fallthrough
 	case ssntp.DetachVolume:
 		fallthrough
+	case ssntp.UpdateVolume:
+		fallthrough
+	case ssntp.PrewarmImage:
+		fallthrough
 	case ssntp.EVACUATE:
 		dest, instanceUUID = sched.fwdCmdToComputeNode(command, payload)
 	case ssntp.AssignPublicIP:
 		fallthrough
 	case ssntp.ReleasePublicIP:
-		dest = sched.fwdCmdToCNCI(command, payload)
+		fallthrough
+	case ssntp.ApplySecurityRules:
+		fallthrough
+	case ssntp.RemoveSecurityRules:
+		dest = sched.fwdSecurityRules(command, payload)
 	default:
 		dest.SetDecision(ssntp.Discard)
 	}
@@ -881,7 +976,15 @@ func (sched *ssntpSchedulerServer) EventForward(uuid string, event ssntp.Event,
 	case ssntp.TenantAdded:
 		fallthrough
 	case ssntp.TenantRemoved:
+		fallthrough
+	case ssntp.DNSRecordsUpdated:
+		fallthrough
+	case ssntp.DHCPOptionsUpdated:
+		fallthrough
+	case ssntp.PortForwardsUpdated:
 		dest = sched.fwdEventToCNCI(event, payload)
+	case ssntp.TunnelPeersUpdated:
+		dest = sched.fwdEventToComputeNode(event, payload)
 	}
 
 	elapsed := time.Since(start)
@@ -1162,6 +1265,22 @@ func setSSNTPForwardRules(sched *ssntpSchedulerServer) {
 			Operand:      ssntp.TenantRemoved,
 			EventForward: sched,
 		},
+		{ // all TunnelPeersUpdated events are processed by the Event forwarder
+			Operand:      ssntp.TunnelPeersUpdated,
+			EventForward: sched,
+		},
+		{ // all DNSRecordsUpdated events are processed by the Event forwarder
+			Operand:      ssntp.DNSRecordsUpdated,
+			EventForward: sched,
+		},
+		{ // all DHCPOptionsUpdated events are processed by the Event forwarder
+			Operand:      ssntp.DHCPOptionsUpdated,
+			EventForward: sched,
+		},
+		{ // all PortForwardsUpdated events are processed by the Event forwarder
+			Operand:      ssntp.PortForwardsUpdated,
+			EventForward: sched,
+		},
 		{ // all AttachVolume command are processed by the Command forwarder
 			Operand:        ssntp.AttachVolume,
 			CommandForward: sched,
@@ -1170,6 +1289,14 @@ func setSSNTPForwardRules(sched *ssntpSchedulerServer) {
 			Operand:        ssntp.DetachVolume,
 			CommandForward: sched,
 		},
+		{ // all UpdateVolume command are processed by the Command forwarder
+			Operand:        ssntp.UpdateVolume,
+			CommandForward: sched,
+		},
+		{ // all PrewarmImage command are processed by the Command forwarder
+			Operand:        ssntp.PrewarmImage,
+			CommandForward: sched,
+		},
 		{ // all AttachVolumeFailure errors go to all Controllers
 			Operand: ssntp.AttachVolumeFailure,
 			Dest:    ssntp.Controller,
@@ -1178,6 +1305,10 @@ func setSSNTPForwardRules(sched *ssntpSchedulerServer) {
 			Operand: ssntp.DetachVolumeFailure,
 			Dest:    ssntp.Controller,
 		},
+		{ // all UpdateVolumeFailure errors go to all Controllers
+			Operand: ssntp.UpdateVolumeFailure,
+			Dest:    ssntp.Controller,
+		},
 		{ // all AssignPublicIP commands are processed by the Command forwarder
 			Operand:        ssntp.AssignPublicIP,
 			CommandForward: sched,
@@ -1186,6 +1317,14 @@ func setSSNTPForwardRules(sched *ssntpSchedulerServer) {
 			Operand:        ssntp.ReleasePublicIP,
 			CommandForward: sched,
 		},
+		{ // all ApplySecurityRules commands are processed by the Command forwarder
+			Operand:        ssntp.ApplySecurityRules,
+			CommandForward: sched,
+		},
+		{ // all RemoveSecurityRules commands are processed by the Command forwarder
+			Operand:        ssntp.RemoveSecurityRules,
+			CommandForward: sched,
+		},
 	}
 }
 
@@ -774,3 +774,44 @@ func TestGetWorkloadAgentUUID(t *testing.T) {
 		}
 	}
 }
+
+func TestFwdSecurityRules(t *testing.T) {
+	sched = configSchedulerServer()
+	if sched == nil {
+		t.Fatal("unable to configure test scheduler")
+	}
+
+	var cmd payloads.CommandApplySecurityRules
+	err := yaml.Unmarshal([]byte(testutil.ApplySecurityRulesYaml), &cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dest := sched.fwdSecurityRules(ssntp.ApplySecurityRules, []byte(testutil.ApplySecurityRulesYaml))
+	recipients := dest.Recipients()
+	if dest.Decision() != ssntp.Forward || len(recipients) != 2 ||
+		recipients[0] != testutil.CNCIUUID || recipients[1] != testutil.AgentUUID {
+		t.Errorf("security rules not sent to CNCI and node, got decision=0x%x, recipients=%v",
+			dest.Decision(), recipients)
+	}
+
+	// rules of an instance that is not running yet only go to the CNCI
+	cmd.Apply.WorkloadAgentUUID = ""
+	y, err := yaml.Marshal(&cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dest = sched.fwdSecurityRules(ssntp.ApplySecurityRules, y)
+	recipients = dest.Recipients()
+	if dest.Decision() != ssntp.Forward || len(recipients) != 1 || recipients[0] != testutil.CNCIUUID {
+		t.Errorf("security rules not sent to CNCI, got decision=0x%x, recipients=%v",
+			dest.Decision(), recipients)
+	}
+
+	dest = sched.fwdSecurityRules(ssntp.RemoveSecurityRules, []byte(testutil.RemoveSecurityRulesYaml))
+	if len(dest.Recipients()) != 2 {
+		t.Errorf("security rules removal not sent to CNCI and node, got recipients=%v",
+			dest.Recipients())
+	}
+}
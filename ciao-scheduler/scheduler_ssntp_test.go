@@ -511,6 +511,50 @@ func TestTenantRemoved(t *testing.T) {
 	}
 }
 
+func TestTunnelPeersUpdated(t *testing.T) {
+	agentCh := agent.AddEventChan(ssntp.TunnelPeersUpdated)
+
+	go cnciAgent.SendTunnelPeersUpdatedEvent()
+
+	_, err := agent.GetEventChanResult(agentCh, ssntp.TunnelPeersUpdated)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDNSRecordsUpdated(t *testing.T) {
+	cnciCh := cnciAgent.AddEventChan(ssntp.DNSRecordsUpdated)
+
+	go controller.SendDNSRecordsUpdatedEvent()
+
+	_, err := cnciAgent.GetEventChanResult(cnciCh, ssntp.DNSRecordsUpdated)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDHCPOptionsUpdated(t *testing.T) {
+	cnciCh := cnciAgent.AddEventChan(ssntp.DHCPOptionsUpdated)
+
+	go controller.SendDHCPOptionsUpdatedEvent()
+
+	_, err := cnciAgent.GetEventChanResult(cnciCh, ssntp.DHCPOptionsUpdated)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPortForwardsUpdated(t *testing.T) {
+	cnciCh := cnciAgent.AddEventChan(ssntp.PortForwardsUpdated)
+
+	go controller.SendPortForwardsUpdatedEvent()
+
+	_, err := cnciAgent.GetEventChanResult(cnciCh, ssntp.PortForwardsUpdated)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestPublicIPAssigned(t *testing.T) {
 	controllerCh := controller.AddEventChan(ssntp.PublicIPAssigned)
 
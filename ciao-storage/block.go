@@ -16,16 +16,22 @@ package storage
 
 import (
 	"errors"
+	"io"
 )
 
 var (
 	// ErrNoDevice is returned from a driver
 	ErrNoDevice = errors.New("Not able to create device")
+
+	// ErrNotSupported is returned by drivers that cannot carry out
+	// an operation
+	ErrNotSupported = errors.New("Operation not supported by driver")
 )
 
 // BlockDriver is the interface that all block drivers must implement.
 type BlockDriver interface {
 	CreateBlockDevice(volumeUUID string, image string, sizeGB int) (BlockDevice, error)
+	CreateEncryptedBlockDevice(volumeUUID string, sizeGB int, key string) (BlockDevice, error)
 	CreateBlockDeviceFromSnapshot(volumeUUID string, snapshotID string) (BlockDevice, error)
 	CreateBlockDeviceSnapshot(volumeUUID string, snapshotID string) error
 	DeleteBlockDevice(string) error
@@ -33,7 +39,10 @@ type BlockDriver interface {
 	MapVolumeToNode(volumeUUID string) (string, error)
 	UnmapVolumeFromNode(volumeUUID string) error
 	GetVolumeMapping() (map[string][]string, error)
+	ListBlockDevices() ([]string, error)
 	CopyBlockDevice(string) (BlockDevice, error)
+	ExportBlockDeviceSnapshot(volumeUUID string, snapshotID string, w io.Writer) error
+	ImportBlockDevice(volumeUUID string, r io.Reader) (BlockDevice, error)
 	GetBlockDeviceSize(volumeUUID string) (uint64, error)
 	IsValidSnapshotUUID(string) error
 }
@@ -19,6 +19,9 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
 	"os/exec"
 	"strconv"
 	"strings"
@@ -80,6 +83,47 @@ func (d CephDriver) CreateBlockDevice(volumeUUID string, imagePath string, size
 	return BlockDevice{ID: volumeUUID, Size: size}, nil
 }
 
+// CreateEncryptedBlockDevice will create an empty LUKS formatted rbd image
+// in the ceph cluster.  key is the base64 encoded secret used to unlock the
+// volume.  It is passed to qemu-img in a temporary file so that it does not
+// appear on the command line.
+func (d CephDriver) CreateEncryptedBlockDevice(volumeUUID string, size int, key string) (BlockDevice, error) {
+	if volumeUUID == "" {
+		volumeUUID = uuid.Generate().String()
+	} else {
+		_, err := uuid.Parse(volumeUUID)
+		if err != nil {
+			return BlockDevice{}, fmt.Errorf("invalid UUID supplied for volume ID")
+		}
+	}
+
+	f, err := ioutil.TempFile("", "ciao-volume-secret")
+	if err != nil {
+		return BlockDevice{}, fmt.Errorf("Unable to create secret file: %v", err)
+	}
+	defer func() { _ = os.Remove(f.Name()) }()
+
+	_, err = f.WriteString(key)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return BlockDevice{}, fmt.Errorf("Unable to write secret file: %v", err)
+	}
+
+	secret := fmt.Sprintf("secret,id=sec0,file=%s,format=base64", f.Name())
+	rbdStr := fmt.Sprintf("rbd:rbd/%s:id=%s", volumeUUID, d.ID)
+	cmd := exec.Command("qemu-img", "create", "-f", "luks", "--object", secret,
+		"-o", "key-secret=sec0", rbdStr, strconv.Itoa(size)+"G")
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return BlockDevice{}, fmt.Errorf("Error when running: %v: %v: %s", cmd.Args, err, out)
+	}
+
+	return BlockDevice{ID: volumeUUID, Size: size}, nil
+}
+
 // CreateBlockDeviceFromSnapshot will create a block device derived from the previously created snapshot.
 func (d CephDriver) CreateBlockDeviceFromSnapshot(volumeUUID string, snapshotID string) (BlockDevice, error) {
 	ID := uuid.Generate().String()
@@ -144,6 +188,53 @@ func (d CephDriver) CopyBlockDevice(volumeUUID string) (BlockDevice, error) {
 	return BlockDevice{ID: ID, Size: size}, nil
 }
 
+// ExportBlockDeviceSnapshot streams the contents of a snapshot of a rbd
+// image to w.
+func (d CephDriver) ExportBlockDeviceSnapshot(volumeUUID string, snapshotID string, w io.Writer) error {
+	var stderr bytes.Buffer
+
+	args := append(d.getCredentials(), "export", volumeUUID+"@"+snapshotID, "-")
+	cmd := exec.Command("rbd", args...)
+	cmd.Stdout = w
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	if err != nil {
+		return fmt.Errorf("Error when running: %v: %v: %s", cmd.Args, err, stderr.Bytes())
+	}
+	return nil
+}
+
+// ImportBlockDevice creates a new rbd image in the ceph cluster from the
+// raw image data read from r.
+func (d CephDriver) ImportBlockDevice(volumeUUID string, r io.Reader) (BlockDevice, error) {
+	if volumeUUID == "" {
+		volumeUUID = uuid.Generate().String()
+	} else {
+		_, err := uuid.Parse(volumeUUID)
+		if err != nil {
+			return BlockDevice{}, fmt.Errorf("invalid UUID supplied for volume ID")
+		}
+	}
+
+	args := append(d.getCredentials(), "--image-feature", "layering", "import", "-", volumeUUID)
+	cmd := exec.Command("rbd", args...)
+	cmd.Stdin = r
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return BlockDevice{}, fmt.Errorf("Error when running: %v: %v: %s", cmd.Args, err, out)
+	}
+
+	size, err := d.getBlockDeviceSizeGiB(volumeUUID)
+	if err != nil {
+		d.DeleteBlockDevice(volumeUUID)
+		return BlockDevice{}, fmt.Errorf("Error when querying block device size: %v", err)
+	}
+
+	return BlockDevice{ID: volumeUUID, Size: size}, nil
+}
+
 // DeleteBlockDevice will remove a rbd image from the ceph cluster.
 func (d CephDriver) DeleteBlockDevice(volumeUUID string) error {
 	cmd := exec.Command("rbd", "--id", d.ID, "rm", volumeUUID)
@@ -231,6 +322,24 @@ func (d CephDriver) UnmapVolumeFromNode(volumeUUID string) error {
 	return nil
 }
 
+// ListBlockDevices returns the names of all the rbd images in the pool.
+func (d CephDriver) ListBlockDevices() ([]string, error) {
+	args := append(d.getCredentials(), "ls", "--format", "json")
+	cmd := exec.Command("rbd", args...)
+	data, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("Error when running: %v: %v", cmd.Args, err)
+	}
+
+	var devices []string
+	err = json.Unmarshal(data, &devices)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse output from rbd ls: %v", err)
+	}
+
+	return devices, nil
+}
+
 // GetVolumeMapping returns a map of volumeUUID to mapped devices.
 func (d CephDriver) GetVolumeMapping() (map[string][]string, error) {
 	args := append(d.getCredentials(), "showmapped", "--format", "json")
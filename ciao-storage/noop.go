@@ -16,6 +16,8 @@ package storage
 
 import (
 	"fmt"
+	"io"
+	"io/ioutil"
 	"strings"
 	"sync/atomic"
 
@@ -32,6 +34,11 @@ func (d *NoopDriver) CreateBlockDevice(volumeUUID string, image string, size int
 	return BlockDevice{ID: uuid.Generate().String(), Size: size}, nil
 }
 
+// CreateEncryptedBlockDevice pretends to create an encrypted block device.
+func (d *NoopDriver) CreateEncryptedBlockDevice(volumeUUID string, size int, key string) (BlockDevice, error) {
+	return BlockDevice{ID: uuid.Generate().String(), Size: size}, nil
+}
+
 // CreateBlockDeviceFromSnapshot pretends to create a block device snapshot
 func (d *NoopDriver) CreateBlockDeviceFromSnapshot(volumeUUID string, snapshotID string) (BlockDevice, error) {
 	return BlockDevice{ID: uuid.Generate().String() + "@" + uuid.Generate().String()}, nil
@@ -47,6 +54,27 @@ func (d *NoopDriver) CopyBlockDevice(string) (BlockDevice, error) {
 	return BlockDevice{ID: uuid.Generate().String()}, nil
 }
 
+// ExportBlockDeviceSnapshot pretends to export a block device snapshot.
+// Nothing is written to w.
+func (d *NoopDriver) ExportBlockDeviceSnapshot(volumeUUID string, snapshotID string, w io.Writer) error {
+	return nil
+}
+
+// ImportBlockDevice pretends to create a block device from the contents of r.
+// The contents of r are consumed and discarded.
+func (d *NoopDriver) ImportBlockDevice(volumeUUID string, r io.Reader) (BlockDevice, error) {
+	if volumeUUID == "" {
+		volumeUUID = uuid.Generate().String()
+	}
+
+	_, err := io.Copy(ioutil.Discard, r)
+	if err != nil {
+		return BlockDevice{}, err
+	}
+
+	return BlockDevice{ID: volumeUUID}, nil
+}
+
 // DeleteBlockDevice pretends to delete a block device.
 func (d *NoopDriver) DeleteBlockDevice(string) error {
 	return nil
@@ -79,6 +107,12 @@ func (d *NoopDriver) GetVolumeMapping() (map[string][]string, error) {
 	return nil, nil
 }
 
+// ListBlockDevices returns ErrNotSupported as the noop driver does not keep
+// track of the devices it pretends to create.
+func (d *NoopDriver) ListBlockDevices() ([]string, error) {
+	return nil, ErrNotSupported
+}
+
 // IsValidSnapshotUUID checks for the Ciao standard snapshot uuid form of
 // {UUID}@{UUID}
 func (d *NoopDriver) IsValidSnapshotUUID(snapshotUUID string) error {
@@ -48,6 +48,7 @@ var mgmtNet string
 var enableNetwork bool
 var enableNATssh bool
 var agentUUID string
+var fwBackend string
 
 func init() {
 	flag.StringVar(&serverURL, "server", "", "URL of SSNTP server, Use auto for auto discovery")
@@ -58,6 +59,7 @@ func init() {
 	flag.BoolVar(&enableNetwork, "network", true, "Enable networking")
 	flag.BoolVar(&enableNATssh, "ssh", true, "Enable NAT and SSH")
 	flag.StringVar(&agentUUID, "uuid", "", "UUID the CNCI Agent should use. Autogenerated otherwise")
+	flag.StringVar(&fwBackend, "firewall", "auto", "Firewall backend auto|iptables|nftables")
 }
 
 const (
@@ -167,6 +169,16 @@ func createMandatoryDirs() error {
 	return nil
 }
 
+//sendTunnelPeers tells the CNs of a unicast VXLAN subnet about each other
+func sendTunnelPeers(client *ssntpConn, cmd *payloads.TenantAddedEvent) {
+	for _, evt := range tunnelPeersEvents(cmd) {
+		err := sendNetworkEvent(client, ssntp.TunnelPeersUpdated, evt)
+		if err != nil {
+			glog.Errorf("Unable to send event : %+v", err)
+		}
+	}
+}
+
 func processCommand(client *ssntpConn, cmd *cmdWrapper) {
 
 	switch netCmd := cmd.cmd.(type) {
@@ -179,7 +191,9 @@ func processCommand(client *ssntpConn, cmd *cmdWrapper) {
 			err := addRemoteSubnet(c)
 			if err != nil {
 				glog.Errorf("Error Processing: CiaoEventTenantAdded %+v", err)
+				return
 			}
+			sendTunnelPeers(client, c)
 		}(cmd)
 
 	case *payloads.EventTenantRemoved:
@@ -191,7 +205,9 @@ func processCommand(client *ssntpConn, cmd *cmdWrapper) {
 
 			if err != nil {
 				glog.Errorf("Error Processing: CiaoEventTenantRemoved %+v", err)
+				return
 			}
+			sendTunnelPeers(client, c)
 		}(cmd)
 
 	case *payloads.CommandAssignPublicIP:
@@ -230,6 +246,61 @@ func processCommand(client *ssntpConn, cmd *cmdWrapper) {
 			}
 		}(cmd)
 
+	case *payloads.CommandApplySecurityRules:
+
+		go func(cmd *cmdWrapper) {
+			c := &netCmd.Apply
+			glog.Infof("Processing: CiaoCommandApplySecurityRules %v", c)
+			err := applySecurityRules(c)
+			if err != nil {
+				glog.Errorf("Error Processing: CiaoCommandApplySecurityRules %+v", err)
+			}
+		}(cmd)
+
+	case *payloads.CommandRemoveSecurityRules:
+
+		go func(cmd *cmdWrapper) {
+			c := &netCmd.Remove
+			glog.Infof("Processing: CiaoCommandRemoveSecurityRules %v", c)
+			err := removeSecurityRules(c)
+			if err != nil {
+				glog.Errorf("Error Processing: CiaoCommandRemoveSecurityRules %+v", err)
+			}
+		}(cmd)
+
+	case *payloads.EventDNSRecordsUpdated:
+
+		go func(cmd *cmdWrapper) {
+			c := &netCmd.DNSRecords
+			glog.Infof("Processing: CiaoEventDNSRecordsUpdated %v", c)
+			err := updateDNSRecords(c)
+			if err != nil {
+				glog.Errorf("Error Processing: CiaoEventDNSRecordsUpdated %+v", err)
+			}
+		}(cmd)
+
+	case *payloads.EventDHCPOptionsUpdated:
+
+		go func(cmd *cmdWrapper) {
+			c := &netCmd.DHCPOptions
+			glog.Infof("Processing: CiaoEventDHCPOptionsUpdated %v", c)
+			err := updateDHCPOptions(c)
+			if err != nil {
+				glog.Errorf("Error Processing: CiaoEventDHCPOptionsUpdated %+v", err)
+			}
+		}(cmd)
+
+	case *payloads.EventPortForwardsUpdated:
+
+		go func(cmd *cmdWrapper) {
+			c := &netCmd.PortForwards
+			glog.Infof("Processing: CiaoEventPortForwardsUpdated %v", c)
+			err := updatePortForwards(c)
+			if err != nil {
+				glog.Errorf("Error Processing: CiaoEventPortForwardsUpdated %+v", err)
+			}
+		}(cmd)
+
 	case *statusConnected:
 		//Block and send this as it does not make sense to send other events
 		//or process commands when we have not yet registered
@@ -289,6 +360,46 @@ func (client *agentClient) CommandNotify(cmd ssntp.Command, frame *ssntp.Frame)
 			client.cmdCh <- &cmdWrapper{&releaseIP}
 		}(payload)
 
+	case ssntp.ApplySecurityRules:
+		glog.Infof("CMD: ssntp.ApplySecurityRules %v", len(payload))
+
+		go func(payload []byte) {
+			var applyRules payloads.CommandApplySecurityRules
+			err := yaml.Unmarshal(payload, &applyRules)
+			if err != nil {
+				glog.Warning("Error unmarshalling ApplySecurityRules")
+				return
+			}
+			glog.Infof("CMD: ssntp.ApplySecurityRules %v", applyRules)
+
+			err = dbProcessCommand(client.db, &applyRules)
+			if err != nil {
+				glog.Errorf("unable to save state %+v", err)
+			}
+
+			client.cmdCh <- &cmdWrapper{&applyRules}
+		}(payload)
+
+	case ssntp.RemoveSecurityRules:
+		glog.Infof("CMD: ssntp.RemoveSecurityRules %v", len(payload))
+
+		go func(payload []byte) {
+			var removeRules payloads.CommandRemoveSecurityRules
+			err := yaml.Unmarshal(payload, &removeRules)
+			if err != nil {
+				glog.Warning("Error unmarshalling RemoveSecurityRules")
+				return
+			}
+			glog.Infof("CMD: ssntp.RemoveSecurityRules %v", removeRules)
+
+			err = dbProcessCommand(client.db, &removeRules)
+			if err != nil {
+				glog.Errorf("unable to save state %+v", err)
+			}
+
+			client.cmdCh <- &cmdWrapper{&removeRules}
+		}(payload)
+
 	default:
 		glog.Infof("CMD: %s", cmd)
 	}
@@ -338,6 +449,66 @@ func (client *agentClient) EventNotify(event ssntp.Event, frame *ssntp.Frame) {
 			client.cmdCh <- &cmdWrapper{&tenantRemoved}
 		}(payload)
 
+	case ssntp.DNSRecordsUpdated:
+		glog.Infof("EVENT: ssntp.DNSRecordsUpdated %v", len(payload))
+
+		go func(payload []byte) {
+			var dnsRecords payloads.EventDNSRecordsUpdated
+			err := yaml.Unmarshal(payload, &dnsRecords)
+			if err != nil {
+				glog.Warning("Error unmarshalling DNSRecordsUpdated")
+				return
+			}
+			glog.Infof("EVENT: ssntp.DNSRecordsUpdated %v", dnsRecords)
+
+			err = dbProcessCommand(client.db, &dnsRecords)
+			if err != nil {
+				glog.Errorf("unable to save state %+v", err)
+			}
+
+			client.cmdCh <- &cmdWrapper{&dnsRecords}
+		}(payload)
+
+	case ssntp.DHCPOptionsUpdated:
+		glog.Infof("EVENT: ssntp.DHCPOptionsUpdated %v", len(payload))
+
+		go func(payload []byte) {
+			var dhcpOptions payloads.EventDHCPOptionsUpdated
+			err := yaml.Unmarshal(payload, &dhcpOptions)
+			if err != nil {
+				glog.Warning("Error unmarshalling DHCPOptionsUpdated")
+				return
+			}
+			glog.Infof("EVENT: ssntp.DHCPOptionsUpdated %v", dhcpOptions)
+
+			err = dbProcessCommand(client.db, &dhcpOptions)
+			if err != nil {
+				glog.Errorf("unable to save state %+v", err)
+			}
+
+			client.cmdCh <- &cmdWrapper{&dhcpOptions}
+		}(payload)
+
+	case ssntp.PortForwardsUpdated:
+		glog.Infof("EVENT: ssntp.PortForwardsUpdated %v", len(payload))
+
+		go func(payload []byte) {
+			var portForwards payloads.EventPortForwardsUpdated
+			err := yaml.Unmarshal(payload, &portForwards)
+			if err != nil {
+				glog.Warning("Error unmarshalling PortForwardsUpdated")
+				return
+			}
+			glog.Infof("EVENT: ssntp.PortForwardsUpdated %v", portForwards)
+
+			err = dbProcessCommand(client.db, &portForwards)
+			if err != nil {
+				glog.Errorf("unable to save state %+v", err)
+			}
+
+			client.cmdCh <- &cmdWrapper{&portForwards}
+		}(payload)
+
 	default:
 		glog.Infof("EVENT %s", event)
 	}
@@ -452,6 +623,25 @@ func rebuildNetworkState(db *cnciDatabase) error {
 	defer db.SubnetMap.Unlock()
 	db.PublicIPMap.Lock()
 	defer db.PublicIPMap.Unlock()
+	db.SecurityMap.Lock()
+	defer db.SecurityMap.Unlock()
+	db.DNSMap.Lock()
+	defer db.DNSMap.Unlock()
+	db.DHCPMap.Lock()
+	defer db.DHCPMap.Unlock()
+	db.PortForwardMap.Lock()
+	defer db.PortForwardMap.Unlock()
+
+	//The options are set before the subnets so that their dnsmasq
+	//starts with them
+	for key, options := range db.DHCPMap.m {
+		glog.Infof("Key: %v DHCPOptions: %v", key, options)
+		err := updateDHCPOptions(options)
+		if err != nil {
+			lastError = err
+			glog.Errorf("rebuildNetworkState: %v", err)
+		}
+	}
 
 	for key, subnet := range db.SubnetMap.m {
 		glog.Infof("Key: %v Subnet: %v", key, subnet)
@@ -471,6 +661,33 @@ func rebuildNetworkState(db *cnciDatabase) error {
 		}
 	}
 
+	for key, rules := range db.SecurityMap.m {
+		glog.Infof("Key: %v SecurityRules: %v", key, rules)
+		err := applySecurityRules(rules)
+		if err != nil {
+			lastError = err
+			glog.Errorf("rebuildNetworkState: %v", err)
+		}
+	}
+
+	for key, records := range db.DNSMap.m {
+		glog.Infof("Key: %v DNSRecords: %v", key, records)
+		err := updateDNSRecords(records)
+		if err != nil {
+			lastError = err
+			glog.Errorf("rebuildNetworkState: %v", err)
+		}
+	}
+
+	for key, forwards := range db.PortForwardMap.m {
+		glog.Infof("Key: %v PortForwards: %v", key, forwards)
+		err := updatePortForwards(forwards)
+		if err != nil {
+			lastError = err
+			glog.Errorf("rebuildNetworkState: %v", err)
+		}
+	}
+
 	return errors.Wrapf(lastError, "rebuild network state")
 }
 
@@ -30,11 +30,19 @@ type cnciDatabase struct {
 	database.DbProvider //Database used to persist the CNCI state
 	SubnetMap
 	PublicIPMap
+	SecurityMap
+	DNSMap
+	DHCPMap
+	PortForwardMap
 }
 
 const (
 	tableSubnetMap   = "SubnetMap"
 	tablePublicIPMap = "PublicIPMap"
+	tableSecurityMap = "SecurityMap"
+	tableDNSMap      = "DNSMap"
+	tableDHCPMap     = "DHCPMap"
+	tableForwardMap  = "PortForwardMap"
 )
 
 //dbCfg controls plugin data base attributes
@@ -114,11 +122,169 @@ func (d *PublicIPMap) Add(k string, v interface{}) error {
 	return nil
 }
 
+//SecurityMap maintains the security rules of the instances whose ingress
+//traffic is filtered by this CNCI
+type SecurityMap struct {
+	sync.Mutex
+	m map[string]*payloads.SecurityRulesCommand //index: Instance private IP
+}
+
+//NewTable creates a new map
+func (d *SecurityMap) NewTable() {
+	d.m = make(map[string]*payloads.SecurityRulesCommand)
+}
+
+//Name provides the name of the map
+func (d *SecurityMap) Name() string {
+	return tableSecurityMap
+}
+
+//NewElement allocates and returns a security rules value
+func (d *SecurityMap) NewElement() interface{} {
+	return &payloads.SecurityRulesCommand{}
+}
+
+//Add adds a value to the map with the specified key
+func (d *SecurityMap) Add(k string, v interface{}) error {
+	val, ok := v.(*payloads.SecurityRulesCommand)
+	if !ok {
+		return errors.Errorf("Invalid value type %t", v)
+	}
+	d.m[k] = val
+	return nil
+}
+
+//DNSMap maintains the names of the tenant instances served by this CNCI
+type DNSMap struct {
+	sync.Mutex
+	m map[string]*payloads.DNSRecordsEvent //index: Tenant UUID
+}
+
+//NewTable creates a new map
+func (d *DNSMap) NewTable() {
+	d.m = make(map[string]*payloads.DNSRecordsEvent)
+}
+
+//Name provides the name of the map
+func (d *DNSMap) Name() string {
+	return tableDNSMap
+}
+
+//NewElement allocates and returns a DNS records value
+func (d *DNSMap) NewElement() interface{} {
+	return &payloads.DNSRecordsEvent{}
+}
+
+//Add adds a value to the map with the specified key
+func (d *DNSMap) Add(k string, v interface{}) error {
+	val, ok := v.(*payloads.DNSRecordsEvent)
+	if !ok {
+		return errors.Errorf("Invalid value type %t", v)
+	}
+	d.m[k] = val
+	return nil
+}
+
+//DHCPMap maintains the DHCP options of the tenant subnets
+type DHCPMap struct {
+	sync.Mutex
+	m map[string]*payloads.DHCPOptionsEvent //index: Tenant Subnet
+}
+
+//NewTable creates a new map
+func (d *DHCPMap) NewTable() {
+	d.m = make(map[string]*payloads.DHCPOptionsEvent)
+}
+
+//Name provides the name of the map
+func (d *DHCPMap) Name() string {
+	return tableDHCPMap
+}
+
+//NewElement allocates and returns a DHCP options value
+func (d *DHCPMap) NewElement() interface{} {
+	return &payloads.DHCPOptionsEvent{}
+}
+
+//Add adds a value to the map with the specified key
+func (d *DHCPMap) Add(k string, v interface{}) error {
+	val, ok := v.(*payloads.DHCPOptionsEvent)
+	if !ok {
+		return errors.Errorf("Invalid value type %t", v)
+	}
+	d.m[k] = val
+	return nil
+}
+
+//PortForwardMap maintains the port forwards of the tenant public IPs
+//shared by several instances
+type PortForwardMap struct {
+	sync.Mutex
+	m map[string]*payloads.PortForwardsEvent //index: Tenant UUID
+}
+
+//NewTable creates a new map
+func (d *PortForwardMap) NewTable() {
+	d.m = make(map[string]*payloads.PortForwardsEvent)
+}
+
+//Name provides the name of the map
+func (d *PortForwardMap) Name() string {
+	return tableForwardMap
+}
+
+//NewElement allocates and returns a port forwards value
+func (d *PortForwardMap) NewElement() interface{} {
+	return &payloads.PortForwardsEvent{}
+}
+
+//Add adds a value to the map with the specified key
+func (d *PortForwardMap) Add(k string, v interface{}) error {
+	val, ok := v.(*payloads.PortForwardsEvent)
+	if !ok {
+		return errors.Errorf("Invalid value type %t", v)
+	}
+	d.m[k] = val
+	return nil
+}
+
+//mergeDNSRecords applies an update to the records of a tenant. The
+//stored records only hold the names currently published
+func mergeDNSRecords(cur *payloads.DNSRecordsEvent, c *payloads.DNSRecordsEvent) *payloads.DNSRecordsEvent {
+	records := make(map[string]payloads.DNSRecord)
+	if cur != nil {
+		for _, r := range cur.Added {
+			records[r.InstanceUUID] = r
+		}
+	}
+	for _, r := range c.Removed {
+		delete(records, r.InstanceUUID)
+	}
+	for _, r := range c.Added {
+		records[r.InstanceUUID] = r
+	}
+
+	merged := &payloads.DNSRecordsEvent{
+		ConcentratorUUID: c.ConcentratorUUID,
+		TenantUUID:       c.TenantUUID,
+		Domain:           c.Domain,
+		Added:            make([]payloads.DNSRecord, 0, len(records)),
+	}
+	for _, r := range records {
+		merged.Added = append(merged.Added, r)
+	}
+	return merged
+}
+
 func dbInit() (*cnciDatabase, error) {
 	db := &cnciDatabase{}
 	db.DbProvider = database.NewBoltDBProvider()
 	db.SubnetMap.m = make(map[string]*payloads.TenantAddedEvent)
 	db.PublicIPMap.m = make(map[string]*payloads.PublicIPCommand)
+	db.SecurityMap.m = make(map[string]*payloads.SecurityRulesCommand)
+	db.DNSMap.m = make(map[string]*payloads.DNSRecordsEvent)
+	db.DHCPMap.m = make(map[string]*payloads.DHCPOptionsEvent)
+	db.PortForwardMap.m = make(map[string]*payloads.PortForwardsEvent)
 
 	if err := db.DbInit(dbCfg.DataDir, dbCfg.DbFile); err != nil {
 		return nil, errors.Wrapf(err, "db init: %v, %v", dbCfg.DataDir, dbCfg.DbFile)
@@ -129,6 +295,18 @@ func dbInit() (*cnciDatabase, error) {
 	if err := db.DbTableRebuild(&db.PublicIPMap); err != nil {
 		return nil, errors.Wrapf(err, "publicIPMap")
 	}
+	if err := db.DbTableRebuild(&db.SecurityMap); err != nil {
+		return nil, errors.Wrapf(err, "securityMap")
+	}
+	if err := db.DbTableRebuild(&db.DNSMap); err != nil {
+		return nil, errors.Wrapf(err, "dnsMap")
+	}
+	if err := db.DbTableRebuild(&db.DHCPMap); err != nil {
+		return nil, errors.Wrapf(err, "dhcpMap")
+	}
+	if err := db.DbTableRebuild(&db.PortForwardMap); err != nil {
+		return nil, errors.Wrapf(err, "portForwardMap")
+	}
 	return db, nil
 }
 
@@ -199,6 +377,76 @@ func dbProcessCommand(db *cnciDatabase, cmd interface{}) error {
 			return errors.Wrapf(err, "delete Public IP from db: %v", c)
 		}
 
+	case *payloads.CommandApplySecurityRules:
+
+		c := &netCmd.Apply
+
+		db.SecurityMap.Lock()
+		defer db.SecurityMap.Unlock()
+
+		key := c.PrivateIP
+		db.SecurityMap.m[key] = c
+
+		if err := db.DbAdd(tableSecurityMap, key, db.SecurityMap.m[key]); err != nil {
+			return errors.Wrapf(err, "add security rules to db: %v", c)
+		}
+
+	case *payloads.CommandRemoveSecurityRules:
+
+		c := &netCmd.Remove
+
+		db.SecurityMap.Lock()
+		defer db.SecurityMap.Unlock()
+
+		key := c.PrivateIP
+		delete(db.SecurityMap.m, key)
+
+		if err := db.DbDelete(tableSecurityMap, key); err != nil {
+			return errors.Wrapf(err, "delete security rules from db: %v", c)
+		}
+
+	case *payloads.EventDNSRecordsUpdated:
+
+		c := &netCmd.DNSRecords
+
+		db.DNSMap.Lock()
+		defer db.DNSMap.Unlock()
+
+		key := c.TenantUUID
+		db.DNSMap.m[key] = mergeDNSRecords(db.DNSMap.m[key], c)
+
+		if err := db.DbAdd(tableDNSMap, key, db.DNSMap.m[key]); err != nil {
+			return errors.Wrapf(err, "add DNS records to db: %v", c)
+		}
+
+	case *payloads.EventDHCPOptionsUpdated:
+
+		c := &netCmd.DHCPOptions
+
+		db.DHCPMap.Lock()
+		defer db.DHCPMap.Unlock()
+
+		key := c.TenantSubnet
+		db.DHCPMap.m[key] = c
+
+		if err := db.DbAdd(tableDHCPMap, key, db.DHCPMap.m[key]); err != nil {
+			return errors.Wrapf(err, "add DHCP options to db: %v", c)
+		}
+
+	case *payloads.EventPortForwardsUpdated:
+
+		c := &netCmd.PortForwards
+
+		db.PortForwardMap.Lock()
+		defer db.PortForwardMap.Unlock()
+
+		key := c.TenantUUID
+		db.PortForwardMap.m[key] = c
+
+		if err := db.DbAdd(tableForwardMap, key, db.PortForwardMap.m[key]); err != nil {
+			return errors.Wrapf(err, "add port forwards to db: %v", c)
+		}
+
 	default:
 		return errors.Errorf("unknown command: %v", netCmd)
 
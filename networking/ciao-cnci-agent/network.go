@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"sync"
 	"time"
 
 	"gopkg.in/yaml.v2"
@@ -36,6 +37,29 @@ import (
 var gCnci *libsnnet.Cnci
 var gFw *libsnnet.Firewall
 
+//subnetNetworks maps the subnets routed by the CNCI to the tenant network
+//they belong to. Subnets allocated by the controller belong to the ""
+//network
+var subnetNetworks = struct {
+	sync.Mutex
+	m map[string]string
+}{m: make(map[string]string)}
+
+//tunnelPeers tracks the CNs of the unicast VXLAN subnets routed by the
+//CNCI. It maps a subnet to the IP address of each CN agent on which the
+//subnet is present
+var tunnelPeers = struct {
+	sync.Mutex
+	m map[string]map[string]string
+}{m: make(map[string]map[string]string)}
+
+//portForwards tracks the port forwards applied for each tenant so that
+//a new list of forwards can be applied as a difference to the current one
+var portForwards = struct {
+	sync.Mutex
+	m map[string]map[payloads.PortForward]bool
+}{m: make(map[string]map[payloads.PortForward]bool)}
+
 //TODO: Subscribe to netlink event to monitor physical interface changes
 //TODO: Why does go not allow chan interface{}
 func initNetwork(cancelCh <-chan os.Signal) error {
@@ -83,7 +107,11 @@ func initNetwork(cancelCh <-chan os.Signal) error {
 	gCnci = cnci
 
 	if enableNetwork {
-		fw, err := libsnnet.InitFirewall(gCnci.ComputeLink[0].Attrs().Name)
+		backend, err := libsnnet.ParseFwBackend(fwBackend)
+		if err != nil {
+			return errors.Wrapf(err, "network init failed")
+		}
+		fw, err := libsnnet.InitFirewallWithBackend(backend, gCnci.ComputeLink[0].Attrs().Name)
 		if err != nil {
 			glog.Errorf("Firewall initialize failed %v", err) //Explicit ignore
 		}
@@ -95,8 +123,6 @@ func initNetwork(cancelCh <-chan os.Signal) error {
 }
 
 func unmarshallSubnetParams(cmd *payloads.TenantAddedEvent) (*net.IPNet, int, net.IP, error) {
-	const maxKey = ^uint32(0)
-
 	_, snet, err := net.ParseCIDR(cmd.TenantSubnet)
 	if err != nil {
 		return nil, 0, nil, errors.Wrapf(err, "invalid Remote subnet")
@@ -104,21 +130,79 @@ func unmarshallSubnetParams(cmd *payloads.TenantAddedEvent) (*net.IPNet, int, ne
 
 	cIP := net.ParseIP(cmd.AgentIP)
 	if cIP == nil {
-		return nil, 0, nil, errors.Wrapf(err, "invalid CN IP %s", cmd.ConcentratorIP)
+		return nil, 0, nil, errors.Errorf("invalid CN IP %s", cmd.AgentIP)
 	}
 
-	//TODO
-	//When we go away from a 1:1 subnet to key map remove this check
-	//Today this ensures the sanity of the YAML and CN
-	key := int(binary.LittleEndian.Uint32(snet.IP))
+	//The controller allocates a cluster unique key to each tenant subnet
+	//which is used as the VNI of its VXLAN tunnels
 	subnetKey := cmd.SubnetKey
-	if key != subnetKey {
-		return nil, 0, nil, errors.Wrapf(err, "invalid subnet key %s %x", cmd.TenantSubnet, cmd.SubnetKey)
+	if subnetKey <= 0 || subnetKey > libsnnet.MaxSubnetKey {
+		return nil, 0, nil, errors.Errorf("invalid subnet key %s %x", cmd.TenantSubnet, cmd.SubnetKey)
 	}
 
 	return snet, subnetKey, cIP, nil
 }
 
+//unmarshallSubnetV6 returns the IPv6 subnet of a dual stack tenant
+//subnet, or nil if the tenant subnet is IPv4 only
+func unmarshallSubnetV6(cmd *payloads.TenantAddedEvent) (*net.IPNet, error) {
+	if cmd.TenantSubnetV6 == "" {
+		return nil, nil
+	}
+
+	_, snet, err := net.ParseCIDR(cmd.TenantSubnetV6)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid Remote IPv6 subnet")
+	}
+
+	return snet, nil
+}
+
+//unmarshallGateway returns the gateway of a tenant defined subnet, or nil
+//if the subnet uses the default gateway
+func unmarshallGateway(cmd *payloads.TenantAddedEvent, subnet *net.IPNet) (net.IP, error) {
+	if cmd.TenantGateway == "" {
+		return nil, nil
+	}
+
+	gw := net.ParseIP(cmd.TenantGateway)
+	if gw == nil || !subnet.Contains(gw) {
+		return nil, errors.Errorf("invalid gateway %s", cmd.TenantGateway)
+	}
+
+	return gw, nil
+}
+
+//isolateSubnet drops the traffic between a newly routed subnet and the
+//subnets of the other networks of the tenant
+func isolateSubnet(subnet net.IPNet, networkID string) error {
+	subnetNetworks.Lock()
+	defer subnetNetworks.Unlock()
+
+	if _, ok := subnetNetworks.m[subnet.String()]; ok {
+		return nil
+	}
+
+	for s, n := range subnetNetworks.m {
+		if n == networkID {
+			continue
+		}
+
+		_, other, err := net.ParseCIDR(s)
+		if err != nil {
+			return errors.Wrapf(err, "invalid subnet %s", s)
+		}
+
+		err = gFw.NetworkIsolation(libsnnet.FwEnable, subnet, *other)
+		if err != nil {
+			return errors.Wrapf(err, "isolate %s %s", subnet.String(), s)
+		}
+	}
+
+	subnetNetworks.m[subnet.String()] = networkID
+	return nil
+}
+
 func genIPsInSubnet(subnet net.IPNet) []net.IP {
 
 	var allIPs []net.IP
@@ -169,6 +253,91 @@ func natSSHSubnet(action libsnnet.FwAction, subnet net.IPNet, intIf string, extI
 	return nil
 }
 
+//unmarshallTunnel returns whether the CN reaches the subnet over VXLAN
+//and the multicast group of the tunnel, if any
+func unmarshallTunnel(cmd *payloads.TenantAddedEvent) (bool, net.IP, error) {
+	switch cmd.TunnelType {
+	case "":
+		return false, nil, nil
+	case "vxlan":
+	default:
+		return false, nil, errors.Errorf("invalid tunnel type %s", cmd.TunnelType)
+	}
+
+	if cmd.VxlanGroup == "" {
+		return true, nil, nil
+	}
+
+	group := net.ParseIP(cmd.VxlanGroup)
+	if group == nil || !group.IsMulticast() {
+		return false, nil, errors.Errorf("invalid vxlan group %s", cmd.VxlanGroup)
+	}
+
+	return true, group, nil
+}
+
+//isUnicastVxlan returns true if the CNs of the subnet need to be told
+//about each other
+func isUnicastVxlan(cmd *payloads.TenantAddedEvent) bool {
+	return cmd.TunnelType == "vxlan" && cmd.VxlanGroup == ""
+}
+
+func addTunnelPeer(cmd *payloads.TenantAddedEvent) {
+	tunnelPeers.Lock()
+	defer tunnelPeers.Unlock()
+
+	peers := tunnelPeers.m[cmd.TenantSubnet]
+	if peers == nil {
+		peers = make(map[string]string)
+		tunnelPeers.m[cmd.TenantSubnet] = peers
+	}
+	peers[cmd.AgentUUID] = cmd.AgentIP
+}
+
+func delTunnelPeer(cmd *payloads.TenantAddedEvent) {
+	tunnelPeers.Lock()
+	defer tunnelPeers.Unlock()
+
+	peers := tunnelPeers.m[cmd.TenantSubnet]
+	delete(peers, cmd.AgentUUID)
+	if len(peers) == 0 {
+		delete(tunnelPeers.m, cmd.TenantSubnet)
+	}
+}
+
+//tunnelPeersEvents generates the TunnelPeersUpdated events to send to
+//the CNs of a unicast VXLAN subnet once a CN has joined or left it
+func tunnelPeersEvents(cmd *payloads.TenantAddedEvent) []*payloads.TunnelPeersEvent {
+	if !isUnicastVxlan(cmd) {
+		return nil
+	}
+
+	tunnelPeers.Lock()
+	defer tunnelPeers.Unlock()
+
+	peers := tunnelPeers.m[cmd.TenantSubnet]
+
+	var ips []string
+	for _, ip := range peers {
+		ips = append(ips, ip)
+	}
+
+	var events []*payloads.TunnelPeersEvent
+	for agent := range peers {
+		events = append(events, &payloads.TunnelPeersEvent{
+			AgentUUID:        agent,
+			TenantUUID:       cmd.TenantUUID,
+			TenantSubnet:     cmd.TenantSubnet,
+			ConcentratorUUID: cmd.ConcentratorUUID,
+			ConcentratorIP:   cmd.ConcentratorIP,
+			SubnetKey:        cmd.SubnetKey,
+			Peers:            ips,
+		})
+	}
+
+	return events
+}
+
 func addRemoteSubnet(cmd *payloads.TenantAddedEvent) error {
 	rs, tk, rip, err := unmarshallSubnetParams(cmd)
 
@@ -176,16 +345,48 @@ func addRemoteSubnet(cmd *payloads.TenantAddedEvent) error {
 		return errors.Wrapf(err, "invalid params %s %x %s", rs, tk, rip)
 	}
 
+	rs6, err := unmarshallSubnetV6(cmd)
+	if err != nil {
+		return errors.Wrapf(err, "invalid params %s", cmd.TenantSubnetV6)
+	}
+
+	gw, err := unmarshallGateway(cmd, rs)
+	if err != nil {
+		return errors.Wrapf(err, "invalid params %s", cmd.TenantGateway)
+	}
+
+	vxlan, group, err := unmarshallTunnel(cmd)
+	if err != nil {
+		return errors.Wrapf(err, "invalid params %s %s", cmd.TunnelType, cmd.VxlanGroup)
+	}
+
 	if !enableNetwork {
 		return nil
 	}
-	bridge, err := gCnci.AddRemoteSubnet(*rs, tk, rip)
+
+	cfg := libsnnet.RemoteSubnetConfig{
+		SubnetV6:   rs6,
+		Gateway:    gw,
+		Vxlan:      vxlan,
+		VxlanGroup: group,
+	}
+
+	bridge, err := gCnci.AddRemoteSubnetWithConfig(*rs, cfg, tk, rip)
 	if err != nil {
 		return errors.Wrapf(err, "add remote subnet %s %x %s", rs, tk, rip)
 	}
 
 	glog.Infof("cnci.AddRemoteSubnet success %s %x %s", rs, tk, rip, err)
 
+	if isUnicastVxlan(cmd) {
+		addTunnelPeer(cmd)
+	}
+
+	err = isolateSubnet(*rs, cmd.NetworkUUID)
+	if err != nil {
+		return errors.Wrapf(err, "isolate subnet %s %s", rs, cmd.NetworkUUID)
+	}
+
 	if enableNATssh && bridge != "" {
 		err = natSSHSubnet(libsnnet.FwEnable, *rs, bridge, gCnci.ComputeLink[0].Attrs().Name)
 		if err != nil {
@@ -214,6 +415,10 @@ func delRemoteSubnet(cmd *payloads.TenantAddedEvent) error {
 	}
 	glog.Infof("cnci.DelRemoteSubnet success %s %x %s", rs, tk, rip, err)
 
+	if isUnicastVxlan(cmd) {
+		delTunnelPeer(cmd)
+	}
+
 	/* We do not delete the bridge till reset.
 	if enableNATssh {
 		err = natSshSubnet(libsnnet.FwDisable, *rs, bridge, gCnci.ComputeLink[0].Attrs().Name)
@@ -274,6 +479,16 @@ func publicIPUnassignedMarshal(cmd *payloads.PublicIPCommand) ([]byte, error) {
 	return yaml.Marshal(&publicIPUnassigned)
 }
 
+func tunnelPeersMarshal(evt *payloads.TunnelPeersEvent) ([]byte, error) {
+	var tunnelPeersUpdated payloads.EventTunnelPeersUpdated
+
+	tunnelPeersUpdated.TunnelPeers = *evt
+
+	glog.Infoln("tunnelPeersMarshal Event ", tunnelPeersUpdated)
+
+	return yaml.Marshal(&tunnelPeersUpdated)
+}
+
 func publicIPFailureMarshal(reason payloads.PublicIPFailureReason, cmd *payloads.PublicIPCommand) ([]byte, error) {
 	var failure payloads.ErrorPublicIPFailure
 
@@ -368,6 +583,13 @@ func generateNetEventPayload(eventType ssntp.Event, eventInfo interface{}, agent
 			return nil, errors.Errorf("invalid eventInfo [%T] %v", eventInfo, eventInfo)
 		}
 		return publicIPUnassignedMarshal(cmd)
+	case ssntp.TunnelPeersUpdated:
+		glog.Infof("generating tunnel peers Updated Event Payload %v", eventInfo)
+		evt, ok := eventInfo.(*payloads.TunnelPeersEvent)
+		if !ok {
+			return nil, errors.Errorf("invalid eventInfo [%T] %v", eventInfo, eventInfo)
+		}
+		return tunnelPeersMarshal(evt)
 	default:
 		return nil, errors.Errorf("unsupported ssntpEventInfo type: %v", eventType)
 	}
@@ -411,3 +633,267 @@ func releasePubIP(cmd *payloads.PublicIPCommand) error {
 	err = gFw.PublicIPAccess(libsnnet.FwDisable, prIP, puIP, gCnci.ComputeLink[0].Attrs().Name)
 	return errors.Wrapf(err, "release ip")
 }
+
+func unmarshallSecurityRules(cmd *payloads.SecurityRulesCommand) ([]net.IP, []libsnnet.SecurityRule, error) {
+	ip := net.ParseIP(cmd.PrivateIP)
+	if ip == nil {
+		return nil, nil, errors.Errorf("invalid private IP %v", cmd.PrivateIP)
+	}
+	ips := []net.IP{ip}
+
+	if cmd.PrivateIPv6 != "" {
+		ip6 := net.ParseIP(cmd.PrivateIPv6)
+		if ip6 == nil || ip6.To4() != nil {
+			return nil, nil, errors.Errorf("invalid private IPv6 %v", cmd.PrivateIPv6)
+		}
+		ips = append(ips, ip6)
+	}
+
+	rules := make([]libsnnet.SecurityRule, 0, len(cmd.Rules))
+	for _, r := range cmd.Rules {
+		rules = append(rules, libsnnet.SecurityRule{
+			Protocol:   r.Protocol,
+			PortMin:    r.PortMin,
+			PortMax:    r.PortMax,
+			RemoteCIDR: r.RemoteCIDR,
+		})
+	}
+
+	return ips, rules, nil
+}
+
+func applySecurityRules(cmd *payloads.SecurityRulesCommand) error {
+	ips, rules, err := unmarshallSecurityRules(cmd)
+	if err != nil {
+		return errors.Wrapf(err, "invalid params %v", cmd)
+	}
+
+	if !enableNetwork {
+		return nil
+	}
+
+	for _, ip := range ips {
+		err = gFw.InstanceSecurity(libsnnet.FwEnable, ip, rules)
+		if err != nil {
+			return errors.Wrapf(err, "apply security rules")
+		}
+	}
+	return nil
+}
+
+func removeSecurityRules(cmd *payloads.SecurityRulesCommand) error {
+	ips, _, err := unmarshallSecurityRules(cmd)
+	if err != nil {
+		return errors.Wrapf(err, "invalid params %v", cmd)
+	}
+
+	if !enableNetwork {
+		return nil
+	}
+
+	for _, ip := range ips {
+		err = gFw.InstanceSecurity(libsnnet.FwDisable, ip, nil)
+		if err != nil {
+			return errors.Wrapf(err, "remove security rules")
+		}
+	}
+	return nil
+}
+
+func updateDNSRecords(cmd *payloads.DNSRecordsEvent) error {
+	added := make(map[string][]net.IP, len(cmd.Added))
+	hosts := make(map[string]net.HardwareAddr)
+	for _, r := range cmd.Added {
+		macs := append([]string{r.MACAddress}, r.ExtraMACAddresses...)
+		for i, addr := range append([]string{r.PrivateIP}, r.ExtraPrivateIPs...) {
+			ip := net.ParseIP(addr)
+			if ip == nil {
+				return errors.Errorf("invalid private IP %v for %v", addr, r.Name)
+			}
+			added[r.Name] = append(added[r.Name], ip)
+
+			//Records from older controllers carry no MAC address,
+			//only IPv4 addresses are handed out by DHCP
+			if i >= len(macs) || macs[i] == "" || ip.To4() == nil {
+				continue
+			}
+			mac, err := net.ParseMAC(macs[i])
+			if err != nil {
+				return errors.Wrapf(err, "invalid MAC address %v for %v", macs[i], r.Name)
+			}
+			hosts[ip.String()] = mac
+		}
+	}
+
+	removed := make([]string, 0, len(cmd.Removed))
+	var removedHosts []string
+	for _, r := range cmd.Removed {
+		removed = append(removed, r.Name)
+		for _, addr := range append([]string{r.PrivateIP}, r.ExtraPrivateIPs...) {
+			if ip := net.ParseIP(addr); ip != nil {
+				removedHosts = append(removedHosts, ip.String())
+			}
+		}
+	}
+
+	if !enableNetwork {
+		return nil
+	}
+
+	if err := gCnci.UpdateDhcpHosts(hosts, removedHosts); err != nil {
+		return errors.Wrapf(err, "update DHCP hosts")
+	}
+
+	err := gCnci.UpdateDNSRecords(cmd.Domain, added, removed)
+	return errors.Wrapf(err, "update DNS records")
+}
+
+func updateDHCPOptions(cmd *payloads.DHCPOptionsEvent) error {
+	_, subnet, err := net.ParseCIDR(cmd.TenantSubnet)
+	if err != nil {
+		return errors.Wrapf(err, "invalid subnet %v", cmd.TenantSubnet)
+	}
+
+	parseIPs := func(addrs []string) ([]net.IP, error) {
+		var ips []net.IP
+		for _, a := range addrs {
+			ip := net.ParseIP(a)
+			if ip == nil {
+				return nil, errors.Errorf("invalid address %v", a)
+			}
+			ips = append(ips, ip)
+		}
+		return ips, nil
+	}
+
+	options := libsnnet.DhcpOptions{
+		SearchDomains: cmd.SearchDomains,
+	}
+
+	if options.DNSServers, err = parseIPs(cmd.DNSServers); err != nil {
+		return errors.Wrapf(err, "invalid params %v", cmd)
+	}
+	if options.NTPServers, err = parseIPs(cmd.NTPServers); err != nil {
+		return errors.Wrapf(err, "invalid params %v", cmd)
+	}
+
+	for _, r := range cmd.Routes {
+		_, dest, err := net.ParseCIDR(r.Destination)
+		if err != nil {
+			return errors.Wrapf(err, "invalid route %v", r)
+		}
+		gw := net.ParseIP(r.NextHop)
+		if gw == nil {
+			return errors.Errorf("invalid route %v", r)
+		}
+		options.Routes = append(options.Routes, libsnnet.DhcpRoute{
+			Destination: *dest,
+			Gateway:     gw,
+		})
+	}
+
+	if !enableNetwork {
+		return nil
+	}
+
+	err = gCnci.UpdateDhcpOptions(*subnet, options)
+	return errors.Wrapf(err, "update DHCP options")
+}
+
+func publicIPsForwarded(forwards map[payloads.PortForward]bool) map[string]bool {
+	ips := make(map[string]bool)
+	for f := range forwards {
+		ips[f.PublicIP] = true
+	}
+	return ips
+}
+
+func portForward(action libsnnet.FwAction, f payloads.PortForward) error {
+	puIP := net.ParseIP(f.PublicIP)
+	prIP := net.ParseIP(f.PrivateIP)
+	if puIP == nil || prIP == nil {
+		return errors.Errorf("invalid port forward %v", f)
+	}
+
+	err := gFw.PortForward(action, f.Protocol, puIP, f.PublicPort, prIP, f.PrivatePort)
+	return errors.Wrapf(err, "port forward %v", f)
+}
+
+func publicIPAssign(action libsnnet.FwAction, ip string) error {
+	puIP := net.ParseIP(ip)
+	if puIP == nil {
+		return errors.Errorf("invalid public IP %v", ip)
+	}
+
+	err := gFw.PublicIPAssign(action, puIP, gCnci.ComputeLink[0].Attrs().Name)
+	return errors.Wrapf(err, "public IP %v", ip)
+}
+
+//updatePortForwards replaces the port forwards of a tenant with the ones
+//listed in the event. The public IPs that no longer carry any forward are
+//released from the external interface
+func updatePortForwards(cmd *payloads.PortForwardsEvent) error {
+	wanted := make(map[payloads.PortForward]bool, len(cmd.Forwards))
+	for _, f := range cmd.Forwards {
+		if net.ParseIP(f.PublicIP) == nil || net.ParseIP(f.PrivateIP) == nil {
+			return errors.Errorf("invalid port forward %v", f)
+		}
+		wanted[f] = true
+	}
+
+	if !enableNetwork {
+		return nil
+	}
+
+	portForwards.Lock()
+	defer portForwards.Unlock()
+
+	applied := portForwards.m[cmd.TenantUUID]
+	if applied == nil {
+		applied = make(map[payloads.PortForward]bool)
+		portForwards.m[cmd.TenantUUID] = applied
+	}
+
+	for f := range applied {
+		if wanted[f] {
+			continue
+		}
+		if err := portForward(libsnnet.FwDisable, f); err != nil {
+			return err
+		}
+		delete(applied, f)
+	}
+
+	assigned := publicIPsForwarded(applied)
+	needed := publicIPsForwarded(wanted)
+
+	for ip := range assigned {
+		if needed[ip] {
+			continue
+		}
+		if err := publicIPAssign(libsnnet.FwDisable, ip); err != nil {
+			return err
+		}
+	}
+
+	for ip := range needed {
+		if assigned[ip] {
+			continue
+		}
+		if err := publicIPAssign(libsnnet.FwEnable, ip); err != nil {
+			return err
+		}
+	}
+
+	for f := range wanted {
+		if applied[f] {
+			continue
+		}
+		if err := portForward(libsnnet.FwEnable, f); err != nil {
+			return err
+		}
+		applied[f] = true
+	}
+
+	return nil
+}
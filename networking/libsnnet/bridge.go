@@ -18,6 +18,7 @@ package libsnnet
 
 import (
 	"net"
+	"syscall"
 
 	"github.com/vishvananda/netlink"
 )
@@ -146,6 +147,12 @@ func (b *Bridge) AddIP(ip *net.IPNet) error {
 
 	addr := &netlink.Addr{IPNet: ip}
 
+	//dnsmasq binds to the address right away, it cannot wait for
+	//duplicate address detection to complete
+	if ip.IP.To4() == nil {
+		addr.Flags = syscall.IFA_F_NODAD
+	}
+
 	if err := netlink.AddrAdd(b.Link, addr); err != nil {
 		return netError(b, "assigning IP address to bridge %v %v", addr.String(), err)
 	}
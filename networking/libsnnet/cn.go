@@ -23,7 +23,6 @@ import (
 	"sync"
 	"time"
 
-	"github.com/coreos/go-iptables/iptables"
 	"github.com/vishvananda/netlink"
 )
 
@@ -38,6 +37,7 @@ type NetworkConfig struct {
 	ManagementNet []net.IPNet // Enumerates all possible management subnets
 	ComputeNet    []net.IPNet // Enumerates all possible compute subnets
 	Mode          NetworkMode //The data center networking mode
+	VxlanGroup    net.IP      //Vxlan mode multicast group, optional
 }
 
 // CnAPICtx contains API level context used to control the behaviour
@@ -59,13 +59,16 @@ type VnicConfig struct {
 	ConcIP     net.IP
 	VnicMAC    net.HardwareAddr
 	MTU        int
-	SubnetKey  int //optional: Currently set to SubnetIP
+	SubnetKey  int //Cluster unique key of the tenant subnet, 1 to MaxSubnetKey
 	Subnet     net.IPNet
-	VnicID     string // UUID
-	InstanceID string // UUID
-	TenantID   string // UUID
-	SubnetID   string // UUID
-	ConcID     string // UUID
+	SubnetV6   net.IPNet //optional: IPv6 subnet of a dual stack tenant subnet
+	Gateway    net.IP    //optional: defaults to the first address of Subnet
+	NetworkID  string    //optional: UUID of the tenant defined network
+	VnicID     string    // UUID
+	InstanceID string    // UUID
+	TenantID   string    // UUID
+	SubnetID   string    // UUID
+	ConcID     string    // UUID
 }
 
 // CNSsntpEvent to be generated in response to a VNIC creation
@@ -89,10 +92,15 @@ type SsntpEventInfo struct {
 	CnciIP            string       // TO: IP Address of the concentrator
 	CnIP              string       // FROM: Compute Network IP for this node
 	Subnet            string       // Tenant Subnet
+	SubnetV6          string       // Tenant IPv6 Subnet, empty if the subnet is IPv4 only
+	Gateway           string       // Tenant Subnet gateway, empty if it is the first address
+	NetworkID         string       // Tenant Network UUID, empty for controller allocated subnets
 	TenantID          string       // Tenant UUID
 	SubnetID          string       // Tenant Subnet UUID
 	ConcID            string       // CNCI UUID
 	CnID              string       // CN UUID
+	Mode              NetworkMode  // Tunnel type, GreTunnel or Vxlan
+	VxlanGroup        string       // Vxlan multicast group, empty if remote end points are explicit
 	SubnetKey         int
 	containerSubnetID string // Logical name of the container network.
 	// Hack: Will be removed once we drop deprecated APIs
@@ -179,7 +187,11 @@ type ComputeNode struct {
 	//simultaneously certain netlink calls suffer higher latencies
 	APITimeout time.Duration
 
-	*iptables.IPTables
+	//FwBackend is the packet filtering framework the instances are
+	//filtered with, iptables is used if available on the node and
+	//nftables otherwise when it is FwAuto
+	FwBackend FwBackend
+	fw        fwBackend
 
 	*cnTopology
 	apiThrottleSem chan int
@@ -190,9 +202,9 @@ type ComputeNode struct {
 //However if the subnets are not specified just add the links
 //It is the callers responsibility to pick the correct links
 //TODO: Add interfaces here so CN and CNCI can share most of the init code
-func (cn *ComputeNode) addPhyLinkToConfig(link netlink.Link, ipv4Addrs []netlink.Addr) {
+func (cn *ComputeNode) addPhyLinkToConfig(link netlink.Link, addrs []netlink.Addr) {
 
-	for _, addr := range ipv4Addrs {
+	for _, addr := range addrs {
 
 		if cn.ManagementNet == nil || len(cn.ManagementNet) == 0 {
 			cn.MgtAddr = append(cn.MgtAddr, addr)
@@ -243,11 +255,16 @@ func (cn *ComputeNode) findPhyNwInterface() error {
 			continue
 		}
 
-		addrs, err := netlink.AddrList(link, netlink.FAMILY_V4)
-		if err != nil || len(addrs) == 0 {
+		addrs, err := netlink.AddrList(link, netlink.FAMILY_ALL)
+		if err != nil {
 			continue //Should be safe to ignore this
 		}
 
+		addrs = nodeAddrs(addrs, cn.ManagementNet, cn.ComputeNet)
+		if len(addrs) == 0 {
+			continue
+		}
+
 		phyInterfaces++
 		cn.addPhyLinkToConfig(link, addrs)
 
@@ -289,17 +306,28 @@ func (cn *ComputeNode) Init() error {
 	}
 
 	//TODO: Support all modes
-	if cn.Mode != GreTunnel {
+	switch cn.Mode {
+	case GreTunnel:
+	case Vxlan:
+		if cn.VxlanGroup != nil && !cn.VxlanGroup.IsMulticast() {
+			return NewAPIError(fmt.Sprintf("Invalid vxlan group %v", cn.VxlanGroup))
+		}
+	default:
 		return NewAPIError(fmt.Sprintf("Unsupported network mode %v", cn.Mode))
 	}
 
 	cn.cnTopology = newCnTopology()
 
-	ipt, err := iptables.New()
+	backend, fw, err := newFwBackend(cn.FwBackend)
 	if err != nil {
-		return fmt.Errorf("Unable to setup iptables %v", err)
+		return fmt.Errorf("Unable to setup %v %v", cn.FwBackend, err)
 	}
-	cn.IPTables = ipt
+
+	if err := fw.initBridgeSecurity(); err != nil {
+		return err
+	}
+	cn.FwBackend = backend
+	cn.fw = fw
 
 	return nil
 }
@@ -308,12 +336,14 @@ type vnicAliases struct {
 	bridge string
 	vnic   string
 	gre    string
+	vxlan  string
 }
 
 const (
 	bridgePrefix   = "br_"
 	vnicPrefix     = "vnic_"
 	grePrefix      = "gre_"
+	vxlanPrefix    = "vxlan_"
 	cnciVnicPrefix = "cncivnic_"
 )
 
@@ -352,6 +382,12 @@ func genCnVnicAliases(cfg *VnicConfig) *vnicAliases {
 		cfg.ConcID,
 		cfg.ConcIP)
 
+	vnic.vxlan = fmt.Sprintf("%s%s_%s_%s_%s", vxlanPrefix,
+		cfg.TenantID,
+		cfg.SubnetID,
+		cfg.ConcID,
+		cfg.ConcIP)
+
 	vnic.vnic = fmt.Sprintf("%s%s_%s_%s_%s##%s", vnicPrefix,
 		cfg.TenantID,
 		cfg.SubnetID,
@@ -377,6 +413,8 @@ func checkCnVnicCfg(cfg *VnicConfig) error {
 		return fmt.Errorf("Invalid VNIC configuration - VnicID")
 	case cfg.VnicMAC == nil:
 		return fmt.Errorf("Invalid VNIC configuration - VnicID")
+	case cfg.SubnetKey <= 0 || cfg.SubnetKey > MaxSubnetKey:
+		return fmt.Errorf("Invalid VNIC configuration - SubnetKey")
 	case cfg.VnicRole != TenantVM && cfg.VnicRole != TenantContainer:
 		return fmt.Errorf("Invalid vnic role %v", cfg)
 	}
@@ -428,11 +466,14 @@ func (cn *ComputeNode) rebuildVnicMap(links []netlink.Link) error {
 				id = strings.Split(id, "##")[0]
 				bridge := bridgePrefix + id
 				gre := grePrefix + id
+				vxlan := vxlanPrefix + id
 				if _, err := cn.dbUpdate(bridge, vnic, dbInsVnic); err != nil {
 					return NewFatalError("db rebuild: add vnic" + err.Error())
 				}
-				if _, ok := cn.linkMap[gre]; !ok {
-					return NewFatalError("db rebuild: missing gre tunnel " + gre)
+				_, greOk := cn.linkMap[gre]
+				_, vxlanOk := cn.linkMap[vxlan]
+				if !greOk && !vxlanOk {
+					return NewFatalError("db rebuild: missing tunnel " + gre)
 				}
 				if link.Type() == "veth" {
 					cn.containerMap[bridge] = true
@@ -739,7 +780,7 @@ func (cn *ComputeNode) waitForExistingVnic(vnic *Vnic, bridge *Bridge, vLink *li
 
 }
 
-func (cn *ComputeNode) createDevicesFromCfg(cfg *VnicConfig) (*Vnic, *Bridge, *GreTunEP, error) {
+func (cn *ComputeNode) createDevicesFromCfg(cfg *VnicConfig) (*Vnic, *Bridge, tunnelEP, error) {
 
 	alias := genCnVnicAliases(cfg)
 
@@ -753,7 +794,17 @@ func (cn *ComputeNode) createDevicesFromCfg(cfg *VnicConfig) (*Vnic, *Bridge, *G
 		return nil, nil, nil, NewAPIError(err.Error())
 	}
 
-	local := cn.ComputeAddr[0].IPNet.IP
+	local := tunnelLocalIP(cn.ComputeAddr, cfg.ConcIP)
+
+	if cn.Mode == Vxlan {
+		vxlan, err := newVxlanEP(alias.vxlan, local, cn.VxlanGroup, uint32(cfg.SubnetKey))
+		if err != nil {
+			return nil, nil, nil, NewAPIError(err.Error())
+		}
+		vxlan.DevIndex = tunnelLocalIndex(cn.ComputeLink, cn.ComputeAddr, local)
+		return vnic, bridge, vxlan, nil
+	}
+
 	gre, err := newGreTunEP(alias.gre, local, cfg.ConcIP, uint32(cfg.SubnetKey))
 	if err != nil {
 		return nil, nil, nil, NewAPIError(err.Error())
@@ -797,9 +848,9 @@ func (cn *ComputeNode) addVnicToBridge(cfg *VnicConfig, vnic *Vnic, bridge *Brid
 }
 
 func (cn *ComputeNode) createVnicInternal(cfg *VnicConfig) (*Vnic, *SsntpEventInfo, *ContainerInfo, error) {
-	var gLink *linkInfo
+	var tLink *linkInfo
 
-	vnic, bridge, gre, err := cn.createDevicesFromCfg(cfg)
+	vnic, bridge, tunnel, err := cn.createDevicesFromCfg(cfg)
 
 	if err != nil {
 		return nil, nil, nil, err
@@ -826,18 +877,25 @@ func (cn *ComputeNode) createVnicInternal(cfg *VnicConfig) (*Vnic, *SsntpEventIn
 	vLink = cn.linkMap[vnic.GlobalID]
 	defer close(vLink.ready)
 
+	//The vnic of an instance deleted while the node was down may have
+	//had the same name and been filtered
+	if err := cn.portSecurity(FwDisable, vnic.LinkName, false, nil); err != nil {
+		cn.cnTopology.Unlock()
+		return nil, nil, nil, err
+	}
+
 	bLink, bridgePresent := cn.linkMap[bridge.GlobalID]
 	if bridgePresent {
 		return cn.addVnicToBridge(cfg, vnic, bridge, vLink, bLink)
 	}
 
-	if err := cn.logicallyCreateBridge(bridge, gre, vnic); err != nil {
+	if err := cn.logicallyCreateBridge(bridge, tunnel, vnic); err != nil {
 		cn.cnTopology.Unlock()
 		return nil, nil, nil, NewFatalError(err.Error())
 	}
 
-	gLink = cn.linkMap[gre.GlobalID]
-	defer close(gLink.ready)
+	tLink = cn.linkMap[tunnel.attrs().GlobalID]
+	defer close(tLink.ready)
 
 	bLink = cn.linkMap[bridge.GlobalID]
 	defer close(bLink.ready)
@@ -861,20 +919,39 @@ func (cn *ComputeNode) createVnicInternal(cfg *VnicConfig) (*Vnic, *SsntpEventIn
 		SubnetID:  cfg.SubnetID,
 		SubnetKey: cfg.SubnetKey,
 		Subnet:    cfg.Subnet.String(),
-		CnIP:      gre.LocalIP.String(),
+		CnIP:      tunnelLocalIP(cn.ComputeAddr, cfg.ConcIP).String(),
 		CnID:      cn.ID,
+		NetworkID: cfg.NetworkID,
+		Mode:      GreTunnel,
+	}
+	if cfg.SubnetV6.IP != nil {
+		brCreateMsg.SubnetV6 = cfg.SubnetV6.String()
+	}
+	if cfg.Gateway != nil {
+		brCreateMsg.Gateway = cfg.Gateway.String()
+	}
+	if vxlan, ok := tunnel.(*VxlanEP); ok {
+		brCreateMsg.Mode = Vxlan
+		if vxlan.Group != nil {
+			brCreateMsg.VxlanGroup = vxlan.Group.String()
+		}
 	}
 
-	if err := createAndEnableBridge(bridge, gre); err != nil {
+	if err := createAndEnableBridge(bridge, tunnel); err != nil {
 		return nil, brCreateMsg, nil, NewFatalError(err.Error())
 	}
 	bLink.index = bridge.Link.Index
-	gLink.index = gre.Link.Index
+	tLink.index = tunnel.linkIndex()
 
-	//iptables -A FORWARD -p all -i "$bridge" -j ACCEPT
-	err = cn.AppendUnique("filter", "FORWARD",
-		"-p", "all", "-i", bridge.LinkName, "-j", "ACCEPT")
+	//Without a multicast group the concentrator is the first remote end
+	//point of the subnet. The other compute nodes are set by SetTunnelPeers
+	if vxlan, ok := tunnel.(*VxlanEP); ok && vxlan.Group == nil {
+		if err := vxlan.addRemote(cfg.ConcIP); err != nil {
+			return nil, brCreateMsg, nil, NewFatalError(err.Error())
+		}
+	}
 
+	err = cn.fw.bridgeFwding(FwEnable, bridge.LinkName)
 	if err != nil {
 		return nil, brCreateMsg, nil, NewFatalError(err.Error())
 	}
@@ -896,8 +973,11 @@ func (cn *ComputeNode) createVnicInternal(cfg *VnicConfig) (*Vnic, *SsntpEventIn
 func getContainerInfo(cfg *VnicConfig, vnic *Vnic, bridge *Bridge) *ContainerInfo {
 	//TODO. Create a ciao gateway function so that in the future
 	//if we ever change our gateway algorithm it will propagate everywhere
-	gateway := cfg.Subnet.IP.To4().Mask(cfg.Subnet.Mask)
-	gateway[3]++
+	gateway := cfg.Gateway.To4()
+	if gateway == nil {
+		gateway = cfg.Subnet.IP.To4().Mask(cfg.Subnet.Mask)
+		gateway[3]++
+	}
 	return &ContainerInfo{
 		CNContainerEvent: ContainerNetworkInfo, //Default. Caller to override
 		SubnetID:         bridge.LinkName,
@@ -936,11 +1016,11 @@ func (cn *ComputeNode) logicallyCreateVnic(vnic *Vnic) (err error) {
 //The physical devices are not yet created but their names aliases
 //are added to the topology reserving them
 //TODO: Check for global topology issues. E.g. Two tenants with same CNCI
-func (cn *ComputeNode) logicallyCreateBridge(bridge *Bridge, gre *GreTunEP, vnic *Vnic) (err error) {
+func (cn *ComputeNode) logicallyCreateBridge(bridge *Bridge, tunnel tunnelEP, vnic *Vnic) (err error) {
 	if bridge.LinkName, err = cn.genLinkName(bridge); err != nil {
 		return err
 	}
-	if gre.LinkName, err = cn.genLinkName(gre); err != nil {
+	if tunnel.attrs().LinkName, err = cn.genLinkName(tunnel); err != nil {
 		return err
 	}
 	if _, err = cn.dbUpdate(bridge.GlobalID, "", dbInsBr); err != nil {
@@ -950,8 +1030,8 @@ func (cn *ComputeNode) logicallyCreateBridge(bridge *Bridge, gre *GreTunEP, vnic
 		return err
 	}
 
-	cn.linkMap[gre.GlobalID] = &linkInfo{
-		name:  gre.LinkName,
+	cn.linkMap[tunnel.attrs().GlobalID] = &linkInfo{
+		name:  tunnel.attrs().LinkName,
 		ready: make(chan struct{}),
 	}
 
@@ -966,22 +1046,24 @@ func (cn *ComputeNode) logicallyCreateBridge(bridge *Bridge, gre *GreTunEP, vnic
 //Physically create the devices by calling into the kernel
 //TODO: Try to be more fault tolerant here. We may miss errors but try to
 // honor the request  e.g. If bridge exists use it and try and create tunnel
-func createAndEnableBridge(bridge *Bridge, gre *GreTunEP) error {
+func createAndEnableBridge(bridge *Bridge, tunnel tunnelEP) error {
+	id := tunnel.attrs().GlobalID
+
 	if err := bridge.Create(); err != nil {
 		return fmt.Errorf("Bridge creation failed %s %s", bridge.GlobalID, err.Error())
 	}
-	if err := gre.create(); err != nil {
-		return fmt.Errorf("GRE creation failed %s %s", gre.GlobalID, err.Error())
+	if err := tunnel.create(); err != nil {
+		return fmt.Errorf("Tunnel creation failed %s %s", id, err.Error())
 	}
-	if err := gre.attach(bridge); err != nil {
-		return fmt.Errorf("GRE attach failed %s %s %s", gre.GlobalID, bridge.GlobalID, err.Error())
+	if err := tunnel.attach(bridge); err != nil {
+		return fmt.Errorf("Tunnel attach failed %s %s %s", id, bridge.GlobalID, err.Error())
 	}
 
-	if err := gre.enable(); err != nil {
-		return fmt.Errorf("GRE enable failed %s %s %s", gre.GlobalID, bridge.GlobalID, err.Error())
+	if err := tunnel.enable(); err != nil {
+		return fmt.Errorf("Tunnel enable failed %s %s %s", id, bridge.GlobalID, err.Error())
 	}
 	if err := bridge.Enable(); err != nil {
-		return fmt.Errorf("Bridge enable failed %s %s %s", gre.GlobalID, bridge.GlobalID, err.Error())
+		return fmt.Errorf("Bridge enable failed %s %s %s", id, bridge.GlobalID, err.Error())
 	}
 	return nil
 }
@@ -1108,18 +1190,22 @@ func (cn *ComputeNode) deleteVnicInternal(vnic *Vnic, vLink *linkInfo) (err erro
 }
 
 //Note: Can only be called when holding the topology lock cn.cnTopology.Lock()
-func (cn *ComputeNode) deleteGreInternal(gre *GreTunEP, gLink *linkInfo) (err error) {
-	gre.LinkName, gre.Link.Index, err = waitForDeviceReady(gLink, cn.APITimeout)
+func (cn *ComputeNode) deleteTunnelInternal(tunnel tunnelEP, tLink *linkInfo) (err error) {
+	attrs := tunnel.attrs()
+
+	name, index, err := waitForDeviceReady(tLink, cn.APITimeout)
 	if err != nil {
-		return NewFatalError(gre.GlobalID + err.Error())
+		return NewFatalError(attrs.GlobalID + err.Error())
 	}
+	attrs.LinkName = name
+	tunnel.setLinkIndex(index)
 
-	err = gre.destroy()
+	err = tunnel.destroy()
 	if err != nil {
-		return NewFatalError("gre destroy " + gre.GlobalID + err.Error())
+		return NewFatalError("tunnel destroy " + attrs.GlobalID + err.Error())
 	}
-	delete(cn.nameMap, gre.LinkName)
-	delete(cn.linkMap, gre.GlobalID)
+	delete(cn.nameMap, attrs.LinkName)
+	delete(cn.linkMap, attrs.GlobalID)
 	return nil
 }
 
@@ -1178,6 +1264,11 @@ func (cn *ComputeNode) destroyVnicInternal(cfg *VnicConfig) (*SsntpEventInfo, er
 		return nil, err
 	}
 
+	//The name of the vnic may be reused by another instance
+	if err := cn.portSecurity(FwDisable, vLink.name, false, nil); err != nil {
+		Logger.Warningf("Unable to remove filtering of %s %v", vLink.name, err)
+	}
+
 	vnicCount, err := cn.dbUpdate(alias.bridge, alias.vnic, dbDelVnic)
 	if err != nil {
 		return nil, NewFatalError(err.Error())
@@ -1192,7 +1283,14 @@ func (cn *ComputeNode) destroyVnicInternal(cfg *VnicConfig) (*SsntpEventInfo, er
 		return nil, NewFatalError(err.Error())
 	}
 
-	gre, err := newGreTunEP(alias.gre, nil, nil, 0)
+	var tunnel tunnelEP
+	tLink, present := cn.linkMap[alias.gre]
+	if present {
+		tunnel, err = newGreTunEP(alias.gre, nil, nil, 0)
+	} else {
+		tLink, present = cn.linkMap[alias.vxlan]
+		tunnel, err = newVxlanEP(alias.vxlan, nil, nil, 0)
+	}
 	if err != nil {
 		return nil, NewFatalError(err.Error())
 	}
@@ -1207,26 +1305,27 @@ func (cn *ComputeNode) destroyVnicInternal(cfg *VnicConfig) (*SsntpEventInfo, er
 		Subnet:    cfg.Subnet.String(),
 		CnIP:      cn.ComputeAddr[0].IPNet.IP.String(),
 		CnID:      cn.ID,
+		Mode:      GreTunnel,
+	}
+	if _, ok := tunnel.(*VxlanEP); ok {
+		brDeleteMsg.Mode = Vxlan
 	}
 
 	//TODO: Try and make forward progress even on error
-	gLink, present := cn.linkMap[alias.gre]
 	if present {
-		err := cn.deleteGreInternal(gre, gLink)
+		err := cn.deleteTunnelInternal(tunnel, tLink)
 		if err != nil {
 			return nil, err
 		}
 	} else {
 		//TODO: Consider logging this and continue to delete bridge
-		return nil, NewFatalError(fmt.Sprintf("gre tunnel not present %s", gre.GlobalID))
+		return nil, NewFatalError(fmt.Sprintf("tunnel not present %s", alias.gre))
 	}
 
 	bLink, present := cn.linkMap[alias.bridge]
 	if present {
 		//Make forward progress even on error
-		err := cn.Delete("filter", "FORWARD",
-			"-p", "all", "-i", bridge.LinkName, "-j", "ACCEPT")
-
+		err := cn.fw.bridgeFwding(FwDisable, bridge.LinkName)
 		if err != nil {
 			fmt.Printf("Unable to delete firewall rule %v", err)
 		}
@@ -1247,6 +1346,113 @@ func (cn *ComputeNode) destroyVnicInternal(cfg *VnicConfig) (*SsntpEventInfo, er
 	return brDeleteMsg, nil
 }
 
+// InstanceSecurity enables or disables the ingress filtering of the
+// instance a vnic belongs to. The rules are the ones the CNCI of the
+// tenant enforces. They apply to all the traffic bridged to the vnic,
+// including the traffic of the instances of the same subnet which never
+// reaches the CNCI: the ones running on this node and, with VXLAN tunnels,
+// the ones running on the other nodes of the subnet. Enabling filtering
+// on a vnic that is already filtered replaces its rules.
+func (cn *ComputeNode) InstanceSecurity(action FwAction, cfg *VnicConfig, rules []SecurityRule) error {
+	if cfg == nil || cn.cnTopology == nil {
+		return NewAPIError("invalid configuration")
+	}
+
+	switch action {
+	case FwEnable, FwDisable:
+	default:
+		return NewAPIError(fmt.Sprintf("invalid action %v", action))
+	}
+
+	alias := genCnVnicAliases(cfg)
+
+	//The topology lock prevents the vnic from being destroyed, along
+	//with its filtering, while the rules are set
+	cn.cnTopology.Lock()
+	defer cn.cnTopology.Unlock()
+
+	vLink, present := cn.linkMap[alias.vnic]
+	if !present {
+		if action == FwDisable {
+			return nil
+		}
+		return NewAPIError(fmt.Sprintf("vnic not present %s", alias.vnic))
+	}
+
+	return cn.portSecurity(action, vLink.name, cfg.SubnetV6.IP != nil, rules)
+}
+
+//portSecurity filters the traffic bridged to port
+//Note: Can only be called when holding the topology lock cn.cnTopology.Lock()
+func (cn *ComputeNode) portSecurity(action FwAction, port string, ipv6 bool, rules []SecurityRule) error {
+	if action == FwEnable {
+		if err := checkSecurityRules(rules); err != nil {
+			return NewAPIError(fmt.Sprintf("Unable to filter %s: %v", port, err))
+		}
+	}
+
+	if err := cn.fw.portSecurity(action, port, ipv6, rules); err != nil {
+		return NewFatalError(err.Error())
+	}
+
+	return nil
+}
+
+// SetTunnelPeers sets the other compute nodes the VXLAN tunnel of a tenant
+// subnet reaches, in addition to the concentrator. The peers of a subnet
+// are sent by its CNCI whenever compute nodes join or leave the subnet.
+// It is a no-op for GRE tunnels, for VXLAN tunnels with a multicast group
+// and for subnets that are no longer present on this node.
+func (cn *ComputeNode) SetTunnelPeers(cfg *VnicConfig, peers []net.IP) error {
+	if cfg == nil || cn.cnTopology == nil {
+		return NewAPIError("invalid configuration")
+	}
+
+	if cfg.TenantID == "" || cfg.SubnetID == "" || cfg.ConcID == "" || cfg.ConcIP == nil {
+		return NewAPIError(fmt.Sprintf("invalid tunnel configuration %v", cfg))
+	}
+
+	alias := genCnVnicAliases(cfg)
+
+	cn.cnTopology.Lock()
+	defer cn.cnTopology.Unlock()
+
+	tLink, present := cn.linkMap[alias.vxlan]
+	if !present {
+		return nil
+	}
+
+	vxlan, err := newVxlanEP(alias.vxlan, nil, nil, 0)
+	if err != nil {
+		return NewAPIError(err.Error())
+	}
+
+	if _, _, err = waitForDeviceReady(tLink, cn.APITimeout); err != nil {
+		return NewAPIError(alias.vxlan + err.Error())
+	}
+
+	if err := vxlan.getDevice(); err != nil {
+		return NewAPIError(err.Error())
+	}
+
+	if vxlan.Group != nil {
+		return nil
+	}
+
+	remotes := []net.IP{cfg.ConcIP}
+	for _, p := range peers {
+		if !p.Equal(vxlan.LocalIP) && !p.Equal(cfg.ConcIP) {
+			remotes = append(remotes, p)
+		}
+	}
+
+	if err := vxlan.setRemotes(remotes); err != nil {
+		return NewAPIError(err.Error())
+	}
+
+	return nil
+}
+
 //ResetNetwork will attempt to clean up all network interfaces
 //created. It will not clean up any interfaces created manually
 func (cn *ComputeNode) ResetNetwork() error {
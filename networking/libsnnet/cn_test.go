@@ -59,6 +59,10 @@ func snTestInit() {
 }
 
 func cnTestInit() (*ComputeNode, error) {
+	return cnTestInitMode(GreTunnel)
+}
+
+func cnTestInitMode(mode NetworkMode) (*ComputeNode, error) {
 	snTestInit()
 
 	_, testNet, err := net.ParseCIDR(snTestNet)
@@ -69,7 +73,7 @@ func cnTestInit() (*ComputeNode, error) {
 	netConfig := &NetworkConfig{
 		ManagementNet: []net.IPNet{*testNet},
 		ComputeNet:    []net.IPNet{*testNet},
-		Mode:          GreTunnel,
+		Mode:          mode,
 	}
 
 	cn := &ComputeNode{
@@ -221,6 +225,7 @@ func TestCN_ResetNetwork(t *testing.T) {
 
 	vnicCfg.TenantID = "tuuid2"
 	vnicCfg.ConcIP = net.IPv4(192, 168, 1, 2)
+	vnicCfg.SubnetKey = 0x10
 
 	_, ssntpEvent, _, err = cn.CreateVnic(vnicCfg)
 	if assert.Nil(err) {
@@ -232,6 +237,7 @@ func TestCN_ResetNetwork(t *testing.T) {
 
 	vnicCfg.TenantID = "tuuid"
 	vnicCfg.ConcIP = net.IPv4(192, 168, 1, 1)
+	vnicCfg.SubnetKey = 0xF
 
 	_, ssntpEvent, _, err = cn.CreateVnic(vnicCfg)
 	if assert.Nil(err) {
@@ -240,6 +246,7 @@ func TestCN_ResetNetwork(t *testing.T) {
 
 	vnicCfg.TenantID = "tuuid2"
 	vnicCfg.ConcIP = net.IPv4(192, 168, 1, 2)
+	vnicCfg.SubnetKey = 0x10
 
 	_, ssntpEvent, _, err = cn.CreateVnic(vnicCfg)
 	if assert.Nil(err) {
@@ -288,6 +295,7 @@ func TestCN_MultiTenant(t *testing.T) {
 
 	vnicCfg.TenantID = "tuuid2"
 	vnicCfg.ConcIP = net.IPv4(192, 168, 1, 2)
+	vnicCfg.SubnetKey = 0x10
 
 	_, ssntpEvent, _, err = cn.CreateVnic(vnicCfg)
 	if assert.Nil(err) {
@@ -301,6 +309,7 @@ func TestCN_MultiTenant(t *testing.T) {
 
 	vnicCfg.TenantID = "tuuid"
 	vnicCfg.ConcIP = net.IPv4(192, 168, 1, 1)
+	vnicCfg.SubnetKey = 0xF
 
 	ssntpEvent, _, err = cn.DestroyVnic(vnicCfg)
 	if assert.Nil(err) {
@@ -342,6 +351,17 @@ func TestCN_Negative(t *testing.T) {
 	//Fix the errors
 	vnicCfg.TenantID = "tuuid"
 
+	//Subnet keys have to fit in a VXLAN network identifier
+	vnicCfg.SubnetKey = MaxSubnetKey + 1
+	_, _, _, err = cn.CreateVnic(vnicCfg)
+	assert.NotNil(err)
+
+	vnicCfg.SubnetKey = 0
+	_, _, _, err = cn.CreateVnic(vnicCfg)
+	assert.NotNil(err)
+
+	vnicCfg.SubnetKey = 0xF
+
 	// Try and create it again.
 	var vnicName string
 	vnic, ssntpEvent, _, err := cn.CreateVnic(vnicCfg)
@@ -685,6 +705,175 @@ func TestCN_Base(t *testing.T) {
 	}
 }
 
+//Tests the CN APIs in VXLAN mode
+//
+//This tests creates and destroys VNICs on a tenant subnet
+//reached over a VXLAN tunnel. It checks that the concentrator
+//and the peers set through SetTunnelPeers are the remote end
+//points of the tunnel and that the topology can be rebuilt
+//
+//Test is expected to pass
+func TestCN_Vxlan(t *testing.T) {
+	assert := assert.New(t)
+	cn, err := cnTestInitMode(Vxlan)
+	require.Nil(t, err)
+
+	_, tenantNet, _ := net.ParseCIDR("192.168.1.0/24")
+	concIP := net.IPv4(192, 168, 111, 1)
+	peer1 := net.IPv4(192, 168, 111, 2)
+	peer2 := net.IPv4(192, 168, 111, 3)
+
+	mac, _ := net.ParseMAC("CA:FE:00:01:02:03")
+	vnicCfg := &VnicConfig{
+		VnicIP:     net.IPv4(192, 168, 1, 100),
+		ConcIP:     concIP,
+		VnicMAC:    mac,
+		Subnet:     *tenantNet,
+		SubnetKey:  0x2A,
+		VnicID:     "vuuid",
+		InstanceID: "iuuid",
+		TenantID:   "tuuid",
+		SubnetID:   "suuid",
+		ConcID:     "cnciuuid",
+	}
+
+	_, ssntpEvent, _, err := cn.CreateVnic(vnicCfg)
+	require.Nil(t, err)
+	if assert.NotNil(ssntpEvent) {
+		assert.Nil(validSsntpEvent(ssntpEvent, vnicCfg))
+		assert.Equal(SsntpTunAdd, ssntpEvent.Event)
+		assert.Equal(Vxlan, ssntpEvent.Mode)
+		assert.Equal("", ssntpEvent.VxlanGroup)
+	}
+
+	vxlan, err := newVxlanEP(genCnVnicAliases(vnicCfg).vxlan, nil, nil, 0)
+	require.Nil(t, err)
+	require.Nil(t, vxlan.getDevice())
+	assert.Equal(uint32(0x2A), vxlan.VNI)
+
+	remotes, err := vxlan.remotes()
+	assert.Nil(err)
+	if assert.Equal(1, len(remotes)) {
+		assert.True(remotes[0].Equal(concIP))
+	}
+
+	//The local end point is not a peer of itself
+	assert.Nil(cn.SetTunnelPeers(vnicCfg, []net.IP{peer1, peer2, vxlan.LocalIP}))
+	remotes, err = vxlan.remotes()
+	assert.Nil(err)
+	assert.Equal(3, len(remotes))
+
+	assert.Nil(cn.SetTunnelPeers(vnicCfg, []net.IP{peer2}))
+	remotes, err = vxlan.remotes()
+	assert.Nil(err)
+	assert.Equal(2, len(remotes))
+
+	assert.Nil(cn.DbRebuild(nil))
+
+	mac2, _ := net.ParseMAC("CA:FE:00:01:02:22")
+	vnicCfg2 := *vnicCfg
+	vnicCfg2.VnicIP = net.IPv4(192, 168, 1, 2)
+	vnicCfg2.VnicMAC = mac2
+	vnicCfg2.VnicID = "vuuid2"
+	vnicCfg2.InstanceID = "iuuid2"
+
+	_, ssntpEvent, _, err = cn.CreateVnic(&vnicCfg2)
+	if assert.Nil(err) {
+		assert.Nil(ssntpEvent)
+	}
+
+	ssntpEvent, _, err = cn.DestroyVnic(&vnicCfg2)
+	if assert.Nil(err) {
+		assert.Nil(ssntpEvent)
+	}
+
+	ssntpEvent, _, err = cn.DestroyVnic(vnicCfg)
+	if assert.Nil(err) && assert.NotNil(ssntpEvent) {
+		assert.Nil(validSsntpEvent(ssntpEvent, vnicCfg))
+		assert.Equal(SsntpTunDel, ssntpEvent.Event)
+		assert.Equal(Vxlan, ssntpEvent.Mode)
+	}
+	assert.NotNil(vxlan.getDevice())
+
+	//The subnet is gone, peers are ignored
+	assert.Nil(cn.SetTunnelPeers(vnicCfg, []net.IP{peer1}))
+}
+
+//Tests the filtering of instances on the compute node
+//
+//This tests filters the ingress traffic of a vnic, replaces its
+//rules and checks that destroying the vnic removes its filtering
+//
+//Test is expected to pass
+func TestCN_InstanceSecurity(t *testing.T) {
+	cn, err := cnTestInit()
+	require.Nil(t, err)
+
+	testCNInstanceSecurity(t, cn, nil)
+}
+
+//Tests the filtering of instances on a VXLAN compute node
+//
+//In VXLAN mode the instances of a subnet running on different compute
+//nodes reach each other directly, without going through the CNCI. This
+//tests that the filtering of the vnic is set up once the other compute
+//nodes are peers of the tunnel, the traffic they send being bridged to
+//the vnic like the traffic of the CNCI
+//
+//Test is expected to pass
+func TestCN_InstanceSecurityVxlan(t *testing.T) {
+	cn, err := cnTestInitMode(Vxlan)
+	require.Nil(t, err)
+
+	testCNInstanceSecurity(t, cn, []net.IP{net.IPv4(192, 168, 111, 2)})
+}
+
+func testCNInstanceSecurity(t *testing.T, cn *ComputeNode, peers []net.IP) {
+	assert := assert.New(t)
+
+	_, tenantNet, _ := net.ParseCIDR("192.168.1.0/24")
+	mac, _ := net.ParseMAC("CA:FE:00:01:02:03")
+	vnicCfg := &VnicConfig{
+		VnicIP:     net.IPv4(192, 168, 1, 100),
+		ConcIP:     net.IPv4(192, 168, 1, 1),
+		VnicMAC:    mac,
+		Subnet:     *tenantNet,
+		SubnetKey:  0xF,
+		VnicID:     "vuuid",
+		InstanceID: "iuuid",
+		TenantID:   "tuuid",
+		SubnetID:   "suuid",
+		ConcID:     "cnciuuid",
+	}
+
+	rules := []SecurityRule{
+		{Protocol: "tcp", PortMin: 22, PortMax: 22},
+		{Protocol: "icmp", RemoteCIDR: "192.168.1.0/24"},
+	}
+
+	//The vnic does not exist yet
+	assert.NotNil(cn.InstanceSecurity(FwEnable, vnicCfg, rules))
+	assert.Nil(cn.InstanceSecurity(FwDisable, vnicCfg, nil))
+
+	_, _, _, err := cn.CreateVnic(vnicCfg)
+	require.Nil(t, err)
+
+	if peers != nil {
+		assert.Nil(cn.SetTunnelPeers(vnicCfg, peers))
+	}
+
+	assert.Nil(cn.InstanceSecurity(FwEnable, vnicCfg, rules))
+	assert.Nil(cn.InstanceSecurity(FwEnable, vnicCfg, rules[:1]))
+	assert.NotNil(cn.InstanceSecurity(FwEnable, vnicCfg, []SecurityRule{{Protocol: "gre"}}))
+	assert.Nil(cn.InstanceSecurity(FwDisable, vnicCfg, nil))
+	assert.Nil(cn.InstanceSecurity(FwEnable, vnicCfg, rules))
+
+	_, _, err = cn.DestroyVnic(vnicCfg)
+	assert.Nil(err)
+
+	assert.Nil(cn.InstanceSecurity(FwDisable, vnicCfg, nil))
+}
+
 //Whitebox test the CN API
 //
 //This tests exercises tests the primitive operations
@@ -56,6 +56,42 @@ type Cnci struct {
 	PublicIPMap map[string]net.IP //Key is public IPNet
 
 	topology *cnciTopology
+	dns      cnciDNS
+	dhcp     cnciDhcp
+}
+
+//cnciDNS holds the tenant instance names served by the dnsmasq of
+//every tenant subnet
+type cnciDNS struct {
+	sync.Mutex
+	domain  string
+	records map[string][]net.IP //Key is the instance name
+}
+
+//configure hands the instance names to the dnsmasq of a subnet
+func (dns *cnciDNS) configure(d *Dnsmasq) {
+	dns.Lock()
+	defer dns.Unlock()
+	d.setDNSRecords(dns.domain, dns.records)
+}
+
+//cnciDhcp holds the DHCP options of the tenant subnets and the custom
+//MAC addresses of the tenant instances
+type cnciDhcp struct {
+	sync.Mutex
+	options map[string]DhcpOptions      //Key is the subnet
+	hosts   map[string]net.HardwareAddr //Key is the IP address
+}
+
+//configure hands the DHCP options of its subnet and the custom MAC
+//addresses to a dnsmasq
+func (dhcp *cnciDhcp) configure(d *Dnsmasq) error {
+	dhcp.Lock()
+	defer dhcp.Unlock()
+	if err := d.setDhcpHosts(dhcp.hosts); err != nil {
+		return err
+	}
+	return d.setDhcpOptions(dhcp.options[d.TenantNet.String()])
 }
 
 //Network topology of the node
@@ -93,9 +129,9 @@ func enableForwarding() error {
 //if the link has an IP address the falls within one of the configured subnets
 //However if the subnets are not specified just add the links
 //It is the callers responsibility to pick the correct link
-func (cnci *Cnci) addPhyLinkToConfig(link netlink.Link, ipv4Addrs []netlink.Addr) {
+func (cnci *Cnci) addPhyLinkToConfig(link netlink.Link, addrs []netlink.Addr) {
 
-	for _, addr := range ipv4Addrs {
+	for _, addr := range addrs {
 
 		if cnci.ManagementNet == nil {
 			cnci.MgtAddr = append(cnci.MgtAddr, addr)
@@ -144,8 +180,13 @@ func (cnci *Cnci) findPhyNwInterface() error {
 			continue
 		}
 
-		addrs, err := netlink.AddrList(link, netlink.FAMILY_V4)
-		if err != nil || len(addrs) == 0 {
+		addrs, err := netlink.AddrList(link, netlink.FAMILY_ALL)
+		if err != nil {
+			continue
+		}
+
+		addrs = nodeAddrs(addrs, cnci.ManagementNet, cnci.ComputeNet)
+		if len(addrs) == 0 {
 			continue //Ignore links with no IP addresses
 		}
 
@@ -244,7 +285,12 @@ func (cnci *Cnci) rebuildBridgeMap(links []netlink.Link) error {
 			return (err)
 		}
 
-		dns, err := startDnsmasq(br, cnci.Tenant, *subnet)
+		cfg := RemoteSubnetConfig{
+			SubnetV6: bridgeSubnetV6(br),
+			Gateway:  bridgeGateway(br, *subnet),
+		}
+
+		dns, err := startDnsmasq(br, cnci.Tenant, *subnet, cfg, &cnci.dns, &cnci.dhcp)
 		if err != nil {
 			return (err)
 		}
@@ -258,7 +304,14 @@ func (cnci *Cnci) rebuildBridgeMap(links []netlink.Link) error {
 
 func (cnci *Cnci) verifyTopology(links []netlink.Link) error {
 	for _, link := range links {
-		if link.Type() != "gretap" {
+		if link.Type() == "vxlan" {
+			if err := cnci.verifyVxlan(link); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if link.Type() != "gretap" && link.Type() != "ip6gretap" {
 			continue
 		}
 
@@ -283,6 +336,26 @@ func (cnci *Cnci) verifyTopology(links []netlink.Link) error {
 	return nil
 }
 
+func (cnci *Cnci) verifyVxlan(link netlink.Link) error {
+	vxlan := link.Attrs().Alias
+	if !strings.HasPrefix(vxlan, vxlanPrefix) {
+		return nil
+	}
+
+	bridgeID := bridgePrefix + strings.TrimPrefix(vxlan, vxlanPrefix)
+
+	if _, ok := cnci.topology.linkMap[bridgeID]; !ok {
+		return fmt.Errorf("missing bridge for vxlan tunnel %s", vxlan)
+	}
+
+	brInfo, ok := cnci.topology.bridgeMap[bridgeID]
+	if !ok {
+		return fmt.Errorf("missing bridge map for vxlan tunnel %s", vxlan)
+	}
+	brInfo.tunnels++
+	return nil
+}
+
 //RebuildTopology CNCI network database using the information contained
 //in the aliases. It can be called if the agent using the library
 //crashes and loses network topology information.
@@ -337,6 +410,10 @@ func genGreAlias(subnet net.IPNet, cnIP net.IP) string {
 	return fmt.Sprintf("%s%s##%s", grePrefix, subnetToString(subnet), cnIP.String())
 }
 
+func genVxlanAlias(subnet net.IPNet) string {
+	return fmt.Sprintf("%s%s", vxlanPrefix, subnetToString(subnet))
+}
+
 func genLinkName(device interface{}, nameMap map[string]bool) (string, error) {
 	for i := 0; i < ifaceRetryLimit; {
 		name, _ := genIface(device, false)
@@ -348,12 +425,67 @@ func genLinkName(device interface{}, nameMap map[string]bool) (string, error) {
 	return "", fmt.Errorf("Unable to generate unique device name")
 }
 
-func startDnsmasq(bridge *Bridge, tenant string, subnet net.IPNet) (*Dnsmasq, error) {
+//bridgeSubnetV6 recovers the IPv6 subnet of a dual stack bridge from the
+//global IPv6 address dnsmasq assigned to it. nil is returned if the
+//bridge only serves IPv4
+func bridgeSubnetV6(bridge *Bridge) *net.IPNet {
+	addrs, err := netlink.AddrList(bridge.Link, netlink.FAMILY_V6)
+	if err != nil {
+		return nil
+	}
+
+	for _, addr := range addrs {
+		if addr.IPNet != nil && addr.IP.IsGlobalUnicast() {
+			return &net.IPNet{
+				IP:   addr.IP.Mask(addr.Mask),
+				Mask: addr.Mask,
+			}
+		}
+	}
+	return nil
+}
+
+//bridgeGateway recovers the gateway of a subnet from the address dnsmasq
+//assigned to the bridge. nil is returned if the bridge has no address in
+//the subnet
+func bridgeGateway(bridge *Bridge, subnet net.IPNet) net.IP {
+	addrs, err := netlink.AddrList(bridge.Link, netlink.FAMILY_V4)
+	if err != nil {
+		return nil
+	}
+
+	for _, addr := range addrs {
+		if addr.IPNet != nil && subnet.Contains(addr.IP) {
+			return addr.IP
+		}
+	}
+	return nil
+}
+
+func startDnsmasq(bridge *Bridge, tenant string, subnet net.IPNet, cfg RemoteSubnetConfig, names *cnciDNS, options *cnciDhcp) (*Dnsmasq, error) {
 	dns, err := newDnsmasq(bridge.GlobalID, tenant, subnet, 0, bridge)
 	if err != nil {
 		return nil, fmt.Errorf("NewDnsmasq failed %v", err)
 	}
 
+	if cfg.Gateway != nil {
+		if err = dns.setGateway(cfg.Gateway); err != nil {
+			return nil, fmt.Errorf("NewDnsmasq failed %v", err)
+		}
+	}
+
+	if cfg.SubnetV6 != nil {
+		if err = dns.setSubnetV6(*cfg.SubnetV6); err != nil {
+			return nil, fmt.Errorf("NewDnsmasq failed %v", err)
+		}
+	}
+
+	names.configure(dns)
+
+	if err = options.configure(dns); err != nil {
+		return nil, fmt.Errorf("NewDnsmasq failed %v", err)
+	}
+
 	if _, err = dns.attach(); err != nil {
 		err = dns.restart()
 		if err != nil {
@@ -363,7 +495,7 @@ func startDnsmasq(bridge *Bridge, tenant string, subnet net.IPNet) (*Dnsmasq, er
 	return dns, nil
 }
 
-func createCnciBridge(bridge *Bridge, brInfo *bridgeInfo, tenant string, subnet net.IPNet) (err error) {
+func createCnciBridge(bridge *Bridge, brInfo *bridgeInfo, tenant string, subnet net.IPNet, cfg RemoteSubnetConfig, names *cnciDNS, options *cnciDhcp) (err error) {
 	if bridge == nil || brInfo == nil {
 		return fmt.Errorf("nil pointer encountered bridge[%v] brInfo[%v]", bridge, brInfo)
 	}
@@ -373,15 +505,15 @@ func createCnciBridge(bridge *Bridge, brInfo *bridgeInfo, tenant string, subnet
 	if err = bridge.Enable(); err != nil {
 		return err
 	}
-	brInfo.Dnsmasq, err = startDnsmasq(bridge, tenant, subnet)
+	brInfo.Dnsmasq, err = startDnsmasq(bridge, tenant, subnet, cfg, names, options)
 	return err
 }
 
-func createCnciTunnel(gre *GreTunEP) (err error) {
-	if err = gre.create(); err != nil {
+func createCnciTunnel(tunnel tunnelEP) (err error) {
+	if err = tunnel.create(); err != nil {
 		return err
 	}
-	if err = gre.enable(); err != nil {
+	if err = tunnel.enable(); err != nil {
 		return err
 	}
 	return nil
@@ -393,7 +525,7 @@ func checkInputParams(subnet net.IPNet, subnetKey int, cnIP net.IP) error {
 		return fmt.Errorf("Invalid input parameters - Subnet IP")
 	case subnet.Mask == nil:
 		return fmt.Errorf("Invalid input parameters - Subnet Mask")
-	case subnetKey == 0:
+	case subnetKey <= 0 || subnetKey > MaxSubnetKey:
 		return fmt.Errorf("Invalid input parameters - Subnet Key")
 	case cnIP == nil:
 		return fmt.Errorf("Invalid input parameters - CN IP")
@@ -405,9 +537,10 @@ func checkInputParams(subnet net.IPNet, subnetKey int, cnIP net.IP) error {
 //If the function returns error the bridgeName can be ignored
 //If the function does not return error and has a valid bridge name
 //then the subnet has been found and no further processing is needed
-func (cnci *Cnci) addSubnetToTopology(bridge *Bridge, gre *GreTunEP, brInfo **bridgeInfo) (brExists bool,
+func (cnci *Cnci) addSubnetToTopology(bridge *Bridge, tunnel tunnelEP, brInfo **bridgeInfo) (brExists bool,
 	greExists bool, bLink *linkInfo, gLink *linkInfo, err error) {
 	err = nil
+	gre := tunnel.attrs()
 
 	// CS Start
 	cnci.topology.Lock()
@@ -444,7 +577,7 @@ func (cnci *Cnci) addSubnetToTopology(bridge *Bridge, gre *GreTunEP, brInfo **br
 	}
 
 	if !greExists {
-		gre.LinkName, err = genLinkName(gre, cnci.topology.nameMap)
+		gre.LinkName, err = genLinkName(tunnel, cnci.topology.nameMap)
 		if err != nil {
 			cnci.topology.Unlock()
 			return
@@ -467,6 +600,29 @@ func (cnci *Cnci) addSubnetToTopology(bridge *Bridge, gre *GreTunEP, brInfo **br
 //If the tunnel exists and the bridge does not exist the bridge is created
 //The bridge name interface name is returned if the bridge is newly created
 func (cnci *Cnci) AddRemoteSubnet(subnet net.IPNet, subnetKey int, cnIP net.IP) (string, error) {
+	return cnci.AddRemoteSubnetWithConfig(subnet, RemoteSubnetConfig{}, subnetKey, cnIP)
+}
+
+//AddRemoteDualStackSubnet attaches a remote dual stack subnet to a local
+//bridge on the CNCI. It behaves like AddRemoteSubnet, in addition the
+//IPv6 /64 subnetV6 is advertised to the instances on the bridge when the
+//bridge is newly created
+func (cnci *Cnci) AddRemoteDualStackSubnet(subnet net.IPNet, subnetV6 net.IPNet, subnetKey int, cnIP net.IP) (string, error) {
+	return cnci.AddRemoteSubnetWithConfig(subnet, RemoteSubnetConfig{SubnetV6: &subnetV6}, subnetKey, cnIP)
+}
+
+//RemoteSubnetConfig holds the optional settings of a remote subnet
+type RemoteSubnetConfig struct {
+	SubnetV6   *net.IPNet //IPv6 /64 of a dual stack subnet
+	Gateway    net.IP     //Gateway of the subnet, defaults to its first address
+	Vxlan      bool       //The CN reaches the subnet over VXLAN instead of GRE
+	VxlanGroup net.IP     //Multicast group of a VXLAN subnet, optional
+}
+
+//AddRemoteSubnetWithConfig attaches a remote subnet to a local bridge on
+//the CNCI like AddRemoteSubnet. The settings in cfg are only applied
+//when the bridge is newly created
+func (cnci *Cnci) AddRemoteSubnetWithConfig(subnet net.IPNet, cfg RemoteSubnetConfig, subnetKey int, cnIP net.IP) (string, error) {
 
 	if err := checkInputParams(subnet, subnetKey, cnIP); err != nil {
 		return "", err
@@ -477,7 +633,11 @@ func (cnci *Cnci) AddRemoteSubnet(subnet net.IPNet, subnetKey int, cnIP net.IP)
 		return "", err
 	}
 
-	gre, err := newGreTunEP(genGreAlias(subnet, cnIP), cnci.ComputeAddr[0].IPNet.IP, cnIP, uint32(subnetKey))
+	if cfg.Vxlan {
+		return cnci.addRemoteVxlanSubnet(bridge, subnet, cfg, subnetKey, cnIP)
+	}
+
+	gre, err := newGreTunEP(genGreAlias(subnet, cnIP), tunnelLocalIP(cnci.ComputeAddr, cnIP), cnIP, uint32(subnetKey))
 	if err != nil {
 		return "", err
 	}
@@ -495,7 +655,7 @@ func (cnci *Cnci) AddRemoteSubnet(subnet net.IPNet, subnetKey int, cnIP net.IP)
 
 	//Now create them. This is time consuming
 	if !brExists {
-		err = createCnciBridge(bridge, brInfo, cnci.Tenant, subnet)
+		err = createCnciBridge(bridge, brInfo, cnci.Tenant, subnet, cfg, &cnci.dns, &cnci.dhcp)
 		bLink.index = bridge.Link.Index
 		close(bLink.ready)
 		if err != nil {
@@ -531,6 +691,77 @@ func (cnci *Cnci) AddRemoteSubnet(subnet net.IPNet, subnetKey int, cnIP net.IP)
 
 }
 
+//addRemoteVxlanSubnet is the VXLAN flavour of AddRemoteSubnetWithConfig.
+//All the CNs of a subnet share a single VXLAN tunnel attached to the
+//bridge. Unless the subnet uses a multicast group each CN is added as a
+//remote end point of the tunnel
+func (cnci *Cnci) addRemoteVxlanSubnet(bridge *Bridge, subnet net.IPNet, cfg RemoteSubnetConfig, subnetKey int, cnIP net.IP) (string, error) {
+
+	local := tunnelLocalIP(cnci.ComputeAddr, cnIP)
+	vxlan, err := newVxlanEP(genVxlanAlias(subnet), local, cfg.VxlanGroup, uint32(subnetKey))
+	if err != nil {
+		return "", err
+	}
+	vxlan.DevIndex = tunnelLocalIndex(cnci.ComputeLink, cnci.ComputeAddr, local)
+
+	var brInfo *bridgeInfo
+	brExists, vxlanExists, bLink, vLink, err := cnci.addSubnetToTopology(bridge, vxlan, &brInfo)
+	if err != nil {
+		return "", err
+	}
+
+	if !brExists {
+		err = createCnciBridge(bridge, brInfo, cnci.Tenant, subnet, cfg, &cnci.dns, &cnci.dhcp)
+		bLink.index = bridge.Link.Index
+		close(bLink.ready)
+		if err != nil {
+			//Do not leave the tunnel hanging
+			if !vxlanExists {
+				close(vLink.ready)
+			}
+			return "", err
+		}
+	}
+
+	if !vxlanExists {
+		err = createCnciTunnel(vxlan)
+		vLink.index = vxlan.Link.Index
+		close(vLink.ready)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	bridge.LinkName, bridge.Link.Index, err = waitForDeviceReady(bLink, cnci.APITimeout)
+	if err != nil {
+		return "", err
+	}
+	vxlan.LinkName, vxlan.Link.Index, err = waitForDeviceReady(vLink, cnci.APITimeout)
+	if err != nil {
+		return "", err
+	}
+
+	if !vxlanExists {
+		if err = vxlan.attach(bridge); err != nil {
+			return "", err
+		}
+	}
+
+	if cfg.VxlanGroup == nil {
+		if err = vxlan.addRemote(cnIP); err != nil {
+			return "", err
+		}
+	}
+
+	switch {
+	case brExists && vxlanExists:
+		return bLink.name, nil
+	case brExists:
+		return "", nil
+	}
+	return bridge.LinkName, nil
+}
+
 //DelRemoteSubnet detaches a remote subnet from the local bridge
 //The bridge and DHCP server is kept around as they impose minimal overhead
 //and helps in the case where instances keep getting added and deleted constantly
@@ -543,7 +774,7 @@ func (cnci *Cnci) DelRemoteSubnet(subnet net.IPNet, subnetKey int, cnIP net.IP)
 	bridgeID := genBridgeAlias(subnet)
 
 	gre, err := newGreTunEP(genGreAlias(subnet, cnIP),
-		cnci.ComputeAddr[0].IPNet.IP,
+		tunnelLocalIP(cnci.ComputeAddr, cnIP),
 		cnIP, uint32(subnetKey))
 
 	if err != nil {
@@ -557,9 +788,7 @@ func (cnci *Cnci) DelRemoteSubnet(subnet net.IPNet, subnetKey int, cnIP net.IP)
 	gLink, present := cnci.topology.linkMap[gre.GlobalID]
 
 	if !present {
-		//TODO: Log this and continue
-		//fmt.Println("Deleting non existent tunnel ", gre.GlobalID)
-		return nil
+		return cnci.delRemoteVxlanSubnet(subnet, cnIP)
 	}
 
 	if brInfo, present := cnci.topology.bridgeMap[bridgeID]; !present {
@@ -581,6 +810,173 @@ func (cnci *Cnci) DelRemoteSubnet(subnet net.IPNet, subnetKey int, cnIP net.IP)
 	return err
 }
 
+//delRemoteVxlanSubnet removes a CN from the remote end points of the VXLAN
+//tunnel of a subnet. The tunnel itself is kept around with the bridge
+//Note: Can only be called when holding the topology lock
+func (cnci *Cnci) delRemoteVxlanSubnet(subnet net.IPNet, cnIP net.IP) error {
+	vxlan, err := newVxlanEP(genVxlanAlias(subnet), nil, nil, 0)
+	if err != nil {
+		return err
+	}
+
+	vLink, present := cnci.topology.linkMap[vxlan.GlobalID]
+	if !present {
+		//TODO: Log this and continue
+		//fmt.Println("Deleting non existent tunnel ", gre.GlobalID)
+		return nil
+	}
+
+	vxlan.LinkName, vxlan.Link.Index, err = waitForDeviceReady(vLink, cnci.APITimeout)
+	if err != nil {
+		return fmt.Errorf("DelRemoteSubnet %s %v", vxlan.GlobalID, err)
+	}
+
+	if err = vxlan.getDevice(); err != nil {
+		return err
+	}
+
+	if vxlan.Group != nil {
+		return nil
+	}
+
+	return vxlan.delRemote(cnIP)
+}
+
+//UpdateDNSRecords adds and removes the names of tenant instances. The
+//dnsmasq of each tenant subnet resolves the names of all the instances of
+//the tenant as <name>.<domain>, as well as the reverse lookups of their
+//addresses. added maps the instance names to their addresses, one per NIC
+//of the instance, removed lists the names to delete
+func (cnci *Cnci) UpdateDNSRecords(domain string, added map[string][]net.IP, removed []string) error {
+	for name, ips := range added {
+		if name == "" || strings.ContainsAny(name, " \t\n") || len(ips) == 0 {
+			return fmt.Errorf("invalid DNS record %q %v", name, ips)
+		}
+		for _, ip := range ips {
+			if ip.To16() == nil {
+				return fmt.Errorf("invalid DNS record %q %v", name, ips)
+			}
+		}
+	}
+
+	cnci.topology.Lock()
+	defer cnci.topology.Unlock()
+	cnci.dns.Lock()
+	defer cnci.dns.Unlock()
+
+	domainChanged := cnci.dns.domain != domain
+	cnci.dns.domain = domain
+	if cnci.dns.records == nil {
+		cnci.dns.records = make(map[string][]net.IP)
+	}
+	for _, name := range removed {
+		delete(cnci.dns.records, name)
+	}
+	for name, ips := range added {
+		cnci.dns.records[name] = ips
+	}
+
+	var lasterr error
+	for _, b := range cnci.topology.bridgeMap {
+		if b.Dnsmasq == nil {
+			//The dnsmasq picks the names when it starts
+			continue
+		}
+		b.Dnsmasq.setDNSRecords(cnci.dns.domain, cnci.dns.records)
+
+		//The domain is only read by dnsmasq when it starts
+		var err error
+		if domainChanged {
+			err = b.Dnsmasq.restart()
+		} else {
+			err = b.Dnsmasq.reload()
+		}
+		if err != nil {
+			lasterr = err
+		}
+	}
+
+	return lasterr
+}
+
+//UpdateDhcpOptions replaces the DHCP options handed out to the instances
+//of a tenant subnet. The dnsmasq of the subnet is restarted if the subnet
+//is already served by this CNCI, otherwise the options are used when the
+//first compute node registers the subnet
+func (cnci *Cnci) UpdateDhcpOptions(subnet net.IPNet, options DhcpOptions) error {
+	if err := validateDhcpOptions(options); err != nil {
+		return err
+	}
+	subnet = net.IPNet{IP: subnet.IP.Mask(subnet.Mask), Mask: subnet.Mask}
+
+	cnci.topology.Lock()
+	defer cnci.topology.Unlock()
+	cnci.dhcp.Lock()
+	defer cnci.dhcp.Unlock()
+
+	if cnci.dhcp.options == nil {
+		cnci.dhcp.options = make(map[string]DhcpOptions)
+	}
+	cnci.dhcp.options[subnet.String()] = options
+
+	b, ok := cnci.topology.bridgeMap[genBridgeAlias(subnet)]
+	if !ok || b.Dnsmasq == nil {
+		return nil
+	}
+
+	if err := b.Dnsmasq.setDhcpOptions(options); err != nil {
+		return err
+	}
+
+	return b.Dnsmasq.restart()
+}
+
+//UpdateDhcpHosts adds and removes the custom MAC addresses of tenant
+//instances. The dnsmasq serving the subnet of an address only hands it out
+//to the MAC address it is bound to, which defaults to the MAC address
+//derived from the IP address. added maps the IP addresses to their custom
+//MAC address, removed lists the IP addresses that revert to their derived
+//MAC address
+func (cnci *Cnci) UpdateDhcpHosts(added map[string]net.HardwareAddr, removed []string) error {
+	for ip, mac := range added {
+		if net.ParseIP(ip).To4() == nil || len(mac) != 6 {
+			return fmt.Errorf("invalid DHCP host %q %v", ip, mac)
+		}
+	}
+
+	cnci.topology.Lock()
+	defer cnci.topology.Unlock()
+	cnci.dhcp.Lock()
+	defer cnci.dhcp.Unlock()
+
+	if cnci.dhcp.hosts == nil {
+		cnci.dhcp.hosts = make(map[string]net.HardwareAddr)
+	}
+	for _, ip := range removed {
+		delete(cnci.dhcp.hosts, ip)
+	}
+	for ip, mac := range added {
+		cnci.dhcp.hosts[ip] = mac
+	}
+
+	var lasterr error
+	for _, b := range cnci.topology.bridgeMap {
+		if b.Dnsmasq == nil {
+			//The dnsmasq picks the hosts when it starts
+			continue
+		}
+		if err := b.Dnsmasq.setDhcpHosts(cnci.dhcp.hosts); err != nil {
+			lasterr = err
+			continue
+		}
+		if err := b.Dnsmasq.reload(); err != nil {
+			lasterr = err
+		}
+	}
+
+	return lasterr
+}
+
 //Shutdown stops all DHCP Servers. Tears down all links and tunnels
 //It will continue even on encountering an error and perform as much
 //cleanup as possible
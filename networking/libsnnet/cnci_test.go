@@ -18,6 +18,7 @@ package libsnnet
 
 import (
 	"fmt"
+	"io/ioutil"
 	"net"
 	"testing"
 
@@ -107,6 +108,184 @@ func TestCNCI_Init(t *testing.T) {
 	assert.Nil(cnci.Shutdown())
 }
 
+//Tests the CNCI APIs for VXLAN subnets
+//
+//Tests adding and deleting compute nodes to a remote subnet
+//reached over VXLAN. All the compute nodes share a single
+//tunnel and are its remote end points. It also checks that
+//the topology can be rebuilt
+//
+//Test should pass ok
+func TestCNCI_Vxlan(t *testing.T) {
+	assert := assert.New(t)
+	cnci, err := cnciTestInit()
+	require.Nil(t, err)
+
+	_, tnet, _ := net.ParseCIDR("192.168.0.0/24")
+	cfg := RemoteSubnetConfig{Vxlan: true}
+	cn1 := net.ParseIP("192.168.0.102")
+	cn2 := net.ParseIP("192.168.0.103")
+	cn3 := net.ParseIP("192.168.0.104")
+
+	bridge, err := cnci.AddRemoteSubnetWithConfig(*tnet, cfg, 1234, cn1)
+	assert.Nil(err)
+	assert.NotEqual("", bridge)
+
+	//Duplicate
+	_, err = cnci.AddRemoteSubnetWithConfig(*tnet, cfg, 1234, cn1)
+	assert.Nil(err)
+
+	_, err = cnci.AddRemoteSubnetWithConfig(*tnet, cfg, 1234, cn2)
+	assert.Nil(err)
+
+	vxlan, err := newVxlanEP(genVxlanAlias(*tnet), nil, nil, 0)
+	require.Nil(t, err)
+	require.Nil(t, vxlan.getDevice())
+	assert.Equal(uint32(1234), vxlan.VNI)
+
+	remotes, err := vxlan.remotes()
+	assert.Nil(err)
+	assert.Equal(2, len(remotes))
+
+	assert.Nil(cnci.DelRemoteSubnet(*tnet, 1234, cn1))
+	remotes, err = vxlan.remotes()
+	assert.Nil(err)
+	if assert.Equal(1, len(remotes)) {
+		assert.True(remotes[0].Equal(cn2))
+	}
+
+	require.Nil(t, cnci.RebuildTopology())
+
+	_, err = cnci.AddRemoteSubnetWithConfig(*tnet, cfg, 1234, cn3)
+	assert.Nil(err)
+	remotes, err = vxlan.remotes()
+	assert.Nil(err)
+	assert.Equal(2, len(remotes))
+
+	assert.Nil(cnci.DelRemoteSubnet(*tnet, 1234, cn2))
+	assert.Nil(cnci.DelRemoteSubnet(*tnet, 1234, cn3))
+
+	//Duplicate
+	assert.Nil(cnci.DelRemoteSubnet(*tnet, 1234, cn3))
+
+	assert.Nil(cnci.Shutdown())
+}
+
+//Tests the CNCI DNS records API
+//
+//Tests that the names of the tenant instances are served by the
+//dnsmasq of every tenant subnet, including the subnets added after
+//the names, and that invalid records are rejected
+//
+//Test should pass ok
+func TestCNCI_DNS(t *testing.T) {
+	assert := assert.New(t)
+	cnci, err := cnciTestInit()
+	require.Nil(t, err)
+
+	_, tnet1, _ := net.ParseCIDR("192.168.0.0/24")
+	_, tnet2, _ := net.ParseCIDR("192.168.1.0/24")
+	cfg := RemoteSubnetConfig{Vxlan: true}
+	cnIP := net.ParseIP("192.168.0.102")
+
+	_, err = cnci.AddRemoteSubnetWithConfig(*tnet1, cfg, 1234, cnIP)
+	assert.Nil(err)
+
+	added := map[string][]net.IP{
+		"instance1": {net.ParseIP("192.168.0.5")},
+		"instance2": {net.ParseIP("192.168.1.5"), net.ParseIP("192.168.0.6")},
+	}
+	assert.Nil(cnci.UpdateDNSRecords("tenant.ciao", added, nil))
+
+	_, err = cnci.AddRemoteSubnetWithConfig(*tnet2, cfg, 1235, cnIP)
+	assert.Nil(err)
+
+	assert.Nil(cnci.UpdateDNSRecords("tenant.ciao", nil, []string{"instance1"}))
+
+	cnci.topology.Lock()
+	for _, b := range cnci.topology.bridgeMap {
+		records, err := ioutil.ReadFile(b.Dnsmasq.dnsFile)
+		if assert.Nil(err) {
+			assert.Equal("192.168.1.5 instance2.tenant.ciao instance2\n"+
+				"192.168.0.6 instance2.tenant.ciao instance2\n", string(records))
+		}
+	}
+	cnci.topology.Unlock()
+
+	invalid := map[string][]net.IP{"instance 3": {net.ParseIP("192.168.0.7")}}
+	assert.NotNil(cnci.UpdateDNSRecords("tenant.ciao", invalid, nil))
+
+	invalid = map[string][]net.IP{"instance3": {net.ParseIP("192.168.0.7"), nil}}
+	assert.NotNil(cnci.UpdateDNSRecords("tenant.ciao", invalid, nil))
+
+	assert.Nil(cnci.DelRemoteSubnet(*tnet1, 1234, cnIP))
+	assert.Nil(cnci.DelRemoteSubnet(*tnet2, 1235, cnIP))
+	assert.Nil(cnci.Shutdown())
+}
+
+//Tests the CNCI DHCP options API
+//
+//Tests that the DHCP options of a subnet are used by its dnsmasq,
+//whether they are set before or after the subnet is added, that the
+//custom MAC addresses reach the dnsmasq of their subnet, and that
+//invalid options are rejected
+//
+//Test should pass ok
+func TestCNCI_DhcpOptions(t *testing.T) {
+	assert := assert.New(t)
+	cnci, err := cnciTestInit()
+	require.Nil(t, err)
+
+	_, tnet1, _ := net.ParseCIDR("192.168.0.0/24")
+	_, tnet2, _ := net.ParseCIDR("192.168.1.0/24")
+	cfg := RemoteSubnetConfig{Vxlan: true}
+	cnIP := net.ParseIP("192.168.0.102")
+
+	_, err = cnci.AddRemoteSubnetWithConfig(*tnet1, cfg, 1234, cnIP)
+	assert.Nil(err)
+
+	options := DhcpOptions{NTPServers: []net.IP{net.ParseIP("192.168.0.253")}}
+	assert.Nil(cnci.UpdateDhcpOptions(*tnet1, options))
+	assert.Nil(cnci.UpdateDhcpOptions(*tnet2, options))
+
+	_, err = cnci.AddRemoteSubnetWithConfig(*tnet2, cfg, 1235, cnIP)
+	assert.Nil(err)
+
+	cnci.topology.Lock()
+	for _, b := range cnci.topology.bridgeMap {
+		conf, err := ioutil.ReadFile(b.Dnsmasq.confFile)
+		if assert.Nil(err) {
+			assert.Contains(string(conf), "dhcp-option=option:ntp-server,192.168.0.253\n")
+		}
+	}
+	cnci.topology.Unlock()
+
+	invalid := DhcpOptions{DNSServers: []net.IP{net.ParseIP("fd00::1")}}
+	assert.NotNil(cnci.UpdateDhcpOptions(*tnet1, invalid))
+
+	mac, _ := net.ParseMAC("52:54:00:12:34:56")
+	assert.Nil(cnci.UpdateDhcpHosts(map[string]net.HardwareAddr{"192.168.1.5": mac}, nil))
+	assert.NotNil(cnci.UpdateDhcpHosts(map[string]net.HardwareAddr{"fd00::5": mac}, nil))
+
+	cnci.topology.Lock()
+	for _, b := range cnci.topology.bridgeMap {
+		hosts, err := ioutil.ReadFile(b.Dnsmasq.hostsFile)
+		if !assert.Nil(err) {
+			continue
+		}
+		if b.Dnsmasq.TenantNet.String() == tnet2.String() {
+			assert.Contains(string(hosts), "52:54:00:12:34:56,192.168.1.5,id:*\n")
+		} else {
+			assert.NotContains(string(hosts), "52:54:00:12:34:56")
+		}
+	}
+	cnci.topology.Unlock()
+
+	assert.Nil(cnci.DelRemoteSubnet(*tnet1, 1234, cnIP))
+	assert.Nil(cnci.DelRemoteSubnet(*tnet2, 1235, cnIP))
+	assert.Nil(cnci.Shutdown())
+}
+
 //Whitebox test case of CNCI API primitives
 //
 //This tests ensure that the lower level primitive
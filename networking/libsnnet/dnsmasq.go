@@ -35,6 +35,7 @@ const (
 	leasePath  = "/tmp/"
 	configPath = "/tmp/"
 	hostsPath  = "/tmp/"
+	dnsPath    = "/tmp/"
 	MACPrefix  = "02:00" //Prefix for all private MAC addresses
 //	CONFIG_PATH = "/etc/"
 //	PID_PATH = "/var/run/"
@@ -42,30 +43,52 @@ const (
 
 //TODO: Set these up above to correct defaults
 
+// DhcpRoute is a static route handed out to the instances of a subnet
+type DhcpRoute struct {
+	Destination net.IPNet // The IPv4 subnet reached through the route
+	Gateway     net.IP    // The next hop, has to be reachable from the subnet
+}
+
+// DhcpOptions are the DHCP options handed out to the instances of a
+// subnet, in addition to their address, gateway and MTU. Empty options
+// are not sent.
+type DhcpOptions struct {
+	DNSServers    []net.IP    // Replaces dnsmasq itself as the DNS server
+	SearchDomains []string    // The domains appended to unqualified names
+	Routes        []DhcpRoute // Static routes besides the default route
+	NTPServers    []net.IP
+}
+
 // Dnsmasq contains all the information required to spawn
 // a dnsmasq process on behalf of a tenant on a concentrator
 type Dnsmasq struct {
-	SubnetID    string                // UUID of the Tenant Subnet to which the  dnsmasq supports
-	CNCIId      string                // UUID of the CNCI instance
-	TenantID    string                // UUID of the Tenant to which the CNCI belongs to
-	TenantNet   net.IPNet             // The tenant subnet served by this dnsmasq, has to be /29 or larger
-	ReservedIPs int                   // Reserve IP at the start of subnet
-	ConcIP      net.IP                // IP Address of the CNCI
-	IPMap       map[string]*DhcpEntry // Static mac to IP map, key is macaddress
-	Dev         *Bridge               // The bridge on which dnsmasq will attach
-	MTU         int                   // MTU that takes into account the tunnel overhead
-	DomainName  string                // Domain Name to be assigned to the subnet
+	SubnetID    string                      // UUID of the Tenant Subnet to which the  dnsmasq supports
+	CNCIId      string                      // UUID of the CNCI instance
+	TenantID    string                      // UUID of the Tenant to which the CNCI belongs to
+	TenantNet   net.IPNet                   // The tenant subnet served by this dnsmasq, has to be /29 or larger
+	ReservedIPs int                         // Reserve IP at the start of subnet
+	ConcIP      net.IP                      // IP Address of the CNCI
+	IPMap       map[string]*DhcpEntry       // Static mac to IP map, key is macaddress
+	Dev         *Bridge                     // The bridge on which dnsmasq will attach
+	MTU         int                         // MTU that takes into account the tunnel overhead
+	DomainName  string                      // Domain Name to be assigned to the subnet
+	TenantNetV6 *net.IPNet                  // Optional IPv6 /64 of a dual stack subnet, addresses are assigned via SLAAC
+	DNSRecords  map[string][]net.IP         // Instance names resolved under DomainName, key is the instance name
+	Options     DhcpOptions                 // Additional DHCP options handed out to the instances
+	DhcpHosts   map[string]net.HardwareAddr // Custom MAC addresses of instances, key is the IP address
 
 	// Private fields
 	dhcpSize  int
 	subnet    net.IP    // The DHCP addresses will be served from this subnet
 	gateway   net.IPNet // The address of the bridge. Will also be default gw to the instances
+	gatewayV6 net.IPNet // The IPv6 address of the bridge, advertised as the default router
 	startIP   net.IP    // First address in the DHCP range Skipping ReservedIPs
 	endIP     net.IP    // Last address in the DHCP range excluding broadcast
 	confFile  string
 	pidFile   string
 	leaseFile string
 	hostsFile string
+	dnsFile   string
 }
 
 // NewDnsmasq initializes a new dnsmasq instance and attaches it to the specified bridge
@@ -111,6 +134,10 @@ func (d *Dnsmasq) start() error {
 		return fmt.Errorf("d.createHostsFile failed %v", err)
 	}
 
+	if err := d.createDNSFile(); err != nil {
+		return fmt.Errorf("d.createDNSFile failed %v", err)
+	}
+
 	if err := d.Dev.AddIP(&d.gateway); err != nil {
 		_ = d.Dev.DelIP(&d.gateway) //TODO: check it already has the IP
 		if err = d.Dev.AddIP(&d.gateway); err != nil {
@@ -118,6 +145,15 @@ func (d *Dnsmasq) start() error {
 		}
 	}
 
+	if d.TenantNetV6 != nil {
+		if err := d.Dev.AddIP(&d.gatewayV6); err != nil {
+			_ = d.Dev.DelIP(&d.gatewayV6)
+			if err = d.Dev.AddIP(&d.gatewayV6); err != nil {
+				return fmt.Errorf("d.Dev.AddIP failed %v %v", err, d.gatewayV6.String())
+			}
+		}
+	}
+
 	if err := d.launch(); err != nil {
 		return fmt.Errorf("d.launch failed %v", err)
 	}
@@ -165,12 +201,19 @@ func (d *Dnsmasq) stop() error {
 		cumError = append(cumError, fmt.Errorf("Unable to delete bridge IP %v", err))
 	}
 
+	if d.TenantNetV6 != nil {
+		if err = d.Dev.DelIP(&d.gatewayV6); err != nil {
+			cumError = append(cumError, fmt.Errorf("Unable to delete bridge IPv6 %v", err))
+		}
+	}
+
 	if err = os.Remove(d.confFile); err != nil {
 		cumError = append(cumError, fmt.Errorf("Unable to delete file %v %v", d.confFile, err))
 	}
 	if err = os.Remove(d.hostsFile); err != nil {
 		cumError = append(cumError, fmt.Errorf("Unable to delete file %v %v", d.hostsFile, err))
 	}
+	_ = os.Remove(d.dnsFile)
 	_ = os.Remove(d.leaseFile)
 
 	if cumError != nil {
@@ -214,6 +257,9 @@ func (d *Dnsmasq) reload() error {
 	if err = d.createHostsFile(); err != nil {
 		return fmt.Errorf("Unable to delete hosts file %v", err)
 	}
+	if err = d.createDNSFile(); err != nil {
+		return fmt.Errorf("Unable to create DNS file %v", err)
+	}
 	if err = syscall.Kill(pid, syscall.SIGHUP); err != nil {
 		return fmt.Errorf("Unable to reload/SIGHUP dnsmasq %v", err)
 	}
@@ -229,6 +275,135 @@ func (d *Dnsmasq) addDhcpEntry(entry *DhcpEntry) error {
 	return nil
 }
 
+// setDNSRecords replaces the instance names served by this dnsmasq.
+// Reload() has to be invoked to serve the new names if the service is
+// already running, the domain however only changes on restart
+func (d *Dnsmasq) setDNSRecords(domain string, records map[string][]net.IP) {
+	d.DomainName = domain
+	d.DNSRecords = make(map[string][]net.IP, len(records))
+	for name, ips := range records {
+		d.DNSRecords[name] = ips
+	}
+}
+
+// setDhcpHosts replaces the custom MAC addresses of the instances. The
+// addresses of the subnet bound to a custom MAC are no longer handed out
+// to their derived MAC. Reload() has to be invoked to activate the
+// entries if the service is already running
+func (d *Dnsmasq) setDhcpHosts(hosts map[string]net.HardwareAddr) error {
+	d.DhcpHosts = make(map[string]net.HardwareAddr, len(hosts))
+	for ip, mac := range hosts {
+		d.DhcpHosts[ip] = mac
+	}
+	return d.getSubnetConfiguration()
+}
+
+// validateDhcpOptions checks that the options can be rendered in the
+// dnsmasq configuration. Only IPv4 addresses can be handed out by DHCP
+func validateDhcpOptions(options DhcpOptions) error {
+	for _, servers := range [][]net.IP{options.DNSServers, options.NTPServers} {
+		for _, ip := range servers {
+			if ip.To4() == nil {
+				return fmt.Errorf("invalid DHCP server option %v", ip)
+			}
+		}
+	}
+
+	for _, domain := range options.SearchDomains {
+		if domain == "" || strings.ContainsAny(domain, " \t\n,") {
+			return fmt.Errorf("invalid search domain %q", domain)
+		}
+	}
+
+	for _, r := range options.Routes {
+		if r.Destination.IP.To4() == nil || r.Gateway.To4() == nil {
+			return fmt.Errorf("invalid route %s via %v", r.Destination.String(), r.Gateway)
+		}
+	}
+
+	return nil
+}
+
+// setDhcpOptions replaces the DHCP options handed out by this dnsmasq.
+// The options are only read when the service starts
+func (d *Dnsmasq) setDhcpOptions(options DhcpOptions) error {
+	if err := validateDhcpOptions(options); err != nil {
+		return err
+	}
+
+	d.Options = DhcpOptions{
+		DNSServers:    append([]net.IP(nil), options.DNSServers...),
+		SearchDomains: append([]string(nil), options.SearchDomains...),
+		Routes:        append([]DhcpRoute(nil), options.Routes...),
+		NTPServers:    append([]net.IP(nil), options.NTPServers...),
+	}
+
+	return nil
+}
+
+//dhcpOptionParams renders the DHCP options of the subnet as dnsmasq
+//configuration parameters
+func (d *Dnsmasq) dhcpOptionParams() []string {
+	var params []string
+
+	ipList := func(ips []net.IP) string {
+		s := make([]string, 0, len(ips))
+		for _, ip := range ips {
+			s = append(s, ip.String())
+		}
+		return strings.Join(s, ",")
+	}
+
+	if len(d.Options.DNSServers) > 0 {
+		params = append(params, fmt.Sprintf("dhcp-option=option:dns-server,%s\n", ipList(d.Options.DNSServers)))
+	}
+	if len(d.Options.SearchDomains) > 0 {
+		params = append(params, fmt.Sprintf("dhcp-option=option:domain-search,%s\n", strings.Join(d.Options.SearchDomains, ",")))
+	}
+	if len(d.Options.Routes) > 0 {
+		//Clients ignore the router option when they are sent classless
+		//routes, so the default route has to be part of them
+		var routes []string
+		hasDefault := false
+		for _, r := range d.Options.Routes {
+			if ones, _ := r.Destination.Mask.Size(); ones == 0 {
+				hasDefault = true
+			}
+			routes = append(routes, r.Destination.String(), r.Gateway.String())
+		}
+		if !hasDefault {
+			routes = append(routes, "0.0.0.0/0", d.gateway.IP.String())
+		}
+		params = append(params, fmt.Sprintf("dhcp-option=option:classless-static-route,%s\n", strings.Join(routes, ",")))
+	}
+	if len(d.Options.NTPServers) > 0 {
+		params = append(params, fmt.Sprintf("dhcp-option=option:ntp-server,%s\n", ipList(d.Options.NTPServers)))
+	}
+
+	return params
+}
+
+// setSubnetV6 makes the subnet served by this dnsmasq dual stack. The
+// bridge gets the first address of the /64 and dnsmasq advertises the
+// prefix, instances pick their address with SLAAC and other settings
+// with stateless DHCPv6.
+func (d *Dnsmasq) setSubnetV6(subnet net.IPNet) error {
+	ones, bits := subnet.Mask.Size()
+	if bits != 128 || ones != 64 || subnet.IP.To4() != nil {
+		return fmt.Errorf("invalid IPv6 subnet %s", subnet.String())
+	}
+
+	prefix := subnet.IP.Mask(subnet.Mask)
+	d.TenantNetV6 = &net.IPNet{IP: prefix, Mask: subnet.Mask}
+
+	d.gatewayV6.IP = make(net.IP, net.IPv6len)
+	copy(d.gatewayV6.IP, prefix)
+	d.gatewayV6.IP[net.IPv6len-1] = 1
+	d.gatewayV6.Mask = subnet.Mask
+
+	return nil
+}
+
 // Populates the file specific private variables
 func (d *Dnsmasq) getFileConfiguration() error {
 
@@ -240,6 +415,7 @@ func (d *Dnsmasq) getFileConfiguration() error {
 	d.confFile = fmt.Sprintf("%sdnsmasq_%s.conf", configPath, d.SubnetID)
 	d.leaseFile = fmt.Sprintf("%sdnsmasq_%s.leases", leasePath, d.SubnetID)
 	d.hostsFile = fmt.Sprintf("%sdnsmasq_%s.hosts", hostsPath, d.SubnetID)
+	d.dnsFile = fmt.Sprintf("%sdnsmasq_%s.dns", dnsPath, d.SubnetID)
 
 	return nil
 }
@@ -270,34 +446,54 @@ func (d *Dnsmasq) getSubnetConfiguration() error {
 		return fmt.Errorf("invalid subnet")
 	}
 
-	d.gateway.IP = d.TenantNet.IP.To4().Mask(d.TenantNet.Mask)
+	//The gateway defaults to the first address after the network address
+	if d.gateway.IP == nil {
+		d.gateway.IP = d.TenantNet.IP.To4().Mask(d.TenantNet.Mask)
+		d.gateway.IP[3]++
+	}
 	d.gateway.Mask = d.TenantNet.Mask
-	d.startIP = d.TenantNet.IP.To4().Mask(d.TenantNet.Mask)
-	d.endIP = d.TenantNet.IP.To4().Mask(d.TenantNet.Mask)
-	//End Hack
-
-	//Skip the network address
-	d.gateway.IP[3]++
 
-	//Designate the first IP after network, gateway and reserved range
-	startU32 := binary.BigEndian.Uint32(d.startIP)
-	startU32 += uint32(2 + d.ReservedIPs)
-	binary.BigEndian.PutUint32(d.startIP, startU32)
+	return d.genDhcpEntries(subnetSize)
+}
 
-	endU32 := binary.BigEndian.Uint32(d.endIP)
-	endU32 += startU32 + uint32(d.dhcpSize)
-	binary.BigEndian.PutUint32(d.endIP, endU32)
+//genDhcpEntries pre-assigns a MAC address to every address of the subnet,
+//the derived one or the custom one from DhcpHosts, except the network, gateway and broadcast addresses and the ReservedIPs
+//addresses at the start of the subnet
+func (d *Dnsmasq) genDhcpEntries(subnetSize int) error {
+	d.IPMap = make(map[string]*DhcpEntry)
+
+	networkU32 := binary.BigEndian.Uint32(d.subnet)
+	gatewayU32 := binary.BigEndian.Uint32(d.gateway.IP.To4())
+	reserved := d.ReservedIPs
+
+	d.startIP = nil
+	for i := 1; i < subnetSize-1; i++ {
+		ipU32 := networkU32 + uint32(i)
+		if ipU32 == gatewayU32 {
+			continue
+		}
+		if reserved > 0 {
+			reserved--
+			continue
+		}
 
-	//Generate all valid IPs in this subnet and pre-assign a MAC address
-	for i := 0; i < d.dhcpSize; i++ {
 		vIP := make(net.IP, net.IPv4len)
-		binary.BigEndian.PutUint32(vIP, startU32+uint32(i))
+		binary.BigEndian.PutUint32(vIP, ipU32)
+		if d.startIP == nil {
+			d.startIP = vIP
+		}
+		d.endIP = vIP
 
 		//last 4 bytes will directly map to the desired IP address
-		macStr := fmt.Sprintf("%s:%02x:%02x:%02x:%02x", MACPrefix, vIP[0], vIP[1], vIP[2], vIP[3])
-		macAddr, err := net.ParseMAC(macStr)
-		if err != nil {
-			return err
+		//unless the instance was created with a custom MAC
+		macAddr, ok := d.DhcpHosts[vIP.String()]
+		if !ok {
+			macStr := fmt.Sprintf("%s:%02x:%02x:%02x:%02x", MACPrefix, vIP[0], vIP[1], vIP[2], vIP[3])
+			var err error
+			macAddr, err = net.ParseMAC(macStr)
+			if err != nil {
+				return err
+			}
 		}
 
 		dhcpEntry := &DhcpEntry{
@@ -313,6 +509,30 @@ func (d *Dnsmasq) getSubnetConfiguration() error {
 	return nil
 }
 
+//setGateway replaces the default gateway of the subnet served by this
+//dnsmasq. The bridge is assigned the gateway address, which dnsmasq
+//advertises as the default router of the instances
+func (d *Dnsmasq) setGateway(gateway net.IP) error {
+	gw := gateway.To4()
+	if gw == nil || !d.TenantNet.Contains(gw) {
+		return fmt.Errorf("invalid gateway %v for subnet %s", gateway, d.TenantNet.String())
+	}
+
+	ones, _ := d.TenantNet.Mask.Size()
+	subnetSize := ^(^0 << uint32(32-ones)) + 1
+
+	gwU32 := binary.BigEndian.Uint32(gw)
+	networkU32 := binary.BigEndian.Uint32(d.subnet)
+	if gwU32 == networkU32 || gwU32 == networkU32+uint32(subnetSize-1) {
+		return fmt.Errorf("invalid gateway %v for subnet %s", gateway, d.TenantNet.String())
+	}
+
+	d.gateway.IP = make(net.IP, net.IPv4len)
+	copy(d.gateway.IP, gw)
+
+	return d.genDhcpEntries(subnetSize)
+}
+
 func (d *Dnsmasq) createHostsFile() error {
 	file, err := os.Create(d.hostsFile)
 	if err != nil {
@@ -334,6 +554,31 @@ func (d *Dnsmasq) createHostsFile() error {
 	return file.Sync()
 }
 
+//createDNSFile writes the A and PTR records of the instances as an
+//additional hosts file. The fully qualified name comes first so that
+//reverse lookups return it
+func (d *Dnsmasq) createDNSFile() error {
+	file, err := os.Create(d.dnsFile)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = file.Close() }()
+
+	for name, ips := range d.DNSRecords {
+		for _, ip := range ips {
+			s := fmt.Sprintf("%s %s\n", ip, name)
+			if d.DomainName != "" {
+				s = fmt.Sprintf("%s %s.%s %s\n", ip, name, d.DomainName, name)
+			}
+			if _, err := file.WriteString(s); err != nil {
+				return err
+			}
+		}
+	}
+
+	return file.Sync()
+}
+
 func (d *Dnsmasq) createConfigFile() error {
 	params := make([]string, 20)
 
@@ -350,8 +595,10 @@ func (d *Dnsmasq) createConfigFile() error {
 	params = append(params, fmt.Sprintf("dhcp-hostsfile=%s\n", d.hostsFile))
 	//params = append(params, "strict-order\n")
 	//params = append(params, "expand-hosts\n")
+	params = append(params, fmt.Sprintf("addn-hosts=%s\n", d.dnsFile))
 	if d.DomainName != "" {
-		params = append(params, "domain=%s\n", d.DomainName)
+		params = append(params, fmt.Sprintf("domain=%s\n", d.DomainName))
+		params = append(params, fmt.Sprintf("local=/%s/\n", d.DomainName))
 	}
 	params = append(params, "domain-needed\n")
 	params = append(params, "bogus-priv\n")
@@ -364,6 +611,13 @@ func (d *Dnsmasq) createConfigFile() error {
 	params = append(params, fmt.Sprintf("dhcp-range=%s,static\n", d.subnet.String()))
 	params = append(params, fmt.Sprintf("dhcp-lease-max=%d\n", d.dhcpSize))
 	params = append(params, fmt.Sprintf("dhcp-option-force=26,%d\n", d.MTU))
+	params = append(params, d.dhcpOptionParams()...)
+	if d.TenantNetV6 != nil {
+		params = append(params, "enable-ra\n")
+		params = append(params, fmt.Sprintf("listen-address=%s\n", d.gatewayV6.IP.String()))
+		params = append(params, fmt.Sprintf("dhcp-range=%s,ra-stateless,64\n", d.TenantNetV6.IP.String()))
+		params = append(params, fmt.Sprintf("ra-param=%s,mtu:%d,60\n", d.Dev.LinkName, d.MTU))
+	}
 	//params = append(params, "log-dhcp\n")
 
 	file, err := os.Create(d.confFile)
@@ -81,6 +81,258 @@ func TestDnsmasq_Basic(t *testing.T) {
 
 }
 
+//Test DHCP/DNS server setup for a dual stack subnet
+//
+//This test checks that only IPv6 /64 subnets are accepted and
+//that the IPv6 subnet is advertised by the dnsmasq serving the
+//bridge
+//
+//Test is expected to pass
+func TestDnsmasq_DualStack(t *testing.T) {
+	assert := assert.New(t)
+
+	subnet := net.IPNet{
+		IP:   net.IPv4(192, 168, 1, 0),
+		Mask: net.IPv4Mask(255, 255, 255, 0),
+	}
+	_, subnetV6, _ := net.ParseCIDR("fd00:1:2:3::/64")
+	_, prefix, _ := net.ParseCIDR("fd00:1:2::/48")
+
+	bridge, _ := NewBridge("dns_testbr")
+
+	err := bridge.Create()
+	assert.Nil(err)
+	defer func() { _ = bridge.Destroy() }()
+
+	d, err := newDnsmasq("concuuid", "tenantuuid", subnet, 0, bridge)
+	assert.Nil(err)
+
+	assert.NotNil(d.setSubnetV6(*prefix))
+	assert.NotNil(d.setSubnetV6(subnet))
+	assert.Nil(d.setSubnetV6(*subnetV6))
+	assert.Equal("fd00:1:2:3::1/64", d.gatewayV6.String())
+
+	err = d.start()
+	assert.Nil(err)
+
+	conf, err := ioutil.ReadFile(d.confFile)
+	if assert.Nil(err) {
+		assert.Contains(string(conf), "enable-ra\n")
+		assert.Contains(string(conf), "dhcp-range=fd00:1:2:3::,ra-stateless,64\n")
+	}
+
+	err = d.stop()
+	assert.Nil(err)
+}
+
+//Test DHCP/DNS server setup for a subnet with a tenant chosen gateway
+//
+//This test checks that the gateway must be a host address of the
+//subnet and that it is never handed out to an instance
+//
+//Test is expected to pass
+func TestDnsmasq_Gateway(t *testing.T) {
+	assert := assert.New(t)
+
+	subnet := net.IPNet{
+		IP:   net.IPv4(10, 10, 0, 0),
+		Mask: net.IPv4Mask(255, 255, 255, 248),
+	}
+
+	bridge, _ := NewBridge("dns_testbr")
+
+	err := bridge.Create()
+	assert.Nil(err)
+	defer func() { _ = bridge.Destroy() }()
+
+	d, err := newDnsmasq("concuuid", "tenantuuid", subnet, 0, bridge)
+	assert.Nil(err)
+
+	assert.NotNil(d.setGateway(net.ParseIP("10.10.0.0")))
+	assert.NotNil(d.setGateway(net.ParseIP("10.10.0.7")))
+	assert.NotNil(d.setGateway(net.ParseIP("10.10.1.1")))
+	assert.Nil(d.setGateway(net.ParseIP("10.10.0.6")))
+
+	assert.Equal("10.10.0.6/29", d.gateway.String())
+	assert.Equal(5, len(d.IPMap))
+	for _, e := range d.IPMap {
+		assert.False(e.IPAddr.Equal(d.gateway.IP))
+	}
+	assert.Equal("10.10.0.1", d.startIP.String())
+	assert.Equal("10.10.0.5", d.endIP.String())
+
+	err = d.start()
+	assert.Nil(err)
+
+	err = d.stop()
+	assert.Nil(err)
+}
+
+//Test DNS records of tenant instances
+//
+//This test checks that the instance names are served under the
+//domain of the tenant, with the fully qualified name first so that
+//reverse lookups return it, and that reload updates the names
+//
+//Test is expected to pass
+func TestDnsmasq_DNS(t *testing.T) {
+	assert := assert.New(t)
+
+	subnet := net.IPNet{
+		IP:   net.IPv4(192, 168, 1, 0),
+		Mask: net.IPv4Mask(255, 255, 255, 0),
+	}
+
+	bridge, _ := NewBridge("dns_testbr")
+
+	err := bridge.Create()
+	assert.Nil(err)
+	defer func() { _ = bridge.Destroy() }()
+
+	d, err := newDnsmasq("concuuid", "tenantuuid", subnet, 0, bridge)
+	assert.Nil(err)
+
+	d.setDNSRecords("tenant.ciao", map[string][]net.IP{
+		"instance1": {net.ParseIP("192.168.1.5")},
+	})
+
+	err = d.start()
+	assert.Nil(err)
+
+	conf, err := ioutil.ReadFile(d.confFile)
+	if assert.Nil(err) {
+		assert.Contains(string(conf), "addn-hosts="+d.dnsFile+"\n")
+		assert.Contains(string(conf), "domain=tenant.ciao\n")
+		assert.Contains(string(conf), "local=/tenant.ciao/\n")
+	}
+
+	records, err := ioutil.ReadFile(d.dnsFile)
+	if assert.Nil(err) {
+		assert.Equal("192.168.1.5 instance1.tenant.ciao instance1\n", string(records))
+	}
+
+	d.setDNSRecords("tenant.ciao", map[string][]net.IP{
+		"instance2": {net.ParseIP("192.168.1.6"), net.ParseIP("10.0.0.6")},
+	})
+	assert.Nil(d.reload())
+
+	records, err = ioutil.ReadFile(d.dnsFile)
+	if assert.Nil(err) {
+		assert.Equal("192.168.1.6 instance2.tenant.ciao instance2\n"+
+			"10.0.0.6 instance2.tenant.ciao instance2\n", string(records))
+	}
+
+	err = d.stop()
+	assert.Nil(err)
+}
+
+//Test custom MAC addresses of tenant instances
+//
+//This test checks that an address bound to a custom MAC address is
+//only handed out to that MAC address, that the binding survives a
+//change of gateway and that removing it restores the derived MAC
+//
+//Test is expected to pass
+func TestDnsmasq_DhcpHosts(t *testing.T) {
+	assert := assert.New(t)
+
+	subnet := net.IPNet{
+		IP:   net.IPv4(192, 168, 1, 0),
+		Mask: net.IPv4Mask(255, 255, 255, 0),
+	}
+
+	bridge, _ := NewBridge("dns_testbr")
+
+	err := bridge.Create()
+	assert.Nil(err)
+	defer func() { _ = bridge.Destroy() }()
+
+	d, err := newDnsmasq("concuuid", "tenantuuid", subnet, 0, bridge)
+	assert.Nil(err)
+
+	mac, _ := net.ParseMAC("52:54:00:12:34:56")
+	assert.Nil(d.setDhcpHosts(map[string]net.HardwareAddr{
+		"192.168.1.5":  mac,
+		"192.168.10.5": mac,
+	}))
+	assert.Nil(d.setGateway(net.ParseIP("192.168.1.254")))
+	assert.Equal(253, len(d.IPMap))
+
+	err = d.start()
+	assert.Nil(err)
+
+	hosts, err := ioutil.ReadFile(d.hostsFile)
+	if assert.Nil(err) {
+		assert.Contains(string(hosts), "52:54:00:12:34:56,192.168.1.5,id:*\n")
+		assert.NotContains(string(hosts), "02:00:c0:a8:01:05,")
+		assert.Contains(string(hosts), "02:00:c0:a8:01:06,192.168.1.6,id:*\n")
+	}
+
+	assert.Nil(d.setDhcpHosts(nil))
+	assert.Nil(d.reload())
+
+	hosts, err = ioutil.ReadFile(d.hostsFile)
+	if assert.Nil(err) {
+		assert.NotContains(string(hosts), "52:54:00:12:34:56")
+		assert.Contains(string(hosts), "02:00:c0:a8:01:05,192.168.1.5,id:*\n")
+	}
+
+	err = d.stop()
+	assert.Nil(err)
+}
+
+//Test DHCP options of a subnet
+//
+//This test checks that the options of the subnet are rendered in the
+//dnsmasq configuration, that the default route is added to the static
+//routes and that invalid options are rejected
+//
+//Test is expected to pass
+func TestDnsmasq_DhcpOptions(t *testing.T) {
+	assert := assert.New(t)
+
+	subnet := net.IPNet{
+		IP:   net.IPv4(192, 168, 1, 0),
+		Mask: net.IPv4Mask(255, 255, 255, 0),
+	}
+
+	bridge, _ := NewBridge("dns_testbr")
+
+	err := bridge.Create()
+	assert.Nil(err)
+	defer func() { _ = bridge.Destroy() }()
+
+	d, err := newDnsmasq("concuuid", "tenantuuid", subnet, 0, bridge)
+	assert.Nil(err)
+
+	_, dest, _ := net.ParseCIDR("10.0.0.0/8")
+	options := DhcpOptions{
+		DNSServers:    []net.IP{net.ParseIP("8.8.8.8"), net.ParseIP("8.8.4.4")},
+		SearchDomains: []string{"example.com", "ciao"},
+		Routes:        []DhcpRoute{{Destination: *dest, Gateway: net.ParseIP("192.168.1.254")}},
+		NTPServers:    []net.IP{net.ParseIP("192.168.1.253")},
+	}
+	assert.Nil(d.setDhcpOptions(options))
+
+	err = d.start()
+	assert.Nil(err)
+
+	conf, err := ioutil.ReadFile(d.confFile)
+	if assert.Nil(err) {
+		assert.Contains(string(conf), "dhcp-option=option:dns-server,8.8.8.8,8.8.4.4\n")
+		assert.Contains(string(conf), "dhcp-option=option:domain-search,example.com,ciao\n")
+		assert.Contains(string(conf), "dhcp-option=option:classless-static-route,10.0.0.0/8,192.168.1.254,0.0.0.0/0,192.168.1.1\n")
+		assert.Contains(string(conf), "dhcp-option=option:ntp-server,192.168.1.253\n")
+	}
+
+	err = d.stop()
+	assert.Nil(err)
+
+	assert.NotNil(d.setDhcpOptions(DhcpOptions{DNSServers: []net.IP{net.ParseIP("fd00::1")}}))
+	assert.NotNil(d.setDhcpOptions(DhcpOptions{SearchDomains: []string{"a,b"}}))
+	assert.NotNil(d.setDhcpOptions(DhcpOptions{Routes: []DhcpRoute{{Destination: *dest}}}))
+}
+
 //Dnsmasq negative test cases
 //
 //Tests that error conditions are handled gracefully
@@ -22,6 +22,7 @@ import (
 	"os"
 	"os/exec"
 	"strconv"
+	"strings"
 
 	"github.com/coreos/go-iptables/iptables"
 	"github.com/vishvananda/netlink"
@@ -67,7 +68,25 @@ Routing decision                                                  |
 */
 
 const (
-	procIPFwd = "/proc/sys/net/ipv4/ip_forward"
+	procIPFwd        = "/proc/sys/net/ipv4/ip_forward"
+	procIP6Fwd       = "/proc/sys/net/ipv6/conf/all/forwarding"
+	procBridgeNFIPT  = "/proc/sys/net/bridge/bridge-nf-call-iptables"
+	procBridgeNFIP6T = "/proc/sys/net/bridge/bridge-nf-call-ip6tables"
+)
+
+const (
+	//securityChain holds one jump per filtered instance IP
+	securityChain = "ciao-security-groups"
+	//securityChainPrefix prefixes the per instance chains
+	securityChainPrefix = "ciao-sg-"
+	//securityChainUpdatePrefix prefixes the chains the new rules of an
+	//instance are built in before they replace its current chain. It
+	//has the length of securityChainPrefix to keep within the chain
+	//name limit
+	securityChainUpdatePrefix = "ciao-su-"
+	//isolationChain drops traffic between the subnets of different
+	//tenant networks
+	isolationChain = "ciao-network-isolation"
 )
 
 //FwAction defines firewall action to be performed
@@ -80,32 +99,182 @@ const (
 	FwEnable
 )
 
+//FwBackend defines the packet filtering framework used by a firewall
+type FwBackend int
+
+const (
+	//FwAuto uses iptables if it is available on the node, nftables otherwise
+	FwAuto FwBackend = iota
+	//FwIPTables uses iptables and ip6tables
+	FwIPTables
+	//FwNFTables uses nftables
+	FwNFTables
+)
+
+func (b FwBackend) String() string {
+	switch b {
+	case FwAuto:
+		return "auto"
+	case FwIPTables:
+		return "iptables"
+	case FwNFTables:
+		return "nftables"
+	}
+
+	return ""
+}
+
+//ParseFwBackend returns the firewall backend named s, i.e.
+//auto, iptables or nftables
+func ParseFwBackend(s string) (FwBackend, error) {
+	for _, b := range []FwBackend{FwAuto, FwIPTables, FwNFTables} {
+		if s == b.String() {
+			return b, nil
+		}
+	}
+
+	return FwAuto, fmt.Errorf("invalid firewall backend %q", s)
+}
+
+//fwBackend programs the rules of a firewall. All the backends implement
+//the same semantics, the Firewall performs the checks common to all of
+//them and the configuration of the node itself
+type fwBackend interface {
+	init(devices []string) error
+	shutdown(devices []string) error
+	extFwding(action FwAction, extDevice string, intDevice string) error
+	extPortAccess(action FwAction, protocol string, extDevice string,
+		externalPort int, internalIP net.IP, internalPort int) error
+	publicIPAccess(action FwAction, internalIP net.IP, publicIP net.IP) error
+	portForward(action FwAction, protocol string, publicIP net.IP, publicPort int,
+		internalIP net.IP, internalPort int) error
+	instanceSecurity(action FwAction, instanceIP net.IP, rules []SecurityRule) error
+	networkIsolation(action FwAction, subnet1 net.IPNet, subnet2 net.IPNet) error
+	dump() string
+
+	//The compute nodes filter the traffic bridged to their instances
+	//instead of the traffic routed by a CNCI
+	initBridgeSecurity() error
+	bridgeFwding(action FwAction, bridge string) error
+	portSecurity(action FwAction, port string, ipv6 bool, rules []SecurityRule) error
+}
+
 //Firewall defines a single firewall instance
+//Backend is the packet filtering framework the firewall rules are
+//built with, it is never FwAuto once the firewall is initialized
 type Firewall struct {
 	ExtInterfaces []string
-	*iptables.IPTables
+	Backend       FwBackend
+	backend       fwBackend
 }
 
 //InitFirewall Enables routing on the node and NAT on all
 //external facing interfaces. Enable NAT right away to prevent
 //tenant traffic escape
+//The firewall uses iptables if available, nftables otherwise
 //TODO: Only enable external routing. Internal routing should
 //always be enabled
 func InitFirewall(devices ...string) (*Firewall, error) {
+	return InitFirewallWithBackend(FwAuto, devices...)
+}
+
+//InitFirewallWithBackend initializes a firewall built with a given
+//backend. See InitFirewall
+func InitFirewallWithBackend(backend FwBackend, devices ...string) (*Firewall, error) {
 
 	if len(devices) == 0 {
 		return nil, fmt.Errorf("initFirewall: Invalid input params")
 	}
 
+	f := &Firewall{}
+
+	var err error
+	f.Backend, f.backend, err = newFwBackend(backend)
+	if err != nil {
+		return nil, fmt.Errorf("initFirewall: Unable to setup %v %v", backend, err)
+	}
+
+	if err := f.backend.init(devices); err != nil {
+		return nil, err
+	}
+	f.ExtInterfaces = append(f.ExtInterfaces, devices...)
+
+	// Traffic between instances on the same tenant subnet is bridged
+	// by the CNCI, it only reaches the filter table if the bridge
+	// netfilter hooks are enabled
+	if err := bridgeFiltering(FwEnable); err != nil {
+		Logger.Warningf("InitFirewall: bridged traffic will not be filtered %v", err)
+	}
+
+	if err = Routing(FwEnable); err != nil {
+		return nil, fmt.Errorf("Error: InitFirewall routing enable %v", err)
+	}
+
+	return f, nil
+
+}
+
+//newFwBackend returns the firewall rules builder of a backend along with
+//the backend it picked if backend is FwAuto
+func newFwBackend(backend FwBackend) (FwBackend, fwBackend, error) {
+	switch backend {
+	case FwAuto:
+		if ipt, err := newIPTablesFw(); err == nil {
+			return FwIPTables, ipt, nil
+		}
+		nft, err := newNFTablesFw()
+		return FwNFTables, nft, err
+	case FwIPTables:
+		ipt, err := newIPTablesFw()
+		return backend, ipt, err
+	case FwNFTables:
+		nft, err := newNFTablesFw()
+		return backend, nft, err
+	}
+
+	return backend, nil, fmt.Errorf("invalid backend %v", backend)
+}
+
+//iptablesFw builds the firewall rules with iptables
+//ip6t is nil when the node cannot filter IPv6 traffic, in which case
+//only IPv4 tenant traffic is routed
+type iptablesFw struct {
+	*iptables.IPTables
+	ip6t *iptables.IPTables
+}
+
+func newIPTablesFw() (*iptablesFw, error) {
 	ipt, err := iptables.New()
 	if err != nil {
-		return nil, fmt.Errorf("initFirewall: Unable to setup iptables %v", err)
+		return nil, err
 	}
 
-	f := &Firewall{
-		IPTables: ipt,
+	return &iptablesFw{IPTables: ipt}, nil
+}
+
+func (f *iptablesFw) init(devices []string) error {
+	if err := initTables(f.IPTables, devices); err != nil {
+		return err
+	}
+
+	// IPv6 is best effort, dual stack tenant subnets lose their
+	// IPv6 connectivity but IPv4 keeps working
+	ip6t, err := iptables.NewWithProtocol(iptables.ProtocolIPv6)
+	if err == nil {
+		err = initTables(ip6t, devices)
+	}
+	if err != nil {
+		Logger.Warningf("InitFirewall: IPv6 traffic will not be routed %v", err)
+	} else {
+		f.ip6t = ip6t
 	}
 
+	return nil
+}
+
+//initTables creates the CIAO chains and enables NAT on the
+//external devices for one IP protocol
+func initTables(ipt *iptables.IPTables, devices []string) error {
 	// create CIAO Floating IPs user defined chains
 	floatingIPsChains := []string{"ciao-floating-ip-pre", "ciao-floating-ip-post"}
 	for _, chain := range floatingIPsChains {
@@ -116,24 +285,42 @@ func InitFirewall(devices ...string) (*Firewall, error) {
 	// insert ciao-floating-ip-pre into PREROUTING Chain
 	ok, err := ipt.Exists("nat", "PREROUTING", "-j", "ciao-floating-ip-pre")
 	if err != nil {
-		return nil, fmt.Errorf("Error: InitFirewall could not verify existence of chain ciao-floating-ip-pre, %v", err)
+		return fmt.Errorf("Error: InitFirewall could not verify existence of chain ciao-floating-ip-pre, %v", err)
 	}
 	if !ok {
 		err := ipt.Insert("nat", "PREROUTING", 1, "-j", "ciao-floating-ip-pre")
 		if err != nil {
-			return nil, fmt.Errorf("Error: InitFirewall could not create ciao-floating-ip-pre chain")
+			return fmt.Errorf("Error: InitFirewall could not create ciao-floating-ip-pre chain")
 		}
 	}
 
 	// insert ciao-floating-ip-post into POSTROUTING Chain
 	ok, err = ipt.Exists("nat", "POSTROUTING", "-j", "ciao-floating-ip-post")
 	if err != nil {
-		return nil, fmt.Errorf("Error: InitFirewall could not verify existence of chain ciao-floating-ip-post, %v", err)
+		return fmt.Errorf("Error: InitFirewall could not verify existence of chain ciao-floating-ip-post, %v", err)
 	}
 	if !ok {
 		err := ipt.Insert("nat", "POSTROUTING", 1, "-j", "ciao-floating-ip-post")
 		if err != nil {
-			return nil, fmt.Errorf("Error: InitFirewall could not create ciao-floating-ip-post chain")
+			return fmt.Errorf("Error: InitFirewall could not create ciao-floating-ip-post chain")
+		}
+	}
+
+	if err := initSecurityChain(ipt); err != nil {
+		return err
+	}
+
+	// create the network isolation chain ahead of the security groups
+	// chain, traffic between networks is dropped whatever the rules
+	_ = ipt.NewChain("filter", isolationChain)
+	ok, err = ipt.Exists("filter", "FORWARD", "-j", isolationChain)
+	if err != nil {
+		return fmt.Errorf("Error: InitFirewall could not verify existence of chain %s, %v", isolationChain, err)
+	}
+	if !ok {
+		err := ipt.Insert("filter", "FORWARD", 1, "-j", isolationChain)
+		if err != nil {
+			return fmt.Errorf("Error: InitFirewall could not create %s chain", isolationChain)
 		}
 	}
 
@@ -147,19 +334,55 @@ func InitFirewall(devices ...string) (*Firewall, error) {
 			ok, err := ipt.Exists("nat", "POSTROUTING",
 				"-o", device, "-j", "MASQUERADE")
 			if !ok {
-				return nil, fmt.Errorf("Error: InitFirewall NAT enable [%v] %v", device, err)
+				return fmt.Errorf("Error: InitFirewall NAT enable [%v] %v", device, err)
 			}
 		}
+	}
 
-		f.ExtInterfaces = append(f.ExtInterfaces, device)
+	return nil
+}
+
+//initSecurityChain creates the CIAO security groups chain and hooks it
+//into FORWARD ahead of the rules that allow tenant traffic through
+func initSecurityChain(ipt *iptables.IPTables) error {
+	_ = ipt.NewChain("filter", securityChain)
+	ok, err := ipt.Exists("filter", "FORWARD", "-j", securityChain)
+	if err != nil {
+		return fmt.Errorf("Error: InitFirewall could not verify existence of chain %s, %v", securityChain, err)
+	}
+	if !ok {
+		err := ipt.Insert("filter", "FORWARD", 1, "-j", securityChain)
+		if err != nil {
+			return fmt.Errorf("Error: InitFirewall could not create %s chain", securityChain)
+		}
 	}
 
-	if err = Routing(FwEnable); err != nil {
-		return nil, fmt.Errorf("Error: InitFirewall routing enable %v", err)
+	return nil
+}
+
+//tables returns the iptables of every IP protocol the firewall handles
+func (f *iptablesFw) tables() []*iptables.IPTables {
+	if f.ip6t == nil {
+		return []*iptables.IPTables{f.IPTables}
 	}
+	return []*iptables.IPTables{f.IPTables, f.ip6t}
+}
 
-	return f, nil
+//tablesFor returns the iptables that handle traffic to and from ip.
+//nil is returned for an IPv6 address if the node cannot filter IPv6
+func (f *iptablesFw) tablesFor(ip net.IP) *iptables.IPTables {
+	if ip.To4() != nil {
+		return f.IPTables
+	}
+	return f.ip6t
+}
 
+//hostCIDR returns ip as a single host subnet
+func hostCIDR(ip net.IP) string {
+	if ip.To4() != nil {
+		return ip.String() + "/32"
+	}
+	return ip.String() + "/128"
 }
 
 //ShutdownFirewall Disables routing and NAT
@@ -170,26 +393,29 @@ func (f *Firewall) ShutdownFirewall() error {
 		return fmt.Errorf("Error: Shutdown Firewall routing disable %v", err)
 	}
 
-	for _, device := range f.ExtInterfaces {
+	return f.backend.shutdown(f.ExtInterfaces)
+}
 
-		err := f.Delete("nat", "POSTROUTING",
-			"-o", device, "-j", "MASQUERADE")
+func (f *iptablesFw) shutdown(devices []string) error {
+	for _, ipt := range f.tables() {
+		for _, device := range devices {
 
-		if err != nil {
-			return fmt.Errorf("Error: Shutdown Firewall NAT disable %v", err)
+			err := ipt.Delete("nat", "POSTROUTING",
+				"-o", device, "-j", "MASQUERADE")
+
+			if err != nil {
+				return fmt.Errorf("Error: Shutdown Firewall NAT disable %v", err)
+			}
 		}
 	}
 
 	return nil
 }
 
-//Routing enable or disables routing
-//echo 0 > /proc/sys/net/ipv4/ip_forward
-//echo 1 > /proc/sys/net/ipv4/ip_forward
-func Routing(action FwAction) error {
-	file, err := os.OpenFile(procIPFwd, os.O_WRONLY|os.O_TRUNC, 0644)
+func writeProcFlag(path string, action FwAction) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_TRUNC, 0644)
 	if err != nil {
-		return fmt.Errorf("Routing: Unable to open %v %v", procIPFwd, err)
+		return err
 	}
 	defer func() { _ = file.Close() }()
 
@@ -200,10 +426,36 @@ func Routing(action FwAction) error {
 		_, err = file.WriteString("0")
 	}
 
-	if err != nil {
+	return err
+}
+
+//Routing enable or disables routing
+//echo 0 > /proc/sys/net/ipv4/ip_forward
+//echo 1 > /proc/sys/net/ipv4/ip_forward
+//IPv6 routing follows IPv4 on nodes where IPv6 is enabled
+func Routing(action FwAction) error {
+	if err := writeProcFlag(procIPFwd, action); err != nil {
 		return fmt.Errorf("Routing failed %v %v", action, err)
 	}
 
+	err := writeProcFlag(procIP6Fwd, action)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("IPv6 Routing failed %v %v", action, err)
+	}
+
+	return nil
+}
+
+//bridgeFiltering enables or disables iptables filtering of bridged traffic
+//echo 1 > /proc/sys/net/bridge/bridge-nf-call-iptables
+//echo 1 > /proc/sys/net/bridge/bridge-nf-call-ip6tables
+func bridgeFiltering(action FwAction) error {
+	for _, path := range []string{procBridgeNFIPT, procBridgeNFIP6T} {
+		if err := writeProcFlag(path, action); err != nil {
+			return fmt.Errorf("bridge filtering failed %v %v %v", path, action, err)
+		}
+	}
+
 	return nil
 }
 
@@ -211,11 +463,25 @@ func Routing(action FwAction) error {
 //and a tenant bridge (hence a tenant subnet)
 //Each tenant subnet created needs explicit enabling/disabling
 func (f *Firewall) ExtFwding(action FwAction, extDevice string, intDevice string) error {
+	return f.backend.extFwding(action, extDevice, intDevice)
+}
+
+func (f *iptablesFw) extFwding(action FwAction, extDevice string, intDevice string) error {
+	for _, ipt := range f.tables() {
+		if err := iptExtFwding(ipt, action, extDevice, intDevice); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func iptExtFwding(ipt *iptables.IPTables, action FwAction, extDevice string, intDevice string) error {
 	switch action {
 	case FwEnable:
 		//iptables -A FORWARD -i $extDevice -o $intDevice
 		// -m state --state RELATED,ESTABLISHED -j ACCEPT
-		err := f.AppendUnique("filter", "FORWARD",
+		err := ipt.AppendUnique("filter", "FORWARD",
 			"-i", extDevice, "-o", intDevice,
 			"-m", "state", "--state", "RELATED,ESTABLISHED", "-j", "ACCEPT")
 
@@ -224,7 +490,7 @@ func (f *Firewall) ExtFwding(action FwAction, extDevice string, intDevice string
 		}
 
 		//iptables -A FORWARD -i $intDevice -o $extDevice -j ACCEPT
-		err = f.AppendUnique("filter", "FORWARD",
+		err = ipt.AppendUnique("filter", "FORWARD",
 			"-i", intDevice, "-o", extDevice, "-j", "ACCEPT")
 		if err != nil {
 			return fmt.Errorf("enable outbound fwding failed: %v [%s] [%s]",
@@ -233,7 +499,7 @@ func (f *Firewall) ExtFwding(action FwAction, extDevice string, intDevice string
 	case FwDisable:
 		//iptables -D FORWARD -i $extDevice -o $intDevice
 		// -m state --state RELATED,ESTABLISHED -j ACCEPT
-		err := f.Delete("filter", "FORWARD",
+		err := ipt.Delete("filter", "FORWARD",
 			"-i", extDevice, "-o", intDevice,
 			"-m", "state", "--state", "RELATED,ESTABLISHED", "-j", "ACCEPT")
 
@@ -242,7 +508,7 @@ func (f *Firewall) ExtFwding(action FwAction, extDevice string, intDevice string
 		}
 
 		//iptables -D FORWARD -i $intDevice -o $extDevice -j ACCEPT
-		err = f.Delete("filter", "FORWARD",
+		err = ipt.Delete("filter", "FORWARD",
 			"-i", intDevice, "-o", extDevice, "-j", "ACCEPT")
 		if err != nil {
 			return fmt.Errorf("disable outbound fwding failed: %v [%s] [%s]",
@@ -256,6 +522,11 @@ func (f *Firewall) ExtFwding(action FwAction, extDevice string, intDevice string
 //ExtPortAccess Enables/Disables port access via external device and port
 //to an internal IP address and port for the specified protocol
 func (f *Firewall) ExtPortAccess(action FwAction, protocol string, extDevice string,
+	externalPort int, internalIP net.IP, internalPort int) error {
+	return f.backend.extPortAccess(action, protocol, extDevice, externalPort, internalIP, internalPort)
+}
+
+func (f *iptablesFw) extPortAccess(action FwAction, protocol string, extDevice string,
 	externalPort int, internalIP net.IP, internalPort int) error {
 	ePort := strconv.Itoa(externalPort)
 	iPort := strconv.Itoa(internalPort)
@@ -315,11 +586,19 @@ func ipAssign(action FwAction, ip net.IP, iface string) error {
 		return fmt.Errorf("Unable to detect interface %v %v", iface, err)
 	}
 
+	family := netlink.FAMILY_V4
 	addr := &netlink.Addr{IPNet: &net.IPNet{
 		IP:   ip.To4(),
-		Mask: net.IPv4Mask(255, 255, 255, 255),
+		Mask: net.CIDRMask(32, 32),
 	},
 	}
+	if addr.IP == nil {
+		family = netlink.FAMILY_V6
+		addr.IPNet = &net.IPNet{
+			IP:   ip.To16(),
+			Mask: net.CIDRMask(128, 128),
+		}
+	}
 
 	switch action {
 	case FwEnable:
@@ -344,7 +623,7 @@ func ipAssign(action FwAction, ip net.IP, iface string) error {
 		}
 
 		//Check if someone deleted it
-		addrs, err := netlink.AddrList(link, netlink.FAMILY_V4)
+		addrs, err := netlink.AddrList(link, family)
 		if err != nil || len(addrs) == 0 {
 			return fmt.Errorf("Unable to unassign IP from interface %s %v %v", ip, iface, err)
 		}
@@ -364,45 +643,50 @@ func ipAssign(action FwAction, ip net.IP, iface string) error {
 func (f *Firewall) PublicIPAccess(action FwAction,
 	internalIP net.IP, publicIP net.IP, extInterface string) error {
 
-	intIP := internalIP.String()
-	pubIP := publicIP.String()
+	if (internalIP.To4() == nil) != (publicIP.To4() == nil) {
+		return fmt.Errorf("Mismatched public IP %v and internal IP %v", publicIP, internalIP)
+	}
 
 	switch action {
-	case FwEnable:
-		// assign the pubIP to the cnci agent
-		err := ipAssign(FwEnable, publicIP, extInterface)
-		if err != nil {
-			return fmt.Errorf("Public IP Assignment failure %v", err)
-		}
-		return enablePublicIP(intIP, pubIP)
-	case FwDisable:
-		// remove the pubIP from the cnci agent
-		err := ipAssign(FwDisable, publicIP, extInterface)
-		if err != nil {
-			return fmt.Errorf("Public IP Assignment failure %v", err)
-		}
-
-		return disablePublicIP(intIP, pubIP)
+	case FwEnable, FwDisable:
 	default:
 		return fmt.Errorf("Invalid parameter %v", action)
 	}
-}
 
-func enablePublicIP(intIP, pubIP string) error {
-	ipt, err := iptables.New()
+	// assign the pubIP to the cnci agent or remove it
+	err := ipAssign(action, publicIP, extInterface)
 	if err != nil {
-		return fmt.Errorf("initFirewall: Unable to setup iptables %v", err)
+		return fmt.Errorf("Public IP Assignment failure %v", err)
+	}
+
+	return f.backend.publicIPAccess(action, internalIP, publicIP)
+}
+
+func (f *iptablesFw) publicIPAccess(action FwAction, internalIP net.IP, publicIP net.IP) error {
+	ipt := f.tablesFor(publicIP)
+	if ipt == nil {
+		return fmt.Errorf("IPv6 not supported, unable to map %v", publicIP)
+	}
+
+	if action == FwEnable {
+		return enablePublicIP(ipt, internalIP, publicIP)
 	}
+	return disablePublicIP(ipt, internalIP, publicIP)
+}
+
+func enablePublicIP(ipt *iptables.IPTables, internalIP, publicIP net.IP) error {
+	intIP := internalIP.String()
+	pubIP := publicIP.String()
 
 	// insert DNAT rule of PREROUTING
 	// iptables -t nat -I ciao-floating-ip-pre -d <pubIP> -j DNAT --to-destination <intIP>
-	ok, err := ipt.Exists("nat", "ciao-floating-ip-pre", "-d", pubIP+"/32", "-j", "DNAT", "--to-destination", intIP)
+	ok, err := ipt.Exists("nat", "ciao-floating-ip-pre", "-d", hostCIDR(publicIP), "-j", "DNAT", "--to-destination", intIP)
 	if err != nil {
 		return fmt.Errorf("Error: InitFirewall could not verify existence of PREROUTING rule %s to %s", pubIP, intIP)
 	}
 
 	if !ok {
-		err := ipt.Insert("nat", "ciao-floating-ip-pre", 1, "-d", pubIP+"/32", "-j", "DNAT", "--to-destination", intIP)
+		err := ipt.Insert("nat", "ciao-floating-ip-pre", 1, "-d", hostCIDR(publicIP), "-j", "DNAT", "--to-destination", intIP)
 		if err != nil {
 			return fmt.Errorf("Could not insert firewall PREROUTING rule %s to %s into chain ciao-floating-ip-pre", pubIP, intIP)
 		}
@@ -410,13 +694,13 @@ func enablePublicIP(intIP, pubIP string) error {
 
 	// insert SNAT rule of POSTROUTING
 	// iptables -t nat -I ciao-floating-ip-post -s <intIP> -j SNAT --to-source <pubIP>
-	ok, err = ipt.Exists("nat", "ciao-floating-ip-post", "-s", intIP+"/32", "-j", "SNAT", "--to-source", pubIP)
+	ok, err = ipt.Exists("nat", "ciao-floating-ip-post", "-s", hostCIDR(internalIP), "-j", "SNAT", "--to-source", pubIP)
 	if err != nil {
 		return fmt.Errorf("Error: InitFirewall could not verify existence of POSTROUTING rule %s to %s", intIP, pubIP)
 	}
 
 	if !ok {
-		err := ipt.Insert("nat", "ciao-floating-ip-post", 1, "-s", intIP+"/32", "-j", "SNAT", "--to-source", pubIP)
+		err := ipt.Insert("nat", "ciao-floating-ip-post", 1, "-s", hostCIDR(internalIP), "-j", "SNAT", "--to-source", pubIP)
 		if err != nil {
 			return fmt.Errorf("Could not insert firewall POSTROUTING rule %s to %s into chain ciao-floating-ip-post", intIP, pubIP)
 		}
@@ -425,21 +709,19 @@ func enablePublicIP(intIP, pubIP string) error {
 	return nil
 }
 
-func disablePublicIP(intIP, pubIP string) error {
-	ipt, err := iptables.New()
-	if err != nil {
-		return fmt.Errorf("initFirewall: Unable to setup iptables %v", err)
-	}
+func disablePublicIP(ipt *iptables.IPTables, internalIP, publicIP net.IP) error {
+	intIP := internalIP.String()
+	pubIP := publicIP.String()
 
 	// delete DNAT PREROUTING rule
 	// iptables -t nat -D ciao-floating-ip-pre -d <pubIP> -j DNAT --to-destination <intIP>
-	ok, err := ipt.Exists("nat", "ciao-floating-ip-pre", "-d", pubIP+"/32", "-j", "DNAT", "--to-destination", intIP)
+	ok, err := ipt.Exists("nat", "ciao-floating-ip-pre", "-d", hostCIDR(publicIP), "-j", "DNAT", "--to-destination", intIP)
 	if err != nil {
 		return fmt.Errorf("Error: InitFirewall could not verify existence of PREROUTING rule %s to %s", pubIP, intIP)
 	}
 
 	if ok {
-		err := ipt.Delete("nat", "ciao-floating-ip-pre", "-d", pubIP+"/32", "-j", "DNAT", "--to-destination", intIP)
+		err := ipt.Delete("nat", "ciao-floating-ip-pre", "-d", hostCIDR(publicIP), "-j", "DNAT", "--to-destination", intIP)
 		if err != nil {
 			return fmt.Errorf("Could not delete firewall PREROUTING rule %s to %s into chain ciao-floating-ip-pre", pubIP, intIP)
 		}
@@ -447,13 +729,13 @@ func disablePublicIP(intIP, pubIP string) error {
 
 	// delete SNAT POSTROUTING rule
 	// iptables -t nat -D ciao-floating-ip-post -s <intIP> -j SNAT --to-source <pubIP>
-	ok, err = ipt.Exists("nat", "ciao-floating-ip-post", "-s", intIP+"/32", "-j", "SNAT", "--to-source", pubIP)
+	ok, err = ipt.Exists("nat", "ciao-floating-ip-post", "-s", hostCIDR(internalIP), "-j", "SNAT", "--to-source", pubIP)
 	if err != nil {
 		return fmt.Errorf("Error: InitFirewall could not verify existence of POSTROUTING rule %s to %s", intIP, pubIP)
 	}
 
 	if ok {
-		err := ipt.Delete("nat", "ciao-floating-ip-post", "-s", intIP+"/32", "-j", "SNAT", "--to-source", pubIP)
+		err := ipt.Delete("nat", "ciao-floating-ip-post", "-s", hostCIDR(internalIP), "-j", "SNAT", "--to-source", pubIP)
 		if err != nil {
 			return fmt.Errorf("Could not delete firewall POSTROUTING rule %s to %s into chain ciao-floating-ip-post", intIP, pubIP)
 		}
@@ -462,6 +744,497 @@ func disablePublicIP(intIP, pubIP string) error {
 	return nil
 }
 
+//PublicIPAssign Assigns/Unassigns a public IP to the external interface
+//without mapping it to an instance. The ports of the public IP can then
+//be forwarded to different instances with PortForward
+func (f *Firewall) PublicIPAssign(action FwAction, publicIP net.IP, extInterface string) error {
+	switch action {
+	case FwEnable, FwDisable:
+	default:
+		return fmt.Errorf("Invalid parameter %v", action)
+	}
+
+	err := ipAssign(action, publicIP, extInterface)
+	if err != nil {
+		return fmt.Errorf("Public IP Assignment failure %v", err)
+	}
+
+	return nil
+}
+
+//PortForward Enables/Disables the forwarding of a port of a public IP
+//to a port of an internal IP for the specified protocol, tcp or udp
+func (f *Firewall) PortForward(action FwAction, protocol string, publicIP net.IP,
+	publicPort int, internalIP net.IP, internalPort int) error {
+
+	if (internalIP.To4() == nil) != (publicIP.To4() == nil) {
+		return fmt.Errorf("Mismatched public IP %v and internal IP %v", publicIP, internalIP)
+	}
+
+	if protocol != "tcp" && protocol != "udp" {
+		return fmt.Errorf("invalid protocol %v", protocol)
+	}
+
+	if publicPort < 1 || publicPort > 65535 || internalPort < 1 || internalPort > 65535 {
+		return fmt.Errorf("invalid ports %d %d", publicPort, internalPort)
+	}
+
+	switch action {
+	case FwEnable, FwDisable:
+	default:
+		return fmt.Errorf("Invalid parameter %v", action)
+	}
+
+	return f.backend.portForward(action, protocol, publicIP, publicPort, internalIP, internalPort)
+}
+
+func (f *iptablesFw) portForward(action FwAction, protocol string, publicIP net.IP,
+	publicPort int, internalIP net.IP, internalPort int) error {
+	ipt := f.tablesFor(publicIP)
+	if ipt == nil {
+		return fmt.Errorf("IPv6 not supported, unable to forward %v", publicIP)
+	}
+
+	// iptables -t nat -I ciao-floating-ip-pre -d <pubIP> -p <protocol>
+	// --dport <pubPort> -j DNAT --to-destination <intIP>:<intPort>
+	rule := []string{"-d", hostCIDR(publicIP), "-p", protocol,
+		"--dport", strconv.Itoa(publicPort), "-j", "DNAT",
+		"--to-destination", net.JoinHostPort(internalIP.String(), strconv.Itoa(internalPort))}
+
+	ok, err := ipt.Exists("nat", "ciao-floating-ip-pre", rule...)
+	if err != nil {
+		return fmt.Errorf("Could not verify existence of port forwarding rule %s:%d to %s:%d",
+			publicIP, publicPort, internalIP, internalPort)
+	}
+
+	switch {
+	case action == FwEnable && !ok:
+		err = ipt.Insert("nat", "ciao-floating-ip-pre", 1, rule...)
+	case action == FwDisable && ok:
+		err = ipt.Delete("nat", "ciao-floating-ip-pre", rule...)
+	}
+
+	if err != nil {
+		return fmt.Errorf("Unable to %v forwarding of %v %s:%d to %s:%d %v",
+			action, protocol, publicIP, publicPort, internalIP, internalPort, err)
+	}
+
+	return nil
+}
+
+//SecurityRule describes traffic that is allowed to reach a tenant
+//instance whose ingress traffic is filtered
+type SecurityRule struct {
+	//Protocol is one of tcp, udp or icmp. All protocols match if empty
+	Protocol string
+	//PortMin and PortMax define the destination port range for tcp
+	//and udp. All ports match if both are 0
+	PortMin int
+	PortMax int
+	//RemoteCIDR restricts the rule to a source subnet. Any source
+	//matches if empty
+	RemoteCIDR string
+}
+
+//securityChainName returns the name of the chain filtering ip. Chain
+//names are limited to 28 characters so IPv6 chains are named after the
+//last 10 bytes of the address, which hold the interface identifier
+func securityChainName(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return fmt.Sprintf("%s%x", securityChainPrefix, []byte(ip4))
+	}
+	return fmt.Sprintf("%s%x", securityChainPrefix, []byte(ip.To16()[6:]))
+}
+
+//securityRuleSpec returns the iptables rule specification that accepts
+//the traffic described by rule for an IPv4 or an IPv6 instance. A nil
+//specification is returned if the remote subnet of the rule belongs to
+//the other IP protocol
+func securityRuleSpec(rule SecurityRule, ipv6 bool) ([]string, error) {
+	var spec []string
+
+	if rule.RemoteCIDR != "" {
+		_, src, err := net.ParseCIDR(rule.RemoteCIDR)
+		if err != nil {
+			return nil, fmt.Errorf("invalid remote subnet %v", rule.RemoteCIDR)
+		}
+		if (src.IP.To4() == nil) != ipv6 {
+			return nil, nil
+		}
+		spec = append(spec, "-s", src.String())
+	}
+
+	ports := rule.PortMin != 0 || rule.PortMax != 0
+
+	switch rule.Protocol {
+	case "tcp", "udp":
+		spec = append(spec, "-p", rule.Protocol)
+		if !ports {
+			break
+		}
+		if rule.PortMin < 1 || rule.PortMax > 65535 || rule.PortMin > rule.PortMax {
+			return nil, fmt.Errorf("invalid port range %d-%d", rule.PortMin, rule.PortMax)
+		}
+		spec = append(spec, "--dport", fmt.Sprintf("%d:%d", rule.PortMin, rule.PortMax))
+	case "icmp", "":
+		if ports {
+			return nil, fmt.Errorf("port range not supported for protocol %q", rule.Protocol)
+		}
+		switch {
+		case rule.Protocol == "":
+		case ipv6:
+			spec = append(spec, "-p", "ipv6-icmp")
+		default:
+			spec = append(spec, "-p", rule.Protocol)
+		}
+	default:
+		return nil, fmt.Errorf("invalid protocol %v", rule.Protocol)
+	}
+
+	return append(spec, "-j", "ACCEPT"), nil
+}
+
+//checkSecurityRules checks rules whatever the IP protocol they apply to
+func checkSecurityRules(rules []SecurityRule) error {
+	for _, rule := range rules {
+		for _, ipv6 := range []bool{false, true} {
+			if _, err := securityRuleSpec(rule, ipv6); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+//InstanceSecurity Enables/Disables ingress filtering for a tenant instance.
+//Once enabled only replies to traffic initiated by the instance and
+//traffic matching one of the rules reach the instance, everything else
+//is dropped. Enabling filtering on an instance that is already filtered
+//replaces its rules.
+func (f *Firewall) InstanceSecurity(action FwAction, instanceIP net.IP, rules []SecurityRule) error {
+	if instanceIP.To16() == nil {
+		return fmt.Errorf("invalid instance IP %v", instanceIP)
+	}
+
+	switch action {
+	case FwEnable, FwDisable:
+	default:
+		return fmt.Errorf("Invalid parameter %v", action)
+	}
+
+	return f.backend.instanceSecurity(action, instanceIP, rules)
+}
+
+//iptSecuritySpecs returns the rules of the chain that filters the ingress
+//traffic of an IPv4 or an IPv6 instance. The accept specifications are
+//matched ahead of the security rules
+func iptSecuritySpecs(rules []SecurityRule, ipv6 bool, accept ...[]string) ([][]string, error) {
+	//iptables -A $chain -m state --state RELATED,ESTABLISHED -j ACCEPT
+	specs := [][]string{{"-m", "state", "--state", "RELATED,ESTABLISHED", "-j", "ACCEPT"}}
+	specs = append(specs, accept...)
+
+	for _, rule := range rules {
+		spec, err := securityRuleSpec(rule, ipv6)
+		if err != nil {
+			return nil, err
+		}
+		if spec != nil {
+			specs = append(specs, spec)
+		}
+	}
+
+	//Neighbour discovery between instances on the same subnet
+	//is bridged through the filter table and has to go through
+	if ipv6 {
+		specs = append(specs,
+			[]string{"-p", "ipv6-icmp", "--icmpv6-type", "neighbour-solicitation", "-j", "ACCEPT"},
+			[]string{"-p", "ipv6-icmp", "--icmpv6-type", "neighbour-advertisement", "-j", "ACCEPT"})
+	}
+
+	//iptables -A $chain -j DROP
+	return append(specs, []string{"-j", "DROP"}), nil
+}
+
+//iptSecurityJump returns the rule of the security groups chain that sends
+//the traffic matching match to chain
+func iptSecurityJump(match []string, chain string) []string {
+	jump := append([]string(nil), match...)
+	return append(jump, "-j", chain)
+}
+
+//iptSwapSecurityChain fills chain with specs and sends the traffic that
+//matches match through it. The rules are built in a new chain which only
+//replaces the current chain once complete, so the traffic is never left
+//unfiltered or filtered by a partial rule set while rules are replaced
+func iptSwapSecurityChain(ipt *iptables.IPTables, chain string, match []string, specs [][]string) error {
+	next := securityChainUpdatePrefix + strings.TrimPrefix(chain, securityChainPrefix)
+
+	//ClearChain creates the chain or flushes what an interrupted
+	//update left behind
+	if err := ipt.ClearChain("filter", next); err != nil {
+		return fmt.Errorf("Unable to setup chain %s %v", next, err)
+	}
+
+	for _, spec := range specs {
+		if err := ipt.Append("filter", next, spec...); err != nil {
+			return fmt.Errorf("Unable to add rule %v to %s %v", spec, next, err)
+		}
+	}
+
+	//iptables -I ciao-security-groups 1 $match -j $next
+	nextJump := iptSecurityJump(match, next)
+	ok, err := ipt.Exists("filter", securityChain, nextJump...)
+	if err != nil {
+		return fmt.Errorf("Unable to check jump to %s %v", next, err)
+	}
+	if !ok {
+		if err := ipt.Insert("filter", securityChain, 1, nextJump...); err != nil {
+			return fmt.Errorf("Unable to jump to %s %v", next, err)
+		}
+	}
+
+	//The new chain is ahead of the current one, which no longer
+	//sees any traffic and can go
+	jump := iptSecurityJump(match, chain)
+	ok, err = ipt.Exists("filter", securityChain, jump...)
+	if err != nil {
+		return fmt.Errorf("Unable to check jump to %s %v", chain, err)
+	}
+	if ok {
+		if err := ipt.Delete("filter", securityChain, jump...); err != nil {
+			return fmt.Errorf("Unable to remove jump to %s %v", chain, err)
+		}
+	}
+
+	//ClearChain creates the chain if the instance was not filtered
+	//yet, it has to be gone for the rename
+	if err := ipt.ClearChain("filter", chain); err != nil {
+		return fmt.Errorf("Unable to flush chain %s %v", chain, err)
+	}
+	if err := ipt.DeleteChain("filter", chain); err != nil {
+		return fmt.Errorf("Unable to delete chain %s %v", chain, err)
+	}
+
+	//The jump follows the chain it points to when it is renamed
+	if err := ipt.RenameChain("filter", next, chain); err != nil {
+		return fmt.Errorf("Unable to rename chain %s %v", next, err)
+	}
+
+	return nil
+}
+
+//iptRemoveSecurityChain stops sending the traffic that matches match
+//through chain and deletes chain
+func iptRemoveSecurityChain(ipt *iptables.IPTables, chain string, match []string) error {
+	jump := iptSecurityJump(match, chain)
+
+	ok, err := ipt.Exists("filter", securityChain, jump...)
+	if err != nil {
+		return fmt.Errorf("Unable to check jump to %s %v", chain, err)
+	}
+	if !ok {
+		return nil
+	}
+
+	if err := ipt.Delete("filter", securityChain, jump...); err != nil {
+		return fmt.Errorf("Unable to remove jump to %s %v", chain, err)
+	}
+	if err := ipt.ClearChain("filter", chain); err != nil {
+		return fmt.Errorf("Unable to flush chain %s %v", chain, err)
+	}
+	if err := ipt.DeleteChain("filter", chain); err != nil {
+		return fmt.Errorf("Unable to delete chain %s %v", chain, err)
+	}
+
+	return nil
+}
+
+func (f *iptablesFw) instanceSecurity(action FwAction, ip net.IP, rules []SecurityRule) error {
+	ipt := f.tablesFor(ip)
+	if ipt == nil {
+		return fmt.Errorf("IPv6 not supported, unable to filter %v", ip)
+	}
+
+	chain := securityChainName(ip)
+	match := []string{"-d", hostCIDR(ip)}
+
+	switch action {
+	case FwEnable:
+		specs, err := iptSecuritySpecs(rules, ip.To4() == nil)
+		if err != nil {
+			return fmt.Errorf("Unable to filter %v: %v", ip, err)
+		}
+
+		if err := iptSwapSecurityChain(ipt, chain, match, specs); err != nil {
+			return fmt.Errorf("Unable to filter %v %v", ip, err)
+		}
+	case FwDisable:
+		if err := iptRemoveSecurityChain(ipt, chain, match); err != nil {
+			return fmt.Errorf("Unable to remove filtering of %v %v", ip, err)
+		}
+	}
+
+	return nil
+}
+
+//NetworkIsolation Enables/Disables isolation between two tenant subnets
+//routed by the CNCI. Once enabled traffic between the subnets is dropped
+//in both directions.
+func (f *Firewall) NetworkIsolation(action FwAction, subnet1 net.IPNet, subnet2 net.IPNet) error {
+	if subnet1.IP.To4() == nil || subnet2.IP.To4() == nil {
+		return fmt.Errorf("invalid subnets %v %v", subnet1, subnet2)
+	}
+
+	switch action {
+	case FwEnable, FwDisable:
+	default:
+		return fmt.Errorf("Invalid parameter %v", action)
+	}
+
+	return f.backend.networkIsolation(action, subnet1, subnet2)
+}
+
+func (f *iptablesFw) networkIsolation(action FwAction, subnet1 net.IPNet, subnet2 net.IPNet) error {
+	specs := [][]string{
+		{"-s", subnet1.String(), "-d", subnet2.String(), "-j", "DROP"},
+		{"-s", subnet2.String(), "-d", subnet1.String(), "-j", "DROP"},
+	}
+
+	for _, spec := range specs {
+		switch action {
+		case FwEnable:
+			//iptables -A ciao-network-isolation -s $subnet1 -d $subnet2 -j DROP
+			if err := f.AppendUnique("filter", isolationChain, spec...); err != nil {
+				return fmt.Errorf("Unable to isolate %v %v", spec, err)
+			}
+		case FwDisable:
+			ok, err := f.Exists("filter", isolationChain, spec...)
+			if err != nil {
+				return fmt.Errorf("Unable to check isolation %v %v", spec, err)
+			}
+			if !ok {
+				continue
+			}
+			if err := f.Delete("filter", isolationChain, spec...); err != nil {
+				return fmt.Errorf("Unable to remove isolation %v %v", spec, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+//cnSecurityAccept is the traffic the CNCI sends to the instances of a
+//subnet without them asking for it first. It has to go through the
+//filtering of the bridge
+var cnSecurityAccept = [][]string{
+	{"-p", "udp", "--sport", "67", "--dport", "68", "-j", "ACCEPT"},
+}
+
+var cnSecurityAcceptV6 = [][]string{
+	{"-p", "udp", "--sport", "547", "--dport", "546", "-j", "ACCEPT"},
+	{"-p", "ipv6-icmp", "--icmpv6-type", "router-advertisement", "-j", "ACCEPT"},
+}
+
+func (f *iptablesFw) initBridgeSecurity() error {
+	//Instances are filtered on the bridge of their subnet, including
+	//the traffic between instances of the subnet running on this node
+	if err := initSecurityChain(f.IPTables); err != nil {
+		return err
+	}
+
+	//IPv6 filtering is best effort, the IPv6 traffic of dual stack
+	//instances is then only filtered by their CNCI
+	ip6t, err := iptables.NewWithProtocol(iptables.ProtocolIPv6)
+	if err == nil {
+		err = initSecurityChain(ip6t)
+	}
+	if err != nil {
+		Logger.Warningf("CN Init: IPv6 traffic will not be filtered %v", err)
+	} else {
+		f.ip6t = ip6t
+	}
+
+	if err := bridgeFiltering(FwEnable); err != nil {
+		Logger.Warningf("CN Init: bridged traffic will not be filtered %v", err)
+	}
+
+	return nil
+}
+
+func (f *iptablesFw) bridgeFwding(action FwAction, bridge string) error {
+	for _, ipt := range f.tables() {
+		var err error
+		switch action {
+		case FwEnable:
+			//iptables -A FORWARD -p all -i "$bridge" -j ACCEPT
+			err = ipt.AppendUnique("filter", "FORWARD",
+				"-p", "all", "-i", bridge, "-j", "ACCEPT")
+		case FwDisable:
+			err = ipt.Delete("filter", "FORWARD",
+				"-p", "all", "-i", bridge, "-j", "ACCEPT")
+		}
+		if err != nil {
+			return fmt.Errorf("Unable to %v fwding of %s %v", action, bridge, err)
+		}
+	}
+
+	return nil
+}
+
+//portSecurity filters the traffic bridged to port. IPv6 traffic is only
+//filtered for dual stack instances but filtering is always disabled for
+//both IP protocols
+func (f *iptablesFw) portSecurity(action FwAction, port string, ipv6 bool, rules []SecurityRule) error {
+	chain := securityChainPrefix + port
+	match := []string{"-m", "physdev", "--physdev-is-bridged", "--physdev-out", port}
+
+	if action == FwDisable {
+		for _, ipt := range f.tables() {
+			if err := iptRemoveSecurityChain(ipt, chain, match); err != nil {
+				return fmt.Errorf("Unable to remove filtering of %s %v", port, err)
+			}
+		}
+		return nil
+	}
+
+	specs, err := iptSecuritySpecs(rules, false, cnSecurityAccept...)
+	if err != nil {
+		return fmt.Errorf("Unable to filter %s: %v", port, err)
+	}
+	if err := iptSwapSecurityChain(f.IPTables, chain, match, specs); err != nil {
+		return fmt.Errorf("Unable to filter %s %v", port, err)
+	}
+
+	if !ipv6 {
+		return nil
+	}
+	if f.ip6t == nil {
+		Logger.Warningf("IPv6 traffic of %s will not be filtered", port)
+		return nil
+	}
+
+	specs, err = iptSecuritySpecs(rules, true, cnSecurityAcceptV6...)
+	if err != nil {
+		return fmt.Errorf("Unable to filter %s: %v", port, err)
+	}
+	if err := iptSwapSecurityChain(f.ip6t, chain, match, specs); err != nil {
+		return fmt.Errorf("Unable to filter %s %v", port, err)
+	}
+
+	return nil
+}
+
+//Dump returns the current state of the rules of the firewall
+func (f *Firewall) Dump() string {
+	return f.backend.dump()
+}
+
+func (f *iptablesFw) dump() string {
+	return DumpIPTables()
+}
+
 //DumpIPTables provides a utility routine that returns
 //the current state of the iptables
 func DumpIPTables() string {
@@ -136,6 +136,39 @@ func TestFw_PublicIP(t *testing.T) {
 	}
 }
 
+//Test forwarding of ports of a shared public IP
+//
+//Test if the ports of a public IP assigned to no instance
+//can be forwarded to different private IPs and removed
+//
+//Test is expected to pass
+func TestFw_PortForward(t *testing.T) {
+	assert := assert.New(t)
+
+	fwinit()
+	fw, err := InitFirewall(fwIf)
+	require.Nil(t, err)
+
+	pubIP := net.ParseIP("198.51.100.100")
+	intIP1 := net.ParseIP("198.51.100.1")
+	intIP2 := net.ParseIP("198.51.100.2")
+
+	assert.Nil(fw.PublicIPAssign(FwEnable, pubIP, fwIfInt))
+	assert.Nil(fw.PortForward(FwEnable, "tcp", pubIP, 80, intIP1, 8080))
+	assert.Nil(fw.PortForward(FwEnable, "tcp", pubIP, 80, intIP1, 8080))
+	assert.Nil(fw.PortForward(FwEnable, "udp", pubIP, 53, intIP2, 53))
+
+	assert.NotNil(fw.PortForward(FwEnable, "icmp", pubIP, 53, intIP2, 53))
+	assert.NotNil(fw.PortForward(FwEnable, "tcp", pubIP, 0, intIP2, 53))
+	assert.NotNil(fw.PortForward(FwEnable, "tcp", pubIP, 53, net.ParseIP("fd00::2"), 53))
+
+	assert.Nil(fw.PortForward(FwDisable, "tcp", pubIP, 80, intIP1, 8080))
+	assert.Nil(fw.PortForward(FwDisable, "udp", pubIP, 53, intIP2, 53))
+	assert.Nil(fw.PublicIPAssign(FwDisable, pubIP, fwIfInt))
+
+	assert.Nil(fw.ShutdownFirewall())
+}
+
 //Exercises all valid CNCI Firewall APIs
 //
 //This tests performs the sequence of operations typically
@@ -183,3 +216,132 @@ func TestFw_All(t *testing.T) {
 	err = fw.ShutdownFirewall()
 	assert.Nil(err)
 }
+
+//Tests the translation of security rules into iptables rules
+//
+//Checks that valid rules are accepted and invalid protocols,
+//port ranges and remote subnets are rejected
+//
+//Test is expected to pass
+func TestFw_SecurityRuleSpec(t *testing.T) {
+	var specTests = []struct {
+		rule     SecurityRule
+		expected []string
+	}{
+		{SecurityRule{}, []string{"-j", "ACCEPT"}},
+		{SecurityRule{Protocol: "tcp", PortMin: 22, PortMax: 22},
+			[]string{"-p", "tcp", "--dport", "22:22", "-j", "ACCEPT"}},
+		{SecurityRule{Protocol: "udp", PortMin: 5000, PortMax: 5010, RemoteCIDR: "198.51.100.7/24"},
+			[]string{"-s", "198.51.100.0/24", "-p", "udp", "--dport", "5000:5010", "-j", "ACCEPT"}},
+		{SecurityRule{Protocol: "icmp"}, []string{"-p", "icmp", "-j", "ACCEPT"}},
+		{SecurityRule{Protocol: "tcp"}, []string{"-p", "tcp", "-j", "ACCEPT"}},
+		{SecurityRule{Protocol: "sctp"}, nil},
+		{SecurityRule{Protocol: "tcp", PortMin: 80, PortMax: 22}, nil},
+		{SecurityRule{Protocol: "tcp", PortMin: 0, PortMax: 22}, nil},
+		{SecurityRule{Protocol: "udp", PortMin: 1, PortMax: 65536}, nil},
+		{SecurityRule{Protocol: "icmp", PortMin: 8, PortMax: 8}, nil},
+		{SecurityRule{RemoteCIDR: "198.51.100.7"}, nil},
+	}
+
+	for _, test := range specTests {
+		spec, err := securityRuleSpec(test.rule, false)
+		if test.expected == nil {
+			assert.NotNil(t, err, "%+v", test.rule)
+			continue
+		}
+		assert.Nil(t, err, "%+v", test.rule)
+		assert.Equal(t, test.expected, spec)
+	}
+}
+
+//Tests the rules of IPv6 instances
+//
+//ICMP maps to ICMPv6 and rules restricted to an IPv4 remote subnet
+//are skipped
+//
+//Test is expected to pass
+func TestFw_SecurityRuleSpecIPv6(t *testing.T) {
+	spec, err := securityRuleSpec(SecurityRule{Protocol: "icmp"}, true)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"-p", "ipv6-icmp", "-j", "ACCEPT"}, spec)
+
+	spec, err = securityRuleSpec(SecurityRule{Protocol: "tcp", PortMin: 22, PortMax: 22,
+		RemoteCIDR: "2001:db8:1::/48"}, true)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"-s", "2001:db8:1::/48", "-p", "tcp", "--dport", "22:22", "-j", "ACCEPT"}, spec)
+
+	spec, err = securityRuleSpec(SecurityRule{Protocol: "tcp", RemoteCIDR: "198.51.100.0/24"}, true)
+	assert.Nil(t, err)
+	assert.Nil(t, spec)
+
+	spec, err = securityRuleSpec(SecurityRule{Protocol: "tcp", RemoteCIDR: "2001:db8:1::/48"}, false)
+	assert.Nil(t, err)
+	assert.Nil(t, spec)
+}
+
+//Tests the chain that filters the ingress traffic of an instance
+//
+//Checks that replies come first, then the traffic accepted
+//whatever the rules, then the rules and that everything else
+//is dropped
+//
+//Test is expected to pass
+func TestFw_SecuritySpecs(t *testing.T) {
+	dhcp := []string{"-p", "udp", "--sport", "67", "--dport", "68", "-j", "ACCEPT"}
+	specs, err := iptSecuritySpecs([]SecurityRule{{Protocol: "tcp", PortMin: 22, PortMax: 22}},
+		false, dhcp)
+	require.Nil(t, err)
+	assert.Equal(t, [][]string{
+		{"-m", "state", "--state", "RELATED,ESTABLISHED", "-j", "ACCEPT"},
+		dhcp,
+		{"-p", "tcp", "--dport", "22:22", "-j", "ACCEPT"},
+		{"-j", "DROP"},
+	}, specs)
+
+	specs, err = iptSecuritySpecs(nil, true)
+	require.Nil(t, err)
+	if assert.Equal(t, 4, len(specs)) {
+		assert.Equal(t, []string{"-j", "DROP"}, specs[3])
+	}
+
+	_, err = iptSecuritySpecs([]SecurityRule{{Protocol: "gre"}}, false)
+	assert.NotNil(t, err)
+}
+
+//Tests instance ingress filtering
+//
+//Enables filtering for an instance, replaces its rules and
+//disables filtering again
+//
+//Test is expected to pass
+func TestFw_InstanceSecurity(t *testing.T) {
+	assert := assert.New(t)
+
+	fwinit()
+	fw, err := InitFirewall(fwIf)
+	require.Nil(t, err)
+
+	instanceIP := net.ParseIP("192.51.100.101")
+	rules := []SecurityRule{
+		{Protocol: "tcp", PortMin: 22, PortMax: 22},
+		{Protocol: "icmp", RemoteCIDR: "192.51.100.0/24"},
+	}
+
+	err = fw.InstanceSecurity(FwEnable, instanceIP, rules)
+	assert.Nil(err)
+
+	err = fw.InstanceSecurity(FwEnable, instanceIP, rules[:1])
+	assert.Nil(err)
+
+	err = fw.InstanceSecurity(FwEnable, instanceIP, []SecurityRule{{Protocol: "gre"}})
+	assert.NotNil(err)
+
+	err = fw.InstanceSecurity(FwDisable, instanceIP, nil)
+	assert.Nil(err)
+
+	err = fw.InstanceSecurity(FwDisable, instanceIP, nil)
+	assert.Nil(err)
+
+	err = fw.ShutdownFirewall()
+	assert.Nil(err)
+}
@@ -71,6 +71,15 @@ func (g *GreTunEP) create() error {
 		return netError(g, "create cannot create an unnamed gretap device")
 	}
 
+	if g.LocalIP == nil || g.RemoteIP == nil {
+		return netError(g, "create missing tunnel end points %v %v", g.LocalIP, g.RemoteIP)
+	}
+
+	//The tunnel is an ip6gretap device when the end points are IPv6
+	if (g.LocalIP.To4() == nil) != (g.RemoteIP.To4() == nil) {
+		return netError(g, "create mismatched tunnel end points %v %v", g.LocalIP, g.RemoteIP)
+	}
+
 	if g.LinkName == "" {
 		if g.LinkName, err = genIface(g, false); err != nil {
 			return netError(g, "create geniface %v, %v", g.GlobalID, err)
@@ -213,3 +222,15 @@ func (g *GreTunEP) detach(dev interface{}) error {
 
 	return nil
 }
+
+func (g *GreTunEP) attrs() *Attrs {
+	return &g.Attrs
+}
+
+func (g *GreTunEP) linkIndex() int {
+	return g.Link.Index
+}
+
+func (g *GreTunEP) setLinkIndex(index int) {
+	g.Link.Index = index
+}
@@ -35,6 +35,8 @@ func netError(dev interface{}, format string, args ...interface{}) error {
 		return fmt.Errorf("cncivnic error: "+format, args...)
 	case GreTunEP, *GreTunEP:
 		return fmt.Errorf("gre error: "+format, args...)
+	case VxlanEP, *VxlanEP:
+		return fmt.Errorf("vxlan error: "+format, args...)
 	}
 	return fmt.Errorf("network error: "+format, args...)
 }
@@ -97,6 +99,9 @@ const (
 	Routed NetworkMode = iota
 	// GreTunnel means tenant instances interlinked using GRE tunnels. Full tenant isolation
 	GreTunnel
+	// Vxlan means tenant instances interlinked using a VXLAN overlay whose VNI is the
+	// subnet key. Full tenant isolation as long as tenant subnets do not overlap
+	Vxlan
 )
 
 // VnicRole specifies the role of the VNIC
@@ -186,3 +191,29 @@ type GreTunEP struct {
 	CNCIId   string // UUID of the CNCI
 	CNId     string // UUID of the CN
 }
+
+// VxlanEP ciao VXLAN Tunnel representation
+// This represents the VXLAN tunnel end point of a tenant subnet on a node.
+// Unlike a GRE tunnel a single end point reaches all the other nodes of
+// the subnet, either through a multicast group or through the unicast
+// entries of its forwarding database
+type VxlanEP struct {
+	Attrs
+	Link     *netlink.Vxlan
+	VNI      uint32
+	LocalIP  net.IP
+	Group    net.IP // Multicast group, nil when remote end points are explicit
+	DevIndex int    // Index of the link that carries the tunnel traffic, optional
+}
+
+// tunnelEP is the local end point of the overlay that links a tenant
+// bridge to the other nodes of the tenant subnet
+type tunnelEP interface {
+	attrs() *Attrs
+	linkIndex() int
+	setLinkIndex(index int)
+	create() error
+	destroy() error
+	enable() error
+	attach(dev interface{}) error
+}
@@ -0,0 +1,653 @@
+//
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package libsnnet
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+)
+
+/* The nftables firewall keeps all the CIAO rules of an IP protocol in a
+   single table. The whole table is replaced in one nft transaction every
+   time a rule changes, so the kernel either sees the old or the new rule
+   set, never a partial one. A restarted CNCI replaces the table left
+   behind by its previous instance the same way.
+
+   table ip ciao {
+       chain ciao-sg-c0a80102 { ... }
+       chain ciao-network-isolation { ... }
+       chain ciao-security-groups { ... }
+       chain ciao-floating-ip-pre { ... }
+       chain ciao-floating-ip-post { ... }
+       chain prerouting { type nat hook prerouting priority -100; ... }
+       chain postrouting { type nat hook postrouting priority 100; ... }
+       chain forward { type filter hook forward priority 0; ... }
+   }
+
+   The compute nodes filter the traffic bridged to their instances in a
+   table of the bridge family, the per instance chains being named after
+   the port of the instance. Connection tracking in the bridge family
+   requires Linux 5.3 or later.
+
+   table bridge ciao {
+       chain ciao-sg-<port> { ... }
+       chain ciao-security-groups { ... }
+       chain forward { type filter hook forward priority 0; ... }
+   }
+*/
+
+const (
+	nftTable = "ciao"
+
+	floatingIPPreChain  = "ciao-floating-ip-pre"
+	floatingIPPostChain = "ciao-floating-ip-post"
+
+	//nftBridge is the family of the table filtering bridged traffic
+	nftBridge = "bridge"
+)
+
+//nftBaseChain is a chain hooked into netfilter, the rules it starts
+//with are never removed
+type nftBaseChain struct {
+	name  string
+	spec  string
+	rules []string
+}
+
+//nftBaseChains are the base chains of the ip and ip6 tables
+var nftBaseChains = []nftBaseChain{
+	{"prerouting", "type nat hook prerouting priority -100; policy accept;",
+		[]string{"jump " + floatingIPPreChain}},
+	{"postrouting", "type nat hook postrouting priority 100; policy accept;",
+		[]string{"jump " + floatingIPPostChain}},
+	{"forward", "type filter hook forward priority 0; policy accept;",
+		[]string{"jump " + isolationChain, "jump " + securityChain}},
+}
+
+//nftChains are the regular chains every ip and ip6 table holds. They
+//are listed ahead of the chains that jump to them
+var nftChains = []string{isolationChain, securityChain,
+	floatingIPPreChain, floatingIPPostChain}
+
+//nftBridgeBaseChains are the base chains of the bridge table
+var nftBridgeBaseChains = []nftBaseChain{
+	{"forward", "type filter hook forward priority 0; policy accept;",
+		[]string{"jump " + securityChain}},
+}
+
+//nftBridgeChains are the regular chains the bridge table holds
+var nftBridgeChains = []string{securityChain}
+
+//nftFamilyChains returns the regular and the base chains of the table
+//of family
+func nftFamilyChains(family string) ([]string, []nftBaseChain) {
+	if family == nftBridge {
+		return nftBridgeChains, nftBridgeBaseChains
+	}
+	return nftChains, nftBaseChains
+}
+
+//nftRules maps the name of a chain to its rules
+type nftRules map[string][]string
+
+func (r nftRules) copy() nftRules {
+	c := make(nftRules, len(r))
+	for chain, rules := range r {
+		c[chain] = append([]string(nil), rules...)
+	}
+	return c
+}
+
+func (r nftRules) exists(chain string, rule string) bool {
+	for _, ru := range r[chain] {
+		if ru == rule {
+			return true
+		}
+	}
+	return false
+}
+
+func (r nftRules) appendUnique(chain string, rule string) {
+	if !r.exists(chain, rule) {
+		r[chain] = append(r[chain], rule)
+	}
+}
+
+func (r nftRules) insertUnique(chain string, rule string) {
+	if !r.exists(chain, rule) {
+		r[chain] = append([]string{rule}, r[chain]...)
+	}
+}
+
+//delete removes rule from chain and reports whether it was present
+func (r nftRules) delete(chain string, rule string) bool {
+	for i, ru := range r[chain] {
+		if ru == rule {
+			r[chain] = append(r[chain][:i], r[chain][i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+//nftablesFw builds the firewall rules with nftables
+//rules holds the rules of the ip and ip6 families. The ip6 family is
+//missing when the node cannot filter IPv6 traffic, in which case only
+//IPv4 tenant traffic is routed
+type nftablesFw struct {
+	sync.Mutex
+	rules map[string]nftRules
+	//apply loads an nft script in a single transaction
+	apply func(script string) error
+}
+
+func newNFTablesFw() (*nftablesFw, error) {
+	path, err := exec.LookPath("nft")
+	if err != nil {
+		return nil, err
+	}
+
+	return &nftablesFw{
+		apply: func(script string) error {
+			cmd := exec.Command(path, "-f", "-")
+			cmd.Stdin = strings.NewReader(script)
+			out, err := cmd.CombinedOutput()
+			if err != nil {
+				return fmt.Errorf("nft failed %v [%s]", err, out)
+			}
+			return nil
+		},
+	}, nil
+}
+
+//nftFamily returns the nftables family of ip
+func nftFamily(ip net.IP) string {
+	if ip.To4() != nil {
+		return "ip"
+	}
+	return "ip6"
+}
+
+//nftAddr returns ip the way nft expects it in a NAT target
+func nftAddr(ip net.IP, port int) string {
+	if ip.To4() != nil {
+		return fmt.Sprintf("%s:%d", ip, port)
+	}
+	return fmt.Sprintf("[%s]:%d", ip, port)
+}
+
+func newNFTRules(family string, devices []string) nftRules {
+	rules := make(nftRules)
+	_, baseChains := nftFamilyChains(family)
+	for _, chain := range baseChains {
+		rules[chain.name] = append([]string(nil), chain.rules...)
+	}
+	for _, device := range devices {
+		rules["postrouting"] = append(rules["postrouting"],
+			fmt.Sprintf("oifname %q masquerade", device))
+	}
+	return rules
+}
+
+//ruleset returns the nft script that replaces the tables of all the
+//families with the current rules
+func (f *nftablesFw) ruleset() string {
+	var b bytes.Buffer
+
+	families := make([]string, 0, len(f.rules))
+	for family := range f.rules {
+		families = append(families, family)
+	}
+	sort.Strings(families)
+
+	for _, family := range families {
+		rules := f.rules[family]
+
+		//Declare the table first so that the delete cannot fail
+		fmt.Fprintf(&b, "table %s %s\n", family, nftTable)
+		fmt.Fprintf(&b, "delete table %s %s\n", family, nftTable)
+		fmt.Fprintf(&b, "table %s %s {\n", family, nftTable)
+
+		var sgChains []string
+		for chain := range rules {
+			if strings.HasPrefix(chain, securityChainPrefix) {
+				sgChains = append(sgChains, chain)
+			}
+		}
+		sort.Strings(sgChains)
+
+		chains, baseChains := nftFamilyChains(family)
+		for _, chain := range append(sgChains, chains...) {
+			fmt.Fprintf(&b, "\tchain %s {\n", chain)
+			for _, rule := range rules[chain] {
+				fmt.Fprintf(&b, "\t\t%s\n", rule)
+			}
+			fmt.Fprintf(&b, "\t}\n")
+		}
+
+		for _, chain := range baseChains {
+			fmt.Fprintf(&b, "\tchain %s {\n", chain.name)
+			fmt.Fprintf(&b, "\t\t%s\n", chain.spec)
+			for _, rule := range rules[chain.name] {
+				fmt.Fprintf(&b, "\t\t%s\n", rule)
+			}
+			fmt.Fprintf(&b, "\t}\n")
+		}
+
+		fmt.Fprintf(&b, "}\n")
+	}
+
+	return b.String()
+}
+
+//update applies the changes made by fn to a copy of the rules. The
+//rules are only replaced if fn succeeds and nft accepts the new rule set
+func (f *nftablesFw) update(fn func(rules map[string]nftRules) error) error {
+	f.Lock()
+	defer f.Unlock()
+
+	prev := f.rules
+	next := make(map[string]nftRules, len(prev))
+	for family, rules := range prev {
+		next[family] = rules.copy()
+	}
+
+	if err := fn(next); err != nil {
+		return err
+	}
+
+	f.rules = next
+	if err := f.apply(f.ruleset()); err != nil {
+		f.rules = prev
+		return err
+	}
+
+	return nil
+}
+
+func (f *nftablesFw) init(devices []string) error {
+	f.Lock()
+	defer f.Unlock()
+
+	f.rules = map[string]nftRules{
+		"ip":  newNFTRules("ip", devices),
+		"ip6": newNFTRules("ip6", devices),
+	}
+	err := f.apply(f.ruleset())
+	if err == nil {
+		return nil
+	}
+
+	// IPv6 is best effort, dual stack tenant subnets lose their
+	// IPv6 connectivity but IPv4 keeps working
+	Logger.Warningf("InitFirewall: IPv6 traffic will not be routed %v", err)
+	delete(f.rules, "ip6")
+	if err := f.apply(f.ruleset()); err != nil {
+		return fmt.Errorf("Error: InitFirewall NAT enable %v %v", devices, err)
+	}
+
+	return nil
+}
+
+func (f *nftablesFw) shutdown(devices []string) error {
+	return f.update(func(tables map[string]nftRules) error {
+		for _, rules := range tables {
+			for _, device := range devices {
+				rule := fmt.Sprintf("oifname %q masquerade", device)
+				if !rules.delete("postrouting", rule) {
+					return fmt.Errorf("Error: Shutdown Firewall NAT disable %v", device)
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func (f *nftablesFw) extFwding(action FwAction, extDevice string, intDevice string) error {
+	inbound := fmt.Sprintf("iifname %q oifname %q ct state established,related accept",
+		extDevice, intDevice)
+	outbound := fmt.Sprintf("iifname %q oifname %q accept", intDevice, extDevice)
+
+	return f.update(func(tables map[string]nftRules) error {
+		for _, rules := range tables {
+			switch action {
+			case FwEnable:
+				rules.appendUnique("forward", inbound)
+				rules.appendUnique("forward", outbound)
+			case FwDisable:
+				if !rules.delete("forward", inbound) {
+					return fmt.Errorf("disable inbound fwding failed: no rule [%s] [%s]",
+						extDevice, intDevice)
+				}
+				if !rules.delete("forward", outbound) {
+					return fmt.Errorf("disable outbound fwding failed: no rule [%s] [%s]",
+						intDevice, extDevice)
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func (f *nftablesFw) extPortAccess(action FwAction, protocol string, extDevice string,
+	externalPort int, internalIP net.IP, internalPort int) error {
+	if protocol != "tcp" && protocol != "udp" {
+		return fmt.Errorf("Unable to %v access for %v %v %v %v: invalid protocol",
+			action, protocol, extDevice, internalIP, externalPort)
+	}
+
+	rule := fmt.Sprintf("iifname %q %s dport %d dnat to %s",
+		extDevice, protocol, externalPort, nftAddr(internalIP, internalPort))
+
+	return f.update(func(tables map[string]nftRules) error {
+		rules := tables[nftFamily(internalIP)]
+		if rules == nil {
+			return fmt.Errorf("IPv6 not supported, unable to map %v", internalIP)
+		}
+
+		switch action {
+		case FwEnable:
+			rules.appendUnique("prerouting", rule)
+		case FwDisable:
+			rules.delete("prerouting", rule)
+		}
+		return nil
+	})
+}
+
+func (f *nftablesFw) publicIPAccess(action FwAction, internalIP net.IP, publicIP net.IP) error {
+	family := nftFamily(publicIP)
+	dnat := fmt.Sprintf("%s daddr %s dnat to %s", family, publicIP, internalIP)
+	snat := fmt.Sprintf("%s saddr %s snat to %s", family, internalIP, publicIP)
+
+	return f.update(func(tables map[string]nftRules) error {
+		rules := tables[family]
+		if rules == nil {
+			return fmt.Errorf("IPv6 not supported, unable to map %v", publicIP)
+		}
+
+		switch action {
+		case FwEnable:
+			rules.insertUnique(floatingIPPreChain, dnat)
+			rules.insertUnique(floatingIPPostChain, snat)
+		case FwDisable:
+			rules.delete(floatingIPPreChain, dnat)
+			rules.delete(floatingIPPostChain, snat)
+		}
+		return nil
+	})
+}
+
+func (f *nftablesFw) portForward(action FwAction, protocol string, publicIP net.IP,
+	publicPort int, internalIP net.IP, internalPort int) error {
+	family := nftFamily(publicIP)
+	dnat := fmt.Sprintf("%s daddr %s %s dport %d dnat to %s", family, publicIP,
+		protocol, publicPort, nftAddr(internalIP, internalPort))
+
+	return f.update(func(tables map[string]nftRules) error {
+		rules := tables[family]
+		if rules == nil {
+			return fmt.Errorf("IPv6 not supported, unable to forward %v", publicIP)
+		}
+
+		switch action {
+		case FwEnable:
+			rules.insertUnique(floatingIPPreChain, dnat)
+		case FwDisable:
+			rules.delete(floatingIPPreChain, dnat)
+		}
+		return nil
+	})
+}
+
+//nftSecurityRule returns the nft rule that accepts the traffic described
+//by rule for an IPv4 or an IPv6 instance. An empty rule is returned if
+//the remote subnet of the rule belongs to the other IP protocol
+func nftSecurityRule(rule SecurityRule, ipv6 bool) (string, error) {
+	spec, err := securityRuleSpec(rule, ipv6)
+	if err != nil || spec == nil {
+		return "", err
+	}
+
+	var match []string
+
+	if rule.RemoteCIDR != "" {
+		_, src, _ := net.ParseCIDR(rule.RemoteCIDR)
+		match = append(match, nftFamily(src.IP), "saddr", src.String())
+	}
+
+	switch {
+	case rule.Protocol == "":
+	case rule.PortMin == rule.PortMax && rule.PortMin != 0:
+		match = append(match, rule.Protocol, "dport", fmt.Sprint(rule.PortMin))
+	case rule.PortMin != 0 || rule.PortMax != 0:
+		match = append(match, rule.Protocol, "dport",
+			fmt.Sprintf("%d-%d", rule.PortMin, rule.PortMax))
+	case rule.Protocol == "icmp" && ipv6:
+		match = append(match, "meta", "l4proto", "ipv6-icmp")
+	default:
+		match = append(match, "meta", "l4proto", rule.Protocol)
+	}
+
+	return strings.Join(append(match, "accept"), " "), nil
+}
+
+func (f *nftablesFw) instanceSecurity(action FwAction, ip net.IP, rules []SecurityRule) error {
+	ipv6 := ip.To4() == nil
+	family := nftFamily(ip)
+	chain := securityChainName(ip)
+	jump := fmt.Sprintf("%s daddr %s jump %s", family, ip, chain)
+
+	chainRules := []string{"ct state established,related accept"}
+	if action == FwEnable {
+		for _, rule := range rules {
+			r, err := nftSecurityRule(rule, ipv6)
+			if err != nil {
+				return fmt.Errorf("Unable to filter %v: %v", ip, err)
+			}
+			if r != "" {
+				chainRules = append(chainRules, r)
+			}
+		}
+
+		//Neighbour discovery between instances on the same subnet
+		//is bridged through the filter table and has to go through
+		if ipv6 {
+			chainRules = append(chainRules,
+				"icmpv6 type { nd-neighbor-solicit, nd-neighbor-advert } accept")
+		}
+		chainRules = append(chainRules, "drop")
+	}
+
+	return f.update(func(tables map[string]nftRules) error {
+		tRules := tables[family]
+		if tRules == nil {
+			return fmt.Errorf("IPv6 not supported, unable to filter %v", ip)
+		}
+
+		switch action {
+		case FwEnable:
+			tRules[chain] = chainRules
+			tRules.appendUnique(securityChain, jump)
+		case FwDisable:
+			if tRules.delete(securityChain, jump) {
+				delete(tRules, chain)
+			}
+		}
+		return nil
+	})
+}
+
+func (f *nftablesFw) networkIsolation(action FwAction, subnet1 net.IPNet, subnet2 net.IPNet) error {
+	drops := []string{
+		fmt.Sprintf("ip saddr %s ip daddr %s drop", subnet1.String(), subnet2.String()),
+		fmt.Sprintf("ip saddr %s ip daddr %s drop", subnet2.String(), subnet1.String()),
+	}
+
+	return f.update(func(tables map[string]nftRules) error {
+		for _, drop := range drops {
+			switch action {
+			case FwEnable:
+				tables["ip"].appendUnique(isolationChain, drop)
+			case FwDisable:
+				tables["ip"].delete(isolationChain, drop)
+			}
+		}
+		return nil
+	})
+}
+
+func (f *nftablesFw) initBridgeSecurity() error {
+	f.Lock()
+	defer f.Unlock()
+
+	f.rules = map[string]nftRules{
+		"ip":      newNFTRules("ip", nil),
+		"ip6":     newNFTRules("ip6", nil),
+		nftBridge: newNFTRules(nftBridge, nil),
+	}
+	err := f.apply(f.ruleset())
+	if err == nil {
+		return nil
+	}
+
+	//The bridged IPv6 traffic is filtered in the bridge table, only
+	//the forwarding of the IPv6 traffic of the bridges is lost
+	Logger.Warningf("CN Init: IPv6 traffic will not be forwarded %v", err)
+	delete(f.rules, "ip6")
+	if err := f.apply(f.ruleset()); err != nil {
+		return fmt.Errorf("Error: CN Init bridge filtering %v", err)
+	}
+
+	return nil
+}
+
+func (f *nftablesFw) bridgeFwding(action FwAction, bridge string) error {
+	rule := fmt.Sprintf("iifname %q accept", bridge)
+
+	return f.update(func(tables map[string]nftRules) error {
+		for family, rules := range tables {
+			if family == nftBridge {
+				continue
+			}
+			switch action {
+			case FwEnable:
+				rules.appendUnique("forward", rule)
+			case FwDisable:
+				rules.delete("forward", rule)
+			}
+		}
+		return nil
+	})
+}
+
+//nftPortSecurityAccept is the traffic the CNCI sends to the instances of a
+//subnet without them asking for it first, see cnSecurityAccept
+var nftPortSecurityAccept = []string{
+	"udp sport 67 udp dport 68 accept",
+}
+
+var nftPortSecurityAcceptV6 = []string{
+	"udp sport 547 udp dport 546 accept",
+	"icmpv6 type nd-router-advert accept",
+}
+
+//portSecurity filters the traffic bridged to port in the bridge table.
+//Only IPv4 traffic, and IPv6 traffic for dual stack instances, is sent
+//through the chain of the port, ARP and other protocols go through
+func (f *nftablesFw) portSecurity(action FwAction, port string, ipv6 bool, rules []SecurityRule) error {
+	chain := securityChainPrefix + port
+	jumps := []string{fmt.Sprintf("oifname %q meta protocol ip jump %s", port, chain)}
+	if ipv6 {
+		jumps = append(jumps, fmt.Sprintf("oifname %q meta protocol ip6 jump %s", port, chain))
+	}
+
+	chainRules := nftRules{chain: {"ct state established,related accept"}}
+	if action == FwEnable {
+		for _, r := range nftPortSecurityAccept {
+			chainRules.appendUnique(chain, r)
+		}
+		if ipv6 {
+			for _, r := range nftPortSecurityAcceptV6 {
+				chainRules.appendUnique(chain, r)
+			}
+		}
+
+		for _, rule := range rules {
+			for _, v6 := range []bool{false, true} {
+				if v6 && !ipv6 {
+					continue
+				}
+				r, err := nftSecurityRule(rule, v6)
+				if err != nil {
+					return fmt.Errorf("Unable to filter %s: %v", port, err)
+				}
+				if r != "" {
+					chainRules.appendUnique(chain, r)
+				}
+			}
+		}
+
+		if ipv6 {
+			chainRules.appendUnique(chain,
+				"icmpv6 type { nd-neighbor-solicit, nd-neighbor-advert } accept")
+		}
+		chainRules.appendUnique(chain, "drop")
+	}
+
+	return f.update(func(tables map[string]nftRules) error {
+		tRules := tables[nftBridge]
+		if tRules == nil {
+			return fmt.Errorf("Unable to filter %s: no bridge filtering", port)
+		}
+
+		//The jumps of a port filtered for both IP protocols are
+		//a superset of the ones of an IPv4 only port
+		tRules.delete(securityChain, fmt.Sprintf("oifname %q meta protocol ip6 jump %s", port, chain))
+
+		switch action {
+		case FwEnable:
+			tRules[chain] = chainRules[chain]
+			for _, jump := range jumps {
+				tRules.appendUnique(securityChain, jump)
+			}
+		case FwDisable:
+			tRules.delete(securityChain, jumps[0])
+			delete(tRules, chain)
+		}
+		return nil
+	})
+}
+
+func (f *nftablesFw) dump() string {
+	return DumpNFTables()
+}
+
+//DumpNFTables provides a utility routine that returns
+//the current state of the nftables
+func DumpNFTables() string {
+	table, err := exec.Command("nft", "list", "ruleset").CombinedOutput()
+	if err != nil {
+		return fmt.Sprintf("unable to list nft ruleset %v", err)
+	}
+	return fmt.Sprintf("nft list ruleset =[%s]", string(table))
+}
@@ -0,0 +1,252 @@
+//
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package libsnnet
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+//nftTestFw returns an nftables firewall that records the scripts it
+//loads instead of running nft. Loading fails while fail is set
+func nftTestFw(scripts *[]string, fail *bool) *nftablesFw {
+	return &nftablesFw{
+		apply: func(script string) error {
+			if *fail {
+				return fmt.Errorf("nft failed")
+			}
+			*scripts = append(*scripts, script)
+			return nil
+		},
+	}
+}
+
+//Tests the nftables backend firewall primitives
+//
+//Checks that every primitive replaces the ciao tables with a rule set
+//holding the expected rules, and that disabling removes them
+//
+//Test is expected to pass
+func TestNft_Ruleset(t *testing.T) {
+	assert := assert.New(t)
+
+	var scripts []string
+	fail := false
+	f := nftTestFw(&scripts, &fail)
+	require.Nil(t, f.init([]string{"eth0"}))
+
+	script := scripts[len(scripts)-1]
+	for _, family := range []string{"ip", "ip6"} {
+		assert.Contains(script, "table "+family+" ciao\ndelete table "+family+" ciao\n")
+	}
+	assert.Contains(script, "type nat hook prerouting priority -100; policy accept;\n\t\tjump ciao-floating-ip-pre\n")
+	assert.Contains(script, "jump ciao-floating-ip-post\n\t\toifname \"eth0\" masquerade\n")
+	assert.Contains(script, "jump ciao-network-isolation\n\t\tjump ciao-security-groups\n")
+
+	intIP := net.ParseIP("192.168.1.2")
+	_, subnet1, _ := net.ParseCIDR("192.168.1.0/24")
+	_, subnet2, _ := net.ParseCIDR("192.168.2.0/24")
+	rules := []SecurityRule{{Protocol: "tcp", PortMin: 22, PortMax: 22}}
+
+	assert.Nil(f.extFwding(FwEnable, "eth0", "br0"))
+	assert.Nil(f.extPortAccess(FwEnable, "tcp", "eth0", 33000, intIP, 22))
+	assert.Nil(f.publicIPAccess(FwEnable, intIP, net.ParseIP("198.51.100.1")))
+	assert.Nil(f.portForward(FwEnable, "udp", net.ParseIP("198.51.100.2"), 5353, intIP, 53))
+	assert.Nil(f.instanceSecurity(FwEnable, intIP, rules))
+	assert.Nil(f.networkIsolation(FwEnable, *subnet1, *subnet2))
+
+	expected := []string{
+		"iifname \"eth0\" oifname \"br0\" ct state established,related accept",
+		"iifname \"br0\" oifname \"eth0\" accept",
+		"iifname \"eth0\" tcp dport 33000 dnat to 192.168.1.2:22",
+		"ip daddr 198.51.100.1 dnat to 192.168.1.2",
+		"ip saddr 192.168.1.2 snat to 198.51.100.1",
+		"ip daddr 198.51.100.2 udp dport 5353 dnat to 192.168.1.2:53",
+		"chain ciao-sg-c0a80102 {\n\t\tct state established,related accept\n\t\ttcp dport 22 accept\n\t\tdrop\n",
+		"ip daddr 192.168.1.2 jump ciao-sg-c0a80102",
+		"ip saddr 192.168.1.0/24 ip daddr 192.168.2.0/24 drop",
+		"ip saddr 192.168.2.0/24 ip daddr 192.168.1.0/24 drop",
+	}
+
+	script = scripts[len(scripts)-1]
+	for _, e := range expected {
+		assert.Contains(script, e)
+	}
+
+	assert.Nil(f.extFwding(FwDisable, "eth0", "br0"))
+	assert.Nil(f.extPortAccess(FwDisable, "tcp", "eth0", 33000, intIP, 22))
+	assert.Nil(f.publicIPAccess(FwDisable, intIP, net.ParseIP("198.51.100.1")))
+	assert.Nil(f.portForward(FwDisable, "udp", net.ParseIP("198.51.100.2"), 5353, intIP, 53))
+	assert.Nil(f.instanceSecurity(FwDisable, intIP, nil))
+	assert.Nil(f.networkIsolation(FwDisable, *subnet1, *subnet2))
+
+	script = scripts[len(scripts)-1]
+	for _, e := range expected {
+		assert.NotContains(script, e)
+	}
+
+	assert.NotNil(f.extFwding(FwDisable, "eth0", "br0"))
+	assert.Nil(f.shutdown([]string{"eth0"}))
+	assert.NotContains(scripts[len(scripts)-1], "masquerade")
+}
+
+//Tests that a rule set nft rejects is not kept
+//
+//Checks that the rules are unchanged after nft fails to load the
+//rule set and that IPv6 is dropped if nft cannot handle it
+//
+//Test is expected to pass
+func TestNft_Rollback(t *testing.T) {
+	assert := assert.New(t)
+
+	var scripts []string
+	fail := false
+	f := nftTestFw(&scripts, &fail)
+	f.apply = func(script string) error {
+		if fail || strings.Contains(script, "table ip6 ciao") {
+			return fmt.Errorf("nft failed")
+		}
+		scripts = append(scripts, script)
+		return nil
+	}
+
+	require.Nil(t, f.init([]string{"eth0"}))
+	assert.NotContains(scripts[len(scripts)-1], "ip6")
+	assert.NotNil(f.instanceSecurity(FwEnable, net.ParseIP("fd00::2"), nil))
+
+	fail = true
+	assert.NotNil(f.extFwding(FwEnable, "eth0", "br0"))
+
+	fail = false
+	assert.NotNil(f.extFwding(FwDisable, "eth0", "br0"))
+	assert.NotContains(f.ruleset(), "br0")
+}
+
+//Tests the nftables backend compute node primitives
+//
+//Checks that the compute node tables let the traffic of the bridges
+//through and filter the traffic bridged to a port in the bridge table,
+//and that disabling removes the rules
+//
+//Test is expected to pass
+func TestNft_BridgeSecurity(t *testing.T) {
+	assert := assert.New(t)
+
+	var scripts []string
+	fail := false
+	f := nftTestFw(&scripts, &fail)
+	require.Nil(t, f.initBridgeSecurity())
+
+	script := scripts[len(scripts)-1]
+	assert.Contains(script, "table bridge ciao {\n\tchain ciao-security-groups {\n\t}\n"+
+		"\tchain forward {\n\t\ttype filter hook forward priority 0; policy accept;\n"+
+		"\t\tjump ciao-security-groups\n")
+	assert.NotContains(script, "masquerade")
+
+	rules := []SecurityRule{
+		{Protocol: "tcp", PortMin: 22, PortMax: 22},
+		{Protocol: "icmp", RemoteCIDR: "192.168.1.0/24"},
+	}
+
+	assert.Nil(f.bridgeFwding(FwEnable, "br0"))
+	assert.Nil(f.portSecurity(FwEnable, "vnic0", false, rules))
+
+	expected := []string{
+		"jump ciao-security-groups\n\t\tiifname \"br0\" accept\n",
+		"chain ciao-sg-vnic0 {\n\t\tct state established,related accept\n" +
+			"\t\tudp sport 67 udp dport 68 accept\n\t\ttcp dport 22 accept\n" +
+			"\t\tip saddr 192.168.1.0/24 meta l4proto icmp accept\n\t\tdrop\n",
+		"oifname \"vnic0\" meta protocol ip jump ciao-sg-vnic0",
+	}
+
+	script = scripts[len(scripts)-1]
+	for _, e := range expected {
+		assert.Contains(script, e)
+	}
+	assert.NotContains(script, "meta protocol ip6")
+
+	//Dual stack ports also send their IPv6 traffic through the chain
+	assert.Nil(f.portSecurity(FwEnable, "vnic0", true, rules[:1]))
+	script = scripts[len(scripts)-1]
+	assert.Contains(script, "oifname \"vnic0\" meta protocol ip6 jump ciao-sg-vnic0")
+	assert.Contains(script, "icmpv6 type nd-router-advert accept")
+	assert.NotContains(script, "meta l4proto icmp")
+
+	assert.Nil(f.portSecurity(FwDisable, "vnic0", false, nil))
+	assert.Nil(f.bridgeFwding(FwDisable, "br0"))
+
+	script = scripts[len(scripts)-1]
+	assert.NotContains(script, "vnic0")
+	assert.NotContains(script, "br0")
+
+	assert.NotNil(f.portSecurity(FwEnable, "vnic0", false,
+		[]SecurityRule{{Protocol: "gre"}}))
+}
+
+//Tests the translation of security rules into nft rules
+//
+//Checks that valid rules are translated for both IP protocols and
+//invalid rules are rejected
+//
+//Test is expected to pass
+func TestNft_SecurityRule(t *testing.T) {
+	var ruleTests = []struct {
+		rule     SecurityRule
+		ipv6     bool
+		expected string
+	}{
+		{SecurityRule{}, false, "accept"},
+		{SecurityRule{Protocol: "tcp", PortMin: 22, PortMax: 22}, false,
+			"tcp dport 22 accept"},
+		{SecurityRule{Protocol: "udp", PortMin: 5000, PortMax: 5100}, false,
+			"udp dport 5000-5100 accept"},
+		{SecurityRule{Protocol: "tcp"}, false, "meta l4proto tcp accept"},
+		{SecurityRule{Protocol: "icmp", RemoteCIDR: "10.0.0.0/8"}, false,
+			"ip saddr 10.0.0.0/8 meta l4proto icmp accept"},
+		{SecurityRule{Protocol: "icmp", RemoteCIDR: "fd00::/64"}, true,
+			"ip6 saddr fd00::/64 meta l4proto ipv6-icmp accept"},
+		{SecurityRule{RemoteCIDR: "fd00::/64"}, false, ""},
+	}
+
+	for _, test := range ruleTests {
+		rule, err := nftSecurityRule(test.rule, test.ipv6)
+		assert.Nil(t, err)
+		assert.Equal(t, test.expected, rule)
+	}
+
+	_, err := nftSecurityRule(SecurityRule{Protocol: "tcp", PortMin: 80, PortMax: 22}, false)
+	assert.NotNil(t, err)
+}
+
+//Tests the parsing of firewall backend names
+//
+//Test is expected to pass
+func TestFw_ParseBackend(t *testing.T) {
+	for _, b := range []FwBackend{FwAuto, FwIPTables, FwNFTables} {
+		parsed, err := ParseFwBackend(b.String())
+		assert.Nil(t, err)
+		assert.Equal(t, b, parsed)
+	}
+
+	_, err := ParseFwBackend("pf")
+	assert.NotNil(t, err)
+}
@@ -35,6 +35,7 @@ const (
 	prefixVnicHost = "svn"
 	prefixCnciVnic = "svc"
 	prefixGretap   = "sgt"
+	prefixVxlan    = "svx"
 )
 
 const ifaceRetryLimit = 10
@@ -76,6 +77,7 @@ func validSnPrefix(s string) bool {
 	case strings.HasPrefix(s, prefixVnicHost):
 	case strings.HasPrefix(s, prefixCnciVnic):
 	case strings.HasPrefix(s, prefixGretap):
+	case strings.HasPrefix(s, prefixVxlan):
 	default:
 		return false
 	}
@@ -99,6 +101,8 @@ func getPrefix(device interface{}) (string, error) {
 		}
 	case *GreTunEP:
 		prefix = prefixGretap
+	case *VxlanEP:
+		prefix = prefixVxlan
 	case *CnciVnic:
 		prefix = prefixCnciVnic
 	}
@@ -166,3 +170,62 @@ func validPhysicalLink(link netlink.Link) bool {
 	}
 	return phyDevice
 }
+
+//nodeAddrs filters the addresses of a physical link down to the ones that
+//can be used to reach the node. IPv6 addresses are only used when they
+//belong to one of the configured networks, so auto configuration always
+//picks IPv4 addresses
+func nodeAddrs(addrs []netlink.Addr, nets ...[]net.IPNet) []netlink.Addr {
+	var nodeAddrs []netlink.Addr
+
+	for _, addr := range addrs {
+		if addr.IPNet == nil {
+			continue
+		}
+
+		if addr.IP.To4() != nil {
+			nodeAddrs = append(nodeAddrs, addr)
+			continue
+		}
+
+		if !addr.IP.IsGlobalUnicast() {
+			continue
+		}
+
+	nets:
+		for _, n := range nets {
+			for _, subnet := range n {
+				if subnet.Contains(addr.IP) {
+					nodeAddrs = append(nodeAddrs, addr)
+					break nets
+				}
+			}
+		}
+	}
+
+	return nodeAddrs
+}
+
+//tunnelLocalIP picks the local tunnel end point for a remote end point.
+//The address has to be of the same family as the remote, otherwise the
+//first address is returned and tunnel creation reports the mismatch
+func tunnelLocalIP(addrs []netlink.Addr, remote net.IP) net.IP {
+	for _, addr := range addrs {
+		if (addr.IP.To4() == nil) == (remote.To4() == nil) {
+			return addr.IP
+		}
+	}
+	return addrs[0].IP
+}
+
+//tunnelLocalIndex returns the index of the link that owns the local tunnel
+//end point or 0 if it is not one of the node addresses. links and addrs
+//are the parallel compute link and address lists of the node
+func tunnelLocalIndex(links []netlink.Link, addrs []netlink.Addr, local net.IP) int {
+	for i, addr := range addrs {
+		if i < len(links) && addr.IP.Equal(local) {
+			return links[i].Attrs().Index
+		}
+	}
+	return 0
+}
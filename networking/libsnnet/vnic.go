@@ -20,7 +20,6 @@ import (
 	"fmt"
 	"net"
 	"strings"
-	"syscall"
 
 	"github.com/vishvananda/netlink"
 )
@@ -29,7 +28,7 @@ import (
 // This has to be called prior to Create() or GetDevice()
 func NewVnic(id string) (*Vnic, error) {
 	Vnic := &Vnic{}
-	Vnic.Link = &netlink.GenericLink{}
+	Vnic.Link = &netlink.Tuntap{}
 	Vnic.GlobalID = id
 	Vnic.Role = TenantVM
 	return Vnic, nil
@@ -93,18 +92,13 @@ func (v *Vnic) GetDevice() error {
 
 	switch v.Role {
 	case TenantVM:
-		vl, ok := link.(*netlink.GenericLink)
+		vl, ok := link.(*netlink.Tuntap)
 		if !ok {
 			return netError(v, "get device incorrect interface type %v %v", v.GlobalID, link.Type())
 		}
 
-		// TODO: Why do both tun and tap interfaces return the type tun
-		if link.Type() != "tun" {
-			return netError(v, "get device incorrect interface type %v %v", v.GlobalID, link.Type())
-		}
-
-		if flags := uint(link.Attrs().Flags); (flags & syscall.IFF_TAP) == 0 {
-			return netError(v, "get device incorrect interface type %v %v", v.GlobalID, link)
+		if vl.Mode != netlink.TUNTAP_MODE_TAP {
+			return netError(v, "get device incorrect interface type %v %v", v.GlobalID, vl.Mode)
 		}
 		v.LinkName = vl.Name
 		v.Link = vl
@@ -133,18 +127,13 @@ func (v *Vnic) GetDeviceByName(linkName string) error {
 
 	switch v.Role {
 	case TenantVM:
-		vl, ok := link.(*netlink.GenericLink)
+		vl, ok := link.(*netlink.Tuntap)
 		if !ok {
 			return netError(v, "get device incorrect interface type %v %v", linkName, link.Type())
 		}
 
-		// TODO: Why do both tun and tap interfaces return the type tun
-		if link.Type() != "tun" {
-			return netError(v, "get device incorrect interface type %v %v", linkName, link.Type())
-		}
-
-		if flags := uint(link.Attrs().Flags); (flags & syscall.IFF_TAP) == 0 {
-			return netError(v, "get device incorrect interface type %v %v", linkName, link)
+		if vl.Mode != netlink.TUNTAP_MODE_TAP {
+			return netError(v, "get device incorrect interface type %v %v", linkName, vl.Mode)
 		}
 		v.LinkName = vl.Name
 		v.Link = vl
@@ -178,7 +167,7 @@ func createVMVnic(v *Vnic) (link netlink.Link, err error) {
 		return nil, netError(v, "create link by name %v %v", v.GlobalID, err)
 	}
 
-	vl, ok := link.(*netlink.GenericLink)
+	vl, ok := link.(*netlink.Tuntap)
 	if !ok {
 		return nil, netError(v, "create incorrect interface type %v %v", v.GlobalID, link.Type())
 	}
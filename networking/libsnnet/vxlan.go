@@ -0,0 +1,347 @@
+//
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package libsnnet
+
+import (
+	"net"
+	"syscall"
+
+	"github.com/vishvananda/netlink"
+)
+
+//vxlanPort is the IANA assigned VXLAN port. The kernel defaults to the
+//legacy Linux port otherwise
+const vxlanPort = 4789
+
+//MaxSubnetKey is the largest subnet key. The subnet keys are used as the
+//VXLAN network identifiers which are only 24 bits long
+const MaxSubnetKey = 1<<24 - 1
+
+//Broadcast, unknown unicast and multicast frames are sent to every
+//remote end point that has an entry for the all zeros MAC address
+var vxlanFloodMAC = net.HardwareAddr{0, 0, 0, 0, 0, 0}
+
+// newVxlanEP is used to initialize the VXLAN tunnel properties
+// This has to be called prior to create() or getDevice()
+func newVxlanEP(id string, localIP net.IP, group net.IP, vni uint32) (*VxlanEP, error) {
+	vxlan := &VxlanEP{}
+	vxlan.Link = &netlink.Vxlan{}
+	vxlan.GlobalID = id
+	vxlan.LocalIP = localIP
+	vxlan.Group = group
+	vxlan.VNI = vni
+	return vxlan, nil
+}
+
+// getDevice associates the tunnel with an existing VXLAN tunnel end point
+func (v *VxlanEP) getDevice() error {
+
+	if v.GlobalID == "" {
+		return netError(v, "get device unnamed vxlan device")
+	}
+
+	link, err := netlink.LinkByAlias(v.GlobalID)
+	if err != nil {
+		return netError(v, "get device interface does not exist: %v %v", v.GlobalID, err)
+	}
+
+	vl, ok := link.(*netlink.Vxlan)
+	if !ok {
+		return netError(v, "get device incorrect interface type %v %v", v.GlobalID, link.Type())
+	}
+	v.Link = vl
+	v.LinkName = vl.Name
+	v.LocalIP = vl.SrcAddr
+	v.Group = vl.Group
+	v.VNI = uint32(vl.VxlanId)
+	v.DevIndex = vl.VtepDevIndex
+
+	return nil
+}
+
+// create instantiates a tunnel
+func (v *VxlanEP) create() error {
+	var err error
+
+	if v.GlobalID == "" || v.VNI == 0 {
+		return netError(v, "create cannot create an unnamed vxlan device")
+	}
+
+	if v.Group != nil {
+		if !v.Group.IsMulticast() {
+			return netError(v, "create group is not multicast %v", v.Group)
+		}
+		if v.LocalIP != nil && (v.LocalIP.To4() == nil) != (v.Group.To4() == nil) {
+			return netError(v, "create mismatched group and end point %v %v", v.Group, v.LocalIP)
+		}
+	}
+
+	if v.LinkName == "" {
+		if v.LinkName, err = genIface(v, false); err != nil {
+			return netError(v, "create geniface %v, %v", v.GlobalID, err)
+		}
+
+		if lerr, err := netlink.LinkByAlias(v.GlobalID); err == nil {
+			return netError(v, "create interface exists %v, %v", v.GlobalID, lerr)
+		}
+	}
+
+	attrs := netlink.NewLinkAttrs()
+	attrs.Name = v.LinkName
+
+	vxlan := &netlink.Vxlan{LinkAttrs: attrs,
+		VxlanId:      int(v.VNI),
+		VtepDevIndex: v.DevIndex,
+		SrcAddr:      v.LocalIP,
+		Group:        v.Group,
+		Learning:     true,
+		Port:         vxlanPort,
+	}
+
+	if err := netlink.LinkAdd(vxlan); err != nil {
+		return netError(v, "create link add %v %v", v.GlobalID, err)
+	}
+
+	link, err := netlink.LinkByName(v.LinkName)
+	if err != nil {
+		return netError(v, "create link by name %v %v", v.GlobalID, err)
+	}
+
+	vl, ok := link.(*netlink.Vxlan)
+	if !ok {
+		return netError(v, "create incorrect interface type %v, %v", v.GlobalID, link.Type())
+	}
+	v.Link = vl
+
+	if err := v.setAlias(v.GlobalID); err != nil {
+		_ = v.destroy()
+		return netError(v, "create link set alias %v %v", v.GlobalID, err)
+	}
+
+	return nil
+}
+
+// destroy an existing tunnel
+func (v *VxlanEP) destroy() error {
+
+	if v.Link == nil || v.Link.Index == 0 {
+		return netError(v, "destroy invalid vxlan link: %v", v)
+	}
+
+	if err := netlink.LinkDel(v.Link); err != nil {
+		return netError(v, "destroy link del %v", err)
+	}
+
+	return nil
+}
+
+// enable the tunnel
+func (v *VxlanEP) enable() error {
+
+	if v.Link == nil || v.Link.Index == 0 {
+		return netError(v, "enable invalid vxlan link: %v", v)
+	}
+
+	if err := netlink.LinkSetUp(v.Link); err != nil {
+		return netError(v, "enable link enable %v", err)
+	}
+
+	return nil
+}
+
+// disable the tunnel
+func (v *VxlanEP) disable() error {
+	if v.Link == nil || v.Link.Index == 0 {
+		return netError(v, "disable invalid vxlan link: %v", v)
+	}
+
+	if err := netlink.LinkSetDown(v.Link); err != nil {
+		return netError(v, "disable link disable %v", err)
+	}
+	return nil
+}
+
+func (v *VxlanEP) setAlias(alias string) error {
+	if v.Link == nil || v.Link.Index == 0 {
+		return netError(v, "set alias invalid vxlan link: %v", v)
+	}
+
+	if err := netlink.LinkSetAlias(v.Link, alias); err != nil {
+		return netError(v, "set alias link set alias %v %v", alias, err)
+	}
+
+	return nil
+}
+
+// attach the VXLAN tunnel to a device/bridge/switch
+func (v *VxlanEP) attach(dev interface{}) error {
+
+	if v.Link == nil || v.Link.Index == 0 {
+		return netError(v, "attach vxlan tunnel unnitialized")
+	}
+
+	br, ok := dev.(*Bridge)
+	if !ok {
+		return netError(v, "attach unknown device %v, %T", dev, dev)
+	}
+
+	if br.Link == nil || br.Link.Index == 0 {
+		return netError(v, "attach bridge unnitialized")
+	}
+
+	err := netlink.LinkSetMaster(v.Link, br.Link)
+	if err != nil {
+		return netError(v, "attach link set master %v", err)
+	}
+
+	return nil
+}
+
+// detach the VXLAN Tunnel from the device/bridge it is attached to
+func (v *VxlanEP) detach(dev interface{}) error {
+	if v.Link == nil || v.Link.Index == 0 {
+		return netError(v, "detach invalid vxlan link: %v", v)
+	}
+
+	br, ok := dev.(*Bridge)
+	if !ok {
+		return netError(v, "detach incorrect device type %v, %T", dev, dev)
+	}
+
+	if br.Link == nil || br.Link.Index == 0 {
+		return netError(v, "detach bridge unnitialized")
+	}
+
+	if err := netlink.LinkSetNoMaster(v.Link); err != nil {
+		return netError(v, "detach link set no master %v", err)
+	}
+
+	return nil
+}
+
+func (v *VxlanEP) remoteEntry(remote net.IP) *netlink.Neigh {
+	return &netlink.Neigh{
+		LinkIndex:    v.Link.Index,
+		Family:       syscall.AF_BRIDGE,
+		State:        netlink.NUD_PERMANENT,
+		Flags:        netlink.NTF_SELF,
+		IP:           remote,
+		HardwareAddr: vxlanFloodMAC,
+	}
+}
+
+// addRemote adds a remote end point to the forwarding database of the
+// tunnel. Equivalent to bridge fdb append 00:00:00:00:00:00 dev <v> dst <remote>
+func (v *VxlanEP) addRemote(remote net.IP) error {
+	if v.Link == nil || v.Link.Index == 0 {
+		return netError(v, "add remote invalid vxlan link: %v", v)
+	}
+
+	err := netlink.NeighAppend(v.remoteEntry(remote))
+	if err != nil && err != syscall.EEXIST {
+		return netError(v, "add remote %v %v", remote, err)
+	}
+
+	return nil
+}
+
+// delRemote removes a remote end point from the forwarding database of
+// the tunnel
+func (v *VxlanEP) delRemote(remote net.IP) error {
+	if v.Link == nil || v.Link.Index == 0 {
+		return netError(v, "delete remote invalid vxlan link: %v", v)
+	}
+
+	err := netlink.NeighDel(v.remoteEntry(remote))
+	if err != nil && err != syscall.ENOENT {
+		return netError(v, "delete remote %v %v", remote, err)
+	}
+
+	return nil
+}
+
+// remotes lists the remote end points in the forwarding database of the
+// tunnel. The MAC addresses learnt from the remote end points are skipped
+func (v *VxlanEP) remotes() ([]net.IP, error) {
+	if v.Link == nil || v.Link.Index == 0 {
+		return nil, netError(v, "remotes invalid vxlan link: %v", v)
+	}
+
+	entries, err := netlink.NeighList(v.Link.Index, syscall.AF_BRIDGE)
+	if err != nil {
+		return nil, netError(v, "remotes neigh list %v", err)
+	}
+
+	var remotes []net.IP
+	for _, e := range entries {
+		if e.IP == nil || e.HardwareAddr.String() != vxlanFloodMAC.String() {
+			continue
+		}
+		remotes = append(remotes, e.IP)
+	}
+
+	return remotes, nil
+}
+
+// setRemotes updates the forwarding database of the tunnel so that it
+// holds exactly the specified remote end points
+func (v *VxlanEP) setRemotes(remotes []net.IP) error {
+	current, err := v.remotes()
+	if err != nil {
+		return err
+	}
+
+	wanted := make(map[string]bool)
+	for _, r := range remotes {
+		wanted[r.String()] = true
+	}
+
+	present := make(map[string]bool)
+	for _, r := range current {
+		if !wanted[r.String()] {
+			if err := v.delRemote(r); err != nil {
+				return err
+			}
+			continue
+		}
+		present[r.String()] = true
+	}
+
+	for _, r := range remotes {
+		if present[r.String()] {
+			continue
+		}
+		if err := v.addRemote(r); err != nil {
+			return err
+		}
+		present[r.String()] = true
+	}
+
+	return nil
+}
+
+func (v *VxlanEP) attrs() *Attrs {
+	return &v.Attrs
+}
+
+func (v *VxlanEP) linkIndex() int {
+	return v.Link.Index
+}
+
+func (v *VxlanEP) setLinkIndex(index int) {
+	v.Link.Index = index
+}
@@ -0,0 +1,305 @@
+//
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package libsnnet
+
+import (
+	"net"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+)
+
+func performVxlanOps(shouldPass bool, assert *assert.Assertions, vxlan *VxlanEP) {
+	a := assert.Nil
+	if !shouldPass {
+		a = assert.NotNil
+	}
+	a(vxlan.enable())
+	a(vxlan.disable())
+	a(vxlan.destroy())
+}
+
+//Test all VXLAN tunnel primitives
+//
+//Tests create, enable, disable and destroy of VXLAN tunnels
+//Failure indicates changes in netlink or kernel and in some
+//case pre-existing tunnels on the test node. Ensure that
+//there are no existing conflicting tunnels before running
+//this test
+//
+//Test is expected to pass
+func TestVxlan_Basic(t *testing.T) {
+	assert := assert.New(t)
+	id := "testvxlan"
+	local := net.ParseIP("127.0.0.1")
+	vni := uint32(0xF)
+
+	vxlan, err := newVxlanEP(id, local, nil, vni)
+	assert.Nil(err)
+
+	assert.Nil(vxlan.create())
+	assert.Nil(vxlan.getDevice())
+	assert.Equal(vni, vxlan.VNI)
+	assert.Nil(vxlan.Group)
+	performVxlanOps(true, assert, vxlan)
+	assert.NotNil(vxlan.destroy())
+}
+
+//Test VXLAN tunnel bridge interactions
+//
+//Test all bridge, vxlan tunnel interactions including
+//attach, detach, enable, disable, destroy
+//
+//Test is expected to pass
+func TestVxlan_Bridge(t *testing.T) {
+	assert := assert.New(t)
+	id := "testvxlan"
+	local := net.ParseIP("127.0.0.1")
+	vni := uint32(0xF)
+
+	vxlan, err := newVxlanEP(id, local, nil, vni)
+	assert.Nil(err)
+	bridge, err := NewBridge("testbridge")
+	assert.Nil(err)
+
+	assert.Nil(vxlan.create())
+	defer func() { _ = vxlan.destroy() }()
+
+	assert.Nil(bridge.Create())
+	defer func() { _ = bridge.Destroy() }()
+
+	assert.Nil(vxlan.attach(bridge))
+	//Duplicate
+	assert.Nil(vxlan.attach(bridge))
+	assert.Nil(vxlan.enable())
+	assert.Nil(bridge.Enable())
+	assert.Nil(vxlan.detach(bridge))
+	//Duplicate
+	assert.Nil(vxlan.detach(bridge))
+}
+
+//Test the VXLAN forwarding database
+//
+//Tests that remote end points can be added, replaced
+//and deleted and that duplicate operations are tolerated
+//
+//Test is expected to pass
+func TestVxlan_Remotes(t *testing.T) {
+	assert := assert.New(t)
+	local := net.ParseIP("127.0.0.1")
+	remote1 := net.ParseIP("127.0.0.2")
+	remote2 := net.ParseIP("127.0.0.3")
+	remote3 := net.ParseIP("127.0.0.4")
+
+	vxlan, err := newVxlanEP("testvxlan", local, nil, 0xF)
+	assert.Nil(err)
+
+	assert.Nil(vxlan.create())
+	defer func() { _ = vxlan.destroy() }()
+
+	assert.Nil(vxlan.addRemote(remote1))
+	//Duplicate
+	assert.Nil(vxlan.addRemote(remote1))
+	assert.Nil(vxlan.addRemote(remote2))
+
+	remotes, err := vxlan.remotes()
+	assert.Nil(err)
+	assert.Equal(2, len(remotes))
+
+	assert.Nil(vxlan.setRemotes([]net.IP{remote2, remote3}))
+	remotes, err = vxlan.remotes()
+	assert.Nil(err)
+	assert.Equal(2, len(remotes))
+	for _, r := range remotes {
+		assert.False(r.Equal(remote1))
+	}
+
+	assert.Nil(vxlan.delRemote(remote2))
+	//Duplicate
+	assert.Nil(vxlan.delRemote(remote2))
+
+	remotes, err = vxlan.remotes()
+	assert.Nil(err)
+	require.Equal(t, 1, len(remotes))
+	assert.True(remotes[0].Equal(remote3))
+}
+
+//Tests failure paths in the VXLAN tunnel
+//
+//Tests that a duplicate tunnel and a tunnel with
+//a unicast group cannot be created
+//
+//Test is expected to pass
+func TestVxlan_Negative(t *testing.T) {
+	assert := assert.New(t)
+	id := "testvxlan"
+	local := net.ParseIP("127.0.0.1")
+	vni := uint32(0xF)
+
+	vxlan, err := newVxlanEP(id, local, nil, vni)
+	assert.Nil(err)
+	vxlanDupl, err := newVxlanEP(id, local, nil, vni)
+	assert.Nil(err)
+	vxlanGroup, err := newVxlanEP("testvxlangroup", local, net.ParseIP("10.1.1.1"), vni+1)
+	assert.Nil(err)
+
+	assert.Nil(vxlan.create())
+	assert.NotNil(vxlanDupl.create())
+	assert.NotNil(vxlanGroup.create())
+	assert.NotNil(vxlanDupl.addRemote(local))
+
+	performVxlanOps(false, assert, vxlanDupl)
+	performVxlanOps(true, assert, vxlan)
+}
+
+//vxlanTestNode sets up a node of an overlay test in the network
+//namespace ns. The node has an underlay address on link and a
+//tenant bridge with an overlay address linked to the other nodes
+//through a VXLAN tunnel
+func vxlanTestNode(t *testing.T, ns netns.NsHandle, link string, underlay *net.IPNet,
+	overlay *net.IPNet, group net.IP, remotes []net.IP) {
+
+	require.Nil(t, netns.Set(ns))
+
+	lo, err := netlink.LinkByName("lo")
+	require.Nil(t, err)
+	require.Nil(t, netlink.LinkSetUp(lo))
+
+	phy, err := netlink.LinkByName(link)
+	require.Nil(t, err)
+	require.Nil(t, netlink.AddrAdd(phy, &netlink.Addr{IPNet: underlay}))
+	require.Nil(t, netlink.LinkSetUp(phy))
+
+	bridge, err := NewBridge("testbridge")
+	require.Nil(t, err)
+	require.Nil(t, bridge.Create())
+	require.Nil(t, netlink.AddrAdd(bridge.Link, &netlink.Addr{IPNet: overlay}))
+
+	vxlan, err := newVxlanEP("testvxlan", underlay.IP, group, 42)
+	require.Nil(t, err)
+	vxlan.DevIndex = phy.Attrs().Index
+	require.Nil(t, vxlan.create())
+	require.Nil(t, vxlan.attach(bridge))
+	require.Nil(t, vxlan.enable())
+	require.Nil(t, bridge.Enable())
+
+	for _, r := range remotes {
+		require.Nil(t, vxlan.addRemote(r))
+	}
+}
+
+//testVxlanOverlay links two network namespaces through a VXLAN overlay
+//and checks that a datagram sent to the overlay address of one of them
+//is received
+func testVxlanOverlay(t *testing.T, group net.IP) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	origin, err := netns.Get()
+	require.Nil(t, err)
+	defer func() { _ = origin.Close() }()
+	defer func() { _ = netns.Set(origin) }()
+
+	ns1, err := netns.New()
+	require.Nil(t, err)
+	defer func() { _ = ns1.Close() }()
+
+	ns2, err := netns.New()
+	require.Nil(t, err)
+	defer func() { _ = ns2.Close() }()
+
+	//Link the two nodes, the veth pair plays the part of the compute network
+	require.Nil(t, netns.Set(ns1))
+	veth := &netlink.Veth{
+		LinkAttrs: netlink.LinkAttrs{Name: "testphy1"},
+		PeerName:  "testphy2",
+	}
+	require.Nil(t, netlink.LinkAdd(veth))
+	peer, err := netlink.LinkByName("testphy2")
+	require.Nil(t, err)
+	require.Nil(t, netlink.LinkSetNsFd(peer, int(ns2)))
+
+	underlay1 := &net.IPNet{IP: net.IPv4(10, 99, 0, 1), Mask: net.CIDRMask(24, 32)}
+	underlay2 := &net.IPNet{IP: net.IPv4(10, 99, 0, 2), Mask: net.CIDRMask(24, 32)}
+	overlay1 := &net.IPNet{IP: net.IPv4(192, 168, 42, 1), Mask: net.CIDRMask(24, 32)}
+	overlay2 := &net.IPNet{IP: net.IPv4(192, 168, 42, 2), Mask: net.CIDRMask(24, 32)}
+
+	var remotes1, remotes2 []net.IP
+	if group == nil {
+		remotes1 = []net.IP{underlay2.IP}
+		remotes2 = []net.IP{underlay1.IP}
+	}
+
+	vxlanTestNode(t, ns1, "testphy1", underlay1, overlay1, group, remotes1)
+	vxlanTestNode(t, ns2, "testphy2", underlay2, overlay2, group, remotes2)
+
+	//Sockets belong to the namespace they are created in
+	require.Nil(t, netns.Set(ns2))
+	conn2, err := net.ListenUDP("udp4", &net.UDPAddr{IP: overlay2.IP, Port: 4242})
+	require.Nil(t, err)
+	defer func() { _ = conn2.Close() }()
+
+	require.Nil(t, netns.Set(ns1))
+	conn1, err := net.DialUDP("udp4", nil, &net.UDPAddr{IP: overlay2.IP, Port: 4242})
+	require.Nil(t, err)
+	defer func() { _ = conn1.Close() }()
+
+	msg := []byte("ciao")
+	buf := make([]byte, 64)
+
+	//The first datagram may be dropped while the neighbours are resolved
+	var n int
+	var from *net.UDPAddr
+	for i := 0; i < 5; i++ {
+		_, err = conn1.Write(msg)
+		require.Nil(t, err)
+
+		require.Nil(t, conn2.SetReadDeadline(time.Now().Add(time.Second)))
+		n, from, err = conn2.ReadFromUDP(buf)
+		if err == nil {
+			break
+		}
+	}
+	require.Nil(t, err)
+	assert.Equal(t, msg, buf[:n])
+	assert.True(t, from.IP.Equal(overlay1.IP))
+}
+
+//Test a unicast VXLAN overlay across network namespaces
+//
+//Two network namespaces stand in for two nodes. Each node
+//lists the other one as the remote end point of its tunnel
+//
+//Test is expected to pass
+func TestVxlan_Unicast(t *testing.T) {
+	testVxlanOverlay(t, nil)
+}
+
+//Test a multicast VXLAN overlay across network namespaces
+//
+//Two network namespaces stand in for two nodes. The nodes
+//find each other through a multicast group
+//
+//Test is expected to pass
+func TestVxlan_Multicast(t *testing.T) {
+	testVxlanOverlay(t, net.ParseIP("239.1.1.42"))
+}
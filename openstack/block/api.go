@@ -182,6 +182,21 @@ type VolumeCreateRequest struct {
 	Volume RequestedVolume `json:"volume"`
 }
 
+// RequestedVolumeUpdate contains the attributes of a volume to be updated.
+// Attributes which are not set are left unchanged.
+// http://developer.openstack.org/api-ref-blockstorage-v2.html#updateVolume
+type RequestedVolumeUpdate struct {
+	Name        *string  `json:"name"`
+	Description *string  `json:"description"`
+	MetaData    MetaData `json:"metadata"`
+}
+
+// VolumeUpdateRequest is the json request for the updateVolume endpoint.
+// http://developer.openstack.org/api-ref-blockstorage-v2.html#updateVolume
+type VolumeUpdateRequest struct {
+	Volume RequestedVolumeUpdate `json:"volume"`
+}
+
 // Attachment contains instance attachment information.
 // If this volume is attached to a server, the attachment contains
 // information about the attachment.
@@ -291,17 +306,147 @@ type ShowVolumeDetails struct {
 	Volume VolumeDetail `json:"volume"`
 }
 
+// BackupStatus is the status of a volume backup.
+// http://developer.openstack.org/api-ref-blockstorage-v2.html#backups-v2-backups
+type BackupStatus string
+
+const (
+	// BackupCreating indicates that a backup is being created.
+	BackupCreating BackupStatus = "creating"
+
+	// BackupAvailable indicates that a backup is ready to be restored.
+	BackupAvailable BackupStatus = "available"
+
+	// BackupRestoring indicates that a backup is being restored.
+	BackupRestoring BackupStatus = "restoring"
+
+	// BackupDeleting indicates that a backup is being deleted.
+	BackupDeleting BackupStatus = "deleting"
+
+	// BackupError indicates that a backup error occurred.
+	BackupError BackupStatus = "error"
+)
+
+// RequestedBackup contains information about a backup to be created.
+// http://developer.openstack.org/api-ref-blockstorage-v2.html#createBackup
+type RequestedBackup struct {
+	Container   *string `json:"container"`
+	Description *string `json:"description"`
+	Incremental bool    `json:"incremental"`
+	VolumeID    string  `json:"volume_id"`
+	Force       bool    `json:"force"`
+	Name        *string `json:"name"`
+}
+
+// BackupCreateRequest is the json request for the createBackup endpoint.
+// http://developer.openstack.org/api-ref-blockstorage-v2.html#createBackup
+type BackupCreateRequest struct {
+	Backup RequestedBackup `json:"backup"`
+}
+
+// Backup contains summary information about a backup.
+// http://developer.openstack.org/api-ref-blockstorage-v2.html#createBackup
+// http://developer.openstack.org/api-ref-blockstorage-v2.html#listBackups
+type Backup struct {
+	ID    string  `json:"id"`
+	Links []Link  `json:"links"`
+	Name  *string `json:"name"`
+}
+
+// BackupResponse is the json response for the createBackup endpoint.
+// http://developer.openstack.org/api-ref-blockstorage-v2.html#createBackup
+type BackupResponse struct {
+	Backup Backup `json:"backup"`
+}
+
+// ListBackups is the json response for the listBackups endpoint.
+// http://developer.openstack.org/api-ref-blockstorage-v2.html#listBackups
+type ListBackups struct {
+	Backups []Backup `json:"backups"`
+}
+
+// BackupDetail contains backup information for the listBackupsDetail
+// and showBackup endpoints.
+// http://developer.openstack.org/api-ref-blockstorage-v2.html#listBackupsDetail
+type BackupDetail struct {
+	AvailabilityZone    *string      `json:"availability_zone"`
+	Container           *string      `json:"container"`
+	CreatedAt           *time.Time   `json:"created_at"`
+	Description         *string      `json:"description"`
+	FailReason          *string      `json:"fail_reason"`
+	ID                  string       `json:"id"`
+	Links               []Link       `json:"links"`
+	Name                *string      `json:"name"`
+	ObjectCount         int          `json:"object_count"`
+	Size                int          `json:"size"`
+	Status              BackupStatus `json:"status"`
+	VolumeID            string       `json:"volume_id"`
+	IsIncremental       bool         `json:"is_incremental"`
+	HasDependentBackups bool         `json:"has_dependent_backups"`
+}
+
+// ListBackupsDetail is the json response for the listBackupsDetail endpoint.
+// http://developer.openstack.org/api-ref-blockstorage-v2.html#listBackupsDetail
+type ListBackupsDetail struct {
+	Backups []BackupDetail `json:"backups"`
+}
+
+// ShowBackupDetails is the json response for the showBackup endpoint.
+// http://developer.openstack.org/api-ref-blockstorage-v2.html#showBackup
+type ShowBackupDetails struct {
+	Backup BackupDetail `json:"backup"`
+}
+
+// RequestedRestore contains information about a backup restore.
+// A backup can only be restored to a new volume, so VolumeID must
+// not be set.
+// http://developer.openstack.org/api-ref-blockstorage-v2.html#restoreBackup
+type RequestedRestore struct {
+	Name     *string `json:"name"`
+	VolumeID *string `json:"volume_id"`
+}
+
+// BackupRestoreRequest is the json request for the restoreBackup endpoint.
+// http://developer.openstack.org/api-ref-blockstorage-v2.html#restoreBackup
+type BackupRestoreRequest struct {
+	Restore RequestedRestore `json:"restore"`
+}
+
+// Restore contains information about the volume a backup is being
+// restored to.
+// http://developer.openstack.org/api-ref-blockstorage-v2.html#restoreBackup
+type Restore struct {
+	BackupID   string  `json:"backup_id"`
+	VolumeID   string  `json:"volume_id"`
+	VolumeName *string `json:"volume_name"`
+}
+
+// RestoreResponse is the json response for the restoreBackup endpoint.
+// http://developer.openstack.org/api-ref-blockstorage-v2.html#restoreBackup
+type RestoreResponse struct {
+	Restore Restore `json:"restore"`
+}
+
 // These errors can be returned by the Service interface
 var (
-	ErrQuota                = errors.New("Tenant over quota")
-	ErrTenantNotFound       = errors.New("Tenant not found")
-	ErrVolumeNotFound       = errors.New("Volume not found")
-	ErrInstanceNotFound     = errors.New("Instance not found")
-	ErrVolumeNotAvailable   = errors.New("Volume not available")
-	ErrVolumeOwner          = errors.New("You are not volume owner")
-	ErrInstanceOwner        = errors.New("You are not instance owner")
-	ErrInstanceNotAvailable = errors.New("Instance not available")
-	ErrVolumeNotAttached    = errors.New("Volume not attached")
+	ErrQuota                  = errors.New("Tenant over quota")
+	ErrTenantNotFound         = errors.New("Tenant not found")
+	ErrVolumeNotFound         = errors.New("Volume not found")
+	ErrInstanceNotFound       = errors.New("Instance not found")
+	ErrVolumeNotAvailable     = errors.New("Volume not available")
+	ErrVolumeOwner            = errors.New("You are not volume owner")
+	ErrInstanceOwner          = errors.New("You are not instance owner")
+	ErrInstanceNotAvailable   = errors.New("Instance not available")
+	ErrVolumeNotAttached      = errors.New("Volume not attached")
+	ErrVolumeTypeNotFound     = errors.New("Volume type not found")
+	ErrInvalidIOLimits        = errors.New("Invalid volume I/O limits")
+	ErrBackupNotFound         = errors.New("Backup not found")
+	ErrBackupNotAvailable     = errors.New("Backup not available")
+	ErrBackupOwner            = errors.New("You are not backup owner")
+	ErrBackupNotSupported     = errors.New("Backups are not supported")
+	ErrRestoreToVolume        = errors.New("Restoring to an existing volume is not supported")
+	ErrEncryptionNotSupported = errors.New("Encrypted volumes can only be created empty or from an encrypted volume")
+	ErrImageNotAvailable      = errors.New("Image is not available for new volumes")
 )
 
 // errorResponse maps service error responses to http responses.
@@ -317,12 +462,22 @@ func errorResponse(err error) APIResponse {
 		return APIResponse{http.StatusNotFound, nil}
 	case ErrInstanceNotFound:
 		return APIResponse{http.StatusNotFound, nil}
+	case ErrVolumeTypeNotFound:
+		return APIResponse{http.StatusNotFound, nil}
+	case ErrBackupNotFound:
+		return APIResponse{http.StatusNotFound, nil}
+	case ErrInvalidIOLimits, ErrRestoreToVolume, ErrEncryptionNotSupported, ErrImageNotAvailable:
+		return APIResponse{http.StatusBadRequest, nil}
+	case ErrBackupNotSupported:
+		return APIResponse{http.StatusNotImplemented, nil}
 	case ErrVolumeNotAvailable,
 		ErrVolumeNotAvailable,
 		ErrVolumeOwner,
 		ErrInstanceOwner,
 		ErrInstanceNotAvailable,
-		ErrVolumeNotAttached:
+		ErrVolumeNotAttached,
+		ErrBackupNotAvailable,
+		ErrBackupOwner:
 		return APIResponse{http.StatusForbidden, nil}
 	default:
 		return APIResponse{http.StatusInternalServerError, nil}
@@ -342,11 +497,18 @@ type Service interface {
 	GetAbsoluteLimits(tenant string) (AbsoluteLimits, error)
 	CreateVolume(tenant string, req RequestedVolume) (Volume, error)
 	DeleteVolume(tenant string, volume string) error
+	UpdateVolume(tenant string, volume string, req RequestedVolumeUpdate) (Volume, error)
 	AttachVolume(tenant string, volume string, instance string, mountpoint string) error
 	DetachVolume(tenant string, volume string, attachment string) error
 	ListVolumes(tenant string) ([]ListVolume, error)
 	ListVolumesDetail(tenant string) ([]VolumeDetail, error)
 	ShowVolumeDetails(tenant string, volume string) (VolumeDetail, error)
+	CreateBackup(tenant string, req RequestedBackup) (Backup, error)
+	DeleteBackup(tenant string, backup string) error
+	ListBackups(tenant string) ([]Backup, error)
+	ListBackupsDetail(tenant string) ([]BackupDetail, error)
+	ShowBackupDetails(tenant string, backup string) (BackupDetail, error)
+	RestoreBackup(tenant string, backup string, req RequestedRestore) (Restore, error)
 }
 
 // Context contains data and interfaces that the block api will need.
@@ -579,6 +741,34 @@ func deleteVolume(bc *Context, w http.ResponseWriter, r *http.Request) (APIRespo
 	return APIResponse{http.StatusAccepted, nil}, nil
 }
 
+func updateVolume(bc *Context, w http.ResponseWriter, r *http.Request) (APIResponse, error) {
+	vars := mux.Vars(r)
+	tenant := vars["tenant"]
+	volume := vars["volume_id"]
+
+	defer r.Body.Close()
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return APIResponse{http.StatusBadRequest, nil}, err
+	}
+
+	var req VolumeUpdateRequest
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		return APIResponse{http.StatusInternalServerError, nil}, err
+	}
+
+	vol, err := bc.UpdateVolume(tenant, volume, req.Volume)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	resp := VolumeResponse{Volume: vol}
+
+	return APIResponse{http.StatusOK, resp}, nil
+}
+
 func volumeActionAttach(bc *Context, m map[string]interface{}, tenant string, volume string) (APIResponse, error) {
 	val := m["os-attach"]
 
@@ -660,6 +850,121 @@ func volumeAction(bc *Context, w http.ResponseWriter, r *http.Request) (APIRespo
 	return APIResponse{http.StatusBadRequest, nil}, err
 }
 
+func createBackup(bc *Context, w http.ResponseWriter, r *http.Request) (APIResponse, error) {
+	vars := mux.Vars(r)
+	tenant := vars["tenant"]
+
+	defer r.Body.Close()
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return APIResponse{http.StatusBadRequest, nil}, err
+	}
+
+	var req BackupCreateRequest
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		return APIResponse{http.StatusInternalServerError, nil}, err
+	}
+
+	if req.Backup.VolumeID == "" {
+		return APIResponse{http.StatusBadRequest, nil}, ErrVolumeNotFound
+	}
+
+	backup, err := bc.CreateBackup(tenant, req.Backup)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	resp := BackupResponse{Backup: backup}
+
+	return APIResponse{http.StatusAccepted, resp}, nil
+}
+
+func listBackups(bc *Context, w http.ResponseWriter, r *http.Request) (APIResponse, error) {
+	vars := mux.Vars(r)
+	tenant := vars["tenant"]
+
+	backups, err := bc.ListBackups(tenant)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	resp := ListBackups{Backups: backups}
+
+	return APIResponse{http.StatusOK, resp}, nil
+}
+
+func listBackupsDetail(bc *Context, w http.ResponseWriter, r *http.Request) (APIResponse, error) {
+	vars := mux.Vars(r)
+	tenant := vars["tenant"]
+
+	backups, err := bc.ListBackupsDetail(tenant)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	resp := ListBackupsDetail{Backups: backups}
+
+	return APIResponse{http.StatusOK, resp}, nil
+}
+
+func showBackupDetails(bc *Context, w http.ResponseWriter, r *http.Request) (APIResponse, error) {
+	vars := mux.Vars(r)
+	tenant := vars["tenant"]
+	backup := vars["backup_id"]
+
+	b, err := bc.ShowBackupDetails(tenant, backup)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	resp := ShowBackupDetails{Backup: b}
+
+	return APIResponse{http.StatusOK, resp}, nil
+}
+
+func deleteBackup(bc *Context, w http.ResponseWriter, r *http.Request) (APIResponse, error) {
+	vars := mux.Vars(r)
+	tenant := vars["tenant"]
+	backup := vars["backup_id"]
+
+	err := bc.DeleteBackup(tenant, backup)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return APIResponse{http.StatusAccepted, nil}, nil
+}
+
+func restoreBackup(bc *Context, w http.ResponseWriter, r *http.Request) (APIResponse, error) {
+	vars := mux.Vars(r)
+	tenant := vars["tenant"]
+	backup := vars["backup_id"]
+
+	defer r.Body.Close()
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return APIResponse{http.StatusBadRequest, nil}, err
+	}
+
+	var req BackupRestoreRequest
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		return APIResponse{http.StatusInternalServerError, nil}, err
+	}
+
+	restore, err := bc.RestoreBackup(tenant, backup, req.Restore)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	resp := RestoreResponse{Restore: restore}
+
+	return APIResponse{http.StatusAccepted, resp}, nil
+}
+
 // Routes provides gorilla mux routes for the supported endpoints.
 func Routes(config APIConfig) *mux.Router {
 	// make new Context
@@ -686,10 +991,26 @@ func Routes(config APIConfig) *mux.Router {
 		APIHandler{context, showVolumeDetails}).Methods("GET")
 	r.Handle("/v2/{tenant}/volumes/{volume_id}",
 		APIHandler{context, deleteVolume}).Methods("DELETE")
+	r.Handle("/v2/{tenant}/volumes/{volume_id}",
+		APIHandler{context, updateVolume}).Methods("PUT")
 
 	// Volume actions
 	r.Handle("/v2/{tenant}/volumes/{volume_id}/action",
 		APIHandler{context, volumeAction}).Methods("POST")
 
+	// Backups
+	r.Handle("/v2/{tenant}/backups",
+		APIHandler{context, createBackup}).Methods("POST")
+	r.Handle("/v2/{tenant}/backups",
+		APIHandler{context, listBackups}).Methods("GET")
+	r.Handle("/v2/{tenant}/backups/detail",
+		APIHandler{context, listBackupsDetail}).Methods("GET")
+	r.Handle("/v2/{tenant}/backups/{backup_id}",
+		APIHandler{context, showBackupDetails}).Methods("GET")
+	r.Handle("/v2/{tenant}/backups/{backup_id}",
+		APIHandler{context, deleteBackup}).Methods("DELETE")
+	r.Handle("/v2/{tenant}/backups/{backup_id}/restore",
+		APIHandler{context, restoreBackup}).Methods("POST")
+
 	return r
 }
@@ -103,6 +103,14 @@ var tests = []test{
 		http.StatusAccepted,
 		"null",
 	},
+	{
+		"PUT",
+		"/v2/validtenantid/volumes/validvolumeid",
+		updateVolume,
+		`{"volume":{"name":"vol-004","description":"Updated volume.","metadata":{"total_iops_sec":"500"}}}`,
+		http.StatusOK,
+		`{"volume":{"status":"available","migration_status":null,"user_id":"validuserid","attachments":[],"links":[],"availability_zone":null,"bootable":"false","encrypted":false,"created_at":null,"description":"Updated volume.","updated_at":null,"volume_type":null,"name":"vol-004","replication_status":"disabled","consistencygroup_id":null,"source_volid":null,"snapshot_id":null,"multiattach":false,"metadata":{"total_iops_sec":"500"},"id":"validvolumeid","size":10}}`,
+	},
 	{
 		"POST",
 		"/v2/validtenantid/volumes/validvolumeid/action",
@@ -119,6 +127,70 @@ var tests = []test{
 		http.StatusAccepted,
 		"null",
 	},
+	{
+		"POST",
+		"/v2/validtenantid/backups",
+		createBackup,
+		`{"backup":{"container":null,"description":null,"name":"backup001","volume_id":"validvolumeid","incremental":false}}`,
+		http.StatusAccepted,
+		`{"backup":{"id":"validbackupid","links":[],"name":"backup001"}}`,
+	},
+	{
+		"POST",
+		"/v2/validtenantid/backups",
+		createBackup,
+		`{"backup":{"name":"backup001"}}`,
+		http.StatusBadRequest,
+		"Volume not found\nnull",
+	},
+	{
+		"GET",
+		"/v2/validtenantid/backups",
+		listBackups,
+		"",
+		http.StatusOK,
+		`{"backups":[{"id":"validbackupid1","links":[],"name":"backup001"},{"id":"validbackupid2","links":[],"name":"backup002"}]}`,
+	},
+	{
+		"GET",
+		"/v2/validtenantid/backups/detail",
+		listBackupsDetail,
+		"",
+		http.StatusOK,
+		`{"backups":[{"availability_zone":null,"container":null,"created_at":null,"description":"Nightly backup.","fail_reason":null,"id":"validbackupid1","links":[],"name":"backup001","object_count":1,"size":10,"status":"available","volume_id":"validvolumeid","is_incremental":false,"has_dependent_backups":false}]}`,
+	},
+	{
+		"GET",
+		"/v2/validtenantid/backups/validbackupid",
+		showBackupDetails,
+		"",
+		http.StatusOK,
+		`{"backup":{"availability_zone":null,"container":null,"created_at":null,"description":"Nightly backup.","fail_reason":null,"id":"validbackupid","links":[],"name":"backup001","object_count":1,"size":10,"status":"available","volume_id":"validvolumeid","is_incremental":false,"has_dependent_backups":false}}`,
+	},
+	{
+		"DELETE",
+		"/v2/validtenantid/backups/validbackupid",
+		deleteBackup,
+		"",
+		http.StatusAccepted,
+		"null",
+	},
+	{
+		"POST",
+		"/v2/validtenantid/backups/validbackupid/restore",
+		restoreBackup,
+		`{"restore":{"name":"vol-restored"}}`,
+		http.StatusAccepted,
+		`{"restore":{"backup_id":"validbackupid","volume_id":"validvolumeid","volume_name":"vol-restored"}}`,
+	},
+	{
+		"POST",
+		"/v2/validtenantid/backups/validbackupid/restore",
+		restoreBackup,
+		`{"restore":{"volume_id":"validvolumeid"}}`,
+		http.StatusBadRequest,
+		"Restoring to an existing volume is not supported\nnull",
+	},
 }
 
 type testVolumeService struct{}
@@ -198,6 +270,22 @@ func (vs testVolumeService) DeleteVolume(tenant string, volume string) error {
 	return nil
 }
 
+func (vs testVolumeService) UpdateVolume(tenant string, volume string, req RequestedVolumeUpdate) (Volume, error) {
+	return Volume{
+		Status:            Available,
+		UserID:            "validuserid",
+		Attachments:       make([]Attachment, 0),
+		Links:             make([]Link, 0),
+		Bootable:          strconv.FormatBool(false),
+		Description:       req.Description,
+		Name:              req.Name,
+		ReplicationStatus: ReplicationDisabled,
+		MetaData:          req.MetaData,
+		ID:                "validvolumeid",
+		Size:              10,
+	}, nil
+}
+
 func (vs testVolumeService) AttachVolume(tenant string, volume string, instance string, mountpoint string) error {
 	return nil
 }
@@ -262,6 +350,64 @@ func (vs testVolumeService) ListVolumesDetail(tenant string) ([]VolumeDetail, er
 	}, nil
 }
 
+func (vs testVolumeService) CreateBackup(tenant string, req RequestedBackup) (Backup, error) {
+	return Backup{
+		ID:    "validbackupid",
+		Links: make([]Link, 0),
+		Name:  req.Name,
+	}, nil
+}
+
+func (vs testVolumeService) DeleteBackup(tenant string, backup string) error {
+	return nil
+}
+
+func (vs testVolumeService) ListBackups(tenant string) ([]Backup, error) {
+	name1 := "backup001"
+	name2 := "backup002"
+
+	return []Backup{
+		{ID: "validbackupid1", Links: make([]Link, 0), Name: &name1},
+		{ID: "validbackupid2", Links: make([]Link, 0), Name: &name2},
+	}, nil
+}
+
+func testBackupDetail(id string) BackupDetail {
+	name := "backup001"
+	desc := "Nightly backup."
+
+	return BackupDetail{
+		ID:          id,
+		Links:       make([]Link, 0),
+		Name:        &name,
+		Description: &desc,
+		ObjectCount: 1,
+		Size:        10,
+		Status:      BackupAvailable,
+		VolumeID:    "validvolumeid",
+	}
+}
+
+func (vs testVolumeService) ListBackupsDetail(tenant string) ([]BackupDetail, error) {
+	return []BackupDetail{testBackupDetail("validbackupid1")}, nil
+}
+
+func (vs testVolumeService) ShowBackupDetails(tenant string, backup string) (BackupDetail, error) {
+	return testBackupDetail("validbackupid"), nil
+}
+
+func (vs testVolumeService) RestoreBackup(tenant string, backup string, req RequestedRestore) (Restore, error) {
+	if req.VolumeID != nil {
+		return Restore{}, ErrRestoreToVolume
+	}
+
+	return Restore{
+		BackupID:   "validbackupid",
+		VolumeID:   "validvolumeid",
+		VolumeName: req.Name,
+	}, nil
+}
+
 func TestAPIResponse(t *testing.T) {
 	var vs testVolumeService
 
@@ -77,6 +77,8 @@ var (
 	ErrServerNotFound       = errors.New("Server not found")
 	ErrServerOwner          = errors.New("You are not server owner")
 	ErrInstanceNotAvailable = errors.New("Instance not currently available for this operation")
+	ErrInvalidAddress       = errors.New("Invalid fixed IP or MAC address")
+	ErrAddressInUse         = errors.New("Address already in use")
 )
 
 // errorResponse maps service error responses to http responses.
@@ -90,6 +92,12 @@ func errorResponse(err error) APIResponse {
 	case ErrQuota, ErrServerOwner, ErrInstanceNotAvailable:
 		return APIResponse{http.StatusForbidden, nil}
 
+	case ErrInvalidAddress:
+		return APIResponse{http.StatusBadRequest, nil}
+
+	case ErrAddressInUse:
+		return APIResponse{http.StatusConflict, nil}
+
 	default:
 		return APIResponse{http.StatusInternalServerError, nil}
 	}
@@ -274,9 +282,18 @@ type CreateServerRequest struct {
 		MaxInstances        int                    `json:"max_count"`
 		MinInstances        int                    `json:"min_count"`
 		BlockDeviceMappings []BlockDeviceMappingV2 `json:"block_device_mapping_v2,omitempty"`
+		Networks            []ServerNetwork        `json:"networks,omitempty"`
 	} `json:"server"`
 }
 
+// ServerNetwork selects a network a new server is attached to. A fixed IP
+// and MAC address can only be requested for the first network of a server.
+type ServerNetwork struct {
+	UUID       string `json:"uuid"`
+	FixedIP    string `json:"fixed_ip,omitempty"`
+	MACAddress string `json:"mac_address,omitempty"`
+}
+
 // APIConfig contains information needed to start the compute api service.
 type APIConfig struct {
 	Port           int     // the https port of the compute api service
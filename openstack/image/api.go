@@ -22,6 +22,8 @@ import (
 	"io/ioutil"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/01org/ciao/service"
@@ -86,6 +88,10 @@ const (
 	// the raw data for the image.
 	Saving Status = "saving"
 
+	// Importing means that the image service is fetching the raw
+	// data for the image from a remote location.
+	Importing Status = "importing"
+
 	// Active means that the image is active and fully available
 	// in the image service.
 	Active Status = "active"
@@ -93,6 +99,14 @@ const (
 	// Killed means that an image data upload error occurred.
 	Killed Status = "killed"
 
+	// Rejected means that the uploaded data is not a valid image.
+	Rejected Status = "rejected"
+
+	// Deactivated means that the image data cannot be downloaded and
+	// that no new instances or volumes can be created from the image.
+	// Existing instances are not affected.
+	Deactivated Status = "deactivated"
+
 	// Deleted means that the image service retains information
 	// about the image but the image is no longer available for use.
 	Deleted Status = "deleted"
@@ -114,6 +128,27 @@ const (
 
 	// Internal indicates that an image is only for Ciao internal usage.
 	Internal Visibility = "internal"
+
+	// Shared indicates that an image is available to its owner and to
+	// the tenants it has been shared with.
+	Shared Visibility = "shared"
+)
+
+// MemberStatus defines whether a tenant an image is shared with has
+// accepted the image.
+type MemberStatus string
+
+const (
+	// MemberPending means that the tenant has not yet accepted or
+	// rejected the image.
+	MemberPending MemberStatus = "pending"
+
+	// MemberAccepted means that the tenant has accepted the image,
+	// which is listed with its own images.
+	MemberAccepted MemberStatus = "accepted"
+
+	// MemberRejected means that the tenant has rejected the image.
+	MemberRejected MemberStatus = "rejected"
 )
 
 // InternalImage defines the types of CIAO internal images (e.g. cnci)
@@ -145,8 +180,43 @@ const (
 
 	// ISO
 	ISO DiskFormat = "iso"
+
+	// VMDK
+	VMDK DiskFormat = "vmdk"
+
+	// VHD
+	VHD DiskFormat = "vhd"
+
+	// VHDX
+	VHDX DiskFormat = "vhdx"
+)
+
+// Valid returns true if f is one of the supported disk formats.
+func (f DiskFormat) Valid() bool {
+	switch f {
+	case Raw, QCow, ISO, VMDK, VHD, VHDX:
+		return true
+	}
+
+	return false
+}
+
+// ImportMethodName defines the ways in which image data can be imported.
+type ImportMethodName string
+
+const (
+	// WebDownload imports the image data from an HTTP(S) URL.
+	WebDownload ImportMethodName = "web-download"
 )
 
+// PatchContentType is the media type of the JSON patches used to update
+// images.
+const PatchContentType = "application/openstack-images-v2.1-json-patch"
+
+// ChecksumHeader is the header in which clients can supply the expected
+// hex encoded MD5 or SHA-256 digest of the image data they upload.
+const ChecksumHeader = "X-Image-Meta-Checksum"
+
 // ErrorImage defines all possible image handling errors
 type ErrorImage error
 
@@ -172,8 +242,121 @@ var (
 
 	// ErrQuota is returned when the tenant exceeds its quota
 	ErrQuota = errors.New("Tenant over quota")
+
+	// ErrImportMethod is returned when an unsupported import method
+	// is requested.
+	ErrImportMethod = errors.New("Unsupported import method")
+
+	// ErrBadURI is returned when the URI to import an image from is
+	// not a valid HTTP(S) URL.
+	ErrBadURI = errors.New("Invalid image URI")
+
+	// ErrBadChecksum is returned when the expected checksum of an
+	// image is not a valid MD5 or SHA-256 digest.
+	ErrBadChecksum = errors.New("Invalid image checksum")
+
+	// ErrChecksumMismatch is returned when the image data does not
+	// match the expected checksum.
+	ErrChecksumMismatch = errors.New("Image checksum mismatch")
+
+	// ErrMalformedImage is returned when the uploaded data is not a
+	// valid image.
+	ErrMalformedImage = errors.New("Malformed image")
+
+	// ErrImageNotQueued is returned when data is imported into an
+	// image that already has data or is being uploaded.
+	ErrImageNotQueued = errors.New("Image is not queued")
+
+	// ErrImageTooLarge is returned when an image exceeds the maximum
+	// size that can be imported.
+	ErrImageTooLarge = errors.New("Image too large")
+
+	// ErrImportFailed is returned when the image data cannot be
+	// fetched from the import URI.
+	ErrImportFailed = errors.New("Unable to fetch image")
+
+	// ErrNoImageData is returned when the data of an image that has
+	// not been uploaded, or whose upload failed, is requested.
+	ErrNoImageData = errors.New("Image has no data")
+
+	// ErrBadRange is returned when the requested range of image data
+	// cannot be satisfied.
+	ErrBadRange = errors.New("Requested range not satisfiable")
+
+	// ErrBadPatch is returned when an image update contains an invalid
+	// operation or value.
+	ErrBadPatch = errors.New("Invalid image update")
+
+	// ErrReadOnly is returned when an image update changes an attribute
+	// that is managed by the image service.
+	ErrReadOnly = errors.New("Attribute is read-only")
+
+	// ErrNotShared is returned when members are added to an image
+	// whose visibility is not shared.
+	ErrNotShared = errors.New("Image is not shared")
+
+	// ErrNoMember is returned when a member of an image is not found.
+	ErrNoMember = errors.New("Member not found")
+
+	// ErrBadMember is returned when an image member request is not
+	// valid.
+	ErrBadMember = errors.New("Invalid member")
+
+	// ErrImageProtected is returned when an attempt is made to delete
+	// a protected image.
+	ErrImageProtected = errors.New("Image is protected")
+
+	// ErrImageInUse is returned when an attempt is made to delete an
+	// image from which instances or volumes were created that still
+	// exist.
+	ErrImageInUse = errors.New("Image in use")
+
+	// ErrImageDeactivated is returned when the data of a deactivated
+	// image is requested.
+	ErrImageDeactivated = errors.New("Image is deactivated")
+
+	// ErrImageNotActive is returned when an image that is not active is
+	// deactivated, or an image that is not deactivated is reactivated.
+	ErrImageNotActive = errors.New("Image is not active")
+
+	// ErrBadSignature is returned when the signature of the image data
+	// cannot be verified.
+	ErrBadSignature = errors.New("Invalid image signature")
+
+	// ErrBadDiskFormat is returned when an image is created with an
+	// unsupported disk format.
+	ErrBadDiskFormat = errors.New("Unsupported disk format")
+
+	// ErrImageNotSigned is returned when an image whose signature has not
+	// been verified is booted while signed images are required.
+	ErrImageNotSigned = errors.New("Image signature not verified")
 )
 
+// Signature contains the signature of the data of an image and the
+// reference of the certificate holding the key with which it can be
+// verified.  They are the image properties used by Glance.
+// https://docs.openstack.org/glance/latest/user/signature.html
+type Signature struct {
+	Signature   string `json:"img_signature,omitempty"`
+	HashMethod  string `json:"img_signature_hash_method,omitempty"`
+	KeyType     string `json:"img_signature_key_type,omitempty"`
+	Certificate string `json:"img_signature_certificate_uuid,omitempty"`
+}
+
+// SetProperty sets the signature property named property to value.
+func (s *Signature) SetProperty(property, value string) {
+	switch property {
+	case "img_signature":
+		s.Signature = value
+	case "img_signature_hash_method":
+		s.HashMethod = value
+	case "img_signature_key_type":
+		s.KeyType = value
+	case "img_signature_certificate_uuid":
+		s.Certificate = value
+	}
+}
+
 // CreateImageRequest contains information for a create image request.
 // http://developer.openstack.org/api-ref/image/v2/index.html#create-an-image
 type CreateImageRequest struct {
@@ -187,6 +370,7 @@ type CreateImageRequest struct {
 	MinRAM          int             `json:"min_ram,omitempty"`
 	Protected       bool            `json:"protected,omitempty"`
 	Properties      interface{}     `json:"properties,omitempty"`
+	Signature
 }
 
 // DefaultResponse contains information about an image
@@ -206,6 +390,8 @@ type DefaultResponse struct {
 	VirtualSize     *int             `json:"virtual_size"`
 	Name            *string          `json:"name"`
 	CheckSum        *string          `json:"checksum"`
+	OSHashAlgo      *string          `json:"os_hash_algo"`
+	OSHashValue     *string          `json:"os_hash_value"`
 	CreatedAt       time.Time        `json:"created_at"`
 	DiskFormat      DiskFormat       `json:"disk_format"`
 	Properties      interface{}      `json:"properties"`
@@ -213,6 +399,7 @@ type DefaultResponse struct {
 	Self            string           `json:"self"`
 	File            string           `json:"file"`
 	Schema          string           `json:"schema"`
+	Signature
 }
 
 // ListImagesResponse contains the list of all images that have been created.
@@ -223,6 +410,86 @@ type ListImagesResponse struct {
 	First  string            `json:"first"`
 }
 
+// PatchOperation is an operation of the JSON patch sent to update an
+// image.
+// https://developer.openstack.org/api-ref/image/v2/index.html#update-an-image
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// UpdateImageRequest contains the changes made to an image by an update.
+// Only the attributes that are not nil are changed.  TenantID is the
+// tenant that owns the image if it is made private.
+type UpdateImageRequest struct {
+	Name       *string
+	Visibility *Visibility
+	Tags       *[]string
+	DiskFormat *DiskFormat
+	Protected  *bool
+	TenantID   string
+
+	// SignatureProperties maps the signature properties changed by the
+	// update to their new values.  Removed properties are empty.
+	SignatureProperties map[string]string
+}
+
+// Member describes a tenant an image is shared with.
+// https://developer.openstack.org/api-ref/image/v2/index.html#sharing
+type Member struct {
+	CreatedAt time.Time    `json:"created_at"`
+	ImageID   string       `json:"image_id"`
+	MemberID  string       `json:"member_id"`
+	Schema    string       `json:"schema"`
+	Status    MemberStatus `json:"status"`
+	UpdatedAt time.Time    `json:"updated_at"`
+}
+
+// CreateMemberRequest contains the tenant to share an image with.
+type CreateMemberRequest struct {
+	Member string `json:"member"`
+}
+
+// UpdateMemberRequest contains the new status of an image member.
+type UpdateMemberRequest struct {
+	Status MemberStatus `json:"status"`
+}
+
+// ListMembersResponse contains the members of an image.
+type ListMembersResponse struct {
+	Members []Member `json:"members"`
+	Schema  string   `json:"schema"`
+}
+
+// ImportMethod describes how the data of an image is to be imported.
+// Checksum is an optional hex encoded MD5 or SHA-256 digest that the
+// imported data must match.
+type ImportMethod struct {
+	Name     ImportMethodName `json:"name"`
+	URI      string           `json:"uri,omitempty"`
+	Checksum string           `json:"checksum,omitempty"`
+}
+
+// ImportImageRequest contains information for an image import request.
+// https://developer.openstack.org/api-ref/image/v2/index.html#import-an-image
+type ImportImageRequest struct {
+	Method ImportMethod `json:"method"`
+}
+
+// ImportMethods lists the import methods supported by the image service.
+type ImportMethods struct {
+	Description string             `json:"description"`
+	Type        string             `json:"type"`
+	Value       []ImportMethodName `json:"value"`
+}
+
+// ImportInfoResponse contains the import methods discovery response.
+// https://developer.openstack.org/api-ref/image/v2/index.html#import-methods-and-values-discovery
+type ImportInfoResponse struct {
+	ImportMethods ImportMethods `json:"import-methods"`
+}
+
 // NoContentImageResponse contains the UUID of the image which content
 // got uploaded or deleted
 // http://developer.openstack.org/api-ref/image/v2/index.html#upload-binary-image-data
@@ -230,6 +497,13 @@ type NoContentImageResponse struct {
 	ImageID string `json:"image_id"`
 }
 
+// ImageData is the data of an image being downloaded.  Checksum is the
+// MD5 digest of the whole image, if it is known.
+type ImageData struct {
+	io.ReadCloser
+	Checksum string
+}
+
 // TBD - can we pull these structs out into some sort of common
 // api service file?
 // ----------
@@ -244,10 +518,19 @@ type APIConfig struct {
 // information needed to implement the image endpoints.
 type Service interface {
 	CreateImage(string, CreateImageRequest) (DefaultResponse, error)
-	UploadImage(string, string, io.Reader) (NoContentImageResponse, error)
+	UploadImage(string, string, io.Reader, string) (NoContentImageResponse, error)
 	ListImages(string) ([]DefaultResponse, error)
 	GetImage(string, string) (DefaultResponse, error)
 	DeleteImage(string, string) (NoContentImageResponse, error)
+	ImportImage(string, string, ImportImageRequest) (NoContentImageResponse, error)
+	DownloadImage(string, string, uint64, uint64) (ImageData, error)
+	UpdateImage(string, string, UpdateImageRequest) (DefaultResponse, error)
+	ListMembers(string, string) ([]Member, error)
+	AddMember(string, string, string) (Member, error)
+	UpdateMember(string, string, string, MemberStatus) (Member, error)
+	DeleteMember(string, string, string) error
+	DeactivateImage(string, string) error
+	ReactivateImage(string, string) error
 }
 
 // Context contains data and interfaces that the image api will need.
@@ -290,6 +573,30 @@ func (h APIHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.Write(b)
 }
 
+// DataHandler is a custom handler for the image APIs that stream image
+// data.  The handler writes the response itself on success, errors are
+// reported as for APIHandler.
+type DataHandler struct {
+	*Context
+	Handler func(*Context, http.ResponseWriter, *http.Request) (APIResponse, error)
+}
+
+// ServeHTTP satisfies the http Handler interface.
+func (h DataHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	resp, err := h.Handler(h.Context, w, r)
+	if resp.status == 0 {
+		// the response has already been streamed.
+		return
+	}
+
+	if err != nil {
+		http.Error(w, err.Error(), resp.status)
+		return
+	}
+
+	w.WriteHeader(resp.status)
+}
+
 // ---------------
 // end possible common service api stuff
 
@@ -298,13 +605,23 @@ func (h APIHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 // on return values all the time.
 func errorResponse(err error) APIResponse {
 	switch err {
-	case ErrNoImage:
+	case ErrNoImage, ErrNoMember:
 		return APIResponse{http.StatusNotFound, nil}
-	case ErrBadUUID:
+	case ErrBadUUID, ErrImportMethod, ErrBadURI, ErrBadChecksum, ErrChecksumMismatch, ErrImportFailed,
+		ErrMalformedImage:
 		return APIResponse{http.StatusBadRequest, nil}
-	case ErrAlreadyExists:
+	case ErrAlreadyExists, ErrImageNotQueued, ErrImageSaving, ErrImageInUse:
 		return APIResponse{http.StatusConflict, nil}
-	case ErrForbiddenAccess, ErrQuota:
+	case ErrNoImageData:
+		return APIResponse{http.StatusNoContent, nil}
+	case ErrBadRange:
+		return APIResponse{http.StatusRequestedRangeNotSatisfiable, nil}
+	case ErrImageTooLarge:
+		return APIResponse{http.StatusRequestEntityTooLarge, nil}
+	case ErrBadPatch, ErrBadMember, ErrBadSignature, ErrBadDiskFormat:
+		return APIResponse{http.StatusBadRequest, nil}
+	case ErrForbiddenAccess, ErrQuota, ErrReadOnly, ErrNotShared, ErrImageProtected,
+		ErrImageDeactivated, ErrImageNotActive, ErrImageNotSigned:
 		return APIResponse{http.StatusForbidden, nil}
 	default:
 		return APIResponse{http.StatusInternalServerError, nil}
@@ -312,7 +629,8 @@ func errorResponse(err error) APIResponse {
 }
 
 func validPrivilege(visibility Visibility, privileged bool) bool {
-	return visibility == Private || (visibility == Public || visibility == Internal) && privileged
+	return visibility == Private || visibility == Shared ||
+		(visibility == Public || visibility == Internal) && privileged
 }
 
 // endpoints
@@ -493,7 +811,8 @@ func uploadImage(context *Context, w http.ResponseWriter, r *http.Request) (APIR
 		return errorResponse(err), err
 	}
 
-	_, err = context.UploadImage(tenantID, imageID, r.Body)
+	checksum := r.Header.Get(ChecksumHeader)
+	_, err = context.UploadImage(tenantID, imageID, r.Body, checksum)
 	if err != nil {
 		return errorResponse(err), err
 	}
@@ -537,6 +856,537 @@ func deleteImage(context *Context, w http.ResponseWriter, r *http.Request) (APIR
 	return APIResponse{http.StatusNoContent, nil}, nil
 }
 
+// findImageTable returns the table holding an image visible to the
+// requester, i.e. the tenant ID or the image visibility for public and
+// internal images.
+func findImageTable(context *Context, r *http.Request, imageID string) (string, APIResponse, error) {
+	tenantID, err := service.GetTenantID(r.Context())
+	if err != nil {
+		return "", APIResponse{http.StatusBadRequest, nil}, err
+	}
+
+	imageTables := []string{tenantID, string(Public)}
+
+	privileged := service.GetPrivilege(r.Context())
+	if privileged {
+		imageTables = append(imageTables, string(Internal))
+	}
+
+	for _, table := range imageTables {
+		img, err := context.GetImage(table, imageID)
+		if err == ErrNoImage {
+			continue
+		}
+		if err != nil {
+			return "", errorResponse(err), err
+		}
+		if img.ID == "" {
+			continue
+		}
+		if !validPrivilege(img.Visibility, privileged) {
+			return "", APIResponse{http.StatusForbidden, nil}, ErrForbiddenAccess
+		}
+		return table, APIResponse{}, nil
+	}
+
+	return "", errorResponse(ErrNoImage), ErrNoImage
+}
+
+// importImage starts fetching the data of a queued image from the
+// location given in the request.  The import completes in the
+// background, its progress is reported in the image status.
+func importImage(context *Context, w http.ResponseWriter, r *http.Request) (APIResponse, error) {
+	defer r.Body.Close()
+	vars := mux.Vars(r)
+	imageID := vars["image_id"]
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return APIResponse{http.StatusBadRequest, nil}, err
+	}
+
+	var req ImportImageRequest
+
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		return APIResponse{http.StatusBadRequest, nil}, err
+	}
+
+	if req.Method.Name != WebDownload {
+		return errorResponse(ErrImportMethod), ErrImportMethod
+	}
+
+	table, resp, err := findImageTable(context, r, imageID)
+	if err != nil {
+		return resp, err
+	}
+
+	_, err = context.ImportImage(table, imageID, req)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return APIResponse{http.StatusAccepted, nil}, nil
+}
+
+// deactivateImage stops new instances and volumes from being created
+// from an active image.  Only privileged users can deactivate images.
+func deactivateImage(context *Context, w http.ResponseWriter, r *http.Request) (APIResponse, error) {
+	vars := mux.Vars(r)
+	imageID := vars["image_id"]
+
+	if !service.GetPrivilege(r.Context()) {
+		return errorResponse(ErrForbiddenAccess), ErrForbiddenAccess
+	}
+
+	table, resp, err := findImageTable(context, r, imageID)
+	if err != nil {
+		return resp, err
+	}
+
+	err = context.DeactivateImage(table, imageID)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return APIResponse{http.StatusNoContent, nil}, nil
+}
+
+// reactivateImage makes a deactivated image active again.  Only
+// privileged users can reactivate images.
+func reactivateImage(context *Context, w http.ResponseWriter, r *http.Request) (APIResponse, error) {
+	vars := mux.Vars(r)
+	imageID := vars["image_id"]
+
+	if !service.GetPrivilege(r.Context()) {
+		return errorResponse(ErrForbiddenAccess), ErrForbiddenAccess
+	}
+
+	table, resp, err := findImageTable(context, r, imageID)
+	if err != nil {
+		return resp, err
+	}
+
+	err = context.ReactivateImage(table, imageID)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return APIResponse{http.StatusNoContent, nil}, nil
+}
+
+// listImportMethods returns the import methods supported by the service.
+func listImportMethods(context *Context, w http.ResponseWriter, r *http.Request) (APIResponse, error) {
+	resp := ImportInfoResponse{
+		ImportMethods: ImportMethods{
+			Description: "Import methods available.",
+			Type:        "array",
+			Value:       []ImportMethodName{WebDownload},
+		},
+	}
+
+	return APIResponse{http.StatusOK, resp}, nil
+}
+
+// readOnlyAttributes are the image attributes that cannot be changed by
+// an update.
+var readOnlyAttributes = map[string]bool{
+	"id":            true,
+	"status":        true,
+	"owner":         true,
+	"size":          true,
+	"virtual_size":  true,
+	"checksum":      true,
+	"os_hash_algo":  true,
+	"os_hash_value": true,
+	"locations":     true,
+	"created_at":    true,
+	"updated_at":    true,
+	"self":          true,
+	"file":          true,
+	"schema":        true,
+}
+
+// patchValue decodes the value set by a patch operation into v.
+func patchValue(op PatchOperation, v interface{}) error {
+	if op.Op == "remove" || json.Unmarshal(op.Value, v) != nil {
+		return ErrBadPatch
+	}
+
+	return nil
+}
+
+// parsePatch turns the operations of a JSON patch into an image update.
+// Operations are applied in order, so later operations on an attribute
+// override earlier ones.
+func parsePatch(ops []PatchOperation) (UpdateImageRequest, error) {
+	var req UpdateImageRequest
+
+	for _, op := range ops {
+		if op.Op != "add" && op.Op != "replace" && op.Op != "remove" {
+			return req, ErrBadPatch
+		}
+
+		attr := strings.TrimPrefix(op.Path, "/")
+		if readOnlyAttributes[attr] {
+			return req, ErrReadOnly
+		}
+
+		var err error
+
+		switch attr {
+		case "name":
+			var name string
+			err = patchValue(op, &name)
+			req.Name = &name
+		case "visibility":
+			var visibility Visibility
+			err = patchValue(op, &visibility)
+			switch visibility {
+			case Public, Private, Internal, Shared:
+			default:
+				err = ErrBadPatch
+			}
+			req.Visibility = &visibility
+		case "tags":
+			tags := []string{}
+			if op.Op != "remove" {
+				err = patchValue(op, &tags)
+			}
+			for _, tag := range tags {
+				if tag == "" || strings.Contains(tag, ",") {
+					err = ErrBadPatch
+				}
+			}
+			req.Tags = &tags
+		case "disk_format":
+			var format DiskFormat
+			err = patchValue(op, &format)
+			if !format.Valid() {
+				err = ErrBadPatch
+			}
+			req.DiskFormat = &format
+		case "protected":
+			var protected bool
+			err = patchValue(op, &protected)
+			req.Protected = &protected
+		case "img_signature", "img_signature_hash_method", "img_signature_key_type",
+			"img_signature_certificate_uuid":
+			value := ""
+			if op.Op != "remove" {
+				err = patchValue(op, &value)
+			}
+			if req.SignatureProperties == nil {
+				req.SignatureProperties = make(map[string]string)
+			}
+			req.SignatureProperties[attr] = value
+		default:
+			err = ErrBadPatch
+		}
+
+		if err != nil {
+			return req, err
+		}
+	}
+
+	return req, nil
+}
+
+// updateImage applies a JSON patch to the attributes of an image.  Only
+// privileged users can make images public or internal.
+func updateImage(context *Context, w http.ResponseWriter, r *http.Request) (APIResponse, error) {
+	defer r.Body.Close()
+	vars := mux.Vars(r)
+	imageID := vars["image_id"]
+
+	if r.Header.Get("Content-Type") != PatchContentType {
+		return APIResponse{http.StatusUnsupportedMediaType, nil}, fmt.Errorf("Unsupported media type")
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return APIResponse{http.StatusBadRequest, nil}, err
+	}
+
+	var ops []PatchOperation
+
+	err = json.Unmarshal(body, &ops)
+	if err != nil {
+		return APIResponse{http.StatusBadRequest, nil}, err
+	}
+
+	req, err := parsePatch(ops)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	table, resp, err := findImageTable(context, r, imageID)
+	if err != nil {
+		return resp, err
+	}
+
+	if req.Visibility != nil && !validPrivilege(*req.Visibility, service.GetPrivilege(r.Context())) {
+		return errorResponse(ErrForbiddenAccess), ErrForbiddenAccess
+	}
+
+	req.TenantID, err = service.GetTenantID(r.Context())
+	if err != nil {
+		return APIResponse{http.StatusBadRequest, nil}, err
+	}
+
+	img, err := context.UpdateImage(table, imageID, req)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return APIResponse{http.StatusOK, img}, nil
+}
+
+// parseRange parses the Range header of a request for the data of an
+// image of size bytes and returns the offset and length of the data to
+// send.  Only single byte ranges are supported, the whole image is sent
+// for any other range.
+func parseRange(header string, size uint64) (uint64, uint64, error) {
+	spec := strings.TrimPrefix(header, "bytes=")
+	if spec == header || strings.Contains(spec, ",") {
+		return 0, size, nil
+	}
+
+	i := strings.Index(spec, "-")
+	if i < 0 {
+		return 0, 0, ErrBadRange
+	}
+
+	first := strings.TrimSpace(spec[:i])
+	last := strings.TrimSpace(spec[i+1:])
+
+	if first == "" {
+		n, err := strconv.ParseUint(last, 10, 64)
+		if err != nil || n == 0 || size == 0 {
+			return 0, 0, ErrBadRange
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, n, nil
+	}
+
+	start, err := strconv.ParseUint(first, 10, 64)
+	if err != nil || start >= size {
+		return 0, 0, ErrBadRange
+	}
+
+	end := size - 1
+	if last != "" {
+		end, err = strconv.ParseUint(last, 10, 64)
+		if err != nil || end < start {
+			return 0, 0, ErrBadRange
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+
+	return start, end - start + 1, nil
+}
+
+// downloadImage streams the data of an image, or the part of it given
+// by the Range header of the request.  Images without data, such as
+// queued images, have an empty response.
+func downloadImage(context *Context, w http.ResponseWriter, r *http.Request) (APIResponse, error) {
+	vars := mux.Vars(r)
+	imageID := vars["image_id"]
+
+	table, resp, err := findImageTable(context, r, imageID)
+	if err != nil {
+		return resp, err
+	}
+
+	img, err := context.GetImage(table, imageID)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	if img.Status == Deactivated && !service.GetPrivilege(r.Context()) {
+		return errorResponse(ErrImageDeactivated), ErrImageDeactivated
+	}
+
+	if (img.Status != Active && img.Status != Deactivated) || img.Size == nil {
+		return errorResponse(ErrNoImageData), nil
+	}
+
+	size := uint64(*img.Size)
+	offset, length, err := parseRange(r.Header.Get("Range"), size)
+	if err != nil {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		return errorResponse(err), err
+	}
+
+	data, err := context.DownloadImage(table, imageID, offset, length)
+	if err != nil {
+		return errorResponse(err), err
+	}
+	defer data.Close()
+
+	status := http.StatusOK
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatUint(length, 10))
+	if length != size {
+		status = http.StatusPartialContent
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+length-1, size))
+	} else if data.Checksum != "" {
+		w.Header().Set("Content-MD5", data.Checksum)
+	}
+
+	w.WriteHeader(status)
+	_, err = io.Copy(w, data)
+
+	return APIResponse{}, err
+}
+
+// listMembers returns the members of an image.  The members of a shared
+// image only see their own membership.
+func listMembers(context *Context, w http.ResponseWriter, r *http.Request) (APIResponse, error) {
+	vars := mux.Vars(r)
+	imageID := vars["image_id"]
+
+	table, resp, err := findImageTable(context, r, imageID)
+	if err != nil {
+		return resp, err
+	}
+
+	members, err := context.ListMembers(table, imageID)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	resp = APIResponse{http.StatusOK, ListMembersResponse{
+		Members: members,
+		Schema:  "/v2/schemas/members",
+	}}
+
+	return resp, nil
+}
+
+// getMember returns a member of an image.
+func getMember(context *Context, w http.ResponseWriter, r *http.Request) (APIResponse, error) {
+	vars := mux.Vars(r)
+	imageID := vars["image_id"]
+	memberID := vars["member_id"]
+
+	table, resp, err := findImageTable(context, r, imageID)
+	if err != nil {
+		return resp, err
+	}
+
+	members, err := context.ListMembers(table, imageID)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	for _, m := range members {
+		if m.MemberID == memberID {
+			return APIResponse{http.StatusOK, m}, nil
+		}
+	}
+
+	return errorResponse(ErrNoMember), ErrNoMember
+}
+
+// addMember shares an image with a tenant.  The membership is pending
+// until the tenant accepts it.
+func addMember(context *Context, w http.ResponseWriter, r *http.Request) (APIResponse, error) {
+	defer r.Body.Close()
+	vars := mux.Vars(r)
+	imageID := vars["image_id"]
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return APIResponse{http.StatusBadRequest, nil}, err
+	}
+
+	var req CreateMemberRequest
+
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		return APIResponse{http.StatusBadRequest, nil}, err
+	}
+
+	if req.Member == "" {
+		return errorResponse(ErrBadMember), ErrBadMember
+	}
+
+	table, resp, err := findImageTable(context, r, imageID)
+	if err != nil {
+		return resp, err
+	}
+
+	member, err := context.AddMember(table, imageID, req.Member)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return APIResponse{http.StatusOK, member}, nil
+}
+
+// updateMember sets the status of a membership.  Only the member can
+// accept or reject an image.
+func updateMember(context *Context, w http.ResponseWriter, r *http.Request) (APIResponse, error) {
+	defer r.Body.Close()
+	vars := mux.Vars(r)
+	imageID := vars["image_id"]
+	memberID := vars["member_id"]
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return APIResponse{http.StatusBadRequest, nil}, err
+	}
+
+	var req UpdateMemberRequest
+
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		return APIResponse{http.StatusBadRequest, nil}, err
+	}
+
+	switch req.Status {
+	case MemberPending, MemberAccepted, MemberRejected:
+	default:
+		return errorResponse(ErrBadMember), ErrBadMember
+	}
+
+	table, resp, err := findImageTable(context, r, imageID)
+	if err != nil {
+		return resp, err
+	}
+
+	member, err := context.UpdateMember(table, imageID, memberID, req.Status)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return APIResponse{http.StatusOK, member}, nil
+}
+
+// deleteMember stops sharing an image with a tenant.
+func deleteMember(context *Context, w http.ResponseWriter, r *http.Request) (APIResponse, error) {
+	vars := mux.Vars(r)
+	imageID := vars["image_id"]
+	memberID := vars["member_id"]
+
+	table, resp, err := findImageTable(context, r, imageID)
+	if err != nil {
+		return resp, err
+	}
+
+	err = context.DeleteMember(table, imageID, memberID)
+	if err != nil {
+		return errorResponse(err), err
+	}
+
+	return APIResponse{http.StatusNoContent, nil}, nil
+}
+
 // Routes provides gorilla mux routes for the supported endpoints.
 func Routes(config APIConfig, serviceClient *gophercloud.ServiceClient) *mux.Router {
 	// make new Context
@@ -548,9 +1398,20 @@ func Routes(config APIConfig, serviceClient *gophercloud.ServiceClient) *mux.Rou
 	r.Handle("/", APIHandler{context, listAPIVersions}).Methods("GET")
 	r.Handle("/v2/images", APIHandler{context, createImage}).Methods("POST")
 	r.Handle("/v2/images/{image_id:"+uuid.UUIDRegex+"}/file", APIHandler{context, uploadImage}).Methods("PUT")
+	r.Handle("/v2/images/{image_id:"+uuid.UUIDRegex+"}/file", DataHandler{context, downloadImage}).Methods("GET")
 	r.Handle("/v2/images", APIHandler{context, listImages}).Methods("GET")
 	r.Handle("/v2/images/{image_id:"+uuid.UUIDRegex+"}", APIHandler{context, getImage}).Methods("GET")
 	r.Handle("/v2/images/{image_id:"+uuid.UUIDRegex+"}", APIHandler{context, deleteImage}).Methods("DELETE")
+	r.Handle("/v2/images/{image_id:"+uuid.UUIDRegex+"}", APIHandler{context, updateImage}).Methods("PATCH")
+	r.Handle("/v2/images/{image_id:"+uuid.UUIDRegex+"}/import", APIHandler{context, importImage}).Methods("POST")
+	r.Handle("/v2/images/{image_id:"+uuid.UUIDRegex+"}/actions/deactivate", APIHandler{context, deactivateImage}).Methods("POST")
+	r.Handle("/v2/images/{image_id:"+uuid.UUIDRegex+"}/actions/reactivate", APIHandler{context, reactivateImage}).Methods("POST")
+	r.Handle("/v2/info/import", APIHandler{context, listImportMethods}).Methods("GET")
+	r.Handle("/v2/images/{image_id:"+uuid.UUIDRegex+"}/members", APIHandler{context, listMembers}).Methods("GET")
+	r.Handle("/v2/images/{image_id:"+uuid.UUIDRegex+"}/members", APIHandler{context, addMember}).Methods("POST")
+	r.Handle("/v2/images/{image_id:"+uuid.UUIDRegex+"}/members/{member_id}", APIHandler{context, getMember}).Methods("GET")
+	r.Handle("/v2/images/{image_id:"+uuid.UUIDRegex+"}/members/{member_id}", APIHandler{context, updateMember}).Methods("PUT")
+	r.Handle("/v2/images/{image_id:"+uuid.UUIDRegex+"}/members/{member_id}", APIHandler{context, deleteMember}).Methods("DELETE")
 
 	return r
 }
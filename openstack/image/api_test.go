@@ -16,11 +16,14 @@ package image
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -52,7 +55,7 @@ var tests = []test{
 		createImage,
 		`{"container_format":"bare","disk_format":"raw","name":"Ubuntu","id":"b2173dd3-7ad6-4362-baa6-a68bce3565cb","visibility":"private"}`,
 		http.StatusCreated,
-		`{"status":"queued","container_format":"bare","min_ram":0,"updated_at":"2015-11-29T22:21:42Z","owner":"bab7d5c60cd041a0a36f7c4b6e1dd978","min_disk":0,"tags":[],"locations":[],"visibility":"private","id":"b2173dd3-7ad6-4362-baa6-a68bce3565cb","size":null,"virtual_size":null,"name":"Ubuntu","checksum":null,"created_at":"2015-11-29T22:21:42Z","disk_format":"raw","properties":null,"protected":false,"self":"/v2/images/b2173dd3-7ad6-4362-baa6-a68bce3565cb","file":"/v2/images/b2173dd3-7ad6-4362-baa6-a68bce3565cb/file","schema":"/v2/schemas/image"}`,
+		`{"status":"queued","container_format":"bare","min_ram":0,"updated_at":"2015-11-29T22:21:42Z","owner":"bab7d5c60cd041a0a36f7c4b6e1dd978","min_disk":0,"tags":[],"locations":[],"visibility":"private","id":"b2173dd3-7ad6-4362-baa6-a68bce3565cb","size":null,"virtual_size":null,"name":"Ubuntu","checksum":null,"os_hash_algo":null,"os_hash_value":null,"created_at":"2015-11-29T22:21:42Z","disk_format":"raw","properties":null,"protected":false,"self":"/v2/images/b2173dd3-7ad6-4362-baa6-a68bce3565cb","file":"/v2/images/b2173dd3-7ad6-4362-baa6-a68bce3565cb/file","schema":"/v2/schemas/image"}`,
 	},
 	{
 		"POST",
@@ -68,7 +71,7 @@ var tests = []test{
 		listImages,
 		"",
 		http.StatusOK,
-		`{"images":[{"status":"queued","container_format":"bare","min_ram":0,"updated_at":"2015-11-29T22:21:42Z","owner":"bab7d5c60cd041a0a36f7c4b6e1dd978","min_disk":0,"tags":[],"locations":[],"visibility":"private","id":"b2173dd3-7ad6-4362-baa6-a68bce3565cb","size":null,"virtual_size":null,"name":"Ubuntu","checksum":null,"created_at":"2015-11-29T22:21:42Z","disk_format":"raw","properties":null,"protected":false,"self":"/v2/images/b2173dd3-7ad6-4362-baa6-a68bce3565cb","file":"/v2/images/b2173dd3-7ad6-4362-baa6-a68bce3565cb/file","schema":"/v2/schemas/image"}],"schema":"/v2/schemas/images","first":"/v2/images"}`,
+		`{"images":[{"status":"queued","container_format":"bare","min_ram":0,"updated_at":"2015-11-29T22:21:42Z","owner":"bab7d5c60cd041a0a36f7c4b6e1dd978","min_disk":0,"tags":[],"locations":[],"visibility":"private","id":"b2173dd3-7ad6-4362-baa6-a68bce3565cb","size":null,"virtual_size":null,"name":"Ubuntu","checksum":null,"os_hash_algo":null,"os_hash_value":null,"created_at":"2015-11-29T22:21:42Z","disk_format":"raw","properties":null,"protected":false,"self":"/v2/images/b2173dd3-7ad6-4362-baa6-a68bce3565cb","file":"/v2/images/b2173dd3-7ad6-4362-baa6-a68bce3565cb/file","schema":"/v2/schemas/image"}],"schema":"/v2/schemas/images","first":"/v2/images"}`,
 	},
 	{
 		"GET",
@@ -76,7 +79,7 @@ var tests = []test{
 		getImage,
 		"",
 		http.StatusOK,
-		`{"status":"active","container_format":"bare","min_ram":0,"updated_at":"2014-05-05T17:15:11Z","owner":"5ef70662f8b34079a6eddb8da9d75fe8","min_disk":0,"tags":[],"locations":[],"visibility":"public","id":"1bea47ed-f6a9-463b-b423-14b9cca9ad27","size":13167616,"virtual_size":null,"name":"cirros-0.3.2-x86_64-disk","checksum":"64d7c1cd2b6f60c92c14662941cb7913","created_at":"2014-05-05T17:15:10Z","disk_format":"qcow2","properties":null,"protected":false,"self":"/v2/images/1bea47ed-f6a9-463b-b423-14b9cca9ad27","file":"/v2/images/1bea47ed-f6a9-463b-b423-14b9cca9ad27/file","schema":"/v2/schemas/image"}`,
+		`{"status":"active","container_format":"bare","min_ram":0,"updated_at":"2014-05-05T17:15:11Z","owner":"5ef70662f8b34079a6eddb8da9d75fe8","min_disk":0,"tags":[],"locations":[],"visibility":"public","id":"1bea47ed-f6a9-463b-b423-14b9cca9ad27","size":13167616,"virtual_size":null,"name":"cirros-0.3.2-x86_64-disk","checksum":"64d7c1cd2b6f60c92c14662941cb7913","os_hash_algo":"sha256","os_hash_value":"3d2a4b27bc2b3fd2ed5ab2b9bcbf4b6bb4cbd0c7fd0d6ae02b0e0cb27d2f8bd2","created_at":"2014-05-05T17:15:10Z","disk_format":"qcow2","properties":null,"protected":false,"self":"/v2/images/1bea47ed-f6a9-463b-b423-14b9cca9ad27","file":"/v2/images/1bea47ed-f6a9-463b-b423-14b9cca9ad27/file","schema":"/v2/schemas/image"}`,
 	},
 	{
 		"DELETE",
@@ -94,6 +97,30 @@ var tests = []test{
 		http.StatusNoContent,
 		`null`,
 	},
+	{
+		"POST",
+		"/v2/images/1bea47ed-f6a9-463b-b423-14b9cca9ad27/import",
+		importImage,
+		`{"method":{"name":"web-download","uri":"https://example.com/cirros.img"}}`,
+		http.StatusAccepted,
+		`null`,
+	},
+	{
+		"POST",
+		"/v2/images/1bea47ed-f6a9-463b-b423-14b9cca9ad27/import",
+		importImage,
+		`{"method":{"name":"glance-direct"}}`,
+		http.StatusBadRequest,
+		"Unsupported import method\nnull",
+	},
+	{
+		"GET",
+		"/v2/info/import",
+		listImportMethods,
+		"",
+		http.StatusOK,
+		`{"import-methods":{"description":"Import methods available.","type":"array","value":["web-download"]}}`,
+	},
 }
 
 const testTenantID = "1bea47ed-f6a9-463b-b423-14b9cca9ad27"
@@ -183,6 +210,8 @@ func (is testImageService) GetImage(tenantID, ID string) (DefaultResponse, error
 	minRAM := 0
 	owner := "5ef70662f8b34079a6eddb8da9d75fe8"
 	checksum := "64d7c1cd2b6f60c92c14662941cb7913"
+	hashAlgo := "sha256"
+	hashValue := "3d2a4b27bc2b3fd2ed5ab2b9bcbf4b6bb4cbd0c7fd0d6ae02b0e0cb27d2f8bd2"
 	size := 13167616
 
 	return DefaultResponse{
@@ -198,6 +227,8 @@ func (is testImageService) GetImage(tenantID, ID string) (DefaultResponse, error
 		MinDisk:         &minDisk,
 		Protected:       false,
 		CheckSum:        &checksum,
+		OSHashAlgo:      &hashAlgo,
+		OSHashValue:     &hashValue,
 		ID:              imageID,
 
 		File:   fmt.Sprintf("/v2/images/%s/file", imageID),
@@ -209,7 +240,7 @@ func (is testImageService) GetImage(tenantID, ID string) (DefaultResponse, error
 	}, nil
 }
 
-func (is testImageService) UploadImage(string, string, io.Reader) (NoContentImageResponse, error) {
+func (is testImageService) UploadImage(string, string, io.Reader, string) (NoContentImageResponse, error) {
 	return NoContentImageResponse{}, nil
 }
 
@@ -217,6 +248,72 @@ func (is testImageService) DeleteImage(string, string) (NoContentImageResponse,
 	return NoContentImageResponse{}, nil
 }
 
+func (is testImageService) ImportImage(string, string, ImportImageRequest) (NoContentImageResponse, error) {
+	return NoContentImageResponse{}, nil
+}
+
+func (is testImageService) DownloadImage(tenantID, ID string, offset, length uint64) (ImageData, error) {
+	data := strings.Repeat("c", int(length))
+	return ImageData{ioutil.NopCloser(strings.NewReader(data)), "64d7c1cd2b6f60c92c14662941cb7913"}, nil
+}
+
+func (is testImageService) UpdateImage(tenantID, ID string, req UpdateImageRequest) (DefaultResponse, error) {
+	img, _ := is.GetImage(tenantID, ID)
+	if req.Name != nil {
+		img.Name = req.Name
+	}
+	if req.Tags != nil {
+		img.Tags = *req.Tags
+	}
+	if req.Visibility != nil {
+		img.Visibility = *req.Visibility
+	}
+	if req.Protected != nil {
+		img.Protected = *req.Protected
+	}
+
+	return img, nil
+}
+
+const testMemberID = "9a2d6a1c-54a1-4c5b-bbf6-2a04f50b5ef2"
+
+func testMember(imageID string, status MemberStatus) Member {
+	createdAt, _ := time.Parse(time.RFC3339, "2017-05-05T17:15:10Z")
+
+	return Member{
+		CreatedAt: createdAt,
+		ImageID:   imageID,
+		MemberID:  testMemberID,
+		Schema:    "/v2/schemas/member",
+		Status:    status,
+		UpdatedAt: createdAt,
+	}
+}
+
+func (is testImageService) ListMembers(tenantID, ID string) ([]Member, error) {
+	return []Member{testMember(ID, MemberPending)}, nil
+}
+
+func (is testImageService) AddMember(tenantID, ID, memberID string) (Member, error) {
+	return testMember(ID, MemberPending), nil
+}
+
+func (is testImageService) UpdateMember(tenantID, ID, memberID string, status MemberStatus) (Member, error) {
+	return testMember(ID, status), nil
+}
+
+func (is testImageService) DeleteMember(tenantID, ID, memberID string) error {
+	return nil
+}
+
+func (is testImageService) DeactivateImage(tenantID, ID string) error {
+	return nil
+}
+
+func (is testImageService) ReactivateImage(tenantID, ID string) error {
+	return nil
+}
+
 func TestRoutes(t *testing.T) {
 	var is testImageService
 	config := APIConfig{9292, is}
@@ -261,3 +358,267 @@ func TestAPIResponse(t *testing.T) {
 		}
 	}
 }
+
+func TestDownloadImage(t *testing.T) {
+	var is testImageService
+
+	r := Routes(APIConfig{9292, is}, nil)
+
+	tests := []struct {
+		rangeHeader  string
+		status       int
+		length       int
+		contentRange string
+		contentMD5   string
+	}{
+		{"", http.StatusOK, 13167616, "", "64d7c1cd2b6f60c92c14662941cb7913"},
+		{"bytes=0-99", http.StatusPartialContent, 100, "bytes 0-99/13167616", ""},
+		{"bytes=13167516-", http.StatusPartialContent, 100, "bytes 13167516-13167615/13167616", ""},
+		{"bytes=-100", http.StatusPartialContent, 100, "bytes 13167516-13167615/13167616", ""},
+		{"bytes=13167600-13200000", http.StatusPartialContent, 16, "bytes 13167600-13167615/13167616", ""},
+		{"bytes=0-9,20-29", http.StatusOK, 13167616, "", "64d7c1cd2b6f60c92c14662941cb7913"},
+		{"bytes=13167616-", http.StatusRequestedRangeNotSatisfiable, 0, "bytes */13167616", ""},
+		{"bytes=10-5", http.StatusRequestedRangeNotSatisfiable, 0, "bytes */13167616", ""},
+	}
+
+	for _, tt := range tests {
+		req, err := http.NewRequest("GET", "/v2/images/1bea47ed-f6a9-463b-b423-14b9cca9ad27/file", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if tt.rangeHeader != "" {
+			req.Header.Set("Range", tt.rangeHeader)
+		}
+
+		ctx := service.SetPrivilege(req.Context(), true)
+		ctx = service.SetTenantID(ctx, testTenantID)
+
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req.WithContext(ctx))
+
+		if rr.Code != tt.status {
+			t.Errorf("Range %q: got status %v, expected %v", tt.rangeHeader, rr.Code, tt.status)
+			continue
+		}
+
+		if tt.status == http.StatusOK || tt.status == http.StatusPartialContent {
+			if rr.Body.Len() != tt.length {
+				t.Errorf("Range %q: got %d bytes, expected %d", tt.rangeHeader, rr.Body.Len(), tt.length)
+			}
+		}
+
+		if cr := rr.Header().Get("Content-Range"); cr != tt.contentRange {
+			t.Errorf("Range %q: got Content-Range %q, expected %q", tt.rangeHeader, cr, tt.contentRange)
+		}
+
+		if md5 := rr.Header().Get("Content-MD5"); md5 != tt.contentMD5 {
+			t.Errorf("Range %q: got Content-MD5 %q, expected %q", tt.rangeHeader, md5, tt.contentMD5)
+		}
+	}
+}
+
+func TestUpdateImage(t *testing.T) {
+	var is testImageService
+
+	r := Routes(APIConfig{9292, is}, nil)
+
+	tests := []struct {
+		contentType string
+		privileged  bool
+		patch       string
+		status      int
+		name        string
+		tags        []string
+		visibility  Visibility
+	}{
+		{PatchContentType, false, `[{"op":"replace","path":"/name","value":"cirros"}]`,
+			http.StatusForbidden, "", nil, ""},
+		{PatchContentType, true, `[{"op":"replace","path":"/name","value":"cirros"}]`,
+			http.StatusOK, "cirros", []string{}, Public},
+		{PatchContentType, true, `[{"op":"add","path":"/tags","value":["a","b"]},{"op":"replace","path":"/visibility","value":"internal"}]`,
+			http.StatusOK, "cirros-0.3.2-x86_64-disk", []string{"a", "b"}, Internal},
+		{PatchContentType, true, `[{"op":"remove","path":"/tags"}]`,
+			http.StatusOK, "cirros-0.3.2-x86_64-disk", []string{}, Public},
+		{PatchContentType, true, `[{"op":"replace","path":"/protected","value":true}]`,
+			http.StatusOK, "cirros-0.3.2-x86_64-disk", []string{}, Public},
+		{PatchContentType, true, `[{"op":"replace","path":"/protected","value":"yes"}]`,
+			http.StatusBadRequest, "", nil, ""},
+		{PatchContentType, true, `[{"op":"replace","path":"/status","value":"active"}]`,
+			http.StatusForbidden, "", nil, ""},
+		{PatchContentType, true, `[{"op":"replace","path":"/visibility","value":"community"}]`,
+			http.StatusBadRequest, "", nil, ""},
+		{PatchContentType, true, `[{"op":"remove","path":"/name"}]`,
+			http.StatusBadRequest, "", nil, ""},
+		{PatchContentType, true, `[{"op":"add","path":"/tags","value":["a,b"]}]`,
+			http.StatusBadRequest, "", nil, ""},
+		{PatchContentType, true, `[{"op":"move","path":"/name","from":"/tags"}]`,
+			http.StatusBadRequest, "", nil, ""},
+		{"application/json", true, `[{"op":"replace","path":"/name","value":"cirros"}]`,
+			http.StatusUnsupportedMediaType, "", nil, ""},
+	}
+
+	for _, tt := range tests {
+		req, err := http.NewRequest("PATCH", "/v2/images/1bea47ed-f6a9-463b-b423-14b9cca9ad27",
+			strings.NewReader(tt.patch))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", tt.contentType)
+
+		ctx := service.SetPrivilege(req.Context(), tt.privileged)
+		ctx = service.SetTenantID(ctx, testTenantID)
+
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req.WithContext(ctx))
+
+		if rr.Code != tt.status {
+			t.Errorf("%s: got status %v, expected %v", tt.patch, rr.Code, tt.status)
+			continue
+		}
+
+		if tt.status != http.StatusOK {
+			continue
+		}
+
+		var img DefaultResponse
+		err = json.Unmarshal(rr.Body.Bytes(), &img)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if img.Name == nil || *img.Name != tt.name {
+			t.Errorf("%s: got name %v, expected %s", tt.patch, img.Name, tt.name)
+		}
+
+		if img.Visibility != tt.visibility {
+			t.Errorf("%s: got visibility %s, expected %s", tt.patch, img.Visibility, tt.visibility)
+		}
+
+		if strings.Join(img.Tags, ",") != strings.Join(tt.tags, ",") {
+			t.Errorf("%s: got tags %v, expected %v", tt.patch, img.Tags, tt.tags)
+		}
+	}
+}
+
+func TestParsePatchSignature(t *testing.T) {
+	var ops []PatchOperation
+
+	patch := `[{"op":"add","path":"/img_signature","value":"c2lnbmF0dXJl"},
+		{"op":"add","path":"/img_signature_hash_method","value":"SHA-256"},
+		{"op":"remove","path":"/img_signature_key_type"}]`
+	err := json.Unmarshal([]byte(patch), &ops)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := parsePatch(ops)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sig Signature
+	for property, value := range req.SignatureProperties {
+		sig.SetProperty(property, value)
+	}
+
+	if len(req.SignatureProperties) != 3 || sig.Signature != "c2lnbmF0dXJl" ||
+		sig.HashMethod != "SHA-256" || sig.KeyType != "" {
+		t.Fatalf("Unexpected signature properties %v", req.SignatureProperties)
+	}
+
+	ops = []PatchOperation{{Op: "add", Path: "/img_signature", Value: json.RawMessage("42")}}
+	_, err = parsePatch(ops)
+	if err != ErrBadPatch {
+		t.Fatalf("Expected %v, got %v", ErrBadPatch, err)
+	}
+}
+
+func TestImageActions(t *testing.T) {
+	var is testImageService
+
+	r := Routes(APIConfig{9292, is}, nil)
+
+	tests := []struct {
+		action     string
+		privileged bool
+		status     int
+	}{
+		{"deactivate", true, http.StatusNoContent},
+		{"deactivate", false, http.StatusForbidden},
+		{"reactivate", true, http.StatusNoContent},
+		{"reactivate", false, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		req, err := http.NewRequest("POST",
+			"/v2/images/1bea47ed-f6a9-463b-b423-14b9cca9ad27/actions/"+tt.action, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ctx := service.SetPrivilege(req.Context(), tt.privileged)
+		ctx = service.SetTenantID(ctx, testTenantID)
+
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req.WithContext(ctx))
+
+		if rr.Code != tt.status {
+			t.Errorf("%s (privileged %v): got status %v, expected %v",
+				tt.action, tt.privileged, rr.Code, tt.status)
+		}
+	}
+}
+
+func TestImageMembers(t *testing.T) {
+	var is testImageService
+
+	r := Routes(APIConfig{9292, is}, nil)
+
+	member := `{"created_at":"2017-05-05T17:15:10Z","image_id":"1bea47ed-f6a9-463b-b423-14b9cca9ad27","member_id":"` +
+		testMemberID + `","schema":"/v2/schemas/member","status":"%s","updated_at":"2017-05-05T17:15:10Z"}`
+	membersURL := "/v2/images/1bea47ed-f6a9-463b-b423-14b9cca9ad27/members"
+
+	tests := []struct {
+		method   string
+		url      string
+		request  string
+		status   int
+		response string
+	}{
+		{"GET", membersURL, "", http.StatusOK,
+			`{"members":[` + fmt.Sprintf(member, MemberPending) + `],"schema":"/v2/schemas/members"}`},
+		{"POST", membersURL, `{"member":"` + testMemberID + `"}`, http.StatusOK,
+			fmt.Sprintf(member, MemberPending)},
+		{"POST", membersURL, `{}`, http.StatusBadRequest, ""},
+		{"GET", membersURL + "/" + testMemberID, "", http.StatusOK,
+			fmt.Sprintf(member, MemberPending)},
+		{"GET", membersURL + "/" + testTenantID, "", http.StatusNotFound, ""},
+		{"PUT", membersURL + "/" + testMemberID, `{"status":"accepted"}`, http.StatusOK,
+			fmt.Sprintf(member, MemberAccepted)},
+		{"PUT", membersURL + "/" + testMemberID, `{"status":"ignored"}`, http.StatusBadRequest, ""},
+		{"DELETE", membersURL + "/" + testMemberID, "", http.StatusNoContent, "null"},
+	}
+
+	for _, tt := range tests {
+		req, err := http.NewRequest(tt.method, tt.url, strings.NewReader(tt.request))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ctx := service.SetPrivilege(req.Context(), true)
+		ctx = service.SetTenantID(ctx, testTenantID)
+
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req.WithContext(ctx))
+
+		if rr.Code != tt.status {
+			t.Errorf("%s %s: got status %v, expected %v", tt.method, tt.url, rr.Code, tt.status)
+			continue
+		}
+
+		if tt.response != "" && rr.Body.String() != tt.response {
+			t.Errorf("%s %s: failed\ngot: %v\nexp: %v", tt.method, tt.url, rr.Body.String(), tt.response)
+		}
+	}
+}
@@ -79,6 +79,25 @@ type ConfigureController struct {
 	CNCIDisk         int    `yaml:"cnci_disk"`
 	AdminSSHKey      string `yaml:"admin_ssh_key"`
 	AdminPassword    string `yaml:"admin_password"`
+
+	// RequireSignedImages prevents instances and volumes from being
+	// created from images whose signature has not been verified.
+	RequireSignedImages bool `yaml:"require_signed_images,omitempty"`
+
+	// ImageSigningCerts is the directory holding the PEM encoded
+	// certificates, named <certificate uuid>.pem, with which image
+	// signatures are verified.
+	ImageSigningCerts string `yaml:"image_signing_certs,omitempty"`
+
+	// TenantIPv6Prefix is the IPv6 /48 out of which the /64 of each
+	// tenant subnet is allocated. Tenant subnets are IPv4 only when
+	// it is empty.
+	TenantIPv6Prefix string `yaml:"tenant_ipv6_prefix,omitempty"`
+
+	// TenantDNSDomain is the DNS domain under which the instances of
+	// a tenant resolve each other by name. It defaults to ciao when
+	// empty.
+	TenantDNSDomain string `yaml:"tenant_dns_domain,omitempty"`
 }
 
 // ConfigureLauncher contains the unmarshalled configurations for the
@@ -88,12 +107,30 @@ type ConfigureLauncher struct {
 	ManagementNetwork []string `yaml:"mgmt_net"`
 	DiskLimit         bool     `yaml:"disk_limit"`
 	MemoryLimit       bool     `yaml:"mem_limit"`
+
+	// TunnelMode is the overlay linking tenant instances to their
+	// CNCI, gre or vxlan.  It defaults to gre when empty.
+	TunnelMode string `yaml:"tunnel_mode,omitempty"`
+
+	// VxlanGroup is the multicast group through which the end points
+	// of vxlan tunnels find each other.  The CNCIs send the end points
+	// to the compute nodes instead when it is empty.
+	VxlanGroup string `yaml:"vxlan_group,omitempty"`
+}
+
+// ConfigureVolumeType contains the unmarshalled configuration of a volume
+// type, i.e., a named set of settings that can be applied to new volumes.
+type ConfigureVolumeType struct {
+	Name      string   `yaml:"name"`
+	IOLimits  IOLimits `yaml:"io_limits"`
+	Encrypted bool     `yaml:"encrypted,omitempty"`
 }
 
 // ConfigureStorage contains the unmarshalled configurations for the
 // Ceph storage driver.
 type ConfigureStorage struct {
-	CephID string `yaml:"ceph_id"`
+	CephID      string                `yaml:"ceph_id"`
+	VolumeTypes []ConfigureVolumeType `yaml:"volume_types,omitempty"`
 }
 
 // ConfigureService contains the unmarshalled configurations for the resources
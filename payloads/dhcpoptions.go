@@ -0,0 +1,64 @@
+/*
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package payloads
+
+// HostRoute is a static route handed out by DHCP to the instances of a
+// tenant subnet.
+type HostRoute struct {
+	// The IPv4 subnet reached through the route, in CIDR notation.
+	Destination string `yaml:"destination"`
+
+	// The address of the next hop, within the tenant subnet.
+	NextHop string `yaml:"nexthop"`
+}
+
+// DHCPOptionsEvent is populated by the controller whenever the DHCP options
+// of a tenant subnet are set.  It is sent to the CNCI of the tenant, via the
+// scheduler, which hands the options out to the instances of the subnet.
+// The event always carries all the options of the subnet, options that are
+// not present are no longer handed out.
+type DHCPOptionsEvent struct {
+	// The UUID of the concentrator the event is destined to.
+	ConcentratorUUID string `yaml:"concentrator_uuid"`
+
+	// The UUID of the tenant.
+	TenantUUID string `yaml:"tenant_uuid"`
+
+	// The UUID of the tenant subnet.
+	SubnetUUID string `yaml:"subnet_uuid"`
+
+	// The tenant subnet, in CIDR notation.
+	TenantSubnet string `yaml:"tenant_subnet"`
+
+	// The addresses of the DNS servers of the instances.
+	DNSServers []string `yaml:"dns_servers,omitempty"`
+
+	// The domains searched when resolving unqualified names.
+	SearchDomains []string `yaml:"search_domains,omitempty"`
+
+	// Static routes, in addition to the default route.
+	Routes []HostRoute `yaml:"routes,omitempty"`
+
+	// The addresses of the NTP servers of the instances.
+	NTPServers []string `yaml:"ntp_servers,omitempty"`
+}
+
+// EventDHCPOptionsUpdated represents the unmarshalled version of the
+// contents of an SSNTP ssntp.DHCPOptionsUpdated event payload.
+type EventDHCPOptionsUpdated struct {
+	DHCPOptions DHCPOptionsEvent `yaml:"dhcp_options_updated"`
+}
@@ -0,0 +1,86 @@
+/*
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package payloads_test
+
+import (
+	"testing"
+
+	. "github.com/01org/ciao/payloads"
+	"github.com/01org/ciao/testutil"
+	"gopkg.in/yaml.v2"
+)
+
+func TestDHCPOptionsUnmarshal(t *testing.T) {
+	var ev EventDHCPOptionsUpdated
+
+	err := yaml.Unmarshal([]byte(testutil.DHCPOptionsYaml), &ev)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ev.DHCPOptions.ConcentratorUUID != testutil.CNCIUUID {
+		t.Errorf("Wrong concentrator UUID field [%s]", ev.DHCPOptions.ConcentratorUUID)
+	}
+
+	if ev.DHCPOptions.TenantSubnet != testutil.TenantSubnet {
+		t.Errorf("Wrong tenant subnet field [%s]", ev.DHCPOptions.TenantSubnet)
+	}
+
+	if len(ev.DHCPOptions.DNSServers) != 1 || ev.DHCPOptions.DNSServers[0] != testutil.DHCPServerIP {
+		t.Errorf("Wrong DNS servers field %v", ev.DHCPOptions.DNSServers)
+	}
+
+	if len(ev.DHCPOptions.SearchDomains) != 1 || ev.DHCPOptions.SearchDomains[0] != testutil.TenantDomain {
+		t.Errorf("Wrong search domains field %v", ev.DHCPOptions.SearchDomains)
+	}
+
+	if len(ev.DHCPOptions.Routes) != 1 ||
+		ev.DHCPOptions.Routes[0].Destination != testutil.DHCPRouteDestination ||
+		ev.DHCPOptions.Routes[0].NextHop != testutil.DHCPRouteNextHop {
+		t.Errorf("Wrong routes field %v", ev.DHCPOptions.Routes)
+	}
+
+	if len(ev.DHCPOptions.NTPServers) != 0 {
+		t.Errorf("Wrong NTP servers field %v", ev.DHCPOptions.NTPServers)
+	}
+}
+
+func TestDHCPOptionsMarshal(t *testing.T) {
+	var ev EventDHCPOptionsUpdated
+
+	ev.DHCPOptions.ConcentratorUUID = testutil.CNCIUUID
+	ev.DHCPOptions.TenantUUID = testutil.TenantUUID
+	ev.DHCPOptions.SubnetUUID = testutil.SubnetUUID
+	ev.DHCPOptions.TenantSubnet = testutil.TenantSubnet
+	ev.DHCPOptions.DNSServers = []string{testutil.DHCPServerIP}
+	ev.DHCPOptions.SearchDomains = []string{testutil.TenantDomain}
+	ev.DHCPOptions.Routes = []HostRoute{
+		{
+			Destination: testutil.DHCPRouteDestination,
+			NextHop:     testutil.DHCPRouteNextHop,
+		},
+	}
+
+	y, err := yaml.Marshal(&ev)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(y) != testutil.DHCPOptionsYaml {
+		t.Errorf("DHCPOptionsUpdated marshalling failed\n[%s]\n vs\n[%s]", string(y), testutil.DHCPOptionsYaml)
+	}
+}
@@ -0,0 +1,68 @@
+/*
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package payloads
+
+// DNSRecord maps the name of a tenant instance to its private IP addresses.
+// It also carries the MAC addresses of the instance so that the CNCI can
+// hand out the private IP addresses of instances with custom MAC addresses.
+type DNSRecord struct {
+	// The UUID of the instance.
+	InstanceUUID string `yaml:"instance_uuid"`
+
+	// The name the instance is resolved by within the tenant domain.
+	Name string `yaml:"name"`
+
+	// The private IP address of the instance.
+	PrivateIP string `yaml:"private_ip"`
+
+	// The MAC address the private IP address is handed out to.
+	MACAddress string `yaml:"mac_address,omitempty"`
+
+	// The private IP addresses of the additional NICs of the instance.
+	ExtraPrivateIPs []string `yaml:"extra_private_ips,omitempty"`
+
+	// The MAC addresses of the additional NICs of the instance, in the
+	// order of ExtraPrivateIPs.
+	ExtraMACAddresses []string `yaml:"extra_mac_addresses,omitempty"`
+}
+
+// DNSRecordsEvent is populated by the controller whenever instances of a
+// tenant are added or deleted.  It is sent to the CNCI of the tenant, via
+// the scheduler, so that the instances can resolve each other by name.
+type DNSRecordsEvent struct {
+	// The UUID of the concentrator the event is destined to.
+	ConcentratorUUID string `yaml:"concentrator_uuid"`
+
+	// The UUID of the tenant.
+	TenantUUID string `yaml:"tenant_uuid"`
+
+	// The DNS domain of the tenant, instances are resolved as
+	// <name>.<domain>.
+	Domain string `yaml:"domain"`
+
+	// The records of the instances that have been added.
+	Added []DNSRecord `yaml:"added,omitempty"`
+
+	// The records of the instances that have been deleted.
+	Removed []DNSRecord `yaml:"removed,omitempty"`
+}
+
+// EventDNSRecordsUpdated represents the unmarshalled version of the
+// contents of an SSNTP ssntp.DNSRecordsUpdated event payload.
+type EventDNSRecordsUpdated struct {
+	DNSRecords DNSRecordsEvent `yaml:"dns_records_updated"`
+}
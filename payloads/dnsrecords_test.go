@@ -0,0 +1,78 @@
+/*
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package payloads_test
+
+import (
+	"testing"
+
+	. "github.com/01org/ciao/payloads"
+	"github.com/01org/ciao/testutil"
+	"gopkg.in/yaml.v2"
+)
+
+func TestDNSRecordsUnmarshal(t *testing.T) {
+	var ev EventDNSRecordsUpdated
+
+	err := yaml.Unmarshal([]byte(testutil.DNSRecordsYaml), &ev)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ev.DNSRecords.ConcentratorUUID != testutil.CNCIUUID {
+		t.Errorf("Wrong concentrator UUID field [%s]", ev.DNSRecords.ConcentratorUUID)
+	}
+
+	if ev.DNSRecords.Domain != testutil.TenantDomain {
+		t.Errorf("Wrong domain field [%s]", ev.DNSRecords.Domain)
+	}
+
+	if len(ev.DNSRecords.Added) != 1 ||
+		ev.DNSRecords.Added[0].Name != testutil.InstanceUUID ||
+		ev.DNSRecords.Added[0].PrivateIP != testutil.InstancePrivateIP ||
+		ev.DNSRecords.Added[0].MACAddress != testutil.VNICMAC {
+		t.Errorf("Wrong added field %v", ev.DNSRecords.Added)
+	}
+
+	if len(ev.DNSRecords.Removed) != 0 {
+		t.Errorf("Wrong removed field %v", ev.DNSRecords.Removed)
+	}
+}
+
+func TestDNSRecordsMarshal(t *testing.T) {
+	var ev EventDNSRecordsUpdated
+
+	ev.DNSRecords.ConcentratorUUID = testutil.CNCIUUID
+	ev.DNSRecords.TenantUUID = testutil.TenantUUID
+	ev.DNSRecords.Domain = testutil.TenantDomain
+	ev.DNSRecords.Added = []DNSRecord{
+		{
+			InstanceUUID: testutil.InstanceUUID,
+			Name:         testutil.InstanceUUID,
+			PrivateIP:    testutil.InstancePrivateIP,
+			MACAddress:   testutil.VNICMAC,
+		},
+	}
+
+	y, err := yaml.Marshal(&ev)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(y) != testutil.DNSRecordsYaml {
+		t.Errorf("DNSRecordsUpdated marshalling failed\n[%s]\n vs\n[%s]", string(y), testutil.DNSRecordsYaml)
+	}
+}
@@ -0,0 +1,58 @@
+/*
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package payloads
+
+// PortForward forwards a port of a public IP shared by several instances to
+// a port of one of them.
+type PortForward struct {
+	// The protocol of the forwarded traffic, tcp or udp.
+	Protocol string `yaml:"protocol"`
+
+	// The public IP the traffic is sent to.
+	PublicIP string `yaml:"public_ip"`
+
+	// The port of the public IP.
+	PublicPort int `yaml:"public_port"`
+
+	// The private IP of the instance the traffic is forwarded to.
+	PrivateIP string `yaml:"private_ip"`
+
+	// The port of the instance.
+	PrivatePort int `yaml:"private_port"`
+}
+
+// PortForwardsEvent is populated by the controller whenever a port forward
+// of a tenant is added or removed.  It is sent to the CNCI of the tenant, via
+// the scheduler.  The event always carries all the port forwards of the
+// tenant, the CNCI assigns the public IPs they use and drops the forwards
+// and public IPs that are no longer listed.
+type PortForwardsEvent struct {
+	// The UUID of the concentrator the event is destined to.
+	ConcentratorUUID string `yaml:"concentrator_uuid"`
+
+	// The UUID of the tenant.
+	TenantUUID string `yaml:"tenant_uuid"`
+
+	// The port forwards of the tenant.
+	Forwards []PortForward `yaml:"forwards,omitempty"`
+}
+
+// EventPortForwardsUpdated represents the unmarshalled version of the
+// contents of an SSNTP ssntp.PortForwardsUpdated event payload.
+type EventPortForwardsUpdated struct {
+	PortForwards PortForwardsEvent `yaml:"port_forwards_updated"`
+}
@@ -0,0 +1,79 @@
+/*
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package payloads_test
+
+import (
+	"testing"
+
+	. "github.com/01org/ciao/payloads"
+	"github.com/01org/ciao/testutil"
+	"gopkg.in/yaml.v2"
+)
+
+func TestPortForwardsUnmarshal(t *testing.T) {
+	var ev EventPortForwardsUpdated
+
+	err := yaml.Unmarshal([]byte(testutil.PortForwardsYaml), &ev)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ev.PortForwards.ConcentratorUUID != testutil.CNCIUUID {
+		t.Errorf("Wrong concentrator UUID field [%s]", ev.PortForwards.ConcentratorUUID)
+	}
+
+	if ev.PortForwards.TenantUUID != testutil.TenantUUID {
+		t.Errorf("Wrong tenant UUID field [%s]", ev.PortForwards.TenantUUID)
+	}
+
+	if len(ev.PortForwards.Forwards) != 1 {
+		t.Fatalf("Wrong forwards field %v", ev.PortForwards.Forwards)
+	}
+
+	f := ev.PortForwards.Forwards[0]
+	if f.Protocol != "tcp" || f.PublicIP != testutil.InstancePublicIP ||
+		f.PublicPort != testutil.ForwardedPublicPort ||
+		f.PrivateIP != testutil.InstancePrivateIP ||
+		f.PrivatePort != testutil.ForwardedPrivatePort {
+		t.Errorf("Wrong forward %v", f)
+	}
+}
+
+func TestPortForwardsMarshal(t *testing.T) {
+	var ev EventPortForwardsUpdated
+
+	ev.PortForwards.ConcentratorUUID = testutil.CNCIUUID
+	ev.PortForwards.TenantUUID = testutil.TenantUUID
+	ev.PortForwards.Forwards = []PortForward{
+		{
+			Protocol:    "tcp",
+			PublicIP:    testutil.InstancePublicIP,
+			PublicPort:  testutil.ForwardedPublicPort,
+			PrivateIP:   testutil.InstancePrivateIP,
+			PrivatePort: testutil.ForwardedPrivatePort,
+		},
+	}
+
+	y, err := yaml.Marshal(&ev)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(y) != testutil.PortForwardsYaml {
+		t.Errorf("PortForwardsUpdated marshalling failed\n[%s]\n vs\n[%s]", string(y), testutil.PortForwardsYaml)
+	}
+}
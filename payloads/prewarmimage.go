@@ -0,0 +1,35 @@
+/*
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package payloads
+
+// PrewarmImageCmd contains the information needed to fetch a backing
+// image into the image cache of a node.
+type PrewarmImageCmd struct {
+	// DockerImage is the name of the docker image to fetch.
+	DockerImage string `yaml:"docker_image"`
+
+	// WorkloadAgentUUID identifies the node that should fetch the image.
+	// This information is needed by the scheduler to route the command
+	// to the correct CN.
+	WorkloadAgentUUID string `yaml:"workload_agent_uuid"`
+}
+
+// PrewarmImage represents the unmarshalled version of the contents of a
+// SSNTP PrewarmImage payload.
+type PrewarmImage struct {
+	Prewarm PrewarmImageCmd `yaml:"prewarm_image"`
+}
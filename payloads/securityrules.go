@@ -0,0 +1,62 @@
+/*
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package payloads
+
+// SecurityRule describes ingress traffic that is allowed to reach an
+// instance.
+type SecurityRule struct {
+	// Protocol is one of tcp, udp or icmp. An empty protocol matches
+	// all protocols.
+	Protocol string `yaml:"protocol,omitempty"`
+
+	// PortMin and PortMax define the range of destination ports of
+	// tcp and udp traffic. All ports match if both are 0.
+	PortMin int `yaml:"port_range_min,omitempty"`
+	PortMax int `yaml:"port_range_max,omitempty"`
+
+	// RemoteCIDR restricts the rule to traffic coming from a subnet.
+	// An empty RemoteCIDR matches all sources.
+	RemoteCIDR string `yaml:"remote_cidr,omitempty"`
+}
+
+// SecurityRulesCommand contains the security rules that the CNCI of
+// a tenant must enforce for one of the tenant's instances. The compute
+// node running the instance, if any, enforces them as well since the
+// traffic between instances of a subnet running on the same node never
+// reaches the CNCI.
+type SecurityRulesCommand struct {
+	ConcentratorUUID  string         `yaml:"concentrator_uuid"`
+	WorkloadAgentUUID string         `yaml:"workload_agent_uuid,omitempty"`
+	TenantUUID        string         `yaml:"tenant_uuid"`
+	InstanceUUID      string         `yaml:"instance_uuid"`
+	PrivateIP         string         `yaml:"private_ip"`
+	PrivateIPv6       string         `yaml:"private_ipv6,omitempty"`
+	Rules             []SecurityRule `yaml:"rules,omitempty"`
+}
+
+// CommandApplySecurityRules is a wrapper around SecurityRulesCommand.
+// It is the ApplySecurityRules command payload. The rules replace any
+// rules previously applied to the instance.
+type CommandApplySecurityRules struct {
+	Apply SecurityRulesCommand `yaml:"apply_security_rules"`
+}
+
+// CommandRemoveSecurityRules is a wrapper around SecurityRulesCommand.
+// It is the RemoveSecurityRules command payload.
+type CommandRemoveSecurityRules struct {
+	Remove SecurityRulesCommand `yaml:"remove_security_rules"`
+}
@@ -0,0 +1,139 @@
+/*
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package payloads_test
+
+import (
+	"reflect"
+	"testing"
+
+	. "github.com/01org/ciao/payloads"
+	"github.com/01org/ciao/testutil"
+	"gopkg.in/yaml.v2"
+)
+
+var testSecurityRules = []SecurityRule{
+	{
+		Protocol: "tcp",
+		PortMin:  22,
+		PortMax:  22,
+	},
+	{
+		Protocol:   "icmp",
+		RemoteCIDR: "192.168.1.0/24",
+	},
+}
+
+func TestApplySecurityRulesUnmarshal(t *testing.T) {
+	var cmd CommandApplySecurityRules
+
+	err := yaml.Unmarshal([]byte(testutil.ApplySecurityRulesYaml), &cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cmd.Apply.ConcentratorUUID != testutil.CNCIUUID {
+		t.Errorf("Wrong concentrator UUID field [%s]", cmd.Apply.ConcentratorUUID)
+	}
+
+	if cmd.Apply.WorkloadAgentUUID != testutil.AgentUUID {
+		t.Errorf("Wrong workload agent UUID field [%s]", cmd.Apply.WorkloadAgentUUID)
+	}
+
+	if cmd.Apply.TenantUUID != testutil.TenantUUID {
+		t.Errorf("Wrong tenant UUID field [%s]", cmd.Apply.TenantUUID)
+	}
+
+	if cmd.Apply.InstanceUUID != testutil.InstanceUUID {
+		t.Errorf("Wrong instance UUID field [%s]", cmd.Apply.InstanceUUID)
+	}
+
+	if cmd.Apply.PrivateIP != testutil.InstancePrivateIP {
+		t.Errorf("Wrong private IP field [%s]", cmd.Apply.PrivateIP)
+	}
+
+	if !reflect.DeepEqual(cmd.Apply.Rules, testSecurityRules) {
+		t.Errorf("Wrong rules field %+v", cmd.Apply.Rules)
+	}
+}
+
+func TestApplySecurityRulesMarshal(t *testing.T) {
+	var cmd CommandApplySecurityRules
+
+	cmd.Apply.ConcentratorUUID = testutil.CNCIUUID
+	cmd.Apply.WorkloadAgentUUID = testutil.AgentUUID
+	cmd.Apply.TenantUUID = testutil.TenantUUID
+	cmd.Apply.InstanceUUID = testutil.InstanceUUID
+	cmd.Apply.PrivateIP = testutil.InstancePrivateIP
+	cmd.Apply.Rules = testSecurityRules
+
+	y, err := yaml.Marshal(&cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(y) != testutil.ApplySecurityRulesYaml {
+		t.Errorf("ApplySecurityRules marshalling failed\n[%s]\n vs\n[%s]", string(y), testutil.ApplySecurityRulesYaml)
+	}
+}
+
+func TestRemoveSecurityRulesUnmarshal(t *testing.T) {
+	var cmd CommandRemoveSecurityRules
+
+	err := yaml.Unmarshal([]byte(testutil.RemoveSecurityRulesYaml), &cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cmd.Remove.ConcentratorUUID != testutil.CNCIUUID {
+		t.Errorf("Wrong concentrator UUID field [%s]", cmd.Remove.ConcentratorUUID)
+	}
+
+	if cmd.Remove.WorkloadAgentUUID != testutil.AgentUUID {
+		t.Errorf("Wrong workload agent UUID field [%s]", cmd.Remove.WorkloadAgentUUID)
+	}
+
+	if cmd.Remove.InstanceUUID != testutil.InstanceUUID {
+		t.Errorf("Wrong instance UUID field [%s]", cmd.Remove.InstanceUUID)
+	}
+
+	if cmd.Remove.PrivateIP != testutil.InstancePrivateIP {
+		t.Errorf("Wrong private IP field [%s]", cmd.Remove.PrivateIP)
+	}
+
+	if len(cmd.Remove.Rules) != 0 {
+		t.Errorf("Unexpected rules %+v", cmd.Remove.Rules)
+	}
+}
+
+func TestRemoveSecurityRulesMarshal(t *testing.T) {
+	var cmd CommandRemoveSecurityRules
+
+	cmd.Remove.ConcentratorUUID = testutil.CNCIUUID
+	cmd.Remove.WorkloadAgentUUID = testutil.AgentUUID
+	cmd.Remove.TenantUUID = testutil.TenantUUID
+	cmd.Remove.InstanceUUID = testutil.InstanceUUID
+	cmd.Remove.PrivateIP = testutil.InstancePrivateIP
+
+	y, err := yaml.Marshal(&cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(y) != testutil.RemoveSecurityRulesYaml {
+		t.Errorf("RemoveSecurityRules marshalling failed\n[%s]\n vs\n[%s]", string(y), testutil.RemoveSecurityRulesYaml)
+	}
+}
@@ -133,6 +133,28 @@ type StorageResource struct {
 
 	// Size is the requested size for an auto-created storage resource
 	Size int `yaml:"size,omitempty"`
+
+	// ReadOnly indicates that the storage resource must be exposed to the
+	// instance as a read-only device, e.g., a volume shared between
+	// several instances
+	ReadOnly bool `yaml:"read_only,omitempty"`
+
+	// IOLimits optionally contains the I/O throttling settings for the
+	// storage resource
+	IOLimits *IOLimits `yaml:"io_limits,omitempty"`
+
+	// EncryptionKey is the base64 encoded LUKS secret of an encrypted
+	// volume.  It is empty for volumes that are not encrypted.
+	EncryptionKey string `yaml:"encryption_key,omitempty"`
+
+	// ImageUUID is the UUID of the image a new volume was cloned from.
+	// It is only set when the image needs to be verified before the
+	// instance first boots from the volume.
+	ImageUUID string `yaml:"image_uuid,omitempty"`
+
+	// ImageSHA256 is the hex encoded SHA-256 digest that the image
+	// identified by ImageUUID must match.
+	ImageSHA256 string `yaml:"image_sha256,omitempty"`
 }
 
 // RequestedResource is used to specify an individual resource contained within
@@ -183,7 +205,8 @@ type NetworkResources struct {
 	Subnet string `yaml:"subnet"`
 
 	// SubnetKey is the subnet identifier to which the instance
-	// is assigned.
+	// is assigned.  The controller allocates a key, between 1 and
+	// 2^24 - 1, to each tenant subnet of the cluster.
 	SubnetKey string `yaml:"subnet_key"`
 
 	// SubnetUUID is the subnet ID of the subnet to which the instance is
@@ -194,6 +217,23 @@ type NetworkResources struct {
 	// specified when creating CN instances.
 	PrivateIP string `yaml:"private_ip"`
 
+	// SubnetV6 is the IPv6 /64 subnet of a dual stack tenant subnet.
+	// It is empty when the tenant subnet is IPv4 only.
+	SubnetV6 string `yaml:"subnet_v6,omitempty"`
+
+	// PrivateIPv6 is the IPv6 address the instance configures on its
+	// VNIC through SLAAC.  Only specified along with SubnetV6.
+	PrivateIPv6 string `yaml:"private_ipv6,omitempty"`
+
+	// Gateway is the address of the default gateway of a tenant defined
+	// subnet.  It is empty for subnets allocated by the controller, whose
+	// gateway is the first address of the subnet.
+	Gateway string `yaml:"gateway,omitempty"`
+
+	// NetworkUUID is the UUID of the tenant defined network the subnet
+	// belongs to.  It is empty for subnets allocated by the controller.
+	NetworkUUID string `yaml:"network_uuid,omitempty"`
+
 	// PublicIP represents the current statu of the assignation of a Public
 	// IP.
 	PublicIP bool `yaml:"public_ip"`
@@ -239,6 +279,12 @@ type StartCmd struct {
 	// for the new instance.
 	Networking NetworkResources `yaml:"networking"`
 
+	// ExtraNetworking contains the networking information of the
+	// additional VNICs of an instance attached to several tenant networks,
+	// in the order they should appear in the instance.  Networking always
+	// describes the first VNIC.
+	ExtraNetworking []NetworkResources `yaml:"extra_networking,omitempty"`
+
 	// Storage contains all the information required to attach or boot
 	// from storage for the new instance.
 	Storage []StorageResource `yaml:"storage,omitempty"`
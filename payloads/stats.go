@@ -61,6 +61,38 @@ type NetworkStat struct {
 	NodeMAC string `yaml:"mac"`
 }
 
+// CachedImageStat contains information about a single backing image held
+// in the image cache of a ciao compute node.
+type CachedImageStat struct {
+	// Name of the image, e.g., the docker image name.
+	Image string `yaml:"image"`
+
+	// Size of the image in MB.
+	SizeMB int `yaml:"size_mb"`
+
+	// Number of instances on the node that use the image.  Images that
+	// are not used by any instance may be evicted from the cache.
+	Instances int `yaml:"instances"`
+
+	// Time at which the image was last used by an instance or fetched,
+	// in RFC3339 format.
+	LastUsed string `yaml:"last_used"`
+}
+
+// ImageCacheStat contains information about the backing images cached on
+// a ciao compute node.
+type ImageCacheStat struct {
+	// Total size in MB of the cached images.
+	SizeMB int `yaml:"size_mb"`
+
+	// Size in MB above which unused images are evicted from the cache.
+	// 0 means that the cache is not limited.
+	BudgetMB int `yaml:"budget_mb"`
+
+	// Array containing one entry for each cached image.
+	Images []CachedImageStat `yaml:"images"`
+}
+
 // Stat represents a snapshot of the state of a compute or a network node.  This
 // information is sent periodically by ciao-launcher to the scheduler.
 type Stat struct {
@@ -102,6 +134,10 @@ type Stat struct {
 	// Array containing statistics information for each instance hosted by
 	// the CN/NN
 	Instances []InstanceStat
+
+	// Usage of the image cache of the CN.  Not reported by nodes that do
+	// not cache images.
+	ImageCache *ImageCacheStat `yaml:"image_cache,omitempty"`
 }
 
 const (
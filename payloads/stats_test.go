@@ -127,3 +127,41 @@ func TestStatsNodeNotAllStats(t *testing.T) {
 		t.Error("Unexpected values in Stat")
 	}
 }
+
+func TestStatsImageCache(t *testing.T) {
+	var cmd Stat
+	err := yaml.Unmarshal([]byte(testutil.ImageCacheStatsYaml), &cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := cmd.ImageCache
+	if c == nil || c.SizeMB != 250 || c.BudgetMB != 1024 || len(c.Images) != 1 {
+		t.Fatalf("Unexpected image cache stats %+v", c)
+	}
+
+	expected := CachedImageStat{
+		Image:     testutil.DockerImage,
+		SizeMB:    250,
+		Instances: 1,
+		LastUsed:  "2017-03-21T10:14:05Z",
+	}
+	if c.Images[0] != expected {
+		t.Errorf("Unexpected cached image stats %+v", c.Images[0])
+	}
+
+	y, err := yaml.Marshal(&cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cmd2 Stat
+	err = yaml.Unmarshal(y, &cmd2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cmd2.ImageCache == nil || cmd2.ImageCache.Images[0] != expected {
+		t.Errorf("Image cache stats not preserved\n%s", string(y))
+	}
+}
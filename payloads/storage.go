@@ -16,6 +16,35 @@
 
 package payloads
 
+// IOLimits contains the I/O throttling settings of a volume.  A value of 0
+// indicates that the corresponding limit is not set.
+type IOLimits struct {
+	// TotalBytesSec is the total (read + write) throughput limit in bytes
+	// per second.
+	TotalBytesSec int64 `yaml:"total_bytes_sec,omitempty"`
+
+	// ReadBytesSec is the read throughput limit in bytes per second.
+	ReadBytesSec int64 `yaml:"read_bytes_sec,omitempty"`
+
+	// WriteBytesSec is the write throughput limit in bytes per second.
+	WriteBytesSec int64 `yaml:"write_bytes_sec,omitempty"`
+
+	// TotalIOPSSec is the total (read + write) I/O operations per second
+	// limit.
+	TotalIOPSSec int64 `yaml:"total_iops_sec,omitempty"`
+
+	// ReadIOPSSec is the read I/O operations per second limit.
+	ReadIOPSSec int64 `yaml:"read_iops_sec,omitempty"`
+
+	// WriteIOPSSec is the write I/O operations per second limit.
+	WriteIOPSSec int64 `yaml:"write_iops_sec,omitempty"`
+}
+
+// IsZero returns true if none of the limits are set.
+func (l IOLimits) IsZero() bool {
+	return l == IOLimits{}
+}
+
 // VolumeCmd contains all the information needed to attach a volume
 // to or detach a volume from an existing instance.
 type VolumeCmd struct {
@@ -30,6 +59,19 @@ type VolumeCmd struct {
 	// running.  This information is needed by the scheduler to route
 	// the command to the correct CN/NN.
 	WorkloadAgentUUID string `yaml:"workload_agent_uuid"`
+
+	// IOLimits optionally contains the I/O throttling settings to apply
+	// to the volume.
+	IOLimits *IOLimits `yaml:"io_limits,omitempty"`
+
+	// ReadOnly indicates that the volume must be attached read-only.
+	// This is used for volumes that are attached to several instances
+	// at once.
+	ReadOnly bool `yaml:"read_only,omitempty"`
+
+	// EncryptionKey is the base64 encoded LUKS secret needed to open
+	// an encrypted volume.
+	EncryptionKey string `yaml:"encryption_key,omitempty"`
 }
 
 // AttachVolume represents the unmarshalled version of the contents of a SSNTP
@@ -45,3 +87,10 @@ type AttachVolume struct {
 type DetachVolume struct {
 	Detach VolumeCmd `yaml:"detach_volume"`
 }
+
+// UpdateVolume represents the unmarshalled version of the contents of a SSNTP
+// UpdateVolume payload.  The structure contains enough information to update
+// the settings of a volume attached to an existing instance.
+type UpdateVolume struct {
+	Update VolumeCmd `yaml:"update_volume"`
+}
@@ -44,6 +44,34 @@ func TestAttachVolumeUnmarshal(t *testing.T) {
 	}
 }
 
+func TestAttachVolumeReadOnlyUnmarshal(t *testing.T) {
+	var attach AttachVolume
+	err := yaml.Unmarshal([]byte(testutil.AttachVolumeReadOnlyYaml), &attach)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if attach.Attach.VolumeUUID != testutil.VolumeUUID {
+		t.Errorf("Wrong Volume UUID field [%s]", attach.Attach.VolumeUUID)
+	}
+
+	if !attach.Attach.ReadOnly {
+		t.Errorf("ReadOnly field not set")
+	}
+}
+
+func TestAttachVolumeEncryptedUnmarshal(t *testing.T) {
+	var attach AttachVolume
+	err := yaml.Unmarshal([]byte(testutil.AttachVolumeEncryptedYaml), &attach)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if attach.Attach.EncryptionKey != testutil.VolumeEncryptionKey {
+		t.Errorf("Wrong EncryptionKey field [%s]", attach.Attach.EncryptionKey)
+	}
+}
+
 func TestDetachVolumeUnmarshal(t *testing.T) {
 	var detach DetachVolume
 	err := yaml.Unmarshal([]byte(testutil.DetachVolumeYaml), &detach)
@@ -77,6 +105,42 @@ func TestAttachVolmeMarshal(t *testing.T) {
 	}
 }
 
+func TestAttachVolumeReadOnlyMarshal(t *testing.T) {
+	var attach AttachVolume
+	attach.Attach.InstanceUUID = testutil.InstanceUUID
+	attach.Attach.VolumeUUID = testutil.VolumeUUID
+	attach.Attach.WorkloadAgentUUID = testutil.AgentUUID
+	attach.Attach.ReadOnly = true
+
+	y, err := yaml.Marshal(&attach)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if string(y) != testutil.AttachVolumeReadOnlyYaml {
+		t.Errorf("AttachVolume marshalling failed\n[%s]\n vs\n[%s]",
+			string(y), testutil.AttachVolumeReadOnlyYaml)
+	}
+}
+
+func TestAttachVolumeEncryptedMarshal(t *testing.T) {
+	var attach AttachVolume
+	attach.Attach.InstanceUUID = testutil.InstanceUUID
+	attach.Attach.VolumeUUID = testutil.VolumeUUID
+	attach.Attach.WorkloadAgentUUID = testutil.AgentUUID
+	attach.Attach.EncryptionKey = testutil.VolumeEncryptionKey
+
+	y, err := yaml.Marshal(&attach)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if string(y) != testutil.AttachVolumeEncryptedYaml {
+		t.Errorf("AttachVolume marshalling failed\n[%s]\n vs\n[%s]",
+			string(y), testutil.AttachVolumeEncryptedYaml)
+	}
+}
+
 func TestDetachVolmeMarshal(t *testing.T) {
 	var detach DetachVolume
 	detach.Detach.InstanceUUID = testutil.InstanceUUID
@@ -93,3 +157,49 @@ func TestDetachVolmeMarshal(t *testing.T) {
 			string(y), testutil.DetachVolumeYaml)
 	}
 }
+
+func TestUpdateVolumeUnmarshal(t *testing.T) {
+	var update UpdateVolume
+	err := yaml.Unmarshal([]byte(testutil.UpdateVolumeYaml), &update)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if update.Update.InstanceUUID != testutil.InstanceUUID {
+		t.Errorf("Wrong instance UUID field [%s]", update.Update.InstanceUUID)
+	}
+
+	if update.Update.VolumeUUID != testutil.VolumeUUID {
+		t.Errorf("Wrong Volume UUID field [%s]", update.Update.VolumeUUID)
+	}
+
+	if update.Update.IOLimits == nil {
+		t.Fatal("Missing IOLimits field")
+	}
+
+	if update.Update.IOLimits.TotalBytesSec != 10485760 ||
+		update.Update.IOLimits.TotalIOPSSec != 500 {
+		t.Errorf("Wrong IOLimits field [%+v]", *update.Update.IOLimits)
+	}
+}
+
+func TestUpdateVolumeMarshal(t *testing.T) {
+	var update UpdateVolume
+	update.Update.InstanceUUID = testutil.InstanceUUID
+	update.Update.VolumeUUID = testutil.VolumeUUID
+	update.Update.WorkloadAgentUUID = testutil.AgentUUID
+	update.Update.IOLimits = &IOLimits{
+		TotalBytesSec: 10485760,
+		TotalIOPSSec:  500,
+	}
+
+	y, err := yaml.Marshal(&update)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if string(y) != testutil.UpdateVolumeYaml {
+		t.Errorf("UpdateVolume marshalling failed\n[%s]\n vs\n[%s]",
+			string(y), testutil.UpdateVolumeYaml)
+	}
+}
@@ -33,6 +33,15 @@ type TenantAddedEvent struct {
 	// The subnet of the Tenant.
 	TenantSubnet string `yaml:"tenant_subnet"`
 
+	// The IPv6 subnet of a dual stack tenant subnet, if any.
+	TenantSubnetV6 string `yaml:"tenant_subnet_v6,omitempty"`
+
+	// The gateway of a tenant defined subnet, if any.
+	TenantGateway string `yaml:"tenant_gateway,omitempty"`
+
+	// The UUID of the tenant defined network the subnet belongs to, if any.
+	NetworkUUID string `yaml:"network_uuid,omitempty"`
+
 	// The UUID of the concentrator.
 	ConcentratorUUID string `yaml:"concentrator_uuid"`
 
@@ -41,6 +50,14 @@ type TenantAddedEvent struct {
 
 	// The UUID of the subnet.
 	SubnetKey int `yaml:"subnet_key"`
+
+	// The type of the tunnel linking the CN to the concentrator, vxlan
+	// or empty for GRE.
+	TunnelType string `yaml:"tunnel_type,omitempty"`
+
+	// The multicast group of a VXLAN tunnel, if any.  The CNs of a
+	// unicast VXLAN tunnel are sent their peers instead.
+	VxlanGroup string `yaml:"vxlan_group,omitempty"`
 }
 
 // EventTenantAdded represents the unmarshalled version of the contents of an
@@ -115,6 +115,57 @@ func TestTenantAddedMarshal(t *testing.T) {
 	}
 }
 
+func TestTenantAddedDualStack(t *testing.T) {
+	var tenantAdded EventTenantAdded
+
+	tenantAdded.TenantAdded.TenantUUID = testutil.TenantUUID
+	tenantAdded.TenantAdded.TenantSubnet = testutil.TenantSubnet
+	tenantAdded.TenantAdded.TenantSubnetV6 = testutil.TenantSubnetV6
+
+	y, err := yaml.Marshal(&tenantAdded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var added EventTenantAdded
+	err = yaml.Unmarshal(y, &added)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if added.TenantAdded.TenantSubnetV6 != testutil.TenantSubnetV6 {
+		t.Errorf("Wrong tenant IPv6 subnet field [%s]", added.TenantAdded.TenantSubnetV6)
+	}
+}
+
+func TestTenantAddedVxlan(t *testing.T) {
+	var tenantAdded EventTenantAdded
+
+	tenantAdded.TenantAdded.TenantUUID = testutil.TenantUUID
+	tenantAdded.TenantAdded.TenantSubnet = testutil.TenantSubnet
+	tenantAdded.TenantAdded.TunnelType = "vxlan"
+	tenantAdded.TenantAdded.VxlanGroup = "239.1.1.1"
+
+	y, err := yaml.Marshal(&tenantAdded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var added EventTenantAdded
+	err = yaml.Unmarshal(y, &added)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if added.TenantAdded.TunnelType != "vxlan" {
+		t.Errorf("Wrong tunnel type field [%s]", added.TenantAdded.TunnelType)
+	}
+
+	if added.TenantAdded.VxlanGroup != "239.1.1.1" {
+		t.Errorf("Wrong VXLAN group field [%s]", added.TenantAdded.VxlanGroup)
+	}
+}
+
 func TestTenantRemovedMarshal(t *testing.T) {
 	var tenantRemoved EventTenantRemoved
 
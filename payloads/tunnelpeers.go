@@ -0,0 +1,50 @@
+/*
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package payloads
+
+// TunnelPeersEvent is populated by a cnci-agent whenever the set of CNs
+// that are part of a unicast VXLAN tenant subnet changes.  It is sent to
+// each of those CNs, via the scheduler, so that ciao-launcher can update
+// the remote end points of its local tunnel.
+type TunnelPeersEvent struct {
+	// The UUID of the ciao-launcher the event is destined to.
+	AgentUUID string `yaml:"agent_uuid"`
+
+	// The UUID of the tenant.
+	TenantUUID string `yaml:"tenant_uuid"`
+
+	// The subnet of the Tenant.
+	TenantSubnet string `yaml:"tenant_subnet"`
+
+	// The UUID of the concentrator.
+	ConcentratorUUID string `yaml:"concentrator_uuid"`
+
+	// The IP address of the concentrator.
+	ConcentratorIP string `yaml:"concentrator_ip"`
+
+	// The UUID of the subnet.
+	SubnetKey int `yaml:"subnet_key"`
+
+	// The IP addresses of all the CNs on which the subnet is present.
+	Peers []string `yaml:"peers,omitempty"`
+}
+
+// EventTunnelPeersUpdated represents the unmarshalled version of the
+// contents of an SSNTP ssntp.TunnelPeersUpdated event payload.
+type EventTunnelPeersUpdated struct {
+	TunnelPeers TunnelPeersEvent `yaml:"tunnel_peers_updated"`
+}
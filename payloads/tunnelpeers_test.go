@@ -0,0 +1,73 @@
+/*
+// Copyright (c) 2017 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package payloads_test
+
+import (
+	"strconv"
+	"testing"
+
+	. "github.com/01org/ciao/payloads"
+	"github.com/01org/ciao/testutil"
+	"gopkg.in/yaml.v2"
+)
+
+func TestTunnelPeersUnmarshal(t *testing.T) {
+	var ev EventTunnelPeersUpdated
+
+	err := yaml.Unmarshal([]byte(testutil.TunnelPeersYaml), &ev)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ev.TunnelPeers.AgentUUID != testutil.AgentUUID {
+		t.Errorf("Wrong agent UUID field [%s]", ev.TunnelPeers.AgentUUID)
+	}
+
+	if ev.TunnelPeers.TenantSubnet != testutil.TenantSubnet {
+		t.Errorf("Wrong tenant subnet field [%s]", ev.TunnelPeers.TenantSubnet)
+	}
+
+	if ev.TunnelPeers.ConcentratorIP != testutil.CNCIIP {
+		t.Errorf("Wrong CNCI IP field [%s]", ev.TunnelPeers.ConcentratorIP)
+	}
+
+	if len(ev.TunnelPeers.Peers) != 1 || ev.TunnelPeers.Peers[0] != testutil.AgentIP {
+		t.Errorf("Wrong peers field %v", ev.TunnelPeers.Peers)
+	}
+}
+
+func TestTunnelPeersMarshal(t *testing.T) {
+	var ev EventTunnelPeersUpdated
+
+	ev.TunnelPeers.AgentUUID = testutil.AgentUUID
+	ev.TunnelPeers.TenantUUID = testutil.TenantUUID
+	ev.TunnelPeers.TenantSubnet = testutil.TenantSubnet
+	ev.TunnelPeers.ConcentratorUUID = testutil.CNCIUUID
+	ev.TunnelPeers.ConcentratorIP = testutil.CNCIIP
+	k, _ := strconv.Atoi(testutil.SubnetKey)
+	ev.TunnelPeers.SubnetKey = k
+	ev.TunnelPeers.Peers = []string{testutil.AgentIP}
+
+	y, err := yaml.Marshal(&ev)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(y) != testutil.TunnelPeersYaml {
+		t.Errorf("TunnelPeersUpdated marshalling failed\n[%s]\n vs\n[%s]", string(y), testutil.TunnelPeersYaml)
+	}
+}
@@ -0,0 +1,97 @@
+/*
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package payloads
+
+// UpdateVolumeFailureReason denotes the underlying error that prevented
+// an SSNTP UpdateVolume command from updating the settings of a volume
+// attached to an instance.
+type UpdateVolumeFailureReason string
+
+const (
+	// UpdateVolumeNoInstance indicates that a volume could not be updated
+	// as the instance does not exist on the node to which the UpdateVolume
+	// command was sent.
+	UpdateVolumeNoInstance UpdateVolumeFailureReason = "no_instance"
+
+	// UpdateVolumeInvalidPayload indicates that the payload of the SSNTP
+	// UpdateVolume command was corrupt and could not be unmarshalled.
+	UpdateVolumeInvalidPayload = "invalid_payload"
+
+	// UpdateVolumeInvalidData is returned by ciao-launcher if the contents
+	// of the UpdateVolume payload are incorrect, e.g., the instance_uuid
+	// is missing.
+	UpdateVolumeInvalidData = "invalid_data"
+
+	// UpdateVolumeUpdateFailure indicates that the attempt to update the
+	// settings of the volume failed.
+	UpdateVolumeUpdateFailure = "update_failure"
+
+	// UpdateVolumeNotAttached indicates that the volume is not attached
+	// to the instance.
+	UpdateVolumeNotAttached = "not_attached"
+
+	// UpdateVolumeStateFailure indicates that launcher was unable to
+	// update its internal state to register the new volume settings.
+	UpdateVolumeStateFailure = "state_failure"
+
+	// UpdateVolumeInstanceFailure indicates that the volume could not
+	// be updated as the instance has failed to start and is being
+	// deleted
+	UpdateVolumeInstanceFailure = "instance_failure"
+
+	// UpdateVolumeNotSupported indicates that the update volume command
+	// is not supported for the given workload type, e.g., a container.
+	UpdateVolumeNotSupported = "not_supported"
+)
+
+// ErrorUpdateVolumeFailure represents the unmarshalled version of the contents of a
+// SSNTP ERROR frame whose type is set to ssntp.UpdateVolumeFailure.
+type ErrorUpdateVolumeFailure struct {
+	// InstanceUUID is the UUID of the instance to which the volume is
+	// attached.
+	InstanceUUID string `yaml:"instance_uuid"`
+
+	// VolumeUUID is the UUID of the volume that could not be updated.
+	VolumeUUID string `yaml:"volume_uuid"`
+
+	// Reason provides the reason for the update failure, e.g.,
+	// UpdateVolumeNoInstance.
+	Reason UpdateVolumeFailureReason `yaml:"reason"`
+}
+
+func (r UpdateVolumeFailureReason) String() string {
+	switch r {
+	case UpdateVolumeNoInstance:
+		return "Instance does not exist"
+	case UpdateVolumeInvalidPayload:
+		return "YAML payload is corrupt"
+	case UpdateVolumeInvalidData:
+		return "Command section of YAML payload is corrupt or missing required information"
+	case UpdateVolumeUpdateFailure:
+		return "Failed to update volume"
+	case UpdateVolumeNotAttached:
+		return "Volume not attached"
+	case UpdateVolumeStateFailure:
+		return "State failure"
+	case UpdateVolumeInstanceFailure:
+		return "Instance failure"
+	case UpdateVolumeNotSupported:
+		return "Not Supported"
+	}
+
+	return ""
+}
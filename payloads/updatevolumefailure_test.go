@@ -0,0 +1,89 @@
+/*
+// Copyright (c) 2016 Intel Corporation
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+*/
+
+package payloads_test
+
+import (
+	"testing"
+
+	. "github.com/01org/ciao/payloads"
+	"github.com/01org/ciao/testutil"
+	"gopkg.in/yaml.v2"
+)
+
+func TestUpdateVolumeFailureUnmarshal(t *testing.T) {
+	var error ErrorUpdateVolumeFailure
+	err := yaml.Unmarshal([]byte(testutil.UpdateVolumeFailureYaml), &error)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if error.InstanceUUID != testutil.InstanceUUID {
+		t.Error("Wrong UUID field")
+	}
+
+	if error.VolumeUUID != testutil.VolumeUUID {
+		t.Error("Wrong UUID field")
+	}
+
+	if error.Reason != UpdateVolumeUpdateFailure {
+		t.Error("Wrong Error field")
+	}
+}
+
+func TestUpdateVolumeFailureMarshal(t *testing.T) {
+	error := ErrorUpdateVolumeFailure{
+		InstanceUUID: testutil.InstanceUUID,
+		VolumeUUID:   testutil.VolumeUUID,
+		Reason:       UpdateVolumeUpdateFailure,
+	}
+
+	y, err := yaml.Marshal(&error)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if string(y) != testutil.UpdateVolumeFailureYaml {
+		t.Errorf("UpdateVolumeFailure marshalling failed\n[%s]\n vs\n[%s]",
+			string(y), testutil.UpdateVolumeFailureYaml)
+	}
+}
+
+func TestUpdateVolumeFailureString(t *testing.T) {
+	var stringTests = []struct {
+		r        UpdateVolumeFailureReason
+		expected string
+	}{
+		{UpdateVolumeNoInstance, "Instance does not exist"},
+		{UpdateVolumeInvalidPayload, "YAML payload is corrupt"},
+		{UpdateVolumeInvalidData, "Command section of YAML payload is corrupt or missing required information"},
+		{UpdateVolumeUpdateFailure, "Failed to update volume"},
+		{UpdateVolumeNotAttached, "Volume not attached"},
+		{UpdateVolumeStateFailure, "State failure"},
+		{UpdateVolumeInstanceFailure, "Instance failure"},
+		{UpdateVolumeNotSupported, "Not Supported"},
+	}
+	error := ErrorUpdateVolumeFailure{
+		InstanceUUID: testutil.InstanceUUID,
+	}
+	for _, test := range stringTests {
+		error.Reason = test.r
+		s := error.Reason.String()
+		if s != test.expected {
+			t.Errorf("expected \"%s\", got \"%s\"", test.expected, s)
+		}
+	}
+}
@@ -603,19 +603,72 @@ func (q *QMP) ExecuteQuit(ctx context.Context) error {
 // used to name the device.  As this identifier will be passed directly to QMP,
 // it must obey QMP's naming rules, e,g., it must start with a letter.
 func (q *QMP) ExecuteBlockdevAdd(ctx context.Context, device, blockdevID string) error {
-	args := map[string]interface{}{
-		"options": map[string]interface{}{
-			"driver": "raw",
-			"file": map[string]interface{}{
-				"driver":   "file",
-				"filename": device,
-			},
-			"id": blockdevID,
+	return q.executeBlockdevAdd(ctx, device, blockdevID, false, "")
+}
+
+// ExecuteBlockdevAddReadOnly is identical to ExecuteBlockdevAdd except that
+// the block device is opened read-only.  Any attempt by the guest to write
+// to the device will fail.
+func (q *QMP) ExecuteBlockdevAddReadOnly(ctx context.Context, device, blockdevID string) error {
+	return q.executeBlockdevAdd(ctx, device, blockdevID, true, "")
+}
+
+// ExecuteBlockdevAddLUKS adds a LUKS encrypted block device to a QEMU
+// instance.  The device is opened with QEMU's luks block driver which
+// decrypts it using the secret object identified by secretID.  The secret
+// must have been created by an earlier call to ExecuteObjectAddSecret.
+func (q *QMP) ExecuteBlockdevAddLUKS(ctx context.Context, device, blockdevID, secretID string, readOnly bool) error {
+	return q.executeBlockdevAdd(ctx, device, blockdevID, readOnly, secretID)
+}
+
+func (q *QMP) executeBlockdevAdd(ctx context.Context, device, blockdevID string, readOnly bool, secretID string) error {
+	options := map[string]interface{}{
+		"driver": "raw",
+		"file": map[string]interface{}{
+			"driver":   "file",
+			"filename": device,
 		},
+		"id": blockdevID,
+	}
+	if secretID != "" {
+		options["driver"] = "luks"
+		options["key-secret"] = secretID
+	}
+	if readOnly {
+		options["read-only"] = true
+	}
+	args := map[string]interface{}{
+		"options": options,
 	}
 	return q.executeCommand(ctx, "blockdev-add", args, nil)
 }
 
+// ExecuteObjectAddSecret creates a secret object in a QEMU instance using
+// the object-add command.  data is the base64 encoded value of the secret
+// and secretID is the identifier by which other objects and devices refer
+// to it.  secretID must be a valid QMP identifier.
+func (q *QMP) ExecuteObjectAddSecret(ctx context.Context, secretID, data string) error {
+	args := map[string]interface{}{
+		"qom-type": "secret",
+		"id":       secretID,
+		"props": map[string]interface{}{
+			"data":   data,
+			"format": "base64",
+		},
+	}
+	return q.executeCommand(ctx, "object-add", args, nil)
+}
+
+// ExecuteObjectDel deletes an object, such as a secret, from a QEMU
+// instance using the object-del command.  objectID must match the
+// identifier of an object previously created with object-add.
+func (q *QMP) ExecuteObjectDel(ctx context.Context, objectID string) error {
+	args := map[string]interface{}{
+		"id": objectID,
+	}
+	return q.executeCommand(ctx, "object-del", args, nil)
+}
+
 // ExecuteDeviceAdd adds the guest portion of a device to a QEMU instance
 // using the device_add command.  blockdevID should match the blockdevID passed
 // to a previous call to ExecuteBlockdevAdd.  devID is the id of the device to
@@ -633,6 +686,46 @@ func (q *QMP) ExecuteDeviceAdd(ctx context.Context, blockdevID, devID, driver, b
 	return q.executeCommand(ctx, "device_add", args, nil)
 }
 
+// BlockIOThrottle contains the I/O limits of a block device.  A limit of 0
+// indicates that the corresponding value is not throttled.
+type BlockIOThrottle struct {
+	// BPS is the total throughput limit in bytes per second.
+	BPS int64
+
+	// BPSRd is the read throughput limit in bytes per second.
+	BPSRd int64
+
+	// BPSWr is the write throughput limit in bytes per second.
+	BPSWr int64
+
+	// IOPS is the total I/O operations per second limit.
+	IOPS int64
+
+	// IOPSRd is the read I/O operations per second limit.
+	IOPSRd int64
+
+	// IOPSWr is the write I/O operations per second limit.
+	IOPSWr int64
+}
+
+// ExecuteBlockSetIOThrottle sets the I/O limits of a block device by sending
+// a block_set_io_throttle command.  blockdevID is the id of the block device
+// whose limits are to be changed.  Typically, this will match the id passed
+// to ExecuteBlockdevAdd or the id of a drive specified on the QEMU command
+// line.  Passing a zero valued limits removes all throttling from the device.
+func (q *QMP) ExecuteBlockSetIOThrottle(ctx context.Context, blockdevID string, limits BlockIOThrottle) error {
+	args := map[string]interface{}{
+		"device":  blockdevID,
+		"bps":     limits.BPS,
+		"bps_rd":  limits.BPSRd,
+		"bps_wr":  limits.BPSWr,
+		"iops":    limits.IOPS,
+		"iops_rd": limits.IOPSRd,
+		"iops_wr": limits.IOPSWr,
+	}
+	return q.executeCommand(ctx, "block_set_io_throttle", args, nil)
+}
+
 // ExecuteXBlockdevDel deletes a block device by sending a x-blockdev-del command.
 // blockdevID is the id of the block device to be deleted.  Typically, this will
 // match the id passed to ExecuteBlockdevAdd.  It must be a valid QMP id.
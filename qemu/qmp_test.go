@@ -368,6 +368,126 @@ func TestQMPBlockdevAdd(t *testing.T) {
 	<-disconnectedCh
 }
 
+// Checks that the read-only blockdev-add command is correctly sent.
+//
+// We start a QMPLoop, send the blockdev-add command with the read-only option
+// and stop the loop.
+//
+// The blockdev-add command should be correctly sent and the QMP loop should
+// exit gracefully.
+func TestQMPBlockdevAddReadOnly(t *testing.T) {
+	connectedCh := make(chan *QMPVersion)
+	disconnectedCh := make(chan struct{})
+	buf := newQMPTestCommandBuffer(t)
+	buf.AddCommand("blockdev-add", nil, "return", nil)
+	cfg := QMPConfig{Logger: qmpTestLogger{}}
+	q := startQMPLoop(buf, cfg, connectedCh, disconnectedCh)
+	checkVersion(t, connectedCh)
+	err := q.ExecuteBlockdevAddReadOnly(context.Background(), "/dev/rbd0",
+		fmt.Sprintf("drive_%s", testutil.VolumeUUID))
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	q.Shutdown()
+	<-disconnectedCh
+}
+
+// Checks that the blockdev-add command is correctly sent for a LUKS
+// encrypted device.
+//
+// We start a QMPLoop, send the blockdev-add command with the luks driver
+// and stop the loop.
+//
+// The blockdev-add command should be correctly sent and the QMP loop should
+// exit gracefully.
+func TestQMPBlockdevAddLUKS(t *testing.T) {
+	connectedCh := make(chan *QMPVersion)
+	disconnectedCh := make(chan struct{})
+	buf := newQMPTestCommandBuffer(t)
+	buf.AddCommand("blockdev-add", nil, "return", nil)
+	cfg := QMPConfig{Logger: qmpTestLogger{}}
+	q := startQMPLoop(buf, cfg, connectedCh, disconnectedCh)
+	checkVersion(t, connectedCh)
+	err := q.ExecuteBlockdevAddLUKS(context.Background(), "/dev/rbd0",
+		fmt.Sprintf("drive_%s", testutil.VolumeUUID),
+		fmt.Sprintf("secret_%s", testutil.VolumeUUID), false)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	q.Shutdown()
+	<-disconnectedCh
+}
+
+// Checks that the object-add command is correctly sent for a secret.
+//
+// We start a QMPLoop, send the object-add command and stop the loop.
+//
+// The object-add command should be correctly sent and the QMP loop should
+// exit gracefully.
+func TestQMPObjectAddSecret(t *testing.T) {
+	connectedCh := make(chan *QMPVersion)
+	disconnectedCh := make(chan struct{})
+	buf := newQMPTestCommandBuffer(t)
+	buf.AddCommand("object-add", nil, "return", nil)
+	cfg := QMPConfig{Logger: qmpTestLogger{}}
+	q := startQMPLoop(buf, cfg, connectedCh, disconnectedCh)
+	checkVersion(t, connectedCh)
+	err := q.ExecuteObjectAddSecret(context.Background(),
+		fmt.Sprintf("secret_%s", testutil.VolumeUUID), testutil.VolumeEncryptionKey)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	q.Shutdown()
+	<-disconnectedCh
+}
+
+// Checks that the object-del command is correctly sent.
+//
+// We start a QMPLoop, send the object-del command and stop the loop.
+//
+// The object-del command should be correctly sent and the QMP loop should
+// exit gracefully.
+func TestQMPObjectDel(t *testing.T) {
+	connectedCh := make(chan *QMPVersion)
+	disconnectedCh := make(chan struct{})
+	buf := newQMPTestCommandBuffer(t)
+	buf.AddCommand("object-del", nil, "return", nil)
+	cfg := QMPConfig{Logger: qmpTestLogger{}}
+	q := startQMPLoop(buf, cfg, connectedCh, disconnectedCh)
+	checkVersion(t, connectedCh)
+	err := q.ExecuteObjectDel(context.Background(),
+		fmt.Sprintf("secret_%s", testutil.VolumeUUID))
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	q.Shutdown()
+	<-disconnectedCh
+}
+
+// Checks that the block_set_io_throttle command is correctly sent.
+//
+// We start a QMPLoop, send the block_set_io_throttle command and stop the loop.
+//
+// The block_set_io_throttle command should be correctly sent and the QMP loop
+// should exit gracefully.
+func TestQMPBlockSetIOThrottle(t *testing.T) {
+	connectedCh := make(chan *QMPVersion)
+	disconnectedCh := make(chan struct{})
+	buf := newQMPTestCommandBuffer(t)
+	buf.AddCommand("block_set_io_throttle", nil, "return", nil)
+	cfg := QMPConfig{Logger: qmpTestLogger{}}
+	q := startQMPLoop(buf, cfg, connectedCh, disconnectedCh)
+	checkVersion(t, connectedCh)
+	err := q.ExecuteBlockSetIOThrottle(context.Background(),
+		fmt.Sprintf("drive_%s", testutil.VolumeUUID),
+		BlockIOThrottle{BPS: 10485760, IOPS: 500})
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	q.Shutdown()
+	<-disconnectedCh
+}
+
 // Checks that the device_add command is correctly sent.
 //
 // We start a QMPLoop, send the device_add command and stop the loop.
@@ -42,7 +42,8 @@ type Type uint8
 
 // Command is the SSNTP Command operand.
 // It can be CONNECT, START, STOP, STATS, EVACUATE, DELETE, RESTART,
-// AssignPublicIP, ReleasePublicIP, CONFIGURE, AttachVolume or DetachVolume.
+// AssignPublicIP, ReleasePublicIP, CONFIGURE, AttachVolume, DetachVolume,
+// UpdateVolume, PrewarmImage, ApplySecurityRules or RemoveSecurityRules.
 type Command uint8
 
 // Status is the SSNTP Status operand.
@@ -62,7 +63,8 @@ type Error uint8
 // Event is the SSNTP Event operand.
 // It can be TenantAdded, TenantRemoval, InstanceDeleted, InstanceStopped,
 // ConcentratorInstanceAdded, PublicIPAssigned, PublicIPUnassigned, TraceReport,
-// NodeConnected or NodeDisconnected
+// NodeConnected, NodeDisconnected, TunnelPeersUpdated, DNSRecordsUpdated,
+// DHCPOptionsUpdated or PortForwardsUpdated
 type Event uint8
 
 const (
@@ -253,6 +255,66 @@ const (
 	//	|       |       | (0x0) |  (0xb)  |                 |                         |
 	//	+-----------------------------------------------------------------------------+
 	DetachVolume
+
+	// UpdateVolume is a command sent to ciao-launcher for updating the
+	// settings, e.g., the I/O limits, of a storage volume attached to a
+	// specific running or paused instance.
+	//
+	// The UpdateVolume command payload includes a volume UUID, an instance UUID
+	// and the new volume settings.
+	//
+	//                                       SSNTP UpdateVolume Command frame
+	//	+-----------------------------------------------------------------------------+
+	//	| Major | Minor | Type  | Operand |  Payload Length | YAML formatted payload  |
+	//	|       |       | (0x0) |  (0xc)  |                 |                         |
+	//	+-----------------------------------------------------------------------------+
+	UpdateVolume
+
+	// PrewarmImage is a command sent to ciao-launcher for fetching a
+	// backing image into the node's image cache before any instance
+	// using it is started.
+	//
+	// The PrewarmImage command payload includes the image to fetch and
+	// the UUID of the node.
+	//
+	//                                       SSNTP PrewarmImage Command frame
+	//	+-----------------------------------------------------------------------------+
+	//	| Major | Minor | Type  | Operand |  Payload Length | YAML formatted payload  |
+	//	|       |       | (0x0) |  (0xd)  |                 |                         |
+	//	+-----------------------------------------------------------------------------+
+	PrewarmImage
+
+	// ApplySecurityRules is a command sent by the Controller to filter
+	// the ingress traffic of a given instance. It is sent to the
+	// Scheduler and must be forwarded to the right CNCI and to the
+	// compute node running the instance, if any.
+	//
+	// The ApplySecurityRules YAML payload schema is made of the
+	// CNCI, agent, tenant and instance UUIDs, the instance private IP and
+	// the full list of rules describing the traffic allowed to reach
+	// the instance. Any other ingress traffic is dropped.
+	//
+	//                                       SSNTP ApplySecurityRules Command frame
+	//	+-----------------------------------------------------------------------------+
+	//	| Major | Minor | Type  | Operand |  Payload Length | YAML formatted payload  |
+	//	|       |       | (0x0) |  (0xe)  |                 |                         |
+	//	+-----------------------------------------------------------------------------+
+	ApplySecurityRules
+
+	// RemoveSecurityRules is a command sent by the Controller to stop
+	// filtering the ingress traffic of a given instance. It is sent
+	// to the Scheduler and must be forwarded to the right CNCI and to
+	// the compute node running the instance, if any.
+	//
+	// The RemoveSecurityRules YAML payload uses the same schema as
+	// the ApplySecurityRules one, without any rule.
+	//
+	//                                       SSNTP RemoveSecurityRules Command frame
+	//	+-----------------------------------------------------------------------------+
+	//	| Major | Minor | Type  | Operand |  Payload Length | YAML formatted payload  |
+	//	|       |       | (0x0) |  (0xf)  |                 |                         |
+	//	+-----------------------------------------------------------------------------+
+	RemoveSecurityRules
 )
 
 const (
@@ -467,6 +529,73 @@ const (
 	//	|       |       | (0x3) |  (0x2)  |                 | instance information  |
 	//	+---------------------------------------------------------------------------+
 	InstanceStopped
+
+	// TunnelPeersUpdated is sent by networking concentrator instances (CNCI)
+	// to the compute nodes of a unicast VXLAN tenant subnet whenever a compute
+	// node joins or leaves the subnet. The Scheduler forwards it to the
+	// workload agent named in the payload.
+	//
+	// The TunnelPeersUpdated event payload contains the tenant subnet, the
+	// concentrator and the IP addresses of all the compute nodes the subnet
+	// spans.
+	//
+	//					 SSNTP TunnelPeersUpdated Event frame
+	//
+	//	+----------------------------------------------------------------------------+
+	//	| Major | Minor | Type  | Operand |  Payload Length | YAML formatted payload |
+	//	|       |       | (0x3) |  (0xa)  |                 |                        |
+	//	+----------------------------------------------------------------------------+
+	TunnelPeersUpdated
+
+	// DNSRecordsUpdated is sent by the Controller to the networking
+	// concentrator instance (CNCI) of a tenant whenever instances of the
+	// tenant are added or deleted. The Scheduler forwards it to the CNCI
+	// named in the payload.
+	//
+	// The DNSRecordsUpdated event payload contains the DNS domain of the
+	// tenant and the names and private IP addresses of the instances
+	// to add to or remove from it.
+	//
+	//					 SSNTP DNSRecordsUpdated Event frame
+	//
+	//	+----------------------------------------------------------------------------+
+	//	| Major | Minor | Type  | Operand |  Payload Length | YAML formatted payload |
+	//	|       |       | (0x3) |  (0xb)  |                 |                        |
+	//	+----------------------------------------------------------------------------+
+	DNSRecordsUpdated
+
+	// DHCPOptionsUpdated is sent by the Controller to the networking
+	// concentrator instance (CNCI) of a tenant whenever the DHCP options
+	// of a subnet of the tenant are set. The Scheduler forwards it to the
+	// CNCI named in the payload.
+	//
+	// The DHCPOptionsUpdated event payload contains the subnet and all
+	// the DHCP options handed out to its instances.
+	//
+	//					 SSNTP DHCPOptionsUpdated Event frame
+	//
+	//	+----------------------------------------------------------------------------+
+	//	| Major | Minor | Type  | Operand |  Payload Length | YAML formatted payload |
+	//	|       |       | (0x3) |  (0xc)  |                 |                        |
+	//	+----------------------------------------------------------------------------+
+	DHCPOptionsUpdated
+
+	// PortForwardsUpdated is sent by the Controller to the networking
+	// concentrator instance (CNCI) of a tenant whenever a port forward
+	// of the tenant is added or removed. The Scheduler forwards it to the
+	// CNCI named in the payload.
+	//
+	// The PortForwardsUpdated event payload contains all the port forwards
+	// of the tenant, from a shared public IP and port to a private IP and
+	// port.
+	//
+	//					 SSNTP PortForwardsUpdated Event frame
+	//
+	//	+----------------------------------------------------------------------------+
+	//	| Major | Minor | Type  | Operand |  Payload Length | YAML formatted payload |
+	//	|       |       | (0x3) |  (0xd)  |                 |                        |
+	//	+----------------------------------------------------------------------------+
+	PortForwardsUpdated
 )
 
 // SSNTP clients and servers can have one or several roles and are expected to declare their
@@ -562,6 +691,10 @@ const (
 	// UnassignPublicIPFailure is sent by the CNCI when a an external IP
 	// cannot be unassigned.
 	UnassignPublicIPFailure
+
+	// UpdateVolumeFailure is sent by launcher agents to report a failure to
+	// update the settings of a volume attached to an instance.
+	UpdateVolumeFailure
 )
 
 // Major is the SSNTP protocol major version
@@ -617,6 +750,14 @@ func (command Command) String() string {
 		return "Attach storage volume"
 	case DetachVolume:
 		return "Detach storage volume"
+	case UpdateVolume:
+		return "Update storage volume"
+	case PrewarmImage:
+		return "Prewarm image"
+	case ApplySecurityRules:
+		return "Apply security rules"
+	case RemoveSecurityRules:
+		return "Remove security rules"
 	}
 
 	return ""
@@ -661,6 +802,14 @@ func (status Event) String() string {
 		return "Node Connected"
 	case NodeDisconnected:
 		return "Node Disconnected"
+	case TunnelPeersUpdated:
+		return "Tunnel Peers Updated"
+	case DNSRecordsUpdated:
+		return "DNS Records Updated"
+	case DHCPOptionsUpdated:
+		return "DHCP Options Updated"
+	case PortForwardsUpdated:
+		return "Port Forwards Updated"
 	}
 
 	return ""
@@ -2483,6 +2483,10 @@ func TestCommandStringer(t *testing.T) {
 		{CONFIGURE, "CONFIGURE"},
 		{AttachVolume, "Attach storage volume"},
 		{DetachVolume, "Detach storage volume"},
+		{UpdateVolume, "Update storage volume"},
+		{PrewarmImage, "Prewarm image"},
+		{ApplySecurityRules, "Apply security rules"},
+		{RemoveSecurityRules, "Remove security rules"},
 	}
 
 	for _, test := range stringTests {
@@ -2526,6 +2530,10 @@ func TestEventStringer(t *testing.T) {
 		{TraceReport, "Trace Report"},
 		{NodeConnected, "Node Connected"},
 		{NodeDisconnected, "Node Disconnected"},
+		{TunnelPeersUpdated, "Tunnel Peers Updated"},
+		{DNSRecordsUpdated, "DNS Records Updated"},
+		{DHCPOptionsUpdated, "DHCP Options Updated"},
+		{PortForwardsUpdated, "Port Forwards Updated"},
 	}
 
 	for _, test := range stringTests {
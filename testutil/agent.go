@@ -504,6 +504,37 @@ func (client *SsntpTestClient) handleDetachVolume(payload []byte) Result {
 	return result
 }
 
+func (client *SsntpTestClient) handleUpdateVolume(payload []byte) Result {
+	var result Result
+	var cmd payloads.UpdateVolume
+
+	err := yaml.Unmarshal(payload, &cmd)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	result.InstanceUUID = cmd.Update.InstanceUUID
+	result.VolumeUUID = cmd.Update.VolumeUUID
+
+	return result
+}
+
+func (client *SsntpTestClient) handlePrewarmImage(payload []byte) Result {
+	var result Result
+	var cmd payloads.PrewarmImage
+
+	err := yaml.Unmarshal(payload, &cmd)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	result.NodeUUID = cmd.Prewarm.WorkloadAgentUUID
+
+	return result
+}
+
 // CommandNotify implements the SSNTP client CommandNotify callback for SsntpTestClient
 func (client *SsntpTestClient) CommandNotify(command ssntp.Command, frame *ssntp.Frame) {
 	payload := frame.Payload
@@ -544,6 +575,12 @@ func (client *SsntpTestClient) CommandNotify(command ssntp.Command, frame *ssntp
 	case ssntp.DetachVolume:
 		result = client.handleDetachVolume(payload)
 
+	case ssntp.UpdateVolume:
+		result = client.handleUpdateVolume(payload)
+
+	case ssntp.PrewarmImage:
+		result = client.handlePrewarmImage(payload)
+
 	default:
 		fmt.Fprintf(os.Stderr, "client %s unhandled command %s\n", client.Role.String(), command.String())
 	}
@@ -576,6 +613,34 @@ func (client *SsntpTestClient) EventNotify(event ssntp.Event, frame *ssntp.Frame
 		if err != nil {
 			result.Err = err
 		}
+	case ssntp.TunnelPeersUpdated:
+		var tunnelPeersEvent payloads.EventTunnelPeersUpdated
+
+		err := yaml.Unmarshal(frame.Payload, &tunnelPeersEvent)
+		if err != nil {
+			result.Err = err
+		}
+	case ssntp.DNSRecordsUpdated:
+		var dnsRecordsEvent payloads.EventDNSRecordsUpdated
+
+		err := yaml.Unmarshal(frame.Payload, &dnsRecordsEvent)
+		if err != nil {
+			result.Err = err
+		}
+	case ssntp.DHCPOptionsUpdated:
+		var dhcpOptionsEvent payloads.EventDHCPOptionsUpdated
+
+		err := yaml.Unmarshal(frame.Payload, &dhcpOptionsEvent)
+		if err != nil {
+			result.Err = err
+		}
+	case ssntp.PortForwardsUpdated:
+		var portForwardsEvent payloads.EventPortForwardsUpdated
+
+		err := yaml.Unmarshal(frame.Payload, &portForwardsEvent)
+		if err != nil {
+			result.Err = err
+		}
 	default:
 		fmt.Fprintf(os.Stderr, "client %s unhandled event: %s\n", client.Role.String(), event.String())
 	}
@@ -722,6 +787,18 @@ func (client *SsntpTestClient) SendTenantAddedEvent() {
 	go client.SendResultAndDelEventChan(ssntp.TenantAdded, result)
 }
 
+// SendTunnelPeersUpdatedEvent allows an SsntpTestClient to push an ssntp.TunnelPeersUpdated event frame
+func (client *SsntpTestClient) SendTunnelPeersUpdatedEvent() {
+	var result Result
+
+	_, err := client.Ssntp.SendEvent(ssntp.TunnelPeersUpdated, []byte(TunnelPeersYaml))
+	if err != nil {
+		result.Err = err
+	}
+
+	go client.SendResultAndDelEventChan(ssntp.TunnelPeersUpdated, result)
+}
+
 // SendTenantRemovedEvent allows an SsntpTestClient to push an ssntp.TenantRemoved event frame
 func (client *SsntpTestClient) SendTenantRemovedEvent() {
 	var result Result
@@ -197,6 +197,42 @@ func (ctl *SsntpTestController) SendResultAndDelErrorChan(error ssntp.Error, res
 	ctl.ErrorChansLock.Unlock()
 }
 
+// SendDNSRecordsUpdatedEvent allows an SsntpTestController to push an ssntp.DNSRecordsUpdated event frame
+func (ctl *SsntpTestController) SendDNSRecordsUpdatedEvent() {
+	var result Result
+
+	_, err := ctl.Ssntp.SendEvent(ssntp.DNSRecordsUpdated, []byte(DNSRecordsYaml))
+	if err != nil {
+		result.Err = err
+	}
+
+	go ctl.SendResultAndDelEventChan(ssntp.DNSRecordsUpdated, result)
+}
+
+// SendDHCPOptionsUpdatedEvent allows an SsntpTestController to push an ssntp.DHCPOptionsUpdated event frame
+func (ctl *SsntpTestController) SendDHCPOptionsUpdatedEvent() {
+	var result Result
+
+	_, err := ctl.Ssntp.SendEvent(ssntp.DHCPOptionsUpdated, []byte(DHCPOptionsYaml))
+	if err != nil {
+		result.Err = err
+	}
+
+	go ctl.SendResultAndDelEventChan(ssntp.DHCPOptionsUpdated, result)
+}
+
+// SendPortForwardsUpdatedEvent allows an SsntpTestController to push an ssntp.PortForwardsUpdated event frame
+func (ctl *SsntpTestController) SendPortForwardsUpdatedEvent() {
+	var result Result
+
+	_, err := ctl.Ssntp.SendEvent(ssntp.PortForwardsUpdated, []byte(PortForwardsYaml))
+	if err != nil {
+		result.Err = err
+	}
+
+	go ctl.SendResultAndDelEventChan(ssntp.PortForwardsUpdated, result)
+}
+
 func openControllerChans(ctl *SsntpTestController) {
 	ctl.CmdChansLock.Lock()
 	ctl.CmdChans = make(map[ssntp.Command]chan Result)
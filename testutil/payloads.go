@@ -24,6 +24,9 @@ const AgentIP = "10.2.3.4"
 //TenantSubnet is a test tenant subnet
 const TenantSubnet = "10.2.0.0/16"
 
+//TenantSubnetV6 is a test tenant IPv6 subnet
+const TenantSubnetV6 = "fd00:1:2:3::/64"
+
 // SubnetKey is a test tenant subnet key
 const SubnetKey = "8"
 
@@ -48,6 +51,27 @@ const CNCIUUID = "7e84c2d6-5a84-4f9b-98e3-38980f722d1b"
 // CNCIIP is a test CNCI instance IP address
 const CNCIIP = "10.1.2.3"
 
+// TenantDomain is a test tenant DNS domain
+const TenantDomain = "ciao"
+
+// SubnetUUID is a test tenant subnet UUID
+const SubnetUUID = "0f1c5f3e-5a2c-4d2e-8d3e-0b2f6a7c9e41"
+
+// DHCPServerIP is a test DNS server handed out by DHCP
+const DHCPServerIP = "10.2.0.53"
+
+// DHCPRouteDestination is a test static route destination
+const DHCPRouteDestination = "10.3.0.0/16"
+
+// DHCPRouteNextHop is a test static route next hop
+const DHCPRouteNextHop = "10.2.0.254"
+
+// ForwardedPublicPort is a test port of a shared public IP
+const ForwardedPublicPort = 8022
+
+// ForwardedPrivatePort is a test instance port a public port is forwarded to
+const ForwardedPrivatePort = 22
+
 // CNCIMAC is a test CNCI instance MAC address
 const CNCIMAC = "CA:FE:C0:00:01:02"
 
@@ -309,6 +333,12 @@ const EvacuateYaml = `evacuate:
   workload_agent_uuid: ` + AgentUUID + `
 `
 
+// PrewarmImageYaml is a sample PrewarmImage ssntp.Command payload for test cases
+const PrewarmImageYaml = `prewarm_image:
+  docker_image: ` + DockerImage + `
+  workload_agent_uuid: ` + AgentUUID + `
+`
+
 // CNCIAddedYaml is a sample ConcentratorInstanceAdded ssntp.Event payload for test cases
 const CNCIAddedYaml = `concentrator_instance_added:
   instance_uuid: ` + CNCIUUID + `
@@ -337,6 +367,30 @@ const ReleaseIPYaml = `release_public_ip:
   vnic_mac: ` + VNICMAC + `
 `
 
+// ApplySecurityRulesYaml is a sample ApplySecurityRules ssntp.Command payload for test cases
+const ApplySecurityRulesYaml = `apply_security_rules:
+  concentrator_uuid: ` + CNCIUUID + `
+  workload_agent_uuid: ` + AgentUUID + `
+  tenant_uuid: ` + TenantUUID + `
+  instance_uuid: ` + InstanceUUID + `
+  private_ip: ` + InstancePrivateIP + `
+  rules:
+  - protocol: tcp
+    port_range_min: 22
+    port_range_max: 22
+  - protocol: icmp
+    remote_cidr: 192.168.1.0/24
+`
+
+// RemoveSecurityRulesYaml is a sample RemoveSecurityRules ssntp.Command payload for test cases
+const RemoveSecurityRulesYaml = `remove_security_rules:
+  concentrator_uuid: ` + CNCIUUID + `
+  workload_agent_uuid: ` + AgentUUID + `
+  tenant_uuid: ` + TenantUUID + `
+  instance_uuid: ` + InstanceUUID + `
+  private_ip: ` + InstancePrivateIP + `
+`
+
 // AssignedIPYaml is a sample PublicIPAssigned ssntp.Event payload for test cases
 const AssignedIPYaml = `public_ip_assigned:
   concentrator_uuid: ` + CNCIUUID + `
@@ -364,6 +418,57 @@ const TenantAddedYaml = `tenant_added:
   subnet_key: ` + SubnetKey + `
 `
 
+// TunnelPeersYaml is a sample TunnelPeersUpdated ssntp.Event payload for test cases
+const TunnelPeersYaml = `tunnel_peers_updated:
+  agent_uuid: ` + AgentUUID + `
+  tenant_uuid: ` + TenantUUID + `
+  tenant_subnet: ` + TenantSubnet + `
+  concentrator_uuid: ` + CNCIUUID + `
+  concentrator_ip: ` + CNCIIP + `
+  subnet_key: ` + SubnetKey + `
+  peers:
+  - ` + AgentIP + `
+`
+
+// DNSRecordsYaml is a sample DNSRecordsUpdated ssntp.Event payload for test cases
+const DNSRecordsYaml = `dns_records_updated:
+  concentrator_uuid: ` + CNCIUUID + `
+  tenant_uuid: ` + TenantUUID + `
+  domain: ` + TenantDomain + `
+  added:
+  - instance_uuid: ` + InstanceUUID + `
+    name: ` + InstanceUUID + `
+    private_ip: ` + InstancePrivateIP + `
+    mac_address: ` + VNICMAC + `
+`
+
+// DHCPOptionsYaml is a sample DHCPOptionsUpdated ssntp.Event payload for test cases
+const DHCPOptionsYaml = `dhcp_options_updated:
+  concentrator_uuid: ` + CNCIUUID + `
+  tenant_uuid: ` + TenantUUID + `
+  subnet_uuid: ` + SubnetUUID + `
+  tenant_subnet: ` + TenantSubnet + `
+  dns_servers:
+  - ` + DHCPServerIP + `
+  search_domains:
+  - ` + TenantDomain + `
+  routes:
+  - destination: ` + DHCPRouteDestination + `
+    nexthop: ` + DHCPRouteNextHop + `
+`
+
+// PortForwardsYaml is a sample PortForwardsUpdated ssntp.Event payload for test cases
+const PortForwardsYaml = `port_forwards_updated:
+  concentrator_uuid: ` + CNCIUUID + `
+  tenant_uuid: ` + TenantUUID + `
+  forwards:
+  - protocol: tcp
+    public_ip: ` + InstancePublicIP + `
+    public_port: 8022
+    private_ip: ` + InstancePrivateIP + `
+    private_port: 22
+`
+
 // TenantRemovedYaml is a sample TenantRemove ssntp.Event payload for test cases
 const TenantRemovedYaml = `tenant_removed:
   agent_uuid: ` + AgentUUID + `
@@ -602,6 +707,19 @@ const PartialStatsYaml = `node_uuid: ` + AgentUUID + `
 load: 1
 `
 
+// ImageCacheStatsYaml is a sample minimal node STATS ssntp.Command payload for
+// test cases with image cache statistics
+const ImageCacheStatsYaml = `node_uuid: ` + AgentUUID + `
+image_cache:
+  size_mb: 250
+  budget_mb: 1024
+  images:
+  - image: ` + DockerImage + `
+    size_mb: 250
+    instances: 1
+    last_used: 2017-03-21T10:14:05Z
+`
+
 // AttachVolumeYaml is a sample yaml payload for the ssntp Attach Volume command.
 const AttachVolumeYaml = `attach_volume:
   instance_uuid: ` + InstanceUUID + `
@@ -609,6 +727,27 @@ const AttachVolumeYaml = `attach_volume:
   workload_agent_uuid: ` + AgentUUID + `
 `
 
+// AttachVolumeReadOnlyYaml is a sample yaml payload for the ssntp Attach Volume
+// command used to attach a shared volume read-only.
+const AttachVolumeReadOnlyYaml = `attach_volume:
+  instance_uuid: ` + InstanceUUID + `
+  volume_uuid: ` + VolumeUUID + `
+  workload_agent_uuid: ` + AgentUUID + `
+  read_only: true
+`
+
+// VolumeEncryptionKey is a sample base64 encoded LUKS secret.
+const VolumeEncryptionKey = "c2VjcmV0IGtleSBmb3IgdGVzdGluZyBvbmx5IQ=="
+
+// AttachVolumeEncryptedYaml is a sample yaml payload for the ssntp Attach
+// Volume command used to attach an encrypted volume.
+const AttachVolumeEncryptedYaml = `attach_volume:
+  instance_uuid: ` + InstanceUUID + `
+  volume_uuid: ` + VolumeUUID + `
+  workload_agent_uuid: ` + AgentUUID + `
+  encryption_key: ` + VolumeEncryptionKey + `
+`
+
 // BadAttachVolumeYaml is a corrupt yaml payload for the ssntp Attach Volume command.
 const BadAttachVolumeYaml = `attach_volume:
   volume_uuid: ` + VolumeUUID + `
@@ -626,6 +765,21 @@ const BadDetachVolumeYaml = `detach_volume:
   instance_uuid: ` + InstanceUUID + `
 `
 
+// UpdateVolumeYaml is a sample yaml payload for the ssntp Update Volume command.
+const UpdateVolumeYaml = `update_volume:
+  instance_uuid: ` + InstanceUUID + `
+  volume_uuid: ` + VolumeUUID + `
+  workload_agent_uuid: ` + AgentUUID + `
+  io_limits:
+    total_bytes_sec: 10485760
+    total_iops_sec: 500
+`
+
+// BadUpdateVolumeYaml is a corrupt yaml payload for the ssntp Update Volume command.
+const BadUpdateVolumeYaml = `update_volume:
+  volume_uuid: ` + VolumeUUID + `
+`
+
 // AttachVolumeFailureYaml is a sample AttachVolumeFailure ssntp.Error payload for test cases
 const AttachVolumeFailureYaml = `instance_uuid: ` + InstanceUUID + `
 volume_uuid: ` + VolumeUUID + `
@@ -637,3 +791,9 @@ const DetachVolumeFailureYaml = `instance_uuid: ` + InstanceUUID + `
 volume_uuid: ` + VolumeUUID + `
 reason: detach_failure
 `
+
+// UpdateVolumeFailureYaml is a sample UpdateVolumeFailure ssntp.Error payload for test cases
+const UpdateVolumeFailureYaml = `instance_uuid: ` + InstanceUUID + `
+volume_uuid: ` + VolumeUUID + `
+reason: update_failure
+`
@@ -337,6 +337,52 @@ func getDetachVolumeResult(payload []byte, result *Result) {
 	}
 }
 
+func getUpdateVolumeResult(payload []byte, result *Result) {
+	var volCmd payloads.UpdateVolume
+
+	err := yaml.Unmarshal(payload, &volCmd)
+	result.Err = err
+	if err == nil {
+		result.NodeUUID = volCmd.Update.WorkloadAgentUUID
+		result.InstanceUUID = volCmd.Update.InstanceUUID
+		result.VolumeUUID = volCmd.Update.VolumeUUID
+	}
+}
+
+func getPrewarmImageResult(payload []byte, result *Result) {
+	var cmd payloads.PrewarmImage
+
+	err := yaml.Unmarshal(payload, &cmd)
+	result.Err = err
+	if err == nil {
+		result.NodeUUID = cmd.Prewarm.WorkloadAgentUUID
+	}
+}
+
+func getApplySecurityRulesResult(payload []byte, result *Result) {
+	var cmd payloads.CommandApplySecurityRules
+
+	err := yaml.Unmarshal(payload, &cmd)
+	result.Err = err
+	if err == nil {
+		result.InstanceUUID = cmd.Apply.InstanceUUID
+		result.TenantUUID = cmd.Apply.TenantUUID
+		result.NodeUUID = cmd.Apply.WorkloadAgentUUID
+	}
+}
+
+func getRemoveSecurityRulesResult(payload []byte, result *Result) {
+	var cmd payloads.CommandRemoveSecurityRules
+
+	err := yaml.Unmarshal(payload, &cmd)
+	result.Err = err
+	if err == nil {
+		result.InstanceUUID = cmd.Remove.InstanceUUID
+		result.TenantUUID = cmd.Remove.TenantUUID
+		result.NodeUUID = cmd.Remove.WorkloadAgentUUID
+	}
+}
+
 func getStartResults(payload []byte, result *Result) {
 	var startCmd payloads.Start
 	var nn bool
@@ -425,6 +471,18 @@ func (server *SsntpTestServer) CommandNotify(uuid string, command ssntp.Command,
 	case ssntp.DetachVolume:
 		getDetachVolumeResult(payload, &result)
 
+	case ssntp.UpdateVolume:
+		getUpdateVolumeResult(payload, &result)
+
+	case ssntp.PrewarmImage:
+		getPrewarmImageResult(payload, &result)
+
+	case ssntp.ApplySecurityRules:
+		getApplySecurityRulesResult(payload, &result)
+
+	case ssntp.RemoveSecurityRules:
+		getRemoveSecurityRulesResult(payload, &result)
+
 	default:
 		fmt.Fprintf(os.Stderr, "server unhandled command %s\n", command.String())
 	}
@@ -465,6 +523,26 @@ func (server *SsntpTestServer) EventNotify(uuid string, event ssntp.Event, frame
 		// forwards to CNCI via server.EventForward()
 	case ssntp.PublicIPAssigned:
 		// forwards from CNCI Controller(s) via server.EventForward()
+	case ssntp.DNSRecordsUpdated:
+		var dnsRecordsEvent payloads.EventDNSRecordsUpdated
+
+		result.Err = yaml.Unmarshal(payload, &dnsRecordsEvent)
+		result.TenantUUID = dnsRecordsEvent.DNSRecords.TenantUUID
+		if len(dnsRecordsEvent.DNSRecords.Added) > 0 {
+			result.InstanceUUID = dnsRecordsEvent.DNSRecords.Added[0].InstanceUUID
+		} else if len(dnsRecordsEvent.DNSRecords.Removed) > 0 {
+			result.InstanceUUID = dnsRecordsEvent.DNSRecords.Removed[0].InstanceUUID
+		}
+	case ssntp.DHCPOptionsUpdated:
+		var dhcpOptionsEvent payloads.EventDHCPOptionsUpdated
+
+		result.Err = yaml.Unmarshal(payload, &dhcpOptionsEvent)
+		result.TenantUUID = dhcpOptionsEvent.DHCPOptions.TenantUUID
+	case ssntp.PortForwardsUpdated:
+		var portForwardsEvent payloads.EventPortForwardsUpdated
+
+		result.Err = yaml.Unmarshal(payload, &portForwardsEvent)
+		result.TenantUUID = portForwardsEvent.PortForwards.TenantUUID
 	default:
 		fmt.Fprintf(os.Stderr, "server unhandled event %s\n", event.String())
 	}
@@ -640,6 +718,48 @@ func (server *SsntpTestServer) handleDetachVolume(payload []byte) ssntp.ForwardD
 	return dest
 }
 
+func (server *SsntpTestServer) handleUpdateVolume(payload []byte) ssntp.ForwardDestination {
+	var cmd payloads.UpdateVolume
+	var dest ssntp.ForwardDestination
+
+	err := yaml.Unmarshal(payload, &cmd)
+	if err != nil {
+		return dest
+	}
+
+	server.clientsLock.Lock()
+	defer server.clientsLock.Unlock()
+
+	for _, c := range server.clients {
+		if c == cmd.Update.WorkloadAgentUUID {
+			dest.AddRecipient(c)
+		}
+	}
+
+	return dest
+}
+
+func (server *SsntpTestServer) handlePrewarmImage(payload []byte) ssntp.ForwardDestination {
+	var cmd payloads.PrewarmImage
+	var dest ssntp.ForwardDestination
+
+	err := yaml.Unmarshal(payload, &cmd)
+	if err != nil {
+		return dest
+	}
+
+	server.clientsLock.Lock()
+	defer server.clientsLock.Unlock()
+
+	for _, c := range server.clients {
+		if c == cmd.Prewarm.WorkloadAgentUUID {
+			dest.AddRecipient(c)
+		}
+	}
+
+	return dest
+}
+
 // CommandForward implements an SSNTP CommandForward callback for SsntpTestServer
 func (server *SsntpTestServer) CommandForward(uuid string, command ssntp.Command, frame *ssntp.Frame) (dest ssntp.ForwardDestination) {
 	payload := frame.Payload
@@ -651,6 +771,10 @@ func (server *SsntpTestServer) CommandForward(uuid string, command ssntp.Command
 		dest = server.handleAttachVolume(payload)
 	case ssntp.DetachVolume:
 		dest = server.handleDetachVolume(payload)
+	case ssntp.UpdateVolume:
+		dest = server.handleUpdateVolume(payload)
+	case ssntp.PrewarmImage:
+		dest = server.handlePrewarmImage(payload)
 	case ssntp.EVACUATE:
 		fallthrough
 	case ssntp.STOP:
@@ -734,6 +858,10 @@ func StartTestServer() *SsntpTestServer {
 				Operand: ssntp.DetachVolumeFailure,
 				Dest:    ssntp.Controller,
 			},
+			{ // all VolumeUpdateFailure errors go to all Controllers
+				Operand: ssntp.UpdateVolumeFailure,
+				Dest:    ssntp.Controller,
+			},
 			{ // all PublicIPAssigned events go to all Controllers
 				Operand: ssntp.PublicIPAssigned,
 				Dest:    ssntp.Controller,
@@ -774,6 +902,14 @@ func StartTestServer() *SsntpTestServer {
 				Operand:        ssntp.DetachVolume,
 				CommandForward: server,
 			},
+			{ // all UpdateVolume commands are processed by the Command forwarder
+				Operand:        ssntp.UpdateVolume,
+				CommandForward: server,
+			},
+			{ // all PrewarmImage commands are processed by the Command forwarder
+				Operand:        ssntp.PrewarmImage,
+				CommandForward: server,
+			},
 		},
 	}
 